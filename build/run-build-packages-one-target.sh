@@ -204,6 +204,8 @@ if test -z "$packages" ; then
         arvados-workbench2
         arvados-ws
         crunch-dispatch-local
+        crunch-dispatch-lsf
+        crunch-dispatch-pbs
         crunch-dispatch-slurm
         crunch-run
         crunchstat
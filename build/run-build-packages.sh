@@ -283,6 +283,10 @@ package_go_binary services/arv-git-httpd arvados-git-httpd \
     "Provide authenticated http access to Arvados-hosted git repositories"
 package_go_binary services/crunch-dispatch-local crunch-dispatch-local \
     "Dispatch Crunch containers on the local system"
+package_go_binary services/crunch-dispatch-lsf crunch-dispatch-lsf \
+    "Dispatch Crunch containers to an LSF cluster"
+package_go_binary services/crunch-dispatch-pbs crunch-dispatch-pbs \
+    "Dispatch Crunch containers to a PBS cluster"
 package_go_binary services/crunch-dispatch-slurm crunch-dispatch-slurm \
     "Dispatch Crunch containers to a SLURM cluster"
 package_go_binary cmd/arvados-server crunch-run \
@@ -73,6 +73,7 @@ apps/workbench_profile
 cmd/arvados-client
 cmd/arvados-server
 doc
+lib/batchdispatch
 lib/cli
 lib/cmd
 lib/controller
@@ -106,6 +107,8 @@ services/keepstore
 services/keep-balance
 services/login-sync
 services/crunch-dispatch-local
+services/crunch-dispatch-lsf
+services/crunch-dispatch-pbs
 services/crunch-dispatch-slurm
 services/ws
 sdk/cli
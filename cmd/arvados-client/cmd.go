@@ -20,13 +20,17 @@ var (
 		"-version":  cmd.Version,
 		"--version": cmd.Version,
 
-		"copy":   cli.Copy,
-		"create": cli.Create,
-		"edit":   cli.Edit,
-		"get":    cli.Get,
-		"keep":   cli.Keep,
-		"tag":    cli.Tag,
-		"ws":     cli.Ws,
+		"copy":     cli.Copy,
+		"create":   cli.Create,
+		"download": cli.Download,
+		"edit":     cli.Edit,
+		"find":     cli.Find,
+		"get":      cli.Get,
+		"keep":     cli.Keep,
+		"ls":       cli.Ls,
+		"put":      cli.Put,
+		"tag":      cli.Tag,
+		"ws":       cli.Ws,
 
 		"api_client_authorization": cli.APICall,
 		"api_client":               cli.APICall,
@@ -0,0 +1,256 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+// Package batchdispatch runs Arvados containers by submitting them as
+// jobs to an HPC batch scheduler. It handles everything that doesn't
+// depend on the specific scheduler -- locking containers, monitoring
+// their state, cancelling jobs, keeping the scheduler's job
+// priorities in the same order as the Arvados container priorities,
+// and cleaning up orphaned jobs left behind by a previous dispatcher
+// process. The scheduler-specific parts (LSF, PBS, etc.) are provided
+// by a Backend.
+package batchdispatch
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/dispatch"
+	"github.com/sirupsen/logrus"
+)
+
+// A Job is a batch job, as reported by the scheduler.
+type Job struct {
+	// Scheduler-assigned job ID.
+	ID string
+	// Job name. For jobs submitted by the dispatcher, this is
+	// the container UUID.
+	Name string
+	// Current scheduler priority. Higher values run first.
+	Priority int64
+	// True if the job is waiting to start (as opposed to
+	// running, suspended, etc.).
+	Pending bool
+}
+
+// A Backend submits and manages jobs using a specific batch
+// scheduler.
+//
+// Backend methods may be called concurrently by multiple goroutines.
+type Backend interface {
+	// Submit a job that runs the given shell script. The job
+	// name must be the container UUID, and the initial priority
+	// should be the lowest value returned by PriorityRange.
+	Submit(ctr arvados.Container, script string) error
+
+	// Return all jobs in the scheduler's queue (pending or
+	// running), including jobs that were not submitted by this
+	// dispatcher. Finished jobs should not be returned.
+	Queue() ([]Job, error)
+
+	// Cancel a job. If the job is running, the backend should
+	// signal crunch-run with SIGTERM (rather than SIGKILL) so it
+	// can stop the container and clean up.
+	Cancel(Job) error
+
+	// Change the priority of a pending job.
+	SetPriority(job Job, priority int64) error
+
+	// Return the range of priority values the dispatcher should
+	// use. If lowest >= highest, the dispatcher does not adjust
+	// job priorities at all.
+	PriorityRange() (lowest, highest int64)
+}
+
+var containerUUIDPattern = regexp.MustCompile(`^[a-z0-9]{5}-dz642-[a-z0-9]{15}$`)
+
+// Dispatcher submits containers to a batch scheduler.
+type Dispatcher struct {
+	*dispatch.Dispatcher
+	Cluster *arvados.Cluster
+	Backend Backend
+	Logger  logrus.FieldLogger
+
+	// Arvados API client. If nil, one is created from the
+	// ARVADOS_API_* environment variables.
+	Arv *arvadosclient.ArvadosClient
+
+	queue *queueChecker
+}
+
+// setup initializes the dispatcher's private fields, and the
+// embedded dispatch.Dispatcher if the caller hasn't already provided
+// one.
+func (disp *Dispatcher) setup() error {
+	if disp.Logger == nil {
+		disp.Logger = logrus.StandardLogger()
+	}
+	if disp.Arv == nil {
+		arv, err := arvadosclient.MakeArvadosClient()
+		if err != nil {
+			return fmt.Errorf("error making Arvados client: %s", err)
+		}
+		arv.Retries = 25
+		disp.Arv = arv
+	}
+	if disp.queue == nil {
+		disp.queue = &queueChecker{
+			Logger:  disp.Logger,
+			Period:  time.Duration(disp.Cluster.Containers.CloudVMs.PollInterval),
+			Backend: disp.Backend,
+		}
+	}
+	if disp.Dispatcher == nil {
+		disp.Dispatcher = &dispatch.Dispatcher{
+			Arv:            disp.Arv,
+			Logger:         disp.Logger,
+			BatchSize:      disp.Cluster.API.MaxItemsPerResponse,
+			RunContainer:   disp.runContainer,
+			PollPeriod:     time.Duration(disp.Cluster.Containers.CloudVMs.PollInterval),
+			MinRetryPeriod: time.Duration(disp.Cluster.Containers.MinRetryPeriod),
+		}
+	}
+	return nil
+}
+
+// Run dispatches containers until ctx is cancelled or an error
+// occurs.
+func (disp *Dispatcher) Run(ctx context.Context) error {
+	if err := disp.setup(); err != nil {
+		return err
+	}
+	defer disp.queue.Stop()
+	go disp.checkQueueForOrphans()
+	return disp.Dispatcher.Run(ctx)
+}
+
+// Check the next queue report, and invoke TrackContainer for all the
+// containers in the report. This gives us a chance to cancel jobs
+// started by a previous dispatch process whose containers are
+// already Cancelled or Complete, and resume monitoring the ones that
+// are still running.
+func (disp *Dispatcher) checkQueueForOrphans() {
+	for _, uuid := range disp.queue.All() {
+		if !containerUUIDPattern.MatchString(uuid) {
+			continue
+		}
+		err := disp.TrackContainer(uuid)
+		if err != nil {
+			disp.Logger.WithError(err).WithField("ContainerUUID", uuid).Warn("error tracking orphaned job")
+		}
+	}
+}
+
+func (disp *Dispatcher) submit(ctr arvados.Container) error {
+	args := []string{disp.Cluster.Containers.CrunchRunCommand}
+	args = append(args, disp.Cluster.Containers.CrunchRunArgumentsList...)
+	args = append(args, ctr.UUID)
+	return disp.Backend.Submit(ctr, execScript(args))
+}
+
+// Submit a container to the batch scheduler (or resume monitoring if
+// it's already in the queue). Cancel the job if the container's
+// priority changes to zero or its state indicates it's no longer
+// running.
+func (disp *Dispatcher) runContainer(_ *dispatch.Dispatcher, ctr arvados.Container, status <-chan arvados.Container) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := disp.Logger.WithField("ContainerUUID", ctr.UUID)
+
+	if ctr.State == dispatch.Locked && !disp.queue.HasUUID(ctr.UUID) {
+		logger.Info("submitting container")
+		if err := disp.submit(ctr); err != nil {
+			text := fmt.Sprintf("Error submitting container %s to batch scheduler: %s", ctr.UUID, err)
+			logger.WithError(err).Error("error submitting container")
+			lr := arvadosclient.Dict{"log": arvadosclient.Dict{
+				"object_uuid": ctr.UUID,
+				"event_type":  "dispatch",
+				"properties":  map[string]string{"text": text}}}
+			disp.Arv.Create("logs", lr, nil)
+			disp.Unlock(ctr.UUID)
+			return
+		}
+	}
+
+	logger.WithField("State", ctr.State).Info("start monitoring container")
+	defer logger.Info("done monitoring container")
+
+	// If the container disappears from the scheduler's queue,
+	// there is no point in waiting for further dispatch updates:
+	// just clean up and return.
+	go func(uuid string) {
+		for ctx.Err() == nil && disp.queue.HasUUID(uuid) {
+		}
+		cancel()
+	}(ctr.UUID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Disappeared from the queue
+			if err := disp.Arv.Get("containers", ctr.UUID, nil, &ctr); err != nil {
+				logger.WithError(err).Error("error getting final container state")
+			}
+			switch ctr.State {
+			case dispatch.Running:
+				disp.UpdateState(ctr.UUID, dispatch.Cancelled)
+			case dispatch.Locked:
+				disp.Unlock(ctr.UUID)
+			}
+			return
+		case updated, ok := <-status:
+			if !ok {
+				logger.Info("container is done: cancel job")
+				disp.cancel(ctr)
+			} else if updated.Priority == 0 {
+				logger.WithField("State", updated.State).Info("container priority is 0: cancel job")
+				disp.cancel(ctr)
+			} else {
+				p := int64(updated.Priority)
+				if p <= 1000 {
+					// API is providing
+					// user-assigned priority. If
+					// ctrs have equal priority,
+					// run the older one first.
+					p = int64(p)<<50 - (updated.CreatedAt.UnixNano() >> 14)
+				}
+				disp.queue.SetPriority(ctr.UUID, p)
+			}
+		}
+	}
+}
+
+func (disp *Dispatcher) cancel(ctr arvados.Container) {
+	logger := disp.Logger.WithField("ContainerUUID", ctr.UUID)
+	job, ok := disp.queue.Job(ctr.UUID)
+	if !ok {
+		// Already gone. The monitoring goroutine in
+		// runContainer will notice on the next queue poll.
+		return
+	}
+	err := disp.Backend.Cancel(job)
+	if err != nil {
+		logger.WithError(err).Warn("error cancelling job")
+		time.Sleep(time.Second)
+	} else if disp.queue.HasUUID(ctr.UUID) {
+		logger.Info("job is still in queue after cancelling")
+		time.Sleep(time.Second)
+	}
+}
+
+// execScript returns a shell script that execs the given command.
+func execScript(args []string) string {
+	s := "#!/bin/sh\nexec"
+	for _, w := range args {
+		s += ` '`
+		s += strings.Replace(w, `'`, `'\''`, -1)
+		s += `'`
+	}
+	return s + "\n"
+}
@@ -0,0 +1,198 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/dispatch"
+	"github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+)
+
+// Gocheck boilerplate
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&IntegrationSuite{})
+
+type IntegrationSuite struct {
+	disp    Dispatcher
+	backend *stubBackend
+}
+
+func (s *IntegrationSuite) SetUpTest(c *C) {
+	arvadostest.StartAPI()
+	os.Setenv("ARVADOS_API_TOKEN", arvadostest.Dispatch1Token)
+	s.backend = &stubBackend{lowest: 1, highest: 100}
+}
+
+func (s *IntegrationSuite) TearDownTest(c *C) {
+	arvadostest.ResetEnv()
+	arvadostest.StopAPI()
+}
+
+func (s *IntegrationSuite) integrationTest(c *C, runContainer func(*dispatch.Dispatcher, arvados.Container)) arvados.Container {
+	arvadostest.ResetEnv()
+
+	arv, err := arvadosclient.MakeArvadosClient()
+	c.Assert(err, IsNil)
+
+	// There should be one queued container
+	params := arvadosclient.Dict{
+		"filters": [][]string{{"state", "=", "Queued"}},
+	}
+	var containers arvados.ContainerList
+	err = arv.List("containers", params, &containers)
+	c.Check(err, IsNil)
+	c.Assert(len(containers.Items), Equals, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneRun := make(chan struct{})
+
+	s.disp = Dispatcher{
+		Cluster: &arvados.Cluster{},
+		Backend: s.backend,
+		Logger:  logrus.StandardLogger(),
+		Arv:     arv,
+	}
+	s.disp.Cluster.Containers.CrunchRunCommand = "echo"
+	s.disp.queue = &queueChecker{
+		Logger:  s.disp.Logger,
+		Period:  500 * time.Millisecond,
+		Backend: s.backend,
+	}
+	s.disp.Dispatcher = &dispatch.Dispatcher{
+		Arv:        arv,
+		PollPeriod: time.Second,
+		RunContainer: func(disp *dispatch.Dispatcher, ctr arvados.Container, status <-chan arvados.Container) {
+			go func() {
+				runContainer(disp, ctr)
+				s.backend.mtx.Lock()
+				s.backend.queue = nil
+				s.backend.mtx.Unlock()
+				doneRun <- struct{}{}
+			}()
+			s.disp.runContainer(disp, ctr, status)
+			cancel()
+		},
+	}
+
+	err = s.disp.Run(ctx)
+	<-doneRun
+	c.Assert(err, Equals, context.Canceled)
+
+	// There should be no queued containers now
+	err = arv.List("containers", params, &containers)
+	c.Check(err, IsNil)
+	c.Check(len(containers.Items), Equals, 0)
+
+	var container arvados.Container
+	err = arv.Get("containers", "zzzzz-dz642-queuedcontainer", nil, &container)
+	c.Check(err, IsNil)
+	return container
+}
+
+func (s *IntegrationSuite) TestNormal(c *C) {
+	container := s.integrationTest(c,
+		func(dispatcher *dispatch.Dispatcher, container arvados.Container) {
+			dispatcher.UpdateState(container.UUID, dispatch.Running)
+			time.Sleep(3 * time.Second)
+			dispatcher.UpdateState(container.UUID, dispatch.Complete)
+		})
+	c.Check(container.State, Equals, arvados.ContainerStateComplete)
+	c.Check(s.backend.didSubmit, DeepEquals, []string{"zzzzz-dz642-queuedcontainer"})
+	c.Check(s.backend.didPriority, DeepEquals, []string{"zzzzz-dz642-queuedcontainer 100"})
+}
+
+func (s *IntegrationSuite) TestCancel(c *C) {
+	container := s.integrationTest(c,
+		func(dispatcher *dispatch.Dispatcher, container arvados.Container) {
+			dispatcher.UpdateState(container.UUID, dispatch.Running)
+			time.Sleep(time.Second)
+			dispatcher.Arv.Update("containers", container.UUID,
+				arvadosclient.Dict{
+					"container": arvadosclient.Dict{"priority": 0}},
+				nil)
+		})
+	c.Check(container.State, Equals, arvados.ContainerStateCancelled)
+	c.Check(s.backend.didCancel, DeepEquals, []string{"zzzzz-dz642-queuedcontainer"})
+}
+
+func (s *IntegrationSuite) TestSubmitFail(c *C) {
+	s.backend.errSubmit = errors.New("something terrible happened")
+	container := s.integrationTest(c,
+		func(dispatcher *dispatch.Dispatcher, container arvados.Container) {
+			dispatcher.UpdateState(container.UUID, dispatch.Running)
+			dispatcher.UpdateState(container.UUID, dispatch.Complete)
+		})
+	c.Check(container.State, Equals, arvados.ContainerStateComplete)
+
+	arv, err := arvadosclient.MakeArvadosClient()
+	c.Assert(err, IsNil)
+
+	var ll arvados.LogList
+	err = arv.List("logs", arvadosclient.Dict{"filters": [][]string{
+		{"object_uuid", "=", container.UUID},
+		{"event_type", "=", "dispatch"},
+	}}, &ll)
+	c.Assert(err, IsNil)
+	c.Assert(len(ll.Items), Equals, 1)
+}
+
+func (s *IntegrationSuite) TestOrphanedJob(c *C) {
+	// A job left behind by a previous dispatcher process,
+	// whose container is already complete, gets cancelled.
+	s.backend.queue = []Job{{ID: "1", Name: arvadostest.CompletedContainerUUID}}
+	arv, err := arvadosclient.MakeArvadosClient()
+	c.Assert(err, IsNil)
+	s.disp = Dispatcher{
+		Cluster: &arvados.Cluster{},
+		Backend: s.backend,
+		Logger:  logrus.StandardLogger(),
+		Arv:     arv,
+	}
+	s.disp.Cluster.Containers.CloudVMs.PollInterval = arvados.Duration(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go s.disp.Run(ctx)
+	for ctx.Err() == nil {
+		s.backend.mtx.Lock()
+		n := len(s.backend.didCancel)
+		s.backend.mtx.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Check(s.backend.didCancel, DeepEquals, []string{arvadostest.CompletedContainerUUID})
+}
+
+var _ = Suite(&ScriptSuite{})
+
+type ScriptSuite struct{}
+
+func (s *ScriptSuite) TestExecScript(c *C) {
+	for _, test := range []struct {
+		args   []string
+		script string
+	}{
+		{nil, `exec`},
+		{[]string{`foo`}, `exec 'foo'`},
+		{[]string{`foo`, `bar baz`}, `exec 'foo' 'bar baz'`},
+		{[]string{`foo"`, "'waz 'qux\n"}, `exec 'foo"' ''\''waz '\''qux` + "\n" + `'`},
+	} {
+		c.Logf("%+v -> %+v", test.args, test.script)
+		c.Check(execScript(test.args), Equals, "#!/bin/sh\n"+test.script+"\n")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CLI runs batch scheduler commands (bsub, qstat, etc.) on behalf of
+// a Backend, limiting the number of commands that run concurrently.
+type CLI struct {
+	Logger logrus.FieldLogger
+
+	runSemaphore chan bool
+}
+
+// NewCLI returns a CLI that runs up to 3 commands at a time.
+func NewCLI(logger logrus.FieldLogger) *CLI {
+	return &CLI{
+		Logger:       logger,
+		runSemaphore: make(chan bool, 3),
+	}
+}
+
+// Run runs the given program with the given stdin (which may be
+// nil), and returns its stdout. If the program fails, the returned
+// error includes its stderr.
+func (cli *CLI) Run(stdin io.Reader, prog string, args ...string) ([]byte, error) {
+	cli.runSemaphore <- true
+	defer func() { <-cli.runSemaphore }()
+	cmd := exec.Command(prog, args...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	errTrim := strings.TrimSpace(stderr.String())
+	if err != nil {
+		cli.Logger.WithFields(logrus.Fields{
+			"Args":   cmd.Args,
+			"stderr": errTrim,
+		}).WithError(err).Warn("command failed")
+		return stdout.Bytes(), fmt.Errorf("%s: %s (%q)", cmd.Path, err, errTrim)
+	}
+	cli.Logger.WithFields(logrus.Fields{
+		"Args":   cmd.Args,
+		"stderr": errTrim,
+	}).Debug("command succeeded")
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/lib/config"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+)
+
+// NewBackendFunc returns a Backend for the given cluster config.
+type NewBackendFunc func(*arvados.Cluster, logrus.FieldLogger) (Backend, error)
+
+type command struct {
+	name       string
+	newBackend NewBackendFunc
+}
+
+// Command returns a cmd.Handler that loads the cluster config, and
+// runs a dispatcher using the Backend returned by newBackend.
+func Command(name string, newBackend NewBackendFunc) cmd.Handler {
+	return &command{name: name, newBackend: newBackend}
+}
+
+func (c *command) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var logger logrus.FieldLogger = ctxlog.New(stderr, "json", "info")
+	var err error
+	defer func() {
+		if err != nil {
+			logger.WithError(err).Error("exiting")
+		}
+	}()
+
+	flags := flag.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	loader := config.NewLoader(stdin, logger)
+	loader.SetupFlags(flags)
+	dumpConfig := flags.Bool("dump-config", false, "write current configuration to stdout and exit")
+	versionFlag := flags.Bool("version", false, "Write version information to stdout and exit 0")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		err = nil
+		return 0
+	} else if err != nil {
+		return 2
+	} else if *versionFlag {
+		return cmd.Version.RunCommand(prog, args, stdin, stdout, stderr)
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return 1
+	}
+	if *dumpConfig {
+		var out []byte
+		out, err = yaml.Marshal(cfg)
+		if err != nil {
+			return 1
+		}
+		_, err = stdout.Write(out)
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+	cluster, err := cfg.GetCluster("")
+	if err != nil {
+		err = fmt.Errorf("config error: %s", err)
+		return 1
+	}
+
+	logger = ctxlog.New(stderr, cluster.SystemLogs.Format, cluster.SystemLogs.LogLevel).WithField("PID", os.Getpid())
+	logger.Printf("%s %s started", c.name, cmd.Version.String())
+
+	// Copy real configs into env vars so [a]
+	// MakeArvadosClient() uses them, and [b] they get propagated
+	// to crunch-run via the batch scheduler.
+	os.Setenv("ARVADOS_API_HOST", cluster.Services.Controller.ExternalURL.Host)
+	os.Setenv("ARVADOS_API_TOKEN", cluster.SystemRootToken)
+	os.Setenv("ARVADOS_API_HOST_INSECURE", "")
+	if cluster.TLS.Insecure {
+		os.Setenv("ARVADOS_API_HOST_INSECURE", "1")
+	}
+	os.Setenv("ARVADOS_EXTERNAL_CLIENT", "")
+
+	backend, err := c.newBackend(cluster, logger)
+	if err != nil {
+		return 1
+	}
+	disp := &Dispatcher{
+		Cluster: cluster,
+		Backend: backend,
+		Logger:  logger,
+	}
+	err = disp.setup()
+	if err != nil {
+		return 1
+	}
+	if _, err := daemon.SdNotify(false, "READY=1"); err != nil {
+		logger.WithError(err).Warn("error notifying init daemon")
+	}
+	err = disp.Run(context.Background())
+	if err != nil {
+		return 1
+	}
+	return 0
+}
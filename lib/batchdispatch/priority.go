@@ -0,0 +1,35 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+// mapPriority maps a list of Arvados priorities, sorted from highest
+// to lowest, to scheduler priorities in the range [lowest, highest].
+// The returned slice will have len(want) elements.
+//
+// The highest Arvados priority gets the highest scheduler priority,
+// and each subsequent distinct Arvados priority gets the next lower
+// scheduler priority, until the lowest scheduler priority is reached.
+// Equal Arvados priorities get equal scheduler priorities.
+//
+// Assigning adjacent values from the top (rather than spreading
+// values across the whole range) means adding or removing a
+// low-priority job doesn't change the priorities of the jobs ahead
+// of it, which keeps the number of adjustments small. If there are
+// more distinct Arvados priorities than scheduler priorities, the
+// lowest-priority jobs all get the lowest scheduler priority.
+func mapPriority(want []int64, lowest, highest int64) []int64 {
+	if len(want) == 0 {
+		return nil
+	}
+	out := make([]int64, len(want))
+	p := highest
+	for i := range want {
+		if i > 0 && want[i] != want[i-1] && p > lowest {
+			p--
+		}
+		out[i] = p
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&PrioritySuite{})
+
+type PrioritySuite struct{}
+
+func (s *PrioritySuite) TestMapPriority(c *C) {
+	for _, test := range []struct {
+		want    []int64
+		lowest  int64
+		highest int64
+		expect  []int64
+	}{
+		{
+			want:   nil,
+			expect: nil,
+		},
+		{
+			want:    []int64{5},
+			lowest:  1,
+			highest: 100,
+			expect:  []int64{100},
+		},
+		{
+			want:    []int64{900, 500, 500, 1},
+			lowest:  1,
+			highest: 100,
+			expect:  []int64{100, 99, 99, 98},
+		},
+		{ // more distinct priorities than available values
+			want:    []int64{9, 8, 7, 6, 5},
+			lowest:  -2,
+			highest: 0,
+			expect:  []int64{0, -1, -2, -2, -2},
+		},
+		{ // negative Arvados priorities (older containers with priority <= 1000)
+			want:    []int64{-10, -20, -20},
+			lowest:  -1024,
+			highest: 1023,
+			expect:  []int64{1023, 1022, 1022},
+		},
+	} {
+		c.Check(mapPriority(test.want, test.lowest, test.highest), DeepEquals, test.expect)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type queuedJob struct {
+	Job
+	wantPriority int64 // Arvados priority, 0 if unknown
+}
+
+// queueChecker polls the scheduler's queue, and adjusts job
+// priorities so the scheduler's order matches the Arvados priority
+// order.
+type queueChecker struct {
+	Logger  logrus.FieldLogger
+	Period  time.Duration
+	Backend Backend
+
+	queue     map[string]*queuedJob // keyed by job name
+	startOnce sync.Once
+	done      chan struct{}
+	lock      sync.RWMutex
+	notify    sync.Cond
+}
+
+// HasUUID checks whether a job with the given container UUID is in
+// the queue. This does not poll the scheduler directly, but instead
+// blocks until woken up by the next successful queue update.
+func (qc *queueChecker) HasUUID(uuid string) bool {
+	qc.startOnce.Do(qc.start)
+
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+
+	// block until next queue broadcast signaling an update.
+	qc.notify.Wait()
+	_, exists := qc.queue[uuid]
+	return exists
+}
+
+// Job returns the job with the given name as of the most recent
+// queue update, without waiting for the next one.
+func (qc *queueChecker) Job(name string) (Job, bool) {
+	qc.startOnce.Do(qc.start)
+
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+	if j, ok := qc.queue[name]; ok {
+		return j.Job, true
+	}
+	return Job{}, false
+}
+
+// SetPriority sets or updates the desired (Arvados) priority for a
+// container.
+func (qc *queueChecker) SetPriority(uuid string, want int64) {
+	qc.startOnce.Do(qc.start)
+
+	qc.lock.RLock()
+	job := qc.queue[uuid]
+	if job == nil {
+		// Wait in case the job was just submitted and will
+		// appear in the next queue update.
+		qc.notify.Wait()
+		job = qc.queue[uuid]
+	}
+	needUpdate := job != nil && job.wantPriority != want
+	qc.lock.RUnlock()
+
+	if needUpdate {
+		qc.lock.Lock()
+		job.wantPriority = want
+		qc.lock.Unlock()
+	}
+}
+
+// reprioritize adjusts job priorities as needed to ensure the
+// scheduler's priority order matches the Arvados priority order.
+func (qc *queueChecker) reprioritize() {
+	lowest, highest := qc.Backend.PriorityRange()
+	if lowest >= highest {
+		return
+	}
+	qc.lock.RLock()
+	jobs := make([]*queuedJob, 0, len(qc.queue))
+	for _, j := range qc.queue {
+		if j.wantPriority == 0 || !j.Pending {
+			// Job with unknown Arvados priority (perhaps
+			// it's not an Arvados job), or already
+			// started.
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	qc.lock.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].wantPriority != jobs[j].wantPriority {
+			return jobs[i].wantPriority > jobs[j].wantPriority
+		}
+		// break ties with container uuid -- otherwise, the
+		// ordering would change from one interval to the
+		// next, and we'd make many pointless adjustments.
+		return jobs[i].Name > jobs[j].Name
+	})
+	want := make([]int64, len(jobs))
+	for i, j := range jobs {
+		want[i] = j.wantPriority
+	}
+	for i, p := range mapPriority(want, lowest, highest) {
+		job := jobs[i]
+		if job.Priority == p {
+			continue
+		}
+		err := qc.Backend.SetPriority(job.Job, p)
+		if err != nil {
+			qc.Logger.WithError(err).WithField("ContainerUUID", job.Name).Warn("error setting job priority")
+			continue
+		}
+		qc.lock.Lock()
+		job.Priority = p
+		qc.lock.Unlock()
+	}
+}
+
+// Stop stops the queue monitoring goroutine. Do not call HasUUID
+// after calling Stop.
+func (qc *queueChecker) Stop() {
+	if qc.done != nil {
+		close(qc.done)
+	}
+}
+
+// check gets the list of jobs in the scheduler's queue. If it
+// succeeds, it updates qc.queue and wakes up any goroutines that are
+// waiting in HasUUID() or All().
+func (qc *queueChecker) check() {
+	jobs, err := qc.Backend.Queue()
+	if err != nil {
+		qc.Logger.WithError(err).Warn("error getting job queue")
+		return
+	}
+	qc.lock.Lock()
+	newq := make(map[string]*queuedJob, len(jobs))
+	for _, job := range jobs {
+		replacing, ok := qc.queue[job.Name]
+		if !ok {
+			replacing = &queuedJob{}
+		}
+		replacing.Job = job
+		newq[job.Name] = replacing
+	}
+	qc.queue = newq
+	qc.lock.Unlock()
+	qc.notify.Broadcast()
+}
+
+// Initialize, and start a goroutine to call check() once per
+// qc.Period until terminated by calling Stop().
+func (qc *queueChecker) start() {
+	qc.notify.L = qc.lock.RLocker()
+	qc.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(qc.Period)
+		for {
+			select {
+			case <-qc.done:
+				ticker.Stop()
+				return
+			case <-ticker.C:
+				qc.check()
+				qc.reprioritize()
+				select {
+				case <-ticker.C:
+					// If this iteration took
+					// longer than qc.Period,
+					// consume the next tick and
+					// wait. Otherwise we would
+					// starve other goroutines.
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// All waits for the next queue update, and returns the names of all
+// jobs in the queue.
+func (qc *queueChecker) All() []string {
+	qc.startOnce.Do(qc.start)
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+	qc.notify.Wait()
+	var names []string
+	for name := range qc.queue {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,253 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&QueueSuite{})
+
+type QueueSuite struct{}
+
+// stubBackend is a Backend whose queue is controlled by the test.
+type stubBackend struct {
+	lowest, highest int64
+
+	mtx         sync.Mutex
+	queue       []Job
+	errQueue    error
+	errSubmit   error
+	didSubmit   []string
+	didCancel   []string
+	didPriority []string
+}
+
+func (sb *stubBackend) Submit(ctr arvados.Container, script string) error {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	sb.didSubmit = append(sb.didSubmit, ctr.UUID)
+	if sb.errSubmit != nil {
+		return sb.errSubmit
+	}
+	sb.queue = append(sb.queue, Job{ID: fmt.Sprintf("%d", len(sb.didSubmit)), Name: ctr.UUID, Priority: sb.lowest, Pending: true})
+	return nil
+}
+
+func (sb *stubBackend) Queue() ([]Job, error) {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	return append([]Job(nil), sb.queue...), sb.errQueue
+}
+
+func (sb *stubBackend) Cancel(job Job) error {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	sb.didCancel = append(sb.didCancel, job.Name)
+	for i, j := range sb.queue {
+		if j.ID == job.ID {
+			sb.queue = append(sb.queue[:i], sb.queue[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (sb *stubBackend) SetPriority(job Job, priority int64) error {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	sb.didPriority = append(sb.didPriority, fmt.Sprintf("%s %d", job.Name, priority))
+	for i := range sb.queue {
+		if sb.queue[i].ID == job.ID {
+			sb.queue[i].Priority = priority
+		}
+	}
+	return nil
+}
+
+func (sb *stubBackend) PriorityRange() (int64, int64) {
+	return sb.lowest, sb.highest
+}
+
+// Call fn() until the done channel is closed.
+func callUntilReady(fn func(), done <-chan struct{}) {
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			fn()
+		}
+	}
+}
+
+func (s *QueueSuite) TestReprioritize(c *C) {
+	uuids := []string{"zzzzz-dz642-fake0fake0fake0", "zzzzz-dz642-fake1fake1fake1", "zzzzz-dz642-fake2fake2fake2", "zzzzz-dz642-fake3fake3fake3"}
+	for _, test := range []struct {
+		queue  []Job
+		want   map[string]int64
+		expect []string
+	}{
+		{
+			queue:  []Job{{ID: "1", Name: uuids[0], Priority: 1, Pending: true}},
+			want:   map[string]int64{uuids[0]: 1},
+			expect: []string{uuids[0] + " 100"},
+		},
+		{ // already in the right order
+			queue: []Job{
+				{ID: "1", Name: uuids[0], Priority: 100, Pending: true},
+				{ID: "2", Name: uuids[1], Priority: 99, Pending: true},
+			},
+			want:   map[string]int64{uuids[0]: 999, uuids[1]: 1},
+			expect: nil,
+		},
+		{ // wrong order
+			queue: []Job{
+				{ID: "1", Name: uuids[0], Priority: 100, Pending: true},
+				{ID: "2", Name: uuids[1], Priority: 99, Pending: true},
+			},
+			want:   map[string]int64{uuids[0]: 1, uuids[1]: 999},
+			expect: []string{uuids[1] + " 100", uuids[0] + " 99"},
+		},
+		{ // ignore running jobs, and jobs with unknown
+			// Arvados priority
+			queue: []Job{
+				{ID: "1", Name: uuids[0], Priority: 1, Pending: true},
+				{ID: "2", Name: uuids[1], Priority: 1, Pending: false},
+				{ID: "3", Name: uuids[2], Priority: 1, Pending: true},
+				{ID: "4", Name: "not-an-arvados-job", Priority: 1, Pending: true},
+			},
+			want:   map[string]int64{uuids[0]: 2, uuids[1]: 3},
+			expect: []string{uuids[0] + " 100"},
+		},
+		{ // equal priorities
+			queue: []Job{
+				{ID: "1", Name: uuids[0], Priority: 1, Pending: true},
+				{ID: "2", Name: uuids[1], Priority: 1, Pending: true},
+				{ID: "3", Name: uuids[2], Priority: 1, Pending: true},
+				{ID: "4", Name: uuids[3], Priority: 1, Pending: true},
+			},
+			want:   map[string]int64{uuids[0]: 5, uuids[1]: 5, uuids[2]: 3, uuids[3]: 7},
+			expect: []string{uuids[3] + " 100", uuids[1] + " 99", uuids[0] + " 99", uuids[2] + " 98"},
+		},
+	} {
+		c.Logf("spec: %+v", test)
+		backend := &stubBackend{lowest: 1, highest: 100, queue: test.queue}
+		qc := &queueChecker{
+			Logger:  logrus.StandardLogger(),
+			Backend: backend,
+			Period:  time.Hour,
+		}
+		qc.startOnce.Do(qc.start)
+		done := make(chan struct{})
+		go func() {
+			for uuid, pri := range test.want {
+				qc.SetPriority(uuid, pri)
+			}
+			close(done)
+		}()
+		callUntilReady(qc.check, done)
+		qc.reprioritize()
+		c.Check(backend.didPriority, DeepEquals, test.expect)
+
+		// After one round of adjustments, nothing more needs
+		// to change.
+		backend.didPriority = nil
+		qc.check()
+		qc.reprioritize()
+		c.Check(backend.didPriority, IsNil)
+		qc.Stop()
+	}
+}
+
+func (s *QueueSuite) TestPriorityDisabled(c *C) {
+	uuids := []string{"zzzzz-dz642-fake0fake0fake0", "zzzzz-dz642-fake1fake1fake1"}
+	backend := &stubBackend{queue: []Job{
+		{ID: "1", Name: uuids[0], Pending: true},
+		{ID: "2", Name: uuids[1], Pending: true},
+	}}
+	qc := &queueChecker{
+		Logger:  logrus.StandardLogger(),
+		Backend: backend,
+		Period:  time.Hour,
+	}
+	qc.startOnce.Do(qc.start)
+	defer qc.Stop()
+	done := make(chan struct{})
+	go func() {
+		qc.SetPriority(uuids[0], 1)
+		qc.SetPriority(uuids[1], 2)
+		close(done)
+	}()
+	callUntilReady(qc.check, done)
+	qc.reprioritize()
+	c.Check(backend.didPriority, IsNil)
+}
+
+func (s *QueueSuite) TestQueueError(c *C) {
+	uuid := "zzzzz-dz642-fake0fake0fake0"
+	backend := &stubBackend{queue: []Job{{ID: "1", Name: uuid}}}
+	qc := &queueChecker{
+		Logger:  logrus.StandardLogger(),
+		Backend: backend,
+		Period:  time.Hour,
+	}
+	qc.startOnce.Do(qc.start)
+	defer qc.Stop()
+
+	var all []string
+	done := make(chan struct{})
+	go func() {
+		all = qc.All()
+		close(done)
+	}()
+	callUntilReady(qc.check, done)
+	c.Check(all, DeepEquals, []string{uuid})
+
+	// A failed queue check doesn't wake up waiters, or forget
+	// the previous queue contents.
+	backend.mtx.Lock()
+	backend.errQueue = errors.New("bjobs: command not found")
+	backend.mtx.Unlock()
+	qc.check()
+	job, ok := qc.Job(uuid)
+	c.Check(ok, Equals, true)
+	c.Check(job.ID, Equals, "1")
+}
+
+func (s *QueueSuite) TestAll(c *C) {
+	backend := &stubBackend{queue: []Job{
+		{ID: "1", Name: "zzzzz-dz642-fake0fake0fake0"},
+		{ID: "2", Name: "zzzzz-dz642-fake1fake1fake1"},
+		{ID: "3", Name: "other job"},
+	}}
+	qc := &queueChecker{
+		Logger:  logrus.StandardLogger(),
+		Backend: backend,
+		Period:  time.Hour,
+	}
+	qc.startOnce.Do(qc.start)
+	defer qc.Stop()
+
+	var all []string
+	done := make(chan struct{})
+	go func() {
+		all = qc.All()
+		close(done)
+	}()
+	callUntilReady(qc.check, done)
+	sort.Strings(all)
+	c.Check(all, DeepEquals, []string{"other job", "zzzzz-dz642-fake0fake0fake0", "zzzzz-dz642-fake1fake1fake1"})
+}
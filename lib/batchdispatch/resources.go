@@ -0,0 +1,34 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package batchdispatch
+
+import (
+	"math"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// Resources is the amount of each resource a job needs in order to
+// run a container.
+type Resources struct {
+	VCPUs      int
+	RAMMiB     int64 // including keep cache and Containers.ReserveExtraRAM
+	ScratchMiB int64 // including space needed to load the docker image
+}
+
+// ContainerResources returns the resources needed to run the given
+// container.
+func ContainerResources(cluster *arvados.Cluster, ctr arvados.Container) Resources {
+	mem := int64(math.Ceil(float64(ctr.RuntimeConstraints.RAM+
+		ctr.RuntimeConstraints.KeepCacheRAM+
+		int64(cluster.Containers.ReserveExtraRAM)) / float64(1048576)))
+	disk := int64(math.Ceil(float64(dispatchcloud.EstimateScratchSpace(&ctr)) / float64(1048576)))
+	return Resources{
+		VCPUs:      ctr.RuntimeConstraints.VCPUs,
+		RAMMiB:     mem,
+		ScratchMiB: disk,
+	}
+}
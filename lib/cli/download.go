@@ -0,0 +1,269 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"rsc.io/getopt"
+)
+
+// Download copies files from a collection to the local filesystem or
+// stdout. It is a native replacement for arv-get, available as
+// "arvados-client download" and "arvados-client keep get". (The
+// top-level "get" subcommand retrieves API objects, not data.)
+//
+// Each block is verified against the hash in its locator as it is
+// read from Keep, so a corrupt block causes the download to fail
+// rather than producing a corrupt file.
+var Download cmd.Handler = downloadCmd{}
+
+type downloadCmd struct{}
+
+type downloadOptions struct {
+	Force        bool
+	SkipExisting bool
+	Progress     bool
+	NoProgress   bool
+}
+
+func (downloadCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	var opts downloadOptions
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, `usage: %s [options] locator[/path] [destination]
+
+Copy data from Keep to a local file or directory, or to stdout.
+
+The locator can be a collection UUID or portable data hash. If the
+source is a directory (e.g., "zzzzz-4zz18-0123456789abcdef/"), its
+contents are copied recursively into the destination directory,
+which defaults to the current directory. If the source is a file and
+the destination is "-", the file is written to stdout.
+
+`, prog)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&opts.Force, "force", false, "Overwrite existing files")
+	flags.BoolVar(&opts.SkipExisting, "skip-existing", false, "Skip files that already exist")
+	flags.BoolVar(&opts.Progress, "progress", false, "Display a progress indicator on stderr (default if stderr is a terminal)")
+	flags.BoolVar(&opts.NoProgress, "no-progress", false, "Do not display a progress indicator")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		err = nil
+		return 0
+	} else if err != nil {
+		err = nil // already printed by flags.Parse
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+	if opts.Force && opts.SkipExisting {
+		err = fmt.Errorf("cannot use --force and --skip-existing together")
+		return 2
+	}
+	if !opts.Progress && !opts.NoProgress {
+		opts.Progress = isTerminal(stderr)
+	}
+	src := flags.Arg(0)
+	dst := flags.Arg(1)
+
+	client := arvados.NewClientFromEnv()
+	ac, err := arvadosclient.New(client)
+	if err != nil {
+		return 1
+	}
+	kc, err := keepclient.MakeKeepClient(ac)
+	if err != nil {
+		return 1
+	}
+	dl := &downloader{
+		client: client,
+		kc:     kc,
+		opts:   opts,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	err = dl.run(src, dst)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+type downloader struct {
+	client *arvados.Client
+	kc     *keepclient.KeepClient
+	opts   downloadOptions
+	stdout io.Writer
+	stderr io.Writer
+
+	progress *progressReporter
+}
+
+func (dl *downloader) run(src, dst string) error {
+	id, srcpath := src, ""
+	if i := strings.Index(src, "/"); i >= 0 {
+		id, srcpath = src[:i], strings.Trim(src[i+1:], "/")
+	}
+	if !arvadosclient.UUIDMatch(id) && !arvadosclient.PDHMatch(id) {
+		return fmt.Errorf("%q is not a collection UUID or portable data hash", id)
+	}
+	var coll arvados.Collection
+	err := dl.client.RequestAndDecode(&coll, "GET", "arvados/v1/collections/"+id, nil, nil)
+	if err != nil {
+		return fmt.Errorf("error getting collection %s: %s", id, err)
+	}
+	fs, err := coll.FileSystem(dl.client, dl.kc)
+	if err != nil {
+		return err
+	}
+	name := srcpath
+	if name == "" {
+		name = "."
+	}
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return fmt.Errorf("%s: %s", src, err)
+	}
+
+	if !fi.IsDir() {
+		if dst == "-" {
+			dl.progress = &progressReporter{w: dl.stderr, total: fi.Size(), enabled: dl.opts.Progress}
+			err = dl.copyFile(fs, srcpath, dl.stdout)
+			dl.progress.finish()
+			return err
+		}
+		if dst == "" {
+			dst = path.Base(srcpath)
+		} else if dstfi, err := os.Stat(dst); err == nil && dstfi.IsDir() {
+			dst = filepath.Join(dst, path.Base(srcpath))
+		}
+		dl.progress = &progressReporter{w: dl.stderr, total: fi.Size(), enabled: dl.opts.Progress}
+		err = dl.downloadFile(fs, srcpath, dst)
+		dl.progress.finish()
+		return err
+	}
+
+	if dst == "-" {
+		return fmt.Errorf("%s: cannot write a directory to stdout", src)
+	} else if dst == "" {
+		dst = "."
+	}
+	type todo struct{ src, dst string }
+	var files []todo
+	var total int64
+	err = walkFS(fs, srcpath, func(p string, fi os.FileInfo) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, srcpath), "/")
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, filepath.FromSlash(rel)), 0777)
+		}
+		files = append(files, todo{src: p, dst: filepath.Join(dst, filepath.FromSlash(rel))})
+		total += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dst, 0777)
+	if err != nil {
+		return err
+	}
+	dl.progress = &progressReporter{w: dl.stderr, total: total, enabled: dl.opts.Progress}
+	for _, f := range files {
+		err = dl.downloadFile(fs, f.src, f.dst)
+		if err != nil {
+			return err
+		}
+	}
+	dl.progress.finish()
+	return nil
+}
+
+// downloadFile copies the file at srcpath in fs to the local file
+// dst. If the copy fails, the partially written local file is
+// removed.
+func (dl *downloader) downloadFile(fs arvados.FileSystem, srcpath, dst string) error {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !dl.opts.Force {
+		flag |= os.O_EXCL
+	}
+	f, err := os.OpenFile(dst, flag, 0666)
+	if os.IsExist(err) && dl.opts.SkipExisting {
+		if fi, err := fs.Stat(srcpath); err == nil {
+			dl.progress.add(fi.Size())
+		}
+		return nil
+	} else if os.IsExist(err) {
+		return fmt.Errorf("%s: file exists (use --force to overwrite, or --skip-existing)", dst)
+	} else if err != nil {
+		return err
+	}
+	err = dl.copyFile(fs, srcpath, f)
+	if err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func (dl *downloader) copyFile(fs arvados.FileSystem, srcpath string, dst io.Writer) error {
+	f, err := fs.Open(srcpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var copied int64
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			copied += int64(n)
+			dl.progress.add(int64(n))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", srcpath, err)
+		}
+	}
+	if copied != fi.Size() {
+		return fmt.Errorf("%s: read %d bytes, expected %d", srcpath, copied, fi.Size())
+	}
+	return nil
+}
@@ -24,8 +24,8 @@ var (
 	Ws   = externalCmd{"arv-ws"}
 
 	Keep = cmd.Multi(map[string]cmd.Handler{
-		"get":       externalCmd{"arv-get"},
-		"put":       externalCmd{"arv-put"},
+		"get":       Download,
+		"put":       Put,
 		"ls":        externalCmd{"arv-ls"},
 		"normalize": externalCmd{"arv-normalize"},
 		"docker":    externalCmd{"arv-keepdocker"},
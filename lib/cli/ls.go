@@ -0,0 +1,494 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"rsc.io/getopt"
+)
+
+// Ls lists projects, collections, and files in the site filesystem.
+var Ls cmd.Handler = lsCmd{}
+
+// Find searches the site filesystem for projects, collections, and
+// files matching the given criteria.
+var Find cmd.Handler = findCmd{}
+
+const siteFSUsage = `Paths refer to the site filesystem, which has the following top level
+directories:
+
+  home/        projects and collections owned by the current user
+  users/       home projects of all visible users, by username
+  by_id/       collections and projects by UUID or portable data hash
+
+A path that starts with a UUID or portable data hash is taken to be
+relative to by_id/. Paths can contain shell-style glob patterns
+(*, ?, [...]); quote them to prevent expansion by the local shell.
+`
+
+type lsCmd struct{}
+
+func (lsCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	var out siteEntryPrinter
+	var dirOnly bool
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] [path ...]\n\nList projects, collections, and files (default path: home).\n\n%s\n", prog, siteFSUsage)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&out.Long, "long", false, "Show type, size, modification time, UUID, and portable data hash")
+	flags.Alias("l", "long")
+	flags.BoolVar(&out.JSON, "json", false, "Print one JSON object per entry, including properties")
+	flags.BoolVar(&dirOnly, "directory", false, "List directories themselves, not their contents")
+	flags.Alias("d", "directory")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		err = nil
+		return 0
+	} else if err != nil {
+		err = nil // already printed by flags.Parse
+		return 2
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"home"}
+	}
+	fs, err := newSiteFS()
+	if err != nil {
+		return 1
+	}
+	out.w = stdout
+
+	exitcode := 0
+	for _, arg := range paths {
+		matches, err := globFS(fs, sitePath(arg))
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s: %s\n", prog, arg, err)
+			exitcode = 1
+			continue
+		} else if len(matches) == 0 {
+			fmt.Fprintf(stderr, "%s: %s: %s\n", prog, arg, os.ErrNotExist)
+			exitcode = 1
+			continue
+		}
+		for _, p := range matches {
+			err = lsPath(fs, p, dirOnly, len(paths) > 1 || len(matches) > 1, &out)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %s: %s\n", prog, p, err)
+				exitcode = 1
+			}
+		}
+	}
+	err = nil
+	return exitcode
+}
+
+// lsPath prints the entry at path p, or (if p is a directory and
+// dirOnly is false) its contents.
+func lsPath(fs arvados.FileSystem, p string, dirOnly, header bool, out *siteEntryPrinter) error {
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() || dirOnly {
+		return out.print(newSiteEntry(p, fi), p)
+	}
+	ents, err := readDirFS(fs, p)
+	if err != nil {
+		return err
+	}
+	if header && !out.JSON {
+		fmt.Fprintf(out.w, "%s:\n", p)
+	}
+	for _, fi := range ents {
+		err = out.print(newSiteEntry(path.Join(p, fi.Name()), fi), fi.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type findCmd struct{}
+
+func (findCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	var out siteEntryPrinter
+	var f finder
+	var properties stringList
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] [path ...]\n\nSearch for projects, collections, and files (default path: home).\n\n%s\n", prog, siteFSUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&f.Name, "name", "", "Only show entries whose name matches the given glob `pattern`")
+	flags.StringVar(&f.Type, "type", "", "Only show entries of the given `type`: f (file), d (directory within a collection), c (collection), or p (project)")
+	flags.Var(&properties, "property", "Only show projects and collections with the given property `key[=value]` (can be repeated)")
+	flags.IntVar(&f.MaxDepth, "maxdepth", -1, "Descend at most `N` levels below the given paths")
+	flags.BoolVar(&out.Long, "long", false, "Show type, size, modification time, UUID, and portable data hash")
+	flags.Alias("l", "long")
+	flags.BoolVar(&out.JSON, "json", false, "Print one JSON object per entry, including properties")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		err = nil
+		return 0
+	} else if err != nil {
+		err = nil // already printed by flags.Parse
+		return 2
+	}
+	switch f.Type {
+	case "", "f", "d", "c", "p":
+	default:
+		err = fmt.Errorf("invalid --type %q: must be f, d, c, or p", f.Type)
+		return 2
+	}
+	if f.Name != "" {
+		if _, err = path.Match(f.Name, ""); err != nil {
+			err = fmt.Errorf("invalid --name pattern %q: %s", f.Name, err)
+			return 2
+		}
+	}
+	for _, prop := range properties {
+		kv := strings.SplitN(prop, "=", 2)
+		f.Properties = append(f.Properties, kv)
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"home"}
+	}
+	fs, err := newSiteFS()
+	if err != nil {
+		return 1
+	}
+	out.w = stdout
+	f.fs = fs
+	f.out = &out
+	f.stderr = stderr
+	f.prog = prog
+
+	for _, arg := range paths {
+		matches, err := globFS(fs, sitePath(arg))
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s: %s\n", prog, arg, err)
+			f.failed = true
+			continue
+		} else if len(matches) == 0 {
+			fmt.Fprintf(stderr, "%s: %s: %s\n", prog, arg, os.ErrNotExist)
+			f.failed = true
+			continue
+		}
+		for _, p := range matches {
+			f.find(p)
+		}
+	}
+	err = nil
+	if f.failed {
+		return 1
+	}
+	return 0
+}
+
+type finder struct {
+	Name       string
+	Type       string
+	Properties [][]string // {key} or {key, value}
+	MaxDepth   int
+
+	fs     arvados.FileSystem
+	out    *siteEntryPrinter
+	stderr io.Writer
+	prog   string
+	failed bool
+}
+
+// find prints p and its descendants that match the criteria. Errors
+// are reported on stderr, and do not stop the search.
+func (f *finder) find(p string) {
+	fi, err := f.fs.Stat(p)
+	if err != nil {
+		f.error(p, err)
+		return
+	}
+	f.walk(newSiteEntry(p, fi), 0)
+}
+
+func (f *finder) walk(ent siteEntry, depth int) {
+	if f.match(ent) {
+		if err := f.out.print(ent, ent.Path); err != nil {
+			f.error(ent.Path, err)
+			return
+		}
+	}
+	if ent.Type == "file" || (f.MaxDepth >= 0 && depth >= f.MaxDepth) {
+		return
+	}
+	if ent.Type == "collection" && (len(f.Properties) > 0 || f.Type == "c" || f.Type == "p") {
+		// Nothing inside a collection can match, so don't
+		// bother loading it.
+		return
+	}
+	ents, err := readDirFS(f.fs, ent.Path)
+	if err != nil {
+		f.error(ent.Path, err)
+		return
+	}
+	for _, fi := range ents {
+		f.walk(newSiteEntry(path.Join(ent.Path, fi.Name()), fi), depth+1)
+	}
+}
+
+func (f *finder) match(ent siteEntry) bool {
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, path.Base(ent.Path)); !ok {
+			return false
+		}
+	}
+	switch f.Type {
+	case "f":
+		if ent.Type != "file" {
+			return false
+		}
+	case "d":
+		if ent.Type != "directory" {
+			return false
+		}
+	case "c":
+		if ent.Type != "collection" {
+			return false
+		}
+	case "p":
+		if ent.Type != "project" {
+			return false
+		}
+	}
+	for _, kv := range f.Properties {
+		if ent.Type != "collection" && ent.Type != "project" {
+			return false
+		}
+		v, ok := ent.Properties[kv[0]]
+		if !ok {
+			return false
+		}
+		if len(kv) == 2 && fmt.Sprint(v) != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *finder) error(p string, err error) {
+	fmt.Fprintf(f.stderr, "%s: %s: %s\n", f.prog, p, err)
+	f.failed = true
+}
+
+// stringList is a flag.Value that accumulates the values of a
+// repeated flag.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+func newSiteFS() (arvados.CustomFileSystem, error) {
+	client := arvados.NewClientFromEnv()
+	ac, err := arvadosclient.New(client)
+	if err != nil {
+		return nil, err
+	}
+	kc, err := keepclient.MakeKeepClient(ac)
+	if err != nil {
+		return nil, err
+	}
+	fs := client.SiteFileSystem(kc)
+	fs.MountProject("home", "")
+	return fs, nil
+}
+
+// sitePath converts a command line argument to an absolute path in
+// the site filesystem. A path that starts with a UUID or portable
+// data hash (or a collection version like "{uuid}@{version}") is
+// taken to be relative to /by_id.
+func sitePath(arg string) string {
+	first := strings.SplitN(strings.TrimPrefix(arg, "/"), "/", 2)[0]
+	first = strings.SplitN(first, "@", 2)[0]
+	if arvadosclient.UUIDMatch(first) || arvadosclient.PDHMatch(first) {
+		return path.Join("/by_id", arg)
+	}
+	return path.Join("/", arg)
+}
+
+// globFS returns the paths in fs that match the given absolute glob
+// pattern, in lexical order. Path components without glob
+// metacharacters are looked up directly, so directories like
+// /users are not listed unnecessarily.
+func globFS(fs arvados.FileSystem, pattern string) ([]string, error) {
+	matches := []string{"/"}
+	for _, elem := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		var next []string
+		for _, dir := range matches {
+			if !strings.ContainsAny(elem, `*?[\`) {
+				p := path.Join(dir, elem)
+				if _, err := fs.Stat(p); err == nil {
+					next = append(next, p)
+				} else if !os.IsNotExist(err) {
+					return nil, err
+				}
+				continue
+			}
+			if _, err := path.Match(elem, ""); err != nil {
+				return nil, err
+			}
+			if fi, err := fs.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+			ents, err := readDirFS(fs, dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, fi := range ents {
+				if ok, _ := path.Match(elem, fi.Name()); ok {
+					next = append(next, path.Join(dir, fi.Name()))
+				}
+			}
+		}
+		matches = next
+	}
+	return matches, nil
+}
+
+// readDirFS returns the entries of directory dir in fs, sorted by
+// name.
+func readDirFS(fs arvados.FileSystem, dir string) ([]os.FileInfo, error) {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	ents, err := d.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+	return ents, nil
+}
+
+// siteEntry describes a project, collection, directory, or file in
+// the site filesystem.
+type siteEntry struct {
+	Path             string                 `json:"path"`
+	Type             string                 `json:"type"`
+	Size             int64                  `json:"size"`
+	ModTime          time.Time              `json:"modified_at"`
+	UUID             string                 `json:"uuid,omitempty"`
+	PortableDataHash string                 `json:"portable_data_hash,omitempty"`
+	Properties       map[string]interface{} `json:"properties,omitempty"`
+}
+
+func newSiteEntry(p string, fi os.FileInfo) siteEntry {
+	ent := siteEntry{
+		Path:    p,
+		Type:    "file",
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	switch sys := fi.Sys().(type) {
+	case *arvados.Collection:
+		ent.Type = "collection"
+		ent.UUID = sys.UUID
+		ent.PortableDataHash = sys.PortableDataHash
+		ent.Properties = sys.Properties
+		ent.Size = sys.FileSizeTotal
+		if !sys.ModifiedAt.IsZero() {
+			ent.ModTime = sys.ModifiedAt
+		}
+	case *arvados.Group:
+		ent.Type = "project"
+		ent.UUID = sys.UUID
+		ent.Properties = sys.Properties
+		ent.Size = 0
+		if !sys.ModifiedAt.IsZero() {
+			ent.ModTime = sys.ModifiedAt
+		}
+	case *arvados.User:
+		ent.Type = "project"
+		ent.UUID = sys.UUID
+		ent.Size = 0
+	default:
+		if fi.IsDir() {
+			ent.Type = "directory"
+			ent.Size = 0
+		}
+	}
+	return ent
+}
+
+// siteEntryPrinter writes siteEntry records in the format selected
+// by command line flags.
+type siteEntryPrinter struct {
+	Long bool
+	JSON bool
+
+	w io.Writer
+}
+
+// print writes ent. In text formats, the entry is identified by
+// the given display name; in JSON format, by its full path.
+func (sep *siteEntryPrinter) print(ent siteEntry, name string) error {
+	var err error
+	switch {
+	case sep.JSON:
+		err = json.NewEncoder(sep.w).Encode(ent)
+	case sep.Long:
+		_, err = fmt.Fprintf(sep.w, "%-10s %12d %s %-27s %-44s %s\n",
+			ent.Type, ent.Size, ent.ModTime.UTC().Format("2006-01-02 15:04"),
+			dashIfEmpty(ent.UUID), dashIfEmpty(ent.PortableDataHash), name)
+	default:
+		_, err = fmt.Fprintln(sep.w, name)
+	}
+	return err
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&LsSuite{})
+
+type LsSuite struct {
+	fs arvados.CollectionFileSystem
+}
+
+func (s *LsSuite) SetUpTest(c *check.C) {
+	var err error
+	s.fs, err = (&arvados.Collection{
+		UUID:             "zzzzz-4zz18-aaaaaaaaaaaaaaa",
+		PortableDataHash: "d41d8cd98f00b204e9800998ecf8427e+0",
+		Properties:       map[string]interface{}{"sample": "s1", "n": 3},
+		FileSizeTotal:    12,
+		ManifestText: ". 37b51d194a7513e45b56f6524f2d51f2+3 acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:bar.txt 3:3:foo.fastq\n" +
+			"./dir1 acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:a.fastq\n" +
+			"./dir1/dir2 acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:b.fastq\n",
+	}).FileSystem(nil, nil)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *LsSuite) TestSitePath(c *check.C) {
+	for in, out := range map[string]string{
+		"":                                   "/",
+		"home":                               "/home",
+		"/home/Project 1/":                   "/home/Project 1",
+		"users/active/*":                     "/users/active/*",
+		"zzzzz-4zz18-aaaaaaaaaaaaaaa":        "/by_id/zzzzz-4zz18-aaaaaaaaaaaaaaa",
+		"/zzzzz-j7d0g-aaaaaaaaaaaaaaa/foo":   "/by_id/zzzzz-j7d0g-aaaaaaaaaaaaaaa/foo",
+		"zzzzz-4zz18-aaaaaaaaaaaaaaa@2/foo":  "/by_id/zzzzz-4zz18-aaaaaaaaaaaaaaa@2/foo",
+		"d41d8cd98f00b204e9800998ecf8427e+0": "/by_id/d41d8cd98f00b204e9800998ecf8427e+0",
+	} {
+		c.Check(sitePath(in), check.Equals, out, check.Commentf("%q", in))
+	}
+}
+
+func (s *LsSuite) TestGlob(c *check.C) {
+	for pattern, expect := range map[string][]string{
+		"/":               {"/"},
+		"/bar.txt":        {"/bar.txt"},
+		"/nonexistent":    nil,
+		"/*.fastq":        {"/foo.fastq"},
+		"/*/*.fastq":      {"/dir1/a.fastq"},
+		"/dir?/dir2/*":    {"/dir1/dir2/b.fastq"},
+		"/*":              {"/bar.txt", "/dir1", "/foo.fastq"},
+		"/bar.txt/*":      nil,
+		"/[a-c]*/[ab]*.*": nil,
+	} {
+		matches, err := globFS(s.fs, pattern)
+		c.Check(err, check.IsNil)
+		c.Check(matches, check.DeepEquals, expect, check.Commentf("%q", pattern))
+	}
+	_, err := globFS(s.fs, "/[")
+	c.Check(err, check.NotNil)
+}
+
+func (s *LsSuite) TestSiteEntry(c *check.C) {
+	fi, err := s.fs.Stat("/")
+	c.Assert(err, check.IsNil)
+	ent := newSiteEntry("/by_id/x", fi)
+	c.Check(ent.Type, check.Equals, "collection")
+	c.Check(ent.UUID, check.Equals, "zzzzz-4zz18-aaaaaaaaaaaaaaa")
+	c.Check(ent.PortableDataHash, check.Equals, "d41d8cd98f00b204e9800998ecf8427e+0")
+	c.Check(ent.Size, check.Equals, int64(12))
+	c.Check(ent.Properties["sample"], check.Equals, "s1")
+
+	fi, err = s.fs.Stat("/dir1")
+	c.Assert(err, check.IsNil)
+	ent = newSiteEntry("/by_id/x/dir1", fi)
+	c.Check(ent.Type, check.Equals, "directory")
+	c.Check(ent.UUID, check.Equals, "")
+
+	fi, err = s.fs.Stat("/dir1/a.fastq")
+	c.Assert(err, check.IsNil)
+	ent = newSiteEntry("/by_id/x/dir1/a.fastq", fi)
+	c.Check(ent.Type, check.Equals, "file")
+	c.Check(ent.Size, check.Equals, int64(3))
+
+	var buf bytes.Buffer
+	out := siteEntryPrinter{Long: true, w: &buf}
+	c.Check(out.print(ent, "a.fastq"), check.IsNil)
+	c.Check(buf.String(), check.Matches, `file +3 \d{4}-\d\d-\d\d \d\d:\d\d - +- +a\.fastq\n`)
+
+	buf.Reset()
+	out = siteEntryPrinter{JSON: true, w: &buf}
+	c.Check(out.print(ent, "a.fastq"), check.IsNil)
+	var decoded map[string]interface{}
+	c.Check(json.Unmarshal(buf.Bytes(), &decoded), check.IsNil)
+	c.Check(decoded["path"], check.Equals, "/by_id/x/dir1/a.fastq")
+	c.Check(decoded["type"], check.Equals, "file")
+}
+
+func (s *LsSuite) find(c *check.C, f finder) []string {
+	var stdout, stderr bytes.Buffer
+	f.fs = s.fs
+	f.out = &siteEntryPrinter{w: &stdout}
+	f.stderr = &stderr
+	f.prog = "find"
+	f.find("/")
+	c.Check(stderr.String(), check.Equals, "")
+	c.Check(f.failed, check.Equals, false)
+	return strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+}
+
+func (s *LsSuite) TestFind(c *check.C) {
+	c.Check(s.find(c, finder{MaxDepth: -1}), check.DeepEquals, []string{
+		"/", "/bar.txt", "/dir1", "/dir1/a.fastq", "/dir1/dir2", "/dir1/dir2/b.fastq", "/foo.fastq",
+	})
+	c.Check(s.find(c, finder{MaxDepth: 1}), check.DeepEquals, []string{
+		"/", "/bar.txt", "/dir1", "/foo.fastq",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Name: "*.fastq"}), check.DeepEquals, []string{
+		"/dir1/a.fastq", "/dir1/dir2/b.fastq", "/foo.fastq",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Type: "d"}), check.DeepEquals, []string{
+		"/dir1", "/dir1/dir2",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Type: "c"}), check.DeepEquals, []string{
+		"/",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Properties: [][]string{{"sample", "s1"}, {"n", "3"}}}), check.DeepEquals, []string{
+		"/",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Properties: [][]string{{"sample"}}}), check.DeepEquals, []string{
+		"/",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Properties: [][]string{{"sample", "s2"}}}), check.DeepEquals, []string{
+		"",
+	})
+	c.Check(s.find(c, finder{MaxDepth: -1, Properties: [][]string{{"nonexistent"}}}), check.DeepEquals, []string{
+		"",
+	})
+}
@@ -0,0 +1,629 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"rsc.io/getopt"
+)
+
+// Put uploads files and directories to Keep and saves them as a
+// collection. It is a native replacement for arv-put.
+var Put cmd.Handler = putCmd{}
+
+type putCmd struct{}
+
+type putOptions struct {
+	Name             string
+	ProjectUUID      string
+	Filename         string
+	Replication      int
+	StorageClasses   string
+	Stream           bool
+	PortableDataHash bool
+	Progress         bool
+	NoProgress       bool
+	Resume           bool
+	NoResume         bool
+	CacheDir         string
+}
+
+// Save the upload state to the resume cache at least this often.
+const putCheckpointInterval = 20 * time.Second
+
+// Don't resume from cached state if any of its block signatures
+// expire sooner than this.
+const putMinSignatureTTL = time.Hour
+
+func (putCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	opts := putOptions{CacheDir: defaultPutCacheDir()}
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] path [path ...]\n\nUpload files and directories to Keep and save them as a new collection.\nUse \"-\" to read data from stdin.\n\n", prog)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.Name, "name", "", "Save the collection with the given `name` (default: \"Saved at {time} by {user}@{host}\")")
+	flags.StringVar(&opts.ProjectUUID, "project-uuid", "", "Save the collection in the given project (default: home project)")
+	flags.StringVar(&opts.Filename, "filename", "stdin", "Use the given `filename` in the collection when reading from stdin")
+	flags.IntVar(&opts.Replication, "replication", 0, "Number of replicas to store, and desired replication level of the new collection (default: cluster default)")
+	flags.StringVar(&opts.StorageClasses, "storage-classes", "", "Comma-separated list of storage `classes` to store the data in (default: cluster default)")
+	flags.BoolVar(&opts.Stream, "stream", false, "Print the manifest text on stdout instead of saving a collection")
+	flags.BoolVar(&opts.PortableDataHash, "portable-data-hash", false, "Print the portable data hash of the new collection instead of its UUID")
+	flags.BoolVar(&opts.Progress, "progress", false, "Display a progress indicator on stderr (default if stderr is a terminal)")
+	flags.BoolVar(&opts.NoProgress, "no-progress", false, "Do not display a progress indicator")
+	flags.BoolVar(&opts.Resume, "resume", true, "Continue interrupted uploads from cached state")
+	flags.BoolVar(&opts.NoResume, "no-resume", false, "Do not continue interrupted uploads; start over and do not save upload state")
+	flags.StringVar(&opts.CacheDir, "cache-dir", opts.CacheDir, "Directory for resumable upload state")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		err = nil
+		return 0
+	} else if err != nil {
+		err = nil // already printed by flags.Parse
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if opts.Replication < 0 {
+		err = fmt.Errorf("invalid --replication value %d", opts.Replication)
+		return 2
+	}
+	if opts.NoResume {
+		opts.Resume = false
+	}
+	if !opts.Progress && !opts.NoProgress {
+		opts.Progress = isTerminal(stderr)
+	}
+
+	client := arvados.NewClientFromEnv()
+	ac, err := arvadosclient.New(client)
+	if err != nil {
+		return 1
+	}
+	kc, err := keepclient.MakeKeepClient(ac)
+	if err != nil {
+		return 1
+	}
+	if opts.Replication > 0 {
+		kc.Want_replicas = opts.Replication
+	}
+	kc.StorageClasses = splitStorageClasses(opts.StorageClasses)
+
+	up := &uploader{
+		client: client,
+		kc:     kc,
+		opts:   opts,
+		stdin:  stdin,
+		stderr: stderr,
+	}
+	err = up.run(flags.Args())
+	if err != nil {
+		return 1
+	}
+	if opts.Stream {
+		_, err = io.WriteString(stdout, up.manifest)
+	} else if opts.PortableDataHash {
+		_, err = fmt.Fprintln(stdout, up.coll.PortableDataHash)
+	} else {
+		_, err = fmt.Fprintln(stdout, up.coll.UUID)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+func splitStorageClasses(s string) []string {
+	var classes []string
+	for _, class := range strings.Split(s, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+func defaultPutCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "arvados", "arv-put")
+	}
+	return ""
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// putFile is a local file (or stdin) to be uploaded.
+type putFile struct {
+	src     string // local path, or "-" for stdin
+	dst     string // path in collection
+	size    int64
+	modTime time.Time
+}
+
+// putState is the resumable upload state saved in the cache
+// directory.
+type putState struct {
+	// Manifest of data uploaded so far, with signed locators.
+	Manifest string
+	// Files that are completely represented in Manifest, keyed
+	// by path in the collection.
+	Files map[string]putFileState
+}
+
+type putFileState struct {
+	Source  string
+	Size    int64
+	ModTime time.Time
+}
+
+type uploader struct {
+	client *arvados.Client
+	kc     *keepclient.KeepClient
+	opts   putOptions
+	stdin  io.Reader
+	stderr io.Writer
+
+	fs             arvados.CollectionFileSystem
+	state          putState
+	cacheFile      string
+	lastCheckpoint time.Time
+	progress       *progressReporter
+
+	// Results
+	manifest string
+	coll     arvados.Collection
+}
+
+func (up *uploader) run(paths []string) error {
+	files, err := up.listFiles(paths)
+	if err != nil {
+		return err
+	}
+	if up.opts.Resume && up.opts.CacheDir != "" && !up.opts.Stream {
+		up.cacheFile = filepath.Join(up.opts.CacheDir, up.cacheKey(files))
+	}
+	err = up.loadState()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, f := range files {
+		if f.size < 0 {
+			// Reading from stdin, size unknown.
+			total = -1
+			break
+		}
+		total += f.size
+	}
+	up.progress = &progressReporter{w: up.stderr, total: total, enabled: up.opts.Progress}
+	up.lastCheckpoint = time.Now()
+	for _, f := range files {
+		err = up.uploadFile(f)
+		if err != nil {
+			return err
+		}
+	}
+	up.progress.finish()
+
+	// MarshalManifest flushes all remaining data to Keep.
+	up.manifest, err = up.fs.MarshalManifest(".")
+	if err != nil {
+		return err
+	}
+	// Save state in case we fail to save the collection, so the
+	// next attempt doesn't need to upload anything.
+	up.state.Manifest = up.manifest
+	err = up.saveState()
+	if err != nil {
+		return err
+	}
+	if !up.opts.Stream {
+		err = up.saveCollection()
+		if err != nil {
+			return err
+		}
+	}
+	if up.cacheFile != "" {
+		os.Remove(up.cacheFile)
+	}
+	return nil
+}
+
+// listFiles returns the files to upload, in the order they should be
+// uploaded.
+//
+// As with arv-put, if a single directory is given, its contents are
+// stored at the top level of the collection. Otherwise, each
+// argument is stored at the top level using its base name.
+func (up *uploader) listFiles(paths []string) ([]putFile, error) {
+	var files []putFile
+	for _, src := range paths {
+		if src == "-" {
+			files = append(files, putFile{src: "-", dst: up.opts.Filename, size: -1})
+			continue
+		}
+		fi, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, putFile{src: src, dst: filepath.Base(src), size: fi.Size(), modTime: fi.ModTime()})
+			continue
+		}
+		prefix := filepath.Base(src)
+		if len(paths) == 1 {
+			prefix = ""
+		}
+		err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, putFile{
+				src:     p,
+				dst:     path.Join(prefix, filepath.ToSlash(rel)),
+				size:    fi.Size(),
+				modTime: fi.ModTime(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if seen[f.dst] {
+			return nil, fmt.Errorf("cannot upload multiple files to the same path %q", f.dst)
+		}
+		seen[f.dst] = true
+	}
+	return files, nil
+}
+
+// cacheKey returns the name of the state cache file for the given
+// upload. Uploading the same files to the same cluster with the same
+// options resumes from the same cache file.
+func (up *uploader) cacheKey(files []putFile) string {
+	h := md5.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00", up.client.APIHost, up.opts.Replication, up.opts.StorageClasses)
+	for _, f := range files {
+		src := f.src
+		if abs, err := filepath.Abs(src); err == nil {
+			src = abs
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", src, f.dst)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// loadState initializes up.fs, using the cached state if possible.
+func (up *uploader) loadState() error {
+	up.state = putState{Files: map[string]putFileState{}}
+	if up.cacheFile != "" {
+		buf, err := ioutil.ReadFile(up.cacheFile)
+		if err == nil {
+			var state putState
+			err = json.Unmarshal(buf, &state)
+			if err != nil {
+				fmt.Fprintf(up.stderr, "ignoring corrupt upload state %s: %s\n", up.cacheFile, err)
+			} else if exp := earliestSignatureExpiry(state.Manifest); !exp.IsZero() && exp.Before(time.Now().Add(putMinSignatureTTL)) {
+				fmt.Fprintf(up.stderr, "ignoring upload state %s: block signatures expire at %s\n", up.cacheFile, exp.UTC().Format(time.RFC3339))
+			} else if state.Files != nil {
+				up.state = state
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	up.fs, err = (&arvados.Collection{ManifestText: up.state.Manifest}).FileSystem(up.client, up.kc)
+	if err != nil {
+		return fmt.Errorf("loading upload state %s: %s", up.cacheFile, err)
+	}
+	// Discard any partially uploaded files.
+	return walkFS(up.fs, "", func(p string, fi os.FileInfo) error {
+		if _, ok := up.state.Files[p]; !ok && !fi.IsDir() {
+			return up.fs.Remove(p)
+		}
+		return nil
+	})
+}
+
+// saveState writes the upload state to the cache directory, if
+// resume is enabled.
+func (up *uploader) saveState() error {
+	up.lastCheckpoint = time.Now()
+	if up.cacheFile == "" {
+		return nil
+	}
+	buf, err := json.Marshal(up.state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(up.cacheFile), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(up.cacheFile), filepath.Base(up.cacheFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), up.cacheFile)
+}
+
+// checkpoint flushes buffered data to Keep and saves the upload
+// state, if enough time has passed since the last checkpoint.
+func (up *uploader) checkpoint() error {
+	if up.cacheFile == "" || time.Since(up.lastCheckpoint) < putCheckpointInterval {
+		return nil
+	}
+	var err error
+	up.state.Manifest, err = up.fs.MarshalManifest(".")
+	if err != nil {
+		return err
+	}
+	return up.saveState()
+}
+
+func (up *uploader) uploadFile(f putFile) error {
+	if cached, ok := up.state.Files[f.dst]; ok && f.src != "-" {
+		if cached.Source == f.src && cached.Size == f.size && cached.ModTime.Equal(f.modTime) {
+			up.progress.add(f.size)
+			return nil
+		}
+		err := up.fs.Remove(f.dst)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(up.state.Files, f.dst)
+	}
+
+	var src io.Reader
+	if f.src == "-" {
+		src = up.stdin
+	} else {
+		local, err := os.Open(f.src)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		src = local
+	}
+	err := mkdirAll(up.fs, path.Dir(f.dst))
+	if err != nil {
+		return err
+	}
+	dst, err := up.fs.OpenFile(f.dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return fmt.Errorf("%s: %s", f.dst, err)
+			}
+			up.progress.add(int64(n))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", f.src, err)
+		}
+		if err := up.checkpoint(); err != nil {
+			return err
+		}
+	}
+	err = dst.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.dst, err)
+	}
+	if f.src != "-" {
+		up.state.Files[f.dst] = putFileState{Source: f.src, Size: f.size, ModTime: f.modTime}
+	}
+	return up.checkpoint()
+}
+
+func (up *uploader) saveCollection() error {
+	name := up.opts.Name
+	if name == "" {
+		username := "unknown"
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+		hostname, _ := os.Hostname()
+		name = fmt.Sprintf("Saved at %s by %s@%s", time.Now().UTC().Format("2006-01-02 15:04:05"), username, hostname)
+	}
+	attrs := map[string]interface{}{
+		"name":          name,
+		"manifest_text": up.manifest,
+	}
+	if up.opts.ProjectUUID != "" {
+		attrs["owner_uuid"] = up.opts.ProjectUUID
+	}
+	if up.opts.Replication > 0 {
+		attrs["replication_desired"] = up.opts.Replication
+	}
+	if classes := splitStorageClasses(up.opts.StorageClasses); len(classes) > 0 {
+		attrs["storage_classes_desired"] = classes
+	}
+	err := up.client.RequestAndDecode(&up.coll, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"ensure_unique_name": true,
+		"collection":         attrs,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving collection: %s", err)
+	}
+	return nil
+}
+
+var signatureExpiryRe = regexp.MustCompile(`\+A[[:xdigit:]]+@([[:xdigit:]]{8,})`)
+
+// earliestSignatureExpiry returns the earliest expiry time of all
+// signed locators in the given manifest, or the zero time if there
+// are none.
+func earliestSignatureExpiry(manifest string) time.Time {
+	var earliest time.Time
+	for _, m := range signatureExpiryRe.FindAllStringSubmatch(manifest, -1) {
+		ts, err := strconv.ParseInt(m[1], 16, 64)
+		if err != nil {
+			continue
+		}
+		if t := time.Unix(ts, 0); earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+// mkdirAll creates the given directory in fs, along with any
+// necessary parents.
+func mkdirAll(fs arvados.FileSystem, dir string) error {
+	if dir == "" || dir == "." || dir == "/" {
+		return nil
+	}
+	fi, err := fs.Stat(dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%s: %s", dir, arvados.ErrNotADirectory)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	err = mkdirAll(fs, path.Dir(dir))
+	if err != nil {
+		return err
+	}
+	err = fs.Mkdir(dir, 0755)
+	if errors.Is(err, os.ErrExist) {
+		err = nil
+	}
+	return err
+}
+
+// walkFS calls fn for each file and directory in fs below dir, in
+// lexical order. Paths passed to fn are relative to the root of fs.
+func walkFS(fs arvados.FileSystem, dir string, fn func(path string, fi os.FileInfo) error) error {
+	name := dir
+	if name == "" {
+		name = "."
+	}
+	d, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	ents, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+	for _, fi := range ents {
+		p := path.Join(dir, fi.Name())
+		err = fn(p, fi)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = walkFS(fs, p, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// progressReporter prints the number of bytes transferred so far on
+// an output stream, at most once per second.
+type progressReporter struct {
+	w       io.Writer
+	total   int64 // negative if unknown
+	enabled bool
+
+	done    int64
+	printed time.Time
+}
+
+func (pr *progressReporter) add(n int64) {
+	pr.done += n
+	if pr.enabled && time.Since(pr.printed) >= time.Second {
+		pr.print()
+	}
+}
+
+func (pr *progressReporter) finish() {
+	if pr.enabled {
+		pr.print()
+		fmt.Fprint(pr.w, "\n")
+	}
+}
+
+func (pr *progressReporter) print() {
+	pr.printed = time.Now()
+	if pr.total > 0 {
+		fmt.Fprintf(pr.w, "\r%d MiB / %d MiB %.1f%%", pr.done>>20, pr.total>>20, float64(pr.done)*100/float64(pr.total))
+	} else {
+		fmt.Fprintf(pr.w, "\r%d MiB", pr.done>>20)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&PutGetSuite{})
+
+// PutGetSuite tests the put and get (download) commands using stub
+// API and Keep servers.
+type PutGetSuite struct {
+	api  *httptest.Server
+	keep *httptest.Server
+
+	mtx         sync.Mutex
+	blocks      map[string][]byte
+	collections map[string]map[string]interface{}
+	putHeaders  []http.Header
+	failCreate  bool
+	corrupt     bool
+
+	env     map[string]string
+	tmpdir  string
+	prevDir string
+}
+
+func (s *PutGetSuite) SetUpTest(c *check.C) {
+	s.blocks = map[string][]byte{}
+	s.collections = map[string]map[string]interface{}{}
+	s.putHeaders = nil
+	s.failCreate = false
+	s.corrupt = false
+	s.api = httptest.NewTLSServer(http.HandlerFunc(s.serveAPI))
+	s.keep = httptest.NewServer(http.HandlerFunc(s.serveKeep))
+	s.env = map[string]string{}
+	for k, v := range map[string]string{
+		"ARVADOS_API_HOST":          strings.TrimPrefix(s.api.URL, "https://"),
+		"ARVADOS_API_TOKEN":         "xyzzy",
+		"ARVADOS_API_HOST_INSECURE": "1",
+		"ARVADOS_KEEP_SERVICES":     s.keep.URL,
+	} {
+		s.env[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	s.tmpdir = c.MkDir()
+	s.prevDir, _ = os.Getwd()
+	os.Chdir(s.tmpdir)
+}
+
+func (s *PutGetSuite) TearDownTest(c *check.C) {
+	os.Chdir(s.prevDir)
+	for k, v := range s.env {
+		os.Setenv(k, v)
+	}
+	s.api.Close()
+	s.keep.Close()
+}
+
+func (s *PutGetSuite) serveAPI(w http.ResponseWriter, req *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch {
+	case req.URL.Path == "/discovery/v1/apis/arvados/v1/rest":
+		json.NewEncoder(w).Encode(map[string]interface{}{"defaultCollectionReplication": 2})
+	case req.Method == "POST" && req.URL.Path == "/arvados/v1/collections":
+		if s.failCreate {
+			http.Error(w, `{"errors":["stub failure"]}`, http.StatusInternalServerError)
+			return
+		}
+		var attrs map[string]interface{}
+		err := json.Unmarshal([]byte(req.FormValue("collection")), &attrs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uuid := fmt.Sprintf("zzzzz-4zz18-%015d", len(s.collections)+1)
+		attrs["uuid"] = uuid
+		attrs["portable_data_hash"] = fmt.Sprintf("%x+%d", md5.Sum([]byte(attrs["manifest_text"].(string))), len(attrs["manifest_text"].(string)))
+		s.collections[uuid] = attrs
+		json.NewEncoder(w).Encode(attrs)
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/arvados/v1/collections/"):
+		coll, ok := s.collections[strings.TrimPrefix(req.URL.Path, "/arvados/v1/collections/")]
+		if !ok {
+			http.Error(w, `{"errors":["not found"]}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(coll)
+	default:
+		http.Error(w, `{"errors":["not implemented"]}`, http.StatusNotFound)
+	}
+}
+
+func (s *PutGetSuite) serveKeep(w http.ResponseWriter, req *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	hash := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "+", 2)[0]
+	switch req.Method {
+	case "PUT":
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.blocks[hash] = data
+		s.putHeaders = append(s.putHeaders, req.Header)
+		w.Header().Set("X-Keep-Replicas-Stored", req.Header.Get("X-Keep-Desired-Replicas"))
+		fmt.Fprintf(w, "%s+%d", hash, len(data))
+	case "GET":
+		data, ok := s.blocks[hash]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if s.corrupt && len(data) > 0 {
+			data = append([]byte{data[0] ^ 0xff}, data[1:]...)
+		}
+		w.Write(data)
+	default:
+		http.Error(w, "not implemented", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *PutGetSuite) writeFiles(c *check.C, dir string, files map[string]string) {
+	for name, content := range files {
+		fnm := filepath.Join(dir, name)
+		c.Assert(os.MkdirAll(filepath.Dir(fnm), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(fnm, []byte(content), 0644), check.IsNil)
+	}
+}
+
+func (s *PutGetSuite) runPut(c *check.C, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	exited := Put.RunCommand("arvados-client put", args, bytes.NewReader(nil), &stdout, &stderr)
+	return exited, stdout.String(), stderr.String()
+}
+
+func (s *PutGetSuite) runGet(c *check.C, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	exited := Download.RunCommand("arvados-client download", args, bytes.NewReader(nil), &stdout, &stderr)
+	return exited, stdout.String(), stderr.String()
+}
+
+var putGetTestFiles = map[string]string{
+	"foo":         "foo",
+	"dir1/bar":    "bar",
+	"dir1/dir2/z": strings.Repeat("z", 3000000),
+}
+
+func (s *PutGetSuite) TestPutAndGetDirectory(c *check.C) {
+	s.writeFiles(c, "src", putGetTestFiles)
+	exited, stdout, stderr := s.runPut(c, "--no-progress", "--no-resume", "--name", "test collection", "src")
+	c.Check(stderr, check.Equals, "")
+	c.Assert(exited, check.Equals, 0)
+	uuid := strings.TrimSpace(stdout)
+	c.Assert(s.collections[uuid], check.NotNil)
+	c.Check(s.collections[uuid]["name"], check.Equals, "test collection")
+	c.Check(s.collections[uuid]["manifest_text"], check.Matches, `(?ms)\. .* 0:3:foo\n\./dir1 .* 0:3:bar\n\./dir1/dir2 .*`)
+
+	exited, _, stderr = s.runGet(c, "--no-progress", uuid+"/", "dst")
+	c.Check(stderr, check.Equals, "")
+	c.Assert(exited, check.Equals, 0)
+	for name, content := range putGetTestFiles {
+		buf, err := ioutil.ReadFile(filepath.Join("dst", name))
+		c.Check(err, check.IsNil)
+		c.Check(string(buf) == content, check.Equals, true, check.Commentf("%s", name))
+	}
+
+	// Single file to stdout
+	exited, stdout, stderr = s.runGet(c, uuid+"/dir1/bar", "-")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout, check.Equals, "bar")
+
+	// Single file into existing directory
+	exited, _, stderr = s.runGet(c, uuid+"/dir1/bar", "dst/dir1")
+	c.Check(exited, check.Equals, 1)
+	c.Check(stderr, check.Matches, `(?ms).*file exists.*`)
+	exited, _, stderr = s.runGet(c, "--force", uuid+"/foo", "dst/dir1/bar")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	buf, err := ioutil.ReadFile(filepath.Join("dst", "dir1", "bar"))
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+
+	// Existing files are skipped
+	exited, _, stderr = s.runGet(c, "--skip-existing", uuid+"/", "dst")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+}
+
+func (s *PutGetSuite) TestPutMultiplePaths(c *check.C) {
+	s.writeFiles(c, ".", map[string]string{"a/foo": "foo", "b": "bar"})
+	exited, stdout, stderr := s.runPut(c, "--no-progress", "--no-resume", "--stream", "a", "b")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout, check.Equals, ". 37b51d194a7513e45b56f6524f2d51f2+3 0:3:b\n./a acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo\n")
+	c.Check(s.collections, check.HasLen, 0)
+}
+
+func (s *PutGetSuite) TestPutStdin(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	exited := Put.RunCommand("arvados-client put", []string{"--stream", "--filename", "foo.txt", "-"}, strings.NewReader("foo"), &stdout, &stderr)
+	c.Check(stderr.String(), check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Equals, ". acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo.txt\n")
+}
+
+func (s *PutGetSuite) TestPutReplicationAndStorageClasses(c *check.C) {
+	s.writeFiles(c, ".", map[string]string{"foo": "foo"})
+	exited, stdout, stderr := s.runPut(c, "--no-progress", "--no-resume", "--replication", "3", "--storage-classes", "archive, hot", "foo")
+	c.Check(stderr, check.Equals, "")
+	c.Assert(exited, check.Equals, 0)
+	coll := s.collections[strings.TrimSpace(stdout)]
+	c.Assert(coll, check.NotNil)
+	c.Check(coll["replication_desired"], check.Equals, float64(3))
+	c.Check(coll["storage_classes_desired"], check.DeepEquals, []interface{}{"archive", "hot"})
+	c.Assert(s.putHeaders, check.HasLen, 1)
+	c.Check(s.putHeaders[0].Get("X-Keep-Desired-Replicas"), check.Equals, "3")
+	c.Check(s.putHeaders[0].Get("X-Keep-Storage-Classes"), check.Equals, "archive, hot")
+}
+
+func (s *PutGetSuite) TestPutResume(c *check.C) {
+	s.writeFiles(c, "src", putGetTestFiles)
+	cacheDir := c.MkDir()
+
+	s.failCreate = true
+	exited, _, stderr := s.runPut(c, "--no-progress", "--cache-dir", cacheDir, "src")
+	c.Check(exited, check.Equals, 1)
+	c.Check(stderr, check.Matches, `(?ms).*error saving collection.*`)
+	c.Check(len(s.putHeaders) > 0, check.Equals, true)
+	ents, err := ioutil.ReadDir(cacheDir)
+	c.Check(err, check.IsNil)
+	c.Check(ents, check.HasLen, 1)
+
+	// Second attempt should not need to write any data.
+	s.failCreate = false
+	s.putHeaders = nil
+	exited, stdout, stderr := s.runPut(c, "--no-progress", "--cache-dir", cacheDir, "src")
+	c.Check(stderr, check.Equals, "")
+	c.Assert(exited, check.Equals, 0)
+	c.Check(s.putHeaders, check.HasLen, 0)
+	coll := s.collections[strings.TrimSpace(stdout)]
+	c.Assert(coll, check.NotNil)
+	c.Check(coll["manifest_text"], check.Matches, `(?ms)\. .* 0:3:foo\n\./dir1 .* 0:3:bar\n\./dir1/dir2 .*`)
+
+	// State is removed after a successful upload.
+	ents, err = ioutil.ReadDir(cacheDir)
+	c.Check(err, check.IsNil)
+	c.Check(ents, check.HasLen, 0)
+}
+
+func (s *PutGetSuite) TestPutResumeModifiedFile(c *check.C) {
+	s.writeFiles(c, "src", map[string]string{"foo": "foo", "bar": "bar"})
+	cacheDir := c.MkDir()
+	s.failCreate = true
+	exited, _, _ := s.runPut(c, "--no-progress", "--cache-dir", cacheDir, "src")
+	c.Check(exited, check.Equals, 1)
+
+	s.writeFiles(c, "src", map[string]string{"foo": "foobar"})
+	s.failCreate = false
+	s.putHeaders = nil
+	exited, stdout, stderr := s.runPut(c, "--no-progress", "--cache-dir", cacheDir, "src")
+	c.Check(stderr, check.Equals, "")
+	c.Assert(exited, check.Equals, 0)
+	c.Check(s.putHeaders, check.HasLen, 1)
+	coll := s.collections[strings.TrimSpace(stdout)]
+	c.Assert(coll, check.NotNil)
+	fs, err := (&arvados.Collection{ManifestText: coll["manifest_text"].(string)}).FileSystem(nil, nil)
+	c.Assert(err, check.IsNil)
+	fi, err := fs.Stat("foo")
+	c.Check(err, check.IsNil)
+	c.Check(fi.Size(), check.Equals, int64(6))
+}
+
+func (s *PutGetSuite) TestGetBadChecksum(c *check.C) {
+	s.writeFiles(c, ".", map[string]string{"foo": "foo"})
+	exited, stdout, _ := s.runPut(c, "--no-progress", "--no-resume", "foo")
+	c.Assert(exited, check.Equals, 0)
+	s.corrupt = true
+	exited, _, stderr := s.runGet(c, "--no-progress", strings.TrimSpace(stdout)+"/foo", "dst")
+	c.Check(exited, check.Equals, 1)
+	c.Check(stderr, check.Matches, `(?ms).*checksum.*`)
+	_, err := os.Stat("dst")
+	c.Check(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *PutGetSuite) TestEarliestSignatureExpiry(c *check.C) {
+	c.Check(earliestSignatureExpiry(". acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo\n").IsZero(), check.Equals, true)
+	exp := earliestSignatureExpiry(". acbd18db4cc2f85cedef654fccc4a4d8+3+Aabcdef0123456789@5f5e1000 37b51d194a7513e45b56f6524f2d51f2+3+Aabcdef0123456789@5f5e0fff 0:6:foo\n")
+	c.Check(exp.Unix(), check.Equals, int64(0x5f5e0fff))
+}
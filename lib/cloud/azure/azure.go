@@ -163,6 +163,16 @@ func (cl *disksClientImpl) delete(ctx context.Context, resourceGroupName string,
 
 var quotaRe = regexp.MustCompile(`(?i:exceed|quota|limit)`)
 
+// Azure error codes indicating a lack of capacity. The value is true
+// if the condition applies only to the requested VM size.
+var capacityErrorCodes = map[string]bool{
+	"AllocationFailed":                      true,
+	"OverconstrainedAllocationRequest":      true,
+	"OverconstrainedZonalAllocationRequest": true,
+	"SkuNotAvailable":                       true,
+	"ZonalAllocationFailed":                 true,
+}
+
 type azureRateLimitError struct {
 	azure.RequestError
 	firstRetry time.Time
@@ -180,6 +190,19 @@ func (ar *azureQuotaError) IsQuotaError() bool {
 	return true
 }
 
+type azureCapacityError struct {
+	azure.RequestError
+	instanceTypeSpecific bool
+}
+
+func (ar *azureCapacityError) IsCapacityError() bool {
+	return true
+}
+
+func (ar *azureCapacityError) IsInstanceTypeSpecific() bool {
+	return ar.instanceTypeSpecific
+}
+
 func wrapAzureError(err error) error {
 	de, ok := err.(autorest.DetailedError)
 	if !ok {
@@ -212,6 +235,9 @@ func wrapAzureError(err error) error {
 	if rq.ServiceError == nil {
 		return err
 	}
+	if specific, ok := capacityErrorCodes[rq.ServiceError.Code]; ok {
+		return &azureCapacityError{*rq, specific}
+	}
 	if quotaRe.FindString(rq.ServiceError.Code) != "" || quotaRe.FindString(rq.ServiceError.Message) != "" {
 		return &azureQuotaError{*rq}
 	}
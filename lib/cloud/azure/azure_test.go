@@ -284,6 +284,26 @@ func (*AzureInstanceSetSuite) TestWrapError(c *check.C) {
 	wrapped = wrapAzureError(quotaError)
 	_, ok = wrapped.(cloud.QuotaError)
 	c.Check(ok, check.Equals, true)
+
+	capacityError := autorest.DetailedError{
+		Original: &azure.RequestError{
+			DetailedError: autorest.DetailedError{
+				Response: &http.Response{
+					StatusCode: 409,
+				},
+			},
+			ServiceError: &azure.ServiceError{
+				Code:    "SkuNotAvailable",
+				Message: "The requested VM size is currently not available in this location. Please try another size or deploy to a different location or zones.",
+			},
+		},
+	}
+	wrapped = wrapAzureError(capacityError)
+	caperr, ok := wrapped.(cloud.CapacityError)
+	c.Check(ok, check.Equals, true)
+	c.Check(caperr.IsInstanceTypeSpecific(), check.Equals, true)
+	_, ok = wrapped.(cloud.QuotaError)
+	c.Check(ok, check.Equals, false)
 }
 
 func (*AzureInstanceSetSuite) TestSetTags(c *check.C) {
@@ -0,0 +1,363 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+// Package fakekube provides a fake Kubernetes API server for testing
+// the kubernetes cloud driver. It implements just enough of the
+// pods API (create, list, get, merge-patch, delete) for that
+// purpose, with no scheduling, validation, or authorization beyond
+// an optional bearer token.
+package fakekube
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectMeta is the metadata of a Pod.
+type ObjectMeta struct {
+	Name              string            `json:"name,omitempty"`
+	GenerateName      string            `json:"generateName,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	DeletionTimestamp *time.Time        `json:"deletionTimestamp,omitempty"`
+}
+
+// PodStatus is the status of a Pod.
+type PodStatus struct {
+	Phase string `json:"phase,omitempty"`
+	PodIP string `json:"podIP,omitempty"`
+}
+
+// A Pod is a pod stored by the fake server. The Spec is stored as
+// provided by the client.
+type Pod struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   ObjectMeta      `json:"metadata"`
+	Spec       json.RawMessage `json:"spec"`
+	Status     PodStatus       `json:"status"`
+}
+
+// A Status is an API error response.
+type Status struct {
+	Kind              string `json:"kind"`
+	APIVersion        string `json:"apiVersion"`
+	Status            string `json:"status"`
+	Message           string `json:"message"`
+	Reason            string `json:"reason"`
+	Code              int    `json:"code"`
+	RetryAfterSeconds int    `json:"-"`
+}
+
+// Server is a fake Kubernetes API server. Public fields should be
+// set before calling Start, and not changed afterward.
+type Server struct {
+	// If not empty, requests must provide this bearer token.
+	Token string
+
+	// SetupPod, if set, is called when a pod is created, before
+	// it is stored. It can modify the pod (e.g., set
+	// Status.PodIP) or return a non-nil Status to make the
+	// create request fail. If SetupPod is nil, each pod gets a
+	// unique fake IP address and Phase "Running".
+	SetupPod func(*Pod) *Status
+
+	// If true, deleted pods remain visible (with
+	// DeletionTimestamp set) until Purge is called, like pods
+	// in their termination grace period.
+	GracefulDelete bool
+
+	// Maximum number of items to return in a list response when
+	// the client does not specify a smaller limit. Zero means
+	// unlimited.
+	MaxPageSize int
+
+	srv    *httptest.Server
+	mtx    sync.Mutex
+	pods   map[string]*Pod // key is namespace/name
+	serial int
+}
+
+// Start starts the server.
+func (s *Server) Start() {
+	s.pods = map[string]*Pod{}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// URL returns the server's base URL.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Pods returns a copy of all stored pods, sorted by namespace and
+// name.
+func (s *Server) Pods() []Pod {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var pods []Pod
+	for _, key := range s.sortedKeys() {
+		pods = append(pods, s.copyPod(s.pods[key]))
+	}
+	return pods
+}
+
+// Purge removes pods that have been deleted but are still visible
+// because GracefulDelete is true.
+func (s *Server) Purge() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for key, pod := range s.pods {
+		if pod.Metadata.DeletionTimestamp != nil {
+			delete(s.pods, key)
+		}
+	}
+}
+
+// Caller must have lock.
+func (s *Server) sortedKeys() []string {
+	var keys []string
+	for key := range s.pods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Caller must have lock.
+func (s *Server) copyPod(pod *Pod) Pod {
+	cp := *pod
+	cp.Metadata.Labels = copyMap(pod.Metadata.Labels)
+	cp.Metadata.Annotations = copyMap(pod.Metadata.Annotations)
+	cp.Spec = append(json.RawMessage(nil), pod.Spec...)
+	return cp
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.Token != "" && req.Header.Get("Authorization") != "Bearer "+s.Token {
+		s.sendError(w, &Status{Code: http.StatusUnauthorized, Reason: "Unauthorized", Message: "Unauthorized"})
+		return
+	}
+	// /api/v1/namespaces/{ns}/pods[/{name}]
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 5 || len(parts) > 6 || parts[0] != "api" || parts[1] != "v1" || parts[2] != "namespaces" || parts[4] != "pods" {
+		s.sendError(w, &Status{Code: http.StatusNotFound, Reason: "NotFound", Message: "the server could not find the requested resource"})
+		return
+	}
+	ns := parts[3]
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(parts) == 5 {
+		switch req.Method {
+		case "GET":
+			s.listPods(w, req, ns)
+		case "POST":
+			s.createPod(w, req, ns)
+		default:
+			s.sendError(w, &Status{Code: http.StatusMethodNotAllowed, Reason: "MethodNotAllowed", Message: "method not allowed"})
+		}
+		return
+	}
+	key := ns + "/" + parts[5]
+	pod, ok := s.pods[key]
+	if !ok {
+		s.sendError(w, &Status{Code: http.StatusNotFound, Reason: "NotFound", Message: fmt.Sprintf("pods %q not found", parts[5])})
+		return
+	}
+	switch req.Method {
+	case "GET":
+		s.sendJSON(w, http.StatusOK, s.copyPod(pod))
+	case "PATCH":
+		if ct := req.Header.Get("Content-Type"); ct != "application/merge-patch+json" {
+			s.sendError(w, &Status{Code: http.StatusUnsupportedMediaType, Reason: "UnsupportedMediaType", Message: fmt.Sprintf("unsupported patch type %q", ct)})
+			return
+		}
+		var patch struct {
+			Metadata struct {
+				Labels      map[string]*string `json:"labels"`
+				Annotations map[string]*string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+			s.sendError(w, &Status{Code: http.StatusBadRequest, Reason: "BadRequest", Message: err.Error()})
+			return
+		}
+		pod.Metadata.Labels = mergePatch(pod.Metadata.Labels, patch.Metadata.Labels)
+		pod.Metadata.Annotations = mergePatch(pod.Metadata.Annotations, patch.Metadata.Annotations)
+		s.sendJSON(w, http.StatusOK, s.copyPod(pod))
+	case "DELETE":
+		if s.GracefulDelete {
+			if pod.Metadata.DeletionTimestamp == nil {
+				now := time.Now().UTC()
+				pod.Metadata.DeletionTimestamp = &now
+			}
+		} else {
+			delete(s.pods, key)
+		}
+		s.sendJSON(w, http.StatusOK, s.copyPod(pod))
+	default:
+		s.sendError(w, &Status{Code: http.StatusMethodNotAllowed, Reason: "MethodNotAllowed", Message: "method not allowed"})
+	}
+}
+
+// mergePatch applies a JSON merge patch (where a null value means
+// delete) to a string map.
+func mergePatch(m map[string]string, patch map[string]*string) map[string]string {
+	if len(patch) == 0 {
+		return m
+	}
+	if m == nil {
+		m = map[string]string{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(m, k)
+		} else {
+			m[k] = *v
+		}
+	}
+	return m
+}
+
+// Caller must have lock.
+func (s *Server) createPod(w http.ResponseWriter, req *http.Request, ns string) {
+	var pod Pod
+	if err := json.NewDecoder(req.Body).Decode(&pod); err != nil {
+		s.sendError(w, &Status{Code: http.StatusBadRequest, Reason: "BadRequest", Message: err.Error()})
+		return
+	}
+	if pod.Metadata.Namespace != "" && pod.Metadata.Namespace != ns {
+		s.sendError(w, &Status{Code: http.StatusBadRequest, Reason: "BadRequest", Message: "the namespace of the provided object does not match the namespace sent on the request"})
+		return
+	}
+	s.serial++
+	if pod.Metadata.Name == "" {
+		if pod.Metadata.GenerateName == "" {
+			s.sendError(w, &Status{Code: http.StatusUnprocessableEntity, Reason: "Invalid", Message: "name or generateName is required"})
+			return
+		}
+		pod.Metadata.Name = fmt.Sprintf("%s%05x", pod.Metadata.GenerateName, s.serial)
+	}
+	key := ns + "/" + pod.Metadata.Name
+	if _, exists := s.pods[key]; exists {
+		s.sendError(w, &Status{Code: http.StatusConflict, Reason: "AlreadyExists", Message: fmt.Sprintf("pods %q already exists", pod.Metadata.Name)})
+		return
+	}
+	pod.APIVersion, pod.Kind = "v1", "Pod"
+	pod.Metadata.Namespace = ns
+	pod.Metadata.UID = fmt.Sprintf("fakekube-uid-%d", s.serial)
+	pod.Metadata.CreationTimestamp = time.Now().UTC()
+	pod.Status = PodStatus{Phase: "Running", PodIP: fmt.Sprintf("10.0.%d.%d", s.serial/256, s.serial%256)}
+	if s.SetupPod != nil {
+		if st := s.SetupPod(&pod); st != nil {
+			s.sendError(w, st)
+			return
+		}
+	}
+	s.pods[key] = &pod
+	s.sendJSON(w, http.StatusCreated, s.copyPod(&pod))
+}
+
+// Caller must have lock.
+func (s *Server) listPods(w http.ResponseWriter, req *http.Request, ns string) {
+	selector := req.FormValue("labelSelector")
+	limit := s.MaxPageSize
+	if l, err := strconv.Atoi(req.FormValue("limit")); err == nil && l > 0 && (limit == 0 || l < limit) {
+		limit = l
+	}
+	// The continue token is the name of the last pod returned
+	// in the previous page.
+	after := req.FormValue("continue")
+	var resp struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Continue string `json:"continue,omitempty"`
+		} `json:"metadata"`
+		Items []Pod `json:"items"`
+	}
+	resp.APIVersion, resp.Kind = "v1", "PodList"
+	resp.Items = []Pod{}
+	for _, key := range s.sortedKeys() {
+		pod := s.pods[key]
+		if pod.Metadata.Namespace != ns || pod.Metadata.Name <= after || !matchSelector(pod.Metadata.Labels, selector) {
+			continue
+		}
+		if limit > 0 && len(resp.Items) >= limit {
+			resp.Metadata.Continue = resp.Items[len(resp.Items)-1].Metadata.Name
+			break
+		}
+		resp.Items = append(resp.Items, s.copyPod(pod))
+	}
+	s.sendJSON(w, http.StatusOK, resp)
+}
+
+// matchSelector returns true if the given labels match a label
+// selector. Only the "key", "!key", "key=value", "key==value", and
+// "key!=value" forms are supported.
+func matchSelector(labels map[string]string, selector string) bool {
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if i := strings.Index(term, "!="); i >= 0 {
+			if v, ok := labels[term[:i]]; ok && v == term[i+2:] {
+				return false
+			}
+		} else if i := strings.Index(term, "="); i >= 0 {
+			if labels[term[:i]] != strings.TrimPrefix(term[i+1:], "=") {
+				return false
+			} else if _, ok := labels[term[:i]]; !ok {
+				return false
+			}
+		} else if strings.HasPrefix(term, "!") {
+			if _, ok := labels[term[1:]]; ok {
+				return false
+			}
+		} else if _, ok := labels[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) sendError(w http.ResponseWriter, st *Status) {
+	st.Kind, st.APIVersion, st.Status = "Status", "v1", "Failure"
+	if st.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfterSeconds))
+	}
+	s.sendJSON(w, st.Code, st)
+}
+
+func (s *Server) sendJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -6,10 +6,15 @@ package cloudtest
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"testing"
 	"time"
 
 	"git.arvados.org/arvados.git/lib/cloud"
+	"git.arvados.org/arvados.git/lib/cloud/cloudtest/fakekube"
+	"git.arvados.org/arvados.git/lib/cloud/kubernetes"
 	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
@@ -95,3 +100,52 @@ func (s *TesterSuite) TestShellCommandFail(c *check.C) {
 	c.Check(ok, check.Equals, false)
 	c.Check(s.log.String(), check.Matches, `(?ms).*\\"falsey\\": command not found.*`)
 }
+
+func (s *TesterSuite) TestKubernetes(c *check.C) {
+	pubkey, _ := test.LoadTestKey(c, "../../dispatchcloud/test/sshkey_dispatch")
+	_, privhostkey := test.LoadTestKey(c, "../../dispatchcloud/test/sshkey_vm")
+
+	kube := &fakekube.Server{}
+	// Each pod's "sshd" is an SSHService that reads the instance
+	// secret from the pod's tags annotation, since there is no
+	// real init command running in the fake pod.
+	sshService := &test.SSHService{
+		HostKey:        privhostkey,
+		AuthorizedUser: "root",
+		AuthorizedKeys: []ssh.PublicKey{pubkey},
+		Exec: func(env map[string]string, command string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			switch command {
+			case "cat /var/run/arvados-instance-secret":
+				pods := kube.Pods()
+				if len(pods) != 1 {
+					fmt.Fprintf(stderr, "expected 1 pod, found %d\n", len(pods))
+					return 1
+				}
+				var tags map[string]string
+				json.Unmarshal([]byte(pods[0].Metadata.Annotations["arvados.org/tags"]), &tags)
+				fmt.Fprint(stdout, tags[s.tester.TagKeyPrefix+"InstanceSecret"])
+				return 0
+			case "crunch-run --list", "true":
+				return 0
+			default:
+				fmt.Fprintf(stderr, "%q: command not found\n", command)
+				return 127
+			}
+		},
+	}
+	c.Assert(sshService.Start(), check.IsNil)
+	defer sshService.Close()
+	kube.SetupPod = func(pod *fakekube.Pod) *fakekube.Status {
+		pod.Status.PodIP = sshService.Address()
+		return nil
+	}
+	kube.Start()
+	defer kube.Close()
+
+	s.tester.Logger = ctxlog.TestLogger(c)
+	s.tester.Driver = kubernetes.Driver
+	s.tester.DriverParameters = json.RawMessage(`{"APIServer":"` + kube.URL() + `","Namespace":"test"}`)
+	ok := s.tester.Run()
+	c.Check(ok, check.Equals, true)
+	c.Check(kube.Pods(), check.HasLen, 0)
+}
@@ -18,6 +18,7 @@ import (
 	"git.arvados.org/arvados.git/lib/cloud"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -213,7 +214,7 @@ func (instanceSet *ec2InstanceSet) Create(
 	rsv, err := instanceSet.client.RunInstances(&rii)
 
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
 	return &ec2Instance{
@@ -320,3 +321,55 @@ func (inst *ec2Instance) RemoteUser() string {
 func (inst *ec2Instance) VerifyHostKey(ssh.PublicKey, *ssh.Client) error {
 	return cloud.ErrNotImplemented
 }
+
+// EC2 error codes indicating the account has reached a limit on
+// instances or VCPUs.
+var ec2QuotaErrorCodes = map[string]bool{
+	"InstanceLimitExceeded":        true,
+	"MaxSpotInstanceCountExceeded": true,
+	"VcpuLimitExceeded":            true,
+}
+
+// EC2 error codes indicating a lack of capacity. The value is true
+// if the condition applies only to the requested instance type.
+var ec2CapacityErrorCodes = map[string]bool{
+	"InsufficientInstanceCapacity":      true,
+	"InsufficientFreeAddressesInSubnet": false,
+	"SpotMaxPriceTooLow":                true,
+	"Unsupported":                       true,
+}
+
+type ec2QuotaError struct {
+	error
+}
+
+func (err ec2QuotaError) IsQuotaError() bool {
+	return true
+}
+
+type ec2CapacityError struct {
+	error
+	instanceTypeSpecific bool
+}
+
+func (err ec2CapacityError) IsCapacityError() bool {
+	return true
+}
+
+func (err ec2CapacityError) IsInstanceTypeSpecific() bool {
+	return err.instanceTypeSpecific
+}
+
+func wrapError(err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+	if ec2QuotaErrorCodes[aerr.Code()] {
+		return ec2QuotaError{err}
+	}
+	if specific, ok := ec2CapacityErrorCodes[aerr.Code()]; ok {
+		return ec2CapacityError{err, specific}
+	}
+	return err
+}
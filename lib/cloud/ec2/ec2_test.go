@@ -32,6 +32,7 @@ import (
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/config"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/sirupsen/logrus"
 	check "gopkg.in/check.v1"
@@ -245,3 +246,30 @@ func (*EC2InstanceSetSuite) TestDestroyInstances(c *check.C) {
 		c.Check(i.Destroy(), check.IsNil)
 	}
 }
+
+func (*EC2InstanceSetSuite) TestWrapError(c *check.C) {
+	for _, trial := range []struct {
+		code     string
+		quota    bool
+		capacity bool
+		specific bool
+	}{
+		{"InstanceLimitExceeded", true, false, false},
+		{"VcpuLimitExceeded", true, false, false},
+		{"InsufficientInstanceCapacity", false, true, true},
+		{"InsufficientFreeAddressesInSubnet", false, true, false},
+		{"InvalidParameterValue", false, false, false},
+	} {
+		c.Logf("trial: %+v", trial)
+		err := wrapError(awserr.New(trial.code, "test error", nil))
+		_, isQuota := err.(cloud.QuotaError)
+		c.Check(isQuota, check.Equals, trial.quota)
+		caperr, isCapacity := err.(cloud.CapacityError)
+		c.Check(isCapacity, check.Equals, trial.capacity)
+		if isCapacity {
+			c.Check(caperr.IsCapacityError(), check.Equals, true)
+			c.Check(caperr.IsInstanceTypeSpecific(), check.Equals, trial.specific)
+		}
+		c.Check(err, check.ErrorMatches, trial.code+`: test error`)
+	}
+}
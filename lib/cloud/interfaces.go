@@ -36,6 +36,22 @@ type QuotaError interface {
 	error
 }
 
+// A CapacityError should be returned by an InstanceSet when the cloud
+// service indicates it does not have enough capacity to create new
+// VMs right now (e.g., AWS "InsufficientInstanceCapacity"). Unlike a
+// QuotaError, the condition is expected to clear up by itself after
+// some time.
+type CapacityError interface {
+	// If true, wait before trying to create more instances. If
+	// false, don't handle the error as a capacity error.
+	IsCapacityError() bool
+	// If true, the condition applies only to the requested
+	// instance type, and creating instances of other types
+	// might still succeed.
+	IsInstanceTypeSpecific() bool
+	error
+}
+
 type SharedResourceTags map[string]string
 type InstanceSetID string
 type InstanceTags map[string]string
@@ -122,7 +138,7 @@ type InstanceSet interface {
 	// ErrNotImplemented. InitCommand will be under 1 KiB.
 	//
 	// The returned error should implement RateLimitError and
-	// QuotaError where applicable.
+	// QuotaError or CapacityError where applicable.
 	Create(arvados.InstanceType, ImageID, InstanceTags, InitCommand, ssh.PublicKey) (Instance, error)
 
 	// Return all instances, including ones that are booting or
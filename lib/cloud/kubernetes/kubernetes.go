@@ -0,0 +1,584 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+// Package kubernetes implements a cloud driver that runs each worker
+// "instance" as a pod in a Kubernetes cluster.
+//
+// Each pod runs a single container using the configured image
+// (CloudVMs.ImageID), which must provide an SSH server at
+// /usr/sbin/sshd and whatever else a worker VM would need to run
+// crunch-run (docker, arv-mount, etc.). The dispatcher connects to
+// the pod's IP address using SSH, exactly as it would connect to a
+// cloud VM.
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cloud"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Driver is the kubernetes implementation of the cloud.Driver
+// interface.
+var Driver = cloud.DriverFunc(newKubernetesInstanceSet)
+
+const (
+	// Label applied to every pod created by this driver, whose
+	// value is the InstanceSetID.
+	labelInstanceSetID = "arvados.org/instance-set-id"
+	// Annotation holding the pod's tags, JSON-encoded. Tags are
+	// not stored as labels because Kubernetes restricts label
+	// keys and values to a small character set.
+	annotationTags = "arvados.org/tags"
+	// Annotation holding the instance type's ProviderType.
+	annotationProviderType = "arvados.org/provider-type"
+
+	// Name of the worker container in each pod.
+	workerContainerName = "arvados-worker"
+
+	// Defaults used when running inside the Kubernetes cluster.
+	inClusterAPIServer = "https://kubernetes.default.svc"
+	serviceAccountDir  = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+type kubernetesInstanceSetConfig struct {
+	// Kubernetes API server URL. Default is the in-cluster
+	// service address.
+	APIServer string
+	// Bearer token, or a file to read it from. Default is the
+	// pod's service account token, if running in a pod.
+	Token     string
+	TokenFile string
+	// PEM-encoded CA certificate(s) to trust when connecting to
+	// APIServer. Default is the pod's service account CA, if
+	// running in a pod, otherwise the system CAs.
+	CACertFile string
+	// Skip TLS certificate verification.
+	Insecure bool
+	// Namespace to create pods in. Default is the pod's own
+	// namespace, if running in a pod, otherwise "default".
+	Namespace string
+
+	// Username to use when connecting to the pod via SSH.
+	// Default is "root".
+	AdminUsername string
+	// Run the worker container in privileged mode (usually
+	// needed to run docker inside the pod).
+	Privileged bool
+	// Service account to use for worker pods.
+	ServiceAccountName string
+	// Names of secrets to use when pulling the worker image.
+	ImagePullSecrets []string
+
+	// Node selectors and tolerations to add to all worker pods.
+	NodeSelector map[string]string
+	Tolerations  []toleration
+	// Additional node selectors and tolerations for pods that
+	// run preemptible instance types.
+	PreemptibleNodeSelector map[string]string
+	PreemptibleTolerations  []toleration
+	// Additional pod settings for specific instance types, keyed
+	// by ProviderType.
+	InstanceTypes map[string]podTypeConfig
+}
+
+type podTypeConfig struct {
+	NodeSelector map[string]string
+	Tolerations  []toleration
+	// Additional resource requests/limits, like
+	// {"nvidia.com/gpu": "1"}.
+	Resources map[string]string
+}
+
+// Subset of the Kubernetes API objects used by this driver.
+
+type objectMeta struct {
+	Name              string            `json:"name,omitempty"`
+	GenerateName      string            `json:"generateName,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	DeletionTimestamp *time.Time        `json:"deletionTimestamp,omitempty"`
+}
+
+type pod struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       podSpec    `json:"spec"`
+	Status     podStatus  `json:"status"`
+}
+
+type podList struct {
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []pod `json:"items"`
+}
+
+type podSpec struct {
+	Containers         []podContainer         `json:"containers"`
+	RestartPolicy      string                 `json:"restartPolicy,omitempty"`
+	NodeSelector       map[string]string      `json:"nodeSelector,omitempty"`
+	Tolerations        []toleration           `json:"tolerations,omitempty"`
+	ServiceAccountName string                 `json:"serviceAccountName,omitempty"`
+	ImagePullSecrets   []localObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+type podContainer struct {
+	Name            string               `json:"name"`
+	Image           string               `json:"image"`
+	Command         []string             `json:"command,omitempty"`
+	Env             []envVar             `json:"env,omitempty"`
+	Resources       resourceRequirements `json:"resources"`
+	SecurityContext *securityContext     `json:"securityContext,omitempty"`
+}
+
+type envVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type resourceRequirements struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type securityContext struct {
+	Privileged bool `json:"privileged"`
+}
+
+type toleration struct {
+	Key               string `json:"key,omitempty"`
+	Operator          string `json:"operator,omitempty"`
+	Value             string `json:"value,omitempty"`
+	Effect            string `json:"effect,omitempty"`
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty"`
+}
+
+type localObjectReference struct {
+	Name string `json:"name"`
+}
+
+type podStatus struct {
+	Phase string `json:"phase,omitempty"`
+	PodIP string `json:"podIP,omitempty"`
+}
+
+// status is a Kubernetes API error response.
+type status struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Details struct {
+		RetryAfterSeconds int `json:"retryAfterSeconds"`
+	} `json:"details"`
+}
+
+func (st *status) Error() string {
+	return fmt.Sprintf("kubernetes API error: %d %s: %s", st.Code, st.Reason, st.Message)
+}
+
+type kubernetesInstanceSet struct {
+	config        kubernetesInstanceSetConfig
+	instanceSetID cloud.InstanceSetID
+	logger        logrus.FieldLogger
+	client        *http.Client
+	apiURL        *url.URL
+}
+
+func newKubernetesInstanceSet(config json.RawMessage, instanceSetID cloud.InstanceSetID, _ cloud.SharedResourceTags, logger logrus.FieldLogger) (cloud.InstanceSet, error) {
+	is := &kubernetesInstanceSet{
+		instanceSetID: instanceSetID,
+		logger:        logger,
+	}
+	err := json.Unmarshal(config, &is.config)
+	if err != nil {
+		return nil, err
+	}
+	inCluster := false
+	if _, err := os.Stat(serviceAccountDir); err == nil {
+		inCluster = true
+	}
+	if is.config.APIServer == "" {
+		is.config.APIServer = inClusterAPIServer
+	}
+	is.apiURL, err = url.Parse(is.config.APIServer)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing APIServer URL: %s", err)
+	}
+	if inCluster && is.config.Token == "" && is.config.TokenFile == "" {
+		is.config.TokenFile = serviceAccountDir + "/token"
+	}
+	if inCluster && is.config.CACertFile == "" {
+		is.config.CACertFile = serviceAccountDir + "/ca.crt"
+	}
+	if is.config.Namespace == "" {
+		is.config.Namespace = "default"
+		if buf, err := ioutil.ReadFile(serviceAccountDir + "/namespace"); err == nil && len(buf) > 0 {
+			is.config.Namespace = strings.TrimSpace(string(buf))
+		}
+	}
+	if is.config.AdminUsername == "" {
+		is.config.AdminUsername = "root"
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: is.config.Insecure}
+	if is.config.CACertFile != "" {
+		pem, err := ioutil.ReadFile(is.config.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", is.config.CACertFile)
+		}
+	}
+	is.client = &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return is, nil
+}
+
+// Make a request to the Kubernetes API. If dst is not nil, decode
+// the response body into it.
+func (is *kubernetesInstanceSet) do(method, path string, query url.Values, contentType string, body interface{}, dst interface{}) error {
+	u := *is.apiURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, u.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token := is.config.Token
+	if token == "" && is.config.TokenFile != "" {
+		// Read the file every time, in case the token has
+		// been rotated.
+		buf, err := ioutil.ReadFile(is.config.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(buf))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := is.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		st := &status{}
+		buf, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if json.Unmarshal(buf, st) != nil || st.Message == "" {
+			st.Message = strings.TrimSpace(string(buf))
+		}
+		st.Code = resp.StatusCode
+		if st.Details.RetryAfterSeconds == 0 {
+			st.Details.RetryAfterSeconds, _ = strconv.Atoi(resp.Header.Get("Retry-After"))
+		}
+		return wrapError(st)
+	}
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func (is *kubernetesInstanceSet) podsPath() string {
+	return "/api/v1/namespaces/" + url.PathEscape(is.config.Namespace) + "/pods"
+}
+
+func (is *kubernetesInstanceSet) Create(
+	instanceType arvados.InstanceType,
+	imageID cloud.ImageID,
+	newTags cloud.InstanceTags,
+	initCommand cloud.InitCommand,
+	publicKey ssh.PublicKey) (cloud.Instance, error) {
+
+	tagsJSON, err := json.Marshal(newTags)
+	if err != nil {
+		return nil, err
+	}
+	p := pod{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Metadata: objectMeta{
+			GenerateName: "arvados-worker-",
+			Namespace:    is.config.Namespace,
+			Labels:       map[string]string{labelInstanceSetID: labelValue(string(is.instanceSetID))},
+			Annotations: map[string]string{
+				annotationTags:         string(tagsJSON),
+				annotationProviderType: instanceType.ProviderType,
+			},
+		},
+		Spec: is.podSpec(instanceType, imageID, initCommand, publicKey),
+	}
+	var created pod
+	err = is.do("POST", is.podsPath(), nil, "application/json", p, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &kubernetesInstance{is: is, pod: created}, nil
+}
+
+func (is *kubernetesInstanceSet) podSpec(it arvados.InstanceType, imageID cloud.ImageID, initCommand cloud.InitCommand, publicKey ssh.PublicKey) podSpec {
+	resources := map[string]string{
+		"cpu":    strconv.Itoa(it.VCPUs),
+		"memory": strconv.FormatInt(int64(it.RAM), 10),
+	}
+	if it.Scratch > 0 {
+		resources["ephemeral-storage"] = strconv.FormatInt(int64(it.Scratch), 10)
+	}
+	nodeSelector := map[string]string{}
+	var tolerations []toleration
+	addSelectors := func(sel map[string]string, tol []toleration) {
+		for k, v := range sel {
+			nodeSelector[k] = v
+		}
+		tolerations = append(tolerations, tol...)
+	}
+	addSelectors(is.config.NodeSelector, is.config.Tolerations)
+	if it.Preemptible {
+		addSelectors(is.config.PreemptibleNodeSelector, is.config.PreemptibleTolerations)
+	}
+	if ptc, ok := is.config.InstanceTypes[it.ProviderType]; ok {
+		addSelectors(ptc.NodeSelector, ptc.Tolerations)
+		for k, v := range ptc.Resources {
+			resources[k] = v
+		}
+	}
+	if len(nodeSelector) == 0 {
+		nodeSelector = nil
+	}
+	var pullSecrets []localObjectReference
+	for _, name := range is.config.ImagePullSecrets {
+		pullSecrets = append(pullSecrets, localObjectReference{Name: name})
+	}
+	var secctx *securityContext
+	if is.config.Privileged {
+		secctx = &securityContext{Privileged: true}
+	}
+	return podSpec{
+		Containers: []podContainer{{
+			Name:    workerContainerName,
+			Image:   string(imageID),
+			Command: []string{"/bin/sh", "-c", startupScript(initCommand)},
+			Env: []envVar{
+				{Name: "ARVADOS_ADMIN_USER", Value: is.config.AdminUsername},
+				{Name: "ARVADOS_AUTHORIZED_KEY", Value: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))},
+			},
+			Resources: resourceRequirements{
+				Requests: resources,
+				Limits:   resources,
+			},
+			SecurityContext: secctx,
+		}},
+		// If the worker container exits, the dispatcher will
+		// notice it's unresponsive and destroy the pod.
+		RestartPolicy:      "Never",
+		NodeSelector:       nodeSelector,
+		Tolerations:        tolerations,
+		ServiceAccountName: is.config.ServiceAccountName,
+		ImagePullSecrets:   pullSecrets,
+	}
+}
+
+// startupScript returns a shell script that installs the
+// dispatcher's public key, runs the given init command, and starts
+// an SSH server.
+func startupScript(initCommand cloud.InitCommand) string {
+	return `set -e
+home=$(eval echo "~$ARVADOS_ADMIN_USER")
+mkdir -p "$home/.ssh"
+echo "$ARVADOS_AUTHORIZED_KEY" >>"$home/.ssh/authorized_keys"
+chown -R "$ARVADOS_ADMIN_USER" "$home/.ssh"
+chmod 700 "$home/.ssh"
+` + string(initCommand) + `
+mkdir -p /run/sshd
+exec /usr/sbin/sshd -D -e
+`
+}
+
+func (is *kubernetesInstanceSet) Instances(tags cloud.InstanceTags) ([]cloud.Instance, error) {
+	var instances []cloud.Instance
+	query := url.Values{
+		"labelSelector": {labelInstanceSetID + "=" + labelValue(string(is.instanceSetID))},
+		"limit":         {"500"},
+	}
+	for {
+		var list podList
+		err := is.do("GET", is.podsPath(), query, "", nil, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list.Items {
+			if p.Metadata.DeletionTimestamp != nil {
+				// Already shutting down.
+				continue
+			}
+			instances = append(instances, &kubernetesInstance{is: is, pod: p})
+		}
+		if list.Metadata.Continue == "" {
+			return instances, nil
+		}
+		query.Set("continue", list.Metadata.Continue)
+	}
+}
+
+func (is *kubernetesInstanceSet) Stop() {
+}
+
+type kubernetesInstance struct {
+	is  *kubernetesInstanceSet
+	pod pod
+	mtx sync.Mutex // protects pod.Metadata.Annotations
+}
+
+func (inst *kubernetesInstance) ID() cloud.InstanceID {
+	return cloud.InstanceID(inst.pod.Metadata.Name)
+}
+
+func (inst *kubernetesInstance) String() string {
+	return inst.pod.Metadata.Name
+}
+
+func (inst *kubernetesInstance) ProviderType() string {
+	inst.mtx.Lock()
+	defer inst.mtx.Unlock()
+	return inst.pod.Metadata.Annotations[annotationProviderType]
+}
+
+func (inst *kubernetesInstance) SetTags(newTags cloud.InstanceTags) error {
+	tagsJSON, err := json.Marshal(newTags)
+	if err != nil {
+		return err
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationTags: string(tagsJSON),
+			},
+		},
+	}
+	err = inst.is.do("PATCH", inst.is.podsPath()+"/"+url.PathEscape(inst.pod.Metadata.Name), nil, "application/merge-patch+json", patch, nil)
+	if err != nil {
+		return err
+	}
+	inst.mtx.Lock()
+	defer inst.mtx.Unlock()
+	annotations := map[string]string{}
+	for k, v := range inst.pod.Metadata.Annotations {
+		annotations[k] = v
+	}
+	annotations[annotationTags] = string(tagsJSON)
+	inst.pod.Metadata.Annotations = annotations
+	return nil
+}
+
+func (inst *kubernetesInstance) Tags() cloud.InstanceTags {
+	inst.mtx.Lock()
+	tagsJSON := inst.pod.Metadata.Annotations[annotationTags]
+	inst.mtx.Unlock()
+	tags := cloud.InstanceTags{}
+	if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+		inst.is.logger.WithField("Instance", inst.String()).WithError(err).Warn("error decoding tags annotation")
+	}
+	return tags
+}
+
+func (inst *kubernetesInstance) Destroy() error {
+	err := inst.is.do("DELETE", inst.is.podsPath()+"/"+url.PathEscape(inst.pod.Metadata.Name), nil, "", nil, nil)
+	if st, ok := err.(*status); ok && st.Code == http.StatusNotFound {
+		// Already gone.
+		return nil
+	}
+	return err
+}
+
+func (inst *kubernetesInstance) Address() string {
+	return inst.pod.Status.PodIP
+}
+
+func (inst *kubernetesInstance) RemoteUser() string {
+	return inst.is.config.AdminUsername
+}
+
+func (inst *kubernetesInstance) VerifyHostKey(ssh.PublicKey, *ssh.Client) error {
+	return cloud.ErrNotImplemented
+}
+
+var labelValueInvalidChars = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// labelValue returns a valid Kubernetes label value derived from s.
+func labelValue(s string) string {
+	s = labelValueInvalidChars.ReplaceAllString(s, "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "-_.")
+}
+
+type kubernetesRateLimitError struct {
+	*status
+	earliestRetry time.Time
+}
+
+func (err kubernetesRateLimitError) EarliestRetry() time.Time {
+	return err.earliestRetry
+}
+
+type kubernetesQuotaError struct {
+	*status
+}
+
+func (err kubernetesQuotaError) IsQuotaError() bool {
+	return true
+}
+
+func wrapError(st *status) error {
+	switch {
+	case st.Code == http.StatusTooManyRequests:
+		retry := time.Duration(st.Details.RetryAfterSeconds) * time.Second
+		if retry <= 0 {
+			retry = time.Second
+		}
+		return kubernetesRateLimitError{st, time.Now().Add(retry)}
+	case st.Code == http.StatusForbidden && strings.Contains(st.Message, "exceeded quota"):
+		// Namespace ResourceQuota reached.
+		return kubernetesQuotaError{st}
+	default:
+		return st
+	}
+}
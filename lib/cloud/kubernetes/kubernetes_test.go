@@ -0,0 +1,237 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cloud"
+	"git.arvados.org/arvados.git/lib/cloud/cloudtest/fakekube"
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"golang.org/x/crypto/ssh"
+	check "gopkg.in/check.v1"
+)
+
+// Gocheck boilerplate
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+var _ = check.Suite(&KubernetesInstanceSetSuite{})
+
+type KubernetesInstanceSetSuite struct {
+	server *fakekube.Server
+	pubkey ssh.PublicKey
+}
+
+func (s *KubernetesInstanceSetSuite) SetUpTest(c *check.C) {
+	s.server = &fakekube.Server{Token: "test-token"}
+	s.server.Start()
+	s.pubkey, _ = test.LoadTestKey(c, "../../dispatchcloud/test/sshkey_dispatch")
+}
+
+func (s *KubernetesInstanceSetSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *KubernetesInstanceSetSuite) instanceSet(c *check.C, id cloud.InstanceSetID, extraConfig string) cloud.InstanceSet {
+	config := `{"APIServer":"` + s.server.URL() + `","Token":"test-token","Namespace":"testns"` + extraConfig + `}`
+	is, err := Driver.InstanceSet(json.RawMessage(config), id, nil, ctxlog.TestLogger(c))
+	c.Assert(err, check.IsNil)
+	return is
+}
+
+func (s *KubernetesInstanceSetSuite) TestCreate(c *check.C) {
+	is := s.instanceSet(c, "test-instance-set", `,
+		"Privileged": true,
+		"ServiceAccountName": "arvados-worker",
+		"ImagePullSecrets": ["regcred"],
+		"NodeSelector": {"pool": "arvados"},
+		"Tolerations": [{"key": "dedicated", "operator": "Equal", "value": "arvados", "effect": "NoSchedule"}],
+		"PreemptibleNodeSelector": {"spot": "true"},
+		"PreemptibleTolerations": [{"key": "spot", "operator": "Exists"}],
+		"InstanceTypes": {"gpu.large": {"NodeSelector": {"gpu": "yes"}, "Resources": {"nvidia.com/gpu": "1"}}}`)
+
+	it := arvados.InstanceType{
+		Name:         "gpu",
+		ProviderType: "gpu.large",
+		VCPUs:        4,
+		RAM:          8 << 30,
+		Scratch:      100 << 30,
+		Preemptible:  true,
+	}
+	inst, err := is.Create(it, "example/arvados-worker:1.2", cloud.InstanceTags{"foo": "bar baz"}, "echo init", s.pubkey)
+	c.Assert(err, check.IsNil)
+	c.Check(inst.ProviderType(), check.Equals, "gpu.large")
+	c.Check(inst.Tags(), check.DeepEquals, cloud.InstanceTags{"foo": "bar baz"})
+	c.Check(inst.Address(), check.Not(check.Equals), "")
+	c.Check(inst.RemoteUser(), check.Equals, "root")
+
+	pods := s.server.Pods()
+	c.Assert(pods, check.HasLen, 1)
+	c.Check(string(inst.ID()), check.Equals, pods[0].Metadata.Name)
+	c.Check(pods[0].Metadata.Namespace, check.Equals, "testns")
+	c.Check(pods[0].Metadata.Labels, check.DeepEquals, map[string]string{labelInstanceSetID: "test-instance-set"})
+
+	var spec podSpec
+	c.Assert(json.Unmarshal(pods[0].Spec, &spec), check.IsNil)
+	c.Check(spec.RestartPolicy, check.Equals, "Never")
+	c.Check(spec.ServiceAccountName, check.Equals, "arvados-worker")
+	c.Check(spec.ImagePullSecrets, check.DeepEquals, []localObjectReference{{Name: "regcred"}})
+	c.Check(spec.NodeSelector, check.DeepEquals, map[string]string{"pool": "arvados", "spot": "true", "gpu": "yes"})
+	c.Check(spec.Tolerations, check.DeepEquals, []toleration{
+		{Key: "dedicated", Operator: "Equal", Value: "arvados", Effect: "NoSchedule"},
+		{Key: "spot", Operator: "Exists"},
+	})
+	c.Assert(spec.Containers, check.HasLen, 1)
+	ctr := spec.Containers[0]
+	c.Check(ctr.Image, check.Equals, "example/arvados-worker:1.2")
+	c.Check(ctr.SecurityContext, check.DeepEquals, &securityContext{Privileged: true})
+	c.Check(ctr.Resources.Requests, check.DeepEquals, map[string]string{
+		"cpu":               "4",
+		"memory":            "8589934592",
+		"ephemeral-storage": "107374182400",
+		"nvidia.com/gpu":    "1",
+	})
+	c.Check(ctr.Resources.Limits, check.DeepEquals, ctr.Resources.Requests)
+	c.Assert(ctr.Command, check.HasLen, 3)
+	c.Check(ctr.Command[2], check.Matches, `(?ms).*\necho init\n.*exec /usr/sbin/sshd -D -e\n`)
+	c.Check(ctr.Env, check.DeepEquals, []envVar{
+		{Name: "ARVADOS_ADMIN_USER", Value: "root"},
+		{Name: "ARVADOS_AUTHORIZED_KEY", Value: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(s.pubkey)))},
+	})
+}
+
+func (s *KubernetesInstanceSetSuite) TestCreateMinimal(c *check.C) {
+	is := s.instanceSet(c, "test-instance-set", "")
+	it := arvados.InstanceType{Name: "tiny", ProviderType: "tiny", VCPUs: 1, RAM: 1 << 30}
+	_, err := is.Create(it, "example/arvados-worker:1.2", nil, "", s.pubkey)
+	c.Assert(err, check.IsNil)
+
+	pods := s.server.Pods()
+	c.Assert(pods, check.HasLen, 1)
+	var spec podSpec
+	c.Assert(json.Unmarshal(pods[0].Spec, &spec), check.IsNil)
+	c.Check(spec.NodeSelector, check.IsNil)
+	c.Check(spec.Tolerations, check.IsNil)
+	c.Check(spec.Containers[0].SecurityContext, check.IsNil)
+	c.Check(spec.Containers[0].Resources.Requests, check.DeepEquals, map[string]string{"cpu": "1", "memory": "1073741824"})
+}
+
+func (s *KubernetesInstanceSetSuite) TestBadToken(c *check.C) {
+	s.server.Token = "other-token"
+	is := s.instanceSet(c, "test-instance-set", "")
+	_, err := is.Instances(nil)
+	c.Check(err, check.ErrorMatches, `.*401 Unauthorized.*`)
+}
+
+func (s *KubernetesInstanceSetSuite) TestInstances(c *check.C) {
+	s.server.MaxPageSize = 2
+	s.server.GracefulDelete = true
+	is := s.instanceSet(c, "test-instance-set", "")
+	other := s.instanceSet(c, "other-instance-set", "")
+	it := arvados.InstanceType{Name: "tiny", ProviderType: "tiny", VCPUs: 1, RAM: 1 << 30}
+
+	var created []cloud.Instance
+	for i := 0; i < 5; i++ {
+		inst, err := is.Create(it, "image", cloud.InstanceTags{"i": "x"}, "", s.pubkey)
+		c.Assert(err, check.IsNil)
+		created = append(created, inst)
+	}
+	_, err := other.Create(it, "image", nil, "", s.pubkey)
+	c.Assert(err, check.IsNil)
+
+	insts, err := is.Instances(nil)
+	c.Assert(err, check.IsNil)
+	c.Check(insts, check.HasLen, 5)
+	for _, inst := range insts {
+		c.Check(inst.ProviderType(), check.Equals, "tiny")
+		c.Check(inst.Tags(), check.DeepEquals, cloud.InstanceTags{"i": "x"})
+	}
+
+	// Pods that are shutting down are not listed.
+	c.Check(created[0].Destroy(), check.IsNil)
+	c.Check(created[3].Destroy(), check.IsNil)
+	insts, err = is.Instances(nil)
+	c.Assert(err, check.IsNil)
+	c.Check(insts, check.HasLen, 3)
+
+	insts, err = other.Instances(nil)
+	c.Assert(err, check.IsNil)
+	c.Check(insts, check.HasLen, 1)
+}
+
+func (s *KubernetesInstanceSetSuite) TestSetTags(c *check.C) {
+	is := s.instanceSet(c, "test-instance-set", "")
+	it := arvados.InstanceType{Name: "tiny", ProviderType: "tiny", VCPUs: 1, RAM: 1 << 30}
+	inst, err := is.Create(it, "image", cloud.InstanceTags{"foo": "bar"}, "", s.pubkey)
+	c.Assert(err, check.IsNil)
+
+	newTags := cloud.InstanceTags{"foo": "baz", "Idle Behavior": "hold"}
+	c.Assert(inst.SetTags(newTags), check.IsNil)
+	c.Check(inst.Tags(), check.DeepEquals, newTags)
+	c.Check(inst.ProviderType(), check.Equals, "tiny")
+
+	insts, err := is.Instances(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(insts, check.HasLen, 1)
+	c.Check(insts[0].Tags(), check.DeepEquals, newTags)
+	c.Check(insts[0].ProviderType(), check.Equals, "tiny")
+}
+
+func (s *KubernetesInstanceSetSuite) TestDestroy(c *check.C) {
+	is := s.instanceSet(c, "test-instance-set", "")
+	it := arvados.InstanceType{Name: "tiny", ProviderType: "tiny", VCPUs: 1, RAM: 1 << 30}
+	inst, err := is.Create(it, "image", nil, "", s.pubkey)
+	c.Assert(err, check.IsNil)
+	c.Check(inst.Destroy(), check.IsNil)
+	c.Check(s.server.Pods(), check.HasLen, 0)
+	// Destroying a pod that no longer exists is not an error.
+	c.Check(inst.Destroy(), check.IsNil)
+}
+
+func (s *KubernetesInstanceSetSuite) TestCreateErrors(c *check.C) {
+	var reject *fakekube.Status
+	s.server.SetupPod = func(*fakekube.Pod) *fakekube.Status { return reject }
+	is := s.instanceSet(c, "test-instance-set", "")
+	it := arvados.InstanceType{Name: "tiny", ProviderType: "tiny", VCPUs: 1, RAM: 1 << 30}
+
+	reject = &fakekube.Status{Code: http.StatusForbidden, Reason: "Forbidden", Message: `pods "arvados-worker-x" is forbidden: exceeded quota: compute-resources`}
+	_, err := is.Create(it, "image", nil, "", s.pubkey)
+	c.Check(err, check.ErrorMatches, `.*403 Forbidden.*exceeded quota.*`)
+	qerr, ok := err.(cloud.QuotaError)
+	if c.Check(ok, check.Equals, true) {
+		c.Check(qerr.IsQuotaError(), check.Equals, true)
+	}
+
+	reject = &fakekube.Status{Code: http.StatusTooManyRequests, Reason: "TooManyRequests", Message: "slow down", RetryAfterSeconds: 30}
+	t0 := time.Now()
+	_, err = is.Create(it, "image", nil, "", s.pubkey)
+	c.Check(err, check.ErrorMatches, `.*429 TooManyRequests: slow down`)
+	rlerr, ok := err.(cloud.RateLimitError)
+	if c.Check(ok, check.Equals, true) {
+		c.Check(rlerr.EarliestRetry().After(t0.Add(29*time.Second)), check.Equals, true)
+	}
+
+	reject = &fakekube.Status{Code: http.StatusForbidden, Reason: "Forbidden", Message: "permission denied"}
+	_, err = is.Create(it, "image", nil, "", s.pubkey)
+	c.Check(err, check.ErrorMatches, `.*403 Forbidden: permission denied`)
+	_, ok = err.(cloud.QuotaError)
+	c.Check(ok, check.Equals, false)
+
+	c.Check(s.server.Pods(), check.HasLen, 0)
+}
+
+func (*KubernetesInstanceSetSuite) TestLabelValue(c *check.C) {
+	c.Check(labelValue("zzzzz-abc_123.x"), check.Equals, "zzzzz-abc_123.x")
+	c.Check(labelValue("a/b c"), check.Equals, "a_b_c")
+	c.Check(labelValue("_"+strings.Repeat("x", 70)), check.Equals, strings.Repeat("x", 62))
+}
@@ -84,6 +84,7 @@ var whitelist = map[string]bool{
 	"Collections.BalanceCollectionBuffers":         false,
 	"Collections.BalancePeriod":                    false,
 	"Collections.BalanceTimeout":                   false,
+	"Collections.BalanceUpdateLimit":               false,
 	"Collections.BlobDeleteConcurrency":            false,
 	"Collections.BlobMissingReport":                false,
 	"Collections.BlobReplicateConcurrency":         false,
@@ -94,6 +95,7 @@ var whitelist = map[string]bool{
 	"Collections.BlobTrashCheckInterval":           false,
 	"Collections.BlobTrashConcurrency":             false,
 	"Collections.BlobTrashLifetime":                false,
+	"Collections.BlockHashAlgorithm":               true,
 	"Collections.CollectionVersioning":             false,
 	"Collections.DefaultReplication":               true,
 	"Collections.DefaultTrashLifetime":             true,
@@ -112,6 +114,7 @@ var whitelist = map[string]bool{
 	"Containers.CrunchRunCommand":                  false,
 	"Containers.DefaultKeepCacheRAM":               true,
 	"Containers.DispatchPrivateKey":                false,
+	"Containers.FairShare":                         false,
 	"Containers.JobsAPI":                           true,
 	"Containers.JobsAPI.Enable":                    true,
 	"Containers.JobsAPI.GitInternalDir":            false,
@@ -122,6 +125,8 @@ var whitelist = map[string]bool{
 	"Containers.MaxRetryAttempts":                  true,
 	"Containers.MinRetryPeriod":                    true,
 	"Containers.ReserveExtraRAM":                   true,
+	"Containers.LSF":                               false,
+	"Containers.PBS":                               false,
 	"Containers.SLURM":                             false,
 	"Containers.StaleLockTimeout":                  false,
 	"Containers.SupportedDockerImageFormats":       true,
@@ -197,6 +197,9 @@ Clusters:
       # Any HTTP requests beyond MaxConcurrentRequests will receive an
       # immediate 503 response.
       #
+      # If any of a Keepstore server's volumes use EncryptionKeys, half
+      # of its buffers are used for encrypting and decrypting blocks.
+      #
       # MaxKeepBlobBuffers should be set such that (MaxKeepBlobBuffers * 64MiB
       # * 1.1) fits comfortably in memory. On a host dedicated to running
       # Keepstore, divide total memory by 88MiB to suggest a suitable value.
@@ -386,6 +389,11 @@ Clusters:
       # collection's replication_desired attribute is nil.
       DefaultReplication: 2
 
+      # Hash algorithm clients should use to compute locators of new
+      # data blocks: "md5" or "sha256". Existing blocks remain
+      # readable regardless of this setting.
+      BlockHashAlgorithm: md5
+
       # BlobSigningTTL determines the minimum lifetime of transient
       # data, i.e., blocks that are not referenced by
       # collections. Unreferenced blocks exist for two reasons:
@@ -450,6 +458,13 @@ Clusters:
       # long-running balancing operation.
       BalanceTimeout: 6h
 
+      # Maximum number of replication_confirmed /
+      # storage_classes_confirmed updates to write to the database
+      # after a rebalancing run. When a large number of collections
+      # need to be updated, this limit spreads the load over several
+      # rebalancing runs. Zero means no limit.
+      BalanceUpdateLimit: 100000
+
       # Default lifetime for ephemeral collections: 2 weeks. This must not
       # be less than BlobSigningTTL.
       DefaultTrashLifetime: 336h
@@ -812,6 +827,33 @@ Clusters:
       # Minimum time between two attempts to run the same container
       MinRetryPeriod: 0s
 
+      # Fair-share scheduling (cloud dispatcher only). When enabled,
+      # containers are started in an order that favors users (or
+      # projects, see GroupBy) whose recent compute usage is low
+      # relative to their weight, instead of strictly in priority
+      # order. Containers belonging to the same user/project are
+      # still started in priority order.
+      FairShare:
+        Enable: false
+
+        # Either "user" (the user the container runs as, i.e., the
+        # user who submitted the earliest container request using
+        # it) or "project" (the project that owns that container
+        # request).
+        GroupBy: user
+
+        # Past usage counts for half as much after this long.
+        HalfLife: 1h
+
+        # Maximum number of instances that can be running containers
+        # for a single user/project at once. 0 means unlimited.
+        MaxInstancesPerGroup: 0
+
+        # Relative share of compute resources for each user or
+        # project UUID. Users/projects not listed here have weight 1.
+        Weights:
+          SAMPLE: 1
+
       Logging:
         # When you run the db:delete_old_container_logs task, it will find
         # containers that have been finished for at least this many seconds,
@@ -854,7 +896,71 @@ Clusters:
         # period.
         LogUpdateSize: 32MiB
 
+        # In addition to the plain text log files, write each log
+        # message as a JSON record (with timestamp, stream, severity,
+        # and source fields) to log.jsonl in the log collection.
+        StructuredLogs: false
+
+      LSF:
+        # Additional arguments to bsub when submitting containers
+        # with crunch-dispatch-lsf, e.g., ["-q", "arvados"]. The
+        # dispatcher already adds -J (job name), -n (cores), and -R
+        # (memory and temp space) arguments.
+        #
+        # Without -o and -e, LSF emails each job's output to the
+        # submitting user.
+        BsubArgumentsList:
+          - "-o"
+          - "/tmp/crunch-run.%J.out"
+          - "-e"
+          - "/tmp/crunch-run.%J.err"
+
+        # If greater than 1, crunch-dispatch-lsf uses "bmod -sp" to
+        # keep the user-assigned priorities of pending jobs (in the
+        # range 1 to MaxUserPriority) in the same order as the
+        # Arvados container priorities. This must not exceed the
+        # MAX_USER_PRIORITY setting in lsb.params.
+        #
+        # If 0, job priorities are not adjusted.
+        MaxUserPriority: 0
+
+      PBS:
+        # Additional arguments to qsub when submitting containers
+        # with crunch-dispatch-pbs, e.g., ["-q", "arvados"]. The
+        # dispatcher already adds -N (job name), -p (priority), -r n
+        # (not rerunnable), -v (Arvados API credentials), and -l
+        # select=... (cores and memory) arguments.
+        QsubArgumentsList: []
+
+        # crunch-dispatch-pbs uses "qalter -p" to keep the
+        # priorities of queued jobs (in the range MinPriority to
+        # MaxPriority) in the same order as the Arvados container
+        # priorities. PBS allows priorities from -1024 to 1023. If
+        # MinPriority is equal to MaxPriority, job priorities are
+        # not adjusted.
+        MinPriority: 0
+        MaxPriority: 1023
+
       SLURM:
+        # How crunch-dispatch-slurm communicates with SLURM: "cli"
+        # (run the sbatch, squeue, scancel, and scontrol commands)
+        # or "rest" (use the slurmrestd API configured below).
+        Interface: cli
+
+        REST:
+          # URL of slurmrestd, e.g., "http://slurmctld.example:6820"
+          # or "unix:///var/run/slurmrestd.sock". Only used when
+          # Interface is "rest".
+          URL: ""
+
+          # SLURM user name and JWT token (see "scontrol token")
+          # used to authenticate to slurmrestd. Containers are run
+          # as this user. If UserName and Token are empty,
+          # slurmrestd must be configured to use local (socket)
+          # authentication.
+          UserName: ""
+          Token: ""
+
         PrioritySpread: 0
         SbatchArgumentsList: []
         SbatchEnvironmentVariables:
@@ -985,6 +1091,8 @@ Clusters:
         # see the SharedImageGalleryName and SharedImageGalleryImageVersion fields.
         # (azure) unmanaged disks (deprecated): the complete URI of the VHD, e.g.
         # https://xxxxx.blob.core.windows.net/system/Microsoft.Compute/Images/images/xxxxx.vhd
+        # (kubernetes) the container image to run in each worker pod, e.g.
+        # registry.example.com/arvados-worker:latest
         ImageID: ""
 
         # An executable file (located on the dispatcher host) to be
@@ -1015,7 +1123,9 @@ Clusters:
         # need to be detected and cleaned up manually.
         TagKeyPrefix: Arvados
 
-        # Cloud driver: "azure" (Microsoft Azure) or "ec2" (Amazon AWS).
+        # Cloud driver: "azure" (Microsoft Azure), "ec2" (Amazon AWS),
+        # or "kubernetes" (run each worker as a pod in a Kubernetes
+        # cluster).
         Driver: ec2
 
         # Cloud-specific driver parameters.
@@ -1070,6 +1180,53 @@ Clusters:
           # objects that are no longer being used.
           DeleteDanglingResourcesAfter: 20s
 
+          # (kubernetes) API server URL and credentials. When the
+          # dispatcher itself runs in a pod, these default to the
+          # in-cluster API server and the pod's service account.
+          APIServer: ""
+          Token: ""
+          TokenFile: ""
+          CACertFile: ""
+          Insecure: false
+
+          # (kubernetes) Namespace where worker pods will be created.
+          # Default is the dispatcher pod's own namespace, or
+          # "default".
+          Namespace: ""
+
+          # (kubernetes) Pod configuration. The worker image must
+          # provide /usr/sbin/sshd, and normally needs Privileged
+          # mode to run docker.
+          Privileged: false
+          ServiceAccountName: ""
+          ImagePullSecrets: []
+
+          # (kubernetes) Node selectors and tolerations for all worker
+          # pods, and additional ones for pods running preemptible
+          # instance types. Example:
+          #
+          # NodeSelector:
+          #   arvados.org/worker: "true"
+          # Tolerations:
+          #   - Key: dedicated
+          #     Operator: Equal
+          #     Value: arvados
+          #     Effect: NoSchedule
+          # PreemptibleNodeSelector:
+          #   cloud.google.com/gke-preemptible: "true"
+          # PreemptibleTolerations: []
+          #
+          # (kubernetes) Additional node selectors, tolerations, and
+          # resource requests for specific instance types, keyed by
+          # ProviderType. Example:
+          #
+          # InstanceTypes:
+          #   gpu.large:
+          #     NodeSelector:
+          #       accelerator: nvidia-tesla-t4
+          #     Resources:
+          #       nvidia.com/gpu: "1"
+
           # Account (that already exists in the VM image) that will be
           # set up with an ssh authorized key to allow the compute
           # dispatcher to connect.
@@ -1156,6 +1313,12 @@ Clusters:
           WriteRaceInterval: 15s
           WriteRacePollTime: 1s
 
+          # for GCS driver -- see
+          # https://doc.arvados.org/install/configure-gcs-storage.html
+          # (Bucket, Endpoint, RequestTimeout, and IndexPageSize are
+          # also used by the GCS driver, see above)
+          CredentialsFile: ""
+
           # for local directory driver -- see
           # https://doc.arvados.org/install/configure-fs-storage.html
           Root: /var/lib/arvados/keep-data
@@ -1176,6 +1339,24 @@ Clusters:
           # should leave this alone.
           Serialize: false
 
+          # for any driver: use a local directory as a write-back
+          # cache in front of the volume -- see
+          # https://doc.arvados.org/install/configure-tiered-storage.html
+          FastTierRoot: ""
+          FastTierSize: 0
+          FastTierFlushWorkers: 4
+
+          # for any driver: encrypt blocks before storing them on
+          # the volume. Each entry is a 256-bit key given as 64 hex
+          # digits, the absolute path of a file containing 64 hex
+          # digits, or an http(s) URL that returns 64 hex digits
+          # (e.g., a key management service). New blocks are
+          # encrypted with the first key;
+          # blocks encrypted with other keys are re-encrypted in the
+          # background -- see
+          # https://doc.arvados.org/install/configure-encryption.html
+          EncryptionKeys: []
+
     Mail:
       MailchimpAPIKey: ""
       MailchimpListID: ""
@@ -16,6 +16,7 @@ import (
 	"strings"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"github.com/ghodss/yaml"
 	"github.com/imdario/mergo"
 	"github.com/sirupsen/logrus"
@@ -271,6 +272,7 @@ func (ldr *Loader) Load() (*arvados.Config, error) {
 	for id, cc := range cfg.Clusters {
 		for _, err = range []error{
 			checkKeyConflict(fmt.Sprintf("Clusters.%s.PostgreSQL.Connection", id), cc.PostgreSQL.Connection),
+			checkBlockHashAlgorithm(fmt.Sprintf("Clusters.%s.Collections.BlockHashAlgorithm", id), cc.Collections.BlockHashAlgorithm),
 			ldr.checkEmptyKeepstores(cc),
 			ldr.checkUnlistedKeepstores(cc),
 		} {
@@ -294,6 +296,13 @@ func checkKeyConflict(label string, m map[string]string) error {
 	return nil
 }
 
+func checkBlockHashAlgorithm(label, name string) error {
+	if _, err := blockdigest.HashAlgorithmByName(name); err != nil {
+		return fmt.Errorf("%s: %s", label, err)
+	}
+	return nil
+}
+
 func removeSampleKeys(m map[string]interface{}) {
 	delete(m, "SAMPLE")
 	for _, v := range m {
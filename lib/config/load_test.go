@@ -291,6 +291,30 @@ Clusters:
 	c.Check(err, check.ErrorMatches, `Clusters.zzzzz.PostgreSQL.Connection: multiple entries for "(dbname|host)".*`)
 }
 
+func (s *LoadSuite) TestBlockHashAlgorithm(c *check.C) {
+	for _, trial := range []struct {
+		name string
+		ok   bool
+	}{
+		{"md5", true},
+		{"sha256", true},
+		{"SHA256", true},
+		{"sha1", false},
+	} {
+		_, err := testLoader(c, `
+Clusters:
+ zzzzz:
+  Collections:
+   BlockHashAlgorithm: `+trial.name+`
+`, nil).Load()
+		if trial.ok {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, `Clusters.zzzzz.Collections.BlockHashAlgorithm: unsupported block hash algorithm "sha1"`)
+		}
+	}
+}
+
 func (s *LoadSuite) TestBadType(c *check.C) {
 	for _, data := range []string{`
 Clusters:
@@ -17,10 +17,10 @@ import (
 	"regexp"
 	"strings"
 
+	"git.arvados.org/arvados.git/lib/controller/localdb"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/auth"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
-	"github.com/jmcvetta/randutil"
 )
 
 var pathPattern = `^/arvados/v1/%s(/([0-9a-z]{5})-%s-[0-9a-z]{15})?(.*)$`
@@ -193,41 +193,7 @@ func (h *Handler) createAPItoken(req *http.Request, userUUID string, scopes []st
 	if err != nil {
 		return nil, err
 	}
-	rd, err := randutil.String(15, "abcdefghijklmnopqrstuvwxyz0123456789")
-	if err != nil {
-		return nil, err
-	}
-	uuid := fmt.Sprintf("%v-gj3su-%v", h.Cluster.ClusterID, rd)
-	token, err := randutil.String(50, "abcdefghijklmnopqrstuvwxyz0123456789")
-	if err != nil {
-		return nil, err
-	}
-	if len(scopes) == 0 {
-		scopes = append(scopes, "all")
-	}
-	scopesjson, err := json.Marshal(scopes)
-	if err != nil {
-		return nil, err
-	}
-	_, err = db.ExecContext(req.Context(),
-		`INSERT INTO api_client_authorizations
-(uuid, api_token, expires_at, scopes,
-user_id,
-api_client_id, created_at, updated_at)
-VALUES ($1, $2, CURRENT_TIMESTAMP AT TIME ZONE 'UTC' + INTERVAL '2 weeks', $3,
-(SELECT id FROM users WHERE users.uuid=$4 LIMIT 1),
-0, CURRENT_TIMESTAMP AT TIME ZONE 'UTC', CURRENT_TIMESTAMP AT TIME ZONE 'UTC')`,
-		uuid, token, string(scopesjson), userUUID)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &arvados.APIClientAuthorization{
-		UUID:      uuid,
-		APIToken:  token,
-		ExpiresAt: "",
-		Scopes:    scopes}, nil
+	return localdb.CreateAPIToken(req.Context(), db, h.Cluster.ClusterID, userUUID, scopes)
 }
 
 // Extract the auth token supplied in req, and replace it with a
@@ -0,0 +1,34 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package federation
+
+import (
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&RewriteManifestSuite{})
+
+type RewriteManifestSuite struct{}
+
+func (s *RewriteManifestSuite) TestRewriteManifest(c *check.C) {
+	for _, trial := range []struct {
+		in, out string
+	}{
+		{
+			". acbd18db4cc2f85cedef654fccc4a4d8+3+Aabcdef0123456789@12345678 0:3:foo\n",
+			". acbd18db4cc2f85cedef654fccc4a4d8+3+Rzzzzz-abcdef0123456789@12345678 0:3:foo\n",
+		},
+		{
+			". 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3+Aabcdef0123456789@12345678 0:3:foo\n",
+			". 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3+Rzzzzz-abcdef0123456789@12345678 0:3:foo\n",
+		},
+		{
+			". acbd18db4cc2f85cedef654fccc4a4d8+3+Aabcdef0123456789@12345678 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3+Aabcdef0123456789@12345678 0:6:foo\n",
+			". acbd18db4cc2f85cedef654fccc4a4d8+3+Rzzzzz-abcdef0123456789@12345678 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3+Rzzzzz-abcdef0123456789@12345678 0:6:foo\n",
+		},
+	} {
+		c.Check(rewriteManifest(trial.in, "zzzzz"), check.Equals, trial.out)
+	}
+}
@@ -19,8 +19,10 @@ import (
 	"git.arvados.org/arvados.git/lib/config"
 	"git.arvados.org/arvados.git/lib/controller/localdb"
 	"git.arvados.org/arvados.git/lib/controller/rpc"
+	"git.arvados.org/arvados.git/lib/ctrlctx"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/auth"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
 )
 
@@ -170,8 +172,13 @@ func (conn *Conn) CollectionUpdate(ctx context.Context, options arvados.UpdateOp
 	return conn.chooseBackend(options.UUID).CollectionUpdate(ctx, options)
 }
 
+var manifestLocatorRe = regexp.MustCompile(` ` + blockdigest.HashPattern + `\+[^ ]*`)
+
+// rewriteManifest replaces the permission signatures of the block
+// locators in a manifest from a remote cluster with remote
+// signature hints (+R) that identify the remote cluster.
 func rewriteManifest(mt, remoteID string) string {
-	return regexp.MustCompile(` [0-9a-f]{32}\+[^ ]*`).ReplaceAllStringFunc(mt, func(tok string) string {
+	return manifestLocatorRe.ReplaceAllStringFunc(mt, func(tok string) string {
 		return strings.Replace(tok, "+A", "+R"+remoteID+"-", -1)
 	})
 }
@@ -331,6 +338,86 @@ func (conn *Conn) ContainerUnlock(ctx context.Context, options arvados.GetOption
 	return conn.chooseBackend(options.UUID).ContainerUnlock(ctx, options)
 }
 
+func (conn *Conn) ContainerRequestList(ctx context.Context, options arvados.ListOptions) (arvados.ContainerRequestList, error) {
+	return conn.generated_ContainerRequestList(ctx, options)
+}
+
+func (conn *Conn) ContainerRequestCreate(ctx context.Context, options arvados.CreateOptions) (arvados.ContainerRequest, error) {
+	be := conn.chooseBackend(options.ClusterID)
+	if be == conn.local {
+		// Submitting container request to local cluster. No
+		// need to set a runtime_token (rails api will create
+		// one when the container runs).
+		return be.ContainerRequestCreate(ctx, options)
+	}
+	if _, ok := options.Attrs["runtime_token"]; !ok {
+		token, err := conn.remoteRuntimeToken(ctx)
+		if err != nil {
+			return arvados.ContainerRequest{}, err
+		}
+		if options.Attrs == nil {
+			options.Attrs = map[string]interface{}{}
+		}
+		options.Attrs["runtime_token"] = token
+	}
+	return be.ContainerRequestCreate(ctx, options)
+}
+
+// remoteRuntimeToken returns a token that a remote cluster can use
+// to run a container on behalf of the current user: a new
+// time-limited token if the user is local, otherwise the caller's
+// own token (minus the optional container UUID suffix).
+func (conn *Conn) remoteRuntimeToken(ctx context.Context) (string, error) {
+	incoming, ok := auth.FromContext(ctx)
+	if !ok || len(incoming.Tokens) == 0 {
+		return "", httpErrorf(http.StatusForbidden, "no token provided")
+	}
+	aca, err := conn.local.APIClientAuthorizationCurrent(ctx, arvados.GetOptions{})
+	if err != nil {
+		return "", httpErrorf(http.StatusForbidden, "invalid API token")
+	}
+	if len(aca.Scopes) != 1 || aca.Scopes[0] != "all" {
+		return "", httpErrorf(http.StatusForbidden, "token scope is not [all]")
+	}
+	if !strings.HasPrefix(aca.UUID, conn.cluster.ClusterID) {
+		// Remote user. Container request will use the
+		// current token, minus the trailing portion
+		// (optional container uuid).
+		sp := strings.Split(incoming.Tokens[0], "/")
+		if len(sp) >= 3 {
+			return strings.Join(sp[0:3], "/"), nil
+		}
+		return incoming.Tokens[0], nil
+	}
+	// Local user, submitting to a remote cluster. Create a new
+	// time-limited token.
+	user, err := conn.local.UserGetCurrent(ctx, arvados.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	tx, err := ctrlctx.CurrentTx(ctx)
+	if err != nil {
+		return "", err
+	}
+	newtok, err := localdb.CreateAPIToken(ctx, tx, conn.cluster.ClusterID, user.UUID, nil)
+	if err != nil {
+		return "", err
+	}
+	return newtok.TokenV2(), nil
+}
+
+func (conn *Conn) ContainerRequestUpdate(ctx context.Context, options arvados.UpdateOptions) (arvados.ContainerRequest, error) {
+	return conn.chooseBackend(options.UUID).ContainerRequestUpdate(ctx, options)
+}
+
+func (conn *Conn) ContainerRequestGet(ctx context.Context, options arvados.GetOptions) (arvados.ContainerRequest, error) {
+	return conn.chooseBackend(options.UUID).ContainerRequestGet(ctx, options)
+}
+
+func (conn *Conn) ContainerRequestDelete(ctx context.Context, options arvados.DeleteOptions) (arvados.ContainerRequest, error) {
+	return conn.chooseBackend(options.UUID).ContainerRequestDelete(ctx, options)
+}
+
 func (conn *Conn) SpecimenList(ctx context.Context, options arvados.ListOptions) (arvados.SpecimenList, error) {
 	return conn.generated_SpecimenList(ctx, options)
 }
@@ -0,0 +1,103 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package federation
+
+import (
+	"context"
+	"net/http"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/auth"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&ContainerRequestSuite{})
+
+type ContainerRequestSuite struct {
+	FederationSuite
+}
+
+// currentTokenStub is an APIStub that reports a fixed current token.
+type currentTokenStub struct {
+	arvadostest.APIStub
+	current arvados.APIClientAuthorization
+}
+
+func (as *currentTokenStub) APIClientAuthorizationCurrent(ctx context.Context, options arvados.GetOptions) (arvados.APIClientAuthorization, error) {
+	return as.current, nil
+}
+
+func (s *ContainerRequestSuite) setup(c *check.C, current arvados.APIClientAuthorization) (local, remote *arvadostest.APIStub) {
+	localStub := &currentTokenStub{current: current}
+	s.fed.local = localStub
+	remote = &arvadostest.APIStub{}
+	s.addDirectRemote(c, "zzzzz", remote)
+	return &localStub.APIStub, remote
+}
+
+func (s *ContainerRequestSuite) TestCreateLocal(c *check.C) {
+	local, remote := s.setup(c, arvados.APIClientAuthorization{})
+	_, err := s.fed.ContainerRequestCreate(s.ctx, arvados.CreateOptions{
+		Attrs: map[string]interface{}{"command": []string{"echo"}},
+	})
+	c.Check(err, check.IsNil)
+	c.Check(remote.Calls(nil), check.HasLen, 0)
+	calls := local.Calls(local.ContainerRequestCreate)
+	if c.Check(calls, check.HasLen, 1) {
+		_, ok := calls[0].Options.(arvados.CreateOptions).Attrs["runtime_token"]
+		c.Check(ok, check.Equals, false)
+	}
+}
+
+func (s *ContainerRequestSuite) TestCreateRemoteWithRemoteUserToken(c *check.C) {
+	_, remote := s.setup(c, arvados.APIClientAuthorization{
+		UUID:   "zzzzz-gj3su-077z32aux8dg2s1",
+		Scopes: []string{"all"},
+	})
+	ctx := auth.NewContext(s.ctx, &auth.Credentials{Tokens: []string{"v2/zzzzz-gj3su-077z32aux8dg2s1/3kg6k6lzmp9kj5cpkcoxie963cmvjahbt2fod9zru30k1jqdmi/zzzzz-dz642-queuedcontainer"}})
+	_, err := s.fed.ContainerRequestCreate(ctx, arvados.CreateOptions{ClusterID: "zzzzz"})
+	c.Check(err, check.IsNil)
+	calls := remote.Calls(remote.ContainerRequestCreate)
+	if c.Check(calls, check.HasLen, 1) {
+		c.Check(calls[0].Options.(arvados.CreateOptions).Attrs["runtime_token"], check.Equals, "v2/zzzzz-gj3su-077z32aux8dg2s1/3kg6k6lzmp9kj5cpkcoxie963cmvjahbt2fod9zru30k1jqdmi")
+	}
+}
+
+func (s *ContainerRequestSuite) TestCreateRemoteWithRuntimeToken(c *check.C) {
+	_, remote := s.setup(c, arvados.APIClientAuthorization{})
+	_, err := s.fed.ContainerRequestCreate(s.ctx, arvados.CreateOptions{
+		ClusterID: "zzzzz",
+		Attrs:     map[string]interface{}{"runtime_token": arvadostest.ActiveTokenV2},
+	})
+	c.Check(err, check.IsNil)
+	calls := remote.Calls(remote.ContainerRequestCreate)
+	if c.Check(calls, check.HasLen, 1) {
+		c.Check(calls[0].Options.(arvados.CreateOptions).Attrs["runtime_token"], check.Equals, arvadostest.ActiveTokenV2)
+	}
+}
+
+func (s *ContainerRequestSuite) TestCreateRemoteWithScopedToken(c *check.C) {
+	_, remote := s.setup(c, arvados.APIClientAuthorization{
+		UUID:   "zzzzz-gj3su-077z32aux8dg2s1",
+		Scopes: []string{"GET /arvados/v1/container_requests"},
+	})
+	_, err := s.fed.ContainerRequestCreate(s.ctx, arvados.CreateOptions{ClusterID: "zzzzz"})
+	c.Check(errStatus(err), check.Equals, http.StatusForbidden)
+	c.Check(remote.Calls(nil), check.HasLen, 0)
+}
+
+func (s *ContainerRequestSuite) TestGetUpdateDeleteChooseBackend(c *check.C) {
+	local, remote := s.setup(c, arvados.APIClientAuthorization{})
+	_, err := s.fed.ContainerRequestGet(s.ctx, arvados.GetOptions{UUID: "zzzzz-xvhdp-cr4queuedcontnr"})
+	c.Check(err, check.IsNil)
+	_, err = s.fed.ContainerRequestUpdate(s.ctx, arvados.UpdateOptions{UUID: "zzzzz-xvhdp-cr4queuedcontnr"})
+	c.Check(err, check.IsNil)
+	_, err = s.fed.ContainerRequestDelete(s.ctx, arvados.DeleteOptions{UUID: "aaaaa-xvhdp-cr4queuedcontnr"})
+	c.Check(err, check.IsNil)
+	c.Check(remote.Calls(remote.ContainerRequestGet), check.HasLen, 1)
+	c.Check(remote.Calls(remote.ContainerRequestUpdate), check.HasLen, 1)
+	c.Check(local.Calls(local.ContainerRequestDelete), check.HasLen, 1)
+}
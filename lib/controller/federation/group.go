@@ -0,0 +1,262 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package federation
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+)
+
+func (conn *Conn) GroupCreate(ctx context.Context, options arvados.CreateOptions) (arvados.Group, error) {
+	return conn.chooseBackend(options.ClusterID).GroupCreate(ctx, options)
+}
+
+func (conn *Conn) GroupUpdate(ctx context.Context, options arvados.UpdateOptions) (arvados.Group, error) {
+	return conn.chooseBackend(options.UUID).GroupUpdate(ctx, options)
+}
+
+func (conn *Conn) GroupGet(ctx context.Context, options arvados.GetOptions) (arvados.Group, error) {
+	return conn.chooseBackend(options.UUID).GroupGet(ctx, options)
+}
+
+func (conn *Conn) GroupList(ctx context.Context, options arvados.ListOptions) (arvados.GroupList, error) {
+	return conn.generated_GroupList(ctx, options)
+}
+
+func (conn *Conn) GroupDelete(ctx context.Context, options arvados.DeleteOptions) (arvados.Group, error) {
+	return conn.chooseBackend(options.UUID).GroupDelete(ctx, options)
+}
+
+func (conn *Conn) GroupTrash(ctx context.Context, options arvados.DeleteOptions) (arvados.Group, error) {
+	return conn.chooseBackend(options.UUID).GroupTrash(ctx, options)
+}
+
+func (conn *Conn) GroupUntrash(ctx context.Context, options arvados.UntrashOptions) (arvados.Group, error) {
+	return conn.chooseBackend(options.UUID).GroupUntrash(ctx, options)
+}
+
+// GroupContents lists the contents of a project.
+//
+// A project (group) lives on a single cluster, so a request for a
+// project's contents is sent to that project's cluster. However, the
+// objects owned by a user -- i.e., a user's home project -- can be
+// stored on any cluster in the federation. If options.UUID is a user
+// UUID (or empty, meaning everything readable by the caller), the
+// request is sent to the local cluster and every remote cluster with
+// a proxy configured, and the results are merged into a single page
+// in the same order the API server would have used. Remote clusters
+// that return errors are left out of the results.
+func (conn *Conn) GroupContents(ctx context.Context, options arvados.GroupContentsOptions) (arvados.ObjectList, error) {
+	if options.BypassFederation || options.ForwardedFor != "" {
+		// Client requested no federation. Pass through.
+		return conn.local.GroupContents(ctx, options)
+	} else if options.ClusterID != "" {
+		return conn.chooseBackend(options.ClusterID).GroupContents(ctx, options)
+	} else if options.UUID != "" && (len(options.UUID) != 27 || options.UUID[6:11] != "tpzed") {
+		return conn.chooseBackend(options.UUID).GroupContents(ctx, options)
+	} else if len(conn.remotes) == 0 {
+		return conn.local.GroupContents(ctx, options)
+	}
+
+	limit := options.Limit
+	if max := int64(conn.cluster.API.MaxItemsPerResponse); max > 0 && (limit < 0 || limit > max) {
+		limit = max
+	}
+	orders := options.Order
+	if len(orders) == 0 {
+		orders = []string{"modified_at desc", "uuid"}
+	}
+
+	// Each backend returns its own first offset+limit items, so
+	// the requested page can be taken from the merged results.
+	backendOpts := options
+	backendOpts.Offset = 0
+	backendOpts.Limit = -1
+	if limit >= 0 {
+		backendOpts.Limit = options.Offset + limit
+	}
+	backendOpts.ForwardedFor = conn.cluster.ClusterID + "-" + options.ForwardedFor
+	if backendOpts.Select != nil {
+		// We always need UUIDs and sort keys to merge the
+		// responses, even if our caller doesn't.
+		backendOpts.Select = append([]string{"uuid"}, backendOpts.Select...)
+		for _, order := range orders {
+			attr := strings.Fields(order)[0]
+			if i := strings.Index(attr, "."); i >= 0 {
+				attr = attr[i+1:]
+			}
+			backendOpts.Select = append(backendOpts.Select, attr)
+		}
+	}
+
+	backends := map[string]backend{conn.cluster.ClusterID: conn.local}
+	for id, be := range conn.remotes {
+		backends[id] = be
+	}
+
+	var mtx sync.Mutex
+	merged := arvados.ObjectList{
+		Included: []interface{}{},
+		Items:    []interface{}{},
+		Offset:   int(options.Offset),
+		Limit:    int(limit),
+	}
+	included := map[string]bool{}
+	errs := make(chan error, len(backends))
+	for clusterID, be := range backends {
+		go func(clusterID string, be backend) {
+			ol, err := be.GroupContents(ctx, backendOpts)
+			if err != nil && clusterID == conn.cluster.ClusterID {
+				errs <- err
+				return
+			} else if err != nil {
+				// Leave out the contents of an
+				// unreachable remote cluster rather
+				// than failing the whole listing.
+				ctxlog.FromContext(ctx).WithError(err).Warnf("skipping cluster %s in federated group contents", clusterID)
+				errs <- nil
+				return
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			merged.Items = append(merged.Items, ol.Items...)
+			merged.ItemsAvailable += ol.ItemsAvailable
+			for _, item := range ol.Included {
+				m, _ := item.(map[string]interface{})
+				uuid, _ := m["uuid"].(string)
+				if !included[uuid] {
+					included[uuid] = true
+					merged.Included = append(merged.Included, item)
+				}
+			}
+			errs <- nil
+		}(clusterID, be)
+	}
+	var firstErr error
+	for range backends {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return arvados.ObjectList{}, firstErr
+	}
+
+	sort.SliceStable(merged.Items, func(i, j int) bool {
+		return contentsLess(merged.Items[i], merged.Items[j], orders)
+	})
+	if int64(len(merged.Items)) > options.Offset {
+		merged.Items = merged.Items[options.Offset:]
+	} else {
+		merged.Items = merged.Items[:0]
+	}
+	if limit >= 0 && int64(len(merged.Items)) > limit {
+		merged.Items = merged.Items[:limit]
+	}
+	return merged, nil
+}
+
+type contentsType struct {
+	rank  int
+	table string
+}
+
+// Types returned by groups/contents, in the order the API server
+// returns them, keyed by UUID infix.
+var contentsTypes = map[string]contentsType{
+	"j7d0g": {0, "groups"},
+	"8i9sb": {1, "jobs"},
+	"d1hrv": {2, "pipeline_instances"},
+	"p5p6p": {3, "pipeline_templates"},
+	"xvhdp": {4, "container_requests"},
+	"7fd4e": {5, "workflows"},
+	"4zz18": {6, "collections"},
+	"7a9it": {7, "humans"},
+	"j58dm": {8, "specimens"},
+	"q1cn2": {9, "traits"},
+}
+
+// contentsLess reports whether groups/contents item a sorts before
+// item b. Like the API server, items are grouped by type, and the
+// given orders are applied within each type: an order applies to a
+// type if it is unqualified ("name asc") or qualified with that
+// type's table name ("collections.name asc").
+func contentsLess(a, b interface{}, orders []string) bool {
+	ma, _ := a.(map[string]interface{})
+	mb, _ := b.(map[string]interface{})
+	uuidA, _ := ma["uuid"].(string)
+	uuidB, _ := mb["uuid"].(string)
+	ta, tb := contentsTypeOf(uuidA), contentsTypeOf(uuidB)
+	if ta.rank != tb.rank {
+		return ta.rank < tb.rank
+	}
+	for _, order := range orders {
+		fields := strings.Fields(order)
+		attr := fields[0]
+		if i := strings.Index(attr, "."); i >= 0 {
+			if attr[:i] != ta.table {
+				continue
+			}
+			attr = attr[i+1:]
+		}
+		desc := len(fields) > 1 && strings.EqualFold(fields[1], "desc")
+		if cmp := compareValues(ma[attr], mb[attr]); cmp != 0 {
+			return (cmp < 0) != desc
+		}
+	}
+	return uuidA < uuidB
+}
+
+func contentsTypeOf(uuid string) contentsType {
+	if len(uuid) == 27 {
+		if t, ok := contentsTypes[uuid[6:11]]; ok {
+			return t
+		}
+	}
+	return contentsType{rank: len(contentsTypes)}
+}
+
+// compareValues compares two JSON-decoded values of the same type,
+// returning -1, 0, or 1. Timestamps sort correctly as strings because
+// the API server always formats them with the same precision. Nil
+// sorts before non-nil.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			if a < b {
+				return -1
+			} else if a > b {
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a != b {
+			if b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
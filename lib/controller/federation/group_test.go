@@ -0,0 +1,148 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package federation
+
+import (
+	"context"
+	"errors"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&GroupSuite{})
+
+type GroupSuite struct {
+	FederationSuite
+}
+
+// contentsStub is an APIStub that returns a fixed list of objects
+// from GroupContents, honoring the requested limit.
+type contentsStub struct {
+	arvadostest.APIStub
+	items []interface{}
+	err   error
+}
+
+func (as *contentsStub) GroupContents(ctx context.Context, options arvados.GroupContentsOptions) (arvados.ObjectList, error) {
+	as.APIStub.GroupContents(ctx, options)
+	if as.err != nil {
+		return arvados.ObjectList{}, as.err
+	}
+	items := as.items
+	if options.Limit >= 0 && int64(len(items)) > options.Limit {
+		items = items[:options.Limit]
+	}
+	return arvados.ObjectList{
+		Items:          items,
+		ItemsAvailable: len(as.items),
+		Included:       []interface{}{map[string]interface{}{"uuid": arvadostest.ActiveUserUUID}},
+	}, nil
+}
+
+func contentsItem(uuid, modifiedAt string) interface{} {
+	return map[string]interface{}{"uuid": uuid, "modified_at": modifiedAt}
+}
+
+func (s *GroupSuite) setupContents(c *check.C) (local, remote *contentsStub) {
+	local = &contentsStub{items: []interface{}{
+		contentsItem("aaaaa-j7d0g-000000000000001", "2020-01-05T00:00:00.000000000Z"),
+		contentsItem("aaaaa-4zz18-000000000000001", "2020-01-04T00:00:00.000000000Z"),
+		contentsItem("aaaaa-4zz18-000000000000002", "2020-01-02T00:00:00.000000000Z"),
+	}}
+	remote = &contentsStub{items: []interface{}{
+		contentsItem("zzzzz-j7d0g-000000000000001", "2020-01-06T00:00:00.000000000Z"),
+		contentsItem("zzzzz-xvhdp-000000000000001", "2020-01-01T00:00:00.000000000Z"),
+		contentsItem("zzzzz-4zz18-000000000000001", "2020-01-03T00:00:00.000000000Z"),
+	}}
+	s.fed.local = local
+	s.addDirectRemote(c, "zzzzz", remote)
+	return
+}
+
+func (s *GroupSuite) TestContentsMerged(c *check.C) {
+	local, remote := s.setupContents(c)
+	var got []string
+	for offset := int64(0); offset < 8; offset += 3 {
+		ol, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{UUID: arvadostest.ActiveUserUUID, Offset: offset, Limit: 3})
+		c.Assert(err, check.IsNil)
+		c.Check(ol.ItemsAvailable, check.Equals, 6)
+		c.Check(ol.Offset, check.Equals, int(offset))
+		c.Check(ol.Included, check.HasLen, 1)
+		for _, item := range ol.Items {
+			got = append(got, item.(map[string]interface{})["uuid"].(string))
+		}
+	}
+	c.Check(got, check.DeepEquals, []string{
+		"zzzzz-j7d0g-000000000000001",
+		"aaaaa-j7d0g-000000000000001",
+		"zzzzz-xvhdp-000000000000001",
+		"aaaaa-4zz18-000000000000001",
+		"zzzzz-4zz18-000000000000001",
+		"aaaaa-4zz18-000000000000002",
+	})
+	for _, stub := range []*contentsStub{local, remote} {
+		calls := stub.Calls(stub.APIStub.GroupContents)
+		if c.Check(calls, check.HasLen, 3) {
+			opts := calls[2].Options.(arvados.GroupContentsOptions)
+			c.Check(opts.Offset, check.Equals, int64(0))
+			c.Check(opts.Limit, check.Equals, int64(9))
+			c.Check(opts.ForwardedFor, check.Equals, "aaaaa-")
+		}
+	}
+}
+
+func (s *GroupSuite) TestContentsRemoteError(c *check.C) {
+	local, remote := s.setupContents(c)
+	remote.err = errors.New("remote cluster is down")
+	ol, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{UUID: arvadostest.ActiveUserUUID, Limit: -1})
+	c.Assert(err, check.IsNil)
+	c.Check(ol.Items, check.HasLen, 3)
+	c.Check(ol.ItemsAvailable, check.Equals, 3)
+
+	local.err = errors.New("local database is down")
+	_, err = s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{UUID: arvadostest.ActiveUserUUID, Limit: -1})
+	c.Check(err, check.ErrorMatches, `local database is down`)
+}
+
+func (s *GroupSuite) TestContentsOrder(c *check.C) {
+	s.cluster.API.MaxItemsPerResponse = 1000
+	s.setupContents(c)
+	ol, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{Limit: -1, Order: []string{"collections.modified_at asc"}})
+	c.Assert(err, check.IsNil)
+	var got []string
+	for _, item := range ol.Items {
+		got = append(got, item.(map[string]interface{})["uuid"].(string))
+	}
+	c.Check(got, check.DeepEquals, []string{
+		"aaaaa-j7d0g-000000000000001",
+		"zzzzz-j7d0g-000000000000001",
+		"zzzzz-xvhdp-000000000000001",
+		"aaaaa-4zz18-000000000000002",
+		"zzzzz-4zz18-000000000000001",
+		"aaaaa-4zz18-000000000000001",
+	})
+}
+
+func (s *GroupSuite) TestContentsOfProject(c *check.C) {
+	local, remote := s.setupContents(c)
+	for _, trial := range []struct {
+		opts   arvados.GroupContentsOptions
+		remote bool
+	}{
+		{arvados.GroupContentsOptions{UUID: "zzzzz-j7d0g-000000000000001"}, true},
+		{arvados.GroupContentsOptions{UUID: "aaaaa-j7d0g-000000000000001"}, false},
+		{arvados.GroupContentsOptions{ClusterID: "zzzzz"}, true},
+		{arvados.GroupContentsOptions{UUID: arvadostest.ActiveUserUUID, BypassFederation: true}, false},
+	} {
+		local.APIStub = arvadostest.APIStub{}
+		remote.APIStub = arvadostest.APIStub{}
+		_, err := s.fed.GroupContents(s.ctx, trial.opts)
+		c.Check(err, check.IsNil)
+		c.Check(remote.Calls(nil), check.HasLen, map[bool]int{true: 1, false: 0}[trial.remote])
+		c.Check(local.Calls(nil), check.HasLen, map[bool]int{true: 0, false: 1}[trial.remote])
+	}
+}
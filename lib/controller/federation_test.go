@@ -351,8 +351,15 @@ func (s *FederationSuite) localServiceHandler(c *check.C, h http.Handler) *https
 func (s *FederationSuite) localServiceReturns404(c *check.C) *httpserver.Server {
 	return s.localServiceHandler(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path == "/arvados/v1/api_client_authorizations/current" {
-			if req.Header.Get("Authorization") == "Bearer "+arvadostest.ActiveToken {
-				json.NewEncoder(w).Encode(arvados.APIClientAuthorization{UUID: arvadostest.ActiveTokenUUID, APIToken: arvadostest.ActiveToken})
+			// Accept the active user's token in v1 or v2
+			// format, with or without a container UUID
+			// suffix.
+			token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if sp := strings.Split(token, "/"); len(sp) >= 3 {
+				token = sp[2]
+			}
+			if token == arvadostest.ActiveToken {
+				json.NewEncoder(w).Encode(arvados.APIClientAuthorization{UUID: arvadostest.ActiveTokenUUID, APIToken: arvadostest.ActiveToken, Scopes: []string{"all"}})
 			} else {
 				w.WriteHeader(http.StatusUnauthorized)
 			}
@@ -87,6 +87,10 @@ func (h *Handler) setup() {
 		Routes: health.Routes{"ping": func() error { _, err := h.db(context.TODO()); return err }},
 	})
 
+	hs := http.NotFoundHandler()
+	hs = prepend(hs, h.proxyRailsAPI)
+	hs = h.setupProxyRemoteCluster(hs)
+
 	rtr := router.New(federation.New(h.Cluster), ctrlctx.WrapCallsInTransactions(h.db))
 	mux.Handle("/arvados/v1/config", rtr)
 	mux.Handle("/"+arvados.EndpointUserAuthenticate.Path, rtr)
@@ -94,15 +98,20 @@ func (h *Handler) setup() {
 	if !h.Cluster.ForceLegacyAPI14 {
 		mux.Handle("/arvados/v1/collections", rtr)
 		mux.Handle("/arvados/v1/collections/", rtr)
+		mux.Handle("/arvados/v1/container_requests", rtr)
+		mux.Handle("/arvados/v1/container_requests/", rtr)
+		mux.Handle("/arvados/v1/groups", rtr)
+		mux.Handle("/arvados/v1/groups/", rtr)
+		// groups/shared is not implemented by the router; it
+		// would otherwise be mistaken for a get request with
+		// uuid "shared".
+		mux.Handle("/arvados/v1/groups/shared", hs)
 		mux.Handle("/arvados/v1/users", rtr)
 		mux.Handle("/arvados/v1/users/", rtr)
 		mux.Handle("/login", rtr)
 		mux.Handle("/logout", rtr)
 	}
 
-	hs := http.NotFoundHandler()
-	hs = prepend(hs, h.proxyRailsAPI)
-	hs = h.setupProxyRemoteCluster(hs)
 	mux.Handle("/", hs)
 	h.handlerStack = mux
 
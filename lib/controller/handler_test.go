@@ -143,6 +143,18 @@ func (s *HandlerSuite) TestProxyWithToken(c *check.C) {
 	c.Check(u.UUID, check.Equals, arvadostest.ActiveUserUUID)
 }
 
+func (s *HandlerSuite) TestProxyGroupsShared(c *check.C) {
+	req := httptest.NewRequest("GET", "/arvados/v1/groups/shared", nil)
+	req.Header.Set("Authorization", "Bearer "+arvadostest.ActiveToken)
+	resp := httptest.NewRecorder()
+	s.handler.ServeHTTP(resp, req)
+	c.Check(resp.Code, check.Equals, http.StatusOK)
+	var gl arvados.GroupList
+	err := json.Unmarshal(resp.Body.Bytes(), &gl)
+	c.Check(err, check.IsNil)
+	c.Check(gl.Items, check.NotNil)
+}
+
 func (s *HandlerSuite) TestProxyWithTokenInRequestBody(c *check.C) {
 	req := httptest.NewRequest("POST", "/arvados/v1/users/current", strings.NewReader(url.Values{
 		"_method":   {"GET"},
@@ -0,0 +1,54 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/jmcvetta/randutil"
+	"github.com/jmoiron/sqlx"
+)
+
+// CreateAPIToken inserts a new token for the given user directly
+// into the database, and returns it. The token expires in two
+// weeks. If scopes is empty, the token has scope ["all"].
+func CreateAPIToken(ctx context.Context, db sqlx.ExecerContext, clusterID, userUUID string, scopes []string) (*arvados.APIClientAuthorization, error) {
+	rd, err := randutil.String(15, "abcdefghijklmnopqrstuvwxyz0123456789")
+	if err != nil {
+		return nil, err
+	}
+	uuid := fmt.Sprintf("%v-gj3su-%v", clusterID, rd)
+	token, err := randutil.String(50, "abcdefghijklmnopqrstuvwxyz0123456789")
+	if err != nil {
+		return nil, err
+	}
+	if len(scopes) == 0 {
+		scopes = append(scopes, "all")
+	}
+	scopesjson, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO api_client_authorizations
+(uuid, api_token, expires_at, scopes,
+user_id,
+api_client_id, created_at, updated_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP AT TIME ZONE 'UTC' + INTERVAL '2 weeks', $3,
+(SELECT id FROM users WHERE users.uuid=$4 LIMIT 1),
+0, CURRENT_TIMESTAMP AT TIME ZONE 'UTC', CURRENT_TIMESTAMP AT TIME ZONE 'UTC')`,
+		uuid, token, string(scopesjson), userUUID)
+	if err != nil {
+		return nil, err
+	}
+	return &arvados.APIClientAuthorization{
+		UUID:     uuid,
+		APIToken: token,
+		Scopes:   scopes,
+	}, nil
+}
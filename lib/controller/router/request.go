@@ -164,9 +164,11 @@ var intParams = map[string]bool{
 
 var boolParams = map[string]bool{
 	"distinct":                true,
+	"exclude_home_project":    true,
 	"ensure_unique_name":      true,
 	"include_trash":           true,
 	"include_old_versions":    true,
+	"recursive":               true,
 	"redirect_to_new_user":    true,
 	"send_notification_email": true,
 	"bypass_federation":       true,
@@ -31,6 +31,9 @@ func (rtr *router) responseOptions(opts interface{}) (responseOptions, error) {
 	case *arvados.ListOptions:
 		rOpts.Select = opts.Select
 		rOpts.Count = opts.Count
+	case *arvados.GroupContentsOptions:
+		rOpts.Select = opts.Select
+		rOpts.Count = opts.Count
 	}
 	return rOpts, nil
 }
@@ -75,7 +78,10 @@ func (rtr *router) sendResponse(w http.ResponseWriter, req *http.Request, resp i
 		tmp["kind"] = respKind
 	}
 	defaultItemKind := ""
-	if strings.HasSuffix(respKind, "List") {
+	if respKind == "arvados#objectList" {
+		// Items can be of any type, and already have
+		// their own "kind" keys.
+	} else if strings.HasSuffix(respKind, "List") {
 		defaultItemKind = strings.TrimSuffix(respKind, "List")
 	}
 
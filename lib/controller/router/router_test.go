@@ -120,6 +120,60 @@ func (s *RouterSuite) TestOptions(c *check.C) {
 			shouldCall:  "CollectionList",
 			withOptions: arvados.ListOptions{Limit: 123, Offset: 456, IncludeTrash: true, IncludeOldVersions: true},
 		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/container_requests/" + arvadostest.QueuedContainerRequestUUID,
+			shouldCall:  "ContainerRequestGet",
+			withOptions: arvados.GetOptions{UUID: arvadostest.QueuedContainerRequestUUID},
+		},
+		{
+			method:      "PATCH",
+			path:        "/arvados/v1/container_requests/" + arvadostest.QueuedContainerRequestUUID,
+			shouldCall:  "ContainerRequestUpdate",
+			withOptions: arvados.UpdateOptions{UUID: arvadostest.QueuedContainerRequestUUID},
+		},
+		{
+			method:      "DELETE",
+			path:        "/arvados/v1/container_requests/" + arvadostest.QueuedContainerRequestUUID,
+			shouldCall:  "ContainerRequestDelete",
+			withOptions: arvados.DeleteOptions{UUID: arvadostest.QueuedContainerRequestUUID},
+		},
+		{
+			method:      "POST",
+			path:        "/arvados/v1/container_requests?cluster_id=zzzzz",
+			shouldCall:  "ContainerRequestCreate",
+			withOptions: arvados.CreateOptions{ClusterID: "zzzzz"},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/container_requests",
+			shouldCall:  "ContainerRequestList",
+			withOptions: arvados.ListOptions{Limit: -1},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID,
+			shouldCall:  "GroupGet",
+			withOptions: arvados.GetOptions{UUID: arvadostest.AProjectUUID},
+		},
+		{
+			method:      "POST",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID + "/trash",
+			shouldCall:  "GroupTrash",
+			withOptions: arvados.DeleteOptions{UUID: arvadostest.AProjectUUID},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/contents?uuid=" + arvadostest.AProjectUUID + "&limit=10&include=owner_uuid",
+			shouldCall:  "GroupContents",
+			withOptions: arvados.GroupContentsOptions{UUID: arvadostest.AProjectUUID, Limit: 10, Include: "owner_uuid"},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID + "/contents?recursive=true",
+			shouldCall:  "GroupContents",
+			withOptions: arvados.GroupContentsOptions{UUID: arvadostest.AProjectUUID, Limit: -1, Recursive: true},
+		},
 		{
 			method:       "PATCH",
 			path:         "/arvados/v1/collections",
@@ -0,0 +1,292 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+)
+
+// Default time to wait for a container to write a checkpoint after
+// a preemption notice. Cloud providers typically give 30 seconds
+// (GCE, Azure) to 2 minutes (AWS) notice.
+const defaultCheckpointGracePeriod = 25 * time.Second
+
+// preemptionWatcher polls a cloud provider's instance metadata
+// service to find out when the instance is about to be reclaimed.
+//
+// Examples:
+//
+//	http://169.254.169.254/latest/meta-data/spot/instance-action (AWS)
+//	http://metadata.google.internal/computeMetadata/v1/instance/preempted (GCE)
+//
+// A notice is considered to have been issued when the URL returns
+// 200 with a response body other than "FALSE". Any other response
+// (such as AWS's 404) means no notice has been issued yet.
+type preemptionWatcher struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	Logf     func(string, ...interface{})
+}
+
+// Watch polls the metadata URL until a preemption notice is issued
+// (in which case it calls notify) or ctx is done.
+func (pw *preemptionWatcher) Watch(ctx context.Context, notify func()) {
+	interval := pw.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	client := pw.Client
+	if client == nil {
+		client = &http.Client{Timeout: interval}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	errorLogged := false
+	for {
+		notice, err := pw.check(ctx, client)
+		if err != nil && !errorLogged {
+			// Log the first error only, to avoid filling
+			// the log if the metadata service is
+			// unreachable.
+			pw.Logf("error checking for preemption notice: %s", err)
+			errorLogged = true
+		} else if notice {
+			notify()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pw *preemptionWatcher) check(ctx context.Context, client *http.Client) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pw.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	// Required by the GCE metadata service, ignored by others.
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+	return !strings.EqualFold(strings.TrimSpace(string(body)), "false"), nil
+}
+
+// notifyPreemption tells WaitFinish that the instance is about to be
+// reclaimed. It is safe to call more than once.
+func (runner *ContainerRunner) notifyPreemption() {
+	runner.preemptionOnce.Do(func() {
+		runner.CrunchLog.Printf("preemption notice received")
+		close(runner.preemptionNotice)
+	})
+}
+
+// checkpointCRProperty is the container request property where
+// saveCheckpoint records the UUID of the checkpoint collection, so
+// the next attempt to run the container request can find it.
+const checkpointCRProperty = "checkpoint_collection_uuid"
+
+// checkpointProperties returns the properties that identify a
+// checkpoint collection saved by a container on behalf of the given
+// container requests.
+func checkpointProperties(containerUUID string, crs []arvados.ContainerRequest) arvadosclient.Dict {
+	crUUIDs := []string{}
+	for _, cr := range crs {
+		crUUIDs = append(crUUIDs, cr.UUID)
+	}
+	return arvadosclient.Dict{
+		"type":                    "checkpoint",
+		"container_uuid":          containerUUID,
+		"container_request_uuids": crUUIDs,
+	}
+}
+
+// containerRequests returns the container requests that are using
+// this container, sorted by UUID.
+func (runner *ContainerRunner) containerRequests() ([]arvados.ContainerRequest, error) {
+	var list arvados.ContainerRequestList
+	err := runner.DispatcherArvClient.Call("GET", "container_requests", "", "", arvadosclient.Dict{
+		"filters": [][]interface{}{{"container_uuid", "=", runner.Container.UUID}},
+		"select":  []string{"uuid", "owner_uuid", "properties"},
+		"order":   []string{"uuid"},
+		"count":   "none",
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// findCheckpoint returns the portable data hash of the most recent
+// checkpoint saved by a previous attempt to run one of this
+// container's container requests, or "" if there is none.
+//
+// Only the checkpoint collections recorded in the container
+// requests' properties are considered. Each one is retrieved using
+// the container's own token, and ignored unless it is owned by the
+// container request's owner.
+func (runner *ContainerRunner) findCheckpoint(crs []arvados.ContainerRequest) (string, error) {
+	var found *arvados.Collection
+	for _, cr := range crs {
+		uuid, _ := cr.Properties[checkpointCRProperty].(string)
+		if uuid == "" {
+			continue
+		}
+		var coll arvados.Collection
+		err := runner.ContainerArvClient.Get("collections", uuid, arvadosclient.Dict{
+			"select": []string{"uuid", "owner_uuid", "portable_data_hash", "properties", "created_at"},
+		}, &coll)
+		if err != nil {
+			return "", fmt.Errorf("error retrieving checkpoint %s recorded by container request %s: %w", uuid, cr.UUID, err)
+		}
+		if coll.OwnerUUID != cr.OwnerUUID {
+			runner.CrunchLog.Printf("ignoring checkpoint %s recorded by container request %s: owner %s does not match container request owner %s", uuid, cr.UUID, coll.OwnerUUID, cr.OwnerUUID)
+			continue
+		}
+		if found == nil || coll.CreatedAt.After(found.CreatedAt) {
+			found = &coll
+		}
+	}
+	if found == nil {
+		return "", nil
+	}
+	runner.CrunchLog.Printf("restoring checkpoint %s (%s) saved by container %v", found.UUID, found.PortableDataHash, found.Properties["container_uuid"])
+	return found.PortableDataHash, nil
+}
+
+// saveCheckpoint saves the contents of the checkpoint directory as a
+// new collection, if a checkpoint was requested.
+func (runner *ContainerRunner) saveCheckpoint() error {
+	runner.cStateLock.Lock()
+	requested := runner.checkpointRequested
+	runner.cStateLock.Unlock()
+	if !requested || runner.checkpointHostDir == "" {
+		return nil
+	}
+	fs, err := (&arvados.Collection{}).FileSystem(runner.containerClient, runner.ContainerKeepClient)
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(runner.checkpointHostDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(runner.checkpointHostDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if fi.IsDir() {
+			return fs.Mkdir(rel, 0755)
+		} else if !fi.Mode().IsRegular() {
+			runner.CrunchLog.Printf("checkpoint: skipping %q (not a regular file or directory)", rel)
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := fs.OpenFile(rel, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("error copying checkpoint directory: %w", err)
+	}
+	txt, err := fs.MarshalManifest(".")
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint data: %w", err)
+	}
+	coll := arvadosclient.Dict{
+		"name":          "checkpoint for " + runner.Container.UUID,
+		"manifest_text": txt,
+		"properties":    checkpointProperties(runner.Container.UUID, runner.checkpointCRs),
+	}
+	ownerUUID := ""
+	if len(runner.checkpointCRs) > 0 {
+		// findCheckpoint only accepts a checkpoint owned by
+		// the container request's owner.
+		ownerUUID = runner.checkpointCRs[0].OwnerUUID
+		coll["owner_uuid"] = ownerUUID
+	}
+	var resp arvados.Collection
+	err = runner.ContainerArvClient.Create("collections", arvadosclient.Dict{
+		"ensure_unique_name": true,
+		"collection":         coll,
+	}, &resp)
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint collection: %w", err)
+	}
+	runner.CrunchLog.Printf("saved checkpoint %s (%s)", resp.UUID, resp.PortableDataHash)
+
+	for _, cr := range runner.checkpointCRs {
+		if cr.OwnerUUID != ownerUUID {
+			// The next attempt would ignore the
+			// checkpoint anyway.
+			continue
+		}
+		err := runner.recordCheckpoint(cr.UUID, resp.UUID)
+		if err != nil {
+			runner.CrunchLog.Printf("error recording checkpoint %s in container request %s: %v", resp.UUID, cr.UUID, err)
+		}
+	}
+	return nil
+}
+
+// recordCheckpoint adds the given checkpoint collection UUID to a
+// container request's properties.
+func (runner *ContainerRunner) recordCheckpoint(crUUID, collUUID string) error {
+	// Get the current properties (rather than using the ones
+	// retrieved before the container started) to avoid undoing
+	// changes made while the container was running.
+	var cr arvados.ContainerRequest
+	err := runner.ContainerArvClient.Get("container_requests", crUUID, arvadosclient.Dict{
+		"select": []string{"uuid", "properties"},
+	}, &cr)
+	if err != nil {
+		return err
+	}
+	props := arvadosclient.Dict{}
+	for k, v := range cr.Properties {
+		props[k] = v
+	}
+	props[checkpointCRProperty] = collUUID
+	return runner.ContainerArvClient.Update("container_requests", crUUID, arvadosclient.Dict{
+		"container_request": arvadosclient.Dict{
+			"properties": props,
+		},
+	}, nil)
+}
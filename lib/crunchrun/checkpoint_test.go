@@ -0,0 +1,220 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&preemptionWatcherSuite{})
+
+type preemptionWatcherSuite struct{}
+
+func (s *preemptionWatcherSuite) TestWatch(c *C) {
+	for _, trial := range []struct {
+		status int
+		body   string
+		notice bool
+	}{
+		{http.StatusNotFound, "", false},
+		{http.StatusInternalServerError, "", false},
+		{http.StatusOK, "FALSE", false},
+		{http.StatusOK, "TRUE", true},
+		{http.StatusOK, `{"action": "terminate", "time": "2017-09-18T08:22:00Z"}`, true},
+	} {
+		c.Logf("trial: %+v", trial)
+		var mtx sync.Mutex
+		requests := 0
+		issued := false
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			c.Check(req.Header.Get("Metadata-Flavor"), Equals, "Google")
+			requests++
+			if requests < 3 {
+				// No notice issued yet.
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			issued = true
+			w.WriteHeader(trial.status)
+			w.Write([]byte(trial.body))
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		notified := make(chan struct{})
+		go func() {
+			(&preemptionWatcher{
+				URL:      srv.URL,
+				Interval: time.Millisecond,
+				Logf:     c.Logf,
+			}).Watch(ctx, func() { close(notified) })
+		}()
+		if trial.notice {
+			select {
+			case <-notified:
+			case <-time.After(10 * time.Second):
+				c.Error("timed out waiting for notification")
+			}
+		} else {
+			for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				mtx.Lock()
+				done := requests > 5
+				mtx.Unlock()
+				if done {
+					break
+				}
+			}
+			select {
+			case <-notified:
+				c.Error("unexpected notification")
+			default:
+			}
+		}
+		cancel()
+		srv.Close()
+		mtx.Lock()
+		c.Check(issued, Equals, true)
+		mtx.Unlock()
+	}
+}
+
+// Owner of the container request returned by the stub API in
+// ArvTestClient.
+const checkpointOwnerUUID = "zzzzz-j7d0g-checkpointowner"
+
+const checkpointContainerRecord = `{
+    "command": ["sleep", "1000"],
+    "container_image": "d4ab34d3d4f8a72f5c4973051ae69fab+122",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {"/tmp": {"kind": "tmp"} },
+    "output_path": "/tmp",
+    "priority": 1,
+    "runtime_constraints": {},
+    "scheduling_parameters": {"preemptible": true, "checkpoint_path": "/checkpoint"},
+    "state": "Locked"
+}`
+
+func (s *TestSuite) TestPreemptionSavesCheckpoint(c *C) {
+	api, cr, _ := s.fullRunHelper(c, checkpointContainerRecord, nil, 143, func(t *TestDockerClient) {
+		c.Check(strings.Join(s.runner.Binds, "\n"), Matches, `(?ms).*`+s.runner.checkpointHostDir+`:/checkpoint$.*`)
+		s.runner.notifyPreemption()
+		select {
+		case sig := <-t.signals:
+			c.Check(sig, Equals, "15")
+		case <-time.After(10 * time.Second):
+			c.Error("timed out waiting for container to be signalled")
+		}
+		err := os.Mkdir(filepath.Join(s.runner.checkpointHostDir, "dir"), 0777)
+		c.Check(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(s.runner.checkpointHostDir, "dir", "state.txt"), []byte("step 3\n"), 0666)
+		c.Check(err, IsNil)
+		t.logWriter.Close()
+	})
+	c.Check(cr.checkpointRequested, Equals, true)
+	c.Check(api.CalledWith("container.state", "Cancelled"), NotNil)
+	c.Check(api.CalledWith("container.state", "Complete"), IsNil)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*Instance is being preempted.*`)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*saved checkpoint zzzzz-4zz18-\w+ \(\w+\+\d+\).*`)
+
+	var created, recorded arvadosclient.Dict
+	for _, content := range cr.ContainerArvClient.(*ArvTestClient).Content {
+		if coll, ok := content["collection"].(arvadosclient.Dict); ok {
+			created = coll
+		} else if req, ok := content["container_request"].(arvadosclient.Dict); ok {
+			recorded = req
+		}
+	}
+	if c.Check(created, NotNil) {
+		c.Check(created["owner_uuid"], Equals, checkpointOwnerUUID)
+		c.Check(created["properties"].(arvadosclient.Dict)["container_request_uuids"], DeepEquals, []string{"zzzzz-xvhdp-zzzzzzzzzzzzzzz"})
+	}
+	if c.Check(recorded, NotNil) {
+		c.Check(recorded["properties"].(arvadosclient.Dict)[checkpointCRProperty], Matches, `zzzzz-4zz18-\w+`)
+	}
+}
+
+func (s *TestSuite) TestPreemptionTimeout(c *C) {
+	api, _, _ := s.fullRunHelper(c, checkpointContainerRecord, nil, 143, func(t *TestDockerClient) {
+		s.runner.checkpointGracePeriod = 100 * time.Millisecond
+		s.runner.notifyPreemption()
+		// Ignore SIGTERM; crunch-run should stop the
+		// container after the grace period.
+		<-t.stop
+		t.logWriter.Close()
+	})
+	c.Check(api.CalledWith("container.state", "Cancelled"), NotNil)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*did not exit within checkpoint grace period.*`)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*saved checkpoint.*`)
+}
+
+func (s *TestSuite) TestPreemptionWithoutCheckpoint(c *C) {
+	api, _, _ := s.fullRunHelper(c, strings.Replace(checkpointContainerRecord, `"checkpoint_path": "/checkpoint"`, `"max_run_time": 0`, 1), nil, 137, func(t *TestDockerClient) {
+		s.runner.notifyPreemption()
+		<-t.stop
+		t.logWriter.Close()
+	})
+	c.Check(api.CalledWith("container.state", "Cancelled"), NotNil)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*Instance is being preempted. Stopping container.*`)
+	c.Check(api.Logs["crunch-run"].String(), Not(Matches), `(?ms).*saved checkpoint.*`)
+}
+
+func (s *TestSuite) TestRestoreCheckpoint(c *C) {
+	s.checkpoint = &arvados.Collection{
+		UUID:             "zzzzz-4zz18-checkpoint00001",
+		OwnerUUID:        checkpointOwnerUUID,
+		PortableDataHash: otherPDH,
+		Properties:       map[string]interface{}{"type": "checkpoint", "container_uuid": "zzzzz-dz642-previousattempt"},
+	}
+	api, _, _ := s.fullRunHelper(c, checkpointContainerRecord, []string{otherPDH + "/dir/subdir"}, 0, func(t *TestDockerClient) {
+		fi, err := os.Stat(filepath.Join(s.runner.checkpointHostDir, "dir", "subdir"))
+		if c.Check(err, IsNil) {
+			c.Check(fi.IsDir(), Equals, true)
+		}
+		t.logWriter.Close()
+	})
+	c.Check(api.CalledWith("container.state", "Complete"), NotNil)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*restoring checkpoint zzzzz-4zz18-checkpoint00001 \(`+regexp.QuoteMeta(otherPDH)+`\) saved by container zzzzz-dz642-previousattempt.*`)
+	c.Check(api.Logs["crunch-run"].String(), Not(Matches), `(?ms).*saved checkpoint.*`)
+}
+
+func (s *TestSuite) TestRestoreCheckpointWrongOwner(c *C) {
+	s.checkpoint = &arvados.Collection{
+		UUID:             "zzzzz-4zz18-checkpoint00001",
+		OwnerUUID:        "zzzzz-tpzed-someotheruser0",
+		PortableDataHash: otherPDH,
+		Properties:       map[string]interface{}{"type": "checkpoint", "container_uuid": "zzzzz-dz642-previousattempt"},
+	}
+	api, _, _ := s.fullRunHelper(c, checkpointContainerRecord, []string{otherPDH + "/dir/subdir"}, 0, func(t *TestDockerClient) {
+		_, err := os.Stat(filepath.Join(s.runner.checkpointHostDir, "dir"))
+		c.Check(os.IsNotExist(err), Equals, true)
+		t.logWriter.Close()
+	})
+	c.Check(api.CalledWith("container.state", "Complete"), NotNil)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*ignoring checkpoint zzzzz-4zz18-checkpoint00001 recorded by container request zzzzz-xvhdp-zzzzzzzzzzzzzzz: owner zzzzz-tpzed-someotheruser0 does not match.*`)
+	c.Check(api.Logs["crunch-run"].String(), Not(Matches), `(?ms).*restoring checkpoint.*`)
+}
+
+func (s *TestSuite) TestCheckpointPathConflicts(c *C) {
+	for _, ckpt := range []string{"checkpoint", "/tmp", "/tmp/checkpoint", "/keep", "/keep/foo", "/"} {
+		record := strings.Replace(checkpointContainerRecord, `"/checkpoint"`, `"`+ckpt+`"`, 1)
+		record = strings.Replace(record, `"mounts": {`, `"mounts": {"/keep/foo": {"kind": "tmp"}, `, 1)
+		_, _, err := s.stdoutErrorRunHelper(c, record, func(t *TestDockerClient) {})
+		c.Check(err, ErrorMatches, `.*checkpoint_path "`+ckpt+`".*`)
+	}
+}
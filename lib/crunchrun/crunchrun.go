@@ -34,11 +34,6 @@ import (
 	"git.arvados.org/arvados.git/sdk/go/keepclient"
 	"git.arvados.org/arvados.git/sdk/go/manifest"
 	"golang.org/x/net/context"
-
-	dockertypes "github.com/docker/docker/api/types"
-	dockercontainer "github.com/docker/docker/api/types/container"
-	dockernetwork "github.com/docker/docker/api/types/network"
-	dockerclient "github.com/docker/docker/client"
 )
 
 type command struct{}
@@ -74,20 +69,6 @@ type RunArvMount func(args []string, tok string) (*exec.Cmd, error)
 
 type MkTempDir func(string, string) (string, error)
 
-// ThinDockerClient is the minimal Docker client interface used by crunch-run.
-type ThinDockerClient interface {
-	ContainerAttach(ctx context.Context, container string, options dockertypes.ContainerAttachOptions) (dockertypes.HijackedResponse, error)
-	ContainerCreate(ctx context.Context, config *dockercontainer.Config, hostConfig *dockercontainer.HostConfig,
-		networkingConfig *dockernetwork.NetworkingConfig, containerName string) (dockercontainer.ContainerCreateCreatedBody, error)
-	ContainerStart(ctx context.Context, container string, options dockertypes.ContainerStartOptions) error
-	ContainerRemove(ctx context.Context, container string, options dockertypes.ContainerRemoveOptions) error
-	ContainerWait(ctx context.Context, container string, condition dockercontainer.WaitCondition) (<-chan dockercontainer.ContainerWaitOKBody, <-chan error)
-	ContainerInspect(ctx context.Context, id string) (dockertypes.ContainerJSON, error)
-	ImageInspectWithRaw(ctx context.Context, image string) (dockertypes.ImageInspect, []byte, error)
-	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (dockertypes.ImageLoadResponse, error)
-	ImageRemove(ctx context.Context, image string, options dockertypes.ImageRemoveOptions) ([]dockertypes.ImageDeleteResponseItem, error)
-}
-
 type PsProcess interface {
 	CmdlineSlice() ([]string, error)
 }
@@ -95,7 +76,7 @@ type PsProcess interface {
 // ContainerRunner is the main stateful struct used for a single execution of a
 // container.
 type ContainerRunner struct {
-	Docker ThinDockerClient
+	executor containerExecutor
 
 	// Dispatcher client is initialized with the Dispatcher token.
 	// This is a privileged token used to manage container status
@@ -119,35 +100,32 @@ type ContainerRunner struct {
 	ContainerArvClient  IArvadosClient
 	ContainerKeepClient IKeepClient
 
-	Container       arvados.Container
-	ContainerConfig dockercontainer.Config
-	HostConfig      dockercontainer.HostConfig
-	token           string
-	ContainerID     string
-	ExitCode        *int
-	NewLogWriter    NewLogWriter
-	loggingDone     chan bool
-	CrunchLog       *ThrottledLogger
-	Stdout          io.WriteCloser
-	Stderr          io.WriteCloser
-	logUUID         string
-	logMtx          sync.Mutex
-	LogCollection   arvados.CollectionFileSystem
-	LogsPDH         *string
-	RunArvMount     RunArvMount
-	MkTempDir       MkTempDir
-	ArvMount        *exec.Cmd
-	ArvMountPoint   string
-	HostOutputDir   string
-	Binds           []string
-	Volumes         map[string]struct{}
-	OutputPDH       *string
-	SigChan         chan os.Signal
-	ArvMountExit    chan error
-	SecretMounts    map[string]arvados.Mount
-	MkArvClient     func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error)
-	finalState      string
-	parentTemp      string
+	Container     arvados.Container
+	token         string
+	executorSpec  containerSpec
+	ExitCode      *int
+	NewLogWriter  NewLogWriter
+	CrunchLog     *ThrottledLogger
+	Stdout        io.WriteCloser
+	Stderr        io.WriteCloser
+	logUUID       string
+	logMtx        sync.Mutex
+	LogCollection arvados.CollectionFileSystem
+	LogsPDH       *string
+	structuredLog *structuredLogWriter
+	RunArvMount   RunArvMount
+	MkTempDir     MkTempDir
+	ArvMount      *exec.Cmd
+	ArvMountPoint string
+	HostOutputDir string
+	Binds         []string
+	OutputPDH     *string
+	SigChan       chan os.Signal
+	ArvMountExit  chan error
+	SecretMounts  map[string]arvados.Mount
+	MkArvClient   func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error)
+	finalState    string
+	parentTemp    string
 
 	statLogger       io.WriteCloser
 	statReporter     *crunchstat.Reporter
@@ -170,48 +148,69 @@ type ContainerRunner struct {
 	setCgroupParent string
 
 	cStateLock sync.Mutex
+	cCreated   bool // executor.Create() succeeded
 	cCancelled bool // StopContainer() invoked
-	cRemoved   bool // docker confirmed the container no longer exists
+	cRemoved   bool // executor confirmed the container no longer exists
 
 	enableNetwork string // one of "default" or "always"
-	networkMode   string // passed through to HostConfig.NetworkMode
+	networkMode   string // passed through to containerSpec.NetworkMode
 	arvMountLog   *ThrottledLogger
 
-	containerWatchdogInterval time.Duration
+	// Closed when the instance is about to be reclaimed by the
+	// cloud provider (see notifyPreemption).
+	preemptionNotice chan struct{}
+	preemptionOnce   sync.Once
+
+	// Host directory bind-mounted at the container's
+	// SchedulingParameters.CheckpointPath, if any.
+	checkpointHostDir string
+	// Container requests the checkpoint is saved on behalf of.
+	checkpointCRs []arvados.ContainerRequest
+	// Time to wait for the container to exit after asking it to
+	// write a checkpoint.
+	checkpointGracePeriod time.Duration
+	// The container was asked to write a checkpoint (protected
+	// by cStateLock).
+	checkpointRequested bool
 }
 
 // setupSignals sets up signal handling to gracefully terminate the underlying
-// Docker container and update state when receiving a TERM, INT or QUIT signal.
+// container and update state when receiving a TERM, INT or QUIT signal.
 func (runner *ContainerRunner) setupSignals() {
 	runner.SigChan = make(chan os.Signal, 1)
 	signal.Notify(runner.SigChan, syscall.SIGTERM)
 	signal.Notify(runner.SigChan, syscall.SIGINT)
 	signal.Notify(runner.SigChan, syscall.SIGQUIT)
+	signal.Notify(runner.SigChan, syscall.SIGUSR2)
 
 	go func(sig chan os.Signal) {
 		for s := range sig {
+			if s == syscall.SIGUSR2 {
+				// Preemption notice from an external
+				// source, e.g., a node agent.
+				runner.notifyPreemption()
+				continue
+			}
 			runner.stop(s)
 		}
 	}(runner.SigChan)
 }
 
-// stop the underlying Docker container.
+// stop the underlying container.
 func (runner *ContainerRunner) stop(sig os.Signal) {
 	runner.cStateLock.Lock()
 	defer runner.cStateLock.Unlock()
 	if sig != nil {
 		runner.CrunchLog.Printf("caught signal: %v", sig)
 	}
-	if runner.ContainerID == "" {
+	if !runner.cCreated {
 		return
 	}
 	runner.cCancelled = true
-	runner.CrunchLog.Printf("removing container")
-	err := runner.Docker.ContainerRemove(context.TODO(), runner.ContainerID, dockertypes.ContainerRemoveOptions{Force: true})
+	err := runner.executor.Stop()
 	if err != nil {
-		runner.CrunchLog.Printf("error removing container: %s", err)
-	}
-	if err == nil || strings.Contains(err.Error(), "No such container: "+runner.ContainerID) {
+		runner.CrunchLog.Printf("error stopping container: %s", err)
+	} else {
 		runner.cRemoved = true
 	}
 }
@@ -258,7 +257,7 @@ func (runner *ContainerRunner) checkBrokenNode(goterr error) bool {
 }
 
 // LoadImage determines the docker image id from the container record and
-// checks if it is available in the local Docker image store.  If not, it loads
+// checks if it is available to the container executor.  If not, it loads
 // the image from Keep.
 func (runner *ContainerRunner) LoadImage() (err error) {
 
@@ -281,32 +280,14 @@ func (runner *ContainerRunner) LoadImage() (err error) {
 
 	runner.CrunchLog.Printf("Using Docker image id '%s'", imageID)
 
-	_, _, err = runner.Docker.ImageInspectWithRaw(context.TODO(), imageID)
+	err = runner.executor.LoadImage(imageID, func() (io.ReadCloser, error) {
+		return runner.ContainerKeepClient.ManifestFileReader(manifest, img)
+	})
 	if err != nil {
-		runner.CrunchLog.Print("Loading Docker image from keep")
-
-		var readCloser io.ReadCloser
-		readCloser, err = runner.ContainerKeepClient.ManifestFileReader(manifest, img)
-		if err != nil {
-			return fmt.Errorf("While creating ManifestFileReader for container image: %v", err)
-		}
-
-		response, err := runner.Docker.ImageLoad(context.TODO(), readCloser, true)
-		if err != nil {
-			return fmt.Errorf("While loading container image into Docker: %v", err)
-		}
-
-		defer response.Body.Close()
-		rbody, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			return fmt.Errorf("Reading response to image load: %v", err)
-		}
-		runner.CrunchLog.Printf("Docker response: %s", rbody)
-	} else {
-		runner.CrunchLog.Print("Docker image is available")
+		return err
 	}
 
-	runner.ContainerConfig.Image = imageID
+	runner.executorSpec.Image = imageID
 
 	runner.ContainerKeepClient.ClearBlockCache()
 
@@ -441,7 +422,6 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 
 	collectionPaths := []string{}
 	runner.Binds = nil
-	runner.Volumes = make(map[string]struct{})
 	needCertMount := true
 	type copyFile struct {
 		src  string
@@ -621,6 +601,45 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 		return fmt.Errorf("Output path does not correspond to a writable mount point")
 	}
 
+	if ckpt := runner.Container.SchedulingParameters.CheckpointPath; ckpt != "" {
+		if !path.IsAbs(ckpt) {
+			return fmt.Errorf("checkpoint_path %q is not an absolute path", ckpt)
+		}
+		ckpt = path.Clean(ckpt)
+		if ckpt == "/" {
+			return fmt.Errorf("checkpoint_path %q cannot be the root directory", ckpt)
+		}
+		if ckpt == runner.Container.OutputPath || strings.HasPrefix(ckpt, runner.Container.OutputPath+"/") {
+			return fmt.Errorf("checkpoint_path %q cannot be inside output_path", ckpt)
+		}
+		for _, bind := range binds {
+			if bind == ckpt || strings.HasPrefix(bind, ckpt+"/") || strings.HasPrefix(ckpt, bind+"/") {
+				return fmt.Errorf("checkpoint_path %q conflicts with mount %q", ckpt, bind)
+			}
+		}
+		tmpdir, err := runner.MkTempDir(runner.parentTemp, "checkpoint")
+		if err != nil {
+			return fmt.Errorf("creating checkpoint dir: %v", err)
+		}
+		err = os.Chmod(tmpdir, 0777|os.ModeSetgid)
+		if err != nil {
+			return fmt.Errorf("While Chmod checkpoint dir: %v", err)
+		}
+		runner.checkpointHostDir = tmpdir
+		runner.Binds = append(runner.Binds, fmt.Sprintf("%s:%s", tmpdir, ckpt))
+
+		runner.checkpointCRs, err = runner.containerRequests()
+		if err != nil {
+			runner.CrunchLog.Printf("error looking up container requests, not restoring checkpoint: %v", err)
+		} else if pdh, err := runner.findCheckpoint(runner.checkpointCRs); err != nil {
+			runner.CrunchLog.Printf("error looking up checkpoint, not restoring: %v", err)
+		} else if pdh != "" {
+			src := fmt.Sprintf("%s/by_id/%s", runner.ArvMountPoint, pdh)
+			collectionPaths = append(collectionPaths, src)
+			copyFiles = append(copyFiles, copyFile{src, tmpdir})
+		}
+	}
+
 	if wantAPI := runner.Container.RuntimeConstraints.API; needCertMount && wantAPI != nil && *wantAPI {
 		for _, certfile := range arvadosclient.CertFiles {
 			_, err := os.Stat(certfile)
@@ -691,54 +710,6 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 	return nil
 }
 
-func (runner *ContainerRunner) ProcessDockerAttach(containerReader io.Reader) {
-	// Handle docker log protocol
-	// https://docs.docker.com/engine/reference/api/docker_remote_api_v1.15/#attach-to-a-container
-	defer close(runner.loggingDone)
-
-	header := make([]byte, 8)
-	var err error
-	for err == nil {
-		_, err = io.ReadAtLeast(containerReader, header, 8)
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			break
-		}
-		readsize := int64(header[7]) | (int64(header[6]) << 8) | (int64(header[5]) << 16) | (int64(header[4]) << 24)
-		if header[0] == 1 {
-			// stdout
-			_, err = io.CopyN(runner.Stdout, containerReader, readsize)
-		} else {
-			// stderr
-			_, err = io.CopyN(runner.Stderr, containerReader, readsize)
-		}
-	}
-
-	if err != nil {
-		runner.CrunchLog.Printf("error reading docker logs: %v", err)
-	}
-
-	err = runner.Stdout.Close()
-	if err != nil {
-		runner.CrunchLog.Printf("error closing stdout logs: %v", err)
-	}
-
-	err = runner.Stderr.Close()
-	if err != nil {
-		runner.CrunchLog.Printf("error closing stderr logs: %v", err)
-	}
-
-	if runner.statReporter != nil {
-		runner.statReporter.Stop()
-		err = runner.statLogger.Close()
-		if err != nil {
-			runner.CrunchLog.Printf("error closing crunchstat logs: %v", err)
-		}
-	}
-}
-
 func (runner *ContainerRunner) stopHoststat() error {
 	if runner.hoststatReporter == nil {
 		return nil
@@ -773,7 +744,7 @@ func (runner *ContainerRunner) startCrunchstat() error {
 	}
 	runner.statLogger = NewThrottledLogger(w)
 	runner.statReporter = &crunchstat.Reporter{
-		CID:          runner.ContainerID,
+		CID:          runner.executor.CgroupID(),
 		Logger:       log.New(runner.statLogger, "", 0),
 		CgroupParent: runner.expectCgroupParent,
 		CgroupRoot:   runner.cgroupRoot,
@@ -936,13 +907,14 @@ func (runner *ContainerRunner) logAPIResponse(label, path string, params map[str
 	return true, nil
 }
 
-// AttachStreams connects the docker container stdin, stdout and stderr logs
-// to the Arvados logger which logs to Keep and the API server logs table.
+// AttachStreams connects the container's stdin, stdout and stderr
+// to the given stdin source (if any) and to the Arvados logger which
+// logs to Keep and the API server logs table.
 func (runner *ContainerRunner) AttachStreams() (err error) {
 
 	runner.CrunchLog.Print("Attaching container streams")
 
-	// If stdin mount is provided, attach it to the docker container
+	// If stdin mount is provided, attach it to the container
 	var stdinRdr arvados.File
 	var stdinJson []byte
 	if stdinMnt, ok := runner.Container.Mounts["stdin"]; ok {
@@ -973,15 +945,12 @@ func (runner *ContainerRunner) AttachStreams() (err error) {
 		}
 	}
 
-	stdinUsed := stdinRdr != nil || len(stdinJson) != 0
-	response, err := runner.Docker.ContainerAttach(context.TODO(), runner.ContainerID,
-		dockertypes.ContainerAttachOptions{Stream: true, Stdin: stdinUsed, Stdout: true, Stderr: true})
-	if err != nil {
-		return fmt.Errorf("While attaching container stdout/stderr streams: %v", err)
+	if stdinRdr != nil {
+		runner.executorSpec.Stdin = stdinRdr
+	} else if len(stdinJson) != 0 {
+		runner.executorSpec.Stdin = ioutil.NopCloser(bytes.NewReader(stdinJson))
 	}
 
-	runner.loggingDone = make(chan bool)
-
 	if stdoutMnt, ok := runner.Container.Mounts["stdout"]; ok {
 		stdoutFile, err := runner.getStdoutFile(stdoutMnt.Path)
 		if err != nil {
@@ -1006,29 +975,8 @@ func (runner *ContainerRunner) AttachStreams() (err error) {
 		runner.Stderr = NewThrottledLogger(w)
 	}
 
-	if stdinRdr != nil {
-		go func() {
-			_, err := io.Copy(response.Conn, stdinRdr)
-			if err != nil {
-				runner.CrunchLog.Printf("While writing stdin collection to docker container: %v", err)
-				runner.stop(nil)
-			}
-			stdinRdr.Close()
-			response.CloseWrite()
-		}()
-	} else if len(stdinJson) != 0 {
-		go func() {
-			_, err := io.Copy(response.Conn, bytes.NewReader(stdinJson))
-			if err != nil {
-				runner.CrunchLog.Printf("While writing stdin json to docker container: %v", err)
-				runner.stop(nil)
-			}
-			response.CloseWrite()
-		}()
-	}
-
-	go runner.ProcessDockerAttach(response.Reader)
-
+	runner.executorSpec.Stdout = runner.Stdout
+	runner.executorSpec.Stderr = runner.Stderr
 	return nil
 }
 
@@ -1057,87 +1005,65 @@ func (runner *ContainerRunner) getStdoutFile(mntPath string) (*os.File, error) {
 	return stdoutFile, nil
 }
 
-// CreateContainer creates the docker container.
+// CreateContainer creates the container.
 func (runner *ContainerRunner) CreateContainer() error {
-	runner.CrunchLog.Print("Creating Docker container")
-
-	runner.ContainerConfig.Cmd = runner.Container.Command
+	runner.executorSpec.Command = runner.Container.Command
+	runner.executorSpec.WorkingDir = ""
 	if runner.Container.Cwd != "." {
-		runner.ContainerConfig.WorkingDir = runner.Container.Cwd
+		runner.executorSpec.WorkingDir = runner.Container.Cwd
 	}
 
+	runner.executorSpec.Env = map[string]string{}
 	for k, v := range runner.Container.Environment {
-		runner.ContainerConfig.Env = append(runner.ContainerConfig.Env, k+"="+v)
+		runner.executorSpec.Env[k] = v
 	}
 
-	runner.ContainerConfig.Volumes = runner.Volumes
-
-	maxRAM := int64(runner.Container.RuntimeConstraints.RAM)
-	minDockerRAM := int64(16)
-	if maxRAM < minDockerRAM*1024*1024 {
-		// Docker daemon won't let you set a limit less than ~10 MiB
-		maxRAM = minDockerRAM * 1024 * 1024
-	}
-	runner.HostConfig = dockercontainer.HostConfig{
-		Binds: runner.Binds,
-		LogConfig: dockercontainer.LogConfig{
-			Type: "none",
-		},
-		Resources: dockercontainer.Resources{
-			CgroupParent: runner.setCgroupParent,
-			NanoCPUs:     int64(runner.Container.RuntimeConstraints.VCPUs) * 1000000000,
-			Memory:       maxRAM, // RAM
-			MemorySwap:   maxRAM, // RAM+swap
-			KernelMemory: maxRAM, // kernel portion
-		},
+	binds, err := parseBinds(runner.Binds)
+	if err != nil {
+		return err
 	}
+	runner.executorSpec.BindMounts = binds
+	runner.executorSpec.RAM = int64(runner.Container.RuntimeConstraints.RAM)
+	runner.executorSpec.VCPUs = runner.Container.RuntimeConstraints.VCPUs
+	runner.executorSpec.CgroupParent = runner.setCgroupParent
+	runner.executorSpec.NetworkMode = runner.networkMode
 
 	if wantAPI := runner.Container.RuntimeConstraints.API; wantAPI != nil && *wantAPI {
 		tok, err := runner.ContainerToken()
 		if err != nil {
 			return err
 		}
-		runner.ContainerConfig.Env = append(runner.ContainerConfig.Env,
-			"ARVADOS_API_TOKEN="+tok,
-			"ARVADOS_API_HOST="+os.Getenv("ARVADOS_API_HOST"),
-			"ARVADOS_API_HOST_INSECURE="+os.Getenv("ARVADOS_API_HOST_INSECURE"),
-		)
-		runner.HostConfig.NetworkMode = dockercontainer.NetworkMode(runner.networkMode)
+		runner.executorSpec.Env["ARVADOS_API_TOKEN"] = tok
+		runner.executorSpec.Env["ARVADOS_API_HOST"] = os.Getenv("ARVADOS_API_HOST")
+		runner.executorSpec.Env["ARVADOS_API_HOST_INSECURE"] = os.Getenv("ARVADOS_API_HOST_INSECURE")
+		runner.executorSpec.EnableNetwork = true
 	} else {
-		if runner.enableNetwork == "always" {
-			runner.HostConfig.NetworkMode = dockercontainer.NetworkMode(runner.networkMode)
-		} else {
-			runner.HostConfig.NetworkMode = dockercontainer.NetworkMode("none")
-		}
+		runner.executorSpec.EnableNetwork = runner.enableNetwork == "always"
 	}
 
-	_, stdinUsed := runner.Container.Mounts["stdin"]
-	runner.ContainerConfig.OpenStdin = stdinUsed
-	runner.ContainerConfig.StdinOnce = stdinUsed
-	runner.ContainerConfig.AttachStdin = stdinUsed
-	runner.ContainerConfig.AttachStdout = true
-	runner.ContainerConfig.AttachStderr = true
-
-	createdBody, err := runner.Docker.ContainerCreate(context.TODO(), &runner.ContainerConfig, &runner.HostConfig, nil, runner.Container.UUID)
+	err = runner.AttachStreams()
 	if err != nil {
-		return fmt.Errorf("While creating container: %v", err)
+		return err
 	}
 
-	runner.ContainerID = createdBody.ID
-
-	return runner.AttachStreams()
+	err = runner.executor.Create(runner.executorSpec)
+	if err != nil {
+		return err
+	}
+	runner.cStateLock.Lock()
+	runner.cCreated = true
+	runner.cStateLock.Unlock()
+	return nil
 }
 
-// StartContainer starts the docker container created by CreateContainer.
+// StartContainer starts the container created by CreateContainer.
 func (runner *ContainerRunner) StartContainer() error {
-	runner.CrunchLog.Printf("Starting Docker container id '%s'", runner.ContainerID)
 	runner.cStateLock.Lock()
 	defer runner.cStateLock.Unlock()
 	if runner.cCancelled {
 		return ErrCancelled
 	}
-	err := runner.Docker.ContainerStart(context.TODO(), runner.ContainerID,
-		dockertypes.ContainerStartOptions{})
+	err := runner.executor.Start()
 	if err != nil {
 		var advice string
 		if m, e := regexp.MatchString("(?ms).*(exec|System error).*(no such file or directory|file not found).*", err.Error()); m && e == nil {
@@ -1154,52 +1080,37 @@ func (runner *ContainerRunner) WaitFinish() error {
 	var runTimeExceeded <-chan time.Time
 	runner.CrunchLog.Print("Waiting for container to finish")
 
-	waitOk, waitErr := runner.Docker.ContainerWait(context.TODO(), runner.ContainerID, dockercontainer.WaitConditionNotRunning)
+	type waitResult struct {
+		exitcode int
+		err      error
+	}
+	waitDone := make(chan waitResult, 1)
+	go func() {
+		exitcode, err := runner.executor.Wait(context.Background())
+		waitDone <- waitResult{exitcode, err}
+	}()
+
 	arvMountExit := runner.ArvMountExit
 	if timeout := runner.Container.SchedulingParameters.MaxRunTime; timeout > 0 {
 		runTimeExceeded = time.After(time.Duration(timeout) * time.Second)
 	}
-
-	containerGone := make(chan struct{})
-	go func() {
-		defer close(containerGone)
-		if runner.containerWatchdogInterval < 1 {
-			runner.containerWatchdogInterval = time.Minute
-		}
-		for range time.NewTicker(runner.containerWatchdogInterval).C {
-			ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(runner.containerWatchdogInterval))
-			ctr, err := runner.Docker.ContainerInspect(ctx, runner.ContainerID)
-			cancel()
-			runner.cStateLock.Lock()
-			done := runner.cRemoved || runner.ExitCode != nil
-			runner.cStateLock.Unlock()
-			if done {
-				return
-			} else if err != nil {
-				runner.CrunchLog.Printf("Error inspecting container: %s", err)
-				runner.checkBrokenNode(err)
-				return
-			} else if ctr.State == nil || !(ctr.State.Running || ctr.State.Status == "created") {
-				runner.CrunchLog.Printf("Container is not running: State=%v", ctr.State)
-				return
-			}
-		}
-	}()
+	preemptionNotice := runner.preemptionNotice
+	var checkpointTimeout <-chan time.Time
 
 	for {
 		select {
-		case waitBody := <-waitOk:
-			runner.CrunchLog.Printf("Container exited with code: %v", waitBody.StatusCode)
-			code := int(waitBody.StatusCode)
-			runner.ExitCode = &code
-
-			// wait for stdout/stderr to complete
-			<-runner.loggingDone
+		case result := <-waitDone:
+			if result.err != nil {
+				runner.checkBrokenNode(result.err)
+				return fmt.Errorf("container wait: %v", result.err)
+			}
+			runner.CrunchLog.Printf("Container exited with code: %v", result.exitcode)
+			runner.cStateLock.Lock()
+			runner.ExitCode = &result.exitcode
+			runner.cStateLock.Unlock()
+			runner.closeStdoutStderr()
 			return nil
 
-		case err := <-waitErr:
-			return fmt.Errorf("container wait: %v", err)
-
 		case <-arvMountExit:
 			runner.CrunchLog.Printf("arv-mount exited while container is still running.  Stopping container.")
 			runner.stop(nil)
@@ -1212,8 +1123,53 @@ func (runner *ContainerRunner) WaitFinish() error {
 			runner.stop(nil)
 			runTimeExceeded = nil
 
-		case <-containerGone:
-			return errors.New("docker client never returned status")
+		case <-preemptionNotice:
+			preemptionNotice = nil
+			if runner.checkpointHostDir == "" {
+				runner.CrunchLog.Printf("Instance is being preempted. Stopping container.")
+				runner.stop(nil)
+				continue
+			}
+			runner.CrunchLog.Printf("Instance is being preempted. Sending SIGTERM to container and waiting up to %v for it to write a checkpoint.", runner.checkpointGracePeriod)
+			runner.cStateLock.Lock()
+			runner.cCancelled = true
+			runner.checkpointRequested = true
+			runner.cStateLock.Unlock()
+			err := runner.executor.Signal(syscall.SIGTERM)
+			if err != nil {
+				runner.CrunchLog.Printf("error signalling container: %v. Stopping container.", err)
+				runner.stop(nil)
+				continue
+			}
+			checkpointTimeout = time.After(runner.checkpointGracePeriod)
+
+		case <-checkpointTimeout:
+			runner.CrunchLog.Printf("Container did not exit within checkpoint grace period. Stopping container.")
+			runner.stop(nil)
+			checkpointTimeout = nil
+		}
+	}
+}
+
+// closeStdoutStderr closes the container's stdout and stderr logs,
+// and stops the crunchstat reporter. It is called after the
+// container has exited and all of its output has been written.
+func (runner *ContainerRunner) closeStdoutStderr() {
+	err := runner.Stdout.Close()
+	if err != nil {
+		runner.CrunchLog.Printf("error closing stdout logs: %v", err)
+	}
+
+	err = runner.Stderr.Close()
+	if err != nil {
+		runner.CrunchLog.Printf("error closing stderr logs: %v", err)
+	}
+
+	if runner.statReporter != nil {
+		runner.statReporter.Stop()
+		err = runner.statLogger.Close()
+		if err != nil {
+			runner.CrunchLog.Printf("error closing crunchstat logs: %v", err)
 		}
 	}
 }
@@ -1392,6 +1348,7 @@ func (runner *ContainerRunner) CommitLogs() error {
 			runner.arvMountLog.Close()
 		}
 		runner.CrunchLog.Close()
+		runner.structuredLog.Close()
 
 		// Closing CrunchLog above allows them to be committed to Keep at this
 		// point, but re-open crunch log with ArvClient in case there are any
@@ -1525,9 +1482,22 @@ func (runner *ContainerRunner) NewArvLogWriter(name string) (io.WriteCloser, err
 		UUID:          runner.Container.UUID,
 		loggingStream: name,
 		writeCloser:   writer,
+		structured:    runner.structuredLog,
 	}, nil
 }
 
+// EnableStructuredLog starts writing a JSON record for each log
+// line, from all log streams, to log.jsonl in the log collection,
+// in addition to the usual text files.
+func (runner *ContainerRunner) EnableStructuredLog() error {
+	w, err := runner.LogCollection.OpenFile("log.jsonl", os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	runner.structuredLog.Start(w)
+	return nil
+}
+
 // Run the full container lifecycle.
 func (runner *ContainerRunner) Run() (err error) {
 	runner.CrunchLog.Printf("crunch-run %s started", cmd.Version.String())
@@ -1592,6 +1562,7 @@ func (runner *ContainerRunner) Run() (err error) {
 		}
 
 		checkErr("CaptureOutput", runner.CaptureOutput())
+		checkErr("SaveCheckpoint", runner.saveCheckpoint())
 		checkErr("stopHoststat", runner.stopHoststat())
 		checkErr("CommitLogs", runner.CommitLogs())
 		checkErr("UpdateContainerFinal", runner.UpdateContainerFinal())
@@ -1716,16 +1687,16 @@ func (runner *ContainerRunner) fetchContainerRecord() error {
 func NewContainerRunner(dispatcherClient *arvados.Client,
 	dispatcherArvClient IArvadosClient,
 	dispatcherKeepClient IKeepClient,
-	docker ThinDockerClient,
 	containerUUID string) (*ContainerRunner, error) {
 
 	cr := &ContainerRunner{
 		dispatcherClient:     dispatcherClient,
 		DispatcherArvClient:  dispatcherArvClient,
 		DispatcherKeepClient: dispatcherKeepClient,
-		Docker:               docker,
 	}
 	cr.NewLogWriter = cr.NewArvLogWriter
+	cr.preemptionNotice = make(chan struct{})
+	cr.checkpointGracePeriod = defaultCheckpointGracePeriod
 	cr.RunArvMount = cr.ArvMountCmd
 	cr.MkTempDir = ioutil.TempDir
 	cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
@@ -1748,6 +1719,7 @@ func NewContainerRunner(dispatcherClient *arvados.Client,
 		return nil, err
 	}
 	cr.Container.UUID = containerUUID
+	cr.structuredLog = &structuredLogWriter{containerUUID: containerUUID}
 	w, err := cr.NewLogWriter("crunch-run")
 	if err != nil {
 		return nil, err
@@ -1781,7 +1753,12 @@ func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, s
 	networkMode := flags.String("container-network-mode", "default",
 		`Set networking mode for container.  Corresponds to Docker network mode (--net).
     	`)
+	runtimeEngine := flags.String("runtime-engine", "docker", "container runtime: docker or singularity")
 	memprofile := flags.String("memprofile", "", "write memory profile to `file` after running container")
+	preemptionNoticeURL := flags.String("preemption-notice-url", "", "poll instance metadata `URL` for a preemption notice (e.g., http://169.254.169.254/latest/meta-data/spot/instance-action)")
+	preemptionNoticeInterval := flags.Duration("preemption-notice-interval", 5*time.Second, "how often to poll for a preemption notice")
+	checkpointGracePeriod := flags.Duration("checkpoint-grace-period", defaultCheckpointGracePeriod, "after a preemption notice, time to wait for the container to write a checkpoint before stopping it")
+	structuredLogs := flags.Bool("structured-logs", false, "also write all log messages as JSON records to log.jsonl in the log collection (default from cluster config Containers.Logging.StructuredLogs)")
 	flags.Duration("check-containerd", 0, "Ignored. Exists for compatibility with older versions.")
 
 	ignoreDetachFlag := false
@@ -1852,21 +1829,47 @@ func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, s
 	kc.BlockCache = &keepclient.BlockCache{MaxBlocks: 2}
 	kc.Retries = 4
 
-	// API version 1.21 corresponds to Docker 1.9, which is currently the
-	// minimum version we want to support.
-	docker, dockererr := dockerclient.NewClient(dockerclient.DefaultDockerHost, "1.21", nil, nil)
-
-	cr, err := NewContainerRunner(arvados.NewClientFromEnv(), api, kc, docker, containerId)
+	cr, err := NewContainerRunner(arvados.NewClientFromEnv(), api, kc, containerId)
 	if err != nil {
 		log.Print(err)
 		return 1
 	}
-	if dockererr != nil {
-		cr.CrunchLog.Printf("%s: %v", containerId, dockererr)
-		cr.checkBrokenNode(dockererr)
+	if *structuredLogs || crunchStructuredLogs {
+		err = cr.EnableStructuredLog()
+		if err != nil {
+			log.Printf("%s: %v", containerId, err)
+			return 1
+		}
+	}
+	cr.checkpointGracePeriod = *checkpointGracePeriod
+	if *preemptionNoticeURL != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go (&preemptionWatcher{
+			URL:      *preemptionNoticeURL,
+			Interval: *preemptionNoticeInterval,
+			Logf:     cr.CrunchLog.Printf,
+		}).Watch(ctx, cr.notifyPreemption)
+	}
+
+	logf := func(format string, args ...interface{}) {
+		cr.CrunchLog.Printf(format, args...)
+	}
+	switch *runtimeEngine {
+	case "docker":
+		cr.executor, err = newDockerExecutor(containerId, logf, 0)
+	case "singularity", "apptainer":
+		cr.executor, err = newSingularityExecutor(*runtimeEngine, logf)
+	default:
+		err = fmt.Errorf("unsupported runtime engine %q", *runtimeEngine)
+	}
+	if err != nil {
+		cr.CrunchLog.Printf("%s: %v", containerId, err)
+		cr.checkBrokenNode(err)
 		cr.CrunchLog.Close()
 		return 1
 	}
+	defer cr.executor.Close()
 
 	parentTemp, tmperr := cr.MkTempDir("", "crunch-run."+containerId+".")
 	if tmperr != nil {
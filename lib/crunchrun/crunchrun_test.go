@@ -45,14 +45,34 @@ func TestCrunchExec(t *testing.T) {
 var _ = Suite(&TestSuite{})
 
 type TestSuite struct {
-	client *arvados.Client
-	docker *TestDockerClient
-	runner *ContainerRunner
+	client   *arvados.Client
+	docker   *TestDockerClient
+	executor *dockerExecutor
+	runner   *ContainerRunner
+
+	// checkpoint collection returned by the stub API in
+	// fullRunHelper
+	checkpoint *arvados.Collection
 }
 
 func (s *TestSuite) SetUpTest(c *C) {
 	s.client = arvados.NewClientFromEnv()
+	s.checkpoint = nil
 	s.docker = NewTestDockerClient()
+	s.executor = &dockerExecutor{
+		containerUUID:    "zzzzz-zzzzz-zzzzzzzzzzzzzzz",
+		watchdogInterval: time.Minute,
+		dockerclient:     s.docker,
+	}
+}
+
+// attachExecutor sets up cr to use a dockerExecutor backed by the
+// stub docker client s.docker.
+func (s *TestSuite) attachExecutor(cr *ContainerRunner) {
+	s.executor.logf = func(format string, args ...interface{}) {
+		cr.CrunchLog.Printf(format, args...)
+	}
+	cr.executor = s.executor
 }
 
 type ArvTestClient struct {
@@ -65,6 +85,7 @@ type ArvTestClient struct {
 	sync.Mutex
 	WasSetRunning bool
 	callraw       bool
+	checkpoint    *arvados.Collection
 }
 
 type KeepTestClient struct {
@@ -100,17 +121,19 @@ type TestDockerClient struct {
 	exitCode    int
 	stop        chan bool
 	cwd         string
-	env         []string
+	env         map[string]string
 	api         *ArvTestClient
 	realTemp    string
 	calledWait  bool
 	ctrExited   bool
+	signals     chan string
 }
 
 func NewTestDockerClient() *TestDockerClient {
 	t := &TestDockerClient{}
 	t.logReader, t.logWriter = io.Pipe()
 	t.stop = make(chan bool, 1)
+	t.signals = make(chan string, 1)
 	t.cwd = "/"
 	return t
 }
@@ -136,7 +159,11 @@ func (t *TestDockerClient) ContainerCreate(ctx context.Context, config *dockerco
 	if config.WorkingDir != "" {
 		t.cwd = config.WorkingDir
 	}
-	t.env = config.Env
+	t.env = map[string]string{}
+	for _, kv := range config.Env {
+		kv := strings.SplitN(kv, "=", 2)
+		t.env[kv[0]] = kv[1]
+	}
 	return dockercontainer.ContainerCreateCreatedBody{ID: "abcde"}, nil
 }
 
@@ -163,7 +190,18 @@ func (t *TestDockerClient) ContainerStart(ctx context.Context, container string,
 }
 
 func (t *TestDockerClient) ContainerRemove(ctx context.Context, container string, options dockertypes.ContainerRemoveOptions) error {
-	t.stop <- true
+	select {
+	case t.stop <- true:
+	default:
+	}
+	return nil
+}
+
+func (t *TestDockerClient) ContainerKill(ctx context.Context, container, signal string) error {
+	select {
+	case t.signals <- signal:
+	default:
+	}
 	return nil
 }
 
@@ -263,6 +301,13 @@ func (client *ArvTestClient) Call(method, resourceType, uuid, action string, par
 		} else {
 			return json.Unmarshal([]byte(`{"secret_mounts":{}}`), output)
 		}
+	case method == "GET" && resourceType == "container_requests" && uuid == "":
+		cr := arvados.ContainerRequest{UUID: "zzzzz-xvhdp-zzzzzzzzzzzzzzz", OwnerUUID: checkpointOwnerUUID}
+		if client.checkpoint != nil {
+			cr.Properties = map[string]interface{}{checkpointCRProperty: client.checkpoint.UUID}
+		}
+		output.(*arvados.ContainerRequestList).Items = []arvados.ContainerRequest{cr}
+		return nil
 	default:
 		return fmt.Errorf("Not found")
 	}
@@ -313,6 +358,8 @@ func (client *ArvTestClient) Get(resourceType string, uuid string, parameters ar
 			output.(*arvados.Collection).ManifestText = normalizedManifestWithSubdirs
 		} else if uuid == denormalizedWithSubdirsPDH {
 			output.(*arvados.Collection).ManifestText = denormalizedManifestWithSubdirs
+		} else if client.checkpoint != nil && uuid == client.checkpoint.UUID {
+			*output.(*arvados.Collection) = *client.checkpoint
 		}
 	}
 	if resourceType == "containers" {
@@ -443,47 +490,48 @@ func (client *KeepTestClient) ManifestFileReader(m manifest.Manifest, filename s
 
 func (s *TestSuite) TestLoadImage(c *C) {
 	cr, err := NewContainerRunner(s.client, &ArvTestClient{},
-		&KeepTestClient{}, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+		&KeepTestClient{}, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 
 	kc := &KeepTestClient{}
 	defer kc.Close()
 	cr.ContainerArvClient = &ArvTestClient{}
 	cr.ContainerKeepClient = kc
 
-	_, err = cr.Docker.ImageRemove(nil, hwImageId, dockertypes.ImageRemoveOptions{})
+	_, err = s.docker.ImageRemove(nil, hwImageId, dockertypes.ImageRemoveOptions{})
 	c.Check(err, IsNil)
 
-	_, _, err = cr.Docker.ImageInspectWithRaw(nil, hwImageId)
+	_, _, err = s.docker.ImageInspectWithRaw(nil, hwImageId)
 	c.Check(err, NotNil)
 
 	cr.Container.ContainerImage = hwPDH
 
 	// (1) Test loading image from keep
 	c.Check(kc.Called, Equals, false)
-	c.Check(cr.ContainerConfig.Image, Equals, "")
+	c.Check(cr.executorSpec.Image, Equals, "")
 
 	err = cr.LoadImage()
 
 	c.Check(err, IsNil)
 	defer func() {
-		cr.Docker.ImageRemove(nil, hwImageId, dockertypes.ImageRemoveOptions{})
+		s.docker.ImageRemove(nil, hwImageId, dockertypes.ImageRemoveOptions{})
 	}()
 
 	c.Check(kc.Called, Equals, true)
-	c.Check(cr.ContainerConfig.Image, Equals, hwImageId)
+	c.Check(cr.executorSpec.Image, Equals, hwImageId)
 
-	_, _, err = cr.Docker.ImageInspectWithRaw(nil, hwImageId)
+	_, _, err = s.docker.ImageInspectWithRaw(nil, hwImageId)
 	c.Check(err, IsNil)
 
 	// (2) Test using image that's already loaded
 	kc.Called = false
-	cr.ContainerConfig.Image = ""
+	cr.executorSpec.Image = ""
 
 	err = cr.LoadImage()
 	c.Check(err, IsNil)
 	c.Check(kc.Called, Equals, false)
-	c.Check(cr.ContainerConfig.Image, Equals, hwImageId)
+	c.Check(cr.executorSpec.Image, Equals, hwImageId)
 
 }
 
@@ -563,7 +611,7 @@ func (s *TestSuite) TestLoadImageArvError(c *C) {
 	// (1) Arvados error
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, &ArvErrorTestClient{}, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, &ArvErrorTestClient{}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 
 	cr.ContainerArvClient = &ArvErrorTestClient{}
@@ -578,8 +626,9 @@ func (s *TestSuite) TestLoadImageArvError(c *C) {
 func (s *TestSuite) TestLoadImageKeepError(c *C) {
 	// (2) Keep error
 	kc := &KeepErrorTestClient{}
-	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 
 	cr.ContainerArvClient = &ArvTestClient{}
 	cr.ContainerKeepClient = &KeepErrorTestClient{}
@@ -594,7 +643,7 @@ func (s *TestSuite) TestLoadImageKeepError(c *C) {
 func (s *TestSuite) TestLoadImageCollectionError(c *C) {
 	// (3) Collection doesn't contain image
 	kc := &KeepReadErrorTestClient{}
-	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.Container.ContainerImage = otherPDH
 
@@ -608,8 +657,9 @@ func (s *TestSuite) TestLoadImageCollectionError(c *C) {
 func (s *TestSuite) TestLoadImageKeepReadError(c *C) {
 	// (4) Collection doesn't contain image
 	kc := &KeepReadErrorTestClient{}
-	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 	cr.Container.ContainerImage = hwPDH
 	cr.ContainerArvClient = &ArvTestClient{}
 	cr.ContainerKeepClient = &KeepReadErrorTestClient{}
@@ -657,8 +707,9 @@ func (s *TestSuite) TestRunContainer(c *C) {
 	}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, &ArvTestClient{}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 
 	cr.ContainerArvClient = &ArvTestClient{}
 	cr.ContainerKeepClient = &KeepTestClient{}
@@ -687,7 +738,7 @@ func (s *TestSuite) TestCommitLogs(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 
@@ -709,7 +760,7 @@ func (s *TestSuite) TestUpdateContainerRunning(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 
 	err = cr.UpdateContainerRunning()
@@ -722,7 +773,7 @@ func (s *TestSuite) TestUpdateContainerComplete(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 
 	cr.LogsPDH = new(string)
@@ -744,7 +795,7 @@ func (s *TestSuite) TestUpdateContainerCancelled(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.cCancelled = true
 	cr.finalState = "Cancelled"
@@ -777,15 +828,16 @@ func (s *TestSuite) fullRunHelper(c *C, record string, extraMounts []string, exi
 	s.docker.fn = fn
 	s.docker.ImageRemove(nil, hwImageId, dockertypes.ImageRemoveOptions{})
 
-	api = &ArvTestClient{Container: rec}
+	api = &ArvTestClient{Container: rec, checkpoint: s.checkpoint}
 	s.docker.api = api
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err = NewContainerRunner(s.client, api, kc, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err = NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 	s.runner = cr
 	cr.statInterval = 100 * time.Millisecond
-	cr.containerWatchdogInterval = time.Second
+	s.executor.watchdogInterval = time.Second
 	am := &ArvMountCmdLine{}
 	cr.RunArvMount = am.ArvMountTest
 
@@ -807,7 +859,7 @@ func (s *TestSuite) fullRunHelper(c *C, record string, extraMounts []string, exi
 		return d, err
 	}
 	cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
-		return &ArvTestClient{secretMounts: secretMounts}, &KeepTestClient{}, nil, nil
+		return &ArvTestClient{secretMounts: secretMounts, checkpoint: s.checkpoint}, &KeepTestClient{}, nil, nil
 	}
 
 	if extraMounts != nil && len(extraMounts) > 0 {
@@ -1140,8 +1192,9 @@ func (s *TestSuite) testStopContainer(c *C, setup func(cr *ContainerRunner)) {
 	api := &ArvTestClient{Container: rec}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 	cr.RunArvMount = func([]string, string) (*exec.Cmd, error) { return nil, nil }
 	cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
 		return &ArvTestClient{}, &KeepTestClient{}, nil, nil
@@ -1181,7 +1234,7 @@ func (s *TestSuite) TestFullRunSetEnv(c *C) {
     "runtime_constraints": {},
     "state": "Locked"
 }`, nil, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1213,7 +1266,7 @@ func (s *TestSuite) TestSetupMounts(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	am := &ArvMountCmdLine{}
 	cr.RunArvMount = am.ArvMountTest
@@ -1606,7 +1659,7 @@ func (s *TestSuite) TestStdout(c *C) {
 	}`
 
 	api, cr, _ := s.fullRunHelper(c, helperRecord, nil, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1627,8 +1680,9 @@ func (s *TestSuite) stdoutErrorRunHelper(c *C, record string, fn func(t *TestDoc
 	api = &ArvTestClient{Container: rec}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err = NewContainerRunner(s.client, api, kc, s.docker, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err = NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
+	s.attachExecutor(cr)
 	am := &ArvMountCmdLine{}
 	cr.RunArvMount = am.ArvMountTest
 	cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
@@ -1686,7 +1740,7 @@ func (s *TestSuite) TestFullRunWithAPI(c *C) {
     "runtime_constraints": {"API": true},
     "state": "Locked"
 }`, nil, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[1][17:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["ARVADOS_API_HOST"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1742,7 +1796,7 @@ func (s *TestSuite) TestStdoutWithExcludeFromOutputMountPointUnderOutputDir(c *C
 	extraMounts := []string{"a3e8f74c6f101eae01fa08bfb4e49b3a+54"}
 
 	api, cr, _ := s.fullRunHelper(c, helperRecord, extraMounts, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1778,7 +1832,7 @@ func (s *TestSuite) TestStdoutWithMultipleMountPointsUnderOutputDir(c *C) {
 	}
 
 	api, runner, realtemp := s.fullRunHelper(c, helperRecord, extraMounts, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1831,7 +1885,7 @@ func (s *TestSuite) TestStdoutWithMountPointsUnderOutputDirDenormalizedManifest(
 	}
 
 	api, _, _ := s.fullRunHelper(c, helperRecord, extraMounts, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1898,7 +1952,7 @@ func (s *TestSuite) TestStdinCollectionMountPoint(c *C) {
 	}
 
 	api, _, _ := s.fullRunHelper(c, helperRecord, extraMounts, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1934,7 +1988,7 @@ func (s *TestSuite) TestStdinJsonMountPoint(c *C) {
 	}`
 
 	api, _, _ := s.fullRunHelper(c, helperRecord, nil, 0, func(t *TestDockerClient) {
-		t.logWriter.Write(dockerLog(1, t.env[0][7:]+"\n"))
+		t.logWriter.Write(dockerLog(1, t.env["FROBIZ"]+"\n"))
 		t.logWriter.Close()
 	})
 
@@ -1982,7 +2036,7 @@ func (s *TestSuite) TestStderrMount(c *C) {
 func (s *TestSuite) TestNumberRoundTrip(c *C) {
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, &ArvTestClient{callraw: true}, kc, nil, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, &ArvTestClient{callraw: true}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.fetchContainerRecord()
 
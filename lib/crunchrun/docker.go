@@ -0,0 +1,284 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockernetwork "github.com/docker/docker/api/types/network"
+	dockerclient "github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// ThinDockerClient is the minimal Docker client interface used by crunch-run.
+type ThinDockerClient interface {
+	ContainerAttach(ctx context.Context, container string, options dockertypes.ContainerAttachOptions) (dockertypes.HijackedResponse, error)
+	ContainerCreate(ctx context.Context, config *dockercontainer.Config, hostConfig *dockercontainer.HostConfig,
+		networkingConfig *dockernetwork.NetworkingConfig, containerName string) (dockercontainer.ContainerCreateCreatedBody, error)
+	ContainerStart(ctx context.Context, container string, options dockertypes.ContainerStartOptions) error
+	ContainerRemove(ctx context.Context, container string, options dockertypes.ContainerRemoveOptions) error
+	ContainerKill(ctx context.Context, container, signal string) error
+	ContainerWait(ctx context.Context, container string, condition dockercontainer.WaitCondition) (<-chan dockercontainer.ContainerWaitOKBody, <-chan error)
+	ContainerInspect(ctx context.Context, id string) (dockertypes.ContainerJSON, error)
+	ImageInspectWithRaw(ctx context.Context, image string) (dockertypes.ImageInspect, []byte, error)
+	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (dockertypes.ImageLoadResponse, error)
+	ImageRemove(ctx context.Context, image string, options dockertypes.ImageRemoveOptions) ([]dockertypes.ImageDeleteResponseItem, error)
+}
+
+type dockerExecutor struct {
+	containerUUID    string
+	logf             func(string, ...interface{})
+	watchdogInterval time.Duration
+	dockerclient     ThinDockerClient
+	containerID      string
+	doneIO           chan struct{}
+}
+
+func newDockerExecutor(containerUUID string, logf func(string, ...interface{}), watchdogInterval time.Duration) (*dockerExecutor, error) {
+	// API version 1.21 corresponds to Docker 1.9, which is
+	// currently the minimum version we want to support.
+	client, err := dockerclient.NewClient(dockerclient.DefaultDockerHost, "1.21", nil, nil)
+	if watchdogInterval < 1 {
+		watchdogInterval = time.Minute
+	}
+	return &dockerExecutor{
+		containerUUID:    containerUUID,
+		logf:             logf,
+		watchdogInterval: watchdogInterval,
+		dockerclient:     client,
+	}, err
+}
+
+func (e *dockerExecutor) LoadImage(imageID string, openTarball func() (io.ReadCloser, error)) error {
+	_, _, err := e.dockerclient.ImageInspectWithRaw(context.TODO(), imageID)
+	if err == nil {
+		e.logf("Docker image is available")
+		return nil
+	}
+
+	e.logf("Loading Docker image from keep")
+	rdr, err := openTarball()
+	if err != nil {
+		return fmt.Errorf("While creating ManifestFileReader for container image: %v", err)
+	}
+
+	resp, err := e.dockerclient.ImageLoad(context.TODO(), rdr, true)
+	if err != nil {
+		return fmt.Errorf("While loading container image into Docker: %v", err)
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Reading response to image load: %v", err)
+	}
+	e.logf("Docker response: %s", buf)
+	return nil
+}
+
+func (e *dockerExecutor) Create(spec containerSpec) error {
+	e.logf("Creating Docker container")
+	cfg := dockercontainer.Config{
+		Image:        spec.Image,
+		Cmd:          spec.Command,
+		WorkingDir:   spec.WorkingDir,
+		Volumes:      map[string]struct{}{},
+		OpenStdin:    spec.Stdin != nil,
+		StdinOnce:    spec.Stdin != nil,
+		AttachStdin:  spec.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	if cfg.WorkingDir == "." {
+		cfg.WorkingDir = ""
+	}
+	var envKeys []string
+	for k := range spec.Env {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+	for _, k := range envKeys {
+		cfg.Env = append(cfg.Env, k+"="+spec.Env[k])
+	}
+	if spec.RAM < minDockerRAM {
+		spec.RAM = minDockerRAM
+	}
+	hostCfg := dockercontainer.HostConfig{
+		LogConfig: dockercontainer.LogConfig{
+			Type: "none",
+		},
+		NetworkMode: dockercontainer.NetworkMode("none"),
+		Resources: dockercontainer.Resources{
+			CgroupParent: spec.CgroupParent,
+			NanoCPUs:     int64(spec.VCPUs) * 1000000000,
+			Memory:       spec.RAM, // RAM
+			MemorySwap:   spec.RAM, // RAM+swap
+			KernelMemory: spec.RAM, // kernel portion
+		},
+	}
+	var ctrPaths []string
+	for path := range spec.BindMounts {
+		ctrPaths = append(ctrPaths, path)
+	}
+	sort.Strings(ctrPaths)
+	for _, path := range ctrPaths {
+		mount := spec.BindMounts[path]
+		bind := mount.HostPath + ":" + path
+		if mount.ReadOnly {
+			bind += ":ro"
+		}
+		hostCfg.Binds = append(hostCfg.Binds, bind)
+	}
+	if spec.EnableNetwork {
+		hostCfg.NetworkMode = dockercontainer.NetworkMode(spec.NetworkMode)
+	}
+
+	created, err := e.dockerclient.ContainerCreate(context.TODO(), &cfg, &hostCfg, nil, e.containerUUID)
+	if err != nil {
+		return fmt.Errorf("While creating container: %v", err)
+	}
+	e.containerID = created.ID
+	return e.startIO(spec.Stdin, spec.Stdout, spec.Stderr)
+}
+
+func (e *dockerExecutor) CgroupID() string {
+	return e.containerID
+}
+
+func (e *dockerExecutor) Start() error {
+	e.logf("Starting Docker container id '%s'", e.containerID)
+	return e.dockerclient.ContainerStart(context.TODO(), e.containerID, dockertypes.ContainerStartOptions{})
+}
+
+func (e *dockerExecutor) Stop() error {
+	e.logf("removing container")
+	err := e.dockerclient.ContainerRemove(context.TODO(), e.containerID, dockertypes.ContainerRemoveOptions{Force: true})
+	if err != nil && strings.Contains(err.Error(), "No such container: "+e.containerID) {
+		err = nil
+	}
+	return err
+}
+
+func (e *dockerExecutor) Signal(sig syscall.Signal) error {
+	e.logf("sending signal %d (%s) to container", int(sig), sig)
+	return e.dockerclient.ContainerKill(context.TODO(), e.containerID, fmt.Sprintf("%d", int(sig)))
+}
+
+// Wait for the container to terminate, capture the exit code, and
+// wait for stdout/stderr logging to finish.
+func (e *dockerExecutor) Wait(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchdogErr := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(e.watchdogInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			dctx, dcancel := context.WithDeadline(ctx, time.Now().Add(e.watchdogInterval))
+			ctr, err := e.dockerclient.ContainerInspect(dctx, e.containerID)
+			dcancel()
+			if ctx.Err() != nil {
+				// Either the container already
+				// exited, or our caller is trying to
+				// kill it.
+				return
+			} else if err != nil {
+				e.logf("Error inspecting container: %s", err)
+				watchdogErr <- err
+				return
+			} else if ctr.State == nil || !(ctr.State.Running || ctr.State.Status == "created") {
+				watchdogErr <- fmt.Errorf("Container is not running: State=%v", ctr.State)
+				return
+			}
+		}
+	}()
+
+	waitOk, waitErr := e.dockerclient.ContainerWait(ctx, e.containerID, dockercontainer.WaitConditionNotRunning)
+	select {
+	case waitBody := <-waitOk:
+		// wait for stdout/stderr to complete
+		<-e.doneIO
+		return int(waitBody.StatusCode), nil
+	case err := <-waitErr:
+		return -1, fmt.Errorf("container wait: %v", err)
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	case err := <-watchdogErr:
+		return -1, err
+	}
+}
+
+func (e *dockerExecutor) startIO(stdin io.ReadCloser, stdout, stderr io.Writer) error {
+	resp, err := e.dockerclient.ContainerAttach(context.TODO(), e.containerID, dockertypes.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("While attaching container stdout/stderr streams: %v", err)
+	}
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(resp.Conn, stdin)
+			if err != nil {
+				e.logf("While writing stdin to docker container: %v", err)
+				e.Stop()
+			}
+			stdin.Close()
+			resp.CloseWrite()
+		}()
+	}
+	e.doneIO = make(chan struct{})
+	go func() {
+		defer close(e.doneIO)
+		e.handleStdoutStderr(stdout, stderr, resp.Reader)
+	}()
+	return nil
+}
+
+// handleStdoutStderr demultiplexes the container's stdout and
+// stderr, using the docker log protocol:
+// https://docs.docker.com/engine/reference/api/docker_remote_api_v1.15/#attach-to-a-container
+func (e *dockerExecutor) handleStdoutStderr(stdout, stderr io.Writer, reader io.Reader) {
+	header := make([]byte, 8)
+	var err error
+	for err == nil {
+		_, err = io.ReadAtLeast(reader, header, 8)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		readsize := int64(header[7]) | (int64(header[6]) << 8) | (int64(header[5]) << 16) | (int64(header[4]) << 24)
+		if header[0] == 1 {
+			_, err = io.CopyN(stdout, reader, readsize)
+		} else {
+			// stderr
+			_, err = io.CopyN(stderr, reader, readsize)
+		}
+	}
+	if err != nil {
+		e.logf("error reading docker logs: %v", err)
+	}
+}
+
+func (e *dockerExecutor) Close() {
+	if e.containerID == "" {
+		return
+	}
+	e.dockerclient.ContainerRemove(context.TODO(), e.containerID, dockertypes.ContainerRemoveOptions{Force: true})
+}
+
+// Docker daemon won't let you set a limit less than ~10 MiB
+const minDockerRAM = int64(16 * 1024 * 1024)
@@ -0,0 +1,94 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+type bindmount struct {
+	HostPath string
+	ReadOnly bool
+}
+
+// containerSpec describes the container to be run, independent of
+// the container runtime.
+type containerSpec struct {
+	Image         string
+	VCPUs         int
+	RAM           int64
+	WorkingDir    string
+	Env           map[string]string
+	BindMounts    map[string]bindmount
+	Command       []string
+	EnableNetwork bool
+	NetworkMode   string // docker network mode, normally "default"
+	CgroupParent  string
+	Stdin         io.ReadCloser
+	Stdout        io.Writer
+	Stderr        io.Writer
+}
+
+// containerExecutor is an interface to a container runtime
+// (docker/singularity).
+type containerExecutor interface {
+	// LoadImage makes the given image available to use when
+	// creating a container. imageID is the docker image ID
+	// (i.e., the name of the tarball in the image collection,
+	// without the ".tar" suffix). If the image is not already
+	// available, LoadImage calls openTarball to get the image
+	// tarball (as written by "docker save").
+	LoadImage(imageID string, openTarball func() (io.ReadCloser, error)) error
+
+	// Create a container, but don't start it yet.
+	Create(spec containerSpec) error
+
+	// Start the container.
+	Start() error
+
+	// CgroupID returns the container's cgroup ID, or "" if the
+	// runtime does not put containers in their own cgroups.
+	CgroupID() string
+
+	// Stop the container immediately.
+	Stop() error
+
+	// Signal sends the given signal to the container's main
+	// process, without waiting for it to exit.
+	Signal(syscall.Signal) error
+
+	// Wait for the container process to finish, and return its
+	// exit code. Wait does not return until all of the
+	// container's stdout and stderr output has been written to
+	// spec.Stdout and spec.Stderr. If applicable, also remove
+	// the stopped container before returning.
+	Wait(context.Context) (int, error)
+
+	// Close releases resources (temp dirs, stopped containers).
+	Close()
+}
+
+// parseBinds converts docker-style bind strings ("src:dst" or
+// "src:dst:ro") to a map of bindmounts, keyed on container path.
+func parseBinds(binds []string) (map[string]bindmount, error) {
+	mounts := map[string]bindmount{}
+	for _, bind := range binds {
+		parts := strings.Split(bind, ":")
+		switch {
+		case len(parts) == 2:
+			mounts[parts[1]] = bindmount{HostPath: parts[0]}
+		case len(parts) == 3 && parts[2] == "ro":
+			mounts[parts[1]] = bindmount{HostPath: parts[0], ReadOnly: true}
+		default:
+			return nil, fmt.Errorf("invalid bind mount %q", bind)
+		}
+	}
+	return mounts, nil
+}
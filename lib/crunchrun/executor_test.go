@@ -0,0 +1,141 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&executorSuite{})
+
+type executorSuite struct{}
+
+// stubExecutor ignores the container image and runs the container
+// command as a local process.
+type stubExecutor struct {
+	loaded string
+	spec   containerSpec
+	cmd    *exec.Cmd
+}
+
+func (e *stubExecutor) LoadImage(imageID string, openTarball func() (io.ReadCloser, error)) error {
+	rdr, err := openTarball()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, rdr)
+	if err != nil {
+		return err
+	}
+	e.loaded = imageID
+	return nil
+}
+
+func (e *stubExecutor) Create(spec containerSpec) error {
+	e.spec = spec
+	e.cmd = exec.Command(spec.Command[0], spec.Command[1:]...)
+	for k, v := range spec.Env {
+		e.cmd.Env = append(e.cmd.Env, k+"="+v)
+	}
+	e.cmd.Stdin = spec.Stdin
+	e.cmd.Stdout = spec.Stdout
+	e.cmd.Stderr = spec.Stderr
+	return nil
+}
+
+func (e *stubExecutor) Start() error     { return e.cmd.Start() }
+func (e *stubExecutor) CgroupID() string { return "" }
+func (e *stubExecutor) Stop() error      { return e.cmd.Process.Kill() }
+func (e *stubExecutor) Close()           {}
+
+func (e *stubExecutor) Signal(sig syscall.Signal) error { return e.cmd.Process.Signal(sig) }
+
+func (e *stubExecutor) Wait(context.Context) (int, error) {
+	err := e.cmd.Wait()
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		return exiterr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+func (s *executorSuite) runStub(c *C, command []string, env map[string]string) (*ContainerRunner, *stubExecutor, *TestLogs) {
+	kc := &KeepTestClient{}
+	defer kc.Close()
+	cr, err := NewContainerRunner(nil, &ArvTestClient{}, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	c.Assert(err, IsNil)
+	stub := &stubExecutor{}
+	cr.executor = stub
+	cr.ContainerArvClient = &ArvTestClient{}
+	cr.ContainerKeepClient = kc
+
+	var logs TestLogs
+	cr.NewLogWriter = logs.NewTestLoggingWriter
+	cr.Container.ContainerImage = hwPDH
+	cr.Container.Command = command
+	cr.Container.Environment = env
+	cr.Container.Cwd = "."
+	c.Assert(cr.LoadImage(), IsNil)
+	c.Assert(cr.CreateContainer(), IsNil)
+	c.Assert(cr.StartContainer(), IsNil)
+	c.Assert(cr.WaitFinish(), IsNil)
+	return cr, stub, &logs
+}
+
+func (s *executorSuite) TestLocalProcess(c *C) {
+	cr, stub, logs := s.runStub(c, []string{"sh", "-c", "echo $FROBIZ; echo oops >&2"}, map[string]string{"FROBIZ": "bilbo"})
+	c.Check(stub.loaded, Equals, hwImageId)
+	c.Check(stub.spec.Image, Equals, hwImageId)
+	c.Check(stub.spec.EnableNetwork, Equals, false)
+	c.Check(*cr.ExitCode, Equals, 0)
+	c.Check(logs.Stdout.String(), Matches, `(?ms).* bilbo\n$`)
+	c.Check(logs.Stderr.String(), Matches, `(?ms).* oops\n$`)
+}
+
+func (s *executorSuite) TestLocalProcessExitCode(c *C) {
+	cr, _, _ := s.runStub(c, []string{"sh", "-c", "exit 3"}, nil)
+	c.Check(*cr.ExitCode, Equals, 3)
+}
+
+func (s *executorSuite) TestParseBinds(c *C) {
+	binds, err := parseBinds([]string{"/host/tmp:/tmp", "/host/keep/by_id/abc:/keep/abc:ro"})
+	c.Check(err, IsNil)
+	c.Check(binds, DeepEquals, map[string]bindmount{
+		"/tmp":      {HostPath: "/host/tmp"},
+		"/keep/abc": {HostPath: "/host/keep/by_id/abc", ReadOnly: true},
+	})
+	for _, bad := range []string{"/tmp", "/a:/b:rw", "/a:/b:ro:x"} {
+		_, err = parseBinds([]string{bad})
+		c.Check(err, NotNil)
+	}
+}
+
+func (s *executorSuite) TestSingularityArgs(c *C) {
+	e := &singularityExecutor{
+		command: "singularity",
+		sifPath: "/tmp/image.sif",
+		spec: containerSpec{
+			WorkingDir: "/work",
+			Env:        map[string]string{"FOO": "bar"},
+			BindMounts: map[string]bindmount{
+				"/work":      {HostPath: "/host/work"},
+				"/keep/data": {HostPath: "/host/keep/data", ReadOnly: true},
+			},
+			Command: []string{"echo", "ok"},
+		},
+	}
+	c.Check(strings.Join(e.execArgs(), " "), Equals, "singularity exec --containall --cleanenv --pwd /work --net --network=none --bind /host/keep/data:/keep/data:ro --bind /host/work:/work /tmp/image.sif echo ok")
+	c.Check(e.execEnv(), DeepEquals, []string{e.execEnv()[0], "SINGULARITYENV_FOO=bar", "APPTAINERENV_FOO=bar"})
+
+	e.spec.EnableNetwork = true
+	e.spec.WorkingDir = ""
+	c.Check(strings.Join(e.execArgs(), " "), Equals, "singularity exec --containall --cleanenv --bind /host/keep/data:/keep/data:ro --bind /host/work:/work /tmp/image.sif echo ok")
+}
@@ -7,6 +7,7 @@ package crunchrun
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -199,6 +200,7 @@ var crunchLogBytesPerEvent int64 = 4096
 var crunchLogSecondsBetweenEvents = time.Second
 var crunchLogUpdatePeriod = time.Hour / 2
 var crunchLogUpdateSize = int64(1 << 25)
+var crunchStructuredLogs = false
 
 // ArvLogWriter is an io.WriteCloser that processes each write by
 // writing it through to another io.WriteCloser (typically a
@@ -209,6 +211,10 @@ type ArvLogWriter struct {
 	loggingStream string
 	writeCloser   io.WriteCloser
 
+	// If not nil, each line is also written here as a JSON
+	// record.
+	structured *structuredLogWriter
+
 	// for rate limiting
 	bytesLogged                  int64
 	logThrottleResetTime         time.Time
@@ -229,6 +235,9 @@ func (arvlog *ArvLogWriter) Write(p []byte) (int, error) {
 	if arvlog.writeCloser != nil {
 		_, err1 = arvlog.writeCloser.Write(p)
 	}
+	if arvlog.structured != nil {
+		arvlog.structured.writeLines(arvlog.loggingStream, p)
+	}
 
 	// write to API after checking rate limit
 	now := time.Now()
@@ -298,6 +307,120 @@ func (arvlog *ArvLogWriter) Close() (err error) {
 	return err
 }
 
+// structuredLogWriter writes log lines from all log streams to a
+// single file (log.jsonl in the log collection), one JSON object per
+// line, so log aggregation systems can ingest them without parsing
+// the text logs.
+type structuredLogWriter struct {
+	containerUUID string
+
+	mtx sync.Mutex
+	w   io.WriteCloser
+	buf bytes.Buffer
+}
+
+// structuredLogEntry is the format of each record in log.jsonl.
+type structuredLogEntry struct {
+	Timestamp     string `json:"timestamp"`
+	ContainerUUID string `json:"container_uuid"`
+	Stream        string `json:"stream"`
+	Source        string `json:"source"`
+	Severity      string `json:"severity"`
+	Message       string `json:"message"`
+}
+
+// Start sending log lines to w. Until Start is called, log lines
+// are discarded.
+func (slw *structuredLogWriter) Start(w io.WriteCloser) {
+	slw.mtx.Lock()
+	defer slw.mtx.Unlock()
+	slw.w = w
+}
+
+// Close the underlying writer. Log lines written after Close are
+// discarded.
+func (slw *structuredLogWriter) Close() error {
+	slw.mtx.Lock()
+	defer slw.mtx.Unlock()
+	if slw.w == nil {
+		return nil
+	}
+	err := slw.w.Close()
+	slw.w = nil
+	return err
+}
+
+// writeLines writes a record for each line in p, which is expected
+// to be a sequence of timestamped lines as produced by
+// ThrottledLogger.
+func (slw *structuredLogWriter) writeLines(stream string, p []byte) {
+	slw.mtx.Lock()
+	defer slw.mtx.Unlock()
+	if slw.w == nil {
+		return
+	}
+	slw.buf.Reset()
+	enc := json.NewEncoder(&slw.buf)
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		ent := structuredLogEntry{
+			ContainerUUID: slw.containerUUID,
+			Stream:        stream,
+			Source:        logSource(stream),
+		}
+		if sp := bytes.IndexByte(line, ' '); sp > 0 && isTimestamp(line[:sp]) {
+			ent.Timestamp = string(line[:sp])
+			ent.Message = string(line[sp+1:])
+		} else {
+			ent.Timestamp = RFC3339Timestamp(time.Now().UTC())
+			ent.Message = string(line)
+		}
+		ent.Severity = logSeverity(ent.Message)
+		enc.Encode(ent)
+	}
+	slw.w.Write(slw.buf.Bytes())
+}
+
+func isTimestamp(b []byte) bool {
+	_, err := time.Parse(time.RFC3339Nano, string(b))
+	return err == nil
+}
+
+// logSource returns the component that produced the given log
+// stream: the container itself, arv-mount, or crunch-run.
+func logSource(stream string) string {
+	switch stream {
+	case "stdout", "stderr":
+		return "container"
+	case "arv-mount":
+		return "arv-mount"
+	default:
+		return "crunch-run"
+	}
+}
+
+var (
+	errorMessageRegexp   = regexp.MustCompile(`(?i)^\W*(error|fatal|panic|critical|crit)\b`)
+	warningMessageRegexp = regexp.MustCompile(`(?i)^\W*warn(ing)?\b`)
+)
+
+// logSeverity returns "error", "warning", or "info", depending on
+// the leading word of the message (optionally enclosed in brackets,
+// as in "[ERROR] ..."). Messages without a recognized level are
+// "info", regardless of which stream they were written to.
+func logSeverity(message string) string {
+	switch {
+	case errorMessageRegexp.MatchString(message):
+		return "error"
+	case warningMessageRegexp.MatchString(message):
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
 var lineRegexp = regexp.MustCompile(`^\S+ (.*)`)
 
 // Test for hard cap on total output and for log throttling. Returns whether
@@ -404,4 +527,9 @@ func loadLogThrottleParams(clnt IArvadosClient) {
 	loadInt64(&crunchLogUpdateSize, "crunchLogUpdateSize")
 	loadDuration(&crunchLogUpdatePeriod, "crunchLogUpdatePeriod")
 
+	if param, err := clnt.Discovery("crunchStructuredLogs"); err == nil {
+		if val, ok := param.(bool); ok {
+			crunchStructuredLogs = val
+		}
+	}
 }
@@ -5,7 +5,10 @@
 package crunchrun
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -45,7 +48,7 @@ func (s *LoggingTestSuite) TestWriteLogs(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 
@@ -74,7 +77,7 @@ func (s *LoggingTestSuite) TestWriteLogsLarge(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 	cr.CrunchLog.Immediate = nil
@@ -97,7 +100,7 @@ func (s *LoggingTestSuite) TestWriteMultipleLogs(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	ts := &TestTimestamper{}
 	cr.CrunchLog.Timestamper = ts.Timestamp
@@ -131,6 +134,98 @@ func (s *LoggingTestSuite) TestWriteMultipleLogs(c *C) {
 	c.Check(mt, Equals, ". 48f9023dc683a850b1c9b482b14c4b97+163 0:83:crunch-run.txt 83:80:stdout.txt\n")
 }
 
+func (s *LoggingTestSuite) TestStructuredLog(c *C) {
+	api := &ArvTestClient{}
+	kc := &KeepTestClient{}
+	defer kc.Close()
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
+	c.Assert(err, IsNil)
+	c.Assert(cr.EnableStructuredLog(), IsNil)
+	ts := &TestTimestamper{}
+	cr.CrunchLog.Timestamper = ts.Timestamp
+	cr.CrunchLog.Immediate = nil
+	w, err := cr.NewLogWriter("stderr")
+	c.Assert(err, IsNil)
+	stderr := NewThrottledLogger(w)
+	stderr.Timestamper = ts.Timestamp
+
+	cr.CrunchLog.Print("Hello world!")
+	stderr.Print("Doing stuff")
+	cr.CrunchLog.Print("error: something went wrong")
+	cr.CrunchLog.Print("Warning: low on disk space")
+	cr.CrunchLog.Close()
+	stderr.Close()
+	c.Check(cr.structuredLog.Close(), IsNil)
+
+	f, err := cr.LogCollection.Open("log.jsonl")
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	var ents []structuredLogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		var ent structuredLogEntry
+		c.Check(json.Unmarshal([]byte(line), &ent), IsNil)
+		c.Check(ent.ContainerUUID, Equals, "zzzzz-zzzzzzzzzzzzzzz")
+		ents = append(ents, ent)
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Timestamp < ents[j].Timestamp })
+	c.Assert(ents, HasLen, 4)
+	c.Check(ents[0], DeepEquals, structuredLogEntry{
+		Timestamp:     "2015-12-29T15:51:45.000000001Z",
+		ContainerUUID: "zzzzz-zzzzzzzzzzzzzzz",
+		Stream:        "crunch-run",
+		Source:        "crunch-run",
+		Severity:      "info",
+		Message:       "Hello world!",
+	})
+	c.Check(ents[1].Stream, Equals, "stderr")
+	c.Check(ents[1].Source, Equals, "container")
+	c.Check(ents[1].Severity, Equals, "info")
+	c.Check(ents[1].Message, Equals, "Doing stuff")
+	c.Check(ents[2].Severity, Equals, "error")
+	c.Check(ents[3].Severity, Equals, "warning")
+
+	// Text logs are unchanged.
+	mt, err := cr.LogCollection.MarshalManifest(".")
+	c.Check(err, IsNil)
+	c.Check(mt, Matches, `\. \S+ 0:\d+:crunch-run\.txt \d+:\d+:log\.jsonl \d+:43:stderr\.txt\n`)
+}
+
+func (s *LoggingTestSuite) TestLogSeverity(c *C) {
+	for _, trial := range []struct {
+		message  string
+		severity string
+	}{
+		{"Doing stuff", "info"},
+		{"no errors found", "info"},
+		{"error: something went wrong", "error"},
+		{"ERROR something went wrong", "error"},
+		{"[ERROR] something went wrong", "error"},
+		{"Fatal: giving up", "error"},
+		{"panic: runtime error", "error"},
+		{"Warning: low on disk space", "warning"},
+		{"[WARN] low on disk space", "warning"},
+		{"warnings are not errors", "info"},
+	} {
+		c.Check(logSeverity(trial.message), Equals, trial.severity, Commentf("%q", trial.message))
+	}
+}
+
+func (s *LoggingTestSuite) TestStructuredLogsFromDiscovery(c *C) {
+	defer func() {
+		delete(discoveryMap, "crunchStructuredLogs")
+		crunchStructuredLogs = false
+	}()
+
+	discoveryMap["crunchStructuredLogs"] = true
+	loadLogThrottleParams(&ArvTestClient{})
+	c.Check(crunchStructuredLogs, Equals, true)
+
+	discoveryMap["crunchStructuredLogs"] = false
+	loadLogThrottleParams(&ArvTestClient{})
+	c.Check(crunchStructuredLogs, Equals, false)
+}
+
 func (s *LoggingTestSuite) TestLogUpdate(c *C) {
 	for _, trial := range []struct {
 		maxBytes    int64
@@ -146,7 +241,7 @@ func (s *LoggingTestSuite) TestLogUpdate(c *C) {
 		api := &ArvTestClient{}
 		kc := &KeepTestClient{}
 		defer kc.Close()
-		cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+		cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 		c.Assert(err, IsNil)
 		ts := &TestTimestamper{}
 		cr.CrunchLog.Timestamper = ts.Timestamp
@@ -197,7 +292,7 @@ func (s *LoggingTestSuite) testWriteLogsWithRateLimit(c *C, throttleParam string
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 
@@ -0,0 +1,224 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// singularityExecutor runs containers using Singularity/Apptainer,
+// which (unlike docker) doesn't need a daemon or root privileges.
+//
+// Docker image tarballs are converted to SIF images in tmpdir. RAM
+// and VCPU constraints are not enforced.
+type singularityExecutor struct {
+	logf    func(string, ...interface{})
+	command string // "singularity" or "apptainer"
+	tmpdir  string
+	sifPath string
+	spec    containerSpec
+	child   *exec.Cmd
+	exited  chan struct{}
+}
+
+func newSingularityExecutor(command string, logf func(string, ...interface{})) (*singularityExecutor, error) {
+	if command == "" {
+		command = "singularity"
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, err
+	}
+	tmpdir, err := ioutil.TempDir("", "crunch-run-singularity-")
+	if err != nil {
+		return nil, err
+	}
+	return &singularityExecutor{
+		logf:    logf,
+		command: command,
+		tmpdir:  tmpdir,
+	}, nil
+}
+
+func (e *singularityExecutor) LoadImage(imageID string, openTarball func() (io.ReadCloser, error)) error {
+	e.sifPath = filepath.Join(e.tmpdir, imageID+".sif")
+	if _, err := os.Stat(e.sifPath); err == nil {
+		e.logf("Singularity image is available")
+		return nil
+	}
+
+	e.logf("Loading Docker image from keep")
+	rdr, err := openTarball()
+	if err != nil {
+		return fmt.Errorf("While creating ManifestFileReader for container image: %v", err)
+	}
+	defer rdr.Close()
+	tarPath := filepath.Join(e.tmpdir, imageID+".tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tarPath)
+	_, err = io.Copy(f, rdr)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("While copying container image to local disk: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	e.logf("Converting Docker image to Singularity image")
+	build := exec.Command(e.command, "build", e.sifPath, "docker-archive://"+tarPath)
+	// Keep singularity's cache and temp files in our own tmpdir
+	// so they get cleaned up by Close().
+	build.Env = append(os.Environ(),
+		"SINGULARITY_CACHEDIR="+e.tmpdir,
+		"SINGULARITY_TMPDIR="+e.tmpdir,
+		"APPTAINER_CACHEDIR="+e.tmpdir,
+		"APPTAINER_TMPDIR="+e.tmpdir)
+	out, err := build.CombinedOutput()
+	e.logf("%s build output: %s", e.command, out)
+	if err != nil {
+		return fmt.Errorf("While converting container image: %v", err)
+	}
+	return nil
+}
+
+func (e *singularityExecutor) Create(spec containerSpec) error {
+	e.spec = spec
+	return nil
+}
+
+// execArgs returns the command line arguments used to run the
+// container.
+func (e *singularityExecutor) execArgs() []string {
+	args := []string{e.command, "exec", "--containall", "--cleanenv"}
+	if e.spec.WorkingDir != "" {
+		args = append(args, "--pwd", e.spec.WorkingDir)
+	}
+	if !e.spec.EnableNetwork {
+		args = append(args, "--net", "--network=none")
+	}
+	var ctrPaths []string
+	for path := range e.spec.BindMounts {
+		ctrPaths = append(ctrPaths, path)
+	}
+	sort.Strings(ctrPaths)
+	for _, path := range ctrPaths {
+		mount := e.spec.BindMounts[path]
+		bind := mount.HostPath + ":" + path
+		if mount.ReadOnly {
+			bind += ":ro"
+		}
+		args = append(args, "--bind", bind)
+	}
+	args = append(args, e.sifPath)
+	return append(args, e.spec.Command...)
+}
+
+// execEnv returns the environment for the singularity process:
+// variables prefixed with SINGULARITYENV_ (and APPTAINERENV_) are
+// passed into the container without the prefix.
+func (e *singularityExecutor) execEnv() []string {
+	env := []string{"PATH=" + os.Getenv("PATH")}
+	for k, v := range e.spec.Env {
+		env = append(env, "SINGULARITYENV_"+k+"="+v, "APPTAINERENV_"+k+"="+v)
+	}
+	return env
+}
+
+func (e *singularityExecutor) Start() error {
+	args := e.execArgs()
+	child := &exec.Cmd{
+		Path:   args[0],
+		Args:   args,
+		Env:    e.execEnv(),
+		Stdin:  e.spec.Stdin,
+		Stdout: e.spec.Stdout,
+		Stderr: e.spec.Stderr,
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	child.Path = path
+	e.logf("Starting %s container", e.command)
+	err = child.Start()
+	if err != nil {
+		return err
+	}
+	e.child = child
+	e.exited = make(chan struct{})
+	return nil
+}
+
+func (e *singularityExecutor) CgroupID() string {
+	return ""
+}
+
+func (e *singularityExecutor) Stop() error {
+	if e.child == nil {
+		return nil
+	}
+	err := e.child.Process.Signal(syscall.SIGKILL)
+	if err != nil {
+		select {
+		case <-e.exited:
+			// Already exited.
+			return nil
+		default:
+		}
+	}
+	return err
+}
+
+func (e *singularityExecutor) Signal(sig syscall.Signal) error {
+	if e.child == nil {
+		return fmt.Errorf("container not started")
+	}
+	return e.child.Process.Signal(sig)
+}
+
+func (e *singularityExecutor) Wait(ctx context.Context) (int, error) {
+	if e.child == nil {
+		return -1, fmt.Errorf("container not started")
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- e.child.Wait()
+		close(e.exited)
+	}()
+	select {
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	case err := <-waitErr:
+		if e.spec.Stdin != nil {
+			e.spec.Stdin.Close()
+		}
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode(), nil
+		} else if err != nil {
+			return -1, err
+		}
+		return 0, nil
+	}
+}
+
+func (e *singularityExecutor) Close() {
+	err := os.RemoveAll(e.tmpdir)
+	if err != nil {
+		e.logf("error removing temp dir: %s", err)
+	}
+}
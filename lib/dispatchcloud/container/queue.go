@@ -15,7 +15,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-type typeChooser func(*arvados.Container) (arvados.InstanceType, error)
+// A typeChooser returns the instance types that can be used to run
+// the given container, in order of preference.
+type typeChooser func(*arvados.Container) ([]arvados.InstanceType, error)
 
 // An APIClient performs Arvados API requests. It is typically an
 // *arvados.Client.
@@ -31,6 +33,20 @@ type QueueEnt struct {
 	// populated.
 	Container    arvados.Container    `json:"container"`
 	InstanceType arvados.InstanceType `json:"instance_type"`
+
+	// All instance types that can be used to run the container,
+	// in order of preference. The first one is InstanceType; the
+	// rest are fallbacks to use if the cloud provider does not
+	// have capacity for the preferred type.
+	InstanceTypes []arvados.InstanceType `json:"instance_types"`
+
+	// The user the container runs as, and the project that owns
+	// the earliest container request that uses this container.
+	// These are used for fair-share scheduling. ProjectUUID is
+	// empty unless the queue was configured to look up projects
+	// (see FetchProjects) and a container request was found.
+	UserUUID    string `json:"user_uuid"`
+	ProjectUUID string `json:"project_uuid"`
 }
 
 // String implements fmt.Stringer by returning the queued container's
@@ -62,6 +78,10 @@ type Queue struct {
 	updated time.Time
 	mtx     sync.Mutex
 
+	// If true, look up the project UUID of each new entry (see
+	// FetchProjects).
+	fetchProjects bool
+
 	// Methods that modify the Queue (like Lock) add the affected
 	// container UUIDs to dontupdate. When applying a batch of
 	// updates received from the network, anything appearing in
@@ -77,7 +97,7 @@ type Queue struct {
 
 // NewQueue returns a new Queue. When a new container appears in the
 // Arvados cluster's queue during Update, chooseType will be called to
-// assign an appropriate arvados.InstanceType for the queue entry.
+// assign appropriate arvados.InstanceTypes for the queue entry.
 func NewQueue(logger logrus.FieldLogger, reg *prometheus.Registry, chooseType typeChooser, client APIClient) *Queue {
 	cq := &Queue{
 		logger:      logger,
@@ -92,6 +112,14 @@ func NewQueue(logger logrus.FieldLogger, reg *prometheus.Registry, chooseType ty
 	return cq
 }
 
+// FetchProjects arranges for Update to look up the project that owns
+// each newly queued container's earliest container request, and
+// report it in the ProjectUUID field of the queue entry. It must be
+// called before the first Update.
+func (cq *Queue) FetchProjects() {
+	cq.fetchProjects = true
+}
+
 // Subscribe returns a channel that becomes ready to receive when an
 // entry in the Queue is updated.
 //
@@ -185,6 +213,25 @@ func (cq *Queue) Update() error {
 		return err
 	}
 
+	var projects map[string]string
+	if cq.fetchProjects {
+		var added []string
+		cq.mtx.Lock()
+		for uuid := range next {
+			if _, ok := cq.current[uuid]; !ok {
+				added = append(added, uuid)
+			}
+		}
+		cq.mtx.Unlock()
+		projects, err = cq.fetchProjectUUIDs(added)
+		if err != nil {
+			// Fair-share scheduling will treat these
+			// containers as having no project, which is
+			// better than not scheduling anything.
+			cq.logger.WithError(err).Warn("error looking up container request owners")
+		}
+	}
+
 	cq.mtx.Lock()
 	defer cq.mtx.Unlock()
 	for uuid, ctr := range next {
@@ -194,7 +241,7 @@ func (cq *Queue) Update() error {
 			continue
 		}
 		if cur, ok := cq.current[uuid]; !ok {
-			cq.addEnt(uuid, *ctr)
+			cq.addEnt(uuid, *ctr, projects[uuid])
 		} else {
 			cur.Container = *ctr
 			cq.current[uuid] = cur
@@ -229,8 +276,11 @@ func (cq *Queue) delEnt(uuid string, state arvados.ContainerState) {
 	delete(cq.current, uuid)
 }
 
-func (cq *Queue) addEnt(uuid string, ctr arvados.Container) {
-	it, err := cq.chooseType(&ctr)
+func (cq *Queue) addEnt(uuid string, ctr arvados.Container, projectUUID string) {
+	types, err := cq.chooseType(&ctr)
+	if err == nil && len(types) == 0 {
+		err = errors.New("no suitable instance type")
+	}
 	if err != nil && (ctr.State == arvados.ContainerStateQueued || ctr.State == arvados.ContainerStateLocked) {
 		// We assume here that any chooseType error is a hard
 		// error: it wouldn't help to try again, or to leave
@@ -278,13 +328,65 @@ func (cq *Queue) addEnt(uuid string, ctr arvados.Container) {
 		}()
 		return
 	}
+	var it arvados.InstanceType
+	if len(types) > 0 {
+		it = types[0]
+	}
 	cq.logger.WithFields(logrus.Fields{
 		"ContainerUUID": ctr.UUID,
 		"State":         ctr.State,
 		"Priority":      ctr.Priority,
 		"InstanceType":  it.Name,
 	}).Info("adding container to queue")
-	cq.current[uuid] = QueueEnt{Container: ctr, InstanceType: it}
+	cq.current[uuid] = QueueEnt{
+		Container:     ctr,
+		InstanceType:  it,
+		InstanceTypes: types,
+		UserUUID:      ctr.RuntimeUserUUID,
+		ProjectUUID:   projectUUID,
+	}
+}
+
+// fetchProjectUUIDs returns the owner of the earliest container
+// request using each of the given containers, keyed by container
+// UUID. If an error occurs, it returns the owners found so far
+// along with the error.
+func (cq *Queue) fetchProjectUUIDs(uuids []string) (map[string]string, error) {
+	projects := make(map[string]string, len(uuids))
+	for len(uuids) > 0 {
+		batch := uuids
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		uuids = uuids[len(batch):]
+		params := arvados.ResourceListParams{
+			Select: []string{"uuid", "container_uuid", "owner_uuid"},
+			Order:  "created_at",
+			Count:  "none",
+			Filters: []arvados.Filter{{
+				Attr:     "container_uuid",
+				Operator: "in",
+				Operand:  batch,
+			}},
+		}
+		for {
+			var list arvados.ContainerRequestList
+			err := cq.client.RequestAndDecode(&list, "GET", "arvados/v1/container_requests", nil, params)
+			if err != nil {
+				return projects, err
+			}
+			if len(list.Items) == 0 {
+				break
+			}
+			for _, cr := range list.Items {
+				if _, ok := projects[cr.ContainerUUID]; !ok {
+					projects[cr.ContainerUUID] = cr.OwnerUUID
+				}
+			}
+			params.Offset += len(list.Items)
+		}
+	}
+	return projects, nil
 }
 
 // Lock acquires the dispatch lock for the given container.
@@ -309,6 +411,22 @@ func (cq *Queue) setRuntimeError(uuid, errorString string) error {
 	})
 }
 
+// AddCost adds the given amount to the container's cost field.
+// The cost field is only updated by dispatchers, so (unlike
+// runtime_status) there are no concurrent updates to lose.
+func (cq *Queue) AddCost(uuid string, cost float64) error {
+	var ctr arvados.Container
+	err := cq.client.RequestAndDecode(&ctr, "GET", "arvados/v1/containers/"+uuid, nil, map[string][]string{"select": {"cost"}})
+	if err != nil {
+		return err
+	}
+	return cq.client.RequestAndDecode(nil, "PUT", "arvados/v1/containers/"+uuid, nil, map[string]map[string]interface{}{
+		"container": {
+			"cost": ctr.Cost + cost,
+		},
+	})
+}
+
 // Cancel cancels the given container.
 func (cq *Queue) Cancel(uuid string) error {
 	var resp arvados.Container
@@ -382,7 +500,7 @@ func (cq *Queue) poll() (map[string]*arvados.Container, error) {
 			*next[upd.UUID] = upd
 		}
 	}
-	selectParam := []string{"uuid", "state", "priority", "runtime_constraints", "container_image", "mounts", "scheduling_parameters"}
+	selectParam := []string{"uuid", "state", "priority", "runtime_constraints", "container_image", "mounts", "scheduling_parameters", "runtime_user_uuid"}
 	limitParam := 1000
 
 	mine, err := cq.fetchAll(arvados.ResourceListParams{
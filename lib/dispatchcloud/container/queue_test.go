@@ -5,7 +5,9 @@
 package container
 
 import (
+	"encoding/json"
 	"errors"
+	"io"
 	"os"
 	"sync"
 	"testing"
@@ -40,8 +42,8 @@ func (suite *IntegrationSuite) TearDownTest(c *check.C) {
 }
 
 func (suite *IntegrationSuite) TestGetLockUnlockCancel(c *check.C) {
-	typeChooser := func(ctr *arvados.Container) (arvados.InstanceType, error) {
-		return arvados.InstanceType{Name: "testType"}, nil
+	typeChooser := func(ctr *arvados.Container) ([]arvados.InstanceType, error) {
+		return []arvados.InstanceType{{Name: "testType"}}, nil
 	}
 
 	client := arvados.NewClientFromEnv()
@@ -105,7 +107,7 @@ func (suite *IntegrationSuite) TestGetLockUnlockCancel(c *check.C) {
 }
 
 func (suite *IntegrationSuite) TestCancelIfNoInstanceType(c *check.C) {
-	errorTypeChooser := func(ctr *arvados.Container) (arvados.InstanceType, error) {
+	errorTypeChooser := func(ctr *arvados.Container) ([]arvados.InstanceType, error) {
 		// Make sure the relevant container fields are
 		// actually populated.
 		c.Check(ctr.ContainerImage, check.Equals, "test")
@@ -113,7 +115,7 @@ func (suite *IntegrationSuite) TestCancelIfNoInstanceType(c *check.C) {
 		c.Check(ctr.RuntimeConstraints.RAM, check.Equals, int64(12000000000))
 		c.Check(ctr.Mounts["/tmp"].Capacity, check.Equals, int64(24000000000))
 		c.Check(ctr.Mounts["/var/spool/cwl"].Capacity, check.Equals, int64(24000000000))
-		return arvados.InstanceType{}, errors.New("no suitable instance type")
+		return nil, errors.New("no suitable instance type")
 	}
 
 	client := arvados.NewClientFromEnv()
@@ -161,3 +163,101 @@ func (suite *IntegrationSuite) waitfor(c *check.C, timeout time.Duration, fn fun
 		time.Sleep(timeout / 1000)
 	}
 }
+
+var _ = check.Suite(&QueueSuite{})
+
+type QueueSuite struct{}
+
+// stubAPIClient serves canned list responses, keyed by path.
+type stubAPIClient struct {
+	lists  map[string]interface{}
+	errors map[string]error
+	calls  []arvados.ResourceListParams
+	mtx    sync.Mutex
+}
+
+func (api *stubAPIClient) RequestAndDecode(dst interface{}, method, path string, body io.Reader, params interface{}) error {
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	var resp interface{}
+	if path == "arvados/v1/api_client_authorizations/current" {
+		resp = arvados.APIClientAuthorization{UUID: "zzzzz-gj3su-000000000000000"}
+	} else if p, ok := params.(arvados.ResourceListParams); ok && p.Offset == 0 && len(p.Filters) > 0 && p.Filters[len(p.Filters)-1].Operator != ">" {
+		if path == "arvados/v1/container_requests" {
+			api.calls = append(api.calls, p)
+		}
+		if err := api.errors[path]; err != nil {
+			return err
+		}
+		resp = api.lists[path]
+	}
+	if resp == nil {
+		resp = map[string]interface{}{"items": []interface{}{}}
+	}
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, dst)
+}
+
+func (suite *QueueSuite) stubAPI() *stubAPIClient {
+	return &stubAPIClient{lists: map[string]interface{}{
+		"arvados/v1/containers": arvados.ContainerList{Items: []arvados.Container{
+			{UUID: "zzzzz-dz642-000000000000001", State: arvados.ContainerStateQueued, Priority: 1, RuntimeUserUUID: "zzzzz-tpzed-000000000000001"},
+			{UUID: "zzzzz-dz642-000000000000002", State: arvados.ContainerStateQueued, Priority: 1, RuntimeUserUUID: "zzzzz-tpzed-000000000000002"},
+		}},
+		"arvados/v1/container_requests": arvados.ContainerRequestList{Items: []arvados.ContainerRequest{
+			{UUID: "zzzzz-xvhdp-000000000000001", ContainerUUID: "zzzzz-dz642-000000000000001", OwnerUUID: "zzzzz-j7d0g-000000000000001"},
+			{UUID: "zzzzz-xvhdp-000000000000002", ContainerUUID: "zzzzz-dz642-000000000000001", OwnerUUID: "zzzzz-j7d0g-000000000000002"},
+		}},
+	}}
+}
+
+func (suite *QueueSuite) typeChooser(ctr *arvados.Container) ([]arvados.InstanceType, error) {
+	return []arvados.InstanceType{{Name: "type1"}}, nil
+}
+
+func (suite *QueueSuite) TestUserUUID(c *check.C) {
+	api := suite.stubAPI()
+	cq := NewQueue(logger(), nil, suite.typeChooser, api)
+	c.Assert(cq.Update(), check.IsNil)
+	ents, _ := cq.Entries()
+	c.Assert(ents, check.HasLen, 2)
+	c.Check(ents["zzzzz-dz642-000000000000001"].UserUUID, check.Equals, "zzzzz-tpzed-000000000000001")
+	c.Check(ents["zzzzz-dz642-000000000000002"].UserUUID, check.Equals, "zzzzz-tpzed-000000000000002")
+	c.Check(ents["zzzzz-dz642-000000000000001"].ProjectUUID, check.Equals, "")
+
+	// Container requests are not looked up unless FetchProjects
+	// was called.
+	c.Check(api.calls, check.HasLen, 0)
+}
+
+func (suite *QueueSuite) TestFetchProjects(c *check.C) {
+	api := suite.stubAPI()
+	cq := NewQueue(logger(), nil, suite.typeChooser, api)
+	cq.FetchProjects()
+	c.Assert(cq.Update(), check.IsNil)
+	ents, _ := cq.Entries()
+	c.Assert(ents, check.HasLen, 2)
+	c.Check(ents["zzzzz-dz642-000000000000001"].ProjectUUID, check.Equals, "zzzzz-j7d0g-000000000000001")
+	c.Check(ents["zzzzz-dz642-000000000000002"].ProjectUUID, check.Equals, "")
+	c.Check(api.calls, check.HasLen, 1)
+
+	// Projects are looked up only when a container is first
+	// added to the queue.
+	c.Assert(cq.Update(), check.IsNil)
+	c.Check(api.calls, check.HasLen, 1)
+}
+
+func (suite *QueueSuite) TestFetchProjectsError(c *check.C) {
+	api := suite.stubAPI()
+	api.errors = map[string]error{"arvados/v1/container_requests": errors.New("stub error")}
+	cq := NewQueue(logger(), nil, suite.typeChooser, api)
+	cq.FetchProjects()
+	c.Assert(cq.Update(), check.IsNil)
+	ents, _ := cq.Entries()
+	c.Assert(ents, check.HasLen, 2)
+	c.Check(ents["zzzzz-dz642-000000000000001"].UserUUID, check.Equals, "zzzzz-tpzed-000000000000001")
+	c.Check(ents["zzzzz-dz642-000000000000001"].ProjectUUID, check.Equals, "")
+}
@@ -59,6 +59,9 @@ type dispatcher struct {
 	httpHandler http.Handler
 	sshKey      ssh.Signer
 
+	sched    *scheduler.Scheduler // nil until run() starts it
+	schedMtx sync.Mutex
+
 	setupOnce sync.Once
 	stop      chan struct{}
 	stopped   chan struct{}
@@ -106,8 +109,8 @@ func (disp *dispatcher) newExecutor(inst cloud.Instance) worker.Executor {
 	return exr
 }
 
-func (disp *dispatcher) typeChooser(ctr *arvados.Container) (arvados.InstanceType, error) {
-	return ChooseInstanceType(disp.Cluster, ctr)
+func (disp *dispatcher) typeChooser(ctr *arvados.Container) ([]arvados.InstanceType, error) {
+	return ChooseInstanceTypes(disp.Cluster, ctr)
 }
 
 func (disp *dispatcher) setup() {
@@ -132,6 +135,10 @@ func (disp *dispatcher) initialize() {
 	disp.stop = make(chan struct{}, 1)
 	disp.stopped = make(chan struct{})
 
+	if fs := disp.Cluster.Containers.FairShare; fs.Enable && fs.GroupBy != "" && fs.GroupBy != "user" && fs.GroupBy != "project" {
+		disp.logger.Fatalf("invalid Containers.FairShare.GroupBy %q: must be \"user\" or \"project\"", fs.GroupBy)
+	}
+
 	if key, err := ssh.ParsePrivateKey([]byte(disp.Cluster.Containers.DispatchPrivateKey)); err != nil {
 		disp.logger.Fatalf("error parsing configured Containers.DispatchPrivateKey: %s", err)
 	} else {
@@ -144,7 +151,11 @@ func (disp *dispatcher) initialize() {
 	}
 	disp.instanceSet = instanceSet
 	disp.pool = worker.NewPool(disp.logger, disp.ArvClient, disp.Registry, disp.InstanceSetID, disp.instanceSet, disp.newExecutor, disp.sshKey.PublicKey(), disp.Cluster)
-	disp.queue = container.NewQueue(disp.logger, disp.Registry, disp.typeChooser, disp.ArvClient)
+	queue := container.NewQueue(disp.logger, disp.Registry, disp.typeChooser, disp.ArvClient)
+	if fs := disp.Cluster.Containers.FairShare; fs.Enable && fs.GroupBy == "project" {
+		queue.FetchProjects()
+	}
+	disp.queue = queue
 
 	if disp.Cluster.ManagementToken == "" {
 		disp.httpHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -159,6 +170,7 @@ func (disp *dispatcher) initialize() {
 		mux.HandlerFunc("POST", "/arvados/v1/dispatch/instances/drain", disp.apiInstanceDrain)
 		mux.HandlerFunc("POST", "/arvados/v1/dispatch/instances/run", disp.apiInstanceRun)
 		mux.HandlerFunc("POST", "/arvados/v1/dispatch/instances/kill", disp.apiInstanceKill)
+		mux.HandlerFunc("GET", "/arvados/v1/dispatch/fairshare", disp.apiFairShare)
 		metricsH := promhttp.HandlerFor(disp.Registry, promhttp.HandlerOpts{
 			ErrorLog: disp.logger,
 		})
@@ -182,6 +194,17 @@ func (disp *dispatcher) run() {
 		pollInterval = defaultPollInterval
 	}
 	sched := scheduler.New(disp.Context, disp.queue, disp.pool, staleLockTimeout, pollInterval)
+	if fs := disp.Cluster.Containers.FairShare; fs.Enable {
+		sched.SetFairShare(scheduler.FairShare{
+			GroupBy:              fs.GroupBy,
+			HalfLife:             time.Duration(fs.HalfLife),
+			MaxInstancesPerGroup: fs.MaxInstancesPerGroup,
+			Weights:              fs.Weights,
+		})
+	}
+	disp.schedMtx.Lock()
+	disp.sched = sched
+	disp.schedMtx.Unlock()
 	sched.Start()
 	defer sched.Stop()
 
@@ -209,6 +232,24 @@ func (disp *dispatcher) apiInstances(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Management API: fair-share scheduling inputs for each user or
+// project with running or queued containers.
+func (disp *dispatcher) apiFairShare(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		Enabled bool                       `json:"enabled"`
+		Items   []scheduler.FairShareGroup `json:"items"`
+	}
+	resp.Enabled = disp.Cluster.Containers.FairShare.Enable
+	resp.Items = []scheduler.FairShareGroup{}
+	disp.schedMtx.Lock()
+	sched := disp.sched
+	disp.schedMtx.Unlock()
+	if sched != nil {
+		resp.Items = append(resp.Items, sched.FairShareStatus()...)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
 // Management API: set idle behavior to "hold" for specified instance.
 func (disp *dispatcher) apiInstanceHold(w http.ResponseWriter, r *http.Request) {
 	disp.apiInstanceIdleBehavior(w, r, worker.IdleBehaviorHold)
@@ -318,3 +318,51 @@ func (s *DispatcherSuite) TestInstancesAPI(c *check.C) {
 	c.Check(sr.Items[0].ProviderInstanceType, check.Equals, test.InstanceType(1).ProviderType)
 	c.Check(sr.Items[0].ArvadosInstanceType, check.Equals, test.InstanceType(1).Name)
 }
+
+func (s *DispatcherSuite) TestFairShareAPI(c *check.C) {
+	s.cluster.ManagementToken = "abcdefgh"
+	s.cluster.Containers.FairShare.Enable = true
+	Drivers["test"] = s.stubDriver
+	s.disp.setupOnce.Do(s.disp.initialize)
+	queue := &test.Queue{
+		ChooseType: func(ctr *arvados.Container) (arvados.InstanceType, error) {
+			return ChooseInstanceType(s.cluster, ctr)
+		},
+		Containers: []arvados.Container{{
+			UUID:     test.ContainerUUID(1),
+			State:    arvados.ContainerStateQueued,
+			Priority: 1,
+			RuntimeConstraints: arvados.RuntimeConstraints{
+				RAM:   1 << 30,
+				VCPUs: 1,
+			},
+		}},
+		UserUUIDs: map[string]string{test.ContainerUUID(1): arvadostest.ActiveUserUUID},
+	}
+	queue.Update()
+	s.disp.queue = queue
+	go s.disp.run()
+
+	var sr struct {
+		Enabled bool
+		Items   []struct {
+			Group  string
+			Queued int
+		}
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		req := httptest.NewRequest("GET", "/arvados/v1/dispatch/fairshare", nil)
+		req.Header.Set("Authorization", "Bearer abcdefgh")
+		resp := httptest.NewRecorder()
+		s.disp.ServeHTTP(resp, req)
+		c.Assert(resp.Code, check.Equals, http.StatusOK)
+		err := json.Unmarshal(resp.Body.Bytes(), &sr)
+		c.Assert(err, check.IsNil)
+		if len(sr.Items) > 0 {
+			break
+		}
+	}
+	c.Check(sr.Enabled, check.Equals, true)
+	c.Assert(sr.Items, check.HasLen, 1)
+	c.Check(sr.Items[0].Group, check.Equals, arvadostest.ActiveUserUUID)
+}
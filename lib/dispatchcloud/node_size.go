@@ -85,10 +85,27 @@ func EstimateScratchSpace(ctr *arvados.Container) (needScratch int64) {
 
 // ChooseInstanceType returns the cheapest available
 // arvados.InstanceType big enough to run ctr.
-func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (best arvados.InstanceType, err error) {
+func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (arvados.InstanceType, error) {
+	types, err := ChooseInstanceTypes(cc, ctr)
+	if err != nil {
+		return arvados.InstanceType{}, err
+	}
+	return types[0], nil
+}
+
+// ChooseInstanceTypes returns all available arvados.InstanceTypes
+// big enough to run ctr, in order of preference: cheapest first, and
+// among types with equal prices, the ones with more RAM and VCPUs
+// first. The first entry is the one returned by ChooseInstanceType.
+//
+// If ctr is preemptible, non-preemptible types are included as
+// fallbacks (in the same order, after all of the preemptible types)
+// so the container can still run when the cloud provider is out of
+// preemptible capacity. The reverse is not true: a non-preemptible
+// container is never offered a preemptible instance type.
+func ChooseInstanceTypes(cc *arvados.Cluster, ctr *arvados.Container) ([]arvados.InstanceType, error) {
 	if len(cc.InstanceTypes) == 0 {
-		err = ErrInstanceTypesNotConfigured
-		return
+		return nil, ErrInstanceTypesNotConfigured
 	}
 
 	needScratch := EstimateScratchSpace(ctr)
@@ -98,23 +115,19 @@ func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (best arvad
 	needRAM := ctr.RuntimeConstraints.RAM + ctr.RuntimeConstraints.KeepCacheRAM
 	needRAM = (needRAM * 100) / int64(100-discountConfiguredRAMPercent)
 
-	ok := false
+	var types, fallbacks []arvados.InstanceType
 	for _, it := range cc.InstanceTypes {
 		switch {
-		case ok && it.Price > best.Price:
 		case int64(it.Scratch) < needScratch:
 		case int64(it.RAM) < needRAM:
 		case it.VCPUs < needVCPUs:
-		case it.Preemptible != ctr.SchedulingParameters.Preemptible:
-		case it.Price == best.Price && (it.RAM < best.RAM || it.VCPUs < best.VCPUs):
-			// Equal price, but worse specs
-		default:
-			// Lower price || (same price && better specs)
-			best = it
-			ok = true
+		case it.Preemptible == ctr.SchedulingParameters.Preemptible:
+			types = append(types, it)
+		case !it.Preemptible:
+			fallbacks = append(fallbacks, it)
 		}
 	}
-	if !ok {
+	if len(types) == 0 {
 		availableTypes := make([]arvados.InstanceType, 0, len(cc.InstanceTypes))
 		for _, t := range cc.InstanceTypes {
 			availableTypes = append(availableTypes, t)
@@ -122,11 +135,30 @@ func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (best arvad
 		sort.Slice(availableTypes, func(a, b int) bool {
 			return availableTypes[a].Price < availableTypes[b].Price
 		})
-		err = ConstraintsNotSatisfiableError{
+		return nil, ConstraintsNotSatisfiableError{
 			errors.New("constraints not satisfiable by any configured instance type"),
 			availableTypes,
 		}
-		return
 	}
-	return
+	sortInstanceTypes(types)
+	sortInstanceTypes(fallbacks)
+	return append(types, fallbacks...), nil
+}
+
+// sortInstanceTypes sorts the given types by price, and types with
+// equal prices by specs (better specs first).
+func sortInstanceTypes(types []arvados.InstanceType) {
+	sort.Slice(types, func(a, b int) bool {
+		ta, tb := types[a], types[b]
+		switch {
+		case ta.Price != tb.Price:
+			return ta.Price < tb.Price
+		case ta.RAM != tb.RAM:
+			return ta.RAM > tb.RAM
+		case ta.VCPUs != tb.VCPUs:
+			return ta.VCPUs > tb.VCPUs
+		default:
+			return ta.Name < tb.Name
+		}
+	})
 }
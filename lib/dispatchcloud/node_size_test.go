@@ -120,6 +120,54 @@ func (*NodeSizeSuite) TestChoosePreemptable(c *check.C) {
 	c.Check(best.Preemptible, check.Equals, true)
 }
 
+func (*NodeSizeSuite) TestChooseFallbackTypes(c *check.C) {
+	menu := map[string]arvados.InstanceType{
+		"costly":         {Price: 4.4, RAM: 4000000000, VCPUs: 8, Scratch: 2 * GiB, Name: "costly"},
+		"costly-spot":    {Price: 1.5, RAM: 4000000000, VCPUs: 8, Scratch: 2 * GiB, Preemptible: true, Name: "costly-spot"},
+		"best":           {Price: 2.2, RAM: 2000000000, VCPUs: 4, Scratch: 2 * GiB, Name: "best"},
+		"best-spot":      {Price: 0.8, RAM: 2000000000, VCPUs: 4, Scratch: 2 * GiB, Preemptible: true, Name: "best-spot"},
+		"bigger-spot":    {Price: 0.8, RAM: 4000000000, VCPUs: 4, Scratch: 2 * GiB, Preemptible: true, Name: "bigger-spot"},
+		"too-small":      {Price: 1.1, RAM: 1000000000, VCPUs: 2, Scratch: 2 * GiB, Name: "too-small"},
+		"too-small-spot": {Price: 0.4, RAM: 1000000000, VCPUs: 2, Scratch: 2 * GiB, Preemptible: true, Name: "too-small-spot"},
+	}
+	ctr := &arvados.Container{
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs: 2,
+			RAM:   1234567890,
+		},
+	}
+	names := func(types []arvados.InstanceType) (names []string) {
+		for _, it := range types {
+			names = append(names, it.Name)
+		}
+		return
+	}
+
+	types, err := ChooseInstanceTypes(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.IsNil)
+	c.Check(names(types), check.DeepEquals, []string{"best", "costly"})
+
+	// Preemptible containers can fall back to non-preemptible
+	// types, but not vice versa.
+	ctr.SchedulingParameters.Preemptible = true
+	types, err = ChooseInstanceTypes(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.IsNil)
+	c.Check(names(types), check.DeepEquals, []string{"bigger-spot", "best-spot", "costly-spot", "best", "costly"})
+	best, err := ChooseInstanceType(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.IsNil)
+	c.Check(best.Name, check.Equals, "bigger-spot")
+
+	// A preemptible container doesn't get a non-preemptible
+	// instance type if no preemptible types are suitable.
+	for name, it := range menu {
+		if it.Preemptible {
+			delete(menu, name)
+		}
+	}
+	_, err = ChooseInstanceTypes(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.FitsTypeOf, ConstraintsNotSatisfiableError{})
+}
+
 func (*NodeSizeSuite) TestScratchForDockerImage(c *check.C) {
 	n := EstimateScratchSpace(&arvados.Container{
 		ContainerImage: "d5025c0f29f6eef304a7358afa82a822+342",
@@ -0,0 +1,83 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultCostReportInterval = time.Minute
+
+// reportCosts records the cost accrued so far by each running
+// container in its cost field, at most once per costReportInterval.
+// The final cost of a finished container is recorded by
+// finishContainer (see sync) before it is dropped from the queue.
+//
+// The amount added to the cost field is the cost accrued since the
+// last report, so a container's recorded cost keeps growing (rather
+// than starting over from zero) if it is taken over by a new
+// dispatcher process.
+func (sch *Scheduler) reportCosts() {
+	if time.Since(sch.lastCostReport) < sch.costReportInterval {
+		return
+	}
+	sch.lastCostReport = time.Now()
+	running := sch.pool.Running()
+	qEntries, _ := sch.queue.Entries()
+	sch.mtx.Lock()
+	for uuid := range sch.reportedCost {
+		if _, ok := qEntries[uuid]; !ok {
+			delete(sch.reportedCost, uuid)
+		}
+	}
+	sch.mtx.Unlock()
+	for uuid, ent := range qEntries {
+		if ent.Container.State != arvados.ContainerStateRunning {
+			continue
+		}
+		if exited, ok := running[uuid]; !ok || !exited.IsZero() {
+			continue
+		}
+		go sch.reportCost(uuid)
+	}
+}
+
+func (sch *Scheduler) reportCost(uuid string) {
+	if !sch.uuidLock(uuid, "cost") {
+		return
+	}
+	defer sch.uuidUnlock(uuid)
+	sch.addCost(uuid)
+}
+
+// addCost adds the cost accrued by the given container since the
+// last report to its cost field.
+//
+// Caller must have the uuid lock.
+func (sch *Scheduler) addCost(uuid string) {
+	cost := sch.pool.ContainerCost(uuid)
+	sch.mtx.Lock()
+	add := cost - sch.reportedCost[uuid]
+	sch.mtx.Unlock()
+	if add <= 0 {
+		return
+	}
+	logger := sch.logger.WithFields(logrus.Fields{
+		"ContainerUUID": uuid,
+		"Cost":          cost,
+	})
+	err := sch.queue.AddCost(uuid, add)
+	if err != nil {
+		logger.WithError(err).Warn("error updating container cost")
+		return
+	}
+	logger.Debug("updated container cost")
+	sch.mtx.Lock()
+	sch.reportedCost[uuid] = cost
+	sch.mtx.Unlock()
+}
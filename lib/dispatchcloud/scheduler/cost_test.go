@@ -0,0 +1,137 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+)
+
+func (*SchedulerSuite) TestReportCosts(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	pool := stubPool{
+		running: map[string]time.Time{
+			test.ContainerUUID(1): {},
+			test.ContainerUUID(2): {},
+			test.ContainerUUID(3): time.Now(),
+		},
+		costs: map[string]float64{
+			test.ContainerUUID(1): 1.5,
+			test.ContainerUUID(2): 0.25,
+			test.ContainerUUID(3): 2,
+		},
+	}
+	queue := test.Queue{
+		ChooseType: chooseType,
+		Containers: []arvados.Container{
+			{
+				UUID:          test.ContainerUUID(1),
+				State:         arvados.ContainerStateRunning,
+				RuntimeStatus: map[string]interface{}{"warning": "foo"},
+			},
+			{
+				// Cost was already recorded by a
+				// previous dispatcher process
+				UUID:  test.ContainerUUID(2),
+				State: arvados.ContainerStateRunning,
+				Cost:  1.0,
+			},
+			{
+				// crunch-run exited
+				UUID:  test.ContainerUUID(3),
+				State: arvados.ContainerStateRunning,
+			},
+			{
+				UUID:  test.ContainerUUID(4),
+				State: arvados.ContainerStateLocked,
+			},
+		},
+	}
+	queue.Update()
+
+	costs := func() []float64 {
+		queue.Update()
+		var costs []float64
+		for i := 1; i <= 4; i++ {
+			ctr, _ := queue.Get(test.ContainerUUID(i))
+			costs = append(costs, ctr.Cost)
+		}
+		return costs
+	}
+	waitForCosts := func(expect []float64) {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && fmt.Sprint(costs()) != fmt.Sprint(expect); time.Sleep(time.Millisecond) {
+		}
+		c.Check(costs(), check.DeepEquals, expect)
+	}
+
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond)
+	sch.costReportInterval = time.Hour
+	sch.reportCosts()
+	waitForCosts([]float64{1.5, 1.25, 0, 0})
+	ctr, _ := queue.Get(test.ContainerUUID(1))
+	c.Check(ctr.RuntimeStatus["warning"], check.Equals, "foo")
+
+	// Nothing more is reported until costReportInterval has
+	// passed.
+	pool.Lock()
+	pool.costs[test.ContainerUUID(1)] = 2.5
+	pool.Unlock()
+	sch.reportCosts()
+	time.Sleep(10 * time.Millisecond)
+	c.Check(costs(), check.DeepEquals, []float64{1.5, 1.25, 0, 0})
+
+	// Only the cost accrued since the last report is added.
+	sch.lastCostReport = time.Time{}
+	sch.reportCosts()
+	waitForCosts([]float64{2.5, 1.25, 0, 0})
+}
+
+func (*SchedulerSuite) TestReportFinalCost(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	pool := stubPool{
+		running: map[string]time.Time{
+			// crunch-run exited after finalizing the
+			// container
+			test.ContainerUUID(1): time.Now(),
+		},
+		costs: map[string]float64{
+			test.ContainerUUID(1): 0.125,
+		},
+	}
+	queue := test.Queue{
+		ChooseType: chooseType,
+		Containers: []arvados.Container{
+			{
+				UUID:  test.ContainerUUID(1),
+				State: arvados.ContainerStateRunning,
+			},
+		},
+	}
+	queue.Update()
+	queue.Containers[0].State = arvados.ContainerStateComplete
+	queue.Update()
+
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond)
+	// The periodic report skips containers that are not
+	// running.
+	sch.reportCosts()
+	// sync records the final cost before dropping the
+	// container from the queue.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		sch.sync()
+		if _, ok := queue.Get(test.ContainerUUID(1)); !ok {
+			break
+		}
+	}
+	_, ok := queue.Get(test.ContainerUUID(1))
+	c.Check(ok, check.Equals, false)
+	c.Check(queue.Containers[0].Cost, check.Equals, 0.125)
+}
@@ -0,0 +1,203 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/container"
+)
+
+const defaultFairShareHalfLife = time.Hour
+
+// FairShare is a fair-share scheduling policy. See the
+// Containers.FairShare section of the cluster config for details.
+type FairShare struct {
+	// GroupBy is "user" or "project".
+	GroupBy string
+
+	// Usage decays by half after this long.
+	HalfLife time.Duration
+
+	// Maximum number of containers that can be running (or
+	// starting) at once for a single group. 0 means unlimited.
+	MaxInstancesPerGroup int
+
+	// Relative weight of each group (default 1).
+	Weights map[string]float64
+}
+
+// FairShareGroup reports the current fair-share scheduling inputs
+// for a user or project.
+type FairShareGroup struct {
+	Group   string  `json:"group"`
+	Weight  float64 `json:"weight"`
+	Usage   float64 `json:"usage"`   // recent usage, in average number of instances
+	Running int     `json:"running"` // containers running now
+	Queued  int     `json:"queued"`  // containers waiting to run
+	Score   float64 `json:"score"`   // max(usage, running) / weight; lowest goes first
+	AtLimit bool    `json:"at_limit"`
+}
+
+// SetFairShare enables fair-share scheduling using the given
+// policy. It must be called before Start.
+//
+// Usage history is kept in memory only, so it starts over when the
+// dispatcher restarts.
+func (sch *Scheduler) SetFairShare(fs FairShare) {
+	if fs.HalfLife <= 0 {
+		fs.HalfLife = defaultFairShareHalfLife
+	}
+	sch.fairShare = &fs
+	sch.usage = map[string]float64{}
+}
+
+// FairShareStatus returns the fair-share scheduling inputs used
+// during the most recent scheduling pass, sorted by score. It
+// returns nil if fair-share scheduling is not enabled.
+func (sch *Scheduler) FairShareStatus() []FairShareGroup {
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	return append([]FairShareGroup(nil), sch.fairShareStatus...)
+}
+
+// group returns the fair-share group of the given queue entry.
+func (fs *FairShare) group(ent container.QueueEnt) string {
+	if fs.GroupBy == "project" {
+		return ent.ProjectUUID
+	}
+	return ent.UserUUID
+}
+
+func (fs *FairShare) weight(group string) float64 {
+	if w, ok := fs.Weights[group]; ok {
+		return w
+	}
+	return 1
+}
+
+// score returns the fair-share score of a group with the given
+// recent usage and number of containers running or already ahead of
+// it in the queue. Lower scores go first.
+func (fs *FairShare) score(group string, load float64) float64 {
+	w := fs.weight(group)
+	if w <= 0 {
+		// Not +Inf, which can't be encoded as JSON.
+		return math.MaxFloat64
+	}
+	return load / w
+}
+
+// accrueUsage updates the usage of each group -- an exponentially
+// decaying average of the number of instances running its
+// containers -- given the number of containers each group has had
+// running since the last call.
+func (sch *Scheduler) accrueUsage(now time.Time, running map[string]int) {
+	if !sch.usageUpdated.IsZero() && now.After(sch.usageUpdated) {
+		dt := now.Sub(sch.usageUpdated)
+		decay := math.Pow(0.5, float64(dt)/float64(sch.fairShare.HalfLife))
+		for group, u := range sch.usage {
+			u *= decay
+			if u < 1e-6 && running[group] == 0 {
+				delete(sch.usage, group)
+			} else {
+				sch.usage[group] = u
+			}
+		}
+		for group, n := range running {
+			sch.usage[group] += float64(n) * (1 - decay)
+		}
+	}
+	sch.usageUpdated = now
+}
+
+// fairShareSort reorders the given queue entries, which must already
+// be sorted by priority, so that the groups take turns in order of
+// their fair-share scores. Each container ahead of a group's next
+// container in the resulting order counts as one more unit of load
+// for that group.
+//
+// It also updates sch.fairShareStatus, and returns the number of
+// containers running for each group.
+func (sch *Scheduler) fairShareSort(sorted []container.QueueEnt, running map[string]time.Time) ([]container.QueueEnt, map[string]int) {
+	fs := sch.fairShare
+	nrunning := map[string]int{}
+	var out []container.QueueEnt
+	waiting := map[string][]container.QueueEnt{}
+	for _, ent := range sorted {
+		group := fs.group(ent)
+		if _, ok := running[ent.Container.UUID]; ok {
+			nrunning[group]++
+			out = append(out, ent)
+		} else if ent.Container.Priority < 1 {
+			out = append(out, ent)
+		} else {
+			waiting[group] = append(waiting[group], ent)
+		}
+	}
+	sch.accrueUsage(time.Now(), nrunning)
+
+	load := map[string]float64{}
+	status := map[string]*FairShareGroup{}
+	addStatus := func(group string) {
+		if status[group] != nil {
+			return
+		}
+		usage := sch.usage[group]
+		load[group] = math.Max(usage, float64(nrunning[group]))
+		status[group] = &FairShareGroup{
+			Group:   group,
+			Weight:  fs.weight(group),
+			Usage:   usage,
+			Running: nrunning[group],
+			Queued:  len(waiting[group]),
+			Score:   fs.score(group, load[group]),
+			AtLimit: fs.MaxInstancesPerGroup > 0 && nrunning[group] >= fs.MaxInstancesPerGroup,
+		}
+	}
+	for group := range nrunning {
+		addStatus(group)
+	}
+	for group := range waiting {
+		addStatus(group)
+	}
+
+	for len(waiting) > 0 {
+		found, best, bestScore := false, "", 0.0
+		for group, ents := range waiting {
+			score := fs.score(group, load[group])
+			if !found ||
+				score < bestScore ||
+				(score == bestScore && ents[0].Container.Priority > waiting[best][0].Container.Priority) ||
+				(score == bestScore && ents[0].Container.Priority == waiting[best][0].Container.Priority && group < best) {
+				found, best, bestScore = true, group, score
+			}
+		}
+		out = append(out, waiting[best][0])
+		load[best]++
+		if len(waiting[best]) == 1 {
+			delete(waiting, best)
+		} else {
+			waiting[best] = waiting[best][1:]
+		}
+	}
+
+	list := make([]FairShareGroup, 0, len(status))
+	for _, s := range status {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score < list[j].Score
+		}
+		return list[i].Group < list[j].Group
+	})
+	sch.mtx.Lock()
+	sch.fairShareStatus = list
+	sch.mtx.Unlock()
+	return out, nrunning
+}
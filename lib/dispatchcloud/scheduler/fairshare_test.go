@@ -0,0 +1,174 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&FairShareSuite{})
+
+type FairShareSuite struct{}
+
+const (
+	fsUserA = "zzzzz-tpzed-fairshareusera"
+	fsUserB = "zzzzz-tpzed-fairshareuserb"
+)
+
+// Return a queue with nA locked containers (priority 10, type1)
+// submitted by fsUserA and nB locked containers (priority 1, type2)
+// submitted by fsUserB.
+func (*FairShareSuite) queue(nA, nB int) *test.Queue {
+	queue := &test.Queue{
+		ChooseType: chooseType,
+		UserUUIDs:  map[string]string{},
+	}
+	for i := 0; i < nA+nB; i++ {
+		ctr := arvados.Container{
+			UUID:     test.ContainerUUID(i + 1),
+			Priority: 10,
+			State:    arvados.ContainerStateLocked,
+			RuntimeConstraints: arvados.RuntimeConstraints{
+				VCPUs: 1,
+				RAM:   1 << 30,
+			},
+		}
+		queue.UserUUIDs[ctr.UUID] = fsUserA
+		if i >= nA {
+			ctr.Priority = 1
+			ctr.RuntimeConstraints.VCPUs = 2
+			queue.UserUUIDs[ctr.UUID] = fsUserB
+		}
+		queue.Containers = append(queue.Containers, ctr)
+	}
+	queue.Update()
+	return queue
+}
+
+func (*FairShareSuite) pool() *stubPool {
+	return &stubPool{
+		quota:   1000,
+		unalloc: map[arvados.InstanceType]int{},
+		idle:    map[arvados.InstanceType]int{},
+		running: map[string]time.Time{},
+		creates: []arvados.InstanceType{},
+	}
+}
+
+func types(n ...int) []arvados.InstanceType {
+	var its []arvados.InstanceType
+	for _, n := range n {
+		its = append(its, test.InstanceType(n))
+	}
+	return its
+}
+
+func (s *FairShareSuite) TestDisabled(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	pool := s.pool()
+	sch := New(ctx, s.queue(3, 2), pool, time.Millisecond, time.Millisecond)
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, types(1, 1, 1, 2, 2))
+	c.Check(sch.FairShareStatus(), check.HasLen, 0)
+}
+
+func (s *FairShareSuite) TestTakeTurns(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	pool := s.pool()
+	sch := New(ctx, s.queue(5, 2), pool, time.Millisecond, time.Millisecond)
+	sch.SetFairShare(FairShare{})
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, types(1, 2, 1, 2, 1, 1, 1))
+}
+
+func (s *FairShareSuite) TestWeights(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	pool := s.pool()
+	sch := New(ctx, s.queue(5, 3), pool, time.Millisecond, time.Millisecond)
+	sch.SetFairShare(FairShare{Weights: map[string]float64{fsUserB: 2}})
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, types(1, 2, 2, 1, 2, 1, 1, 1))
+}
+
+func (s *FairShareSuite) TestRecentUsage(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	pool := s.pool()
+	sch := New(ctx, s.queue(2, 2), pool, time.Millisecond, time.Millisecond)
+	sch.SetFairShare(FairShare{HalfLife: time.Hour})
+	// fsUserA recently used an average of 1.5 instances.
+	sch.usage[fsUserA] = 1.5
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, types(2, 2, 1, 1))
+
+	status := sch.FairShareStatus()
+	c.Assert(status, check.HasLen, 2)
+	c.Check(status[0].Group, check.Equals, fsUserB)
+	c.Check(status[0].Score, check.Equals, 0.0)
+	c.Check(status[0].Queued, check.Equals, 2)
+	c.Check(status[1].Group, check.Equals, fsUserA)
+	c.Check(status[1].Usage > 1.49 && status[1].Usage <= 1.5, check.Equals, true)
+	c.Check(status[1].Score, check.Equals, status[1].Usage)
+}
+
+func (s *FairShareSuite) TestAccrueUsage(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	sch := New(ctx, s.queue(0, 0), s.pool(), time.Millisecond, time.Millisecond)
+	sch.SetFairShare(FairShare{HalfLife: time.Hour})
+	t0 := time.Now()
+	sch.accrueUsage(t0, map[string]int{fsUserA: 2})
+	c.Check(sch.usage, check.HasLen, 0)
+	sch.accrueUsage(t0.Add(time.Hour), map[string]int{fsUserA: 2})
+	c.Check(sch.usage[fsUserA], check.Equals, 1.0)
+	sch.accrueUsage(t0.Add(2*time.Hour), map[string]int{fsUserB: 1})
+	c.Check(sch.usage[fsUserA], check.Equals, 0.5)
+	c.Check(sch.usage[fsUserB], check.Equals, 0.5)
+
+	// Running 4 instances for a long time converges to an
+	// average of 4 instances.
+	for i := 3; i < 100; i++ {
+		sch.accrueUsage(t0.Add(time.Duration(i)*time.Hour/2), map[string]int{fsUserB: 4})
+	}
+	c.Check(sch.usage[fsUserA] < 0.01, check.Equals, true)
+	c.Check(sch.usage[fsUserB] > 3.99 && sch.usage[fsUserB] <= 4, check.Equals, true)
+}
+
+func (s *FairShareSuite) TestMaxInstancesPerGroup(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := s.queue(5, 2)
+	pool := s.pool()
+	// One of fsUserA's containers is already running.
+	pool.running[test.ContainerUUID(1)] = time.Now()
+	sch := New(ctx, queue, pool, time.Millisecond, time.Millisecond)
+	sch.SetFairShare(FairShare{MaxInstancesPerGroup: 2})
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, types(2, 1, 2))
+
+	status := sch.FairShareStatus()
+	c.Assert(status, check.HasLen, 2)
+	c.Check(status[1].Group, check.Equals, fsUserA)
+	c.Check(status[1].Running, check.Equals, 1)
+	c.Check(status[1].Queued, check.Equals, 4)
+	c.Check(status[1].AtLimit, check.Equals, false)
+
+	// fsUserA's containers that were held back by the limit
+	// are unlocked, like other containers that can't be
+	// scheduled yet.
+	states := map[arvados.ContainerState]int{}
+	for i := 2; i <= 5; i++ {
+		ctr, ok := queue.Get(test.ContainerUUID(i))
+		c.Assert(ok, check.Equals, true)
+		states[ctr.State]++
+	}
+	c.Check(states, check.DeepEquals, map[arvados.ContainerState]int{
+		arvados.ContainerStateLocked: 1,
+		arvados.ContainerStateQueued: 3,
+	})
+}
@@ -20,6 +20,7 @@ type ContainerQueue interface {
 	Lock(uuid string) error
 	Unlock(uuid string) error
 	Cancel(uuid string) error
+	AddCost(uuid string, cost float64) error
 	Forget(uuid string)
 	Get(uuid string) (arvados.Container, bool)
 	Subscribe() <-chan struct{}
@@ -35,11 +36,13 @@ type WorkerPool interface {
 	Unallocated() map[arvados.InstanceType]int
 	CountWorkers() map[worker.State]int
 	AtQuota() bool
+	AtCapacity(arvados.InstanceType) bool
 	Create(arvados.InstanceType) bool
 	Shutdown(arvados.InstanceType) bool
 	StartContainer(arvados.InstanceType, arvados.Container) bool
 	KillContainer(uuid, reason string) bool
 	ForgetContainer(uuid string)
+	ContainerCost(uuid string) float64
 	Subscribe() <-chan struct{}
 	Unsubscribe(<-chan struct{})
 }
@@ -26,6 +26,11 @@ func (sch *Scheduler) runQueue() {
 	running := sch.pool.Running()
 	unalloc := sch.pool.Unallocated()
 
+	var groupRunning map[string]int
+	if sch.fairShare != nil {
+		sorted, groupRunning = sch.fairShareSort(sorted, running)
+	}
+
 	sch.logger.WithFields(logrus.Fields{
 		"Containers": len(sorted),
 		"Processes":  len(running),
@@ -35,8 +40,8 @@ func (sch *Scheduler) runQueue() {
 	var overquota []container.QueueEnt // entries that are unmappable because of worker pool quota
 
 tryrun:
-	for i, ctr := range sorted {
-		ctr, it := ctr.Container, ctr.InstanceType
+	for i, ent := range sorted {
+		ctr, it := ent.Container, sch.chooseType(ent, unalloc)
 		logger := sch.logger.WithFields(logrus.Fields{
 			"ContainerUUID": ctr.UUID,
 			"InstanceType":  it.Name,
@@ -44,6 +49,27 @@ tryrun:
 		if _, running := running[ctr.UUID]; running || ctr.Priority < 1 {
 			continue
 		}
+		if sch.fairShare != nil && sch.fairShare.MaxInstancesPerGroup > 0 {
+			group := sch.fairShare.group(ent)
+			if groupRunning[group] >= sch.fairShare.MaxInstancesPerGroup {
+				logger := logger.WithField("FairShareGroup", group)
+				logger.Debug("not starting: group has reached MaxInstancesPerGroup")
+				if ctr.State == arvados.ContainerStateLocked {
+					// Let another dispatcher (or a
+					// later runQueue) have it.
+					logger.Debug("unlocking: group has reached MaxInstancesPerGroup")
+					err := sch.queue.Unlock(ctr.UUID)
+					if err != nil {
+						logger.WithError(err).Warn("error unlocking")
+					}
+				}
+				continue
+			}
+			groupRunning[group]++
+		}
+		if it != ent.InstanceType {
+			logger.WithField("PreferredInstanceType", ent.InstanceType.Name).Debug("preferred instance type is at capacity, using fallback")
+		}
 		switch ctr.State {
 		case arvados.ContainerStateQueued:
 			if unalloc[it] < 1 && sch.pool.AtQuota() {
@@ -122,6 +148,29 @@ tryrun:
 	}
 }
 
+// chooseType returns the instance type that should be used for the
+// given queue entry: the first of the entry's eligible instance
+// types that has an unallocated worker, otherwise the first one
+// that is not currently at capacity. If all eligible types are at
+// capacity, it returns the entry's preferred type.
+//
+// Checking for unallocated workers first means a fallback instance
+// that is already booting for this container gets used, even if the
+// preferred type is no longer at capacity by the time it's ready.
+func (sch *Scheduler) chooseType(ent container.QueueEnt, unalloc map[arvados.InstanceType]int) arvados.InstanceType {
+	for _, it := range ent.InstanceTypes {
+		if unalloc[it] > 0 {
+			return it
+		}
+	}
+	for _, it := range ent.InstanceTypes {
+		if !sch.pool.AtCapacity(it) {
+			return it
+		}
+	}
+	return ent.InstanceType
+}
+
 // Lock the given container. Should be called in a new goroutine.
 func (sch *Scheduler) lockContainer(logger logrus.FieldLogger, uuid string) {
 	if !sch.uuidLock(uuid, "lock") {
@@ -38,6 +38,8 @@ type stubPool struct {
 	idle      map[arvados.InstanceType]int
 	unknown   map[arvados.InstanceType]int
 	running   map[string]time.Time
+	costs     map[string]float64
+	atCap     map[arvados.InstanceType]bool
 	quota     int
 	canCreate int
 	creates   []arvados.InstanceType
@@ -51,6 +53,11 @@ func (p *stubPool) AtQuota() bool {
 	defer p.Unlock()
 	return len(p.unalloc)+len(p.running)+len(p.unknown) >= p.quota
 }
+func (p *stubPool) AtCapacity(it arvados.InstanceType) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.atCap[it]
+}
 func (p *stubPool) Subscribe() <-chan struct{}  { return p.notify }
 func (p *stubPool) Unsubscribe(<-chan struct{}) {}
 func (p *stubPool) Running() map[string]time.Time {
@@ -84,6 +91,11 @@ func (p *stubPool) Create(it arvados.InstanceType) bool {
 }
 func (p *stubPool) ForgetContainer(uuid string) {
 }
+func (p *stubPool) ContainerCost(uuid string) float64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.costs[uuid]
+}
 func (p *stubPool) KillContainer(uuid, reason string) bool {
 	p.Lock()
 	defer p.Unlock()
@@ -194,6 +206,118 @@ func (*SchedulerSuite) TestUseIdleWorkers(c *check.C) {
 	}
 }
 
+// If the preferred instance type is at capacity, create an instance
+// of the next eligible type instead -- unless the preferred type
+// already has an unallocated worker.
+func (*SchedulerSuite) TestFallbackWhenAtCapacity(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseTypes: func(ctr *arvados.Container) ([]arvados.InstanceType, error) {
+			n := ctr.RuntimeConstraints.VCPUs
+			return []arvados.InstanceType{test.InstanceType(n), test.InstanceType(n + 1)}, nil
+		},
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 1,
+					RAM:   1 << 30,
+				},
+			},
+			{
+				UUID:     test.ContainerUUID(2),
+				Priority: 2,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+			},
+			{
+				UUID:     test.ContainerUUID(3),
+				Priority: 3,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+			},
+		},
+	}
+	queue.Update()
+	pool := stubPool{
+		quota: 1000,
+		unalloc: map[arvados.InstanceType]int{
+			test.InstanceType(2): 1,
+		},
+		idle: map[arvados.InstanceType]int{},
+		atCap: map[arvados.InstanceType]bool{
+			test.InstanceType(1): true,
+			test.InstanceType(2): true,
+		},
+		running:   map[string]time.Time{},
+		creates:   []arvados.InstanceType{},
+		canCreate: 10,
+	}
+	New(ctx, &queue, &pool, time.Millisecond, time.Millisecond).runQueue()
+	// ctr3 gets the unallocated type2 worker; ctr2 falls back to
+	// type3; all of ctr1's eligible types are at capacity, so it
+	// sticks with its preferred type1.
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(3), test.InstanceType(1)})
+}
+
+// If the preferred instance type's capacity backoff ends while a
+// fallback instance is booting, the container keeps using the
+// fallback instance instead of creating another instance.
+func (*SchedulerSuite) TestFallbackAfterCapacityBackoffExpires(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseTypes: func(ctr *arvados.Container) ([]arvados.InstanceType, error) {
+			return []arvados.InstanceType{test.InstanceType(1), test.InstanceType(2)}, nil
+		},
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 1,
+					RAM:   1 << 30,
+				},
+			},
+		},
+	}
+	queue.Update()
+	pool := stubPool{
+		quota:   1000,
+		unalloc: map[arvados.InstanceType]int{},
+		idle:    map[arvados.InstanceType]int{},
+		atCap: map[arvados.InstanceType]bool{
+			test.InstanceType(1): true,
+		},
+		running:   map[string]time.Time{},
+		creates:   []arvados.InstanceType{},
+		canCreate: 10,
+	}
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond)
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(2)})
+
+	// Backoff ends while the type2 instance is still booting.
+	pool.atCap = map[arvados.InstanceType]bool{}
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(2)})
+	c.Check(pool.shutdowns, check.Equals, 0)
+
+	// The container starts on the type2 instance once it's idle.
+	pool.idle[test.InstanceType(2)] = 1
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(2)})
+	c.Check(pool.running, check.HasLen, 1)
+}
+
 // If pool.AtQuota() is true, shutdown some unalloc nodes, and don't
 // call Create().
 func (*SchedulerSuite) TestShutdownAtQuota(c *check.C) {
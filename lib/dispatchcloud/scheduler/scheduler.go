@@ -38,6 +38,15 @@ type Scheduler struct {
 	mtx    sync.Mutex
 	wakeup *time.Timer
 
+	costReportInterval time.Duration
+	lastCostReport     time.Time
+	reportedCost       map[string]float64 // cost already added to the container's cost field (protected by mtx)
+
+	fairShare       *FairShare         // nil if fair-share scheduling is disabled
+	usage           map[string]float64 // recent average number of instances used by each fair-share group
+	usageUpdated    time.Time
+	fairShareStatus []FairShareGroup // inputs used in the last runQueue (protected by mtx)
+
 	runOnce sync.Once
 	stop    chan struct{}
 	stopped chan struct{}
@@ -58,6 +67,8 @@ func New(ctx context.Context, queue ContainerQueue, pool WorkerPool, staleLockTi
 		stop:                make(chan struct{}),
 		stopped:             make(chan struct{}),
 		uuidOp:              map[string]string{},
+		costReportInterval:  defaultCostReportInterval,
+		reportedCost:        map[string]float64{},
 	}
 }
 
@@ -113,6 +124,7 @@ func (sch *Scheduler) run() {
 	for {
 		sch.runQueue()
 		sch.sync()
+		sch.reportCosts()
 		select {
 		case <-sch.stop:
 			return
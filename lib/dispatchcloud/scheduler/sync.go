@@ -50,7 +50,7 @@ func (sch *Scheduler) sync() {
 				// of kill() will be to make the
 				// worker available for the next
 				// container.
-				go sch.kill(uuid, fmt.Sprintf("state=%s", ent.Container.State))
+				go sch.finishContainer(uuid, fmt.Sprintf("state=%s", ent.Container.State))
 			} else {
 				sch.logger.WithFields(logrus.Fields{
 					"ContainerUUID": uuid,
@@ -117,6 +117,19 @@ func (sch *Scheduler) kill(uuid string, reason string) {
 	sch.pool.ForgetContainer(uuid)
 }
 
+// finishContainer records the final cost of a finished container,
+// then kills its crunch-run process (in case it's stuck) and clears
+// the pool's record of it.
+func (sch *Scheduler) finishContainer(uuid string, reason string) {
+	if !sch.uuidLock(uuid, "finish") {
+		return
+	}
+	defer sch.uuidUnlock(uuid)
+	sch.addCost(uuid)
+	sch.pool.KillContainer(uuid, reason)
+	sch.pool.ForgetContainer(uuid)
+}
+
 func (sch *Scheduler) requeue(ent container.QueueEnt, reason string) {
 	uuid := ent.Container.UUID
 	if !sch.uuidLock(uuid, "requeue") {
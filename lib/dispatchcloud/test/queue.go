@@ -20,10 +20,21 @@ type Queue struct {
 	// Containers represent the API server database contents.
 	Containers []arvados.Container
 
-	// ChooseType will be called for each entry in Containers. It
-	// must not be nil.
+	// ChooseType will be called for each entry in Containers,
+	// unless ChooseTypes is set. One of them must not be nil.
 	ChooseType func(*arvados.Container) (arvados.InstanceType, error)
 
+	// ChooseTypes, if set, will be called for each entry in
+	// Containers to get a list of eligible instance types in
+	// order of preference.
+	ChooseTypes func(*arvados.Container) ([]arvados.InstanceType, error)
+
+	// UserUUIDs and ProjectUUIDs, if set, supply the UserUUID
+	// and ProjectUUID fields of queue entries, keyed by
+	// container UUID.
+	UserUUIDs    map[string]string
+	ProjectUUIDs map[string]string
+
 	Logger logrus.FieldLogger
 
 	entries     map[string]container.QueueEnt
@@ -81,6 +92,22 @@ func (q *Queue) Cancel(uuid string) error {
 	return q.changeState(uuid, q.entries[uuid].Container.State, arvados.ContainerStateCancelled)
 }
 
+// AddCost adds the given amount to the cost of the container in
+// the Containers slice. Like a real API update, the change is not
+// exposed through Get() or Entries() until the next call to
+// Update().
+func (q *Queue) AddCost(uuid string, cost float64) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for i, ctr := range q.Containers {
+		if ctr.UUID == uuid {
+			q.Containers[i].Cost += cost
+			return nil
+		}
+	}
+	return fmt.Errorf("no such container: %s", uuid)
+}
+
 func (q *Queue) Subscribe() <-chan struct{} {
 	q.mtx.Lock()
 	defer q.mtx.Unlock()
@@ -141,10 +168,23 @@ func (q *Queue) Update() error {
 			ent.Container = ctr
 			upd[ctr.UUID] = ent
 		} else {
-			it, _ := q.ChooseType(&ctr)
+			var types []arvados.InstanceType
+			if q.ChooseTypes != nil {
+				types, _ = q.ChooseTypes(&ctr)
+			} else {
+				it, _ := q.ChooseType(&ctr)
+				types = []arvados.InstanceType{it}
+			}
+			var it arvados.InstanceType
+			if len(types) > 0 {
+				it = types[0]
+			}
 			upd[ctr.UUID] = container.QueueEnt{
-				Container:    ctr,
-				InstanceType: it,
+				Container:     ctr,
+				InstanceType:  it,
+				InstanceTypes: types,
+				UserUUID:      q.UserUUIDs[ctr.UUID],
+				ProjectUUID:   q.ProjectUUIDs[ctr.UUID],
 			}
 		}
 	}
@@ -56,6 +56,10 @@ type StubDriver struct {
 	// called.
 	HoldCloudOps bool
 
+	// CreateError, if set, is called by Create. If it returns a
+	// non-nil error, Create fails with that error.
+	CreateError func(arvados.InstanceType) error
+
 	instanceSets []*StubInstanceSet
 	holdCloudOps chan bool
 }
@@ -120,6 +124,11 @@ func (sis *StubInstanceSet) Create(it arvados.InstanceType, image cloud.ImageID,
 		return nil, RateLimitError{sis.allowCreateCall}
 	}
 	sis.allowCreateCall = time.Now().Add(sis.driver.MinTimeBetweenCreateCalls)
+	if fn := sis.driver.CreateError; fn != nil {
+		if err := fn(it); err != nil {
+			return nil, err
+		}
+	}
 	ak := sis.driver.AuthorizedKeys
 	if authKey != nil {
 		ak = append([]ssh.PublicKey{authKey}, ak...)
@@ -175,6 +184,14 @@ type RateLimitError struct{ Retry time.Time }
 func (e RateLimitError) Error() string            { return fmt.Sprintf("rate limited until %s", e.Retry) }
 func (e RateLimitError) EarliestRetry() time.Time { return e.Retry }
 
+// CapacityError is a cloud.CapacityError that can be returned by a
+// StubDriver's CreateError func.
+type CapacityError struct{ InstanceTypeSpecific bool }
+
+func (e CapacityError) Error() string                { return "insufficient capacity" }
+func (e CapacityError) IsCapacityError() bool        { return true }
+func (e CapacityError) IsInstanceTypeSpecific() bool { return e.InstanceTypeSpecific }
+
 // StubVM is a fake server that runs an SSH service. It represents a
 // VM running in a fake cloud.
 //
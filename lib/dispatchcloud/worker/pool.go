@@ -78,6 +78,20 @@ const (
 	// instances have been shutdown.
 	quotaErrorTTL = time.Minute
 
+	// Time after a capacity error to try again. The wait time
+	// doubles after each consecutive capacity error for the same
+	// instance type, up to maxCapacityErrorTTL.
+	capacityErrorTTL    = time.Minute
+	maxCapacityErrorTTL = time.Minute * 16
+
+	// Number of consecutive capacity errors for an instance type
+	// before AtCapacity reports it, so the scheduler falls back
+	// to other instance types instead of waiting. Errors are not
+	// consecutive if they are more than capacityErrorWindow
+	// apart.
+	capacityFallbackThreshold = 3
+	capacityErrorWindow       = time.Hour
+
 	// Time between "X failed because rate limiting" messages
 	logRateLimitErrorInterval = time.Second * 10
 )
@@ -161,8 +175,10 @@ type Pool struct {
 	workers      map[cloud.InstanceID]*worker
 	loaded       bool                 // loaded list of instances from InstanceSet at least once
 	exited       map[string]time.Time // containers whose crunch-run proc has exited, but ForgetContainer has not been called
+	exitedCost   map[string]float64   // accrued cost of containers in exited
 	atQuotaUntil time.Time
 	atQuotaErr   cloud.QuotaError
+	atCapacity   map[capacityKey]capacityBackoff // instance types that recently failed with capacity errors
 	stop         chan bool
 	mtx          sync.RWMutex
 	setupOnce    sync.Once
@@ -177,10 +193,31 @@ type Pool struct {
 	mMemory                  *prometheus.GaugeVec
 	mBootOutcomes            *prometheus.CounterVec
 	mDisappearances          *prometheus.CounterVec
+	mInstancesCost           *prometheus.CounterVec
+	mContainersCost          *prometheus.CounterVec
 	mTimeToSSH               prometheus.Summary
 	mTimeToReadyForContainer prometheus.Summary
 }
 
+// capacityKey identifies a set of instance types that are subject to
+// the same cloud capacity constraints. The zero value refers to all
+// instance types, and is used for capacity errors that are not
+// specific to an instance type.
+type capacityKey struct {
+	providerType string
+	preemptible  bool
+}
+
+func capacityKeyFor(it arvados.InstanceType) capacityKey {
+	return capacityKey{providerType: it.ProviderType, preemptible: it.Preemptible}
+}
+
+type capacityBackoff struct {
+	until    time.Time
+	last     time.Time // time of last capacity error
+	failures int       // consecutive capacity errors
+}
+
 type createCall struct {
 	time         time.Time
 	instanceType arvados.InstanceType
@@ -299,7 +336,7 @@ func (wp *Pool) Create(it arvados.InstanceType) bool {
 	}
 	wp.mtx.Lock()
 	defer wp.mtx.Unlock()
-	if time.Now().Before(wp.atQuotaUntil) || wp.instanceSet.throttleCreate.Error() != nil {
+	if time.Now().Before(wp.atQuotaUntil) || wp.instanceSet.throttleCreate.Error() != nil || wp.atCapacityLocked(it) {
 		return false
 	}
 	// The maxConcurrentInstanceCreateOps knob throttles the number of node create
@@ -339,10 +376,36 @@ func (wp *Pool) Create(it arvados.InstanceType) bool {
 				wp.atQuotaUntil = time.Now().Add(quotaErrorTTL)
 				time.AfterFunc(quotaErrorTTL, wp.notify)
 			}
+			if err, ok := err.(cloud.CapacityError); ok && err.IsCapacityError() {
+				key := capacityKey{}
+				if err.IsInstanceTypeSpecific() {
+					key = capacityKeyFor(it)
+				}
+				now := time.Now()
+				backoff := wp.atCapacity[key]
+				if now.Sub(backoff.last) > capacityErrorWindow {
+					backoff.failures = 0
+				}
+				ttl := capacityErrorTTL << uint(backoff.failures)
+				if ttl > maxCapacityErrorTTL || ttl <= 0 {
+					ttl = maxCapacityErrorTTL
+				}
+				backoff.failures++
+				backoff.last = now
+				backoff.until = now.Add(ttl)
+				wp.atCapacity[key] = backoff
+				time.AfterFunc(ttl, wp.notify)
+				logger = logger.WithFields(logrus.Fields{
+					"CapacityErrors": backoff.failures,
+					"RetryAfter":     backoff.until,
+				})
+			}
 			logger.WithError(err).Error("create failed")
 			wp.instanceSet.throttleCreate.CheckRateLimitError(err, wp.logger, "create instance", wp.notify)
 			return
 		}
+		delete(wp.atCapacity, capacityKeyFor(it))
+		delete(wp.atCapacity, capacityKey{})
 		wp.updateWorker(inst, it)
 	}()
 	return true
@@ -356,6 +419,36 @@ func (wp *Pool) AtQuota() bool {
 	return time.Now().Before(wp.atQuotaUntil)
 }
 
+// AtCapacity returns true if Create is not expected to work for the
+// given instance type at the moment, because the last
+// capacityFallbackThreshold attempts to create an instance of that
+// type (or, in some cases, any type) failed with capacity errors.
+//
+// After fewer consecutive capacity errors, Create still waits for
+// the backoff period before trying again, but AtCapacity returns
+// false, so the scheduler waits for the preferred instance type
+// instead of falling back to a different one.
+func (wp *Pool) AtCapacity(it arvados.InstanceType) bool {
+	wp.setupOnce.Do(wp.setup)
+	wp.mtx.Lock()
+	defer wp.mtx.Unlock()
+	now := time.Now()
+	for _, key := range []capacityKey{{}, capacityKeyFor(it)} {
+		backoff := wp.atCapacity[key]
+		if now.Before(backoff.until) && backoff.failures >= capacityFallbackThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Caller must have lock.
+func (wp *Pool) atCapacityLocked(it arvados.InstanceType) bool {
+	now := time.Now()
+	return now.Before(wp.atCapacity[capacityKey{}].until) ||
+		now.Before(wp.atCapacity[capacityKeyFor(it)].until)
+}
+
 // SetIdleBehavior determines how the indicated instance will behave
 // when it has no containers running.
 func (wp *Pool) SetIdleBehavior(id cloud.InstanceID, idleBehavior IdleBehavior) error {
@@ -445,6 +538,7 @@ func (wp *Pool) updateWorker(inst cloud.Instance, it arvados.InstanceType) (*wor
 		probed:       now,
 		busy:         now,
 		updated:      now,
+		costUpdated:  now,
 		running:      make(map[string]*remoteRunner),
 		starting:     make(map[string]*remoteRunner),
 		probing:      make(chan struct{}, 1),
@@ -539,6 +633,35 @@ func (wp *Pool) StartContainer(it arvados.InstanceType, ctr arvados.Container) b
 	return true
 }
 
+// ContainerCost returns the share of instance costs accrued so far
+// by the given container, which is either running or has exited but
+// not yet been passed to ForgetContainer. If several containers run
+// on the same instance at the same time, the cost of the instance is
+// divided evenly among them.
+//
+// Costs are calculated from the Price of the worker's instance type,
+// which is assumed to be an hourly rate.
+func (wp *Pool) ContainerCost(uuid string) float64 {
+	wp.setupOnce.Do(wp.setup)
+	wp.mtx.Lock()
+	defer wp.mtx.Unlock()
+	if cost, ok := wp.exitedCost[uuid]; ok {
+		return cost
+	}
+	now := time.Now()
+	for _, wkr := range wp.workers {
+		rr := wkr.running[uuid]
+		if rr == nil {
+			rr = wkr.starting[uuid]
+		}
+		if rr != nil {
+			wkr.accrueCost(now)
+			return rr.cost
+		}
+	}
+	return 0
+}
+
 // KillContainer kills the crunch-run process for the given container
 // UUID, if it's running on any worker.
 //
@@ -583,6 +706,7 @@ func (wp *Pool) ForgetContainer(uuid string) {
 	if _, ok := wp.exited[uuid]; ok {
 		wp.logger.WithField("ContainerUUID", uuid).Debug("clearing placeholder for exited crunch-run process")
 		delete(wp.exited, uuid)
+		delete(wp.exitedCost, uuid)
 	}
 }
 
@@ -645,6 +769,20 @@ func (wp *Pool) registerMetrics(reg *prometheus.Registry) {
 		wp.mDisappearances.WithLabelValues(v).Add(0)
 	}
 	reg.MustRegister(wp.mDisappearances)
+	wp.mInstancesCost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "arvados",
+		Subsystem: "dispatchcloud",
+		Name:      "instances_cost_total",
+		Help:      "Accrued cost of cloud VMs, including booting and idle time, based on hourly instance type prices.",
+	}, []string{"instance_type"})
+	reg.MustRegister(wp.mInstancesCost)
+	wp.mContainersCost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "arvados",
+		Subsystem: "dispatchcloud",
+		Name:      "containers_cost_total",
+		Help:      "Accrued cost of cloud VMs while running containers, based on hourly instance type prices.",
+	}, []string{"instance_type"})
+	reg.MustRegister(wp.mContainersCost)
 	wp.mTimeToSSH = prometheus.NewSummary(prometheus.SummaryOpts{
 		Namespace:  "arvados",
 		Subsystem:  "dispatchcloud",
@@ -737,7 +875,9 @@ func (wp *Pool) runProbes() {
 	for range probeticker.C {
 		workers = workers[:0]
 		wp.mtx.Lock()
+		now := time.Now()
 		for id, wkr := range wp.workers {
+			wkr.accrueCost(now)
 			if wkr.state == StateShutdown || wkr.shutdownIfIdle() {
 				continue
 			}
@@ -831,6 +971,8 @@ func (wp *Pool) KillInstance(id cloud.InstanceID, reason string) error {
 func (wp *Pool) setup() {
 	wp.creating = map[string]createCall{}
 	wp.exited = map[string]time.Time{}
+	wp.exitedCost = map[string]float64{}
+	wp.atCapacity = map[capacityKey]capacityBackoff{}
 	wp.workers = map[cloud.InstanceID]*worker{}
 	wp.subscribers = map[<-chan struct{}]chan<- struct{}{}
 	wp.loadRunnerData()
@@ -7,6 +7,7 @@ package worker
 import (
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"git.arvados.org/arvados.git/lib/cloud"
@@ -239,6 +240,128 @@ func (suite *PoolSuite) TestNodeCreateThrottle(c *check.C) {
 	c.Check(res, check.Equals, true)
 }
 
+func (suite *PoolSuite) TestCreateAtCapacity(c *check.C) {
+	logger := ctxlog.TestLogger(c)
+	var mtx sync.Mutex
+	var createErr error
+	driver := test.StubDriver{
+		CreateError: func(arvados.InstanceType) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			return createErr
+		},
+	}
+	instanceSet, err := driver.InstanceSet(nil, "test-instance-set-id", nil, logger)
+	c.Assert(err, check.IsNil)
+
+	type1 := test.InstanceType(1)
+	type2 := test.InstanceType(2)
+	pool := &Pool{
+		logger:      logger,
+		instanceSet: &throttledInstanceSet{InstanceSet: instanceSet},
+		newExecutor: func(cloud.Instance) Executor { return &stubExecutor{} },
+		instanceTypes: arvados.InstanceTypeMap{
+			type1.Name: type1,
+			type2.Name: type2,
+		},
+	}
+	setCreateErr := func(err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		createErr = err
+	}
+	waitCreating := func() {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			pool.mtx.RLock()
+			n := len(pool.creating)
+			pool.mtx.RUnlock()
+			if n == 0 {
+				return
+			}
+		}
+		c.Error("timed out waiting for create calls")
+	}
+
+	expire := func(key capacityKey) {
+		pool.mtx.Lock()
+		defer pool.mtx.Unlock()
+		backoff := pool.atCapacity[key]
+		backoff.until = time.Now()
+		pool.atCapacity[key] = backoff
+	}
+
+	// A single type-specific capacity error makes Create back
+	// off, but does not make the scheduler fall back to other
+	// types yet.
+	setCreateErr(test.CapacityError{InstanceTypeSpecific: true})
+	c.Check(pool.Create(type1), check.Equals, true)
+	waitCreating()
+	c.Check(pool.AtCapacity(type1), check.Equals, false)
+	c.Check(pool.Create(type1), check.Equals, false)
+
+	// Consecutive errors back off exponentially.
+	expire(capacityKeyFor(type1))
+	c.Check(pool.Create(type1), check.Equals, true)
+	waitCreating()
+	pool.mtx.RLock()
+	backoff := pool.atCapacity[capacityKeyFor(type1)]
+	pool.mtx.RUnlock()
+	c.Check(backoff.failures, check.Equals, 2)
+	c.Check(backoff.until.After(time.Now().Add(capacityErrorTTL)), check.Equals, true)
+	c.Check(pool.AtCapacity(type1), check.Equals, false)
+
+	// Repeated type-specific capacity errors affect only that
+	// type.
+	expire(capacityKeyFor(type1))
+	c.Check(pool.Create(type1), check.Equals, true)
+	waitCreating()
+	c.Check(pool.AtCapacity(type1), check.Equals, true)
+	c.Check(pool.AtCapacity(type2), check.Equals, false)
+	c.Check(pool.Create(type1), check.Equals, false)
+
+	// Errors that are far apart are not consecutive.
+	pool.mtx.Lock()
+	backoff = pool.atCapacity[capacityKeyFor(type1)]
+	backoff.until = time.Now()
+	backoff.last = time.Now().Add(-capacityErrorWindow - time.Minute)
+	pool.atCapacity[capacityKeyFor(type1)] = backoff
+	pool.mtx.Unlock()
+	c.Check(pool.Create(type1), check.Equals, true)
+	waitCreating()
+	pool.mtx.RLock()
+	backoff = pool.atCapacity[capacityKeyFor(type1)]
+	pool.mtx.RUnlock()
+	c.Check(backoff.failures, check.Equals, 1)
+	c.Check(pool.AtCapacity(type1), check.Equals, false)
+
+	// Repeated non-specific capacity errors affect all types.
+	setCreateErr(test.CapacityError{InstanceTypeSpecific: false})
+	for i := 0; i < capacityFallbackThreshold; i++ {
+		c.Check(pool.AtCapacity(type2), check.Equals, false)
+		expire(capacityKey{})
+		c.Check(pool.Create(type2), check.Equals, true)
+		waitCreating()
+	}
+	c.Check(pool.AtCapacity(type2), check.Equals, true)
+	c.Check(pool.AtCapacity(type1), check.Equals, true)
+	c.Check(pool.Create(type2), check.Equals, false)
+
+	// A successful create clears the capacity errors.
+	setCreateErr(nil)
+	pool.mtx.Lock()
+	pool.atCapacity = map[capacityKey]capacityBackoff{
+		capacityKeyFor(type1): {until: time.Now().Add(time.Hour), failures: 3},
+		{}:                    {failures: 1},
+	}
+	pool.mtx.Unlock()
+	c.Check(pool.Create(type2), check.Equals, true)
+	waitCreating()
+	c.Check(pool.AtCapacity(type1), check.Equals, true)
+	pool.mtx.RLock()
+	c.Check(pool.atCapacity, check.HasLen, 1)
+	pool.mtx.RUnlock()
+}
+
 func (suite *PoolSuite) TestCreateUnallocShutdown(c *check.C) {
 	logger := ctxlog.TestLogger(c)
 	driver := test.StubDriver{HoldCloudOps: true}
@@ -31,6 +31,7 @@ type remoteRunner struct {
 	stopping bool          // true if Stop() has been called
 	givenup  bool          // true if timeoutTERM has been reached
 	closed   chan struct{} // channel is closed if Close() has been called
+	cost     float64       // share of instance cost accrued so far (protected by worker's lock)
 }
 
 // newRemoteRunner returns a new remoteRunner. Caller should ensure
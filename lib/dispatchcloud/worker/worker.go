@@ -104,6 +104,7 @@ type worker struct {
 	busy                time.Time
 	destroyed           time.Time
 	firstSSHConnection  time.Time
+	costUpdated         time.Time
 	lastUUID            string
 	running             map[string]*remoteRunner // remember to update state idle<->running when this changes
 	starting            map[string]*remoteRunner // remember to update state idle<->running when this changes
@@ -168,6 +169,7 @@ func (wkr *worker) startContainer(ctr arvados.Container) {
 		"Priority":      ctr.Priority,
 	})
 	logger.Debug("starting container")
+	wkr.accrueCost(time.Now())
 	rr := newRemoteRunner(ctr.UUID, wkr)
 	wkr.starting[ctr.UUID] = rr
 	if wkr.state != StateRunning {
@@ -524,6 +526,7 @@ func (wkr *worker) shutdownIfIdle() bool {
 // caller must have lock.
 func (wkr *worker) shutdown() {
 	now := time.Now()
+	wkr.accrueCost(now)
 	wkr.updated = now
 	wkr.destroyed = now
 	wkr.state = StateShutdown
@@ -584,6 +587,7 @@ func (wkr *worker) Close() {
 //
 // Caller must have lock.
 func (wkr *worker) updateRunning(ctrUUIDs []string) (changed bool) {
+	wkr.accrueCost(time.Now())
 	alive := map[string]bool{}
 	for _, uuid := range ctrUUIDs {
 		alive[uuid] = true
@@ -616,14 +620,50 @@ func (wkr *worker) closeRunner(uuid string) {
 	if rr == nil {
 		return
 	}
-	wkr.logger.WithField("ContainerUUID", uuid).Info("crunch-run process ended")
+	now := time.Now()
+	wkr.accrueCost(now)
+	wkr.logger.WithFields(logrus.Fields{
+		"ContainerUUID": uuid,
+		"Cost":          rr.cost,
+	}).Info("crunch-run process ended")
 	delete(wkr.running, uuid)
 	rr.Close()
 
-	now := time.Now()
 	wkr.updated = now
 	wkr.wp.exited[uuid] = now
+	wkr.wp.exitedCost[uuid] = rr.cost
 	if wkr.state == StateRunning && len(wkr.running)+len(wkr.starting) == 0 {
 		wkr.state = StateIdle
 	}
 }
+
+// accrueCost adds the cost of running the instance since the last
+// call to the cost metrics, and divides it evenly among the
+// containers currently starting or running on the instance.
+//
+// Caller must have lock.
+func (wkr *worker) accrueCost(now time.Time) {
+	dt := now.Sub(wkr.costUpdated)
+	wkr.costUpdated = now
+	if dt <= 0 || wkr.state == StateShutdown {
+		return
+	}
+	cost := wkr.instType.Price * dt.Hours()
+	if wkr.wp.mInstancesCost != nil {
+		wkr.wp.mInstancesCost.WithLabelValues(wkr.instType.Name).Add(cost)
+	}
+	n := len(wkr.running) + len(wkr.starting)
+	if n == 0 {
+		return
+	}
+	share := cost / float64(n)
+	for _, rr := range wkr.running {
+		rr.cost += share
+	}
+	for _, rr := range wkr.starting {
+		rr.cost += share
+	}
+	if wkr.wp.mContainersCost != nil {
+		wkr.wp.mContainersCost.WithLabelValues(wkr.instType.Name).Add(cost)
+	}
+}
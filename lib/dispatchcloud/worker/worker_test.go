@@ -17,6 +17,7 @@ import (
 	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	check "gopkg.in/check.v1"
 )
 
@@ -235,6 +236,7 @@ func (suite *WorkerSuite) TestProbeAndUpdate(c *check.C) {
 			timeoutBooting:   bootTimeout,
 			timeoutProbe:     probeTimeout,
 			exited:           map[string]time.Time{},
+			exitedCost:       map[string]float64{},
 			runnerCmd:        "crunch-run",
 			runnerData:       trial.deployRunner,
 			runnerMD5:        md5.Sum(trial.deployRunner),
@@ -274,6 +276,71 @@ func (suite *WorkerSuite) TestProbeAndUpdate(c *check.C) {
 	}
 }
 
+func (suite *WorkerSuite) TestAccrueCost(c *check.C) {
+	logger := ctxlog.TestLogger(c)
+	is, err := (&test.StubDriver{}).InstanceSet(nil, "test-instance-set-id", nil, logger)
+	c.Assert(err, check.IsNil)
+	inst, err := is.Create(arvados.InstanceType{}, "", nil, "echo InitCommand", nil)
+	c.Assert(err, check.IsNil)
+
+	wp := &Pool{
+		logger:     logger,
+		arvClient:  arvados.NewClientFromEnv(),
+		exited:     map[string]time.Time{},
+		exitedCost: map[string]float64{},
+		workers:    map[cloud.InstanceID]*worker{},
+	}
+	wp.registerMetrics(nil)
+	wp.setupOnce.Do(func() {})
+	t0 := time.Now().Add(-10 * time.Hour)
+	wkr := &worker{
+		logger:      logger,
+		executor:    &stubExecutor{},
+		wp:          wp,
+		mtx:         &wp.mtx,
+		state:       StateIdle,
+		instance:    inst,
+		instType:    arvados.InstanceType{Name: "type1", Price: 0.5},
+		costUpdated: t0,
+		running:     map[string]*remoteRunner{},
+		starting:    map[string]*remoteRunner{},
+	}
+	wp.workers[inst.ID()] = wkr
+	uuid1 := "zzzzz-dz642-000000000000001"
+	uuid2 := "zzzzz-dz642-000000000000002"
+
+	// Idle for 2 hours: cost is not attributed to any container.
+	wkr.accrueCost(t0.Add(2 * time.Hour))
+	// One container for 2 hours, then two containers for 4 hours.
+	wkr.running[uuid1] = newRemoteRunner(uuid1, wkr)
+	wkr.accrueCost(t0.Add(4 * time.Hour))
+	wkr.starting[uuid2] = newRemoteRunner(uuid2, wkr)
+	wkr.accrueCost(t0.Add(8 * time.Hour))
+	c.Check(wkr.running[uuid1].cost, check.Equals, 0.5*2+0.5*4/2)
+	c.Check(wkr.starting[uuid2].cost, check.Equals, 0.5*4/2)
+
+	// Both containers share the cost from t0+8h until now (~2
+	// hours).
+	wkr.closeRunner(uuid1)
+	c.Check(wp.ContainerCost(uuid1) > 2.5, check.Equals, true)
+	c.Check(wp.ContainerCost(uuid1) < 2.501, check.Equals, true)
+	c.Check(wp.ContainerCost(uuid2) > 1.5, check.Equals, true)
+	c.Check(wp.ContainerCost(uuid2) < 1.501, check.Equals, true)
+	wp.ForgetContainer(uuid1)
+	c.Check(wp.ContainerCost(uuid1), check.Equals, 0.0)
+
+	c.Check(testutil.ToFloat64(wp.mInstancesCost.WithLabelValues("type1")) > 5.0, check.Equals, true)
+	c.Check(testutil.ToFloat64(wp.mInstancesCost.WithLabelValues("type1")) < 5.001, check.Equals, true)
+	c.Check(testutil.ToFloat64(wp.mContainersCost.WithLabelValues("type1")) > 4.0, check.Equals, true)
+	c.Check(testutil.ToFloat64(wp.mContainersCost.WithLabelValues("type1")) < 4.001, check.Equals, true)
+
+	// No cost accrues after shutdown.
+	wkr.shutdown()
+	cost := wp.ContainerCost(uuid2)
+	wkr.accrueCost(time.Now().Add(time.Hour))
+	c.Check(wp.ContainerCost(uuid2), check.Equals, cost)
+}
+
 type stubResp struct {
 	stdout string
 	stderr string
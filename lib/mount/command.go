@@ -6,11 +6,13 @@ package mount
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"strconv"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
@@ -41,6 +43,10 @@ func (c *cmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, st
 	ro := flags.Bool("ro", false, "read-only")
 	experimental := flags.Bool("experimental", false, "acknowledge this is an experimental command, and should not be used in production (required)")
 	blockCache := flags.Int("block-cache", 4, "read cache size (number of 64MiB blocks)")
+	readahead := flags.Int("readahead", 2, "number of upcoming blocks to prefetch when a file is read sequentially (must be less than -block-cache)")
+	diskCacheDir := flags.String("disk-cache-dir", "", "persistent block cache `directory`, shared by all mounts that use the same directory (default: no disk cache)")
+	diskCacheSize := arvados.ByteSize(10 << 30)
+	flags.Var(&byteSizeFlag{&diskCacheSize}, "disk-cache-size", "maximum `size` of the disk cache, e.g., 20GiB")
 	pprof := flags.String("pprof", "", "serve Go profile data at `[addr]:port`")
 	err := flags.Parse(args)
 	if err != nil {
@@ -51,6 +57,10 @@ func (c *cmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, st
 		logger.Printf("error: experimental command %q used without --experimental flag", prog)
 		return 2
 	}
+	if *readahead < 0 || *readahead >= *blockCache {
+		logger.Printf("error: -readahead (%d) must be at least 0 and less than -block-cache (%d)", *readahead, *blockCache)
+		return 2
+	}
 	if *pprof != "" {
 		go func() {
 			log.Println(http.ListenAndServe(*pprof, nil))
@@ -68,7 +78,16 @@ func (c *cmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, st
 		logger.Print(err)
 		return 1
 	}
-	kc.BlockCache = &keepclient.BlockCache{MaxBlocks: *blockCache}
+	kc.BlockCache = &keepclient.BlockCache{
+		MaxBlocks: *blockCache,
+		Readahead: *readahead,
+	}
+	if *diskCacheDir != "" {
+		kc.BlockCache.Disk = &keepclient.DiskCache{
+			Dir:     *diskCacheDir,
+			MaxSize: int64(diskCacheSize),
+		}
+	}
 	host := fuse.NewFileSystemHost(&keepFS{
 		Client:     client,
 		KeepClient: kc,
@@ -84,3 +103,20 @@ func (c *cmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, st
 	}
 	return 0
 }
+
+// byteSizeFlag is a flag.Value that accepts sizes with units, like
+// "10GiB".
+type byteSizeFlag struct {
+	*arvados.ByteSize
+}
+
+func (bsf byteSizeFlag) String() string {
+	if bsf.ByteSize == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", int64(*bsf.ByteSize))
+}
+
+func (bsf byteSizeFlag) Set(s string) error {
+	return bsf.ByteSize.UnmarshalJSON([]byte(strconv.Quote(s)))
+}
@@ -133,6 +133,8 @@ func (fs *keepFS) errCode(err error) int {
 		return -fuse.ENOSYS
 	case arvados.ErrDirectoryNotEmpty:
 		return -fuse.ENOTEMPTY
+	case arvados.ErrReadOnlyFileSystem:
+		return -fuse.EROFS
 	case nil:
 		return 0
 	default:
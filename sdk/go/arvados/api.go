@@ -41,6 +41,20 @@ var (
 	EndpointContainerDelete               = APIEndpoint{"DELETE", "arvados/v1/containers/{uuid}", ""}
 	EndpointContainerLock                 = APIEndpoint{"POST", "arvados/v1/containers/{uuid}/lock", ""}
 	EndpointContainerUnlock               = APIEndpoint{"POST", "arvados/v1/containers/{uuid}/unlock", ""}
+	EndpointContainerRequestCreate        = APIEndpoint{"POST", "arvados/v1/container_requests", "container_request"}
+	EndpointContainerRequestUpdate        = APIEndpoint{"PATCH", "arvados/v1/container_requests/{uuid}", "container_request"}
+	EndpointContainerRequestGet           = APIEndpoint{"GET", "arvados/v1/container_requests/{uuid}", ""}
+	EndpointContainerRequestList          = APIEndpoint{"GET", "arvados/v1/container_requests", ""}
+	EndpointContainerRequestDelete        = APIEndpoint{"DELETE", "arvados/v1/container_requests/{uuid}", ""}
+	EndpointGroupCreate                   = APIEndpoint{"POST", "arvados/v1/groups", "group"}
+	EndpointGroupUpdate                   = APIEndpoint{"PATCH", "arvados/v1/groups/{uuid}", "group"}
+	EndpointGroupGet                      = APIEndpoint{"GET", "arvados/v1/groups/{uuid}", ""}
+	EndpointGroupList                     = APIEndpoint{"GET", "arvados/v1/groups", ""}
+	EndpointGroupContents                 = APIEndpoint{"GET", "arvados/v1/groups/contents", ""}
+	EndpointGroupContentsUUIDInPath       = APIEndpoint{"GET", "arvados/v1/groups/{uuid}/contents", ""} // for compatibility with older clients
+	EndpointGroupDelete                   = APIEndpoint{"DELETE", "arvados/v1/groups/{uuid}", ""}
+	EndpointGroupTrash                    = APIEndpoint{"POST", "arvados/v1/groups/{uuid}/trash", ""}
+	EndpointGroupUntrash                  = APIEndpoint{"POST", "arvados/v1/groups/{uuid}/untrash", ""}
 	EndpointUserActivate                  = APIEndpoint{"POST", "arvados/v1/users/{uuid}/activate", ""}
 	EndpointUserCreate                    = APIEndpoint{"POST", "arvados/v1/users", "user"}
 	EndpointUserCurrent                   = APIEndpoint{"GET", "arvados/v1/users/current", ""}
@@ -89,6 +103,24 @@ type ListOptions struct {
 	ForwardedFor       string                 `json:"forwarded_for,omitempty"`
 }
 
+type GroupContentsOptions struct {
+	ClusterID          string   `json:"cluster_id"`
+	UUID               string   `json:"uuid,omitempty"`
+	Select             []string `json:"select"`
+	Filters            []Filter `json:"filters"`
+	Limit              int64    `json:"limit"`
+	Offset             int64    `json:"offset"`
+	Order              []string `json:"order"`
+	Count              string   `json:"count"`
+	Include            string   `json:"include"`
+	Recursive          bool     `json:"recursive"`
+	ExcludeHomeProject bool     `json:"exclude_home_project"`
+	IncludeTrash       bool     `json:"include_trash"`
+	IncludeOldVersions bool     `json:"include_old_versions"`
+	BypassFederation   bool     `json:"bypass_federation"`
+	ForwardedFor       string   `json:"forwarded_for,omitempty"`
+}
+
 type CreateOptions struct {
 	ClusterID        string                 `json:"cluster_id"`
 	EnsureUniqueName bool                   `json:"ensure_unique_name"`
@@ -175,6 +207,19 @@ type API interface {
 	ContainerDelete(ctx context.Context, options DeleteOptions) (Container, error)
 	ContainerLock(ctx context.Context, options GetOptions) (Container, error)
 	ContainerUnlock(ctx context.Context, options GetOptions) (Container, error)
+	ContainerRequestCreate(ctx context.Context, options CreateOptions) (ContainerRequest, error)
+	ContainerRequestUpdate(ctx context.Context, options UpdateOptions) (ContainerRequest, error)
+	ContainerRequestGet(ctx context.Context, options GetOptions) (ContainerRequest, error)
+	ContainerRequestList(ctx context.Context, options ListOptions) (ContainerRequestList, error)
+	ContainerRequestDelete(ctx context.Context, options DeleteOptions) (ContainerRequest, error)
+	GroupCreate(ctx context.Context, options CreateOptions) (Group, error)
+	GroupUpdate(ctx context.Context, options UpdateOptions) (Group, error)
+	GroupGet(ctx context.Context, options GetOptions) (Group, error)
+	GroupList(ctx context.Context, options ListOptions) (GroupList, error)
+	GroupContents(ctx context.Context, options GroupContentsOptions) (ObjectList, error)
+	GroupDelete(ctx context.Context, options DeleteOptions) (Group, error)
+	GroupTrash(ctx context.Context, options DeleteOptions) (Group, error)
+	GroupUntrash(ctx context.Context, options UntrashOptions) (Group, error)
 	SpecimenCreate(ctx context.Context, options CreateOptions) (Specimen, error)
 	SpecimenUpdate(ctx context.Context, options UpdateOptions) (Specimen, error)
 	SpecimenGet(ctx context.Context, options GetOptions) (Specimen, error)
@@ -17,6 +17,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 )
 
 var (
@@ -47,7 +49,7 @@ func makePermSignature(blobHash, apiToken, expiry, blobSignatureTTL string, perm
 }
 
 var (
-	mBlkRe      = regexp.MustCompile(`^[0-9a-f]{32}.*`)
+	mBlkRe      = regexp.MustCompile(`^` + blockdigest.HashPattern + `.*`)
 	mPermHintRe = regexp.MustCompile(`\+A[^+]*`)
 )
 
@@ -82,8 +84,8 @@ func SignLocator(blobLocator, apiToken string, expiry time.Time, blobSignatureTT
 }
 
 var SignedLocatorRe = regexp.MustCompile(
-	//1                 2          34                         5   6                  7                 89
-	`^([[:xdigit:]]{32})(\+[0-9]+)?((\+[B-Z][A-Za-z0-9@_-]*)*)(\+A([[:xdigit:]]{40})@([[:xdigit:]]{8}))((\+[B-Z][A-Za-z0-9@_-]*)*)$`)
+	//1                                2          34                         5   6                  7                 89
+	`^(` + blockdigest.HashPattern + `)(\+[0-9]+)?((\+[B-Z][A-Za-z0-9@_-]*)*)(\+A([[:xdigit:]]{40})@([[:xdigit:]]{8}))((\+[B-Z][A-Za-z0-9@_-]*)*)$`)
 
 // VerifySignature returns nil if the signature on the signedLocator
 // can be verified using the given apiToken. Otherwise it returns
@@ -53,6 +53,24 @@ func (s *BlobSignatureSuite) TestVerifySignatureExtraHints(c *check.C) {
 	c.Check(VerifySignature(knownLocator+"+K@xyzzy"+knownSigHint+"+Zfoo", knownToken, blobSignatureTTL, []byte(knownKey)), check.IsNil)
 }
 
+func (s *BlobSignatureSuite) TestSignAndVerifySHA256Locator(c *check.C) {
+	loc := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3"
+	ts, err := parseHexTimestamp(knownTimestamp)
+	c.Assert(err, check.IsNil)
+	signed := SignLocator(loc, knownToken, ts, blobSignatureTTL, []byte(knownKey))
+	c.Check(signed[:len(loc)], check.Equals, loc)
+	c.Check(signed[len(loc):], check.Matches, `\+A[0-9a-f]{40}@`+knownTimestamp)
+	c.Check(VerifySignature(signed, knownToken, blobSignatureTTL, []byte(knownKey)), check.IsNil)
+	c.Check(VerifySignature(signed+"+K@xyzzy", knownToken, blobSignatureTTL, []byte(knownKey)), check.IsNil)
+
+	// Signature of a SHA-256 locator must not verify as a
+	// signature of an MD5 locator with the same prefix.
+	c.Check(VerifySignature(loc[:32]+signed[64:], knownToken, blobSignatureTTL, []byte(knownKey)), check.Equals, ErrSignatureInvalid)
+
+	mt := ". " + loc + " 0:3:foo.txt\n"
+	c.Check(SignManifest(mt, knownToken, ts, blobSignatureTTL, []byte(knownKey)), check.Equals, ". "+signed+" 0:3:foo.txt\n")
+}
+
 // The size hint on the locator string should not affect signature
 // validation.
 func (s *BlobSignatureSuite) TestVerifySignatureWrongSize(c *check.C) {
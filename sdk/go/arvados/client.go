@@ -396,6 +396,7 @@ func (c *Client) apiURL(path string) string {
 type DiscoveryDocument struct {
 	BasePath                     string              `json:"basePath"`
 	DefaultCollectionReplication int                 `json:"defaultCollectionReplication"`
+	BlockHashAlgorithm           string              `json:"blockHashAlgorithm"`
 	BlobSignatureTTL             int64               `json:"blobSignatureTtl"`
 	GitURL                       string              `json:"gitUrl"`
 	Schemas                      map[string]Schema   `json:"schemas"`
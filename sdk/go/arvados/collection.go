@@ -76,9 +76,9 @@ func (c *Collection) SizedDigests() ([]SizedDigest, error) {
 				// FIXME: ensure it's a file token
 				break
 			}
-			// FIXME: shouldn't assume 32 char hash
-			if i := strings.IndexRune(token[33:], '+'); i >= 0 {
-				token = token[:33+i]
+			hashlen := len(blockdigest.HashPart(token))
+			if i := strings.IndexRune(token[hashlen+1:], '+'); i >= 0 {
+				token = token[:hashlen+1+i]
 			}
 			sds = append(sds, SizedDigest(token))
 		}
@@ -94,7 +94,7 @@ type CollectionList struct {
 }
 
 var (
-	blkRe = regexp.MustCompile(`^ [0-9a-f]{32}\+\d+`)
+	blkRe = regexp.MustCompile(`^ ` + blockdigest.HashPattern + `\+\d+`)
 	tokRe = regexp.MustCompile(` ?[^ ]*`)
 )
 
@@ -111,6 +111,7 @@ type Cluster struct {
 		CollectionVersioning     bool
 		DefaultTrashLifetime     Duration
 		DefaultReplication       int
+		BlockHashAlgorithm       string
 		ManagedProperties        map[string]struct {
 			Value     interface{}
 			Function  string
@@ -127,6 +128,7 @@ type Cluster struct {
 		BalanceCollectionBatch   int
 		BalanceCollectionBuffers int
 		BalanceTimeout           Duration
+		BalanceUpdateLimit       int
 
 		WebDAVCache WebDAVCacheConfig
 	}
@@ -412,6 +414,13 @@ type ContainersConfig struct {
 	SupportedDockerImageFormats StringSet
 	UsePreemptibleInstances     bool
 
+	FairShare struct {
+		Enable               bool
+		GroupBy              string
+		HalfLife             Duration
+		MaxInstancesPerGroup int
+		Weights              map[string]float64
+	}
 	JobsAPI struct {
 		Enable         string
 		GitInternalDir string
@@ -427,8 +436,24 @@ type ContainersConfig struct {
 		LogPartialLineThrottlePeriod Duration
 		LogUpdatePeriod              Duration
 		LogUpdateSize                ByteSize
+		StructuredLogs               bool
+	}
+	LSF struct {
+		BsubArgumentsList []string
+		MaxUserPriority   int64
+	}
+	PBS struct {
+		QsubArgumentsList []string
+		MinPriority       int64
+		MaxPriority       int64
 	}
 	SLURM struct {
+		Interface string
+		REST      struct {
+			URL      URL
+			UserName string
+			Token    string
+		}
 		PrioritySpread             int64
 		SbatchArgumentsList        []string
 		SbatchEnvironmentVariables map[string]string
@@ -30,9 +30,11 @@ type Container struct {
 	RuntimeStatus        map[string]interface{} `json:"runtime_status"`
 	StartedAt            *time.Time             `json:"started_at"`  // nil if not yet started
 	FinishedAt           *time.Time             `json:"finished_at"` // nil if not yet finished
+	Cost                 float64                `json:"cost"`
+	RuntimeUserUUID      string                 `json:"runtime_user_uuid"`
 }
 
-// Container is an arvados#container resource.
+// ContainerRequest is an arvados#container_request resource.
 type ContainerRequest struct {
 	UUID                    string                 `json:"uuid"`
 	OwnerUUID               string                 `json:"owner_uuid"`
@@ -49,6 +51,7 @@ type ContainerRequest struct {
 	State                   ContainerRequestState  `json:"state"`
 	RequestingContainerUUID string                 `json:"requesting_container_uuid"`
 	ContainerUUID           string                 `json:"container_uuid"`
+	ContainerCount          int                    `json:"container_count"`
 	ContainerCountMax       int                    `json:"container_count_max"`
 	Mounts                  map[string]Mount       `json:"mounts"`
 	RuntimeConstraints      RuntimeConstraints     `json:"runtime_constraints"`
@@ -65,6 +68,8 @@ type ContainerRequest struct {
 	LogUUID                 string                 `json:"log_uuid"`
 	OutputUUID              string                 `json:"output_uuid"`
 	RuntimeToken            string                 `json:"runtime_token"`
+	ExpiresAt               *time.Time             `json:"expires_at"`
+	Filters                 string                 `json:"filters"`
 }
 
 // Mount is special behavior to attach to a filesystem path or device.
@@ -98,6 +103,12 @@ type SchedulingParameters struct {
 	Partitions  []string `json:"partitions"`
 	Preemptible bool     `json:"preemptible"`
 	MaxRunTime  int      `json:"max_run_time"`
+
+	// Directory in the container where the container can save
+	// its state when asked to stop early (e.g., because a
+	// preemptible instance is being reclaimed). The saved
+	// directory is restored when the container is retried.
+	CheckpointPath string `json:"checkpoint_path,omitempty"`
 }
 
 // ContainerList is an arvados#containerList resource.
@@ -9,6 +9,7 @@ import "io"
 type fsBackend interface {
 	keepClient
 	apiClient
+	blockPrefetcher
 }
 
 // Ideally *Client would do everything; meanwhile keepBackend
@@ -24,6 +25,31 @@ type keepClient interface {
 	LocalLocator(locator string) (string, error)
 }
 
+// blockPrefetcher is an optional interface implemented by keep
+// clients that can retrieve blocks in the background before they are
+// needed.
+type blockPrefetcher interface {
+	// Number of upcoming blocks to prefetch when a file is being
+	// read sequentially. Zero means no readahead.
+	ReadaheadBlocks() int
+	// Start retrieving the given block, if it is not already
+	// cached, and return without waiting for it.
+	PrefetchBlock(locator string)
+}
+
+func (kb keepBackend) ReadaheadBlocks() int {
+	if p, ok := kb.keepClient.(blockPrefetcher); ok {
+		return p.ReadaheadBlocks()
+	}
+	return 0
+}
+
+func (kb keepBackend) PrefetchBlock(locator string) {
+	if p, ok := kb.keepClient.(blockPrefetcher); ok {
+		p.PrefetchBlock(locator)
+	}
+}
+
 type apiClient interface {
 	RequestAndDecode(dst interface{}, method, path string, body io.Reader, params interface{}) error
 }
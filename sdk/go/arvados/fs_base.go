@@ -18,17 +18,18 @@ import (
 )
 
 var (
-	ErrReadOnlyFile      = errors.New("read-only file")
-	ErrNegativeOffset    = errors.New("cannot seek to negative offset")
-	ErrFileExists        = errors.New("file exists")
-	ErrInvalidOperation  = errors.New("invalid operation")
-	ErrInvalidArgument   = errors.New("invalid argument")
-	ErrDirectoryNotEmpty = errors.New("directory not empty")
-	ErrWriteOnlyMode     = errors.New("file is O_WRONLY")
-	ErrSyncNotSupported  = errors.New("O_SYNC flag is not supported")
-	ErrIsDirectory       = errors.New("cannot rename file to overwrite existing directory")
-	ErrNotADirectory     = errors.New("not a directory")
-	ErrPermission        = os.ErrPermission
+	ErrReadOnlyFile       = errors.New("read-only file")
+	ErrReadOnlyFileSystem = errors.New("read-only file system")
+	ErrNegativeOffset     = errors.New("cannot seek to negative offset")
+	ErrFileExists         = errors.New("file exists")
+	ErrInvalidOperation   = errors.New("invalid operation")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrDirectoryNotEmpty  = errors.New("directory not empty")
+	ErrWriteOnlyMode      = errors.New("file is O_WRONLY")
+	ErrSyncNotSupported   = errors.New("O_SYNC flag is not supported")
+	ErrIsDirectory        = errors.New("cannot rename file to overwrite existing directory")
+	ErrNotADirectory      = errors.New("not a directory")
+	ErrPermission         = os.ErrPermission
 )
 
 type syncer interface {
@@ -163,6 +164,9 @@ type fileinfo struct {
 	mode    os.FileMode
 	size    int64
 	modTime time.Time
+	// If not nil, sys() returns the source data structure, which
+	// can be a *Collection, *Group, or *User, or nil.
+	sys func() interface{}
 }
 
 // Name implements os.FileInfo.
@@ -190,9 +194,12 @@ func (fi fileinfo) Size() int64 {
 	return fi.size
 }
 
-// Sys implements os.FileInfo.
+// Sys implements os.FileInfo. See comment in fileinfo struct.
 func (fi fileinfo) Sys() interface{} {
-	return nil
+	if fi.sys == nil {
+		return nil
+	}
+	return fi.sys()
 }
 
 type nullnode struct{}
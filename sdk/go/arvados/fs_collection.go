@@ -46,9 +46,17 @@ type CollectionFileSystem interface {
 type collectionFileSystem struct {
 	fileSystem
 	uuid string
+
+	// If true, all modifications fail with
+	// ErrReadOnlyFileSystem, and Sync() is a no-op.
+	readonly bool
 }
 
 // FileSystem returns a CollectionFileSystem for the collection.
+//
+// If c is a past version of a collection (i.e., its
+// CurrentVersionUUID is not its own UUID), the returned filesystem
+// is read-only.
 func (c *Collection) FileSystem(client apiClient, kc keepClient) (CollectionFileSystem, error) {
 	modTime := c.ModifiedAt
 	if modTime.IsZero() {
@@ -61,6 +69,12 @@ func (c *Collection) FileSystem(client apiClient, kc keepClient) (CollectionFile
 			thr:       newThrottle(concurrentWriters),
 		},
 	}
+	// Keep a copy of the collection record (without the
+	// manifest, which will be out of date as soon as the
+	// filesystem is modified) for the root's FileInfo().Sys().
+	sys := *c
+	sys.ManifestText = ""
+	sys.UnsignedManifestText = ""
 	root := &dirnode{
 		fs: fs,
 		treenode: treenode{
@@ -68,6 +82,7 @@ func (c *Collection) FileSystem(client apiClient, kc keepClient) (CollectionFile
 				name:    ".",
 				mode:    os.ModeDir | 0755,
 				modTime: modTime,
+				sys:     func() interface{} { return &sys },
 			},
 			inodes: make(map[string]inode),
 		},
@@ -78,6 +93,7 @@ func (c *Collection) FileSystem(client apiClient, kc keepClient) (CollectionFile
 	}
 	backdateTree(root, modTime)
 	fs.root = root
+	fs.readonly = c.CurrentVersionUUID != "" && c.CurrentVersionUUID != c.UUID
 	return fs, nil
 }
 
@@ -178,7 +194,7 @@ func (fs *collectionFileSystem) Truncate(int64) error {
 }
 
 func (fs *collectionFileSystem) Sync() error {
-	if fs.uuid == "" {
+	if fs.uuid == "" || fs.readonly {
 		return nil
 	}
 	txt, err := fs.MarshalManifest(".")
@@ -387,6 +403,32 @@ func (fn *filenode) Read(p []byte, startPtr filenodePtr) (n int, ptr filenodePtr
 	return
 }
 
+// prefetch asks the backend to start retrieving the blocks needed to
+// continue reading sequentially from ptr: the block at ptr, and the
+// configured number of blocks after that.
+//
+// Caller must have lock (or rlock).
+func (fn *filenode) prefetch(ptr filenodePtr) {
+	var want int
+	seen := map[string]bool{}
+	for i := ptr.segmentIdx; i < len(fn.segments); i++ {
+		seg, ok := fn.segments[i].(storedSegment)
+		if !ok || seen[seg.locator] {
+			continue
+		}
+		if len(seen) == 0 {
+			want = seg.kc.ReadaheadBlocks()
+			if want <= 0 {
+				return
+			}
+		} else if len(seen) > want {
+			return
+		}
+		seen[seg.locator] = true
+		seg.kc.PrefetchBlock(seg.locator)
+	}
+}
+
 func (fn *filenode) Size() int64 {
 	fn.RLock()
 	defer fn.RUnlock()
@@ -400,6 +442,9 @@ func (fn *filenode) FileInfo() os.FileInfo {
 }
 
 func (fn *filenode) Truncate(size int64) error {
+	if isReadOnly(fn.fs) {
+		return ErrReadOnlyFileSystem
+	}
 	fn.Lock()
 	defer fn.Unlock()
 	return fn.truncate(size)
@@ -456,6 +501,9 @@ func (fn *filenode) truncate(size int64) error {
 // Write writes data from p to the file, starting at startPtr,
 // extending the file size if necessary. Caller must have Lock.
 func (fn *filenode) Write(p []byte, startPtr filenodePtr) (n int, ptr filenodePtr, err error) {
+	if isReadOnly(fn.fs) {
+		return 0, startPtr, ErrReadOnlyFileSystem
+	}
 	if startPtr.off > fn.fileinfo.size {
 		if err = fn.truncate(startPtr.off); err != nil {
 			return 0, startPtr, err
@@ -591,6 +639,55 @@ func (fn *filenode) Write(p []byte, startPtr filenodePtr) (n int, ptr filenodePt
 	return
 }
 
+// Concat replaces the content of the file at dst (creating it if
+// needed) with the content of the files at srcs, in order.
+//
+// Data that has already been written to Keep is not copied: the new
+// file refers to the same stored blocks as the source files. This
+// works across collections, as long as all paths are in the same
+// filesystem (e.g., a site filesystem).
+func Concat(fs FileSystem, dst string, srcs ...string) error {
+	var segs []segment
+	for _, src := range srcs {
+		f, err := fs.OpenFile(src, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		fn, ok := f.(*filehandle).inode.(*filenode)
+		f.Close()
+		if !ok {
+			return fmt.Errorf("%q: %w", src, ErrInvalidArgument)
+		}
+		fn.RLock()
+		for _, seg := range fn.segments {
+			// Slice returns a copy of a memSegment, so
+			// later writes to src won't affect dst.
+			segs = append(segs, seg.Slice(0, seg.Len()))
+		}
+		fn.RUnlock()
+	}
+	f, err := fs.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fn, ok := f.(*filehandle).inode.(*filenode)
+	if !ok {
+		return fmt.Errorf("%q: %w", dst, ErrInvalidArgument)
+	}
+	fn.Lock()
+	defer fn.Unlock()
+	fn.repacked++
+	for _, seg := range segs {
+		if seg, ok := seg.(*memSegment); ok {
+			fn.memsize += int64(seg.Len())
+		}
+		fn.appendSegment(seg)
+	}
+	fn.fileinfo.modTime = time.Now()
+	return nil
+}
+
 // Write some data out to disk to reduce memory use. Caller must have
 // write lock.
 func (fn *filenode) pruneMemSegments() {
@@ -687,9 +784,19 @@ func (dn *dirnode) Child(name string, replace func(inode) (inode, error)) (inode
 		gn.SetParent(dn, name)
 		return gn, nil
 	}
+	if replace != nil && dn.fs.readonly {
+		child, _ := dn.treenode.Child(name, nil)
+		return child, ErrReadOnlyFileSystem
+	}
 	return dn.treenode.Child(name, replace)
 }
 
+// isReadOnly returns true if fs is a read-only collection filesystem.
+func isReadOnly(fs FileSystem) bool {
+	cfs, ok := fs.(*collectionFileSystem)
+	return ok && cfs.readonly
+}
+
 type fnSegmentRef struct {
 	fn  *filenode
 	idx int
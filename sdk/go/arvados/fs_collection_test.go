@@ -112,6 +112,20 @@ func (s *CollectionFSSuite) TestHttpFileSystemInterface(c *check.C) {
 	c.Check(ok, check.Equals, true)
 }
 
+func (s *CollectionFSSuite) TestRootSys(c *check.C) {
+	fi, err := s.fs.Stat(".")
+	c.Assert(err, check.IsNil)
+	coll, ok := fi.Sys().(*Collection)
+	c.Assert(ok, check.Equals, true)
+	c.Check(coll.UUID, check.Equals, fixtureFooAndBarFilesInDirUUID)
+	c.Check(coll.PortableDataHash, check.Equals, s.coll.PortableDataHash)
+	c.Check(coll.ManifestText, check.Equals, "")
+
+	fi, err = s.fs.Stat("dir1/foo")
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Sys(), check.IsNil)
+}
+
 func (s *CollectionFSSuite) TestColonInFilename(c *check.C) {
 	fs, err := (&Collection{
 		ManifestText: "./foo:foo 3858f62230ac3c915f300c664312c63f+3 0:3:bar:bar\n",
@@ -816,6 +830,44 @@ func (s *CollectionFSSuite) TestRename(c *check.C) {
 	c.Check(err, check.Equals, os.ErrNotExist)
 }
 
+func (s *CollectionFSSuite) TestConcat(c *check.C) {
+	fs, err := (&Collection{
+		ManifestText: ". 3858f62230ac3c915f300c664312c63f+6 0:3:foo 3:3:bar\n",
+	}).FileSystem(s.client, s.kc)
+	c.Assert(err, check.IsNil)
+	f, err := fs.OpenFile("baz", os.O_CREATE|os.O_WRONLY, 0644)
+	c.Assert(err, check.IsNil)
+	_, err = f.Write([]byte("baz"))
+	c.Assert(err, check.IsNil)
+	c.Assert(f.Close(), check.IsNil)
+
+	c.Assert(fs.Mkdir("dir", 0755), check.IsNil)
+	c.Check(Concat(fs, "dir", "foo"), check.NotNil)
+	c.Check(Concat(fs, "dir/foobarbaz", "foo", "dir"), check.NotNil)
+	c.Check(Concat(fs, "dir/foobarbaz", "foo", "missing"), check.NotNil)
+	c.Assert(Concat(fs, "dir/foobarbaz", "foo", "bar", "baz", "foo"), check.IsNil)
+
+	// Later writes to a source file don't affect the result
+	f, err = fs.OpenFile("baz", os.O_WRONLY, 0)
+	c.Assert(err, check.IsNil)
+	_, err = f.Write([]byte("ZZZ"))
+	c.Assert(err, check.IsNil)
+	c.Assert(f.Close(), check.IsNil)
+
+	rdr, err := fs.Open("dir/foobarbaz")
+	c.Assert(err, check.IsNil)
+	buf, err := ioutil.ReadAll(rdr)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foobarbazfoo")
+	fi, err := fs.Stat("dir/foobarbaz")
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Size(), check.Equals, int64(12))
+
+	m, err := fs.MarshalManifest(".")
+	c.Assert(err, check.IsNil)
+	c.Check(m, check.Matches, `(?ms).*\n\./dir 3858f62230ac3c915f300c664312c63f\+6\S* 73feffa4b7f6bb68e44cf984c85f6e88\+3\S* 3858f62230ac3c915f300c664312c63f\+6\S* 0:12:foobarbaz\n`)
+}
+
 func (s *CollectionFSSuite) TestPersist(c *check.C) {
 	maxBlockSize = 1024
 	defer func() { maxBlockSize = 2 << 26 }()
@@ -1468,7 +1520,105 @@ func (s *CollectionFSUnitSuite) TestLargeManifest(c *check.C) {
 	c.Logf("%s Alloc=%d Sys=%d", time.Now(), memstats.Alloc, memstats.Sys)
 }
 
+type prefetchingKeepClientStub struct {
+	*keepClientStub
+	readahead  int
+	prefetched []string
+}
+
+func (kcs *prefetchingKeepClientStub) ReadaheadBlocks() int {
+	return kcs.readahead
+}
+
+func (kcs *prefetchingKeepClientStub) PrefetchBlock(locator string) {
+	kcs.prefetched = append(kcs.prefetched, locator[:32])
+}
+
+func (s *CollectionFSUnitSuite) TestPrefetchSequentialRead(c *check.C) {
+	kc := &prefetchingKeepClientStub{
+		keepClientStub: &keepClientStub{blocks: map[string][]byte{}},
+		readahead:      2,
+	}
+	var locators []string
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("block%d", i))
+		hash := fmt.Sprintf("%x", md5.Sum(data))
+		kc.blocks[hash] = data
+		locators = append(locators, fmt.Sprintf("%s+%d", hash, len(data)))
+	}
+	fs, err := (&Collection{
+		ManifestText: ". " + strings.Join(locators, " ") + " 0:30:file\n",
+	}).FileSystem(nil, kc)
+	c.Assert(err, check.IsNil)
+	f, err := fs.Open("file")
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+
+	// First read, at offset 0, is sequential: prefetch current
+	// block and 2 more.
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(f, buf)
+	c.Check(err, check.IsNil)
+	c.Check(kc.prefetched, check.DeepEquals, []string{locators[1][:32], locators[2][:32], locators[3][:32]})
+
+	// Non-sequential read: no prefetch.
+	kc.prefetched = nil
+	_, err = f.Seek(24, io.SeekStart)
+	c.Check(err, check.IsNil)
+	_, err = io.ReadFull(f, buf)
+	c.Check(err, check.IsNil)
+	c.Check(kc.prefetched, check.HasLen, 0)
+
+	// Readahead disabled.
+	kc.readahead = 0
+	_, err = f.Seek(0, io.SeekStart)
+	c.Check(err, check.IsNil)
+	_, err = io.ReadFull(f, buf)
+	c.Check(err, check.IsNil)
+	_, err = io.ReadFull(f, buf)
+	c.Check(err, check.IsNil)
+	c.Check(kc.prefetched, check.HasLen, 0)
+}
+
 // Gocheck boilerplate
 func Test(t *testing.T) {
 	check.TestingT(t)
 }
+
+func (s *CollectionFSUnitSuite) TestOldVersionReadOnly(c *check.C) {
+	fs, err := (&Collection{
+		UUID:               "zzzzz-4zz18-aaaaaaaaaaaaaaa",
+		CurrentVersionUUID: "zzzzz-4zz18-bbbbbbbbbbbbbbb",
+		ManifestText:       ". acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo 0:3:dir/bar\n",
+	}).FileSystem(nil, &keepClientStub{})
+	c.Assert(err, check.IsNil)
+
+	f, err := fs.OpenFile("foo", os.O_RDWR, 0)
+	c.Assert(err, check.IsNil)
+	_, err = f.Write([]byte("bar"))
+	c.Check(err, check.Equals, ErrReadOnlyFileSystem)
+	c.Check(f.Truncate(0), check.Equals, ErrReadOnlyFileSystem)
+	c.Check(f.Close(), check.IsNil)
+
+	_, err = fs.OpenFile("foo", os.O_RDWR|os.O_TRUNC, 0)
+	c.Check(err, check.Equals, ErrReadOnlyFileSystem)
+	_, err = fs.OpenFile("newfile", os.O_CREATE|os.O_RDWR, 0644)
+	c.Check(err, check.Equals, ErrReadOnlyFileSystem)
+	c.Check(fs.Mkdir("newdir", 0755), check.Equals, ErrReadOnlyFileSystem)
+	c.Check(fs.Remove("foo"), check.Equals, ErrReadOnlyFileSystem)
+	c.Check(fs.RemoveAll("dir"), check.Equals, ErrReadOnlyFileSystem)
+	c.Check(fs.Rename("foo", "dir/foo"), check.Equals, ErrReadOnlyFileSystem)
+	c.Check(fs.Sync(), check.IsNil)
+
+	txt, err := fs.MarshalManifest(".")
+	c.Check(err, check.IsNil)
+	c.Check(txt, check.Equals, ". acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo\n./dir acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:bar\n")
+
+	// The current version is writable.
+	fs, err = (&Collection{
+		UUID:               "zzzzz-4zz18-aaaaaaaaaaaaaaa",
+		CurrentVersionUUID: "zzzzz-4zz18-aaaaaaaaaaaaaaa",
+	}).FileSystem(nil, &keepClientStub{})
+	c.Assert(err, check.IsNil)
+	c.Check(fs.Mkdir("newdir", 0755), check.IsNil)
+}
@@ -11,7 +11,11 @@ import (
 	"time"
 )
 
-func deferredCollectionFS(fs FileSystem, parent inode, coll Collection) inode {
+// deferredCollectionFS returns a deferrednode that loads the given
+// collection's manifest only when it is needed. If readonly is true,
+// the loaded collection filesystem rejects modifications.
+func deferredCollectionFS(fs FileSystem, parent inode, coll Collection, readonly bool) inode {
+	name := coll.Name
 	modTime := coll.ModifiedAt
 	if modTime.IsZero() {
 		modTime = time.Now()
@@ -21,9 +25,10 @@ func deferredCollectionFS(fs FileSystem, parent inode, coll Collection) inode {
 		parent: parent,
 		inodes: nil,
 		fileinfo: fileinfo{
-			name:    coll.Name,
+			name:    name,
 			modTime: modTime,
 			mode:    0755 | os.ModeDir,
+			sys:     func() interface{} { return &coll },
 		},
 	}
 	return &deferrednode{wrapped: placeholder, create: func() inode {
@@ -38,7 +43,8 @@ func deferredCollectionFS(fs FileSystem, parent inode, coll Collection) inode {
 			return placeholder
 		}
 		cfs := newfs.(*collectionFileSystem)
-		cfs.SetParent(parent, coll.Name)
+		cfs.readonly = cfs.readonly || readonly
+		cfs.SetParent(parent, name)
 		return cfs
 	}}
 }
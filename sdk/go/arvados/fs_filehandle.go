@@ -16,6 +16,11 @@ type filehandle struct {
 	readable   bool
 	writable   bool
 	unreaddirs []os.FileInfo
+
+	// Offset where the last Read ended. If the next Read starts
+	// here, the file is being read sequentially, and upcoming
+	// blocks are prefetched.
+	lastReadEnd int64
 }
 
 func (f *filehandle) Read(p []byte) (n int, err error) {
@@ -24,7 +29,14 @@ func (f *filehandle) Read(p []byte) (n int, err error) {
 	}
 	f.inode.RLock()
 	defer f.inode.RUnlock()
+	sequential := f.ptr.off == f.lastReadEnd
 	n, f.ptr, err = f.inode.Read(p, f.ptr)
+	f.lastReadEnd = f.ptr.off
+	if sequential && n > 0 {
+		if fn, ok := f.inode.(*filenode); ok {
+			fn.prefetch(f.ptr)
+		}
+	}
 	return
 }
 
@@ -9,12 +9,16 @@ import (
 	"strings"
 )
 
+// Number of collections or projects to request per page when
+// loading a project directory. The API server may return fewer.
+const projectsPageSize = 1000
+
 func (fs *customFileSystem) defaultUUID(uuid string) (string, error) {
 	if uuid != "" {
 		return uuid, nil
 	}
 	var resp User
-	err := fs.RequestAndDecode(&resp, "GET", "arvados/v1/users/current", nil, nil)
+	err := fs.RequestAndDecode(&resp, EndpointUserGetCurrent.Method, EndpointUserGetCurrent.Path, nil, nil)
 	if err != nil {
 		return "", err
 	}
@@ -31,7 +35,9 @@ func (fs *customFileSystem) projectsLoadOne(parent inode, uuid, name string) (in
 	var contents CollectionList
 	for _, subst := range []string{"/", fs.forwardSlashNameSubstitution} {
 		contents = CollectionList{}
-		err = fs.RequestAndDecode(&contents, "GET", "arvados/v1/groups/"+uuid+"/contents", nil, ResourceListParams{
+		err = fs.RequestAndDecode(&contents, EndpointGroupContents.Method, EndpointGroupContents.Path, nil, GroupContentsOptions{
+			UUID:  uuid,
+			Limit: 1,
 			Count: "none",
 			Filters: []Filter{
 				{"name", "=", strings.Replace(name, subst, "/", -1)},
@@ -63,9 +69,15 @@ func (fs *customFileSystem) projectsLoadOne(parent inode, uuid, name string) (in
 	if strings.Contains(coll.UUID, "-j7d0g-") {
 		// Group item was loaded into a Collection var -- but
 		// we only need the Name and UUID anyway, so it's OK.
-		return fs.newProjectNode(parent, coll.Name, coll.UUID), nil
+		return fs.newProjectNode(parent, coll.Name, coll.UUID, &Group{
+			UUID:       coll.UUID,
+			Name:       coll.Name,
+			OwnerUUID:  coll.OwnerUUID,
+			ModifiedAt: coll.ModifiedAt,
+			Properties: coll.Properties,
+		}), nil
 	} else if strings.Contains(coll.UUID, "-4zz18-") {
-		return deferredCollectionFS(fs, parent, coll), nil
+		return deferredCollectionFS(fs, parent, coll, false), nil
 	} else {
 		log.Printf("projectnode: unrecognized UUID in response: %q", coll.UUID)
 		return nil, ErrInvalidArgument
@@ -84,14 +96,15 @@ func (fs *customFileSystem) projectsLoadAll(parent inode, uuid string) ([]inode,
 	// by append(filters,...) below. This isn't goroutine safe,
 	// but all accesses are in the same goroutine, so it's OK.
 	filters := []Filter{{"owner_uuid", "=", uuid}}
-	params := ResourceListParams{
+	params := ListOptions{
 		Count:   "none",
 		Filters: filters,
-		Order:   "uuid",
+		Limit:   projectsPageSize,
+		Order:   []string{"uuid"},
 	}
 	for {
 		var resp CollectionList
-		err = fs.RequestAndDecode(&resp, "GET", "arvados/v1/collections", nil, params)
+		err = fs.RequestAndDecode(&resp, EndpointCollectionList.Method, EndpointCollectionList.Path, nil, params)
 		if err != nil {
 			return nil, err
 		}
@@ -106,7 +119,7 @@ func (fs *customFileSystem) projectsLoadAll(parent inode, uuid string) ([]inode,
 			if !permittedName(coll.Name) {
 				continue
 			}
-			inodes = append(inodes, deferredCollectionFS(fs, parent, coll))
+			inodes = append(inodes, deferredCollectionFS(fs, parent, coll, false))
 		}
 		params.Filters = append(filters, Filter{"uuid", ">", resp.Items[len(resp.Items)-1].UUID})
 	}
@@ -115,21 +128,22 @@ func (fs *customFileSystem) projectsLoadAll(parent inode, uuid string) ([]inode,
 	params.Filters = filters
 	for {
 		var resp GroupList
-		err = fs.RequestAndDecode(&resp, "GET", "arvados/v1/groups", nil, params)
+		err = fs.RequestAndDecode(&resp, EndpointGroupList.Method, EndpointGroupList.Path, nil, params)
 		if err != nil {
 			return nil, err
 		}
 		if len(resp.Items) == 0 {
 			break
 		}
-		for _, group := range resp.Items {
+		for _, i := range resp.Items {
+			group := i
 			if fs.forwardSlashNameSubstitution != "" {
 				group.Name = strings.Replace(group.Name, "/", fs.forwardSlashNameSubstitution, -1)
 			}
 			if !permittedName(group.Name) {
 				continue
 			}
-			inodes = append(inodes, fs.newProjectNode(parent, group.Name, group.UUID))
+			inodes = append(inodes, fs.newProjectNode(parent, group.Name, group.UUID, &group))
 		}
 		params.Filters = append(filters, Filter{"uuid", ">", resp.Items[len(resp.Items)-1].UUID})
 	}
@@ -118,6 +118,40 @@ func (s *SiteFSSuite) TestProjectReaddirAfterLoadOne(c *check.C) {
 	c.Check(foundCollection, check.Equals, true)
 }
 
+func (s *SiteFSSuite) TestProjectSys(c *check.C) {
+	fi, err := s.fs.Stat("/users/active/A Project")
+	c.Assert(err, check.IsNil)
+	proj, ok := fi.Sys().(*Group)
+	c.Assert(ok, check.Equals, true)
+	c.Check(proj.UUID, check.Equals, fixtureAProjectUUID)
+
+	fi, err = s.fs.Stat("/by_id/" + fixtureAProjectUUID)
+	c.Assert(err, check.IsNil)
+	proj, ok = fi.Sys().(*Group)
+	c.Assert(ok, check.Equals, true)
+	c.Check(proj.Name, check.Equals, "A Project")
+
+	fi, err = s.fs.Stat("/users/active")
+	c.Assert(err, check.IsNil)
+	user, ok := fi.Sys().(*User)
+	c.Assert(ok, check.Equals, true)
+	c.Check(user.Username, check.Equals, "active")
+
+	f, err := s.fs.Open("/users/active/A Project")
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	fis, err := f.Readdir(-1)
+	c.Assert(err, check.IsNil)
+	for _, fi := range fis {
+		if fi.Name() == "collection_to_move_around" {
+			coll, ok := fi.Sys().(*Collection)
+			c.Assert(ok, check.Equals, true)
+			c.Check(coll.UUID, check.Matches, `zzzzz-4zz18-.*`)
+			c.Check(coll.PortableDataHash, check.Not(check.Equals), "")
+		}
+	}
+}
+
 func (s *SiteFSSuite) TestSlashInName(c *check.C) {
 	var badCollection Collection
 	err := s.client.RequestAndDecode(&badCollection, "POST", "arvados/v1/collections", nil, map[string]interface{}{
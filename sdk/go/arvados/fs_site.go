@@ -73,7 +73,7 @@ func (fs *customFileSystem) MountByID(mount string) {
 
 func (fs *customFileSystem) MountProject(mount, uuid string) {
 	fs.root.treenode.Child(mount, func(inode) (inode, error) {
-		return fs.newProjectNode(fs.root, mount, uuid), nil
+		return fs.newProjectNode(fs.root, mount, uuid, nil), nil
 	})
 }
 
@@ -104,6 +104,10 @@ func (fs *customFileSystem) ForwardSlashNameSubstitution(repl string) {
 // SiteFileSystem returns a FileSystem that maps collections and other
 // Arvados objects onto a filesystem layout.
 //
+// Past versions of a collection are available read-only as
+// by_id/{uuid}@{version}, and are listed in
+// by_id/.versions/{uuid}/{version}.
+//
 // This is experimental: the filesystem layout is not stable, and
 // there are significant known bugs and shortcomings. For example,
 // writes are not persisted until Sync() is called.
@@ -131,10 +135,14 @@ func (fs *customFileSystem) newNode(name string, perm os.FileMode, modTime time.
 }
 
 func (fs *customFileSystem) mountByID(parent inode, id string) inode {
-	if strings.Contains(id, "-4zz18-") || pdhRegexp.MatchString(id) {
+	if id == versionDirName {
+		return fs.newVersionsNode(parent)
+	} else if versionIDRegexp.MatchString(id) {
+		return fs.mountCollectionVersion(parent, id)
+	} else if strings.Contains(id, "-4zz18-") || pdhRegexp.MatchString(id) {
 		return fs.mountCollection(parent, id)
 	} else if strings.Contains(id, "-j7d0g-") {
-		return fs.newProjectNode(fs.root, id, id)
+		return fs.newProjectNode(fs.root, id, id, nil)
 	} else {
 		return nil
 	}
@@ -155,7 +163,35 @@ func (fs *customFileSystem) mountCollection(parent inode, id string) inode {
 	return cfs
 }
 
-func (fs *customFileSystem) newProjectNode(root inode, name, uuid string) inode {
+// newProjectNode returns a directory node for the project (or user's
+// home project) with the given UUID. If proj is nil, the project
+// record is retrieved from the API server when FileInfo().Sys() is
+// first called.
+func (fs *customFileSystem) newProjectNode(root inode, name, uuid string, proj interface{}) inode {
+	var once sync.Once
+	sys := func() interface{} {
+		once.Do(func() {
+			if proj != nil {
+				return
+			}
+			uuid, err := fs.defaultUUID(uuid)
+			if err != nil {
+				return
+			}
+			if strings.Contains(uuid, "-tpzed-") {
+				var user User
+				if fs.RequestAndDecode(&user, "GET", "arvados/v1/users/"+uuid, nil, nil) == nil {
+					proj = &user
+				}
+			} else {
+				var group Group
+				if fs.RequestAndDecode(&group, "GET", "arvados/v1/groups/"+uuid, nil, nil) == nil {
+					proj = &group
+				}
+			}
+		})
+		return proj
+	}
 	return &lookupnode{
 		stale:   fs.Stale,
 		loadOne: func(parent inode, name string) (inode, error) { return fs.projectsLoadOne(parent, uuid, name) },
@@ -168,6 +204,7 @@ func (fs *customFileSystem) newProjectNode(root inode, name, uuid string) inode
 				name:    name,
 				modTime: time.Now(),
 				mode:    0755 | os.ModeDir,
+				sys:     sys,
 			},
 		},
 	}
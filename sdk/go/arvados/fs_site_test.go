@@ -7,6 +7,7 @@ package arvados
 import (
 	"net/http"
 	"os"
+	"sort"
 	"time"
 
 	check "gopkg.in/check.v1"
@@ -23,6 +24,7 @@ const (
 	fixtureFooCollectionPDH        = "1f4b0bc7583c2a7f9102c395f4ffc5e3+45"
 	fixtureFooCollection           = "zzzzz-4zz18-fy296fx3hot09f7"
 	fixtureNonexistentCollection   = "zzzzz-4zz18-totallynotexist"
+	fixtureWazCollection           = "zzzzz-4zz18-25k12570yk134b3"
 	fixtureBlobSigningKey          = "zfhgfenhffzltr9dixws36j1yhksjoll2grmku38mi7yxd66h5j4q9w4jzanezacp8s6q0ro3hxakfye02152hncy6zml2ed0uc"
 	fixtureBlobSigningTTL          = 336 * time.Hour
 )
@@ -118,3 +120,49 @@ func (s *SiteFSSuite) TestByUUIDAndPDH(c *check.C) {
 	err = s.fs.Rename("/by_id", "/beep")
 	c.Check(err, check.Equals, ErrInvalidArgument)
 }
+
+func (s *SiteFSSuite) TestCollectionVersions(c *check.C) {
+	f, err := s.fs.Open("/by_id/.versions/" + fixtureWazCollection)
+	c.Assert(err, check.IsNil)
+	fis, err := f.Readdir(-1)
+	c.Assert(err, check.IsNil)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	c.Check(names, check.DeepEquals, []string{"1", "2"})
+
+	for path, expect := range map[string][]string{
+		"/by_id/.versions/" + fixtureWazCollection + "/1": {"waz"},
+		"/by_id/.versions/" + fixtureWazCollection + "/2": {"w a z"},
+		"/by_id/" + fixtureWazCollection + "@1":           {"waz"},
+		"/by_id/" + fixtureWazCollection + "@2":           {"w a z"},
+	} {
+		f, err = s.fs.Open(path)
+		c.Assert(err, check.IsNil, check.Commentf("%s", path))
+		fis, err = f.Readdir(-1)
+		c.Assert(err, check.IsNil)
+		names = nil
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		c.Check(names, check.DeepEquals, expect, check.Commentf("%s", path))
+
+		// Versions are read-only, even the current one.
+		_, err = s.fs.OpenFile(path+"/newfile", os.O_CREATE|os.O_RDWR, 0644)
+		c.Check(err, check.Equals, ErrReadOnlyFileSystem)
+		err = s.fs.Remove(path + "/" + expect[0])
+		c.Check(err, check.Equals, ErrReadOnlyFileSystem)
+	}
+
+	for _, path := range []string{
+		"/by_id/.versions/" + fixtureWazCollection + "/3",
+		"/by_id/.versions/" + fixtureWazCollection + "/01",
+		"/by_id/.versions/" + fixtureAProjectUUID,
+		"/by_id/" + fixtureWazCollection + "@3",
+	} {
+		_, err = s.fs.Stat(path)
+		c.Check(err, check.Equals, os.ErrNotExist, check.Commentf("%s", path))
+	}
+}
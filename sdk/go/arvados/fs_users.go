@@ -20,7 +20,7 @@ func (fs *customFileSystem) usersLoadOne(parent inode, name string) (inode, erro
 		return nil, os.ErrNotExist
 	}
 	user := resp.Items[0]
-	return fs.newProjectNode(parent, user.Username, user.UUID), nil
+	return fs.newProjectNode(parent, user.Username, user.UUID, &user), nil
 }
 
 func (fs *customFileSystem) usersLoadAll(parent inode) ([]inode, error) {
@@ -37,11 +37,12 @@ func (fs *customFileSystem) usersLoadAll(parent inode) ([]inode, error) {
 		} else if len(resp.Items) == 0 {
 			return inodes, nil
 		}
-		for _, user := range resp.Items {
+		for _, i := range resp.Items {
+			user := i
 			if user.Username == "" {
 				continue
 			}
-			inodes = append(inodes, fs.newProjectNode(parent, user.Username, user.UUID))
+			inodes = append(inodes, fs.newProjectNode(parent, user.Username, user.UUID, &user))
 		}
 		params.Filters = []Filter{{"uuid", ">", resp.Items[len(resp.Items)-1].UUID}}
 	}
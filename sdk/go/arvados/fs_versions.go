@@ -0,0 +1,164 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package arvados
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// versionDirName is the name of the directory (inside a by_id mount)
+// that lists past versions of collections, e.g.,
+// by_id/.versions/zzzzz-4zz18-aaaaaaaaaaaaaaa/3/.
+const versionDirName = ".versions"
+
+// versionIDRegexp matches a reference to a specific version of a
+// collection, like "zzzzz-4zz18-aaaaaaaaaaaaaaa@3".
+var versionIDRegexp = regexp.MustCompile(`^([0-9a-z]{5}-4zz18-[0-9a-z]{15})@([0-9]+)$`)
+
+var collectionUUIDRegexp = regexp.MustCompile(`^[0-9a-z]{5}-4zz18-[0-9a-z]{15}$`)
+
+// Fields retrieved when listing collection versions. The manifest
+// is retrieved later, when a version's content is accessed.
+var versionListSelect = []string{"uuid", "name", "owner_uuid", "portable_data_hash", "version", "current_version_uuid", "created_at", "modified_at", "modified_by_user_uuid", "properties", "file_count", "file_size_total"}
+
+// newVersionsNode returns a directory whose children are named
+// "{uuid}" for collection UUIDs, each of which in turn lists the
+// collection's versions.
+func (fs *customFileSystem) newVersionsNode(parent inode) inode {
+	return &vdirnode{
+		treenode: treenode{
+			fs:     fs,
+			parent: parent,
+			inodes: make(map[string]inode),
+			fileinfo: fileinfo{
+				name:    versionDirName,
+				modTime: time.Now(),
+				mode:    0755 | os.ModeDir,
+			},
+		},
+		create: func(parent inode, uuid string) inode {
+			if !collectionUUIDRegexp.MatchString(uuid) {
+				return nil
+			}
+			return fs.newCollectionVersionsNode(parent, uuid)
+		},
+	}
+}
+
+// newCollectionVersionsNode returns a directory listing all versions
+// of the collection with the given UUID, named by version number.
+// Each version is a read-only collection filesystem.
+func (fs *customFileSystem) newCollectionVersionsNode(parent inode, uuid string) inode {
+	return &lookupnode{
+		stale: fs.Stale,
+		loadOne: func(parent inode, name string) (inode, error) {
+			return fs.versionsLoadOne(parent, uuid, name)
+		},
+		loadAll: func(parent inode) ([]inode, error) {
+			return fs.versionsLoadAll(parent, uuid)
+		},
+		treenode: treenode{
+			fs:     fs,
+			parent: parent,
+			inodes: make(map[string]inode),
+			fileinfo: fileinfo{
+				name:    uuid,
+				modTime: time.Now(),
+				mode:    0755 | os.ModeDir,
+			},
+		},
+	}
+}
+
+// findVersion returns the given version of the collection with the
+// given (current version) UUID, or nil if there is no such version.
+func (fs *customFileSystem) findVersion(uuid string, version int) (*Collection, error) {
+	var resp CollectionList
+	err := fs.RequestAndDecode(&resp, "GET", "arvados/v1/collections", nil, ResourceListParams{
+		Count:              "none",
+		IncludeOldVersions: true,
+		Select:             versionListSelect,
+		Filters: []Filter{
+			{"current_version_uuid", "=", uuid},
+			{"version", "=", version},
+		},
+	})
+	if err != nil {
+		return nil, err
+	} else if len(resp.Items) == 0 {
+		return nil, nil
+	}
+	return &resp.Items[0], nil
+}
+
+func (fs *customFileSystem) versionsLoadOne(parent inode, uuid, name string) (inode, error) {
+	version, err := strconv.Atoi(name)
+	if err != nil || strconv.Itoa(version) != name {
+		return nil, nil
+	}
+	coll, err := fs.findVersion(uuid, version)
+	if err != nil || coll == nil {
+		return nil, err
+	}
+	coll.Name = name
+	return deferredCollectionFS(fs, parent, *coll, true), nil
+}
+
+func (fs *customFileSystem) versionsLoadAll(parent inode, uuid string) ([]inode, error) {
+	filters := []Filter{{"current_version_uuid", "=", uuid}}
+	params := ResourceListParams{
+		Count:              "none",
+		IncludeOldVersions: true,
+		Select:             versionListSelect,
+		Filters:            filters,
+		Order:              "version",
+	}
+	var inodes []inode
+	for {
+		var resp CollectionList
+		err := fs.RequestAndDecode(&resp, "GET", "arvados/v1/collections", nil, params)
+		if err != nil {
+			return nil, err
+		} else if len(resp.Items) == 0 {
+			return inodes, nil
+		}
+		for _, i := range resp.Items {
+			coll := i
+			coll.Name = strconv.Itoa(coll.Version)
+			inodes = append(inodes, deferredCollectionFS(fs, parent, coll, true))
+		}
+		params.Filters = append(filters, Filter{"version", ">", resp.Items[len(resp.Items)-1].Version})
+	}
+}
+
+// mountCollectionVersion returns a read-only collection filesystem
+// for a collection version reference like
+// "zzzzz-4zz18-aaaaaaaaaaaaaaa@3", or nil if the version does not
+// exist.
+func (fs *customFileSystem) mountCollectionVersion(parent inode, id string) inode {
+	m := versionIDRegexp.FindStringSubmatch(id)
+	if m == nil {
+		return nil
+	}
+	version, err := strconv.Atoi(m[2])
+	if err != nil {
+		return nil
+	}
+	coll, err := fs.findVersion(m[1], version)
+	if err != nil || coll == nil {
+		return nil
+	}
+	node := fs.mountCollection(parent, coll.UUID)
+	if node == nil {
+		return nil
+	}
+	cfs := node.(*collectionFileSystem)
+	cfs.readonly = true
+	cfs.SetParent(parent, id)
+	return cfs
+}
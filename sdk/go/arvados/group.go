@@ -4,12 +4,28 @@
 
 package arvados
 
+import (
+	"time"
+)
+
 // Group is an arvados#group record
 type Group struct {
-	UUID       string `json:"uuid"`
-	Name       string `json:"name"`
-	OwnerUUID  string `json:"owner_uuid"`
-	GroupClass string `json:"group_class"`
+	UUID                 string                 `json:"uuid"`
+	Name                 string                 `json:"name"`
+	OwnerUUID            string                 `json:"owner_uuid"`
+	GroupClass           string                 `json:"group_class"`
+	Etag                 string                 `json:"etag"`
+	Href                 string                 `json:"href"`
+	Description          string                 `json:"description"`
+	Properties           map[string]interface{} `json:"properties"`
+	CreatedAt            time.Time              `json:"created_at"`
+	ModifiedAt           time.Time              `json:"modified_at"`
+	ModifiedByClientUUID string                 `json:"modified_by_client_uuid"`
+	ModifiedByUserUUID   string                 `json:"modified_by_user_uuid"`
+	TrashAt              *time.Time             `json:"trash_at"`
+	DeleteAt             *time.Time             `json:"delete_at"`
+	IsTrashed            bool                   `json:"is_trashed"`
+	WritableBy           []string               `json:"writable_by,omitempty"`
 }
 
 // GroupList is an arvados#groupList resource.
@@ -20,6 +36,16 @@ type GroupList struct {
 	Limit          int     `json:"limit"`
 }
 
+// ObjectList is an arvados#objectList resource, as returned by the
+// groups/contents API. Items may be of any type.
+type ObjectList struct {
+	Included       []interface{} `json:"included"`
+	Items          []interface{} `json:"items"`
+	ItemsAvailable int           `json:"items_available"`
+	Offset         int           `json:"offset"`
+	Limit          int           `json:"limit"`
+}
+
 func (g Group) resourceName() string {
 	return "group"
 }
@@ -12,8 +12,20 @@ import (
 // SizedDigest is a minimal Keep block locator: hash+size
 type SizedDigest string
 
+// Hash returns the hash part of the locator, without the size.
+func (sd SizedDigest) Hash() string {
+	if i := strings.IndexByte(string(sd), '+'); i >= 0 {
+		return string(sd[:i])
+	}
+	return string(sd)
+}
+
 // Size returns the size of the data block, in bytes.
 func (sd SizedDigest) Size() int64 {
-	n, _ := strconv.ParseInt(strings.Split(string(sd), "+")[1], 10, 64)
+	i := strings.IndexByte(string(sd), '+')
+	if i < 0 {
+		return 0
+	}
+	n, _ := strconv.ParseInt(string(sd[i+1:]), 10, 64)
 	return n
 }
@@ -13,13 +13,19 @@ import (
 )
 
 var LocatorPattern = regexp.MustCompile(
-	"^[0-9a-fA-F]{32}\\+[0-9]+(\\+[A-Z][A-Za-z0-9@_-]*)*$")
+	"^" + hashPattern("0-9a-fA-F") + "\\+[0-9]+(\\+[A-Z][A-Za-z0-9@_-]*)*$")
 
-// Stores a Block Locator Digest compactly, up to 128 bits.
+// Stores a Block Locator Digest compactly, up to 256 bits.
 // Can be used as a map key.
 type BlockDigest struct {
 	H uint64
 	L uint64
+
+	// For 256-bit digests, Wide is true and the low-order 128
+	// bits are stored in H2 and L2.
+	H2   uint64
+	L2   uint64
+	Wide bool
 }
 
 type DigestWithSize struct {
@@ -34,6 +40,9 @@ type BlockLocator struct {
 }
 
 func (d BlockDigest) String() string {
+	if d.Wide {
+		return fmt.Sprintf("%016x%016x%016x%016x", d.H, d.L, d.H2, d.L2)
+	}
 	return fmt.Sprintf("%016x%016x", d.H, d.L)
 }
 
@@ -43,8 +52,8 @@ func (w DigestWithSize) String() string {
 
 // Will create a new BlockDigest unless an error is encountered.
 func FromString(s string) (dig BlockDigest, err error) {
-	if len(s) != 32 {
-		err = fmt.Errorf("Block digest should be exactly 32 characters but this one is %d: %s", len(s), s)
+	if len(s) != 32 && len(s) != 64 {
+		err = fmt.Errorf("Block digest should be exactly 32 or 64 characters but this one is %d: %s", len(s), s)
 		return
 	}
 
@@ -53,10 +62,21 @@ func FromString(s string) (dig BlockDigest, err error) {
 	if err != nil {
 		return
 	}
-	d.L, err = strconv.ParseUint(s[16:], 16, 64)
+	d.L, err = strconv.ParseUint(s[16:32], 16, 64)
 	if err != nil {
 		return
 	}
+	if len(s) == 64 {
+		d.Wide = true
+		d.H2, err = strconv.ParseUint(s[32:48], 16, 64)
+		if err != nil {
+			return
+		}
+		d.L2, err = strconv.ParseUint(s[48:], 16, 64)
+		if err != nil {
+			return
+		}
+	}
 	dig = d
 	return
 }
@@ -83,6 +83,12 @@ func TestValidDigestStrings(t *testing.T) {
 	expectValidDigestString(t, "01234567890123456789AbCdEfaBcDeF")
 }
 
+func TestValidSHA256DigestStrings(t *testing.T) {
+	expectValidDigestString(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae")
+	expectValidDigestString(t, "2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE")
+	expectValidDigestString(t, "0000000000000000000000000000000000000000000000000000000000000000")
+}
+
 func TestInvalidDigestStrings(t *testing.T) {
 	expectInvalidDigestString(t, "01234567890123456789abcdefabcdeg")
 	expectInvalidDigestString(t, "01234567890123456789abcdefabcde")
@@ -90,6 +96,26 @@ func TestInvalidDigestStrings(t *testing.T) {
 	expectInvalidDigestString(t, "g1234567890123456789abcdefabcdef")
 }
 
+func TestInvalidSHA256DigestStrings(t *testing.T) {
+	expectInvalidDigestString(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ag")
+	expectInvalidDigestString(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7a")
+	expectInvalidDigestString(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7aee")
+}
+
+func TestMD5AndSHA256DigestsAreDistinct(t *testing.T) {
+	md5, err := FromString("00000000000000000000000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256, err := FromString("0000000000000000000000000000000000000000000000000000000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5 == sha256 {
+		t.Fatalf("Expected %v != %v", md5, sha256)
+	}
+}
+
 func TestBlockDigestWorksAsMapKey(t *testing.T) {
 	m := make(map[BlockDigest]int)
 	bd, err := FromString("01234567890123456789abcdefabcdef")
@@ -151,6 +177,9 @@ func TestLocatorPatternBasic(t *testing.T) {
 	expectLocatorPatternMatch(t, "12345678901234567890123456789012+12345+A1+B")
 	expectLocatorPatternMatch(t, "12345678901234567890123456789012+12345+A+B2")
 
+	expectLocatorPatternMatch(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3")
+	expectLocatorPatternMatch(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3+A1+B2")
+
 	expectLocatorPatternFail(t, "12345678901234567890123456789012")
 	expectLocatorPatternFail(t, "12345678901234567890123456789012+")
 	expectLocatorPatternFail(t, "12345678901234567890123456789012+12345+")
@@ -162,6 +191,8 @@ func TestLocatorPatternBasic(t *testing.T) {
 	expectLocatorPatternFail(t, "12345678901234567890123456789012+12345+1A")
 	expectLocatorPatternFail(t, "12345678901234567890123456789012+12345+a1")
 	expectLocatorPatternFail(t, "12345678901234567890123456789012+12345+A1+")
+	expectLocatorPatternFail(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7a+3")
+	expectLocatorPatternFail(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae0+3")
 
 }
 
@@ -179,3 +210,36 @@ func TestParseBlockLocatorSimple(t *testing.T) {
 		Hints: []string{"K@qr1hi",
 			"Af0c9a66381f3b028677411926f0be1c6282fe67c@542b5ddf"}})
 }
+
+func TestHashAlgorithmFor(t *testing.T) {
+	expectEqual(t, HashAlgorithmFor("acbd18db4cc2f85cedef654fccc4a4d8"), MD5)
+	expectEqual(t, HashAlgorithmFor("acbd18db4cc2f85cedef654fccc4a4d8+3+Afoo@bar"), MD5)
+	expectEqual(t, HashAlgorithmFor("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"), SHA256)
+	expectEqual(t, HashAlgorithmFor("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3"), SHA256)
+	expectEqual(t, HashAlgorithmFor("acbd18db4cc2f85cedef654fccc4a4d+3"), (*HashAlgorithm)(nil))
+	expectEqual(t, HashAlgorithmFor(""), (*HashAlgorithm)(nil))
+}
+
+func TestHashAlgorithmByName(t *testing.T) {
+	for name, expect := range map[string]*HashAlgorithm{
+		"":       MD5,
+		"md5":    MD5,
+		"sha256": SHA256,
+		"SHA256": SHA256,
+	} {
+		alg, err := HashAlgorithmByName(name)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		expectEqual(t, alg, expect)
+	}
+	if _, err := HashAlgorithmByName("sha1"); err == nil {
+		t.Fatal("Expected error for unsupported algorithm sha1")
+	}
+}
+
+func TestHashAlgorithmSum(t *testing.T) {
+	expectEqual(t, MD5.Sum([]byte("foo")), "acbd18db4cc2f85cedef654fccc4a4d8")
+	expectEqual(t, SHA256.Sum([]byte("foo")), "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae")
+	expectEqual(t, SHA256.Sum(nil), "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+}
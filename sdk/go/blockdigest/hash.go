@@ -0,0 +1,101 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package blockdigest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// HashAlgorithm is a hash function that can be used to compute the
+// hash part of a block locator.
+//
+// The algorithm used to compute a given locator is identified by the
+// length of its hash part, so each algorithm must have a distinct
+// HexLen.
+type HashAlgorithm struct {
+	// Name used in configs and command line flags, e.g., "md5".
+	Name string
+	// Length of the hex-encoded digest.
+	HexLen int
+	// Function that returns a new hash.Hash.
+	New func() hash.Hash
+}
+
+var (
+	MD5    = &HashAlgorithm{Name: "md5", HexLen: md5.Size * 2, New: md5.New}
+	SHA256 = &HashAlgorithm{Name: "sha256", HexLen: sha256.Size * 2, New: sha256.New}
+
+	// HashAlgorithms lists all supported algorithms. MD5 is the
+	// default.
+	HashAlgorithms = []*HashAlgorithm{MD5, SHA256}
+)
+
+// HashPattern is a regular expression (without anchors) that
+// matches the hash part of a locator using any supported algorithm.
+var HashPattern = hashPattern("0-9a-f")
+
+func hashPattern(charclass string) string {
+	var alts []string
+	for _, alg := range HashAlgorithms {
+		alts = append(alts, fmt.Sprintf("[%s]{%d}", charclass, alg.HexLen))
+	}
+	return "(?:" + strings.Join(alts, "|") + ")"
+}
+
+// Sum returns the hex-encoded digest of the given data.
+func (alg *HashAlgorithm) Sum(data []byte) string {
+	h := alg.New()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// String implements fmt.Stringer.
+func (alg *HashAlgorithm) String() string {
+	return alg.Name
+}
+
+// HashAlgorithmByName returns the algorithm with the given name. An
+// empty name means the default (MD5).
+func HashAlgorithmByName(name string) (*HashAlgorithm, error) {
+	if name == "" {
+		return MD5, nil
+	}
+	for _, alg := range HashAlgorithms {
+		if alg.Name == strings.ToLower(name) {
+			return alg, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported block hash algorithm %q", name)
+}
+
+// HashAlgorithmFor returns the algorithm that was used to compute
+// the given locator, which can be a bare hash or a locator with
+// size and hints. It returns nil if the hash part does not have the
+// length of any supported algorithm.
+//
+// HashAlgorithmFor does not check that the hash part consists of
+// hex digits.
+func HashAlgorithmFor(locator string) *HashAlgorithm {
+	hexlen := len(HashPart(locator))
+	for _, alg := range HashAlgorithms {
+		if alg.HexLen == hexlen {
+			return alg
+		}
+	}
+	return nil
+}
+
+// HashPart returns the hash part of the given locator, i.e., the
+// part before the first "+".
+func HashPart(locator string) string {
+	if i := strings.IndexByte(locator, '+'); i >= 0 {
+		return locator[:i]
+	}
+	return locator
+}
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 )
 
 var DefaultBlockCache = &BlockCache{}
@@ -20,6 +22,17 @@ type BlockCache struct {
 	// default size (currently 4) is used instead.
 	MaxBlocks int
 
+	// Number of upcoming blocks to retrieve in the background
+	// when a file is being read sequentially (see
+	// PrefetchBlock). If 0, there is no readahead. Prefetched
+	// blocks count toward MaxBlocks, so MaxBlocks should be
+	// greater than Readahead.
+	Readahead int
+
+	// If not nil, blocks are also stored in (and retrieved from)
+	// a persistent cache on local disk.
+	Disk *DiskCache
+
 	cache map[string]*cacheBlock
 	mtx   sync.Mutex
 }
@@ -33,6 +46,11 @@ func (c *BlockCache) Sweep() {
 	if max == 0 {
 		max = defaultMaxBlocks
 	}
+	if max <= c.Readahead {
+		// Don't evict prefetched blocks before they are
+		// used.
+		max = c.Readahead + 1
+	}
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if len(c.cache) <= max {
@@ -67,7 +85,30 @@ func (c *BlockCache) ReadAt(kc *KeepClient, locator string, p []byte, off int) (
 // Get returns data from the cache, first retrieving it from Keep if
 // necessary.
 func (c *BlockCache) Get(kc *KeepClient, locator string) ([]byte, error) {
-	cacheKey := locator[:32]
+	b := c.get(kc, locator)
+
+	// Wait (with mtx unlocked) for the fetch goroutine to finish,
+	// in case it hasn't already.
+	<-b.fetched
+
+	c.mtx.Lock()
+	b.lastUse = time.Now()
+	c.mtx.Unlock()
+	return b.data, b.err
+}
+
+// Prefetch starts retrieving the given block in the background, if
+// it is not already in the cache, and returns without waiting for
+// it.
+func (c *BlockCache) Prefetch(kc *KeepClient, locator string) {
+	c.get(kc, locator)
+}
+
+// get returns the cache entry for the given block, starting a fetch
+// goroutine if needed. The caller must wait for b.fetched before
+// using b.data or b.err.
+func (c *BlockCache) get(kc *KeepClient, locator string) *cacheBlock {
+	cacheKey := blockdigest.HashPart(locator)
 	bufsize := BLOCKSIZE
 	if parts := strings.SplitN(locator, "+", 3); len(parts) >= 2 {
 		datasize, err := strconv.ParseInt(parts[1], 10, 32)
@@ -76,6 +117,7 @@ func (c *BlockCache) Get(kc *KeepClient, locator string) ([]byte, error) {
 		}
 	}
 	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	if c.cache == nil {
 		c.cache = make(map[string]*cacheBlock)
 	}
@@ -87,16 +129,7 @@ func (c *BlockCache) Get(kc *KeepClient, locator string) ([]byte, error) {
 		}
 		c.cache[cacheKey] = b
 		go func() {
-			rdr, size, _, err := kc.Get(locator)
-			var data []byte
-			if err == nil {
-				data = make([]byte, size, bufsize)
-				_, err = io.ReadFull(rdr, data)
-				err2 := rdr.Close()
-				if err == nil {
-					err = err2
-				}
-			}
+			data, err := c.fetch(kc, locator, cacheKey, bufsize)
 			c.mtx.Lock()
 			b.data, b.err = data, err
 			c.mtx.Unlock()
@@ -104,16 +137,36 @@ func (c *BlockCache) Get(kc *KeepClient, locator string) ([]byte, error) {
 			go c.Sweep()
 		}()
 	}
-	c.mtx.Unlock()
-
-	// Wait (with mtx unlocked) for the fetch goroutine to finish,
-	// in case it hasn't already.
-	<-b.fetched
+	return b
+}
 
-	c.mtx.Lock()
-	b.lastUse = time.Now()
-	c.mtx.Unlock()
-	return b.data, b.err
+// fetch retrieves a block from the disk cache (if enabled) or from
+// Keep.
+func (c *BlockCache) fetch(kc *KeepClient, locator, hash string, bufsize int) ([]byte, error) {
+	if c.Disk != nil {
+		if data := c.Disk.get(hash); data != nil {
+			return data, nil
+		}
+	}
+	rdr, size, _, err := kc.Get(locator)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size, bufsize)
+	_, err = io.ReadFull(rdr, data)
+	err2 := rdr.Close()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Disk != nil {
+		// Errors writing to the disk cache are not fatal:
+		// the caller gets the data either way.
+		c.Disk.put(hash, data)
+	}
+	return data, nil
 }
 
 func (c *BlockCache) Clear() {
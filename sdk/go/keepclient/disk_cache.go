@@ -0,0 +1,178 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package keepclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
+)
+
+// DiskCache is a persistent block cache in a local directory. The
+// same directory can be shared by multiple processes, e.g.,
+// consecutive or concurrent FUSE mounts.
+//
+// Each block is stored in a file named after its hash. When the
+// total size of the cached blocks exceeds MaxSize, the least
+// recently used blocks (according to file modification times) are
+// deleted.
+//
+// Cached data is verified against the block hash when it is read,
+// so a corrupt or truncated cache file is treated as a cache miss.
+// Cached blocks are not subject to permission checks, so the
+// directory should be accessible only to the user who populated it.
+type DiskCache struct {
+	Dir string
+
+	// Maximum total size of cached blocks, in bytes. If 0, a
+	// default size (currently 10 GiB) is used instead.
+	MaxSize int64
+
+	mtx          sync.Mutex
+	sizeEstimate int64
+	lastTidy     time.Time
+	tidying      bool
+}
+
+const (
+	defaultDiskCacheMaxSize = 10 << 30
+
+	// Re-scan the cache directory at least this often while
+	// writing blocks, to account for blocks written by other
+	// processes.
+	diskCacheTidyInterval = time.Minute
+
+	// Delete temp files left behind by crashed processes after
+	// this long.
+	diskCacheTmpMaxAge = time.Hour
+)
+
+func (dc *DiskCache) maxSize() int64 {
+	if dc.MaxSize > 0 {
+		return dc.MaxSize
+	}
+	return defaultDiskCacheMaxSize
+}
+
+func (dc *DiskCache) blockPath(hash string) string {
+	return filepath.Join(dc.Dir, hash[:3], hash)
+}
+
+// get returns the data for the block with the given hash, or nil if
+// the block is not in the cache.
+func (dc *DiskCache) get(hash string) []byte {
+	alg := blockdigest.HashAlgorithmFor(hash)
+	if alg == nil {
+		return nil
+	}
+	fnm := dc.blockPath(hash)
+	data, err := ioutil.ReadFile(fnm)
+	if err != nil {
+		return nil
+	}
+	if alg.Sum(data) != hash {
+		os.Remove(fnm)
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(fnm, now, now)
+	return data
+}
+
+// put stores the given block in the cache, and starts a background
+// goroutine to delete old blocks if needed.
+func (dc *DiskCache) put(hash string, data []byte) error {
+	if blockdigest.HashAlgorithmFor(hash) == nil {
+		return nil
+	}
+	fnm := dc.blockPath(hash)
+	err := os.MkdirAll(filepath.Dir(fnm), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fnm), hash+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), fnm)
+	if err != nil {
+		return err
+	}
+
+	dc.mtx.Lock()
+	defer dc.mtx.Unlock()
+	dc.sizeEstimate += int64(len(data))
+	if !dc.tidying && (dc.sizeEstimate > dc.maxSize() || time.Since(dc.lastTidy) > diskCacheTidyInterval) {
+		dc.tidying = true
+		go dc.tidy()
+	}
+	return nil
+}
+
+// tidy deletes the least recently used blocks until the total size
+// of the cache is no more than MaxSize.
+func (dc *DiskCache) tidy() {
+	type entry struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var ents []entry
+	var total int64
+	filepath.Walk(dc.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		// Only touch files that look like ours, in case Dir
+		// is shared with other data.
+		hash := strings.SplitN(fi.Name(), ".tmp", 2)[0]
+		if blockdigest.HashAlgorithmFor(hash) == nil || filepath.Base(filepath.Dir(path)) != hash[:3] {
+			return nil
+		}
+		if hash != fi.Name() {
+			if time.Since(fi.ModTime()) > diskCacheTmpMaxAge {
+				os.Remove(path)
+			}
+			return nil
+		}
+		ents = append(ents, entry{path: path, size: fi.Size(), mtime: fi.ModTime()})
+		total += fi.Size()
+		return nil
+	})
+	max := dc.maxSize()
+	if total > max {
+		sort.Slice(ents, func(i, j int) bool { return ents[i].mtime.Before(ents[j].mtime) })
+		for _, ent := range ents {
+			if total <= max {
+				break
+			}
+			err := os.Remove(ent.path)
+			if err == nil || os.IsNotExist(err) {
+				total -= ent.size
+			}
+		}
+	}
+	dc.mtx.Lock()
+	dc.sizeEstimate = total
+	dc.lastTidy = time.Now()
+	dc.tidying = false
+	dc.mtx.Unlock()
+}
@@ -0,0 +1,184 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package keepclient
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&DiskCacheSuite{})
+
+type DiskCacheSuite struct {
+	srv    *httptest.Server
+	mtx    sync.Mutex
+	blocks map[string][]byte
+	gets   int
+}
+
+func (s *DiskCacheSuite) SetUpTest(c *C) {
+	s.blocks = map[string][]byte{}
+	s.gets = 0
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.gets++
+		data, ok := s.blocks[req.URL.Path[1:33]]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+}
+
+func (s *DiskCacheSuite) TearDownTest(c *C) {
+	s.srv.Close()
+}
+
+func (s *DiskCacheSuite) keepClient() *KeepClient {
+	kc := &KeepClient{
+		Arvados: &arvadosclient.ArvadosClient{ApiToken: "xyzzy"},
+	}
+	kc.SetServiceRoots(map[string]string{"zzzzz-bi6l4-000000000000000": s.srv.URL}, nil, nil)
+	return kc
+}
+
+func (s *DiskCacheSuite) addBlock(data string) string {
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(data)))
+	s.mtx.Lock()
+	s.blocks[hash] = []byte(data)
+	s.mtx.Unlock()
+	return fmt.Sprintf("%s+%d", hash, len(data))
+}
+
+func (s *DiskCacheSuite) getCount() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.gets
+}
+
+func (s *DiskCacheSuite) TestGetPut(c *C) {
+	dc := &DiskCache{Dir: c.MkDir()}
+	hash := fmt.Sprintf("%x", md5.Sum([]byte("foo")))
+	c.Check(dc.get(hash), IsNil)
+	c.Check(dc.put(hash, []byte("foo")), IsNil)
+	c.Check(string(dc.get(hash)), Equals, "foo")
+
+	// Corrupt cache file is treated as a miss, and deleted.
+	fnm := dc.blockPath(hash)
+	c.Check(ioutil.WriteFile(fnm, []byte("bar"), 0600), IsNil)
+	c.Check(dc.get(hash), IsNil)
+	_, err := os.Stat(fnm)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// Unsupported hash is ignored.
+	c.Check(dc.put("abc", []byte("foo")), IsNil)
+	c.Check(dc.get("abc"), IsNil)
+}
+
+func (s *DiskCacheSuite) TestTidy(c *C) {
+	dc := &DiskCache{Dir: c.MkDir(), MaxSize: 10}
+	var hashes []string
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("%04d", i))
+		hash := fmt.Sprintf("%x", md5.Sum(data))
+		hashes = append(hashes, hash)
+		c.Assert(dc.put(hash, data), IsNil)
+		// Give each file a distinct mtime, oldest first.
+		t := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(dc.blockPath(hash), t, t)
+	}
+	// Using a block makes it the most recently used.
+	c.Check(dc.get(hashes[0]), NotNil)
+	// Leftover temp file from a crashed process.
+	tmpfile := dc.blockPath(hashes[1]) + ".tmp123"
+	c.Assert(ioutil.WriteFile(tmpfile, []byte("x"), 0600), IsNil)
+	old := time.Now().Add(-2 * diskCacheTmpMaxAge)
+	os.Chtimes(tmpfile, old, old)
+	// Unrelated files are left alone.
+	otherfile := filepath.Join(dc.Dir, "README")
+	c.Assert(ioutil.WriteFile(otherfile, []byte("hello world"), 0600), IsNil)
+	os.Chtimes(otherfile, old, old)
+
+	dc.mtx.Lock()
+	dc.tidying = true
+	dc.mtx.Unlock()
+	dc.tidy()
+
+	var remaining []string
+	for _, hash := range hashes {
+		if _, err := os.Stat(dc.blockPath(hash)); err == nil {
+			remaining = append(remaining, hash)
+		}
+	}
+	c.Check(remaining, DeepEquals, []string{hashes[0], hashes[4]})
+	c.Check(dc.sizeEstimate, Equals, int64(8))
+	_, err := os.Stat(tmpfile)
+	c.Check(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(otherfile)
+	c.Check(err, IsNil)
+}
+
+func (s *DiskCacheSuite) TestBlockCacheUsesDiskCache(c *C) {
+	dir := c.MkDir()
+	locator := s.addBlock("foo")
+	kc := s.keepClient()
+	kc.BlockCache = &BlockCache{Disk: &DiskCache{Dir: dir}}
+	buf, err := kc.BlockCache.Get(kc, locator)
+	c.Check(err, IsNil)
+	c.Check(string(buf), Equals, "foo")
+	c.Check(s.getCount(), Equals, 1)
+
+	// A new BlockCache using the same directory (e.g., in a
+	// different process) doesn't need to fetch the block again.
+	kc.BlockCache = &BlockCache{Disk: &DiskCache{Dir: dir}}
+	buf, err = kc.BlockCache.Get(kc, locator)
+	c.Check(err, IsNil)
+	c.Check(string(buf), Equals, "foo")
+	c.Check(s.getCount(), Equals, 1)
+}
+
+func (s *DiskCacheSuite) TestPrefetch(c *C) {
+	locator := s.addBlock("foo")
+	kc := s.keepClient()
+	kc.BlockCache = &BlockCache{Readahead: 2}
+	c.Check(kc.ReadaheadBlocks(), Equals, 2)
+	kc.PrefetchBlock(locator)
+	for deadline := time.Now().Add(10 * time.Second); s.getCount() == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+	}
+	c.Check(s.getCount(), Equals, 1)
+	buf := make([]byte, 3)
+	n, err := kc.ReadAt(locator, buf, 0)
+	c.Check(err, IsNil)
+	c.Check(string(buf[:n]), Equals, "foo")
+	c.Check(s.getCount(), Equals, 1)
+
+	// Prefetched blocks aren't evicted before use.
+	var locators []string
+	for i := 0; i < 3; i++ {
+		locators = append(locators, s.addBlock(strings.Repeat("x", i+1)))
+	}
+	kc.BlockCache = &BlockCache{MaxBlocks: 1, Readahead: 2}
+	for _, loc := range locators {
+		kc.PrefetchBlock(loc)
+	}
+	for _, loc := range locators {
+		_, err := kc.BlockCache.Get(kc, loc)
+		c.Check(err, IsNil)
+	}
+	c.Check(s.getCount(), Equals, 4)
+}
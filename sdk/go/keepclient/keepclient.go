@@ -7,7 +7,6 @@ package keepclient
 
 import (
 	"bytes"
-	"crypto/md5"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +21,7 @@ import (
 
 	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
 	"git.arvados.org/arvados.git/sdk/go/asyncbuf"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"git.arvados.org/arvados.git/sdk/go/httpserver"
 )
 
@@ -103,6 +103,14 @@ type KeepClient struct {
 	RequestID          string
 	StorageClasses     []string
 
+	// Hash algorithm used to compute locators for new blocks
+	// written by PutB and PutR. New sets this according to the
+	// cluster's Collections.BlockHashAlgorithm config, as
+	// published in the discovery document. If nil, use MD5.
+	// Blocks are always verified using the algorithm indicated by
+	// their locators, regardless of this setting.
+	HashAlgorithm *blockdigest.HashAlgorithm
+
 	// set to 1 if all writable services are of disk type, otherwise 0
 	replicasPerService int
 
@@ -132,10 +140,18 @@ func New(arv *arvadosclient.ArvadosClient) *KeepClient {
 			defaultReplicationLevel = int(v)
 		}
 	}
+	var hashAlgorithm *blockdigest.HashAlgorithm
+	value, err = arv.Discovery("blockHashAlgorithm")
+	if err == nil {
+		if name, ok := value.(string); ok {
+			hashAlgorithm, _ = blockdigest.HashAlgorithmByName(name)
+		}
+	}
 	return &KeepClient{
 		Arvados:       arv,
 		Want_replicas: defaultReplicationLevel,
 		Retries:       2,
+		HashAlgorithm: hashAlgorithm,
 	}
 }
 
@@ -148,6 +164,10 @@ func New(arv *arvadosclient.ArvadosClient) *KeepClient {
 // Returns an InsufficientReplicasError if 0 <= replicas <
 // kc.Wants_replicas.
 func (kc *KeepClient) PutHR(hash string, r io.Reader, dataBytes int64) (string, int, error) {
+	alg := blockdigest.HashAlgorithmFor(hash)
+	if alg == nil {
+		return "", 0, InvalidLocatorError
+	}
 	// Buffer for reads from 'r'
 	var bufsize int
 	if dataBytes > 0 {
@@ -161,7 +181,7 @@ func (kc *KeepClient) PutHR(hash string, r io.Reader, dataBytes int64) (string,
 
 	buf := asyncbuf.NewBuffer(make([]byte, 0, bufsize))
 	go func() {
-		_, err := io.Copy(buf, HashCheckingReader{r, md5.New(), hash})
+		_, err := io.Copy(buf, HashCheckingReader{r, alg.New(), hash})
 		buf.CloseWithError(err)
 	}()
 	return kc.putReplicas(hash, buf.NewReader, dataBytes)
@@ -180,8 +200,14 @@ func (kc *KeepClient) PutHB(hash string, buf []byte) (string, int, error) {
 //
 // Return values are the same as for PutHR.
 func (kc *KeepClient) PutB(buffer []byte) (string, int, error) {
-	hash := fmt.Sprintf("%x", md5.Sum(buffer))
-	return kc.PutHB(hash, buffer)
+	return kc.PutHB(kc.hashAlgorithm().Sum(buffer), buffer)
+}
+
+func (kc *KeepClient) hashAlgorithm() *blockdigest.HashAlgorithm {
+	if kc.HashAlgorithm == nil {
+		return blockdigest.MD5
+	}
+	return kc.HashAlgorithm
 }
 
 // PutR writes a block to Keep. It first reads all data from r into a buffer
@@ -199,7 +225,11 @@ func (kc *KeepClient) PutR(r io.Reader) (locator string, replicas int, err error
 }
 
 func (kc *KeepClient) getOrHead(method string, locator string, header http.Header) (io.ReadCloser, int64, string, http.Header, error) {
-	if strings.HasPrefix(locator, "d41d8cd98f00b204e9800998ecf8427e+0") {
+	alg := blockdigest.HashAlgorithmFor(locator)
+	if alg == nil {
+		return nil, 0, "", nil, InvalidLocatorError
+	}
+	if strings.HasPrefix(locator, alg.Sum(nil)+"+0") {
 		return ioutil.NopCloser(bytes.NewReader(nil)), 0, "", nil, nil
 	}
 
@@ -287,8 +317,8 @@ func (kc *KeepClient) getOrHead(method string, locator string, header http.Heade
 			if method == "GET" {
 				return HashCheckingReader{
 					Reader: resp.Body,
-					Hash:   md5.New(),
-					Check:  locator[0:32],
+					Hash:   alg.New(),
+					Check:  blockdigest.HashPart(locator),
 				}, expectLength, url, resp.Header, nil
 			} else {
 				resp.Body.Close()
@@ -351,6 +381,20 @@ func (kc *KeepClient) ReadAt(locator string, p []byte, off int) (int, error) {
 	return kc.cache().ReadAt(kc, locator, p, off)
 }
 
+// ReadaheadBlocks returns the number of upcoming blocks that should
+// be prefetched when a file is being read sequentially. See
+// (*BlockCache)Readahead.
+func (kc *KeepClient) ReadaheadBlocks() int {
+	return kc.cache().Readahead
+}
+
+// PrefetchBlock starts retrieving the given block into the block
+// cache in the background, so a subsequent ReadAt does not have to
+// wait for it.
+func (kc *KeepClient) PrefetchBlock(locator string) {
+	kc.cache().Prefetch(kc, locator)
+}
+
 // Ask() verifies that a block with the given hash is available and
 // readable, according to at least one Keep service. Unlike Get, it
 // does not retrieve the data or verify that the data content matches
@@ -486,7 +530,7 @@ func (kc *KeepClient) getSortedRoots(locator string) []string {
 		}
 	}
 	// After trying all usable service hints, fall back to local roots.
-	found = append(found, NewRootSorter(kc.LocalRoots(), locator[0:32]).GetSortedRoots()...)
+	found = append(found, NewRootSorter(kc.LocalRoots(), blockdigest.HashPart(locator)).GetSortedRoots()...)
 	return found
 }
 
@@ -595,7 +639,7 @@ func (loc *Locator) String() string {
 	return s
 }
 
-var locatorMatcher = regexp.MustCompile("^([0-9a-f]{32})([+](.*))?$")
+var locatorMatcher = regexp.MustCompile("^(" + blockdigest.HashPattern + ")([+](.*))?$")
 
 func MakeLocator(path string) (*Locator, error) {
 	sm := locatorMatcher.FindStringSubmatch(path)
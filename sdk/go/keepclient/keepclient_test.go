@@ -7,6 +7,7 @@ package keepclient
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +22,7 @@ import (
 
 	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
 	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	. "gopkg.in/check.v1"
 )
 
@@ -94,6 +96,25 @@ func (s *ServerRequiredSuite) TestDefaultReplications(c *C) {
 	c.Assert(kc.Want_replicas, Equals, 1)
 }
 
+func (s *StandaloneSuite) TestHashAlgorithmFromDiscovery(c *C) {
+	for _, trial := range []struct {
+		name   interface{}
+		expect *blockdigest.HashAlgorithm
+	}{
+		{"sha256", blockdigest.SHA256},
+		{"md5", blockdigest.MD5},
+		{"bogus", nil},
+		{nil, nil},
+	} {
+		arv := &arvadosclient.ArvadosClient{DiscoveryDoc: arvadosclient.Dict{"defaultCollectionReplication": 2.0}}
+		if trial.name != nil {
+			arv.DiscoveryDoc["blockHashAlgorithm"] = trial.name
+		}
+		kc := New(arv)
+		c.Check(kc.HashAlgorithm, Equals, trial.expect, Commentf("%v", trial.name))
+	}
+}
+
 type StubPutHandler struct {
 	c                  *C
 	expectPath         string
@@ -807,6 +828,80 @@ func (s *StandaloneSuite) TestChecksum(c *C) {
 	<-st.handled
 }
 
+func (s *StandaloneSuite) TestChecksumSHA256(c *C) {
+	foohash := fmt.Sprintf("%x", sha256.Sum256([]byte("foo")))
+	barhash := fmt.Sprintf("%x", sha256.Sum256([]byte("bar")))
+
+	st := BarHandler{make(chan string, 1)}
+
+	ks := RunFakeKeepServer(st)
+	defer ks.listener.Close()
+
+	arv, err := arvadosclient.MakeArvadosClient()
+	c.Check(err, IsNil)
+	kc, _ := MakeKeepClient(arv)
+	arv.ApiToken = "abc123"
+	kc.SetServiceRoots(map[string]string{"x": ks.url}, nil, nil)
+
+	r, n, _, err := kc.Get(barhash + "+3")
+	c.Check(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Check(n, Equals, int64(3))
+	c.Check(err, Equals, nil)
+
+	<-st.handled
+
+	r, n, _, err = kc.Get(foohash + "+3")
+	c.Check(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Check(n, Equals, int64(3))
+	c.Check(err, Equals, BadChecksum)
+
+	<-st.handled
+}
+
+func (s *StandaloneSuite) TestGetEmptyBlockSHA256(c *C) {
+	arv, err := arvadosclient.MakeArvadosClient()
+	c.Check(err, IsNil)
+	kc, _ := MakeKeepClient(arv)
+	kc.SetServiceRoots(map[string]string{}, nil, nil)
+
+	r, n, _, err := kc.Get("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855+0")
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, int64(0))
+	content, err := ioutil.ReadAll(r)
+	c.Check(err, IsNil)
+	c.Check(content, HasLen, 0)
+}
+
+func (s *StandaloneSuite) TestPutBSHA256(c *C) {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("foo")))
+
+	st := StubPutHandler{
+		c,
+		hash,
+		"abc123",
+		"foo",
+		"",
+		make(chan string, 5)}
+
+	arv, _ := arvadosclient.MakeArvadosClient()
+	kc, _ := MakeKeepClient(arv)
+
+	kc.Want_replicas = 1
+	kc.HashAlgorithm = blockdigest.SHA256
+	arv.ApiToken = "abc123"
+
+	ks := RunFakeKeepServer(st)
+	defer ks.listener.Close()
+	kc.SetServiceRoots(map[string]string{"x": ks.url}, map[string]string{"x": ks.url}, nil)
+
+	_, replicas, err := kc.PutB([]byte("foo"))
+	<-st.handled
+	c.Check(err, IsNil)
+	c.Check(replicas, Equals, 1)
+}
+
 func (s *StandaloneSuite) TestGetWithFailures(c *C) {
 	content := []byte("waz")
 	hash := fmt.Sprintf("%x", md5.Sum(content))
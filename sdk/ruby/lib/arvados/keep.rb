@@ -10,7 +10,7 @@ module Keep
     #
     #   locator      ::= address hint*
     #   address      ::= digest size-hint
-    #   digest       ::= <32 hexadecimal digits>
+    #   digest       ::= <32 or 64 hexadecimal digits>
     #   size-hint    ::= "+" [0-9]+
     #   hint         ::= "+" hint-type hint-content
     #   hint-type    ::= [A-Z]
@@ -22,7 +22,7 @@ module Keep
     #   sign-timestamp ::= <8 lowercase hex digits>
     attr_reader :hash, :hints, :size
 
-    LOCATOR_REGEXP = /^([[:xdigit:]]{32})(\+([[:digit:]]+))?((\+([[:upper:]][[:alnum:]@_-]*))+)?\z/
+    LOCATOR_REGEXP = /^([[:xdigit:]]{64}|[[:xdigit:]]{32})(\+([[:digit:]]+))?((\+([[:upper:]][[:alnum:]@_-]*))+)?\z/
 
     def initialize(hasharg, sizearg, hintarg)
       @hash = hasharg
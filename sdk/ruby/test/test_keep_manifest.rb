@@ -279,6 +279,10 @@ class ManifestTest < Minitest::Test
    [true, 'd41d8cd98f00b204e9800998ecf8427e+Ad41d8cd98f00b204e9800998ecf8427e00000000+Foo', nil,nil,'+Ad41d8cd98f00b204e9800998ecf8427e00000000+Foo'],
    [true, 'd41d8cd98f00b204e9800998ecf8427e+0+Z', '+0','0','+Z'],
    [true, 'd41d8cd98f00b204e9800998ecf8427e+Z', nil,nil,'+Z'],
+   [true, 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855', nil,nil,nil],
+   [true, 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855+0+Z', '+0','0','+Z'],
+   [false, 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b85+0'],
+   [false, 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b8550+0'],
   ].each do |ok, locator, match2, match3, match4|
     define_method "test_LOCATOR_REGEXP_on_#{locator.inspect}" do
       match = Keep::Locator::LOCATOR_REGEXP.match locator
@@ -322,6 +326,7 @@ class ManifestTest < Minitest::Test
     [true, ". d41d8cd98f00b204e9800998ecf8427e+0 000000000000000000000000000000:0777:foo.txt\n"],
     [true, ". d41d8cd98f00b204e9800998ecf8427e+0 0:0:0:0\n"],
     [true, ". d41d8cd98f00b204e9800998ecf8427e+0 0:0:\\040\n"],
+    [true, ". e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855+0 0:0:empty.txt\n"],
     [true, ". 00000000000000000000000000000000+0 0:0:0\n"],
     [true, ". 00000000000000000000000000000000+0 0:0:d41d8cd98f00b204e9800998ecf8427e+0+Ad41d8cd98f00b204e9800998ecf8427e00000000@ffffffff\n"],
     [true, ". d41d8cd98f00b204e9800998ecf8427e+0+Ad41d8cd98f00b204e9800998ecf8427e00000000@ffffffff 0:0:empty.txt\n"],
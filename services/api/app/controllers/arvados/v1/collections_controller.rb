@@ -111,7 +111,7 @@ class Arvados::V1::CollectionsController < ApplicationController
         find_collections(visited, v, &b)
       end
     when String
-      if m = /[a-f0-9]{32}\+\d+/.match(sp)
+      if m = /([a-f0-9]{64}|[a-f0-9]{32})\+\d+/.match(sp)
         yield m[0], nil
       elsif m = Collection.uuid_regex.match(sp)
         yield nil, m[0]
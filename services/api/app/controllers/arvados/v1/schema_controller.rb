@@ -45,6 +45,7 @@ class Arvados::V1::SchemaController < ApplicationController
         description: "The API to interact with Arvados.",
         documentationLink: "http://doc.arvados.org/api/index.html",
         defaultCollectionReplication: Rails.configuration.Collections.DefaultReplication,
+        blockHashAlgorithm: Rails.configuration.Collections.BlockHashAlgorithm,
         protocol: "rest",
         baseUrl: root_url + "arvados/v1/",
         basePath: "/arvados/v1/",
@@ -66,6 +67,7 @@ class Arvados::V1::SchemaController < ApplicationController
         crunchLogPartialLineThrottlePeriod: Rails.configuration.Containers.Logging.LogPartialLineThrottlePeriod,
         crunchLogUpdatePeriod: Rails.configuration.Containers.Logging.LogUpdatePeriod,
         crunchLogUpdateSize: Rails.configuration.Containers.Logging.LogUpdateSize,
+        crunchStructuredLogs: Rails.configuration.Containers.Logging.StructuredLogs,
         remoteHosts: remoteHosts,
         remoteHostsViaDNS: Rails.configuration.RemoteClusters["*"].Proxy,
         websocketUrl: Rails.configuration.Services.Websocket.ExternalURL.to_s,
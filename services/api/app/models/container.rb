@@ -76,6 +76,7 @@ class Container < ArvadosModel
     t.add :runtime_user_uuid
     t.add :runtime_auth_scopes
     t.add :lock_count
+    t.add :cost
   end
 
   # Supported states for a container
@@ -514,6 +515,11 @@ class Container < ArvadosModel
       # dispatcher, even though it has admin privileges.
       permitted = permitted - progress_attrs
     end
+    if current_user.andand.is_admin
+      # The dispatcher records the cost of running the container,
+      # including after it has finished.
+      permitted.push :cost
+    end
     check_update_whitelist permitted
   end
 
@@ -394,6 +394,18 @@ class ContainerRequest < ArvadosModel
           scheduling_parameters['max_run_time'] < 0)
           errors.add :scheduling_parameters, "max_run_time must be positive integer"
       end
+      if scheduling_parameters.include? 'checkpoint_path'
+        ckpt = scheduling_parameters['checkpoint_path']
+        if !ckpt.is_a?(String) || !ckpt.start_with?('/')
+          errors.add :scheduling_parameters, "checkpoint_path must be an absolute path"
+        elsif (ckpt = File.expand_path(ckpt)) == '/'
+          errors.add :scheduling_parameters, "checkpoint_path cannot be the root directory"
+        elsif ckpt == output_path || ckpt.start_with?("#{output_path}/")
+          errors.add :scheduling_parameters, "checkpoint_path cannot be inside output_path"
+        elsif (mnt = (mounts || {}).keys.find { |m| m == ckpt || m.start_with?("#{ckpt}/") || ckpt.start_with?("#{m}/") })
+          errors.add :scheduling_parameters, "checkpoint_path conflicts with mount #{mnt}"
+        end
+      end
     end
   end
 
@@ -0,0 +1,9 @@
+# Copyright (C) The Arvados Authors. All rights reserved.
+#
+# SPDX-License-Identifier: AGPL-3.0
+
+class AddCostToContainers < ActiveRecord::Migration[5.0]
+  def change
+    add_column :containers, :cost, :float, :null => false, :default => 0
+  end
+end
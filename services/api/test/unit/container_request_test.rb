@@ -1052,6 +1052,12 @@ class ContainerRequestTest < ActiveSupport::TestCase
     [{"max_run_time" => -1}, ContainerRequest::Committed, ActiveRecord::RecordInvalid],
     [{"max_run_time" => -1}, ContainerRequest::Uncommitted],
     [{"max_run_time" => 86400}, ContainerRequest::Committed],
+    [{"checkpoint_path" => "/checkpoint"}, ContainerRequest::Committed],
+    [{"checkpoint_path" => "checkpoint"}, ContainerRequest::Committed, ActiveRecord::RecordInvalid],
+    [{"checkpoint_path" => "checkpoint"}, ContainerRequest::Uncommitted],
+    [{"checkpoint_path" => ["/checkpoint"]}, ContainerRequest::Committed, ActiveRecord::RecordInvalid],
+    [{"checkpoint_path" => "/"}, ContainerRequest::Committed, ActiveRecord::RecordInvalid],
+    [{"checkpoint_path" => "/checkpoint/.."}, ContainerRequest::Committed, ActiveRecord::RecordInvalid],
   ].each do |sp, state, expected|
     test "create container request with scheduling_parameters #{sp} in state #{state} and verify #{expected}" do
       common_attrs = {cwd: "test",
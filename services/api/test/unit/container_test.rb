@@ -223,6 +223,27 @@ class ContainerTest < ActiveSupport::TestCase
     end
   end
 
+  test "Container cost can be updated by admin in any state" do
+    c = containers(:completed)
+    assert_equal Container::Complete, c.state
+
+    set_user_from_auth :active
+    assert_raises ArvadosModel::PermissionDeniedError do
+      c.update_attributes! cost: 1.5
+    end
+
+    set_user_from_auth :dispatch1
+    c.reload
+    c.update_attributes! cost: 1.5
+    assert_equal 1.5, c.reload.cost
+
+    # Other attributes still can't be changed.
+    assert_raises ActiveRecord::RecordInvalid do
+      c.update_attributes! cost: 2.5, exit_code: 1
+    end
+    assert_equal 1.5, c.reload.cost
+  end
+
   test "Container serialized hash attributes sorted before save" do
     set_user_from_auth :active
     env = {"C" => "3", "B" => "2", "A" => "1"}
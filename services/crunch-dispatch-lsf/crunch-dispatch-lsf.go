@@ -0,0 +1,18 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+// Dispatcher service for Crunch that submits containers to an LSF
+// cluster.
+
+import (
+	"os"
+
+	"git.arvados.org/arvados.git/lib/batchdispatch"
+)
+
+func main() {
+	os.Exit(batchdispatch.Command("crunch-dispatch-lsf", newLSFBackend).RunCommand(os.Args[0], os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
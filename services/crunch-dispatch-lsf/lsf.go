@@ -0,0 +1,134 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/batchdispatch"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+)
+
+// lsfBackend submits and manages LSF jobs using the bsub, bjobs,
+// bkill, and bmod commands.
+type lsfBackend struct {
+	cluster *arvados.Cluster
+	cli     *batchdispatch.CLI
+}
+
+func newLSFBackend(cluster *arvados.Cluster, logger logrus.FieldLogger) (batchdispatch.Backend, error) {
+	return &lsfBackend{
+		cluster: cluster,
+		cli:     batchdispatch.NewCLI(logger),
+	}, nil
+}
+
+func (b *lsfBackend) bsubArgs(ctr arvados.Container) []string {
+	res := batchdispatch.ContainerResources(b.cluster, ctr)
+	args := append([]string(nil), b.cluster.Containers.LSF.BsubArgumentsList...)
+	args = append(args,
+		"-J", ctr.UUID,
+		"-n", fmt.Sprintf("%d", res.VCPUs),
+		"-R", fmt.Sprintf("rusage[mem=%dMB:tmp=%dMB] span[hosts=1]", res.RAMMiB, res.ScratchMiB))
+	if lowest, highest := b.PriorityRange(); lowest < highest {
+		args = append(args, "-sp", fmt.Sprintf("%d", lowest))
+	}
+	if len(ctr.SchedulingParameters.Partitions) > 0 {
+		// bsub accepts a space-separated list of queues.
+		args = append(args, "-q", strings.Join(ctr.SchedulingParameters.Partitions, " "))
+	}
+	return args
+}
+
+// Submit submits a job using bsub. The job script is passed on
+// stdin.
+func (b *lsfBackend) Submit(ctr arvados.Container, script string) error {
+	_, err := b.cli.Run(strings.NewReader(script), "bsub", b.bsubArgs(ctr)...)
+	return err
+}
+
+type bjobsOutput struct {
+	Records []struct {
+		JobID       string `json:"JOBID"`
+		Stat        string `json:"STAT"`
+		JobName     string `json:"JOB_NAME"`
+		JobPriority string `json:"JOB_PRIORITY"`
+		Error       string `json:"ERROR"`
+	} `json:"RECORDS"`
+}
+
+// Queue returns all unfinished jobs, as reported by bjobs.
+func (b *lsfBackend) Queue() ([]batchdispatch.Job, error) {
+	out, err := b.cli.Run(nil, "bjobs", "-u", "all", "-o", "jobid stat job_name job_priority", "-json")
+	if err != nil {
+		if strings.Contains(err.Error(), "No unfinished job found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var resp bjobsOutput
+	err = json.Unmarshal(out, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bjobs output: %s", err)
+	}
+	var jobs []batchdispatch.Job
+	for _, rec := range resp.Records {
+		if rec.Error != "" || rec.JobID == "" {
+			continue
+		}
+		switch rec.Stat {
+		case "DONE", "EXIT":
+			// Finished jobs stay in bjobs output for a
+			// while (see CLEAN_PERIOD in lsb.params).
+			continue
+		}
+		// JOB_PRIORITY is empty if no user priority was
+		// assigned.
+		prio, _ := strconv.ParseInt(rec.JobPriority, 10, 64)
+		jobs = append(jobs, batchdispatch.Job{
+			ID:       rec.JobID,
+			Name:     rec.JobName,
+			Priority: prio,
+			Pending:  rec.Stat == "PEND" || rec.Stat == "PSUSP",
+		})
+	}
+	return jobs, nil
+}
+
+// Cancel kills a job using bkill. A running job is sent SIGTERM so
+// crunch-run can stop the container and clean up; by default, bkill
+// would follow up with SIGKILL after JOB_TERMINATE_INTERVAL.
+func (b *lsfBackend) Cancel(job batchdispatch.Job) error {
+	args := []string{job.ID}
+	if !job.Pending {
+		args = []string{"-s", "TERM", job.ID}
+	}
+	_, err := b.cli.Run(nil, "bkill", args...)
+	if err != nil && strings.Contains(err.Error(), "Job has already finished") {
+		return nil
+	}
+	return err
+}
+
+// SetPriority sets a job's user-assigned priority using bmod -sp.
+func (b *lsfBackend) SetPriority(job batchdispatch.Job, priority int64) error {
+	_, err := b.cli.Run(nil, "bmod", "-sp", fmt.Sprintf("%d", priority), job.ID)
+	return err
+}
+
+// PriorityRange returns the range of user-assigned priorities to
+// use. LSF only allows user-assigned priorities if MAX_USER_PRIORITY
+// is configured, so priority adjustment is disabled unless
+// Containers.LSF.MaxUserPriority is set.
+func (b *lsfBackend) PriorityRange() (int64, int64) {
+	if max := b.cluster.Containers.LSF.MaxUserPriority; max > 1 {
+		return 1, max
+	}
+	return 0, 0
+}
@@ -0,0 +1,184 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"git.arvados.org/arvados.git/lib/batchdispatch"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	. "gopkg.in/check.v1"
+)
+
+// Gocheck boilerplate
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&LSFSuite{})
+
+type LSFSuite struct {
+	stubdir string
+	oldPath string
+	cluster *arvados.Cluster
+	backend batchdispatch.Backend
+}
+
+// Each stub command logs its arguments to {stubdir}/calls, saves
+// its stdin (bsub only), and writes {stubdir}/{cmd}.stdout and
+// {cmd}.stderr to stdout and stderr, exiting with the status code
+// in {cmd}.exit.
+const stubScript = `#!/bin/sh
+dir=$(dirname "$0")
+name=$(basename "$0")
+echo "$name $*" >>"$dir/calls"
+if [ "$name" = bsub ]; then cat >"$dir/bsub.stdin"; fi
+if [ -e "$dir/$name.stdout" ]; then cat "$dir/$name.stdout"; fi
+if [ -e "$dir/$name.stderr" ]; then cat "$dir/$name.stderr" >&2; fi
+if [ -e "$dir/$name.exit" ]; then exit $(cat "$dir/$name.exit"); fi
+`
+
+func (s *LSFSuite) SetUpTest(c *C) {
+	s.stubdir = c.MkDir()
+	for _, cmd := range []string{"bsub", "bjobs", "bkill", "bmod"} {
+		err := ioutil.WriteFile(s.stubdir+"/"+cmd, []byte(stubScript), 0755)
+		c.Assert(err, IsNil)
+	}
+	s.oldPath = os.Getenv("PATH")
+	os.Setenv("PATH", s.stubdir+":"+s.oldPath)
+
+	s.cluster = &arvados.Cluster{}
+	s.cluster.Containers.LSF.BsubArgumentsList = []string{"-o", "/tmp/crunch-run.%J.out"}
+	s.cluster.Containers.LSF.MaxUserPriority = 100
+	s.cluster.Containers.ReserveExtraRAM = 256 << 20
+	var err error
+	s.backend, err = newLSFBackend(s.cluster, ctxlog.TestLogger(c))
+	c.Assert(err, IsNil)
+}
+
+func (s *LSFSuite) TearDownTest(c *C) {
+	os.Setenv("PATH", s.oldPath)
+}
+
+func (s *LSFSuite) stub(c *C, cmd, stdout, stderr, exit string) {
+	for suffix, content := range map[string]string{".stdout": stdout, ".stderr": stderr, ".exit": exit} {
+		err := ioutil.WriteFile(s.stubdir+"/"+cmd+suffix, []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *LSFSuite) calls(c *C) []string {
+	buf, err := ioutil.ReadFile(s.stubdir + "/calls")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	c.Assert(err, IsNil)
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+}
+
+func (s *LSFSuite) TestSubmit(c *C) {
+	ctr := arvados.Container{
+		UUID: "zzzzz-dz642-queuedcontainer",
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs:        4,
+			RAM:          11 << 30,
+			KeepCacheRAM: 256 << 20,
+		},
+		SchedulingParameters: arvados.SchedulingParameters{
+			Partitions: []string{"short", "long"},
+		},
+	}
+	err := s.backend.Submit(ctr, "#!/bin/sh\nexec 'crunch-run' 'zzzzz-dz642-queuedcontainer'\n")
+	c.Assert(err, IsNil)
+	c.Check(s.calls(c), DeepEquals, []string{
+		"bsub -o /tmp/crunch-run.%J.out -J zzzzz-dz642-queuedcontainer -n 4 -R rusage[mem=11776MB:tmp=0MB] span[hosts=1] -sp 1 -q short long",
+	})
+	stdin, err := ioutil.ReadFile(s.stubdir + "/bsub.stdin")
+	c.Assert(err, IsNil)
+	c.Check(string(stdin), Equals, "#!/bin/sh\nexec 'crunch-run' 'zzzzz-dz642-queuedcontainer'\n")
+}
+
+func (s *LSFSuite) TestSubmitNoPriority(c *C) {
+	s.cluster.Containers.LSF.MaxUserPriority = 0
+	err := s.backend.Submit(arvados.Container{UUID: "zzzzz-dz642-queuedcontainer"}, "")
+	c.Assert(err, IsNil)
+	c.Check(s.calls(c), DeepEquals, []string{
+		"bsub -o /tmp/crunch-run.%J.out -J zzzzz-dz642-queuedcontainer -n 0 -R rusage[mem=256MB:tmp=0MB] span[hosts=1]",
+	})
+	lowest, highest := s.backend.PriorityRange()
+	c.Check(lowest < highest, Equals, false)
+}
+
+func (s *LSFSuite) TestSubmitFail(c *C) {
+	s.stub(c, "bsub", "", "Bad resource requirement syntax. Job not submitted.\n", "255")
+	err := s.backend.Submit(arvados.Container{UUID: "zzzzz-dz642-queuedcontainer"}, "")
+	c.Check(err, ErrorMatches, `.*Bad resource requirement syntax.*`)
+}
+
+func (s *LSFSuite) TestQueue(c *C) {
+	s.stub(c, "bjobs", `{
+  "COMMAND":"bjobs",
+  "JOBS":5,
+  "RECORDS":[
+    {"JOBID":"101","STAT":"RUN","JOB_NAME":"zzzzz-dz642-fake0fake0fake0","JOB_PRIORITY":"7"},
+    {"JOBID":"102","STAT":"PEND","JOB_NAME":"zzzzz-dz642-fake1fake1fake1","JOB_PRIORITY":"1"},
+    {"JOBID":"103","STAT":"PEND","JOB_NAME":"someone else's job","JOB_PRIORITY":""},
+    {"JOBID":"104","STAT":"DONE","JOB_NAME":"zzzzz-dz642-fake2fake2fake2","JOB_PRIORITY":"1"},
+    {"JOBID":"105","STAT":"EXIT","JOB_NAME":"zzzzz-dz642-fake3fake3fake3","JOB_PRIORITY":"1"}
+  ]
+}`, "", "0")
+	jobs, err := s.backend.Queue()
+	c.Assert(err, IsNil)
+	c.Check(jobs, DeepEquals, []batchdispatch.Job{
+		{ID: "101", Name: "zzzzz-dz642-fake0fake0fake0", Priority: 7, Pending: false},
+		{ID: "102", Name: "zzzzz-dz642-fake1fake1fake1", Priority: 1, Pending: true},
+		{ID: "103", Name: "someone else's job", Priority: 0, Pending: true},
+	})
+	c.Check(s.calls(c), DeepEquals, []string{"bjobs -u all -o jobid stat job_name job_priority -json"})
+}
+
+func (s *LSFSuite) TestQueueEmpty(c *C) {
+	s.stub(c, "bjobs", "", "No unfinished job found\n", "255")
+	jobs, err := s.backend.Queue()
+	c.Check(err, IsNil)
+	c.Check(jobs, HasLen, 0)
+}
+
+func (s *LSFSuite) TestQueueError(c *C) {
+	s.stub(c, "bjobs", "", "LSF is down. Please wait ...\n", "255")
+	_, err := s.backend.Queue()
+	c.Check(err, ErrorMatches, `.*LSF is down.*`)
+
+	s.stub(c, "bjobs", "this is not json", "", "0")
+	_, err = s.backend.Queue()
+	c.Check(err, ErrorMatches, `error parsing bjobs output: .*`)
+}
+
+func (s *LSFSuite) TestCancel(c *C) {
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "101", Pending: true}), IsNil)
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "102", Pending: false}), IsNil)
+	s.stub(c, "bkill", "", "Job <103>: Job has already finished\n", "255")
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "103", Pending: false}), IsNil)
+	s.stub(c, "bkill", "", "Job <104>: No matching job found\n", "255")
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "104", Pending: false}), ErrorMatches, `.*No matching job found.*`)
+	c.Check(s.calls(c), DeepEquals, []string{
+		"bkill 101",
+		"bkill -s TERM 102",
+		"bkill -s TERM 103",
+		"bkill -s TERM 104",
+	})
+}
+
+func (s *LSFSuite) TestSetPriority(c *C) {
+	c.Check(s.backend.SetPriority(batchdispatch.Job{ID: "101"}, 42), IsNil)
+	c.Check(s.calls(c), DeepEquals, []string{"bmod -sp 42 101"})
+	lowest, highest := s.backend.PriorityRange()
+	c.Check(lowest, Equals, int64(1))
+	c.Check(highest, Equals, int64(100))
+}
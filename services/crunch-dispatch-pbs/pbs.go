@@ -0,0 +1,131 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/batchdispatch"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+)
+
+// pbsBackend submits and manages PBS Professional jobs using the
+// qsub, qstat, qdel, and qalter commands.
+type pbsBackend struct {
+	cluster *arvados.Cluster
+	cli     *batchdispatch.CLI
+	logger  logrus.FieldLogger
+}
+
+func newPBSBackend(cluster *arvados.Cluster, logger logrus.FieldLogger) (batchdispatch.Backend, error) {
+	cfg := cluster.Containers.PBS
+	if cfg.MinPriority > cfg.MaxPriority || cfg.MinPriority < -1024 || cfg.MaxPriority > 1023 {
+		return nil, fmt.Errorf("invalid PBS priority range %d..%d (must be within -1024..1023)", cfg.MinPriority, cfg.MaxPriority)
+	}
+	return &pbsBackend{
+		cluster: cluster,
+		cli:     batchdispatch.NewCLI(logger),
+		logger:  logger,
+	}, nil
+}
+
+func (b *pbsBackend) qsubArgs(ctr arvados.Container) []string {
+	res := batchdispatch.ContainerResources(b.cluster, ctr)
+	lowest, _ := b.PriorityRange()
+	args := append([]string(nil), b.cluster.Containers.PBS.QsubArgumentsList...)
+	args = append(args,
+		"-N", ctr.UUID,
+		"-p", fmt.Sprintf("%d", lowest),
+		// Don't rerun the job if the node fails. The
+		// dispatcher will notice the container was
+		// cancelled.
+		"-r", "n",
+		// Unlike LSF and SLURM, PBS doesn't pass the
+		// submitter's environment to the job by default.
+		"-v", "ARVADOS_API_HOST,ARVADOS_API_TOKEN,ARVADOS_API_HOST_INSECURE",
+		"-l", fmt.Sprintf("select=1:ncpus=%d:mem=%dmb", res.VCPUs, res.RAMMiB))
+	if parts := ctr.SchedulingParameters.Partitions; len(parts) > 0 {
+		// qsub accepts only one queue.
+		if len(parts) > 1 {
+			b.logger.WithField("ContainerUUID", ctr.UUID).Warnf("PBS does not support multiple queues, using first of %q", parts)
+		}
+		args = append(args, "-q", parts[0])
+	}
+	return args
+}
+
+// Submit submits a job using qsub. The job script is passed on
+// stdin.
+func (b *pbsBackend) Submit(ctr arvados.Container, script string) error {
+	_, err := b.cli.Run(strings.NewReader(script), "qsub", b.qsubArgs(ctr)...)
+	return err
+}
+
+type qstatOutput struct {
+	Jobs map[string]struct {
+		JobName  string `json:"Job_Name"`
+		JobState string `json:"job_state"`
+		Priority int64  `json:"Priority"`
+	} `json:"Jobs"`
+}
+
+// Queue returns all unfinished jobs, as reported by qstat.
+func (b *pbsBackend) Queue() ([]batchdispatch.Job, error) {
+	out, err := b.cli.Run(nil, "qstat", "-f", "-F", "json")
+	if err != nil {
+		return nil, err
+	}
+	var resp qstatOutput
+	err = json.Unmarshal(out, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing qstat output: %s", err)
+	}
+	var jobs []batchdispatch.Job
+	for id, rec := range resp.Jobs {
+		pending := false
+		switch rec.JobState {
+		case "F", "X":
+			// Finished
+			continue
+		case "Q", "H", "W", "T":
+			// Queued, held, waiting, or in transit
+			pending = true
+		}
+		jobs = append(jobs, batchdispatch.Job{
+			ID:       id,
+			Name:     rec.JobName,
+			Priority: rec.Priority,
+			Pending:  pending,
+		})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+// Cancel deletes a job using qdel. PBS sends SIGTERM to a running
+// job, and SIGKILL only after the queue's kill_delay, so crunch-run
+// has a chance to stop the container and clean up.
+func (b *pbsBackend) Cancel(job batchdispatch.Job) error {
+	_, err := b.cli.Run(nil, "qdel", job.ID)
+	if err != nil && (strings.Contains(err.Error(), "Unknown Job Id") || strings.Contains(err.Error(), "Job has finished")) {
+		return nil
+	}
+	return err
+}
+
+// SetPriority sets a job's priority using qalter -p.
+func (b *pbsBackend) SetPriority(job batchdispatch.Job, priority int64) error {
+	_, err := b.cli.Run(nil, "qalter", "-p", fmt.Sprintf("%d", priority), job.ID)
+	return err
+}
+
+// PriorityRange returns the configured range of job priorities.
+func (b *pbsBackend) PriorityRange() (int64, int64) {
+	return b.cluster.Containers.PBS.MinPriority, b.cluster.Containers.PBS.MaxPriority
+}
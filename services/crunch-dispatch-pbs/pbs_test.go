@@ -0,0 +1,183 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"git.arvados.org/arvados.git/lib/batchdispatch"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	. "gopkg.in/check.v1"
+)
+
+// Gocheck boilerplate
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&PBSSuite{})
+
+type PBSSuite struct {
+	stubdir string
+	oldPath string
+	cluster *arvados.Cluster
+	backend batchdispatch.Backend
+}
+
+// Each stub command logs its arguments to {stubdir}/calls, saves
+// its stdin (qsub only), and writes {stubdir}/{cmd}.stdout and
+// {cmd}.stderr to stdout and stderr, exiting with the status code
+// in {cmd}.exit.
+const stubScript = `#!/bin/sh
+dir=$(dirname "$0")
+name=$(basename "$0")
+echo "$name $*" >>"$dir/calls"
+if [ "$name" = qsub ]; then cat >"$dir/qsub.stdin"; fi
+if [ -e "$dir/$name.stdout" ]; then cat "$dir/$name.stdout"; fi
+if [ -e "$dir/$name.stderr" ]; then cat "$dir/$name.stderr" >&2; fi
+if [ -e "$dir/$name.exit" ]; then exit $(cat "$dir/$name.exit"); fi
+`
+
+func (s *PBSSuite) SetUpTest(c *C) {
+	s.stubdir = c.MkDir()
+	for _, cmd := range []string{"qsub", "qstat", "qdel", "qalter"} {
+		err := ioutil.WriteFile(s.stubdir+"/"+cmd, []byte(stubScript), 0755)
+		c.Assert(err, IsNil)
+	}
+	s.oldPath = os.Getenv("PATH")
+	os.Setenv("PATH", s.stubdir+":"+s.oldPath)
+
+	s.cluster = &arvados.Cluster{}
+	s.cluster.Containers.PBS.QsubArgumentsList = []string{"-j", "oe"}
+	s.cluster.Containers.PBS.MinPriority = 0
+	s.cluster.Containers.PBS.MaxPriority = 1023
+	s.cluster.Containers.ReserveExtraRAM = 256 << 20
+	var err error
+	s.backend, err = newPBSBackend(s.cluster, ctxlog.TestLogger(c))
+	c.Assert(err, IsNil)
+}
+
+func (s *PBSSuite) TearDownTest(c *C) {
+	os.Setenv("PATH", s.oldPath)
+}
+
+func (s *PBSSuite) stub(c *C, cmd, stdout, stderr, exit string) {
+	for suffix, content := range map[string]string{".stdout": stdout, ".stderr": stderr, ".exit": exit} {
+		err := ioutil.WriteFile(s.stubdir+"/"+cmd+suffix, []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *PBSSuite) calls(c *C) []string {
+	buf, err := ioutil.ReadFile(s.stubdir + "/calls")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	c.Assert(err, IsNil)
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+}
+
+func (s *PBSSuite) TestInvalidPriorityRange(c *C) {
+	for _, r := range [][2]int64{{10, 5}, {-2000, 0}, {0, 2000}} {
+		s.cluster.Containers.PBS.MinPriority, s.cluster.Containers.PBS.MaxPriority = r[0], r[1]
+		_, err := newPBSBackend(s.cluster, ctxlog.TestLogger(c))
+		c.Check(err, ErrorMatches, `invalid PBS priority range .*`)
+	}
+}
+
+func (s *PBSSuite) TestSubmit(c *C) {
+	ctr := arvados.Container{
+		UUID: "zzzzz-dz642-queuedcontainer",
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs:        4,
+			RAM:          11 << 30,
+			KeepCacheRAM: 256 << 20,
+		},
+		SchedulingParameters: arvados.SchedulingParameters{
+			Partitions: []string{"workq", "other"},
+		},
+	}
+	s.stub(c, "qsub", "1234.pbsserver\n", "", "0")
+	err := s.backend.Submit(ctr, "#!/bin/sh\nexec 'crunch-run' 'zzzzz-dz642-queuedcontainer'\n")
+	c.Assert(err, IsNil)
+	c.Check(s.calls(c), DeepEquals, []string{
+		"qsub -j oe -N zzzzz-dz642-queuedcontainer -p 0 -r n -v ARVADOS_API_HOST,ARVADOS_API_TOKEN,ARVADOS_API_HOST_INSECURE -l select=1:ncpus=4:mem=11776mb -q workq",
+	})
+	stdin, err := ioutil.ReadFile(s.stubdir + "/qsub.stdin")
+	c.Assert(err, IsNil)
+	c.Check(string(stdin), Equals, "#!/bin/sh\nexec 'crunch-run' 'zzzzz-dz642-queuedcontainer'\n")
+}
+
+func (s *PBSSuite) TestSubmitFail(c *C) {
+	s.stub(c, "qsub", "", "qsub: Unknown queue\n", "170")
+	err := s.backend.Submit(arvados.Container{UUID: "zzzzz-dz642-queuedcontainer"}, "")
+	c.Check(err, ErrorMatches, `.*qsub: Unknown queue.*`)
+}
+
+func (s *PBSSuite) TestQueue(c *C) {
+	s.stub(c, "qstat", `{
+    "timestamp":1602000000,
+    "pbs_version":"19.1.3",
+    "pbs_server":"pbsserver",
+    "Jobs":{
+        "101.pbsserver":{"Job_Name":"zzzzz-dz642-fake0fake0fake0","job_state":"R","Priority":7},
+        "102.pbsserver":{"Job_Name":"zzzzz-dz642-fake1fake1fake1","job_state":"Q","Priority":-3},
+        "103.pbsserver":{"Job_Name":"someone else's job","job_state":"H"},
+        "104.pbsserver":{"Job_Name":"zzzzz-dz642-fake2fake2fake2","job_state":"F","Priority":1},
+        "105.pbsserver":{"Job_Name":"zzzzz-dz642-fake3fake3fake3","job_state":"E","Priority":1}
+    }
+}`, "", "0")
+	jobs, err := s.backend.Queue()
+	c.Assert(err, IsNil)
+	c.Check(jobs, DeepEquals, []batchdispatch.Job{
+		{ID: "101.pbsserver", Name: "zzzzz-dz642-fake0fake0fake0", Priority: 7, Pending: false},
+		{ID: "102.pbsserver", Name: "zzzzz-dz642-fake1fake1fake1", Priority: -3, Pending: true},
+		{ID: "103.pbsserver", Name: "someone else's job", Priority: 0, Pending: true},
+		{ID: "105.pbsserver", Name: "zzzzz-dz642-fake3fake3fake3", Priority: 1, Pending: false},
+	})
+	c.Check(s.calls(c), DeepEquals, []string{"qstat -f -F json"})
+}
+
+func (s *PBSSuite) TestQueueEmpty(c *C) {
+	s.stub(c, "qstat", `{"timestamp":1602000000,"pbs_version":"19.1.3","pbs_server":"pbsserver"}`, "", "0")
+	jobs, err := s.backend.Queue()
+	c.Check(err, IsNil)
+	c.Check(jobs, HasLen, 0)
+}
+
+func (s *PBSSuite) TestQueueError(c *C) {
+	s.stub(c, "qstat", "", "Connection refused\nqstat: cannot connect to server pbsserver (errno=15010)\n", "1")
+	_, err := s.backend.Queue()
+	c.Check(err, ErrorMatches, `.*cannot connect to server.*`)
+
+	s.stub(c, "qstat", "this is not json", "", "0")
+	_, err = s.backend.Queue()
+	c.Check(err, ErrorMatches, `error parsing qstat output: .*`)
+}
+
+func (s *PBSSuite) TestCancel(c *C) {
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "101.pbsserver"}), IsNil)
+	s.stub(c, "qdel", "", "qdel: Unknown Job Id 102.pbsserver\n", "153")
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "102.pbsserver"}), IsNil)
+	s.stub(c, "qdel", "", "qdel: Unauthorized Request  103.pbsserver\n", "159")
+	c.Check(s.backend.Cancel(batchdispatch.Job{ID: "103.pbsserver"}), ErrorMatches, `.*Unauthorized Request.*`)
+	c.Check(s.calls(c), DeepEquals, []string{
+		"qdel 101.pbsserver",
+		"qdel 102.pbsserver",
+		"qdel 103.pbsserver",
+	})
+}
+
+func (s *PBSSuite) TestSetPriority(c *C) {
+	c.Check(s.backend.SetPriority(batchdispatch.Job{ID: "101.pbsserver"}, 42), IsNil)
+	c.Check(s.calls(c), DeepEquals, []string{"qalter -p 42 101.pbsserver"})
+	lowest, highest := s.backend.PriorityRange()
+	c.Check(lowest, Equals, int64(0))
+	c.Check(highest, Equals, int64(1023))
+}
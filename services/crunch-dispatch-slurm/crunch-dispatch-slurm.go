@@ -162,7 +162,17 @@ func (disp *Dispatcher) setup() {
 	}
 	arv.Retries = 25
 
-	disp.slurm = NewSlurmCLI()
+	switch disp.cluster.Containers.SLURM.Interface {
+	case "", "cli":
+		disp.slurm = NewSlurmCLI()
+	case "rest":
+		disp.slurm, err = NewSlurmREST(disp.cluster)
+		if err != nil {
+			disp.logger.Fatalf("Error configuring slurmrestd client: %s", err)
+		}
+	default:
+		disp.logger.Fatalf("Invalid Containers.SLURM.Interface %q (must be \"cli\" or \"rest\")", disp.cluster.Containers.SLURM.Interface)
+	}
 	disp.sqCheck = &SqueueChecker{
 		Logger:         disp.logger,
 		Period:         time.Duration(disp.cluster.Containers.CloudVMs.PollInterval),
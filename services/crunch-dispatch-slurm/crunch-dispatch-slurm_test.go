@@ -14,7 +14,6 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"os/exec"
 	"strings"
 	"testing"
 	"time"
@@ -73,8 +72,8 @@ func (sf *slurmFake) Batch(script io.Reader, args []string) error {
 	return sf.errBatch
 }
 
-func (sf *slurmFake) QueueCommand(args []string) *exec.Cmd {
-	return exec.Command("echo", sf.queue)
+func (sf *slurmFake) Queue(logger dispatch.Logger) ([]squeueEntry, error) {
+	return parseSqueue(logger, sf.queue), nil
 }
 
 func (sf *slurmFake) Release(name string) error {
@@ -5,21 +5,38 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"os/exec"
 	"strings"
+
+	"git.arvados.org/arvados.git/sdk/go/dispatch"
 )
 
 type Slurm interface {
 	Batch(script io.Reader, args []string) error
 	Cancel(name string) error
-	QueueCommand(args []string) *exec.Cmd
+	// Queue returns the jobs in the SLURM queue. Problems that
+	// don't prevent it from returning the rest of the queue
+	// (like an unparseable line of squeue output) are reported
+	// to logger.
+	Queue(logger dispatch.Logger) ([]squeueEntry, error)
 	Release(name string) error
 	Renice(name string, nice int64) error
 }
 
+// squeueEntry is a job in the SLURM queue, as reported by squeue or
+// slurmrestd.
+type squeueEntry struct {
+	name     string
+	nice     int64
+	priority int64
+	state    string
+	reason   string
+}
+
 type slurmCLI struct {
 	runSemaphore chan bool
 }
@@ -59,8 +76,32 @@ func (scli *slurmCLI) Cancel(name string) error {
 	return nil
 }
 
-func (scli *slurmCLI) QueueCommand(args []string) *exec.Cmd {
-	return exec.Command("squeue", args...)
+func (scli *slurmCLI) Queue(logger dispatch.Logger) ([]squeueEntry, error) {
+	cmd := exec.Command("squeue", "--all", "--noheader", "--format=%j %y %Q %T %r")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%q %q: %s %q", cmd.Path, cmd.Args, err, stderr.String())
+	}
+	return parseSqueue(logger, stdout.String()), nil
+}
+
+// parseSqueue parses squeue output in the format "%j %y %Q %T %r"
+// (name, nice, priority, state, reason).
+func parseSqueue(logger dispatch.Logger, out string) []squeueEntry {
+	var entries []squeueEntry
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		var ent squeueEntry
+		if _, err := fmt.Sscan(line, &ent.name, &ent.nice, &ent.priority, &ent.state, &ent.reason); err != nil {
+			logger.Warnf("ignoring unparsed line in squeue output: %q", line)
+			continue
+		}
+		entries = append(entries, ent)
+	}
+	return entries
 }
 
 func (scli *slurmCLI) Release(name string) error {
@@ -0,0 +1,453 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/dispatch"
+)
+
+// slurmrestd API version used for all requests (SLURM 22.05).
+const slurmrestdAPIVersion = "v0.0.38"
+
+// Job states reported by slurmrestd that squeue would list by
+// default. Jobs in other states (COMPLETED, CANCELLED, FAILED, etc.)
+// have already finished.
+var slurmrestdActiveStates = map[string]bool{
+	"PENDING":     true,
+	"RUNNING":     true,
+	"SUSPENDED":   true,
+	"COMPLETING":  true,
+	"CONFIGURING": true,
+}
+
+// slurmREST implements the Slurm interface using the slurmrestd JSON
+// API instead of the SLURM command line tools.
+type slurmREST struct {
+	baseURL  string
+	userName string
+	token    string
+	env      []string
+	client   *http.Client
+
+	// Job IDs and states from the most recent Queue() call, plus
+	// jobs submitted by Batch since then, so Cancel, Renice, and
+	// Release can look up job IDs by name without listing all
+	// jobs again.
+	jobs map[string][]slurmrestdJob
+	mtx  sync.Mutex
+}
+
+type slurmrestdJob struct {
+	JobID       int64  `json:"job_id"`
+	Name        string `json:"name"`
+	Nice        int64  `json:"nice"`
+	Priority    int64  `json:"priority"`
+	JobState    string `json:"job_state"`
+	StateReason string `json:"state_reason"`
+}
+
+// slurmrestdJobProperties is the subset of slurmrestd's
+// job_properties used for submitting and updating jobs.
+type slurmrestdJobProperties struct {
+	Name                    string   `json:"name,omitempty"`
+	Nice                    *int64   `json:"nice,omitempty"`
+	Hold                    *bool    `json:"hold,omitempty"`
+	Requeue                 *bool    `json:"requeue,omitempty"`
+	MemoryPerNode           int64    `json:"memory_per_node,omitempty"`
+	CPUsPerTask             int64    `json:"cpus_per_task,omitempty"`
+	TmpDiskPerNode          int64    `json:"tmp_disk_per_node,omitempty"`
+	Constraints             string   `json:"constraints,omitempty"`
+	Partition               string   `json:"partition,omitempty"`
+	Account                 string   `json:"account,omitempty"`
+	QOS                     string   `json:"qos,omitempty"`
+	Reservation             string   `json:"reservation,omitempty"`
+	Comment                 string   `json:"comment,omitempty"`
+	StandardOutput          string   `json:"standard_output,omitempty"`
+	StandardError           string   `json:"standard_error,omitempty"`
+	Environment             []string `json:"environment,omitempty"`
+	CurrentWorkingDirectory string   `json:"current_working_directory,omitempty"`
+}
+
+type slurmrestdError struct {
+	Error       string `json:"error"`
+	ErrorNumber int    `json:"error_number"`
+	Description string `json:"description"`
+}
+
+type slurmrestdResponse struct {
+	Errors []slurmrestdError `json:"errors"`
+	Jobs   []slurmrestdJob   `json:"jobs"`
+	JobID  int64             `json:"job_id"` // job/submit only
+}
+
+// Environment variables passed to SLURM jobs, in addition to
+// Containers.SLURM.SbatchEnvironmentVariables. sbatch passes the
+// dispatcher's entire environment to the job, but slurmrestd
+// doesn't have access to it.
+var slurmrestdEnvironment = []string{
+	"ARVADOS_API_HOST",
+	"ARVADOS_API_TOKEN",
+	"ARVADOS_API_HOST_INSECURE",
+	"PATH",
+}
+
+// NewSlurmREST returns a slurmrestd client using the cluster's
+// Containers.SLURM.REST configuration. It returns an error if the
+// configuration is unusable, or Containers.SLURM.SbatchArgumentsList
+// has arguments that can't be expressed as slurmrestd job properties.
+func NewSlurmREST(cluster *arvados.Cluster) (*slurmREST, error) {
+	cfg := cluster.Containers.SLURM.REST
+	if _, err := sbatchJobProperties(cluster.Containers.SLURM.SbatchArgumentsList); err != nil {
+		return nil, fmt.Errorf("SbatchArgumentsList: %s", err)
+	}
+	transport := &http.Transport{}
+	baseURL := cfg.URL
+	switch baseURL.Scheme {
+	case "http", "https":
+	case "unix":
+		sockPath := baseURL.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sockPath)
+		}
+		baseURL = arvados.URL{Scheme: "http", Host: "localhost"}
+	default:
+		return nil, fmt.Errorf("invalid slurmrestd URL %q (must be http://, https://, or unix://)", cfg.URL.String())
+	}
+	var env []string
+	for _, k := range slurmrestdEnvironment {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	for k := range cluster.Containers.SLURM.SbatchEnvironmentVariables {
+		env = append(env, k+"="+os.Getenv(k))
+	}
+	return &slurmREST{
+		baseURL:  strings.TrimSuffix(baseURL.String(), "/"),
+		userName: cfg.UserName,
+		token:    cfg.Token,
+		env:      env,
+		client:   &http.Client{Transport: transport, Timeout: time.Minute},
+	}, nil
+}
+
+// Batch submits a job. The given sbatch arguments are translated to
+// the equivalent slurmrestd job properties.
+func (rest *slurmREST) Batch(script io.Reader, args []string) error {
+	props, err := sbatchJobProperties(args)
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadAll(script)
+	if err != nil {
+		return err
+	}
+	props.Environment = rest.env
+	props.CurrentWorkingDirectory = "/tmp"
+	var resp slurmrestdResponse
+	err = rest.do("POST", "/job/submit", map[string]interface{}{
+		"script": string(buf),
+		"job":    props,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	rest.mtx.Lock()
+	defer rest.mtx.Unlock()
+	if rest.jobs == nil {
+		rest.jobs = map[string][]slurmrestdJob{}
+	}
+	rest.jobs[props.Name] = append(rest.jobs[props.Name], slurmrestdJob{
+		JobID:    resp.JobID,
+		Name:     props.Name,
+		JobState: "PENDING",
+	})
+	return nil
+}
+
+// Cancel removes pending jobs with the given name from the queue,
+// and sends SIGTERM to running jobs (see slurmCLI.Cancel).
+//
+// Jobs that were not in the last Queue() response, and were not
+// submitted by Batch since then, are not cancelled. The caller
+// retries while the job is still in the queue, so it will be
+// cancelled after the next Queue() call.
+func (rest *slurmREST) Cancel(name string) error {
+	for _, job := range rest.lookup(name) {
+		// Get the job's current state, rather than relying on
+		// the last Queue() call: sending the default SIGKILL
+		// to a job that has just started would kill crunch-run
+		// without stopping the container.
+		path := fmt.Sprintf("/job/%d", job.JobID)
+		var resp slurmrestdResponse
+		err := rest.do("GET", path, nil, &resp)
+		if err != nil && strings.Contains(err.Error(), "Invalid job id") {
+			// Already finished and purged.
+			continue
+		} else if err != nil {
+			return err
+		}
+		state := ""
+		for _, j := range resp.Jobs {
+			if j.JobID == job.JobID {
+				state = j.JobState
+			}
+		}
+		switch state {
+		case "PENDING":
+		case "RUNNING", "SUSPENDED":
+			path += "?signal=TERM"
+		default:
+			continue
+		}
+		err = rest.do("DELETE", path, nil, nil)
+		if err != nil && !strings.Contains(err.Error(), "already completing or completed") {
+			return err
+		}
+	}
+	return nil
+}
+
+// Queue returns the jobs in the SLURM queue.
+func (rest *slurmREST) Queue(dispatch.Logger) ([]squeueEntry, error) {
+	var resp slurmrestdResponse
+	err := rest.do("GET", "/jobs", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	jobs := map[string][]slurmrestdJob{}
+	var entries []squeueEntry
+	for _, job := range resp.Jobs {
+		if !slurmrestdActiveStates[job.JobState] {
+			continue
+		}
+		jobs[job.Name] = append(jobs[job.Name], job)
+		reason := job.StateReason
+		if reason == "" {
+			reason = "None"
+		}
+		entries = append(entries, squeueEntry{
+			name:     job.Name,
+			nice:     job.Nice,
+			priority: job.Priority,
+			state:    job.JobState,
+			reason:   reason,
+		})
+	}
+	rest.mtx.Lock()
+	rest.jobs = jobs
+	rest.mtx.Unlock()
+	return entries, nil
+}
+
+// Release releases held jobs with the given name.
+func (rest *slurmREST) Release(name string) error {
+	hold := false
+	return rest.update(name, slurmrestdJobProperties{Hold: &hold})
+}
+
+// Renice updates the nice value of jobs with the given name.
+func (rest *slurmREST) Renice(name string, nice int64) error {
+	return rest.update(name, slurmrestdJobProperties{Nice: &nice})
+}
+
+// update updates the properties of jobs with the given name that
+// were in the last Queue() response or submitted by Batch since
+// then. If there are no such jobs, it does nothing: the squeue
+// checker only updates jobs it found in the last Queue() response.
+func (rest *slurmREST) update(name string, props slurmrestdJobProperties) error {
+	for _, job := range rest.lookup(name) {
+		err := rest.do("POST", fmt.Sprintf("/job/%d", job.JobID), props, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rest *slurmREST) lookup(name string) []slurmrestdJob {
+	rest.mtx.Lock()
+	defer rest.mtx.Unlock()
+	return rest.jobs[name]
+}
+
+// do sends a request to slurmrestd and decodes the response into
+// resp (if not nil). Errors reported in the response body are
+// returned as a single error.
+func (rest *slurmREST) do(method, path string, body interface{}, resp *slurmrestdResponse) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	reqURL := rest.baseURL + "/slurm/" + slurmrestdAPIVersion + path
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if rest.userName != "" {
+		req.Header.Set("X-SLURM-USER-NAME", rest.userName)
+	}
+	if rest.token != "" {
+		req.Header.Set("X-SLURM-USER-TOKEN", rest.token)
+	}
+	httpResp, err := rest.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	buf, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: error reading response: %s", method, reqURL, err)
+	}
+	if resp == nil {
+		resp = &slurmrestdResponse{}
+	}
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, resp)
+		if err != nil && httpResp.StatusCode == http.StatusOK {
+			return fmt.Errorf("%s %s: error decoding response: %s", method, reqURL, err)
+		}
+	}
+	var msgs []string
+	for _, e := range resp.Errors {
+		msg := e.Error
+		if e.Description != "" {
+			msg = e.Description + ": " + msg
+		}
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 && httpResp.StatusCode != http.StatusOK {
+		msgs = append(msgs, strings.TrimSpace(string(buf)))
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s %s: %s: %s", method, reqURL, httpResp.Status, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// sbatchJobProperties translates sbatch command line arguments (as
+// returned by sbatchArgs(), including
+// SbatchArgumentsList) to slurmrestd job properties.
+//
+// Only long options are supported, as "--name=value" or "--name
+// value".
+func sbatchJobProperties(args []string) (slurmrestdJobProperties, error) {
+	var props slurmrestdJobProperties
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			return props, fmt.Errorf("unsupported sbatch argument %q", arg)
+		}
+		name, value := arg[2:], ""
+		hasValue := false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		switch name {
+		case "requeue", "no-requeue":
+			if hasValue {
+				return props, fmt.Errorf("unsupported sbatch argument %q", arg)
+			}
+			requeue := name == "requeue"
+			props.Requeue = &requeue
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return props, fmt.Errorf("sbatch argument %q requires a value", arg)
+			}
+			i++
+			value = args[i]
+		}
+		var err error
+		switch name {
+		case "job-name":
+			props.Name = value
+		case "nice":
+			var nice int64
+			nice, err = strconv.ParseInt(value, 10, 64)
+			props.Nice = &nice
+		case "mem":
+			props.MemoryPerNode, err = parseSbatchMiB(value)
+		case "cpus-per-task":
+			props.CPUsPerTask, err = strconv.ParseInt(value, 10, 64)
+		case "tmp":
+			props.TmpDiskPerNode, err = parseSbatchMiB(value)
+		case "constraint":
+			props.Constraints = value
+		case "partition":
+			props.Partition = value
+		case "account":
+			props.Account = value
+		case "qos":
+			props.QOS = value
+		case "reservation":
+			props.Reservation = value
+		case "comment":
+			props.Comment = value
+		case "output":
+			props.StandardOutput = value
+		case "error":
+			props.StandardError = value
+		default:
+			return props, fmt.Errorf("unsupported sbatch argument %q", arg)
+		}
+		if err != nil {
+			return props, fmt.Errorf("invalid sbatch argument %q: %s", arg, err)
+		}
+	}
+	return props, nil
+}
+
+// parseSbatchMiB parses a size argument like sbatch's --mem and --tmp
+// options: an integer number of megabytes, optionally followed by a
+// K, M, G, or T suffix.
+func parseSbatchMiB(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	var mult, div int64 = 1, 1
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		div = 1024
+	case "M":
+	case "G":
+		mult = 1024
+	case "T":
+		mult = 1024 * 1024
+	default:
+		s += "M"
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return (n*mult + div - 1) / div, nil
+}
@@ -0,0 +1,465 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+)
+
+// fakeSlurmrestd is a minimal slurmrestd server, implementing the
+// subset of the API used by slurmREST.
+type fakeSlurmrestd struct {
+	UserName string
+	Token    string
+
+	// If true, reject nice values > 10000 like SLURM 15.x.
+	RejectNice10K bool
+
+	mtx       sync.Mutex
+	jobs      []*fakeSlurmrestdJob
+	nextID    int64
+	requests  []string
+	submitted []fakeSlurmrestdSubmit
+}
+
+type fakeSlurmrestdJob struct {
+	slurmrestdJob
+	Hold   bool
+	Signal string
+}
+
+type fakeSlurmrestdSubmit struct {
+	Script string                  `json:"script"`
+	Job    slurmrestdJobProperties `json:"job"`
+}
+
+// AddJob adds a job to the fake queue, and returns its job ID.
+func (fake *fakeSlurmrestd) AddJob(name, state string, nice, priority int64) int64 {
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	fake.nextID++
+	fake.jobs = append(fake.jobs, &fakeSlurmrestdJob{slurmrestdJob: slurmrestdJob{
+		JobID:       fake.nextID,
+		Name:        name,
+		Nice:        nice,
+		Priority:    priority,
+		JobState:    state,
+		StateReason: "None",
+	}})
+	return fake.nextID
+}
+
+// Job returns a copy of the job with the given ID.
+func (fake *fakeSlurmrestd) Job(id int64) fakeSlurmrestdJob {
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	for _, job := range fake.jobs {
+		if job.JobID == id {
+			return *job
+		}
+	}
+	return fakeSlurmrestdJob{}
+}
+
+// SetState changes the state of the job with the given ID.
+func (fake *fakeSlurmrestd) SetState(id int64, state string) {
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	for _, job := range fake.jobs {
+		if job.JobID == id {
+			job.JobState = state
+		}
+	}
+}
+
+// Requests returns the "METHOD path?query" of each request received,
+// and clears the list.
+func (fake *fakeSlurmrestd) Requests() []string {
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	reqs := fake.requests
+	fake.requests = nil
+	return reqs
+}
+
+func (fake *fakeSlurmrestd) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	reqDesc := req.Method + " " + req.URL.Path
+	if req.URL.RawQuery != "" {
+		reqDesc += "?" + req.URL.RawQuery
+	}
+	fake.requests = append(fake.requests, reqDesc)
+
+	if req.Header.Get("X-SLURM-USER-NAME") != fake.UserName || req.Header.Get("X-SLURM-USER-TOKEN") != fake.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Authentication failure"))
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/slurm/"+slurmrestdAPIVersion)
+	if path == req.URL.Path {
+		fake.respondError(w, http.StatusNotFound, "Unable to find requested URL")
+		return
+	}
+
+	switch {
+	case req.Method == "GET" && path == "/jobs":
+		resp := slurmrestdResponse{Errors: []slurmrestdError{}}
+		for _, job := range fake.jobs {
+			resp.Jobs = append(resp.Jobs, job.slurmrestdJob)
+		}
+		json.NewEncoder(w).Encode(resp)
+	case req.Method == "POST" && path == "/job/submit":
+		var sub fakeSlurmrestdSubmit
+		if err := json.NewDecoder(req.Body).Decode(&sub); err != nil {
+			fake.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !strings.HasPrefix(sub.Script, "#!") {
+			fake.respondError(w, http.StatusInternalServerError, "batch script must start with #!")
+			return
+		}
+		if len(sub.Job.Environment) == 0 {
+			fake.respondError(w, http.StatusInternalServerError, "environment must be set")
+			return
+		}
+		fake.submitted = append(fake.submitted, sub)
+		fake.nextID++
+		job := &fakeSlurmrestdJob{slurmrestdJob: slurmrestdJob{
+			JobID:       fake.nextID,
+			Name:        sub.Job.Name,
+			Priority:    4294000000,
+			JobState:    "PENDING",
+			StateReason: "None",
+		}}
+		if sub.Job.Nice != nil {
+			job.Nice = *sub.Job.Nice
+			job.Priority -= job.Nice
+		}
+		fake.jobs = append(fake.jobs, job)
+		fmt.Fprintf(w, `{"errors":[],"job_id":%d,"step_id":"BATCH","job_submit_user_msg":""}`, job.JobID)
+	case strings.HasPrefix(path, "/job/"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(path, "/job/"), 10, 64)
+		var job *fakeSlurmrestdJob
+		for _, j := range fake.jobs {
+			if j.JobID == id {
+				job = j
+			}
+		}
+		if err != nil || job == nil {
+			fake.respondError(w, http.StatusInternalServerError, "Invalid job id specified")
+			return
+		}
+		switch req.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(slurmrestdResponse{Errors: []slurmrestdError{}, Jobs: []slurmrestdJob{job.slurmrestdJob}})
+		case "POST":
+			var props slurmrestdJobProperties
+			if err := json.NewDecoder(req.Body).Decode(&props); err != nil {
+				fake.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if props.Nice != nil {
+				if fake.RejectNice10K && *props.Nice > 10000 {
+					fake.respondError(w, http.StatusInternalServerError, "Invalid nice value")
+					return
+				}
+				job.Priority += job.Nice - *props.Nice
+				job.Nice = *props.Nice
+			}
+			if props.Hold != nil {
+				job.Hold = *props.Hold
+			}
+			w.Write([]byte(`{"errors":[]}`))
+		case "DELETE":
+			if !slurmrestdActiveStates[job.JobState] {
+				fake.respondError(w, http.StatusInternalServerError, "Job/step already completing or completed")
+				return
+			}
+			job.Signal = req.FormValue("signal")
+			if job.Signal == "" {
+				job.JobState = "CANCELLED"
+			}
+			w.Write([]byte(`{"errors":[]}`))
+		default:
+			fake.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	default:
+		fake.respondError(w, http.StatusNotFound, "Unable to find requested URL")
+	}
+}
+
+func (fake *fakeSlurmrestd) respondError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(slurmrestdResponse{Errors: []slurmrestdError{{Error: msg, ErrorNumber: 9999}}})
+}
+
+var _ = Suite(&SlurmRESTSuite{})
+
+type SlurmRESTSuite struct {
+	fake    *fakeSlurmrestd
+	server  *httptest.Server
+	cluster *arvados.Cluster
+	rest    *slurmREST
+}
+
+func (s *SlurmRESTSuite) SetUpTest(c *C) {
+	s.fake = &fakeSlurmrestd{UserName: "crunch", Token: "fake.jwt.token"}
+	s.server = httptest.NewServer(s.fake)
+	s.cluster = &arvados.Cluster{}
+	s.cluster.Containers.SLURM.Interface = "rest"
+	s.cluster.Containers.SLURM.REST.URL = arvados.URL{Scheme: "http", Host: s.server.Listener.Addr().String()}
+	s.cluster.Containers.SLURM.REST.UserName = "crunch"
+	s.cluster.Containers.SLURM.REST.Token = "fake.jwt.token"
+	var err error
+	s.rest, err = NewSlurmREST(s.cluster)
+	c.Assert(err, IsNil)
+}
+
+func (s *SlurmRESTSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *SlurmRESTSuite) TestBatch(c *C) {
+	defer os.Setenv("ARVADOS_API_HOST", os.Getenv("ARVADOS_API_HOST"))
+	os.Setenv("ARVADOS_API_HOST", "zzzzz.example.com")
+	os.Setenv("SAMPLE_VAR", "sample value")
+	defer os.Unsetenv("SAMPLE_VAR")
+	s.cluster.Containers.SLURM.SbatchEnvironmentVariables = map[string]string{"SAMPLE_VAR": "sample value"}
+	rest, err := NewSlurmREST(s.cluster)
+	c.Assert(err, IsNil)
+
+	err = rest.Batch(strings.NewReader("#!/bin/sh\nexec 'crunch-run' 'zzzzz-dz642-queuedcontainer'\n"), []string{
+		"--account", "arvados",
+		"--job-name=zzzzz-dz642-queuedcontainer", "--nice=10000", "--no-requeue",
+		"--mem=11445", "--cpus-per-task=4", "--tmp=2G",
+		"--partition=blurb,b2",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.fake.submitted, HasLen, 1)
+	sub := s.fake.submitted[0]
+	c.Check(sub.Script, Equals, "#!/bin/sh\nexec 'crunch-run' 'zzzzz-dz642-queuedcontainer'\n")
+	c.Check(sub.Job.Account, Equals, "arvados")
+	c.Check(sub.Job.Name, Equals, "zzzzz-dz642-queuedcontainer")
+	c.Assert(sub.Job.Nice, NotNil)
+	c.Check(*sub.Job.Nice, Equals, int64(10000))
+	c.Assert(sub.Job.Requeue, NotNil)
+	c.Check(*sub.Job.Requeue, Equals, false)
+	c.Check(sub.Job.MemoryPerNode, Equals, int64(11445))
+	c.Check(sub.Job.CPUsPerTask, Equals, int64(4))
+	c.Check(sub.Job.TmpDiskPerNode, Equals, int64(2048))
+	c.Check(sub.Job.Partition, Equals, "blurb,b2")
+	c.Check(sub.Job.CurrentWorkingDirectory, Equals, "/tmp")
+	env := map[string]bool{}
+	for _, kv := range sub.Job.Environment {
+		env[kv] = true
+	}
+	c.Check(env["ARVADOS_API_HOST=zzzzz.example.com"], Equals, true)
+	c.Check(env["PATH="+os.Getenv("PATH")], Equals, true)
+	c.Check(env["SAMPLE_VAR=sample value"], Equals, true)
+
+	entries, err := rest.Queue(logrus.StandardLogger())
+	c.Check(err, IsNil)
+	c.Check(entries, DeepEquals, []squeueEntry{
+		{name: "zzzzz-dz642-queuedcontainer", nice: 10000, priority: 4293990000, state: "PENDING", reason: "None"},
+	})
+}
+
+func (s *SlurmRESTSuite) TestBatchError(c *C) {
+	err := s.rest.Batch(strings.NewReader("echo no shebang\n"), []string{"--job-name=zzzzz-dz642-queuedcontainer"})
+	c.Check(err, ErrorMatches, `POST http://.*/slurm/v0.0.38/job/submit: 500 Internal Server Error: batch script must start with #!`)
+}
+
+func (s *SlurmRESTSuite) TestSbatchJobProperties(c *C) {
+	for _, args := range [][]string{
+		{"-N", "1"},
+		{"--exclusive"},
+		{"--mem"},
+		{"--mem=lots"},
+		{"--nice=x"},
+		{"--no-requeue=yes"},
+	} {
+		_, err := sbatchJobProperties(args)
+		c.Check(err, NotNil, Commentf("%q", args))
+
+		s.cluster.Containers.SLURM.SbatchArgumentsList = args
+		_, err = NewSlurmREST(s.cluster)
+		c.Check(err, ErrorMatches, `SbatchArgumentsList: .*`)
+	}
+
+	for in, out := range map[string]int64{
+		"100":   100,
+		"100M":  100,
+		"1g":    1024,
+		"2T":    2 << 20,
+		"1K":    1,
+		"2049k": 3,
+	} {
+		n, err := parseSbatchMiB(in)
+		c.Check(err, IsNil)
+		c.Check(n, Equals, out, Commentf("%q", in))
+	}
+}
+
+func (s *SlurmRESTSuite) TestInvalidURL(c *C) {
+	for _, u := range []arvados.URL{{}, {Scheme: "ftp", Host: "example"}} {
+		s.cluster.Containers.SLURM.REST.URL = u
+		_, err := NewSlurmREST(s.cluster)
+		c.Check(err, ErrorMatches, `invalid slurmrestd URL .*`)
+	}
+}
+
+func (s *SlurmRESTSuite) TestUnixSocket(c *C) {
+	sockPath := c.MkDir() + "/slurmrestd.sock"
+	ln, err := net.Listen("unix", sockPath)
+	c.Assert(err, IsNil)
+	srv := &http.Server{Handler: s.fake}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	s.cluster.Containers.SLURM.REST.URL = arvados.URL{Scheme: "unix", Path: sockPath}
+	rest, err := NewSlurmREST(s.cluster)
+	c.Assert(err, IsNil)
+	s.fake.AddJob("zzzzz-dz642-fake0fake0fake0", "RUNNING", 0, 4294000000)
+	entries, err := rest.Queue(logrus.StandardLogger())
+	c.Check(err, IsNil)
+	c.Check(entries, HasLen, 1)
+	c.Check(s.fake.Requests(), DeepEquals, []string{"GET /slurm/v0.0.38/jobs"})
+}
+
+func (s *SlurmRESTSuite) TestAuthFailure(c *C) {
+	s.cluster.Containers.SLURM.REST.Token = "wrong.jwt.token"
+	rest, err := NewSlurmREST(s.cluster)
+	c.Assert(err, IsNil)
+	_, err = rest.Queue(logrus.StandardLogger())
+	c.Check(err, ErrorMatches, `GET .*: 401 Unauthorized: Authentication failure`)
+}
+
+func (s *SlurmRESTSuite) TestQueue(c *C) {
+	s.fake.AddJob("zzzzz-dz642-fake0fake0fake0", "RUNNING", 0, 4294000000)
+	s.fake.AddJob("zzzzz-dz642-fake1fake1fake1", "PENDING", 10000, 4294000111)
+	s.fake.AddJob("zzzzz-dz642-fake2fake2fake2", "COMPLETED", 10000, 0)
+	s.fake.AddJob("someone else's job", "PENDING", 0, 1)
+	entries, err := s.rest.Queue(logrus.StandardLogger())
+	c.Check(err, IsNil)
+	c.Check(entries, DeepEquals, []squeueEntry{
+		{name: "zzzzz-dz642-fake0fake0fake0", nice: 0, priority: 4294000000, state: "RUNNING", reason: "None"},
+		{name: "zzzzz-dz642-fake1fake1fake1", nice: 10000, priority: 4294000111, state: "PENDING", reason: "None"},
+		{name: "someone else's job", nice: 0, priority: 1, state: "PENDING", reason: "None"},
+	})
+}
+
+func (s *SlurmRESTSuite) TestCancel(c *C) {
+	pending := s.fake.AddJob("zzzzz-dz642-fake0fake0fake0", "PENDING", 0, 4294000000)
+	running := s.fake.AddJob("zzzzz-dz642-fake1fake1fake1", "RUNNING", 0, 4294000000)
+	s.fake.AddJob("zzzzz-dz642-fake2fake2fake2", "COMPLETED", 0, 4294000000)
+
+	started := s.fake.AddJob("zzzzz-dz642-fake3fake3fake3", "PENDING", 0, 4294000000)
+	_, err := s.rest.Queue(logrus.StandardLogger())
+	c.Assert(err, IsNil)
+	s.fake.Requests()
+
+	// Job started since the last Queue() call => get its current
+	// state, and send SIGTERM instead of removing it.
+	s.fake.SetState(started, "RUNNING")
+	// Job submitted by someone else since the last Queue() call
+	// => not cancelled (until the next Queue() call).
+	s.fake.AddJob("zzzzz-dz642-fake4fake4fake4", "PENDING", 0, 4294000000)
+
+	for _, name := range []string{"zzzzz-dz642-fake0fake0fake0", "zzzzz-dz642-fake1fake1fake1", "zzzzz-dz642-fake2fake2fake2", "zzzzz-dz642-fake3fake3fake3", "zzzzz-dz642-fake4fake4fake4"} {
+		c.Check(s.rest.Cancel(name), IsNil)
+	}
+	c.Check(s.fake.Requests(), DeepEquals, []string{
+		fmt.Sprintf("GET /slurm/v0.0.38/job/%d", pending),
+		fmt.Sprintf("DELETE /slurm/v0.0.38/job/%d", pending),
+		fmt.Sprintf("GET /slurm/v0.0.38/job/%d", running),
+		fmt.Sprintf("DELETE /slurm/v0.0.38/job/%d?signal=TERM", running),
+		fmt.Sprintf("GET /slurm/v0.0.38/job/%d", started),
+		fmt.Sprintf("DELETE /slurm/v0.0.38/job/%d?signal=TERM", started),
+	})
+	c.Check(s.fake.Job(pending).JobState, Equals, "CANCELLED")
+	c.Check(s.fake.Job(running).JobState, Equals, "RUNNING")
+	c.Check(s.fake.Job(running).Signal, Equals, "TERM")
+	c.Check(s.fake.Job(started).Signal, Equals, "TERM")
+
+	// Job finished since the last Queue() call => nothing to do.
+	c.Check(s.rest.Cancel("zzzzz-dz642-fake0fake0fake0"), IsNil)
+	c.Check(s.fake.Requests(), DeepEquals, []string{
+		fmt.Sprintf("GET /slurm/v0.0.38/job/%d", pending),
+	})
+}
+
+func (s *SlurmRESTSuite) TestReniceAndRelease(c *C) {
+	id := s.fake.AddJob("zzzzz-dz642-fake0fake0fake0", "PENDING", 10000, 4294000000)
+	_, err := s.rest.Queue(logrus.StandardLogger())
+	c.Assert(err, IsNil)
+	s.fake.Requests()
+
+	c.Check(s.rest.Renice("zzzzz-dz642-fake0fake0fake0", 123), IsNil)
+	c.Check(s.rest.Release("zzzzz-dz642-fake0fake0fake0"), IsNil)
+	c.Check(s.fake.Requests(), DeepEquals, []string{
+		fmt.Sprintf("POST /slurm/v0.0.38/job/%d", id),
+		fmt.Sprintf("POST /slurm/v0.0.38/job/%d", id),
+	})
+	c.Check(s.fake.Job(id).Nice, Equals, int64(123))
+
+	// Submitted by Batch since the last Queue() call => job ID
+	// is already known
+	err = s.rest.Batch(strings.NewReader("#!/bin/sh\n"), []string{"--job-name=zzzzz-dz642-fake1fake1fake1"})
+	c.Assert(err, IsNil)
+	s.fake.Requests()
+	id = s.fake.nextID
+	c.Check(s.rest.Renice("zzzzz-dz642-fake1fake1fake1", 456), IsNil)
+	c.Check(s.fake.Requests(), DeepEquals, []string{
+		fmt.Sprintf("POST /slurm/v0.0.38/job/%d", id),
+	})
+	c.Check(s.fake.Job(id).Nice, Equals, int64(456))
+
+	// Not in the last Queue() response => nothing to do
+	s.fake.AddJob("zzzzz-dz642-fake2fake2fake2", "PENDING", 10000, 4294000000)
+	c.Check(s.rest.Renice("zzzzz-dz642-fake2fake2fake2", 456), IsNil)
+	c.Check(s.fake.Requests(), HasLen, 0)
+
+	s.fake.RejectNice10K = true
+	err = s.rest.Renice("zzzzz-dz642-fake1fake1fake1", 20000)
+	c.Check(err, ErrorMatches, `.*Invalid nice value.*`)
+}
+
+// SqueueChecker works the same way with the slurmrestd backend as
+// with the command line tools.
+func (s *SlurmRESTSuite) TestSqueueChecker(c *C) {
+	uuids := []string{"zzzzz-dz642-fake0fake0fake0", "zzzzz-dz642-fake1fake1fake1"}
+	ids := []int64{
+		s.fake.AddJob(uuids[0], "PENDING", 10000, 4294000777),
+		s.fake.AddJob(uuids[1], "PENDING", 10000, 4294000444),
+	}
+	sqc := &SqueueChecker{
+		Logger:         logrus.StandardLogger(),
+		Slurm:          s.rest,
+		PrioritySpread: 1,
+		Period:         time.Hour,
+	}
+	sqc.startOnce.Do(sqc.start)
+	defer sqc.Stop()
+	sqc.check()
+	sqc.SetPriority(uuids[0], 1)
+	sqc.SetPriority(uuids[1], 999)
+	sqc.reniceAll()
+	c.Check(s.fake.Job(ids[0]).Nice, Equals, int64(334))
+	c.Check(s.fake.Job(ids[1]).Nice, Equals, int64(0))
+}
@@ -5,8 +5,6 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
 	"sort"
 	"strings"
 	"sync"
@@ -23,8 +21,8 @@ type slurmJob struct {
 	hitNiceLimit bool
 }
 
-// Squeue implements asynchronous polling monitor of the SLURM queue using the
-// command 'squeue'.
+// Squeue implements asynchronous polling monitor of the SLURM queue
+// (using the command 'squeue', or slurmrestd).
 type SqueueChecker struct {
 	Logger         logger
 	Period         time.Duration
@@ -139,26 +137,15 @@ func (sqc *SqueueChecker) Stop() {
 // queued). If it succeeds, it updates sqc.queue and wakes up any
 // goroutines that are waiting in HasUUID() or All().
 func (sqc *SqueueChecker) check() {
-	cmd := sqc.Slurm.QueueCommand([]string{"--all", "--noheader", "--format=%j %y %Q %T %r"})
-	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = stdout, stderr
-	if err := cmd.Run(); err != nil {
-		sqc.Logger.Warnf("Error running %q %q: %s %q", cmd.Path, cmd.Args, err, stderr.String())
+	entries, err := sqc.Slurm.Queue(sqc.Logger)
+	if err != nil {
+		sqc.Logger.Warnf("Error getting slurm queue: %s", err)
 		return
 	}
 
-	lines := strings.Split(stdout.String(), "\n")
-	newq := make(map[string]*slurmJob, len(lines))
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		var uuid, state, reason string
-		var n, p int64
-		if _, err := fmt.Sscan(line, &uuid, &n, &p, &state, &reason); err != nil {
-			sqc.Logger.Warnf("ignoring unparsed line in squeue output: %q", line)
-			continue
-		}
+	newq := make(map[string]*slurmJob, len(entries))
+	for _, ent := range entries {
+		uuid, n, p, state, reason := ent.name, ent.nice, ent.priority, ent.state, ent.reason
 
 		// No other goroutines write to jobs' priority or nice
 		// fields, so we can read and write them without
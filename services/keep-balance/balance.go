@@ -42,6 +42,10 @@ type Balancer struct {
 
 	LostBlocksFile string
 
+	// If non-empty, write a JSON-lines report of all pull and
+	// trash requests to this file.
+	ReportFile string
+
 	*BlockStateMap
 	KeepServices       map[string]*KeepService
 	DefaultReplication int
@@ -139,6 +143,7 @@ func (bal *Balancer) Run(client *arvados.Client, cluster *arvados.Cluster, runOp
 		nextRunOptions.SafeRendezvousState = rs
 	}
 
+	stateTime := time.Now()
 	if err = bal.GetCurrentState(ctx, client, cluster.Collections.BalanceCollectionBatch, cluster.Collections.BalanceCollectionBuffers); err != nil {
 		return
 	}
@@ -158,6 +163,18 @@ func (bal *Balancer) Run(client *arvados.Client, cluster *arvados.Cluster, runOp
 		}
 		lbFile = nil
 	}
+	if bal.ReportFile != "" {
+		err = bal.writeReport(ctx, client, cluster)
+		if err != nil {
+			return
+		}
+	}
+	if runOptions.CommitConfirmedFields {
+		err = bal.updateCollections(ctx, client, cluster, stateTime)
+		if err != nil {
+			return
+		}
+	}
 	if runOptions.CommitPulls {
 		err = bal.CommitPulls(ctx, client)
 		if err != nil {
@@ -615,7 +632,7 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 		}
 	}
 
-	uuids := keepclient.NewRootSorter(bal.serviceRoots, string(blkid[:32])).GetSortedRoots()
+	uuids := keepclient.NewRootSorter(bal.serviceRoots, blkid.Hash()).GetSortedRoots()
 	srvRendezvous := make(map[*KeepService]int, len(uuids))
 	for i, uuid := range uuids {
 		srv := bal.KeepServices[uuid]
@@ -785,16 +802,23 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 				SizedDigest: blkid,
 				Mtime:       slot.repl.Mtime,
 				From:        slot.mnt,
+				Reason:      bal.trashReason(blk, slot.mnt),
 			})
 			change = changeTrash
 		case slot.repl == nil && slot.want && len(blk.Replicas) == 0:
 			lost = true
 			change = changeNone
 		case slot.repl == nil && slot.want && !slot.mnt.ReadOnly:
+			reason := reasonRendezvous
+			if underreplicated {
+				reason = reasonUnderreplicated
+			}
 			slot.mnt.KeepService.AddPull(Pull{
 				SizedDigest: blkid,
 				From:        blk.Replicas[0].KeepMount.KeepService,
+				FromMount:   blk.Replicas[0].KeepMount,
 				To:          slot.mnt,
+				Reason:      reason,
 			})
 			change = changePull
 		case slot.repl != nil:
@@ -823,6 +847,21 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 	}
 }
 
+// trashReason returns the reason a replica of blk on mnt is being
+// trashed.
+func (bal *Balancer) trashReason(blk *BlockState, mnt *KeepMount) string {
+	reason := reasonUnreferenced
+	for class, desired := range blk.Desired {
+		if desired == 0 {
+			continue
+		} else if bal.mountsByClass[class][mnt] {
+			return reasonOverreplicated
+		}
+		reason = reasonStorageClass
+	}
+	return reason
+}
+
 func computeBlockState(slots []slot, onlyCount map[*KeepMount]bool, have, needRepl int) (bbs balancedBlockState) {
 	repl := 0
 	countedDev := map[string]bool{}
@@ -1095,7 +1134,7 @@ func (bal *Balancer) CheckSanityLate() error {
 	}
 
 	// TODO: no two services have identical indexes
-	// TODO: no collisions (same md5, different size)
+	// TODO: no collisions (same hash, different size)
 	return nil
 }
 
@@ -1166,7 +1205,7 @@ func (bal *Balancer) time(name, help string) func() {
 // Rendezvous hash sort function. Less efficient than sorting on
 // precomputed rendezvous hashes, but also rarely used.
 func rendezvousLess(i, j string, blkid arvados.SizedDigest) bool {
-	a := md5.Sum([]byte(string(blkid[:32]) + i))
-	b := md5.Sum([]byte(string(blkid[:32]) + j))
+	a := md5.Sum([]byte(blkid.Hash() + i))
+	b := md5.Sum([]byte(blkid.Hash() + j))
 	return bytes.Compare(a[:], b[:]) < 0
 }
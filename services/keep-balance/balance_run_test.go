@@ -444,9 +444,15 @@ func (s *runSuite) TestDryRun(c *check.C) {
 	s.stub.serveKeepstoreIndexFoo4Bar1()
 	trashReqs := s.stub.serveKeepstoreTrash()
 	pullReqs := s.stub.serveKeepstorePull()
+	var updates int
+	s.stub.mux.HandleFunc("/arvados/v1/collections/", func(w http.ResponseWriter, r *http.Request) {
+		updates++
+		io.WriteString(w, `{}`)
+	})
 	srv := s.newServer(&opts)
 	bal, err := srv.runOnce()
 	c.Check(err, check.IsNil)
+	c.Check(updates, check.Equals, 0)
 	for _, req := range collReqs.reqs {
 		c.Check(req.Form.Get("include_trash"), check.Equals, "true")
 		c.Check(req.Form.Get("include_old_versions"), check.Equals, "true")
@@ -458,6 +464,66 @@ func (s *runSuite) TestDryRun(c *check.C) {
 	c.Check(bal.stats.overrep.replicas, check.Not(check.Equals), 0)
 }
 
+func (s *runSuite) TestDryRunReport(c *check.C) {
+	reportf, err := ioutil.TempFile("", "keep-balance-report-test-")
+	c.Assert(err, check.IsNil)
+	defer os.Remove(reportf.Name())
+
+	opts := RunOptions{
+		ReportFile: reportf.Name(),
+		Logger:     ctxlog.TestLogger(c),
+	}
+	s.stub.serveCurrentUserAdmin()
+	s.stub.serveFooBarFileCollections()
+	s.stub.serveKeepServices(stubServices)
+	s.stub.serveKeepstoreMounts()
+	s.stub.serveKeepstoreIndexFoo4Bar1()
+	trashReqs := s.stub.serveKeepstoreTrash()
+	pullReqs := s.stub.serveKeepstorePull()
+	var updates int
+	s.stub.mux.HandleFunc("/arvados/v1/collections/", func(w http.ResponseWriter, r *http.Request) {
+		updates++
+		io.WriteString(w, `{}`)
+	})
+	srv := s.newServer(&opts)
+	bal, err := srv.runOnce()
+	c.Check(err, check.IsNil)
+	c.Check(trashReqs.Count(), check.Equals, 0)
+	c.Check(pullReqs.Count(), check.Equals, 0)
+	c.Check(updates, check.Equals, 0)
+
+	buf, err := ioutil.ReadFile(reportf.Name())
+	c.Assert(err, check.IsNil)
+	var pulls, trashes int
+	for _, line := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		var ent reportEntry
+		c.Assert(json.Unmarshal([]byte(line), &ent), check.IsNil)
+		switch ent.Op {
+		case "pull":
+			pulls++
+			// "bar" is underreplicated
+			c.Check(ent.Block, check.Equals, "37b51d194a7513e45b56f6524f2d51f2+3")
+			c.Check(ent.Reason, check.Equals, "underreplicated")
+			c.Check(ent.FromService, check.Equals, "zzzzz-bi6l4-000000000000000")
+			c.Check(ent.FromMount, check.Equals, "zzzzz-ivpuk-000000000000000")
+			c.Check(ent.ToMount, check.Not(check.Equals), "")
+			c.Check(ent.Collections, check.DeepEquals, []string{"zzzzz-4zz18-aaaaaaaaaaaaaaa", "zzzzz-4zz18-ehbhgtheo8909or"})
+		case "trash":
+			trashes++
+			// "foo" is overreplicated
+			c.Check(ent.Block, check.Equals, "acbd18db4cc2f85cedef654fccc4a4d8+3")
+			c.Check(ent.Reason, check.Equals, "overreplicated")
+			c.Check(ent.FromMount, check.Not(check.Equals), "")
+			c.Check(ent.Mtime, check.Not(check.Equals), int64(0))
+			c.Check(ent.Collections, check.DeepEquals, []string{"zzzzz-4zz18-znfnqtbbv4spc3w"})
+		default:
+			c.Errorf("unexpected op %q", ent.Op)
+		}
+	}
+	c.Check(pulls, check.Equals, bal.stats.pulls)
+	c.Check(trashes, check.Equals, bal.stats.trashes)
+}
+
 func (s *runSuite) TestCommit(c *check.C) {
 	lostf, err := ioutil.TempFile("", "keep-balance-lost-blocks-test-")
 	c.Assert(err, check.IsNil)
@@ -502,6 +568,49 @@ func (s *runSuite) TestCommit(c *check.C) {
 	c.Check(buf, check.Matches, `(?ms).*\narvados_keep_dedup_block_ratio 1\.5\n.*`)
 }
 
+func (s *runSuite) TestCommitConfirmedFields(c *check.C) {
+	opts := RunOptions{
+		CommitConfirmedFields: true,
+		Logger:                ctxlog.TestLogger(c),
+	}
+	s.stub.serveCurrentUserAdmin()
+	s.stub.serveFooBarFileCollections()
+	s.stub.serveKeepServices(stubServices)
+	s.stub.serveKeepstoreMounts()
+	s.stub.serveKeepstoreIndexFoo4Bar1()
+	updates := map[string]string{}
+	var mtx sync.Mutex
+	s.stub.mux.HandleFunc("/arvados/v1/collections/", func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "PATCH")
+		r.ParseForm()
+		mtx.Lock()
+		defer mtx.Unlock()
+		updates[strings.TrimPrefix(r.URL.Path, "/arvados/v1/collections/")] = r.Form.Get("collection")
+		io.WriteString(w, `{}`)
+	})
+	srv := s.newServer(&opts)
+	_, err := srv.runOnce()
+	c.Check(err, check.IsNil)
+	c.Check(updates, check.HasLen, 3)
+	for uuid, expect := range map[string]string{
+		"zzzzz-4zz18-aaaaaaaaaaaaaaa": `(?ms).*"replication_confirmed":1,.*"storage_classes_confirmed":\[\],.*`,
+		"zzzzz-4zz18-ehbhgtheo8909or": `(?ms).*"replication_confirmed":1,.*"storage_classes_confirmed":\[\],.*`,
+		"zzzzz-4zz18-znfnqtbbv4spc3w": `(?ms).*"replication_confirmed":4,.*"storage_classes_confirmed":\["default"\],.*`,
+	} {
+		c.Check(updates[uuid], check.Matches, expect)
+	}
+
+	// The stub server doesn't save the updates, so the next run
+	// finds the same stale values and tries to update them
+	// again, but BalanceUpdateLimit stops it after one update.
+	s.config.Collections.BalanceUpdateLimit = 1
+	updates = map[string]string{}
+	srv = s.newServer(&opts)
+	_, err = srv.runOnce()
+	c.Check(err, check.IsNil)
+	c.Check(updates, check.HasLen, 1)
+}
+
 func (s *runSuite) TestRunForever(c *check.C) {
 	s.config.ManagementToken = "xyzzy"
 	opts := RunOptions{
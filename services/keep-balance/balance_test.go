@@ -6,6 +6,7 @@ package main
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 	"sort"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
 	check "gopkg.in/check.v1"
 )
 
@@ -657,6 +659,106 @@ func (bal *balancerSuite) TestChangeStorageClasses(c *check.C) {
 		current: slots{0, 1}})
 }
 
+func (bal *balancerSuite) TestChangeReasons(c *check.C) {
+	bal.srvs[9].mounts = []*KeepMount{{
+		KeepMount: arvados.KeepMount{
+			Replication:    1,
+			StorageClasses: map[string]bool{"special": true},
+			UUID:           "zzzzz-mount-special00000009",
+			DeviceID:       "9-special",
+		},
+		KeepService: bal.srvs[9],
+	}}
+	reasons := func(t tester) (pulls, trashes []string) {
+		bal.try(c, t)
+		for _, srv := range bal.srvs {
+			for _, pull := range srv.Pulls {
+				pulls = append(pulls, pull.Reason)
+			}
+			for _, trash := range srv.Trashes {
+				trashes = append(trashes, trash.Reason)
+			}
+		}
+		return
+	}
+	pulls, trashes := reasons(tester{
+		desired:    map[string]int{"default": 3},
+		current:    slots{0, 1},
+		shouldPull: slots{2}})
+	c.Check(pulls, check.DeepEquals, []string{"underreplicated"})
+	c.Check(trashes, check.HasLen, 0)
+	pulls, trashes = reasons(tester{
+		desired:     map[string]int{"default": 2},
+		current:     slots{2, 3, 4},
+		shouldPull:  slots{0, 1},
+		shouldTrash: slots{4}})
+	c.Check(pulls, check.DeepEquals, []string{"rendezvous", "rendezvous"})
+	c.Check(trashes, check.DeepEquals, []string{"overreplicated"})
+	pulls, trashes = reasons(tester{
+		desired:     map[string]int{"default": 2},
+		current:     slots{0, 1, 2},
+		shouldTrash: slots{2}})
+	c.Check(pulls, check.HasLen, 0)
+	c.Check(trashes, check.DeepEquals, []string{"overreplicated"})
+	// known block 0 is on server 9 at slot 9
+	pulls, trashes = reasons(tester{
+		desired:     map[string]int{"special": 1},
+		current:     slots{0, 9},
+		shouldTrash: slots{0}})
+	c.Check(pulls, check.HasLen, 0)
+	c.Check(trashes, check.DeepEquals, []string{"storage-class"})
+	pulls, trashes = reasons(tester{
+		desired:     map[string]int{},
+		current:     slots{0},
+		shouldTrash: slots{0}})
+	c.Check(pulls, check.HasLen, 0)
+	c.Check(trashes, check.DeepEquals, []string{"unreferenced"})
+}
+
+func (bal *balancerSuite) TestConfirmedState(c *check.C) {
+	special := &KeepMount{
+		KeepMount: arvados.KeepMount{
+			Replication:    1,
+			StorageClasses: map[string]bool{"special": true},
+			UUID:           "zzzzz-mount-special00000009",
+			DeviceID:       "9-special",
+		},
+		KeepService: bal.srvs[9],
+	}
+	bal.srvs[9].mounts = []*KeepMount{special}
+	bal.DefaultReplication = 2
+	bal.BlockStateMap = NewBlockStateMap()
+	for _, srv := range []int{0, 1} {
+		bal.BlockStateMap.AddReplicas(bal.srvs[srv].mounts[0], []arvados.KeepServiceIndexEntry{
+			{SizedDigest: knownBlkid(0)},
+			{SizedDigest: knownBlkid(1)},
+		})
+	}
+	bal.BlockStateMap.AddReplicas(special, []arvados.KeepServiceIndexEntry{{SizedDigest: knownBlkid(0)}})
+	// Same device mounted on a second server should not count
+	// twice.
+	bal.BlockStateMap.AddReplicas(&KeepMount{KeepMount: special.KeepMount, KeepService: bal.srvs[10]}, []arvados.KeepServiceIndexEntry{{SizedDigest: knownBlkid(0)}})
+
+	one := 1
+	for _, trial := range []struct {
+		coll    arvados.Collection
+		blkids  []arvados.SizedDigest
+		repl    int
+		classes []string
+	}{
+		{arvados.Collection{}, []arvados.SizedDigest{knownBlkid(0), knownBlkid(1)}, 2, []string{"default"}},
+		{arvados.Collection{StorageClassesDesired: []string{"special"}}, []arvados.SizedDigest{knownBlkid(0)}, 1, []string{}},
+		{arvados.Collection{StorageClassesDesired: []string{"special"}, ReplicationDesired: &one}, []arvados.SizedDigest{knownBlkid(0)}, 1, []string{"special"}},
+		{arvados.Collection{StorageClassesDesired: []string{"special", "default"}, ReplicationDesired: &one}, []arvados.SizedDigest{knownBlkid(0), knownBlkid(1)}, 0, []string{"default"}},
+		{arvados.Collection{}, []arvados.SizedDigest{knownBlkid(0), knownBlkid(2)}, 0, []string{}},
+		{arvados.Collection{StorageClassesDesired: []string{"special"}}, nil, 2, []string{"special"}},
+	} {
+		repl, classes := bal.confirmedState(trial.coll, trial.blkids)
+		c.Check(repl, check.Equals, trial.repl)
+		c.Check(classes, check.DeepEquals, trial.classes)
+	}
+}
+
 // Clear all servers' changesets, balance a single block, and verify
 // the appropriate changes for that block have been added to the
 // changesets.
@@ -716,6 +818,36 @@ func (bal *balancerSuite) try(c *check.C, t tester) {
 	}
 }
 
+// SHA-256 blocks are pulled to the same servers, in the same order,
+// that keepclient would write them to.
+func (bal *balancerSuite) TestSHA256Locator(c *check.C) {
+	blkid := arvados.SizedDigest(fmt.Sprintf("%x+3", sha256.Sum256([]byte("foo"))))
+	bal.setupLookupTables()
+	for _, srv := range bal.srvs {
+		srv.ChangeSet = &ChangeSet{}
+	}
+	bal.balanceBlock(blkid, &BlockState{
+		Replicas: []Replica{{bal.srvs[0].mounts[0], time.Now().UnixNano() - (bal.signatureTTL+86400)*1e9}},
+		Desired:  map[string]int{"default": 2},
+	})
+	best := keepclient.NewRootSorter(bal.serviceRoots, blkid.Hash()).GetSortedRoots()[:2]
+	var pulledTo []string
+	for _, srv := range bal.srvs {
+		for _, pull := range srv.Pulls {
+			c.Check(pull.SizedDigest, check.Equals, blkid)
+			pulledTo = append(pulledTo, srv.UUID)
+		}
+	}
+	var expect []string
+	for _, uuid := range best {
+		if uuid != bal.srvs[0].UUID {
+			expect = append(expect, uuid)
+		}
+	}
+	sort.Strings(expect)
+	c.Check(pulledTo, check.DeepEquals, expect)
+}
+
 // srvList returns the KeepServices, sorted in rendezvous order and
 // then selected by idx. For example, srvList(3, slots{0, 1, 4})
 // returns the first-, second-, and fifth-best servers for storing
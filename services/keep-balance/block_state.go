@@ -133,3 +133,61 @@ func (bsm *BlockStateMap) IncreaseDesired(pdh string, classes []string, n int, b
 		bsm.get(blkid).increaseDesired(pdh, classes, n)
 	}
 }
+
+// GetConfirmedReplication returns the replication level of the given
+// blocks in each of the given storage classes, i.e., for each class,
+// the smallest number of replicas of any one of the blocks that are
+// currently stored on mounts of that class. Mounts with no storage
+// classes are counted as "default". A volume that is mounted on more
+// than one server (i.e., has a DeviceID that appears on more than
+// one replica) is only counted once.
+//
+// If classes is empty, the default class is used.
+func (bsm *BlockStateMap) GetConfirmedReplication(blkids []arvados.SizedDigest, classes []string) map[string]int {
+	if len(classes) == 0 {
+		classes = defaultClasses
+	}
+	confirmed := make(map[string]int, len(classes))
+	for i, blkid := range blkids {
+		perClass := bsm.getReplication(blkid)
+		for _, class := range classes {
+			if n := perClass[class]; i == 0 || n < confirmed[class] {
+				confirmed[class] = n
+			}
+		}
+	}
+	return confirmed
+}
+
+// getReplication returns the number of replicas of the given block
+// stored on mounts of each storage class.
+func (bsm *BlockStateMap) getReplication(blkid arvados.SizedDigest) map[string]int {
+	bsm.mutex.Lock()
+	defer bsm.mutex.Unlock()
+
+	perClass := map[string]int{}
+	bs, ok := bsm.entries[blkid]
+	if !ok {
+		return perClass
+	}
+	counted := map[string]bool{}
+	for _, r := range bs.Replicas {
+		if r.KeepMount.DeviceID != "" {
+			if counted[r.KeepMount.DeviceID] {
+				continue
+			}
+			counted[r.KeepMount.DeviceID] = true
+		}
+		classes := defaultClasses
+		if len(r.KeepMount.StorageClasses) > 0 {
+			classes = nil
+			for class := range r.KeepMount.StorageClasses {
+				classes = append(classes, class)
+			}
+		}
+		for _, class := range classes {
+			perClass[class] += r.KeepMount.Replication
+		}
+	}
+	return perClass
+}
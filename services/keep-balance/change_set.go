@@ -18,6 +18,15 @@ type Pull struct {
 	arvados.SizedDigest
 	From *KeepService
 	To   *KeepMount
+
+	// FromMount is the mount where the block was found on the
+	// From server (for reporting only; keepstore decides which
+	// of its mounts to read from).
+	FromMount *KeepMount
+
+	// Reason is a short explanation of why the pull is needed
+	// (for reporting only; it is not sent to keepstore).
+	Reason string
 }
 
 // MarshalJSON formats a pull request the way keepstore wants to see
@@ -29,7 +38,7 @@ func (p Pull) MarshalJSON() ([]byte, error) {
 		MountUUID string   `json:"mount_uuid"`
 	}
 	return json.Marshal(KeepstorePullRequest{
-		Locator:   string(p.SizedDigest[:32]),
+		Locator:   p.SizedDigest.Hash(),
 		Servers:   []string{p.From.URLBase()},
 		MountUUID: p.To.KeepMount.UUID,
 	})
@@ -40,6 +49,10 @@ type Trash struct {
 	arvados.SizedDigest
 	Mtime int64
 	From  *KeepMount
+
+	// Reason is a short explanation of why the replica is not
+	// needed (for reporting only; it is not sent to keepstore).
+	Reason string
 }
 
 // MarshalJSON formats a trash request the way keepstore wants to see
@@ -51,12 +64,30 @@ func (t Trash) MarshalJSON() ([]byte, error) {
 		MountUUID  string `json:"mount_uuid"`
 	}
 	return json.Marshal(KeepstoreTrashRequest{
-		Locator:    string(t.SizedDigest[:32]),
+		Locator:    t.SizedDigest.Hash(),
 		BlockMtime: t.Mtime,
 		MountUUID:  t.From.KeepMount.UUID,
 	})
 }
 
+// Reasons for pull and trash requests.
+const (
+	// Block has fewer replicas than desired in some storage
+	// class.
+	reasonUnderreplicated = "underreplicated"
+	// Block has enough replicas, but some are not in optimal
+	// rendezvous positions.
+	reasonRendezvous = "rendezvous"
+	// Block is not referenced by any collection (or only by
+	// collections with replication_desired=0).
+	reasonUnreferenced = "unreferenced"
+	// Replica is on a mount that doesn't provide any of the
+	// block's desired storage classes.
+	reasonStorageClass = "storage-class"
+	// Block has more replicas than desired.
+	reasonOverreplicated = "overreplicated"
+)
+
 // ChangeSet is a set of change requests that will be sent to a
 // keepstore server.
 type ChangeSet struct {
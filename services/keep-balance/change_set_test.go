@@ -42,3 +42,30 @@ func (s *changeSetSuite) TestJSONFormat(c *check.C) {
 	c.Check(err, check.IsNil)
 	c.Check(string(buf), check.Equals, `[{"locator":"acbd18db4cc2f85cedef654fccc4a4d8","block_mtime":123456789,"mount_uuid":"zzzzz-mount-abcdefghijklmno"}]`)
 }
+
+func (s *changeSetSuite) TestJSONFormatSHA256(c *check.C) {
+	mnt := &KeepMount{
+		KeepMount: arvados.KeepMount{
+			UUID: "zzzzz-mount-abcdefghijklmno"}}
+	srv := &KeepService{
+		KeepService: arvados.KeepService{
+			UUID:           "zzzzz-bi6l4-000000000000001",
+			ServiceType:    "disk",
+			ServiceSSLFlag: false,
+			ServiceHost:    "keep1.zzzzz.arvadosapi.com",
+			ServicePort:    25107}}
+
+	buf, err := json.Marshal([]Pull{{
+		SizedDigest: arvados.SizedDigest("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3"),
+		To:          mnt,
+		From:        srv}})
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, `[{"locator":"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae","servers":["http://keep1.zzzzz.arvadosapi.com:25107"],"mount_uuid":"zzzzz-mount-abcdefghijklmno"}]`)
+
+	buf, err = json.Marshal([]Trash{{
+		SizedDigest: arvados.SizedDigest("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae+3"),
+		From:        mnt,
+		Mtime:       123456789}})
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, `[{"locator":"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae","block_mtime":123456789,"mount_uuid":"zzzzz-mount-abcdefghijklmno"}]`)
+}
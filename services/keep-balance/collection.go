@@ -6,7 +6,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
@@ -65,7 +67,7 @@ func EachCollection(ctx context.Context, c *arvados.Client, pageSize int, f func
 		Limit:              &limit,
 		Order:              "modified_at, uuid",
 		Count:              "none",
-		Select:             []string{"uuid", "unsigned_manifest_text", "modified_at", "portable_data_hash", "replication_desired"},
+		Select:             []string{"uuid", "unsigned_manifest_text", "modified_at", "portable_data_hash", "replication_desired", "replication_confirmed", "storage_classes_desired", "storage_classes_confirmed", "current_version_uuid"},
 		IncludeTrash:       true,
 		IncludeOldVersions: true,
 	}
@@ -165,3 +167,117 @@ func EachCollection(ctx context.Context, c *arvados.Client, pageSize int, f func
 
 	return nil
 }
+
+var errUpdateDone = errors.New("done updating collections")
+
+// updateCollections sets replication_confirmed and
+// storage_classes_confirmed on each collection whose current values
+// differ from the state reported by keepstore indexes during this
+// run.
+//
+// Collections modified after threshold (i.e., after we started
+// retrieving the current state) are skipped, because they might
+// reference blocks we don't know about. Old collection versions are
+// skipped, because they can't be updated.
+//
+// No more than cluster.Collections.BalanceUpdateLimit collections
+// are updated (if it is non-zero).
+func (bal *Balancer) updateCollections(ctx context.Context, c *arvados.Client, cluster *arvados.Cluster, threshold time.Time) error {
+	defer bal.time("update_collections", "wall clock time to update collections")()
+	limit := cluster.Collections.BalanceUpdateLimit
+	updated, unchanged := 0, 0
+	err := EachCollection(ctx, c, cluster.Collections.BalanceCollectionBatch, func(coll arvados.Collection) error {
+		if coll.ModifiedAt.After(threshold) {
+			return errUpdateDone
+		}
+		if coll.CurrentVersionUUID != "" && coll.CurrentVersionUUID != coll.UUID {
+			return nil
+		}
+		if limit > 0 && updated >= limit {
+			return errUpdateDone
+		}
+		blkids, err := coll.SizedDigests()
+		if err != nil {
+			return fmt.Errorf("%v: %v", coll.UUID, err)
+		}
+		repl, classes := bal.confirmedState(coll, blkids)
+		if coll.ReplicationConfirmed != nil && *coll.ReplicationConfirmed == repl && stringsEqual(coll.StorageClassesConfirmed, classes) {
+			unchanged++
+			return nil
+		}
+		now := time.Now()
+		err = c.RequestAndDecodeContext(ctx, nil, "PATCH", "arvados/v1/collections/"+coll.UUID, nil, map[string]interface{}{
+			"collection": map[string]interface{}{
+				"replication_confirmed":        repl,
+				"replication_confirmed_at":     now,
+				"storage_classes_confirmed":    classes,
+				"storage_classes_confirmed_at": now,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("%v: error updating confirmed replication: %v", coll.UUID, err)
+		}
+		updated++
+		return nil
+	}, nil)
+	if err == errUpdateDone {
+		err = nil
+	}
+	bal.logf("updated confirmed replication on %d collections (%d unchanged)", updated, unchanged)
+	if limit > 0 && updated >= limit {
+		bal.logf("reached BalanceUpdateLimit (%d); remaining collections will be updated in a subsequent run", limit)
+	}
+	return err
+}
+
+// confirmedState returns the replication_confirmed and
+// storage_classes_confirmed values for a collection with the given
+// blocks: the lowest replication level of any block in any desired
+// storage class, and the desired storage classes in which every block
+// has at least the desired number of replicas.
+func (bal *Balancer) confirmedState(coll arvados.Collection, blkids []arvados.SizedDigest) (int, []string) {
+	want := bal.DefaultReplication
+	if coll.ReplicationDesired != nil {
+		want = *coll.ReplicationDesired
+	}
+	desired := coll.StorageClassesDesired
+	if len(desired) == 0 {
+		desired = defaultClasses
+	}
+	if len(blkids) == 0 {
+		// Vacuously satisfied.
+		return want, append([]string(nil), desired...)
+	}
+	confirmed := bal.BlockStateMap.GetConfirmedReplication(blkids, desired)
+	repl := -1
+	classes := []string{}
+	for _, class := range desired {
+		n := confirmed[class]
+		if repl < 0 || n < repl {
+			repl = n
+		}
+		if n >= want {
+			classes = append(classes, class)
+		}
+	}
+	sort.Strings(classes)
+	return repl, classes
+}
+
+// stringsEqual returns true if a and b contain the same strings,
+// regardless of order.
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// reportEntry is one line of the JSON-lines report written by
+// writeReport.
+type reportEntry struct {
+	Op          string   `json:"op"` // "pull" or "trash"
+	Block       string   `json:"block"`
+	Reason      string   `json:"reason"`
+	FromService string   `json:"from_service,omitempty"`
+	FromMount   string   `json:"from_mount,omitempty"`
+	ToService   string   `json:"to_service,omitempty"`
+	ToMount     string   `json:"to_mount,omitempty"`
+	Mtime       int64    `json:"mtime,omitempty"`
+	Collections []string `json:"collections"`
+}
+
+// writeReport writes every pull and trash request in the computed
+// change sets to bal.ReportFile, one JSON object per line, including
+// the UUIDs of the collections that reference each affected block.
+//
+// It should not be called until ComputeChangeSets has finished.
+//
+// Finding the referring collections requires another pass through
+// all collections, but only the UUIDs of collections that reference
+// affected blocks are kept in memory.
+func (bal *Balancer) writeReport(ctx context.Context, c *arvados.Client, cluster *arvados.Cluster) error {
+	defer bal.time("report", "wall clock time to write report")()
+
+	colls := map[arvados.SizedDigest][]string{}
+	for _, srv := range bal.KeepServices {
+		for _, pull := range srv.ChangeSet.Pulls {
+			colls[pull.SizedDigest] = nil
+		}
+		for _, trash := range srv.ChangeSet.Trashes {
+			colls[trash.SizedDigest] = nil
+		}
+	}
+	if len(colls) > 0 {
+		err := EachCollection(ctx, c, cluster.Collections.BalanceCollectionBatch, func(coll arvados.Collection) error {
+			blkids, err := coll.SizedDigests()
+			if err != nil {
+				return fmt.Errorf("%v: %v", coll.UUID, err)
+			}
+			for _, blkid := range blkids {
+				if uuids, ok := colls[blkid]; ok && (len(uuids) == 0 || uuids[len(uuids)-1] != coll.UUID) {
+					colls[blkid] = append(uuids, coll.UUID)
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	var entries []reportEntry
+	for _, srv := range bal.KeepServices {
+		for _, pull := range srv.ChangeSet.Pulls {
+			entries = append(entries, reportEntry{
+				Op:          "pull",
+				Block:       string(pull.SizedDigest),
+				Reason:      pull.Reason,
+				FromService: pull.From.UUID,
+				FromMount:   pull.FromMount.UUID,
+				ToService:   srv.UUID,
+				ToMount:     pull.To.UUID,
+				Collections: colls[pull.SizedDigest],
+			})
+		}
+		for _, trash := range srv.ChangeSet.Trashes {
+			entries = append(entries, reportEntry{
+				Op:          "trash",
+				Block:       string(trash.SizedDigest),
+				Reason:      trash.Reason,
+				FromService: srv.UUID,
+				FromMount:   trash.From.UUID,
+				Mtime:       trash.Mtime,
+				Collections: colls[trash.SizedDigest],
+			})
+		}
+	}
+	// Sort by block, so all changes to a given block are
+	// together, and the output is stable from one run to the
+	// next.
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Block != b.Block {
+			return a.Block < b.Block
+		} else if a.Op != b.Op {
+			return a.Op < b.Op
+		}
+		return a.FromMount+a.ToMount < b.FromMount+b.ToMount
+	})
+
+	tmpfn := bal.ReportFile + ".tmp"
+	f, err := os.OpenFile(tmpfn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfn)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, ent := range entries {
+		if ent.Collections == nil {
+			ent.Collections = []string{}
+		}
+		err = enc.Encode(ent)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpfn, bal.ReportFile)
+	if err != nil {
+		return err
+	}
+	bal.logf("wrote %d pull/trash requests to report file %s", len(entries), bal.ReportFile)
+	return nil
+}
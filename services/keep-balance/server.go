@@ -29,6 +29,17 @@ type RunOptions struct {
 	Logger      logrus.FieldLogger
 	Dumper      logrus.FieldLogger
 
+	// Update replication_confirmed and storage_classes_confirmed
+	// on collections to reflect the current state of the keep
+	// services.
+	CommitConfirmedFields bool
+
+	// If non-empty, write a JSON-lines report of all pull and
+	// trash requests to this file. Combined with CommitPulls and
+	// CommitTrash set to false, this makes it possible to review
+	// the changes keep-balance would make before committing them.
+	ReportFile string
+
 	// SafeRendezvousState from the most recent balance operation,
 	// or "" if unknown. If this changes from one run to the next,
 	// we need to watch out for races. See
@@ -79,6 +90,7 @@ func (srv *Server) runOnce() (*Balancer, error) {
 		Dumper:         srv.Dumper,
 		Metrics:        srv.Metrics,
 		LostBlocksFile: srv.Cluster.Collections.BlobMissingReport,
+		ReportFile:     srv.RunOptions.ReportFile,
 	}
 	var err error
 	srv.RunOptions, err = bal.Run(srv.ArvClient, srv.Cluster, srv.RunOptions)
@@ -5,11 +5,13 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -17,7 +19,6 @@ import (
 	"strings"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
-	"git.arvados.org/arvados.git/sdk/go/ctxlog"
 	"github.com/AdRoll/goamz/s3"
 )
 
@@ -67,6 +68,10 @@ func (h *handler) serveS3(w http.ResponseWriter, r *http.Request) bool {
 
 	objectNameGiven := strings.Count(strings.TrimSuffix(r.URL.Path, "/"), "/") > 1
 
+	if h.s3multipart(w, r, fs, client, kc) {
+		return true
+	}
+
 	switch {
 	case r.Method == http.MethodGet && !objectNameGiven:
 		// Path is "/{uuid}" or "/{uuid}/", has no object name
@@ -115,6 +120,11 @@ func (h *handler) serveS3(w http.ResponseWriter, r *http.Request) bool {
 			http.Error(w, "missing object name in PUT request", http.StatusBadRequest)
 			return true
 		}
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			// CopyObject
+			h.s3copy(w, r, fs, src)
+			return true
+		}
 		fspath := "by_id" + r.URL.Path
 		var objectIsDir bool
 		if strings.HasSuffix(fspath, "/") {
@@ -150,28 +160,9 @@ func (h *handler) serveS3(w http.ResponseWriter, r *http.Request) bool {
 			http.Error(w, "object name conflicts with existing object", http.StatusBadRequest)
 			return true
 		}
-		// create missing parent/intermediate directories, if any
-		for i, c := range fspath {
-			if i > 0 && c == '/' {
-				dir := fspath[:i]
-				if strings.HasSuffix(dir, "/") {
-					err = errors.New("invalid object name (consecutive '/' chars)")
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return true
-				}
-				err = fs.Mkdir(dir, 0755)
-				if err == arvados.ErrInvalidArgument {
-					// Cannot create a directory
-					// here.
-					err = fmt.Errorf("mkdir %q failed: %w", dir, err)
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return true
-				} else if err != nil && !os.IsExist(err) {
-					err = fmt.Errorf("mkdir %q failed: %w", dir, err)
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return true
-				}
-			}
+		if status, err := s3mkdirParents(fs, fspath); err != nil {
+			http.Error(w, err.Error(), status)
+			return true
 		}
 		if !objectIsDir {
 			f, err := fs.OpenFile(fspath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
@@ -205,51 +196,31 @@ func (h *handler) serveS3(w http.ResponseWriter, r *http.Request) bool {
 		}
 		w.WriteHeader(http.StatusOK)
 		return true
+	case r.Method == http.MethodPost && !objectNameGiven:
+		if _, ok := r.URL.Query()["delete"]; !ok {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return true
+		}
+		// DeleteObjects
+		h.s3deleteObjects(w, r, fs)
+		return true
 	case r.Method == http.MethodDelete:
 		if !objectNameGiven || r.URL.Path == "/" {
 			http.Error(w, "missing object name in DELETE request", http.StatusBadRequest)
 			return true
 		}
-		fspath := "by_id" + r.URL.Path
-		if strings.HasSuffix(fspath, "/") {
-			fspath = strings.TrimSuffix(fspath, "/")
-			fi, err := fs.Stat(fspath)
-			if os.IsNotExist(err) {
-				w.WriteHeader(http.StatusNoContent)
-				return true
-			} else if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return true
-			} else if !fi.IsDir() {
-				// if "foo" exists and is a file, then
-				// "foo/" doesn't exist, so we say
-				// delete was successful.
-				w.WriteHeader(http.StatusNoContent)
-				return true
-			}
-		} else if fi, err := fs.Stat(fspath); err == nil && fi.IsDir() {
-			// if "foo" is a dir, it is visible via S3
-			// only as "foo/", not "foo" -- so we leave
-			// the dir alone and return 204 to indicate
-			// that "foo" does not exist.
-			w.WriteHeader(http.StatusNoContent)
-			return true
-		}
-		err = fs.Remove(fspath)
-		if os.IsNotExist(err) {
-			w.WriteHeader(http.StatusNoContent)
-			return true
-		}
+		removed, status, err := s3removeObject(fs, "by_id"+r.URL.Path)
 		if err != nil {
-			err = fmt.Errorf("rm failed: %w", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), status)
 			return true
 		}
-		err = fs.Sync()
-		if err != nil {
-			err = fmt.Errorf("sync failed: %w", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return true
+		if removed {
+			err = fs.Sync()
+			if err != nil {
+				err = fmt.Errorf("sync failed: %w", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return true
+			}
 		}
 		w.WriteHeader(http.StatusNoContent)
 		return true
@@ -259,6 +230,62 @@ func (h *handler) serveS3(w http.ResponseWriter, r *http.Request) bool {
 	}
 }
 
+// s3removeObject removes the object at fspath, if it exists. The
+// returned bool indicates whether anything was removed (in which case
+// the caller should sync fs). If removal fails, s3removeObject
+// returns an error and a suitable HTTP status code.
+func s3removeObject(fs arvados.CustomFileSystem, fspath string) (bool, int, error) {
+	if strings.HasSuffix(fspath, "/") {
+		fspath = strings.TrimSuffix(fspath, "/")
+		fi, err := fs.Stat(fspath)
+		if os.IsNotExist(err) {
+			return false, 0, nil
+		} else if err != nil {
+			return false, http.StatusInternalServerError, err
+		} else if !fi.IsDir() {
+			// if "foo" exists and is a file, then
+			// "foo/" doesn't exist, so we say
+			// delete was successful.
+			return false, 0, nil
+		}
+	} else if fi, err := fs.Stat(fspath); err == nil && fi.IsDir() {
+		// if "foo" is a dir, it is visible via S3
+		// only as "foo/", not "foo" -- so we leave
+		// the dir alone and report that "foo" does
+		// not exist.
+		return false, 0, nil
+	}
+	err := fs.Remove(fspath)
+	if os.IsNotExist(err) {
+		return false, 0, nil
+	} else if err != nil {
+		return false, http.StatusBadRequest, fmt.Errorf("rm failed: %w", err)
+	}
+	return true, 0, nil
+}
+
+// s3mkdirParents creates missing parent/intermediate directories of
+// fspath, if any. If that fails, it returns an error and a suitable
+// HTTP status code.
+func s3mkdirParents(fs arvados.CustomFileSystem, fspath string) (int, error) {
+	for i, c := range fspath {
+		if i > 0 && c == '/' {
+			dir := fspath[:i]
+			if strings.HasSuffix(dir, "/") {
+				return http.StatusBadRequest, errors.New("invalid object name (consecutive '/' chars)")
+			}
+			err := fs.Mkdir(dir, 0755)
+			if err == arvados.ErrInvalidArgument {
+				// Cannot create a directory here.
+				return http.StatusBadRequest, fmt.Errorf("mkdir %q failed: %w", dir, err)
+			} else if err != nil && !os.IsExist(err) {
+				return http.StatusInternalServerError, fmt.Errorf("mkdir %q failed: %w", dir, err)
+			}
+		}
+	}
+	return 0, nil
+}
+
 // Call fn on the given path (directory) and its contents, in
 // lexicographic order.
 //
@@ -317,15 +344,33 @@ var errDone = errors.New("done")
 
 func (h *handler) s3list(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem) {
 	var params struct {
-		bucket    string
-		delimiter string
-		marker    string
-		maxKeys   int
-		prefix    string
+		v2                bool
+		bucket            string
+		delimiter         string
+		marker            string
+		continuationToken string
+		startAfter        string
+		maxKeys           int
+		prefix            string
 	}
 	params.bucket = strings.SplitN(r.URL.Path[1:], "/", 2)[0]
 	params.delimiter = r.FormValue("delimiter")
-	params.marker = r.FormValue("marker")
+	if r.FormValue("list-type") == "2" {
+		// ListObjectsV2: the continuation token is an
+		// opaque encoding of the next key to return, and
+		// start-after is exclusive.
+		params.v2 = true
+		params.continuationToken = r.FormValue("continuation-token")
+		marker, err := base64.StdEncoding.DecodeString(params.continuationToken)
+		if err != nil {
+			http.Error(w, "invalid continuation token", http.StatusBadRequest)
+			return
+		}
+		params.marker = string(marker)
+		params.startAfter = r.FormValue("start-after")
+	} else {
+		params.marker = r.FormValue("marker")
+	}
 	if mk, _ := strconv.ParseInt(r.FormValue("max-keys"), 10, 64); mk > 0 && mk < s3MaxKeys {
 		params.maxKeys = int(mk)
 	} else {
@@ -374,6 +419,7 @@ func (h *handler) s3list(w http.ResponseWriter, r *http.Request, fs arvados.Cust
 		},
 	}
 	commonPrefixes := map[string]bool{}
+	nextMarker := ""
 	err := walkFS(fs, strings.TrimSuffix(bucketdir+"/"+walkpath, "/"), true, func(path string, fi os.FileInfo) error {
 		if path == bucketdir {
 			return nil
@@ -412,7 +458,7 @@ func (h *handler) s3list(w http.ResponseWriter, r *http.Request, fs arvados.Cust
 				return errDone
 			}
 		}
-		if path < params.marker || path < params.prefix {
+		if path < params.marker || path < params.prefix || path <= params.startAfter {
 			return nil
 		}
 		if fi.IsDir() && !h.Config.cluster.Collections.S3FolderObjects {
@@ -436,9 +482,7 @@ func (h *handler) s3list(w http.ResponseWriter, r *http.Request, fs arvados.Cust
 		}
 		if len(resp.Contents)+len(commonPrefixes) >= params.maxKeys {
 			resp.IsTruncated = true
-			if params.delimiter != "" {
-				resp.NextMarker = path
-			}
+			nextMarker = path
 			return errDone
 		}
 		resp.Contents = append(resp.Contents, s3.Key{
@@ -459,9 +503,169 @@ func (h *handler) s3list(w http.ResponseWriter, r *http.Request, fs arvados.Cust
 		}
 		sort.Slice(resp.CommonPrefixes, func(i, j int) bool { return resp.CommonPrefixes[i].Prefix < resp.CommonPrefixes[j].Prefix })
 	}
-	w.Header().Set("Content-Type", "application/xml")
-	io.WriteString(w, xml.Header)
-	if err := xml.NewEncoder(w).Encode(resp); err != nil {
-		ctxlog.FromContext(r.Context()).WithError(err).Error("error writing xml response")
+	if !params.v2 {
+		if params.delimiter != "" {
+			resp.NextMarker = nextMarker
+		}
+		s3writeXML(w, r, resp)
+		return
+	}
+	respV2 := struct {
+		XMLName               string `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string
+		MaxKeys               int
+		KeyCount              int
+		IsTruncated           bool
+		ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+		StartAfter            string `xml:"StartAfter,omitempty"`
+		Contents              []s3.Key
+		CommonPrefixes        []commonPrefix
+	}{
+		Name:              resp.Name,
+		Prefix:            resp.Prefix,
+		Delimiter:         resp.Delimiter,
+		MaxKeys:           resp.MaxKeys,
+		KeyCount:          len(resp.Contents) + len(resp.CommonPrefixes),
+		IsTruncated:       resp.IsTruncated,
+		ContinuationToken: params.continuationToken,
+		StartAfter:        params.startAfter,
+		Contents:          resp.Contents,
+		CommonPrefixes:    resp.CommonPrefixes,
+	}
+	if resp.IsTruncated {
+		respV2.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(nextMarker))
+	}
+	s3writeXML(w, r, respV2)
+}
+
+// s3copy handles a CopyObject request. The new object refers to the
+// same stored data blocks as the source object, so no file data is
+// transferred, even when copying between collections.
+func (h *handler) s3copy(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem, src string) {
+	src, err := url.PathUnescape(strings.SplitN(src, "?", 2)[0])
+	if err != nil {
+		http.Error(w, "invalid copy source: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	srcpath := "by_id/" + strings.TrimPrefix(src, "/")
+	dstpath := "by_id" + r.URL.Path
+	if strings.HasSuffix(srcpath, "/") || strings.HasSuffix(dstpath, "/") {
+		http.Error(w, "cannot copy folder objects", http.StatusBadRequest)
+		return
+	}
+	fi, err := fs.Stat(srcpath)
+	if os.IsNotExist(err) ||
+		(err != nil && err.Error() == "not a directory") ||
+		(fi != nil && fi.IsDir()) {
+		http.Error(w, "copy source not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if status, err := s3mkdirParents(fs, dstpath); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	err = arvados.Concat(fs, dstpath, srcpath)
+	if err != nil {
+		err = fmt.Errorf("copy to %q failed: %w", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = s3syncCollection(fs, dstpath)
+	if err != nil {
+		err = fmt.Errorf("sync failed: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fi, err = fs.Stat(dstpath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s3writeXML(w, r, struct {
+		XMLName      string `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+		LastModified string
+	}{
+		LastModified: fi.ModTime().UTC().Format("2006-01-02T15:04:05.999") + "Z",
+	})
+}
+
+// s3syncCollection saves changes to the collection containing
+// fspath. Unlike fs.Sync(), it doesn't try to save other collections
+// that have been loaded into fs (e.g., the source of a copy), which
+// the client might not have permission to update.
+func s3syncCollection(fs arvados.CustomFileSystem, fspath string) error {
+	f, err := fs.OpenFile(fspath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// s3deleteObjects handles a DeleteObjects request.
+func (h *handler) s3deleteObjects(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem) {
+	var req struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	err := xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "MalformedXML: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Objects) > s3MaxKeys {
+		http.Error(w, fmt.Sprintf("MalformedXML: cannot delete more than %d objects", s3MaxKeys), http.StatusBadRequest)
+		return
+	}
+	type deleted struct {
+		Key string
+	}
+	type deleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+	var resp struct {
+		XMLName string `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+		Deleted []deleted
+		Error   []deleteError
+	}
+	bucket := strings.SplitN(r.URL.Path[1:], "/", 2)[0]
+	anyRemoved := false
+	for _, obj := range req.Objects {
+		if obj.Key == "" {
+			resp.Error = append(resp.Error, deleteError{Key: obj.Key, Code: "InvalidArgument", Message: "missing object name"})
+			continue
+		}
+		removed, status, err := s3removeObject(fs, "by_id/"+bucket+"/"+obj.Key)
+		if err != nil {
+			code := "InternalError"
+			if status == http.StatusBadRequest {
+				code = "InvalidArgument"
+			}
+			resp.Error = append(resp.Error, deleteError{Key: obj.Key, Code: code, Message: err.Error()})
+			continue
+		}
+		anyRemoved = anyRemoved || removed
+		if !req.Quiet {
+			resp.Deleted = append(resp.Deleted, deleted{Key: obj.Key})
+		}
+	}
+	if anyRemoved {
+		err = fs.Sync()
+		if err != nil {
+			err = fmt.Errorf("sync failed: %w", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+	s3writeXML(w, r, resp)
 }
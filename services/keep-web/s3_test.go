@@ -6,11 +6,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/md5"
 	"crypto/rand"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -221,6 +225,170 @@ func (s *IntegrationSuite) testS3PutObjectSuccess(c *check.C, bucket *s3.Bucket,
 	}
 }
 
+func (s *IntegrationSuite) TestS3CollectionMultipartUpload(c *check.C) {
+	stage := s.s3setup(c)
+	defer stage.teardown(c)
+	s.testS3MultipartUpload(c, stage.collbucket, "")
+}
+func (s *IntegrationSuite) TestS3ProjectMultipartUpload(c *check.C) {
+	stage := s.s3setup(c)
+	defer stage.teardown(c)
+	s.testS3MultipartUpload(c, stage.projbucket, stage.coll.Name+"/")
+}
+func (s *IntegrationSuite) testS3MultipartUpload(c *check.C, bucket *s3.Bucket, prefix string) {
+	objname := prefix + "newdir/multipart"
+	multi, err := bucket.InitMulti(objname, "application/octet-stream", s3.Private, s3.Options{})
+	c.Assert(err, check.IsNil)
+	c.Check(multi.UploadId, check.Matches, `zzzzz-4zz18-.*`)
+
+	var want []byte
+	var wg sync.WaitGroup
+	for n, size := range []int{1 << 20, 3 << 20, 12345} {
+		buf := make([]byte, size)
+		rand.Read(buf)
+		want = append(want, buf...)
+		wg.Add(1)
+		go func(n int, buf []byte) {
+			defer wg.Done()
+			part, err := multi.PutPart(n, bytes.NewReader(buf))
+			c.Check(err, check.IsNil)
+			c.Check(part.ETag, check.Equals, fmt.Sprintf(`"%x"`, md5.Sum(buf)))
+		}(n+1, buf)
+	}
+	wg.Wait()
+
+	// Re-uploading a part replaces the earlier upload
+	buf := make([]byte, 12345)
+	rand.Read(buf)
+	want = append(want[:len(want)-len(buf)], buf...)
+	part, err := multi.PutPart(3, bytes.NewReader(buf))
+	c.Assert(err, check.IsNil)
+
+	listed, err := multi.ListParts()
+	c.Assert(err, check.IsNil)
+	c.Assert(listed, check.HasLen, 3)
+	for i, part := range listed {
+		c.Check(part.N, check.Equals, i+1)
+	}
+	c.Check(listed[2].ETag, check.Equals, part.ETag)
+	c.Check(listed[2].Size, check.Equals, int64(12345))
+
+	_, err = bucket.GetReader(objname)
+	c.Check(err, check.ErrorMatches, `404 Not Found`)
+
+	// Stale ETag is rejected
+	err = multi.Complete([]s3.Part{{N: 1, ETag: `"00000000000000000000000000000000"`}})
+	c.Check(err, check.NotNil)
+
+	err = multi.Complete(listed)
+	c.Assert(err, check.IsNil)
+
+	rdr, err := bucket.GetReader(objname)
+	c.Assert(err, check.IsNil)
+	got, err := ioutil.ReadAll(rdr)
+	c.Check(err, check.IsNil)
+	c.Check(got, check.HasLen, len(want))
+	c.Check(bytes.Equal(got, want), check.Equals, true)
+
+	// Upload and part collections are gone
+	_, err = multi.ListParts()
+	c.Check(err, check.ErrorMatches, `.*404.*`)
+}
+
+func (s *IntegrationSuite) TestS3AbortMultipartUpload(c *check.C) {
+	stage := s.s3setup(c)
+	defer stage.teardown(c)
+	bucket := stage.collbucket
+
+	multi, err := bucket.InitMulti("aborted", "application/octet-stream", s3.Private, s3.Options{})
+	c.Assert(err, check.IsNil)
+	_, err = multi.PutPart(1, bytes.NewReader([]byte("foo")))
+	c.Assert(err, check.IsNil)
+
+	// Upload ID doesn't work with a different object name
+	other := *multi
+	other.Key = "other"
+	_, err = other.ListParts()
+	c.Check(err, check.ErrorMatches, `.*404.*`)
+
+	err = multi.Abort()
+	c.Check(err, check.IsNil)
+	_, err = multi.PutPart(2, bytes.NewReader([]byte("bar")))
+	c.Check(err, check.ErrorMatches, `.*404.*`)
+	_, err = bucket.GetReader("aborted")
+	c.Check(err, check.ErrorMatches, `404 Not Found`)
+}
+
+func (s *IntegrationSuite) TestS3CopyObject(c *check.C) {
+	stage := s.s3setup(c)
+	defer stage.teardown(c)
+
+	var dst arvados.Collection
+	err := stage.arv.RequestAndDecode(&dst, "POST", "arvados/v1/collections", nil, map[string]interface{}{"collection": map[string]interface{}{
+		"owner_uuid": stage.proj.UUID,
+		"name":       "keep-web s3 copy test collection",
+	}})
+	c.Assert(err, check.IsNil)
+
+	for _, trial := range []struct {
+		bucket *s3.Bucket
+		dst    string
+		src    string
+	}{
+		{stage.collbucket, "copy/sailboat.txt", stage.coll.UUID + "/sailboat.txt"},
+		{stage.collbucket, "sailboat copy.txt", "/" + stage.coll.UUID + "/sailboat.txt"},
+		{stage.projbucket, dst.Name + "/sailboat.txt", stage.coll.UUID + "/sailboat.txt"},
+		{stage.projbucket, dst.Name + "/emptyfile", stage.proj.UUID + "/" + stage.coll.Name + "/emptyfile"},
+	} {
+		c.Logf("=== %v", trial)
+		_, err := trial.bucket.PutCopy(trial.dst, s3.Private, s3.CopyOptions{}, trial.src)
+		c.Check(err, check.IsNil)
+		srcbuf, err := stage.collbucket.Get(strings.TrimPrefix(strings.TrimPrefix(trial.src, "/"), stage.coll.UUID+"/"))
+		if strings.HasPrefix(trial.src, stage.proj.UUID) {
+			srcbuf, err = stage.projbucket.Get(strings.TrimPrefix(trial.src, stage.proj.UUID+"/"))
+		}
+		c.Assert(err, check.IsNil)
+		dstbuf, err := trial.bucket.Get(trial.dst)
+		c.Check(err, check.IsNil)
+		c.Check(string(dstbuf), check.Equals, string(srcbuf))
+	}
+
+	_, err = stage.collbucket.PutCopy("copy/missing", s3.Private, s3.CopyOptions{}, stage.coll.UUID+"/missing")
+	c.Check(err, check.ErrorMatches, `.*404.*`)
+	_, err = stage.collbucket.PutCopy("copy/emptydir", s3.Private, s3.CopyOptions{}, stage.coll.UUID+"/emptydir")
+	c.Check(err, check.ErrorMatches, `.*404.*`)
+
+	// Copying between collections doesn't transfer any data: the
+	// destination manifest refers to the source block.
+	err = stage.arv.RequestAndDecode(&dst, "GET", "arvados/v1/collections/"+dst.UUID, nil, nil)
+	c.Assert(err, check.IsNil)
+	block := regexp.MustCompile(`[0-9a-f]{32}\+4`).FindString(stage.coll.ManifestText)
+	c.Assert(block, check.Not(check.Equals), "")
+	c.Check(dst.ManifestText, check.Matches, `(?ms).* `+regexp.QuoteMeta(block)+`.* 0:4:sailboat.txt\n`)
+}
+
+func (s *IntegrationSuite) TestS3DeleteObjects(c *check.C) {
+	stage := s.s3setup(c)
+	defer stage.teardown(c)
+	bucket := stage.collbucket
+
+	err := bucket.PutReader("dir/file", bytes.NewReader([]byte("foo")), 3, "application/octet-stream", s3.Private, s3.Options{})
+	c.Assert(err, check.IsNil)
+	err = bucket.DelMulti(s3.Delete{Objects: []s3.Object{
+		{Key: "emptyfile"},
+		{Key: "dir/file"},
+		{Key: "missing"},
+		{Key: "emptydir"},
+	}})
+	c.Check(err, check.IsNil)
+	for _, key := range []string{"emptyfile", "dir/file"} {
+		_, err = bucket.GetReader(key)
+		c.Check(err, check.ErrorMatches, `404 Not Found`)
+	}
+	_, err = bucket.GetReader("sailboat.txt")
+	c.Check(err, check.IsNil)
+}
+
 func (s *IntegrationSuite) TestS3ProjectPutObjectNotSupported(c *check.C) {
 	stage := s.s3setup(c)
 	defer stage.teardown(c)
@@ -432,6 +600,54 @@ func (s *IntegrationSuite) TestS3ListNoNextMarker(c *check.C) {
 	}
 }
 
+func (s *IntegrationSuite) TestS3ListObjectsV2(c *check.C) {
+	stage := s.s3setup(c)
+	defer stage.teardown(c)
+	stage.writeBigDirs(c, 1, 5)
+
+	type listV2Resp struct {
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string
+		Contents              []s3.Key
+	}
+	list := func(query string) listV2Resp {
+		req, err := http.NewRequest("GET", stage.collbucket.URL("/"), nil)
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Authorization", "AWS "+arvadostest.ActiveTokenV2+":none")
+		req.URL.RawQuery = "list-type=2&" + query
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+		var lresp listV2Resp
+		err = xml.NewDecoder(resp.Body).Decode(&lresp)
+		c.Assert(err, check.IsNil)
+		return lresp
+	}
+
+	var keys []string
+	token := ""
+	for pages := 0; ; pages++ {
+		c.Assert(pages < 10, check.Equals, true)
+		lresp := list("prefix=dir0/&max-keys=2&continuation-token=" + url.QueryEscape(token))
+		c.Check(lresp.KeyCount, check.Equals, len(lresp.Contents))
+		for _, key := range lresp.Contents {
+			keys = append(keys, key.Key)
+		}
+		if !lresp.IsTruncated {
+			c.Check(lresp.NextContinuationToken, check.Equals, "")
+			break
+		}
+		c.Check(lresp.NextContinuationToken, check.Not(check.Equals), "")
+		token = lresp.NextContinuationToken
+	}
+	c.Check(keys, check.DeepEquals, []string{"dir0/file0.txt", "dir0/file1.txt", "dir0/file2.txt", "dir0/file3.txt", "dir0/file4.txt"})
+
+	lresp := list("prefix=dir0/&start-after=dir0/file2.txt")
+	c.Assert(lresp.Contents, check.HasLen, 2)
+	c.Check(lresp.Contents[0].Key, check.Equals, "dir0/file3.txt")
+}
+
 func (s *IntegrationSuite) TestS3CollectionList(c *check.C) {
 	stage := s.s3setup(c)
 	defer stage.teardown(c)
@@ -0,0 +1,426 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+)
+
+// Multipart uploads are stored as ordinary collections in the
+// user's home project:
+//
+// The upload ID is the UUID of an empty "upload" collection whose
+// properties record the destination bucket and object name.
+//
+// Each uploaded part is a separate "part" collection containing a
+// single file. Using one collection per part lets clients upload
+// parts concurrently without clobbering one another's manifest
+// updates.
+//
+// CompleteMultipartUpload splices the parts' data segments into the
+// destination collection (without copying any data) and deletes the
+// upload and part collections. Abandoned uploads are trashed
+// automatically after s3MultipartUploadTTL.
+const (
+	s3MultipartUploadTTL   = 7 * 24 * time.Hour
+	s3MultipartPropUpload  = "s3_multipart_upload"
+	s3MultipartPropBucket  = "s3_multipart_bucket"
+	s3MultipartPropKey     = "s3_multipart_key"
+	s3MultipartPropPart    = "s3_multipart_part_number"
+	s3MultipartPropETag    = "s3_multipart_etag"
+	s3MultipartPartFile    = "part"
+	s3MultipartMaxPartNum  = 10000
+	s3MultipartMaxListSize = 1000
+)
+
+type s3multipartPart struct {
+	uuid     string
+	number   int
+	etag     string
+	size     int64
+	modified time.Time
+}
+
+// s3multipart returns true if r is a multipart upload request, after
+// handling it.
+func (h *handler) s3multipart(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem, client *arvados.Client, kc *keepclient.KeepClient) bool {
+	query := r.URL.Query()
+	_, initiate := query["uploads"]
+	uploadID := query.Get("uploadId")
+	if !initiate && uploadID == "" {
+		return false
+	}
+	bucket, key := s3splitPath(r.URL.Path)
+	if key == "" || strings.HasSuffix(key, "/") {
+		http.Error(w, "invalid object name for multipart upload", http.StatusBadRequest)
+		return true
+	}
+	switch {
+	case r.Method == http.MethodPost && initiate:
+		h.s3createMultipartUpload(w, r, client, bucket, key)
+	case r.Method == http.MethodPut && uploadID != "":
+		h.s3uploadPart(w, r, client, kc, bucket, key, uploadID)
+	case r.Method == http.MethodPost && uploadID != "":
+		h.s3completeMultipartUpload(w, r, fs, client, bucket, key, uploadID)
+	case r.Method == http.MethodDelete && uploadID != "":
+		h.s3abortMultipartUpload(w, r, client, bucket, key, uploadID)
+	case r.Method == http.MethodGet && uploadID != "":
+		h.s3listParts(w, r, client, bucket, key, uploadID)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+	return true
+}
+
+// s3splitPath splits a request path "/bucket/key" into bucket and
+// key.
+func s3splitPath(path string) (bucket, key string) {
+	split := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(split) < 2 {
+		return split[0], ""
+	}
+	return split[0], split[1]
+}
+
+func (h *handler) s3createMultipartUpload(w http.ResponseWriter, r *http.Request, client *arvados.Client, bucket, key string) {
+	var coll arvados.Collection
+	err := client.RequestAndDecodeContext(r.Context(), &coll, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"collection": map[string]interface{}{
+			"name":     fmt.Sprintf("S3 multipart upload %s/%s", bucket, key),
+			"trash_at": time.Now().Add(s3MultipartUploadTTL).UTC(),
+			"properties": map[string]interface{}{
+				s3MultipartPropBucket: bucket,
+				s3MultipartPropKey:    key,
+			},
+		},
+		"ensure_unique_name": true,
+	})
+	if err != nil {
+		http.Error(w, "create upload failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	s3writeXML(w, r, struct {
+		XMLName  string `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{
+		Bucket:   bucket,
+		Key:      key,
+		UploadId: coll.UUID,
+	})
+}
+
+// s3checkUpload returns the upload collection for the given upload
+// ID, after checking that it belongs to the given bucket and key. If
+// it doesn't exist or doesn't match, s3checkUpload sends an error
+// response and returns nil.
+func (h *handler) s3checkUpload(w http.ResponseWriter, r *http.Request, client *arvados.Client, bucket, key, uploadID string) *arvados.Collection {
+	var coll arvados.Collection
+	err := client.RequestAndDecodeContext(r.Context(), &coll, "GET", "arvados/v1/collections/"+uploadID, nil, map[string]interface{}{
+		"select": []string{"uuid", "owner_uuid", "properties"},
+	})
+	if err != nil || coll.Properties[s3MultipartPropBucket] != bucket || coll.Properties[s3MultipartPropKey] != key {
+		http.Error(w, "NoSuchUpload: specified upload does not exist", http.StatusNotFound)
+		return nil
+	}
+	return &coll
+}
+
+func (h *handler) s3uploadPart(w http.ResponseWriter, r *http.Request, client *arvados.Client, kc *keepclient.KeepClient, bucket, key, uploadID string) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > s3MultipartMaxPartNum {
+		http.Error(w, fmt.Sprintf("invalid partNumber (must be 1 to %d)", s3MultipartMaxPartNum), http.StatusBadRequest)
+		return
+	}
+	upload := h.s3checkUpload(w, r, client, bucket, key, uploadID)
+	if upload == nil {
+		return
+	}
+
+	// Write the part data to Keep using a standalone (not yet
+	// saved) collection filesystem, then save its manifest as a
+	// new part collection.
+	partfs, err := (&arvados.Collection{}).FileSystem(client, kc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := partfs.OpenFile(s3MultipartPartFile, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r.Body)
+	if err != nil {
+		f.Close()
+		http.Error(w, fmt.Sprintf("write part %d failed: %s", partNumber, err), http.StatusBadGateway)
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("write part %d failed: close: %s", partNumber, err), http.StatusBadGateway)
+		return
+	}
+	manifest, err := partfs.MarshalManifest(".")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("write part %d failed: %s", partNumber, err), http.StatusBadGateway)
+		return
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	err = client.RequestAndDecodeContext(r.Context(), nil, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"collection": map[string]interface{}{
+			"owner_uuid":    upload.OwnerUUID,
+			"name":          fmt.Sprintf("S3 multipart upload %s part %d", uploadID, partNumber),
+			"manifest_text": manifest,
+			"trash_at":      time.Now().Add(s3MultipartUploadTTL).UTC(),
+			"properties": map[string]interface{}{
+				s3MultipartPropUpload: uploadID,
+				s3MultipartPropPart:   partNumber,
+				s3MultipartPropETag:   etag,
+			},
+		},
+		"ensure_unique_name": true,
+		"select":             []string{"uuid"},
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("save part %d failed: %s", partNumber, err), http.StatusBadGateway)
+		return
+	}
+	ctxlog.FromContext(r.Context()).WithField("uploadID", uploadID).WithField("partNumber", partNumber).WithField("size", size).Debug("saved multipart upload part")
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3loadParts returns the parts that have been uploaded so far,
+// sorted by part number. If a part number was uploaded more than
+// once, only the most recent upload is returned. All uploaded part
+// collections (including superseded ones) are returned in allUUIDs.
+func (h *handler) s3loadParts(r *http.Request, client *arvados.Client, uploadID string) (parts []s3multipartPart, allUUIDs []string, err error) {
+	latest := map[int]s3multipartPart{}
+	uploadFilter := arvados.Filter{Attr: "properties." + s3MultipartPropUpload, Operator: "=", Operand: uploadID}
+	filters := []arvados.Filter{uploadFilter}
+	for {
+		var resp arvados.CollectionList
+		err = client.RequestAndDecodeContext(r.Context(), &resp, "GET", "arvados/v1/collections", nil, map[string]interface{}{
+			"filters": filters,
+			"order":   []string{"uuid"},
+			"select":  []string{"uuid", "properties", "file_size_total", "created_at"},
+			"count":   "none",
+			"limit":   s3MultipartMaxListSize,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(resp.Items) == 0 {
+			break
+		}
+		for _, coll := range resp.Items {
+			allUUIDs = append(allUUIDs, coll.UUID)
+			num, _ := coll.Properties[s3MultipartPropPart].(float64)
+			etag, _ := coll.Properties[s3MultipartPropETag].(string)
+			part := s3multipartPart{
+				uuid:     coll.UUID,
+				number:   int(num),
+				etag:     etag,
+				size:     coll.FileSizeTotal,
+				modified: coll.CreatedAt,
+			}
+			if prev, ok := latest[part.number]; !ok || prev.modified.Before(part.modified) {
+				latest[part.number] = part
+			}
+		}
+		filters = []arvados.Filter{
+			uploadFilter,
+			{Attr: "uuid", Operator: ">", Operand: resp.Items[len(resp.Items)-1].UUID},
+		}
+	}
+	for _, part := range latest {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].number < parts[j].number })
+	return parts, allUUIDs, nil
+}
+
+func (h *handler) s3listParts(w http.ResponseWriter, r *http.Request, client *arvados.Client, bucket, key, uploadID string) {
+	if h.s3checkUpload(w, r, client, bucket, key, uploadID) == nil {
+		return
+	}
+	marker, _ := strconv.Atoi(r.FormValue("part-number-marker"))
+	maxParts, _ := strconv.Atoi(r.FormValue("max-parts"))
+	if maxParts < 1 || maxParts > s3MultipartMaxListSize {
+		maxParts = s3MultipartMaxListSize
+	}
+	parts, _, err := h.s3loadParts(r, client, uploadID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	type s3part struct {
+		PartNumber   int
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	resp := struct {
+		XMLName              string `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListPartsResult"`
+		Bucket               string
+		Key                  string
+		UploadId             string
+		PartNumberMarker     int
+		NextPartNumberMarker int `xml:"NextPartNumberMarker,omitempty"`
+		MaxParts             int
+		IsTruncated          bool
+		Part                 []s3part
+	}{
+		Bucket:           bucket,
+		Key:              key,
+		UploadId:         uploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, part := range parts {
+		if part.number <= marker {
+			continue
+		}
+		if len(resp.Part) >= maxParts {
+			resp.IsTruncated = true
+			resp.NextPartNumberMarker = resp.Part[len(resp.Part)-1].PartNumber
+			break
+		}
+		resp.Part = append(resp.Part, s3part{
+			PartNumber:   part.number,
+			LastModified: part.modified.UTC().Format("2006-01-02T15:04:05.999") + "Z",
+			ETag:         `"` + part.etag + `"`,
+			Size:         part.size,
+		})
+	}
+	s3writeXML(w, r, resp)
+}
+
+func (h *handler) s3completeMultipartUpload(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem, client *arvados.Client, bucket, key, uploadID string) {
+	if h.s3checkUpload(w, r, client, bucket, key, uploadID) == nil {
+		return
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	err := xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "MalformedXML: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Parts) == 0 {
+		http.Error(w, "MalformedXML: no parts specified", http.StatusBadRequest)
+		return
+	}
+	uploaded, allUUIDs, err := h.s3loadParts(r, client, uploadID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	byNumber := map[int]s3multipartPart{}
+	for _, part := range uploaded {
+		byNumber[part.number] = part
+	}
+	var srcs []string
+	etags := md5.New()
+	for i, reqpart := range req.Parts {
+		if i > 0 && reqpart.PartNumber <= req.Parts[i-1].PartNumber {
+			http.Error(w, "InvalidPartOrder: parts must be listed in ascending order", http.StatusBadRequest)
+			return
+		}
+		part, ok := byNumber[reqpart.PartNumber]
+		if !ok || strings.Trim(reqpart.ETag, `"`) != part.etag {
+			http.Error(w, fmt.Sprintf("InvalidPart: part %d not found or ETag mismatch", reqpart.PartNumber), http.StatusBadRequest)
+			return
+		}
+		srcs = append(srcs, "by_id/"+part.uuid+"/"+s3MultipartPartFile)
+		etag, _ := hex.DecodeString(part.etag)
+		etags.Write(etag)
+	}
+
+	fspath := "by_id" + r.URL.Path
+	if status, err := s3mkdirParents(fs, fspath); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	err = arvados.Concat(fs, fspath, srcs...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("write to %q failed: %s", r.URL.Path, err), http.StatusBadRequest)
+		return
+	}
+	err = s3syncCollection(fs, fspath)
+	if err != nil {
+		err = fmt.Errorf("sync failed: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.s3deleteUpload(r, client, uploadID, allUUIDs)
+	s3writeXML(w, r, struct {
+		XMLName  string `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{
+		Location: r.URL.Path,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     fmt.Sprintf(`"%x-%d"`, etags.Sum(nil), len(req.Parts)),
+	})
+}
+
+func (h *handler) s3abortMultipartUpload(w http.ResponseWriter, r *http.Request, client *arvados.Client, bucket, key, uploadID string) {
+	if h.s3checkUpload(w, r, client, bucket, key, uploadID) == nil {
+		return
+	}
+	_, allUUIDs, err := h.s3loadParts(r, client, uploadID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	h.s3deleteUpload(r, client, uploadID, allUUIDs)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// s3deleteUpload deletes the given part collections and the upload
+// collection. Errors are logged but otherwise ignored: any leftovers
+// will be trashed automatically when their trash_at time arrives.
+func (h *handler) s3deleteUpload(r *http.Request, client *arvados.Client, uploadID string, partUUIDs []string) {
+	logger := ctxlog.FromContext(r.Context()).WithField("uploadID", uploadID)
+	for _, uuid := range append(partUUIDs, uploadID) {
+		err := client.RequestAndDecodeContext(r.Context(), nil, "DELETE", "arvados/v1/collections/"+uuid, nil, nil)
+		if err != nil {
+			logger.WithError(err).WithField("UUID", uuid).Warn("error deleting multipart upload collection")
+		}
+	}
+}
+
+func s3writeXML(w http.ResponseWriter, r *http.Request, resp interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(resp); err != nil {
+		ctxlog.FromContext(r.Context()).WithError(err).Error("error writing xml response")
+	}
+}
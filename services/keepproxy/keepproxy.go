@@ -23,6 +23,7 @@ import (
 	"git.arvados.org/arvados.git/lib/config"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"git.arvados.org/arvados.git/sdk/go/health"
 	"git.arvados.org/arvados.git/sdk/go/httpserver"
 	"git.arvados.org/arvados.git/sdk/go/keepclient"
@@ -295,17 +296,17 @@ func MakeRESTRouter(kc *keepclient.KeepClient, timeout time.Duration, mgmtToken
 		},
 	}
 
-	rest.HandleFunc(`/{locator:[0-9a-f]{32}\+.*}`, h.Get).Methods("GET", "HEAD")
-	rest.HandleFunc(`/{locator:[0-9a-f]{32}}`, h.Get).Methods("GET", "HEAD")
+	rest.HandleFunc(`/{locator:`+blockdigest.HashPattern+`\+.*}`, h.Get).Methods("GET", "HEAD")
+	rest.HandleFunc(`/{locator:`+blockdigest.HashPattern+`}`, h.Get).Methods("GET", "HEAD")
 
 	// List all blocks
 	rest.HandleFunc(`/index`, h.Index).Methods("GET")
 
 	// List blocks whose hash has the given prefix
-	rest.HandleFunc(`/index/{prefix:[0-9a-f]{0,32}}`, h.Index).Methods("GET")
+	rest.HandleFunc(`/index/{prefix:[0-9a-f]{0,64}}`, h.Index).Methods("GET")
 
-	rest.HandleFunc(`/{locator:[0-9a-f]{32}\+.*}`, h.Put).Methods("PUT")
-	rest.HandleFunc(`/{locator:[0-9a-f]{32}}`, h.Put).Methods("PUT")
+	rest.HandleFunc(`/{locator:`+blockdigest.HashPattern+`\+.*}`, h.Put).Methods("PUT")
+	rest.HandleFunc(`/{locator:`+blockdigest.HashPattern+`}`, h.Put).Methods("PUT")
 	rest.HandleFunc(`/`, h.Put).Methods("POST")
 	rest.HandleFunc(`/{any}`, h.Options).Methods("OPTIONS")
 	rest.HandleFunc(`/`, h.Options).Methods("OPTIONS")
@@ -555,7 +556,7 @@ func (h *proxyHandler) Put(resp http.ResponseWriter, req *http.Request) {
 }
 
 // ServeHTTP implementation for IndexHandler
-// Supports only GET requests for /index/{prefix:[0-9a-f]{0,32}}
+// Supports only GET requests for /index/{prefix:[0-9a-f]{0,64}}
 // For each keep server found in LocalRoots:
 //   Invokes GetIndex using keepclient
 //   Expects "complete" response (terminating with blank new line)
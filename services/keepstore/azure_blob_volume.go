@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/prometheus/client_golang/prometheus"
@@ -163,7 +164,7 @@ func (v *AzureBlobVolume) Get(ctx context.Context, loc string, buf []byte) (int,
 	var deadline time.Time
 	haveDeadline := false
 	size, err := v.get(ctx, loc, buf)
-	for err == nil && size == 0 && loc != "d41d8cd98f00b204e9800998ecf8427e" {
+	for err == nil && size == 0 && loc != blockdigest.HashAlgorithmFor(loc).Sum(nil) {
 		// Seeing a brand new empty block probably means we're
 		// in a race with CreateBlob, which under the hood
 		// (apparently) does "CreateEmpty" and "CommitData"
@@ -214,8 +215,8 @@ func (v *AzureBlobVolume) get(ctx context.Context, loc string, buf []byte) (int,
 		if err != nil {
 			return 0, v.translateError(err)
 		}
-		if props.ContentLength > int64(BlockSize) || props.ContentLength < 0 {
-			return 0, fmt.Errorf("block %s invalid size %d (max %d)", loc, props.ContentLength, BlockSize)
+		if props.ContentLength > int64(maxStoredBlockSize) || props.ContentLength < 0 {
+			return 0, fmt.Errorf("block %s invalid size %d (max %d)", loc, props.ContentLength, maxStoredBlockSize)
 		}
 		expectSize = int(props.ContentLength)
 		pieces = (expectSize + pieceSize - 1) / pieceSize
@@ -340,7 +341,7 @@ func (v *AzureBlobVolume) Compare(ctx context.Context, loc string, expect []byte
 		return v.translateError(err)
 	}
 	defer rdr.Close()
-	return compareReaderWithBuf(ctx, rdr, expect, loc[:32])
+	return compareReaderWithBuf(ctx, rdr, expect, loc)
 }
 
 // Put stores a Keep block as a block blob in the container.
@@ -563,7 +564,7 @@ func (v *AzureBlobVolume) translateError(err error) error {
 	}
 }
 
-var keepBlockRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var keepBlockRegexp = regexp.MustCompile(`^` + blockdigest.HashPattern + `$`)
 
 func (v *AzureBlobVolume) isKeepBlock(s string) bool {
 	return keepBlockRegexp.MatchString(s)
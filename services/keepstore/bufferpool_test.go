@@ -19,12 +19,13 @@ type BufferPoolSuite struct{}
 // Initialize a default-sized buffer pool for the benefit of test
 // suites that don't run main().
 func init() {
-	bufs = newBufferPool(ctxlog.FromContext(context.Background()), 12, BlockSize)
+	bufs = newBufferPool(ctxlog.FromContext(context.Background()), 12, maxStoredBlockSize)
+	encryptedBufs = newBufferPool(ctxlog.FromContext(context.Background()), 12, maxStoredBlockSize)
 }
 
 // Restore sane default after bufferpool's own tests
 func (s *BufferPoolSuite) TearDownTest(c *C) {
-	bufs = newBufferPool(ctxlog.FromContext(context.Background()), 12, BlockSize)
+	bufs = newBufferPool(ctxlog.FromContext(context.Background()), 12, maxStoredBlockSize)
 }
 
 func (s *BufferPoolSuite) TestBufferPoolBufSize(c *C) {
@@ -7,29 +7,34 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
 	"fmt"
 	"io"
+
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 )
 
-// Compute the MD5 digest of a data block (consisting of buf1 + buf2 +
-// all bytes readable from rdr). If all data is read successfully,
-// return DiskHashError or CollisionError depending on whether it
-// matches expectMD5. If an error occurs while reading, return that
-// error.
+// Compute the digest of a data block (consisting of buf1 + buf2 +
+// all bytes readable from rdr), using the hash algorithm indicated by
+// expectHash. If all data is read successfully, return DiskHashError
+// or CollisionError depending on whether it matches expectHash. If an
+// error occurs while reading, return that error.
 //
-// "content has expected MD5" is called a collision because this
+// "content has expected hash" is called a collision because this
 // function is used in cases where we have another block in hand with
-// the given MD5 but different content.
-func collisionOrCorrupt(expectMD5 string, buf1, buf2 []byte, rdr io.Reader) error {
+// the given hash but different content.
+func collisionOrCorrupt(expectHash string, buf1, buf2 []byte, rdr io.Reader) error {
+	alg := blockdigest.HashAlgorithmFor(expectHash)
+	if alg == nil {
+		return fmt.Errorf("unsupported hash %q", expectHash)
+	}
 	outcome := make(chan error)
 	data := make(chan []byte, 1)
 	go func() {
-		h := md5.New()
+		h := alg.New()
 		for b := range data {
 			h.Write(b)
 		}
-		if fmt.Sprintf("%x", h.Sum(nil)) == expectMD5 {
+		if fmt.Sprintf("%x", h.Sum(nil)) == expectHash {
 			outcome <- CollisionError
 		} else {
 			outcome <- DiskHashError
@@ -49,3 +49,19 @@ func (s *CollisionSuite) TestCollisionOrCorrupt(c *check.C) {
 	c.Check(collisionOrCorrupt(fooMD5, []byte{}, nil, iotest.TimeoutReader(iotest.OneByteReader(bytes.NewBufferString("foo")))),
 		check.Equals, iotest.ErrTimeout)
 }
+
+func (s *CollisionSuite) TestCollisionOrCorruptSHA256(c *check.C) {
+	fooSHA256 := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	c.Check(collisionOrCorrupt(fooSHA256, []byte{'f'}, []byte{'o'}, bytes.NewBufferString("o")),
+		check.Equals, CollisionError)
+	c.Check(collisionOrCorrupt(fooSHA256, []byte{'f', 'o', 'o'}, nil, nil),
+		check.Equals, CollisionError)
+	c.Check(collisionOrCorrupt(fooSHA256, []byte{'f', 'o', 'o'}, nil, bytes.NewBufferString("bar")),
+		check.Equals, DiskHashError)
+	c.Check(collisionOrCorrupt(fooSHA256, []byte{'f', 'O'}, nil, bytes.NewBufferString("o")),
+		check.Equals, DiskHashError)
+	// MD5 hashes are still checked using MD5.
+	c.Check(collisionOrCorrupt("acbd18db4cc2f85cedef654fccc4a4d8", []byte("foo"), nil, nil),
+		check.Equals, CollisionError)
+}
@@ -155,7 +155,24 @@ func (h *handler) setup(ctx context.Context, cluster *arvados.Cluster, token str
 	if h.Cluster.API.MaxKeepBlobBuffers <= 0 {
 		return fmt.Errorf("API.MaxKeepBlobBuffers must be greater than zero")
 	}
-	bufs = newBufferPool(h.Logger, h.Cluster.API.MaxKeepBlobBuffers, BlockSize)
+	nbufs := h.Cluster.API.MaxKeepBlobBuffers
+	for _, cfgvol := range h.Cluster.Volumes {
+		if _, ok := cfgvol.AccessViaHosts[serviceURL]; !ok && len(cfgvol.AccessViaHosts) > 0 {
+			continue
+		}
+		if encryptionEnabled(cfgvol) {
+			// Encrypted volumes need a second buffer for
+			// some requests. Split MaxKeepBlobBuffers
+			// evenly between bufs and encryptedBufs.
+			if nbufs < 2 {
+				return fmt.Errorf("API.MaxKeepBlobBuffers must be at least 2 when using EncryptionKeys")
+			}
+			encryptedBufs = newBufferPool(h.Logger, nbufs/2, maxStoredBlockSize)
+			nbufs -= nbufs / 2
+			break
+		}
+	}
+	bufs = newBufferPool(h.Logger, nbufs, maxStoredBlockSize)
 
 	if h.Cluster.API.MaxConcurrentRequests > 0 && h.Cluster.API.MaxConcurrentRequests < h.Cluster.API.MaxKeepBlobBuffers {
 		h.Logger.Warnf("Possible configuration mistake: not useful to set API.MaxKeepBlobBuffers (%d) higher than API.MaxConcurrentRequests (%d)", h.Cluster.API.MaxKeepBlobBuffers, h.Cluster.API.MaxConcurrentRequests)
@@ -0,0 +1,550 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+)
+
+// Each encrypted block is stored as
+//
+//	magic (4 bytes) | key ID (8 bytes) | nonce (12 bytes) | AES-GCM ciphertext and tag
+//
+// where the key ID is the first 8 bytes of the SHA-256 hash of the
+// key. The locator is used as additional authenticated data, so an
+// encrypted block can't be passed off as a different block.
+const (
+	encryptionMagic      = "AEB1"
+	encryptionKeyIDSize  = 8
+	encryptionNonceSize  = 12
+	encryptionTagSize    = 16
+	encryptionHeaderSize = len(encryptionMagic) + encryptionKeyIDSize + encryptionNonceSize
+	encryptionOverhead   = encryptionHeaderSize + encryptionTagSize
+
+	// Maximum size of a block as stored by a volume driver, which
+	// is more than BlockSize if the block is encrypted.
+	maxStoredBlockSize = BlockSize + encryptionOverhead
+
+	// Minimum time between the starts of two key rotation
+	// passes.
+	encryptionRotationInterval = 24 * time.Hour
+)
+
+var (
+	errNotEncrypted = errors.New("stored data is not an encrypted block")
+
+	errCannotRewrite     = errors.New("volume cannot replace block without updating its timestamp")
+	errMtimeChanged      = errors.New("block timestamp changed while re-encrypting")
+	errTooOldToReencrypt = errors.New("block is too old to re-encrypt on this volume")
+
+	// Buffers for encrypted blocks, which are bigger than
+	// BlockSize. These are not taken from bufs, because callers
+	// usually hold a buffer from bufs already, and waiting for a
+	// second one from the same pool could deadlock. This pool is
+	// only created if a volume uses encryption, and takes half of
+	// API.MaxKeepBlobBuffers (see handler.setup).
+	encryptedBufs *bufferPool
+)
+
+// An EncryptedVolume encrypts blocks with AES-256-GCM before storing
+// them on another volume, and decrypts them when reading.
+//
+// The first of EncryptionKeys is used to encrypt new blocks. The
+// others are only used to decrypt existing blocks. While there is
+// more than one key, an index request (i.e., a keep-balance pass)
+// starts a background pass that re-encrypts blocks that are not
+// encrypted with the first key, unless another pass started less
+// than rotationInterval ago. Once a pass finds that every block uses
+// the first key, no more passes are started.
+//
+// Locators, timestamps, and trash are not encrypted. All blocks on
+// the underlying volume must be encrypted: sizes in the index are
+// computed from the encrypted sizes, without reading the blocks.
+type EncryptedVolume struct {
+	EncryptionKeys []string
+
+	cluster *arvados.Cluster
+	volume  arvados.Volume
+	logger  logrus.FieldLogger
+	inner   Volume
+	keys    []encryptionKey
+
+	rotationInterval time.Duration
+	rotate           chan struct{}
+	stop             chan struct{}
+}
+
+type encryptionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// encryptionEnabled returns true if the volume's DriverParameters
+// specify encryption keys.
+func encryptionEnabled(volume arvados.Volume) bool {
+	var params struct{ EncryptionKeys []string }
+	json.Unmarshal(volume.DriverParameters, &params)
+	return len(params.EncryptionKeys) > 0
+}
+
+// wrapEncryptedVolume returns an EncryptedVolume that stores blocks
+// on the given volume, if the volume's DriverParameters specify
+// EncryptionKeys. Otherwise it returns the given volume itself.
+func wrapEncryptedVolume(cluster *arvados.Cluster, volume arvados.Volume, inner Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) (Volume, error) {
+	v := &EncryptedVolume{
+		cluster:          cluster,
+		volume:           volume,
+		logger:           logger,
+		inner:            inner,
+		rotationInterval: encryptionRotationInterval,
+	}
+	if len(volume.DriverParameters) > 0 {
+		err := json.Unmarshal(volume.DriverParameters, &v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(v.EncryptionKeys) == 0 {
+		return inner, nil
+	}
+	for i, src := range v.EncryptionKeys {
+		key, err := loadEncryptionKey(src)
+		if err != nil {
+			return nil, fmt.Errorf("DriverParameters.EncryptionKeys[%d]: %s", i, err)
+		}
+		v.keys = append(v.keys, key)
+	}
+	v.logger = logger.WithField("Volume", v.String())
+	v.stop = make(chan struct{})
+	if len(v.keys) > 1 && !v.volume.ReadOnly {
+		v.rotate = make(chan struct{}, 1)
+		go v.runRotation()
+	}
+	return v, nil
+}
+
+// loadEncryptionKey parses a 256-bit key given as 64 hex digits, as
+// the absolute path of a file containing 64 hex digits, or as an
+// http:// or https:// URL that returns 64 hex digits (e.g., a key
+// management service).
+func loadEncryptionKey(src string) (encryptionKey, error) {
+	if strings.HasPrefix(src, "/") {
+		buf, err := ioutil.ReadFile(src)
+		if err != nil {
+			return encryptionKey{}, err
+		}
+		src = strings.TrimSpace(string(buf))
+	} else if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		buf, err := fetchEncryptionKey(src)
+		if err != nil {
+			return encryptionKey{}, err
+		}
+		src = strings.TrimSpace(string(buf))
+	}
+	raw, err := hex.DecodeString(src)
+	if err != nil || len(raw) != 32 {
+		return encryptionKey{}, errors.New("key must be 64 hexadecimal digits, or the path or URL of a file containing 64 hexadecimal digits")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	sum := sha256.Sum256(raw)
+	return encryptionKey{id: sum[:encryptionKeyIDSize], aead: aead}, nil
+}
+
+// fetchEncryptionKey returns the response body from the given URL.
+// The URL itself is not logged or included in errors, because it
+// might contain a credential.
+func fetchEncryptionKey(keyURL string) ([]byte, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(keyURL)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("error fetching key from URL: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching key from URL: %s", resp.Status)
+	}
+	// Read a little more than a key (plus a newline), so a
+	// response that is too long is rejected without reading
+	// all of it.
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+}
+
+// shutdown stops the key rotation worker.
+func (v *EncryptedVolume) shutdown() {
+	select {
+	case <-v.stop:
+	default:
+		close(v.stop)
+	}
+}
+
+// encrypt appends the encrypted form of data to dst using the
+// current key.
+//
+// To encrypt in place, data must start at dst[encryptionHeaderSize]
+// and dst must have length 0.
+func (v *EncryptedVolume) encrypt(loc string, data, dst []byte) ([]byte, error) {
+	key := v.keys[0]
+	var nonce [encryptionNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	dst = append(dst, encryptionMagic...)
+	dst = append(dst, key.id...)
+	dst = append(dst, nonce[:]...)
+	return key.aead.Seal(dst, nonce[:], data, []byte(loc)), nil
+}
+
+// decrypt decrypts sealed in place, and returns the decrypted data
+// (which starts at sealed[encryptionHeaderSize]) and the index of
+// the key that was used.
+func (v *EncryptedVolume) decrypt(loc string, sealed []byte) ([]byte, int, error) {
+	if len(sealed) < encryptionOverhead || string(sealed[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, -1, errNotEncrypted
+	}
+	keyID := sealed[len(encryptionMagic) : len(encryptionMagic)+encryptionKeyIDSize]
+	nonce := sealed[len(encryptionMagic)+encryptionKeyIDSize : encryptionHeaderSize]
+	for i, key := range v.keys {
+		if !bytes.Equal(key.id, keyID) {
+			continue
+		}
+		ciphertext := sealed[encryptionHeaderSize:]
+		data, err := key.aead.Open(ciphertext[:0], nonce, ciphertext, []byte(loc))
+		if err != nil {
+			// Wrong data, wrong locator, or corrupt
+			// ciphertext.
+			return nil, i, DiskHashError
+		}
+		return data, i, nil
+	}
+	return nil, -1, fmt.Errorf("block is encrypted with unknown key %x", keyID)
+}
+
+// Get reads and decrypts a block.
+//
+// If buf has enough capacity for an encrypted block (which is the
+// case for buffers from bufs), the encrypted block is read into buf
+// and decrypted in place. Otherwise, a buffer from encryptedBufs is
+// used.
+func (v *EncryptedVolume) Get(ctx context.Context, loc string, buf []byte) (int, error) {
+	sealed := buf[:cap(buf)]
+	if len(sealed) < maxStoredBlockSize {
+		sealed = encryptedBufs.Get(maxStoredBlockSize)
+		defer encryptedBufs.Put(sealed)
+	}
+	n, err := v.inner.Get(ctx, loc, sealed)
+	if err != nil {
+		return 0, err
+	}
+	if n-encryptionOverhead > len(buf) {
+		return 0, fmt.Errorf("block %s is too big for buffer", loc)
+	}
+	data, _, err := v.decrypt(loc, sealed[:n])
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf, data), nil
+}
+
+// Compare decrypts the stored block and compares it with the given
+// data.
+func (v *EncryptedVolume) Compare(ctx context.Context, loc string, expect []byte) error {
+	sealed := encryptedBufs.Get(maxStoredBlockSize)
+	defer encryptedBufs.Put(sealed)
+	n, err := v.inner.Get(ctx, loc, sealed)
+	if err != nil {
+		return err
+	}
+	data, _, err := v.decrypt(loc, sealed[:n])
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, expect) {
+		return nil
+	}
+	return collisionOrCorrupt(loc, data, nil, nil)
+}
+
+// Put encrypts a block with the current key and stores it.
+func (v *EncryptedVolume) Put(ctx context.Context, loc string, block []byte) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	buf := encryptedBufs.Get(maxStoredBlockSize)
+	defer encryptedBufs.Put(buf)
+	sealed, err := v.encrypt(loc, block, buf[:0])
+	if err != nil {
+		return err
+	}
+	return v.inner.Put(ctx, loc, sealed)
+}
+
+func (v *EncryptedVolume) Touch(loc string) error {
+	return v.inner.Touch(loc)
+}
+
+func (v *EncryptedVolume) Mtime(loc string) (time.Time, error) {
+	return v.inner.Mtime(loc)
+}
+
+// IndexTo writes the underlying volume's index, with sizes adjusted
+// to match the decrypted blocks. If there are old keys, it also
+// wakes up the key rotation worker (see runRotation).
+func (v *EncryptedVolume) IndexTo(prefix string, w io.Writer) error {
+	select {
+	case v.rotate <- struct{}{}:
+	default:
+	}
+	return v.inner.IndexTo(prefix, &encryptedIndexWriter{Writer: w})
+}
+
+func (v *EncryptedVolume) Trash(loc string) error {
+	return v.inner.Trash(loc)
+}
+
+func (v *EncryptedVolume) Untrash(loc string) error {
+	return v.inner.Untrash(loc)
+}
+
+func (v *EncryptedVolume) Status() *VolumeStatus {
+	return v.inner.Status()
+}
+
+func (v *EncryptedVolume) String() string {
+	return fmt.Sprintf("[EncryptedVolume %s]", v.inner)
+}
+
+func (v *EncryptedVolume) EmptyTrash() {
+	v.inner.EmptyTrash()
+}
+
+func (v *EncryptedVolume) GetDeviceID() string {
+	return v.inner.GetDeviceID()
+}
+
+// InternalStats returns the underlying volume's stats.
+func (v *EncryptedVolume) InternalStats() interface{} {
+	if is, ok := v.inner.(InternalStatser); ok {
+		return is.InternalStats()
+	}
+	return nil
+}
+
+// runRotation starts a key rotation pass each time it is woken up by
+// IndexTo, unless the last pass started less than rotationInterval
+// ago. It returns when a pass completes without errors, because all
+// blocks are then encrypted with the current key.
+func (v *EncryptedVolume) runRotation() {
+	var lastStart time.Time
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-v.rotate:
+		}
+		if !lastStart.IsZero() && time.Since(lastStart) < v.rotationInterval {
+			continue
+		}
+		lastStart = time.Now()
+		if v.rotateKeys() {
+			v.logger.Info("all blocks are encrypted with the current key, old keys can be removed from EncryptionKeys")
+			return
+		}
+	}
+}
+
+// rotateKeys re-encrypts all blocks that are encrypted with an old
+// key, and returns true if it checked every block without errors
+// and without skipping any (see rotateBlock).
+func (v *EncryptedVolume) rotateKeys() bool {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(v.inner.IndexTo("", pw))
+	}()
+	defer pr.Close()
+	t0 := time.Now()
+	var checked, rotated, skipped, failed int
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		select {
+		case <-v.stop:
+			return false
+		default:
+		}
+		loc := strings.SplitN(scanner.Text(), "+", 2)[0]
+		checked++
+		ok, err := v.rotateBlock(loc)
+		if err == errTooOldToReencrypt {
+			skipped++
+		} else if err != nil {
+			v.logger.WithError(err).Warnf("error re-encrypting block %s", loc)
+			failed++
+		} else if ok {
+			rotated++
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		v.logger.WithError(err).Warn("key rotation pass ended early: error reading index")
+	}
+	v.logger.WithFields(logrus.Fields{
+		"Checked": checked,
+		"Rotated": rotated,
+		"Skipped": skipped,
+		"Failed":  failed,
+		"Elapsed": time.Since(t0).String(),
+	}).Info("key rotation pass finished")
+	return err == nil && failed == 0 && skipped == 0
+}
+
+// An uncachedGetter can read a block without adding it to a cache
+// (see TieredVolume).
+type uncachedGetter interface {
+	getUncached(ctx context.Context, loc string, buf []byte) (int, error)
+}
+
+// A rewriter can replace a block's data without changing its
+// timestamp, unless the block has been deleted or trashed
+// (os.ErrNotExist) or its timestamp is no longer mtime
+// (errMtimeChanged). It returns errCannotRewrite if it can't replace
+// this block that way.
+type rewriter interface {
+	rewrite(ctx context.Context, loc string, data []byte, mtime time.Time) error
+}
+
+// rotateBlock re-encrypts a block with the current key, if it's
+// encrypted with an old key.
+//
+// The block's timestamp must not change, otherwise unreferenced
+// blocks would never get old enough to be trashed. If the underlying
+// volume can't replace the block without changing its timestamp,
+// the block is only re-encrypted if it is too new to be trashed
+// anyway, otherwise rotateBlock returns errTooOldToReencrypt.
+func (v *EncryptedVolume) rotateBlock(loc string) (bool, error) {
+	ctx := context.Background()
+	mtime, err := v.inner.Mtime(loc)
+	if err != nil {
+		return false, err
+	}
+	rw, canRewrite := v.inner.(rewriter)
+	if !canRewrite && v.tooOldToReencrypt(mtime) {
+		return false, errTooOldToReencrypt
+	}
+	sealed := encryptedBufs.Get(maxStoredBlockSize)
+	defer encryptedBufs.Put(sealed)
+	var n int
+	if ug, ok := v.inner.(uncachedGetter); ok {
+		// Don't fill the cache with blocks that nobody
+		// asked for.
+		n, err = ug.getUncached(ctx, loc, sealed)
+	} else {
+		n, err = v.inner.Get(ctx, loc, sealed)
+	}
+	if err != nil {
+		return false, err
+	}
+	data, keyIndex, err := v.decrypt(loc, sealed[:n])
+	if err != nil || keyIndex == 0 {
+		return false, err
+	}
+	resealed, err := v.encrypt(loc, data, sealed[:0])
+	if err != nil {
+		return false, err
+	}
+	if canRewrite {
+		err = rw.rewrite(ctx, loc, resealed, mtime)
+		if err != errCannotRewrite {
+			return err == nil, err
+		}
+		if v.tooOldToReencrypt(mtime) {
+			return false, errTooOldToReencrypt
+		}
+	}
+	// Put updates the timestamp. Check the block hasn't been
+	// trashed since we read it, so we don't bring it back.
+	if _, err := v.inner.Mtime(loc); err != nil {
+		return false, err
+	}
+	return true, v.inner.Put(ctx, loc, resealed)
+}
+
+// tooOldToReencrypt returns true if a block with the given timestamp
+// might be trashed before rotateBlock can replace it using Put. A
+// volume doesn't trash blocks that are newer than BlobSigningTTL.
+func (v *EncryptedVolume) tooOldToReencrypt(mtime time.Time) bool {
+	return time.Since(mtime) > v.cluster.Collections.BlobSigningTTL.Duration()/2
+}
+
+// encryptedIndexWriter passes an index through to Writer,
+// subtracting the encryption overhead from each block size.
+type encryptedIndexWriter struct {
+	io.Writer
+	partial []byte
+}
+
+func (ew *encryptedIndexWriter) Write(p []byte) (int, error) {
+	data := append(ew.partial, p...)
+	var out []byte
+	for {
+		eol := bytes.IndexByte(data, '\n')
+		if eol < 0 {
+			break
+		}
+		out = append(out, adjustIndexLine(data[:eol+1])...)
+		data = data[eol+1:]
+	}
+	ew.partial = append([]byte(nil), data...)
+	if len(out) > 0 {
+		if _, err := ew.Writer.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// adjustIndexLine converts an index line "loc+size mtime\n" with
+// the encrypted size to one with the decrypted size.
+func adjustIndexLine(line []byte) []byte {
+	plus := bytes.IndexByte(line, '+')
+	space := bytes.IndexByte(line, ' ')
+	if plus < 0 || space < plus {
+		return line
+	}
+	size, err := strconv.ParseInt(string(line[plus+1:space]), 10, 64)
+	if err != nil || size < int64(encryptionOverhead) {
+		return line
+	}
+	return []byte(fmt.Sprintf("%s+%d%s", line[:plus], size-int64(encryptionOverhead), line[space:]))
+}
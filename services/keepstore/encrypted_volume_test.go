@@ -0,0 +1,477 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/sirupsen/logrus"
+	check "gopkg.in/check.v1"
+)
+
+const (
+	testEncryptionKey1 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testEncryptionKey2 = "f0e1d2c3b4a5968778695a4b3c2d1e0ff0e1d2c3b4a5968778695a4b3c2d1e0f"
+)
+
+type TestableEncryptedVolume struct {
+	*EncryptedVolume
+	inner *TestableUnixVolume
+	t     TB
+}
+
+// PutRaw encrypts a block and writes it to the underlying volume,
+// even if the volume is readonly.
+func (v *TestableEncryptedVolume) PutRaw(locator string, data []byte) {
+	sealed, err := v.encrypt(locator, data, nil)
+	if err != nil {
+		v.t.Fatal(err)
+	}
+	v.inner.PutRaw(locator, sealed)
+}
+
+// readRaw returns the data stored for the given block on a
+// UnixVolume.
+func readRaw(c *check.C, v *TestableUnixVolume, locator string) []byte {
+	buf, err := ioutil.ReadFile(v.blockPath(locator))
+	c.Assert(err, check.IsNil)
+	return buf
+}
+
+func (v *TestableEncryptedVolume) TouchWithDate(locator string, lastPut time.Time) {
+	v.inner.TouchWithDate(locator, lastPut)
+}
+
+func (v *TestableEncryptedVolume) Teardown() {
+	v.shutdown()
+	v.inner.Teardown()
+}
+
+func (v *TestableEncryptedVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return v.inner.ReadWriteOperationLabelValues()
+}
+
+var _ = check.Suite(&EncryptedVolumeSuite{})
+
+type EncryptedVolumeSuite struct {
+	unix    UnixVolumeSuite
+	cluster *arvados.Cluster
+	metrics *volumeMetricsVecs
+}
+
+func (s *EncryptedVolumeSuite) SetUpTest(c *check.C) {
+	s.unix.SetUpTest(c)
+	s.cluster = s.unix.cluster
+	s.metrics = s.unix.metrics
+}
+
+// newTestableEncryptedVolume returns an EncryptedVolume with the
+// given keys, that stores blocks on the given UnixVolume (or a new
+// one if inner is nil).
+func (s *EncryptedVolumeSuite) newTestableEncryptedVolume(c *check.C, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs, inner *TestableUnixVolume, keys ...string) *TestableEncryptedVolume {
+	if inner == nil {
+		inner = s.unix.newTestableUnixVolume(c, cluster, volume, metrics, false)
+	}
+	var err error
+	volume.DriverParameters, err = json.Marshal(map[string]interface{}{
+		"Root":           inner.Root,
+		"EncryptionKeys": keys,
+	})
+	c.Assert(err, check.IsNil)
+	vol, err := wrapEncryptedVolume(cluster, volume, inner, ctxlog.TestLogger(c), metrics)
+	c.Assert(err, check.IsNil)
+	return &TestableEncryptedVolume{EncryptedVolume: vol.(*EncryptedVolume), inner: inner, t: c}
+}
+
+func (s *EncryptedVolumeSuite) TestEncryptedVolumeWithGenericTests(c *check.C) {
+	DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableEncryptedVolume(c, cluster, volume, metrics, nil, testEncryptionKey1)
+	})
+}
+
+func (s *EncryptedVolumeSuite) TestEncryptedVolumeWithGenericTestsReadOnly(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableEncryptedVolume(c, cluster, volume, metrics, nil, testEncryptionKey1)
+	})
+}
+
+func (s *EncryptedVolumeSuite) TestKeyConfig(c *check.C) {
+	inner := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	defer inner.Teardown()
+	vol, err := wrapEncryptedVolume(s.cluster, arvados.Volume{DriverParameters: json.RawMessage(`{"Root":"/tmp"}`)}, inner, ctxlog.TestLogger(c), s.metrics)
+	c.Check(err, check.IsNil)
+	c.Check(vol, check.Equals, inner)
+
+	keyfile := c.MkDir() + "/key"
+	c.Assert(ioutil.WriteFile(keyfile, []byte(testEncryptionKey2+"\n"), 0600), check.IsNil)
+	keysrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/key" {
+			w.Write([]byte(testEncryptionKey2 + "\n"))
+		} else {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer keysrv.Close()
+	for _, trial := range []struct {
+		keys   []string
+		errMsg string
+	}{
+		{[]string{testEncryptionKey1}, ``},
+		{[]string{keyfile, testEncryptionKey1}, ``},
+		{[]string{"0123"}, `.*EncryptionKeys\[0\]: key must be 64 hexadecimal digits.*`},
+		{[]string{testEncryptionKey1, "/nonexistent/key"}, `.*EncryptionKeys\[1\]: open /nonexistent/key: no such file.*`},
+		{[]string{keysrv.URL + "/key", testEncryptionKey1}, ``},
+		{[]string{keysrv.URL + "/nonexistent"}, `.*EncryptionKeys\[0\]: error fetching key from URL: 404 Not Found`},
+	} {
+		params, _ := json.Marshal(map[string]interface{}{"EncryptionKeys": trial.keys})
+		vol, err := wrapEncryptedVolume(s.cluster, arvados.Volume{DriverParameters: params}, inner, ctxlog.TestLogger(c), s.metrics)
+		if trial.errMsg == "" {
+			c.Check(err, check.IsNil)
+			vol.(*EncryptedVolume).shutdown()
+		} else {
+			c.Check(err, check.ErrorMatches, trial.errMsg)
+		}
+	}
+}
+
+// Blocks are stored encrypted, and can only be read with the right
+// key and locator.
+func (s *EncryptedVolumeSuite) TestEncrypted(c *check.C) {
+	v := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, nil, testEncryptionKey1)
+	defer v.Teardown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+
+	raw := make([]byte, BlockSize)
+	n, err := v.inner.Get(context.Background(), TestHash, raw)
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, len(TestBlock)+encryptionOverhead)
+	c.Check(bytes.Contains(raw[:n], TestBlock[:16]), check.Equals, false)
+
+	// Index shows the decrypted size.
+	var index bytes.Buffer
+	c.Check(v.IndexTo("", &index), check.IsNil)
+	c.Check(index.String(), check.Matches, TestHash+`\+44 \d+\n`)
+
+	// Same ciphertext under a different locator doesn't decrypt.
+	v.inner.PutRaw(TestHash2, raw[:n])
+	_, err = v.Get(context.Background(), TestHash2, make([]byte, BlockSize))
+	c.Check(err, check.Equals, DiskHashError)
+
+	// Corrupt ciphertext doesn't decrypt.
+	raw[n-1] ^= 1
+	v.inner.PutRaw(TestHash, raw[:n])
+	_, err = v.Get(context.Background(), TestHash, make([]byte, BlockSize))
+	c.Check(err, check.Equals, DiskHashError)
+	c.Check(v.Compare(context.Background(), TestHash, TestBlock), check.Equals, DiskHashError)
+
+	// Unencrypted data isn't returned.
+	v.inner.PutRaw(TestHash, TestBlock)
+	_, err = v.Get(context.Background(), TestHash, make([]byte, BlockSize))
+	c.Check(err, check.Equals, errNotEncrypted)
+
+	// A volume with a different key can't read blocks written
+	// with this one.
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	v2 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, v.inner, testEncryptionKey2)
+	defer v2.shutdown()
+	_, err = v2.Get(context.Background(), TestHash, make([]byte, BlockSize))
+	c.Check(err, check.ErrorMatches, `block is encrypted with unknown key [0-9a-f]{16}`)
+}
+
+// After a new key is added, blocks encrypted with the old key are
+// still readable, and are re-encrypted with the new key after the
+// next index request.
+func (s *EncryptedVolumeSuite) TestKeyRotation(c *check.C) {
+	v := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, nil, testEncryptionKey1)
+	defer v.Teardown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	c.Assert(v.Put(context.Background(), TestHash2, TestBlock2), check.IsNil)
+	v.shutdown()
+
+	v2 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, v.inner, testEncryptionKey2, testEncryptionKey1)
+	defer v2.shutdown()
+	c.Assert(v2.Put(context.Background(), TestHash3, TestBlock3), check.IsNil)
+	buf := make([]byte, BlockSize)
+	n, err := v2.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+
+	// The index request itself doesn't wait for re-encryption.
+	var index bytes.Buffer
+	c.Check(v2.IndexTo("", &index), check.IsNil)
+	c.Check(strings.Count(index.String(), "\n"), check.Equals, 3)
+
+	v3 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, v.inner, testEncryptionKey2)
+	defer v3.shutdown()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, err1 := v3.Get(context.Background(), TestHash, buf)
+		_, err2 := v3.Get(context.Background(), TestHash2, buf)
+		if err1 == nil && err2 == nil {
+			break
+		} else if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for key rotation: %v, %v", err1, err2)
+		}
+	}
+	n, err = v3.Get(context.Background(), TestHash2, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock2)
+}
+
+// A rotation pass that finds blocks it can't re-encrypt is not
+// complete. Once a pass completes, the rotation worker exits.
+func (s *EncryptedVolumeSuite) TestKeyRotationDone(c *check.C) {
+	v := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, nil, testEncryptionKey1)
+	defer v.Teardown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	v.inner.PutRaw(TestHash2, TestBlock2)
+
+	// Start our own worker, so we can tell when it exits.
+	v2 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{ReadOnly: true}, s.metrics, v.inner, testEncryptionKey2, testEncryptionKey1)
+	defer v2.shutdown()
+	v2.volume.ReadOnly = false
+	v2.rotate = make(chan struct{}, 1)
+	v2.rotationInterval = 0
+	done := make(chan struct{})
+	go func() {
+		v2.runRotation()
+		close(done)
+	}()
+
+	c.Check(v2.rotateKeys(), check.Equals, false)
+	c.Assert(os.Remove(v.inner.blockPath(TestHash2)), check.IsNil)
+	c.Check(v2.IndexTo("", ioutil.Discard), check.IsNil)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("timed out waiting for rotation worker to exit")
+	}
+	_, keyIndex, err := v2.decrypt(TestHash, readRaw(c, v.inner, TestHash))
+	c.Check(err, check.IsNil)
+	c.Check(keyIndex, check.Equals, 0)
+
+	// Passes are not repeated more often than rotationInterval.
+	v3 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, v.inner, testEncryptionKey1, testEncryptionKey2)
+	defer v3.shutdown()
+	v3.rotationInterval = time.Hour
+	v.inner.PutRaw(TestHash2, TestBlock2)
+	c.Check(v3.IndexTo("", ioutil.Discard), check.IsNil)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, keyIndex, _ := v3.decrypt(TestHash, readRaw(c, v.inner, TestHash)); keyIndex == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for key rotation")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(v2.Put(context.Background(), TestHash3, TestBlock3), check.IsNil)
+	c.Check(v3.IndexTo("", ioutil.Discard), check.IsNil)
+	time.Sleep(100 * time.Millisecond)
+	_, keyIndex, err = v3.decrypt(TestHash3, readRaw(c, v.inner, TestHash3))
+	c.Check(err, check.IsNil)
+	c.Check(keyIndex, check.Equals, 1)
+}
+
+// Re-encrypting a block doesn't change its timestamp, so
+// unreferenced blocks still get trashed eventually.
+func (s *EncryptedVolumeSuite) TestKeyRotationKeepsMtime(c *check.C) {
+	v := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, nil, testEncryptionKey1)
+	defer v.Teardown()
+	ttl := s.cluster.Collections.BlobSigningTTL.Duration()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	c.Assert(v.Put(context.Background(), TestHash2, TestBlock2), check.IsNil)
+	v.TouchWithDate(TestHash, time.Now().Add(-2*ttl))
+	v.TouchWithDate(TestHash2, time.Now().Add(-time.Minute))
+	mtime1, err := v.Mtime(TestHash)
+	c.Assert(err, check.IsNil)
+	mtime2, err := v.Mtime(TestHash2)
+	c.Assert(err, check.IsNil)
+
+	v2 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, v.inner, testEncryptionKey2, testEncryptionKey1)
+	defer v2.shutdown()
+	c.Check(v2.rotateKeys(), check.Equals, true)
+	for loc, mtime := range map[string]time.Time{TestHash: mtime1, TestHash2: mtime2} {
+		_, keyIndex, err := v2.decrypt(loc, readRaw(c, v.inner, loc))
+		c.Check(err, check.IsNil)
+		c.Check(keyIndex, check.Equals, 0)
+		t, err := v2.Mtime(loc)
+		c.Check(err, check.IsNil)
+		c.Check(t.Equal(mtime), check.Equals, true, check.Commentf("%s: mtime %v, expected %v", loc, t, mtime))
+	}
+
+	// On a volume that can't replace a block without updating
+	// its timestamp, only blocks that are too new to be trashed
+	// are re-encrypted.
+	v3 := s.newTestableEncryptedVolume(c, s.cluster, arvados.Volume{}, s.metrics, v.inner, testEncryptionKey1, testEncryptionKey2)
+	defer v3.shutdown()
+	v3.EncryptedVolume.inner = struct{ Volume }{v.inner}
+	c.Check(v3.rotateKeys(), check.Equals, false)
+	_, keyIndex, err := v3.decrypt(TestHash, readRaw(c, v.inner, TestHash))
+	c.Check(err, check.IsNil)
+	c.Check(keyIndex, check.Equals, 1)
+	t, err := v3.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(t.Equal(mtime1), check.Equals, true)
+	_, keyIndex, err = v3.decrypt(TestHash2, readRaw(c, v.inner, TestHash2))
+	c.Check(err, check.IsNil)
+	c.Check(keyIndex, check.Equals, 0)
+}
+
+// Encrypted blocks on a TieredVolume's fast tier are encrypted, and
+// are flushed to the capacity tier after a restart.
+func (s *EncryptedVolumeSuite) TestTiered(c *check.C) {
+	defer func(orig *bufferPool) { bufs = orig }(bufs)
+	bufs = newBufferPool(ctxlog.TestLogger(c), 4, maxStoredBlockSize)
+
+	capacity := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	defer capacity.Teardown()
+	fastTierRoot := c.MkDir()
+	params, _ := json.Marshal(map[string]interface{}{
+		"FastTierRoot":   fastTierRoot,
+		"FastTierSize":   BlockSize,
+		"EncryptionKeys": []string{testEncryptionKey1},
+	})
+	cfgvol := arvados.Volume{DriverParameters: params}
+	newVolume := func() (*TieredVolume, Volume) {
+		tiered, err := wrapTieredVolume(s.cluster, cfgvol, capacity, ctxlog.TestLogger(c), s.metrics)
+		c.Assert(err, check.IsNil)
+		vol, err := wrapEncryptedVolume(s.cluster, cfgvol, tiered, ctxlog.TestLogger(c), s.metrics)
+		c.Assert(err, check.IsNil)
+		return tiered.(*TieredVolume), vol
+	}
+
+	tiered, vol := newVolume()
+	tiered.shutdown()
+	c.Assert(vol.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	buf, err := ioutil.ReadFile(tiered.fast.blockPath(TestHash))
+	c.Assert(err, check.IsNil)
+	c.Check(bytes.Contains(buf, TestBlock[:16]), check.Equals, false)
+
+	tiered, vol = newVolume()
+	defer tiered.shutdown()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := capacity.Mtime(TestHash); err == nil {
+			break
+		} else if !os.IsNotExist(err) || time.Now().After(deadline) {
+			c.Fatalf("block was not flushed to capacity tier: %v", err)
+		}
+	}
+	n, err := vol.Get(context.Background(), TestHash, make([]byte, BlockSize))
+	c.Check(err, check.IsNil)
+	c.Check(n, check.Equals, len(TestBlock))
+}
+
+// A full-size block is stored on the capacity tier in full, even
+// though encryption makes it bigger than BlockSize.
+func (s *EncryptedVolumeSuite) TestTieredFullBlock(c *check.C) {
+	defer func(orig *bufferPool) { bufs = orig }(bufs)
+	bufs = newBufferPool(ctxlog.TestLogger(c), 4, maxStoredBlockSize)
+
+	capacity := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	defer capacity.Teardown()
+	params, _ := json.Marshal(map[string]interface{}{
+		"FastTierRoot":   c.MkDir(),
+		"FastTierSize":   2 * BlockSize,
+		"EncryptionKeys": []string{testEncryptionKey1},
+	})
+	cfgvol := arvados.Volume{DriverParameters: params}
+	vol, err := wrapTieredVolume(s.cluster, cfgvol, capacity, ctxlog.TestLogger(c), s.metrics)
+	c.Assert(err, check.IsNil)
+	tiered := vol.(*TieredVolume)
+	defer tiered.shutdown()
+	vol, err = wrapEncryptedVolume(s.cluster, cfgvol, tiered, ctxlog.TestLogger(c), s.metrics)
+	c.Assert(err, check.IsNil)
+
+	data := make([]byte, BlockSize)
+	_, err = rand.Read(data)
+	c.Assert(err, check.IsNil)
+	loc := fmt.Sprintf("%x", md5.Sum(data))
+	c.Assert(vol.Put(context.Background(), loc, data), check.IsNil)
+	for deadline := time.Now().Add(10 * time.Second); tiered.InternalStats().(tieredVolumeStats).PendingBlocks > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for flush")
+		}
+	}
+	c.Check(readRaw(c, capacity, loc), check.HasLen, maxStoredBlockSize)
+
+	tiered.evict(loc)
+	_, err = os.Stat(tiered.fast.blockPath(loc))
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	buf := make([]byte, BlockSize)
+	n, err := vol.Get(context.Background(), loc, buf)
+	c.Check(err, check.IsNil)
+	c.Check(n, check.Equals, BlockSize)
+	c.Check(bytes.Equal(buf, data), check.Equals, true)
+}
+
+// Key rotation reads blocks from the capacity tier without copying
+// them to the fast tier.
+func (s *EncryptedVolumeSuite) TestTieredRotationNoPromote(c *check.C) {
+	defer func(orig *bufferPool) { bufs = orig }(bufs)
+	bufs = newBufferPool(ctxlog.TestLogger(c), 4, maxStoredBlockSize)
+
+	capacity := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	defer capacity.Teardown()
+	params, _ := json.Marshal(map[string]interface{}{
+		"FastTierRoot":   c.MkDir(),
+		"FastTierSize":   BlockSize,
+		"EncryptionKeys": []string{testEncryptionKey1, testEncryptionKey2},
+	})
+	cfgvol := arvados.Volume{DriverParameters: params}
+	vol, err := wrapTieredVolume(s.cluster, cfgvol, capacity, ctxlog.TestLogger(c), s.metrics)
+	c.Assert(err, check.IsNil)
+	tiered := vol.(*TieredVolume)
+	defer tiered.shutdown()
+	vol, err = wrapEncryptedVolume(s.cluster, cfgvol, tiered, ctxlog.TestLogger(c), s.metrics)
+	c.Assert(err, check.IsNil)
+	v := vol.(*EncryptedVolume)
+	defer v.shutdown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	for deadline := time.Now().Add(10 * time.Second); tiered.InternalStats().(tieredVolumeStats).PendingBlocks > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for flush")
+		}
+	}
+	tiered.evict(TestHash)
+
+	rotated, err := v.rotateBlock(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(rotated, check.Equals, false)
+	_, err = os.Stat(tiered.fast.blockPath(TestHash))
+	c.Check(os.IsNotExist(err), check.Equals, true)
+
+	// Re-encrypting a block replaces it on the capacity tier
+	// without changing its timestamp, and removes the old copy
+	// from the fast tier.
+	capacity.TouchWithDate(TestHash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+	mtime, err := capacity.Mtime(TestHash)
+	c.Assert(err, check.IsNil)
+	_, err = v.Get(context.Background(), TestHash, make([]byte, BlockSize))
+	c.Assert(err, check.IsNil)
+	c.Assert(tiered.InternalStats().(tieredVolumeStats).FastTierBlocks, check.Equals, 1)
+	v.keys[0], v.keys[1] = v.keys[1], v.keys[0]
+	rotated, err = v.rotateBlock(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(rotated, check.Equals, true)
+	c.Check(tiered.InternalStats().(tieredVolumeStats).FastTierBlocks, check.Equals, 0)
+	t, err := capacity.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(t.Equal(mtime), check.Equals, true)
+	_, keyIndex, err := v.decrypt(TestHash, readRaw(c, capacity, TestHash))
+	c.Check(err, check.IsNil)
+	c.Check(keyIndex, check.Equals, 0)
+}
@@ -0,0 +1,516 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+func init() {
+	driver["GCS"] = newGCSVolume
+}
+
+func newGCSVolume(cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) (Volume, error) {
+	v := &GCSVolume{
+		RequestTimeout: gcsDefaultRequestTimeout,
+		IndexPageSize:  gcsDefaultIndexPageSize,
+		cluster:        cluster,
+		volume:         volume,
+		logger:         logger,
+		metrics:        metrics,
+	}
+	err := json.Unmarshal(volume.DriverParameters, &v)
+	if err != nil {
+		return nil, err
+	}
+	if v.Bucket == "" {
+		return nil, errors.New("DriverParameters: Bucket must be provided")
+	}
+	opts := []option.ClientOption{option.WithScopes(storage.DevstorageReadWriteScope)}
+	if v.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(v.CredentialsFile))
+	}
+	if v.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(v.Endpoint))
+	}
+	return v, v.connect(opts...)
+}
+
+// connect sets up the GCS client and checks that the bucket exists.
+func (v *GCSVolume) connect(opts ...option.ClientOption) error {
+	svc, err := storage.NewService(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("creating GCS client: %s", err)
+	}
+	v.bucket = &gcsBucket{
+		svc:  svc,
+		name: v.Bucket,
+	}
+	lbls := prometheus.Labels{"device_id": v.GetDeviceID()}
+	v.bucket.stats.opsCounters, v.bucket.stats.errCounters, v.bucket.stats.ioBytes = v.metrics.getCounterVecsFor(lbls)
+
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	err = v.bucket.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("GCS bucket %q: %s", v.Bucket, err)
+	}
+	return nil
+}
+
+const (
+	gcsDefaultRequestTimeout = arvados.Duration(5 * time.Minute)
+	gcsDefaultIndexPageSize  = 1000
+
+	// Object metadata key indicating a trashed block. The
+	// value is the time (in Unix seconds) after which
+	// EmptyTrash can delete the object.
+	gcsExpiresAtKey = "expires_at"
+)
+
+// A GCSVolume stores and retrieves blocks in a Google Cloud Storage
+// bucket.
+//
+// Each block is stored as an object whose name is the block hash. A
+// trashed block is an object with an "expires_at" metadata entry.
+// The block's Mtime is the object's "updated" time, which changes
+// whenever the object data or metadata is modified.
+type GCSVolume struct {
+	Bucket          string
+	CredentialsFile string // "" means use application default credentials
+	Endpoint        string // "" means default, "https://www.googleapis.com/storage/v1/"
+	RequestTimeout  arvados.Duration
+	IndexPageSize   int
+
+	cluster *arvados.Cluster
+	volume  arvados.Volume
+	logger  logrus.FieldLogger
+	metrics *volumeMetricsVecs
+	bucket  *gcsBucket
+}
+
+// Type implements Volume.
+func (v *GCSVolume) Type() string {
+	return "GCS"
+}
+
+// GetDeviceID returns a globally unique ID for the storage bucket.
+func (v *GCSVolume) GetDeviceID() string {
+	return "gs://" + v.Bucket
+}
+
+// context returns a child of ctx that is cancelled after
+// RequestTimeout (if RequestTimeout is non-zero).
+func (v *GCSVolume) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if v.RequestTimeout > 0 {
+		return context.WithTimeout(ctx, v.RequestTimeout.Duration())
+	}
+	return context.WithCancel(ctx)
+}
+
+// attrs returns the attributes of the object for the given block.
+// It returns os.ErrNotExist if the block does not exist or has been
+// trashed.
+func (v *GCSVolume) attrs(ctx context.Context, loc string) (*storage.Object, error) {
+	obj, err := v.bucket.Attrs(ctx, loc)
+	if err != nil {
+		return nil, v.translateError(err)
+	}
+	if obj.Metadata[gcsExpiresAtKey] != "" {
+		return nil, os.ErrNotExist
+	}
+	return obj, nil
+}
+
+// Get reads a Keep block that has been stored as an object in the
+// bucket.
+func (v *GCSVolume) Get(ctx context.Context, loc string, buf []byte) (int, error) {
+	ctx, cancel := v.context(ctx)
+	defer cancel()
+	obj, err := v.attrs(ctx, loc)
+	if err != nil {
+		return 0, err
+	}
+	if obj.Size > uint64(len(buf)) {
+		return 0, fmt.Errorf("block %s invalid size %d (max %d)", loc, obj.Size, len(buf))
+	}
+	rdr, err := v.bucket.Download(ctx, loc, obj.Generation)
+	if err != nil {
+		return 0, v.ctxError(ctx, err)
+	}
+	defer rdr.Close()
+	n, err := io.ReadFull(rdr, buf[:obj.Size])
+	if err != nil {
+		return 0, v.ctxError(ctx, err)
+	}
+	return n, nil
+}
+
+// Compare the given data with existing stored data.
+func (v *GCSVolume) Compare(ctx context.Context, loc string, expect []byte) error {
+	ctx, cancel := v.context(ctx)
+	defer cancel()
+	obj, err := v.attrs(ctx, loc)
+	if err != nil {
+		return err
+	}
+	rdr, err := v.bucket.Download(ctx, loc, obj.Generation)
+	if err != nil {
+		return v.ctxError(ctx, err)
+	}
+	defer rdr.Close()
+	return compareReaderWithBuf(ctx, rdr, expect, loc)
+}
+
+// Put stores a Keep block as an object in the bucket.
+func (v *GCSVolume) Put(ctx context.Context, loc string, block []byte) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	ctx, cancel := v.context(ctx)
+	defer cancel()
+	err := v.bucket.Insert(ctx, loc, block)
+	return v.ctxError(ctx, err)
+}
+
+// Touch updates the Mtime of a block by setting a "touch" metadata
+// entry on its object.
+func (v *GCSVolume) Touch(loc string) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	obj, err := v.attrs(ctx, loc)
+	if err != nil {
+		return err
+	}
+	err = v.bucket.Patch(ctx, loc, obj.Generation, obj.Metageneration, map[string]string{
+		"touch": fmt.Sprintf("%d", time.Now().Unix()),
+	}, nil)
+	return v.translateError(err)
+}
+
+// Mtime returns the last-modified time of a block's object.
+func (v *GCSVolume) Mtime(loc string) (time.Time, error) {
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	obj, err := v.attrs(ctx, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, obj.Updated)
+}
+
+// IndexTo writes a list of Keep blocks that are stored in the
+// bucket.
+func (v *GCSVolume) IndexTo(prefix string, writer io.Writer) error {
+	return v.bucket.List(context.Background(), prefix, v.IndexPageSize, func(obj *storage.Object) error {
+		if !keepBlockRegexp.MatchString(obj.Name) || obj.Metadata[gcsExpiresAtKey] != "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, obj.Updated)
+		if err != nil {
+			return fmt.Errorf("%s: error parsing updated time %q: %s", obj.Name, obj.Updated, err)
+		}
+		_, err = fmt.Fprintf(writer, "%s+%d %d\n", obj.Name, obj.Size, t.UnixNano())
+		return err
+	})
+}
+
+// Trash a Keep block.
+func (v *GCSVolume) Trash(loc string) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	obj, err := v.attrs(ctx, loc)
+	if err != nil {
+		return err
+	}
+	if t, err := time.Parse(time.RFC3339Nano, obj.Updated); err != nil {
+		return err
+	} else if time.Since(t) < v.cluster.Collections.BlobSigningTTL.Duration() {
+		return nil
+	}
+
+	// The generation and metageneration preconditions ensure we
+	// don't delete or trash the block if Put() or Touch()
+	// happens after we check Mtime above. Both are needed: a Put
+	// creates a new generation whose metageneration starts over
+	// at 1.
+
+	// If BlobTrashLifetime == 0, just delete it
+	if v.cluster.Collections.BlobTrashLifetime == 0 {
+		err = v.bucket.Delete(ctx, loc, obj.Generation, obj.Metageneration)
+		return v.translateError(err)
+	}
+
+	// Otherwise, mark as trash
+	err = v.bucket.Patch(ctx, loc, obj.Generation, obj.Metageneration, map[string]string{
+		gcsExpiresAtKey: fmt.Sprintf("%d", time.Now().Add(v.cluster.Collections.BlobTrashLifetime.Duration()).Unix()),
+	}, nil)
+	return v.translateError(err)
+}
+
+// Untrash a Keep block by deleting its expires_at metadata entry.
+func (v *GCSVolume) Untrash(loc string) error {
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	obj, err := v.bucket.Attrs(ctx, loc)
+	if err != nil {
+		return v.translateError(err)
+	}
+	if obj.Metadata[gcsExpiresAtKey] == "" {
+		return os.ErrNotExist
+	}
+	err = v.bucket.Patch(ctx, loc, obj.Generation, obj.Metageneration, nil, []string{gcsExpiresAtKey})
+	return v.translateError(err)
+}
+
+// Status returns a VolumeStatus struct with placeholder data.
+func (v *GCSVolume) Status() *VolumeStatus {
+	return &VolumeStatus{
+		DeviceNum: 1,
+		BytesFree: BlockSize * 1000,
+		BytesUsed: 1,
+	}
+}
+
+// String returns a volume label, including the bucket name.
+func (v *GCSVolume) String() string {
+	return fmt.Sprintf("gcs-bucket:%+q", v.Bucket)
+}
+
+// translateError translates a GCS API error to a recognizable error
+// like os.ErrNotExist, if possible.
+func (v *GCSVolume) translateError(err error) error {
+	if err, ok := err.(*googleapi.Error); ok {
+		switch err.Code {
+		case http.StatusNotFound:
+			return os.ErrNotExist
+		case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+			return VolumeBusyError
+		}
+	}
+	return err
+}
+
+// ctxError returns ctx.Err() if ctx is done (in which case err is
+// most likely a side effect of cancellation), otherwise the
+// translated err.
+func (v *GCSVolume) ctxError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return v.translateError(err)
+}
+
+// EmptyTrash looks for trashed blocks that exceeded BlobTrashLifetime
+// and deletes them from the volume.
+func (v *GCSVolume) EmptyTrash() {
+	if v.cluster.Collections.BlobDeleteConcurrency < 1 {
+		return
+	}
+
+	var bytesDeleted, bytesInTrash int64
+	var blocksDeleted, blocksInTrash int64
+
+	doObject := func(obj *storage.Object) {
+		atomic.AddInt64(&blocksInTrash, 1)
+		atomic.AddInt64(&bytesInTrash, int64(obj.Size))
+
+		expiresAt, err := strconv.ParseInt(obj.Metadata[gcsExpiresAtKey], 10, 64)
+		if err != nil {
+			v.logger.Printf("EmptyTrash: ParseInt(%v): %v", obj.Metadata[gcsExpiresAtKey], err)
+			return
+		}
+		if expiresAt > time.Now().Unix() {
+			return
+		}
+
+		ctx, cancel := v.context(context.Background())
+		defer cancel()
+		// The preconditions ensure we don't delete a newer
+		// copy written by Put(), or a copy that has been
+		// untrashed, since we listed the bucket.
+		err = v.bucket.Delete(ctx, obj.Name, obj.Generation, obj.Metageneration)
+		if err != nil {
+			v.logger.Printf("EmptyTrash: Delete(%v): %v", obj.Name, err)
+			return
+		}
+		atomic.AddInt64(&blocksDeleted, 1)
+		atomic.AddInt64(&bytesDeleted, int64(obj.Size))
+	}
+
+	var wg sync.WaitGroup
+	todo := make(chan *storage.Object, v.cluster.Collections.BlobDeleteConcurrency)
+	for i := 0; i < v.cluster.Collections.BlobDeleteConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range todo {
+				doObject(obj)
+			}
+		}()
+	}
+
+	err := v.bucket.List(context.Background(), "", v.IndexPageSize, func(obj *storage.Object) error {
+		if keepBlockRegexp.MatchString(obj.Name) && obj.Metadata[gcsExpiresAtKey] != "" {
+			todo <- obj
+		}
+		return nil
+	})
+	if err != nil {
+		v.logger.Printf("EmptyTrash: List: %v", err)
+	}
+	close(todo)
+	wg.Wait()
+
+	v.logger.Printf("EmptyTrash stats for %v: Deleted %v bytes in %v blocks. Remaining in trash: %v bytes in %v blocks.", v.String(), bytesDeleted, blocksDeleted, bytesInTrash-bytesDeleted, blocksInTrash-blocksDeleted)
+}
+
+// InternalStats returns bucket I/O and API call counters.
+func (v *GCSVolume) InternalStats() interface{} {
+	return &v.bucket.stats
+}
+
+type gcsBucketStats struct {
+	statsTicker
+	Ops      uint64
+	GetOps   uint64
+	AttrOps  uint64
+	PutOps   uint64
+	PatchOps uint64
+	DelOps   uint64
+	ListOps  uint64
+}
+
+func (s *gcsBucketStats) TickErr(err error) {
+	if err == nil {
+		return
+	}
+	errType := fmt.Sprintf("%T", err)
+	if err, ok := err.(*googleapi.Error); ok {
+		errType = errType + fmt.Sprintf(" %d", err.Code)
+	}
+	s.statsTicker.TickErr(err, errType)
+}
+
+// gcsBucket wraps the GCS objects API in order to count I/O and API
+// usage stats.
+type gcsBucket struct {
+	svc   *storage.Service
+	name  string
+	stats gcsBucketStats
+}
+
+func (b *gcsBucket) Exists(ctx context.Context) error {
+	b.stats.TickOps("exists")
+	b.stats.Tick(&b.stats.Ops)
+	_, err := b.svc.Buckets.Get(b.name).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+func (b *gcsBucket) Attrs(ctx context.Context, name string) (*storage.Object, error) {
+	b.stats.TickOps("attrs")
+	b.stats.Tick(&b.stats.Ops, &b.stats.AttrOps)
+	obj, err := b.svc.Objects.Get(b.name, name).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return obj, err
+}
+
+// Download returns the content of the given generation of an object.
+func (b *gcsBucket) Download(ctx context.Context, name string, generation int64) (io.ReadCloser, error) {
+	b.stats.TickOps("get")
+	b.stats.Tick(&b.stats.Ops, &b.stats.GetOps)
+	resp, err := b.svc.Objects.Get(b.name, name).IfGenerationMatch(generation).Context(ctx).Download()
+	b.stats.TickErr(err)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{NewCountingReader(resp.Body, b.stats.TickInBytes), resp.Body}, nil
+}
+
+func (b *gcsBucket) Insert(ctx context.Context, name string, data []byte) error {
+	b.stats.TickOps("put")
+	b.stats.Tick(&b.stats.Ops, &b.stats.PutOps)
+	rdr := NewCountingReader(bytes.NewReader(data), b.stats.TickOutBytes)
+	_, err := b.svc.Objects.Insert(b.name, &storage.Object{Name: name}).
+		Media(rdr, googleapi.ContentType("application/octet-stream"), googleapi.ChunkSize(0)).
+		Context(ctx).Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+// Patch sets and deletes metadata entries on an object, if the
+// object's generation and metageneration still match the given
+// values.
+func (b *gcsBucket) Patch(ctx context.Context, name string, generation, metageneration int64, set map[string]string, del []string) error {
+	b.stats.TickOps("patch")
+	b.stats.Tick(&b.stats.Ops, &b.stats.PatchOps)
+	obj := &storage.Object{Metadata: set}
+	for _, key := range del {
+		obj.NullFields = append(obj.NullFields, "Metadata."+key)
+		// Without this, the client library omits an empty
+		// map, along with the null entries.
+		obj.ForceSendFields = []string{"Metadata"}
+	}
+	_, err := b.svc.Objects.Patch(b.name, name, obj).Generation(generation).IfGenerationMatch(generation).IfMetagenerationMatch(metageneration).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+// Delete deletes an object, if its generation and metageneration
+// still match the given values.
+func (b *gcsBucket) Delete(ctx context.Context, name string, generation, metageneration int64) error {
+	b.stats.TickOps("delete")
+	b.stats.Tick(&b.stats.Ops, &b.stats.DelOps)
+	err := b.svc.Objects.Delete(b.name, name).IfGenerationMatch(generation).IfMetagenerationMatch(metageneration).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+// List calls f for each object whose name starts with prefix, in
+// lexical order.
+func (b *gcsBucket) List(ctx context.Context, prefix string, pageSize int, f func(*storage.Object) error) error {
+	call := b.svc.Objects.List(b.name).Prefix(prefix)
+	if pageSize > 0 {
+		call = call.MaxResults(int64(pageSize))
+	}
+	return call.Pages(ctx, func(page *storage.Objects) error {
+		b.stats.TickOps("list")
+		b.stats.Tick(&b.stats.Ops, &b.stats.ListOps)
+		for _, obj := range page.Items {
+			if err := f(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
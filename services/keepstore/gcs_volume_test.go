@@ -0,0 +1,381 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+	check "gopkg.in/check.v1"
+)
+
+type gcsObject struct {
+	data           []byte
+	generation     int64
+	metageneration int64
+	metadata       map[string]string
+	updated        time.Time
+}
+
+// gcsStubHandler is a fake GCS JSON API server that supports the
+// subset of the API used by GCSVolume.
+type gcsStubHandler struct {
+	sync.Mutex
+	bucket     string
+	objects    map[string]*gcsObject
+	generation int64
+
+	// If not nil, called (with the lock held) before handling
+	// each request.
+	hook func(*http.Request)
+}
+
+func newGCSStubHandler(bucket string) *gcsStubHandler {
+	return &gcsStubHandler{
+		bucket:  bucket,
+		objects: map[string]*gcsObject{},
+	}
+}
+
+func (h *gcsStubHandler) PutRaw(name string, data []byte) {
+	h.Lock()
+	defer h.Unlock()
+	h.put(name, data)
+}
+
+func (h *gcsStubHandler) put(name string, data []byte) *gcsObject {
+	h.generation++
+	obj := &gcsObject{
+		data:           data,
+		generation:     h.generation,
+		metageneration: 1,
+		metadata:       map[string]string{},
+		updated:        time.Now(),
+	}
+	h.objects[name] = obj
+	return obj
+}
+
+func (h *gcsStubHandler) TouchWithDate(name string, t time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	if obj, ok := h.objects[name]; ok {
+		obj.updated = t
+	}
+}
+
+func (h *gcsStubHandler) resource(name string, obj *gcsObject) *storage.Object {
+	return &storage.Object{
+		Bucket:         h.bucket,
+		Name:           name,
+		Size:           uint64(len(obj.data)),
+		Generation:     obj.generation,
+		Metageneration: obj.metageneration,
+		Metadata:       obj.metadata,
+		Updated:        obj.updated.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func (h *gcsStubHandler) respondError(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": http.StatusText(code),
+		},
+	})
+}
+
+func (h *gcsStubHandler) respondJSON(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkPreconditions returns false (after sending a 412 response) if
+// the request's ifGenerationMatch or ifMetagenerationMatch parameters
+// don't match obj.
+func (h *gcsStubHandler) checkPreconditions(w http.ResponseWriter, r *http.Request, obj *gcsObject) bool {
+	for param, have := range map[string]int64{
+		"ifGenerationMatch":     obj.generation,
+		"ifMetagenerationMatch": obj.metageneration,
+	} {
+		if want := r.FormValue(param); want != "" && want != fmt.Sprintf("%d", have) {
+			h.respondError(w, http.StatusPreconditionFailed)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *gcsStubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+	r.ParseForm()
+	if h.hook != nil {
+		h.hook(r)
+	}
+
+	bucketPath := "/storage/v1/b/" + h.bucket
+	switch {
+	case r.Method == "GET" && r.URL.Path == bucketPath:
+		h.respondJSON(w, &storage.Bucket{Name: h.bucket})
+
+	case r.Method == "GET" && r.URL.Path == bucketPath+"/o":
+		h.serveList(w, r)
+
+	case r.Method == "POST" && r.URL.Path == "/upload"+bucketPath+"/o":
+		h.serveInsert(w, r)
+
+	case strings.HasPrefix(r.URL.Path, bucketPath+"/o/"):
+		name := strings.TrimPrefix(r.URL.Path, bucketPath+"/o/")
+		obj, ok := h.objects[name]
+		if !ok {
+			h.respondError(w, http.StatusNotFound)
+			return
+		}
+		if !h.checkPreconditions(w, r, obj) {
+			return
+		}
+		switch r.Method {
+		case "GET":
+			if r.FormValue("alt") == "media" {
+				w.Write(obj.data)
+			} else {
+				h.respondJSON(w, h.resource(name, obj))
+			}
+		case "PATCH":
+			var patch map[string]map[string]*string
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				h.respondError(w, http.StatusBadRequest)
+				return
+			}
+			for k, v := range patch["metadata"] {
+				if v == nil {
+					delete(obj.metadata, k)
+				} else {
+					obj.metadata[k] = *v
+				}
+			}
+			obj.metageneration++
+			obj.updated = time.Now()
+			h.respondJSON(w, h.resource(name, obj))
+		case "DELETE":
+			delete(h.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			h.respondError(w, http.StatusMethodNotAllowed)
+		}
+
+	default:
+		h.respondError(w, http.StatusNotFound)
+	}
+}
+
+func (h *gcsStubHandler) serveInsert(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.FormValue("uploadType") != "multipart" {
+		h.respondError(w, http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest)
+		return
+	}
+	var attrs storage.Object
+	if err := json.NewDecoder(part).Decode(&attrs); err != nil {
+		h.respondError(w, http.StatusBadRequest)
+		return
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest)
+		return
+	}
+	h.respondJSON(w, h.resource(attrs.Name, h.put(attrs.Name, data)))
+}
+
+func (h *gcsStubHandler) serveList(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range h.objects {
+		if strings.HasPrefix(name, r.FormValue("prefix")) && name > r.FormValue("pageToken") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	resp := &storage.Objects{Items: []*storage.Object{}}
+	if max, err := strconv.Atoi(r.FormValue("maxResults")); err == nil && max > 0 && len(names) > max {
+		names = names[:max]
+		resp.NextPageToken = names[max-1]
+	}
+	for _, name := range names {
+		resp.Items = append(resp.Items, h.resource(name, h.objects[name]))
+	}
+	h.respondJSON(w, resp)
+}
+
+type TestableGCSVolume struct {
+	*GCSVolume
+	gcsHandler *gcsStubHandler
+	gcsStub    *httptest.Server
+}
+
+func (s *StubbedGCSSuite) newTestableGCSVolume(t TB, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs) *TestableGCSVolume {
+	handler := newGCSStubHandler("test-bucket")
+	stub := httptest.NewServer(handler)
+	v := &GCSVolume{
+		Bucket:         handler.bucket,
+		RequestTimeout: arvados.Duration(time.Minute),
+		IndexPageSize:  3,
+		cluster:        cluster,
+		volume:         volume,
+		logger:         ctxlog.TestLogger(t),
+		metrics:        metrics,
+	}
+	err := v.connect(option.WithEndpoint(stub.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TestableGCSVolume{
+		GCSVolume:  v,
+		gcsHandler: handler,
+		gcsStub:    stub,
+	}
+}
+
+var _ = check.Suite(&StubbedGCSSuite{})
+
+type StubbedGCSSuite struct{}
+
+func (s *StubbedGCSSuite) TestGCSVolumeWithGeneric(c *check.C) {
+	DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableGCSVolume(t, cluster, volume, metrics)
+	})
+}
+
+func (s *StubbedGCSSuite) TestReadonlyGCSVolumeWithGeneric(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableGCSVolume(t, cluster, volume, metrics)
+	})
+}
+
+func (s *StubbedGCSSuite) TestMissingBucket(c *check.C) {
+	v := s.newTestableGCSVolume(c, testCluster(c), arvados.Volume{}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	v.Bucket = "nonexistent-bucket"
+	err := v.connect(option.WithEndpoint(v.gcsStub.URL+"/storage/v1/"), option.WithoutAuthentication())
+	c.Check(err, check.ErrorMatches, `GCS bucket "nonexistent-bucket": .*404.*`)
+}
+
+// If a block is written (or touched) after Trash checks its Mtime,
+// Trash must not delete it.
+func (s *StubbedGCSSuite) TestTrashRace(c *check.C) {
+	cluster := testCluster(c)
+	v := s.newTestableGCSVolume(c, cluster, arvados.Volume{}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+
+	for _, lifetime := range []string{"0s", "1h"} {
+		cluster.Collections.BlobTrashLifetime.Set(lifetime)
+		for _, race := range []struct {
+			name     string
+			simulate func()
+		}{
+			// A concurrent Touch bumps the metageneration.
+			{"touch", func() { v.gcsHandler.objects[TestHash].metageneration++ }},
+			// A concurrent Put creates a new generation,
+			// whose metageneration is 1 again.
+			{"put", func() { v.gcsHandler.put(TestHash, TestBlock) }},
+		} {
+			c.Logf("lifetime %s, race with %s", lifetime, race.name)
+			v.PutRaw(TestHash, TestBlock)
+			v.TouchWithDate(TestHash, time.Now().Add(-2*cluster.Collections.BlobSigningTTL.Duration()))
+			// Simulate the race between Trash's attrs
+			// request and its patch/delete request.
+			v.gcsHandler.hook = func(r *http.Request) {
+				if r.Method != "GET" {
+					race.simulate()
+				}
+			}
+			err := v.Trash(TestHash)
+			v.gcsHandler.hook = nil
+			c.Check(err, check.ErrorMatches, `.*412.*`)
+			_, err = v.Get(context.Background(), TestHash, make([]byte, BlockSize))
+			c.Check(err, check.IsNil)
+			c.Check(v.gcsHandler.objects[TestHash].metadata[gcsExpiresAtKey], check.Equals, "")
+		}
+	}
+}
+
+func (s *StubbedGCSSuite) TestStats(c *check.C) {
+	v := s.newTestableGCSVolume(c, testCluster(c), arvados.Volume{}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+
+	stats := func() string {
+		buf, err := json.Marshal(v.InternalStats())
+		c.Check(err, check.IsNil)
+		return string(buf)
+	}
+
+	c.Check(stats(), check.Matches, `.*"Errors":0,.*`)
+
+	loc := "acbd18db4cc2f85cedef654fccc4a4d8"
+	_, err := v.Get(context.Background(), loc, make([]byte, 3))
+	c.Check(err, check.NotNil)
+	c.Check(stats(), check.Matches, `.*"Ops":[^0],.*`)
+	c.Check(stats(), check.Matches, `.*"Errors":[^0],.*`)
+	c.Check(stats(), check.Matches, `.*"\*googleapi.Error 404":[^0].*`)
+	c.Check(stats(), check.Matches, `.*"InBytes":0,.*`)
+
+	err = v.Put(context.Background(), loc, []byte("foo"))
+	c.Check(err, check.IsNil)
+	c.Check(stats(), check.Matches, `.*"OutBytes":3,.*`)
+	c.Check(stats(), check.Matches, `.*"PutOps":1,.*`)
+
+	_, err = v.Get(context.Background(), loc, make([]byte, 3))
+	c.Check(err, check.IsNil)
+	_, err = v.Get(context.Background(), loc, make([]byte, 3))
+	c.Check(err, check.IsNil)
+	c.Check(stats(), check.Matches, `.*"InBytes":6,.*`)
+}
+
+func (v *TestableGCSVolume) PutRaw(loc string, data []byte) {
+	v.gcsHandler.PutRaw(loc, data)
+}
+
+func (v *TestableGCSVolume) TouchWithDate(loc string, t time.Time) {
+	v.gcsHandler.TouchWithDate(loc, t)
+}
+
+func (v *TestableGCSVolume) Teardown() {
+	v.gcsStub.Close()
+}
+
+func (v *TestableGCSVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return "get", "put"
+}
@@ -16,6 +16,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -957,6 +958,33 @@ func ExpectBody(
 	}
 }
 
+// If a volume uses encryption, MaxKeepBlobBuffers is split between
+// bufs and encryptedBufs. Otherwise, encryptedBufs isn't created.
+func (s *HandlerSuite) TestEncryptionBuffers(c *check.C) {
+	defer func(origBufs, origEncryptedBufs *bufferPool) {
+		bufs, encryptedBufs = origBufs, origEncryptedBufs
+	}(bufs, encryptedBufs)
+	encryptedBufs = nil
+	s.cluster.API.MaxKeepBlobBuffers = 5
+	c.Assert(s.handler.setup(context.Background(), s.cluster, "", prometheus.NewRegistry(), testServiceURL), check.IsNil)
+	c.Check(cap(bufs.limiter), check.Equals, 5)
+	c.Check(encryptedBufs, check.IsNil)
+
+	vol := s.cluster.Volumes["zzzzz-nyw5e-111111111111111"]
+	vol.DriverParameters = json.RawMessage(`{"EncryptionKeys":["` + testEncryptionKey1 + `"]}`)
+	s.cluster.Volumes["zzzzz-nyw5e-111111111111111"] = vol
+	s.handler = &handler{}
+	c.Assert(s.handler.setup(context.Background(), s.cluster, "", prometheus.NewRegistry(), testServiceURL), check.IsNil)
+	c.Check(cap(bufs.limiter), check.Equals, 3)
+	c.Assert(encryptedBufs, check.NotNil)
+	c.Check(cap(encryptedBufs.limiter), check.Equals, 2)
+
+	s.cluster.API.MaxKeepBlobBuffers = 1
+	s.handler = &handler{}
+	err := s.handler.setup(context.Background(), s.cluster, "", prometheus.NewRegistry(), testServiceURL)
+	c.Check(err, check.ErrorMatches, `API.MaxKeepBlobBuffers must be at least 2 .*`)
+}
+
 // See #7121
 func (s *HandlerSuite) TestPutNeedsOnlyOneBuffer(c *check.C) {
 	c.Assert(s.handler.setup(context.Background(), s.cluster, "", prometheus.NewRegistry(), testServiceURL), check.IsNil)
@@ -1127,6 +1155,61 @@ func (s *HandlerSuite) TestPutReplicationHeader(c *check.C) {
 	}
 }
 
+func (s *HandlerSuite) TestPutGetSHA256(c *check.C) {
+	c.Assert(s.handler.setup(context.Background(), s.cluster, "", prometheus.NewRegistry(), testServiceURL), check.IsNil)
+	s.cluster.Collections.BlobSigningKey = knownKey
+	s.cluster.Collections.BlobSigningTTL.Set("5m")
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(TestBlock))
+
+	// Wrong content for the given hash
+	// => 422
+	resp := IssueRequest(s.handler, &RequestTester{
+		method:      "PUT",
+		uri:         "/" + hash,
+		requestBody: TestBlock2,
+	})
+	ExpectStatusCode(c, "PUT SHA-256 hash with wrong content", RequestHashError.HTTPCode, resp)
+
+	// MD5 of the content used as a SHA-256-length hash is not
+	// accepted either.
+	resp = IssueRequest(s.handler, &RequestTester{
+		method:      "PUT",
+		uri:         "/" + TestHash + TestHash,
+		requestBody: TestBlock,
+	})
+	ExpectStatusCode(c, "PUT doubled MD5 hash", RequestHashError.HTTPCode, resp)
+
+	resp = IssueRequest(s.handler, &RequestTester{
+		method:      "PUT",
+		uri:         "/" + hash,
+		requestBody: TestBlock,
+		apiToken:    knownToken,
+	})
+	ExpectStatusCode(c, "PUT SHA-256 hash", http.StatusOK, resp)
+	signedLocator := strings.TrimSpace(resp.Body.String())
+	c.Check(signedLocator, check.Matches, hash+`\+`+fmt.Sprintf("%d", len(TestBlock))+`\+A.*`)
+	c.Check(VerifySignature(s.cluster, signedLocator, knownToken), check.IsNil)
+
+	s.cluster.Collections.BlobSigning = true
+	resp = IssueRequest(s.handler, &RequestTester{
+		method:   "GET",
+		uri:      "/" + signedLocator,
+		apiToken: knownToken,
+	})
+	ExpectStatusCode(c, "GET SHA-256 locator", http.StatusOK, resp)
+	ExpectBody(c, "GET SHA-256 locator", string(TestBlock), resp)
+
+	// The block is stored under its SHA-256 hash, not its MD5
+	// hash.
+	resp = IssueRequest(s.handler, &RequestTester{
+		method:   "GET",
+		uri:      "/" + SignLocator(s.cluster, TestHash, knownToken, time.Now().Add(time.Minute)),
+		apiToken: knownToken,
+	})
+	ExpectStatusCode(c, "GET MD5 locator", http.StatusNotFound, resp)
+}
+
 func (s *HandlerSuite) TestUntrashHandler(c *check.C) {
 	c.Assert(s.handler.setup(context.Background(), s.cluster, "", prometheus.NewRegistry(), testServiceURL), check.IsNil)
 
@@ -7,7 +7,6 @@ package main
 import (
 	"container/list"
 	"context"
-	"crypto/md5"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,6 +20,7 @@ import (
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
 	"git.arvados.org/arvados.git/sdk/go/health"
 	"git.arvados.org/arvados.git/sdk/go/httpserver"
@@ -53,21 +53,25 @@ func MakeRESTRouter(ctx context.Context, cluster *arvados.Cluster, reg *promethe
 		trashq:  trashq,
 	}
 
+	// A block hash can be MD5 (32 hex digits) or SHA-256 (64
+	// hex digits).
+	hashRoute := `/{hash:` + blockdigest.HashPattern + `}`
+
 	rtr.HandleFunc(
-		`/{hash:[0-9a-f]{32}}`, rtr.handleGET).Methods("GET", "HEAD")
+		hashRoute, rtr.handleGET).Methods("GET", "HEAD")
 	rtr.HandleFunc(
-		`/{hash:[0-9a-f]{32}}+{hints}`,
+		hashRoute+`+{hints}`,
 		rtr.handleGET).Methods("GET", "HEAD")
 
-	rtr.HandleFunc(`/{hash:[0-9a-f]{32}}`, rtr.handlePUT).Methods("PUT")
-	rtr.HandleFunc(`/{hash:[0-9a-f]{32}}`, rtr.handleDELETE).Methods("DELETE")
+	rtr.HandleFunc(hashRoute, rtr.handlePUT).Methods("PUT")
+	rtr.HandleFunc(hashRoute, rtr.handleDELETE).Methods("DELETE")
 	// List all blocks stored here. Privileged client only.
 	rtr.HandleFunc(`/index`, rtr.handleIndex).Methods("GET", "HEAD")
 	// List blocks stored here whose hash has the given prefix.
 	// Privileged client only.
-	rtr.HandleFunc(`/index/{prefix:[0-9a-f]{0,32}}`, rtr.handleIndex).Methods("GET", "HEAD")
+	rtr.HandleFunc(`/index/{prefix:[0-9a-f]{0,64}}`, rtr.handleIndex).Methods("GET", "HEAD")
 	// Update timestamp on existing block. Privileged client only.
-	rtr.HandleFunc(`/{hash:[0-9a-f]{32}}`, rtr.handleTOUCH).Methods("TOUCH")
+	rtr.HandleFunc(hashRoute, rtr.handleTOUCH).Methods("TOUCH")
 
 	// Internals/debugging info (runtime.MemStats)
 	rtr.HandleFunc(`/debug.json`, rtr.DebugHandler).Methods("GET", "HEAD")
@@ -87,7 +91,7 @@ func MakeRESTRouter(ctx context.Context, cluster *arvados.Cluster, reg *promethe
 	rtr.HandleFunc(`/trash`, rtr.handleTrash).Methods("PUT")
 
 	// Untrash moves blocks from trash back into store
-	rtr.HandleFunc(`/untrash/{hash:[0-9a-f]{32}}`, rtr.handleUntrash).Methods("PUT")
+	rtr.HandleFunc(`/untrash`+hashRoute, rtr.handleUntrash).Methods("PUT")
 
 	rtr.Handle("/_health/{check}", &health.Handler{
 		Token:  cluster.ManagementToken,
@@ -432,7 +436,7 @@ func getWorkQueueStatus(q *WorkQueue) WorkQueueStatus {
 
 // handleDELETE processes DELETE requests.
 //
-// DELETE /{hash:[0-9a-f]{32} will delete the block with the specified hash
+// DELETE /{hash} will delete the block with the specified hash
 // from all connected volumes.
 //
 // Only the Data Manager, or an Arvados admin with scope "all", are
@@ -612,7 +616,7 @@ func (rtr *router) handleTrash(resp http.ResponseWriter, req *http.Request) {
 	rtr.trashq.ReplaceQueue(tlist)
 }
 
-// UntrashHandler processes "PUT /untrash/{hash:[0-9a-f]{32}}" requests for the data manager.
+// UntrashHandler processes "PUT /untrash/{hash}" requests for the data manager.
 func (rtr *router) handleUntrash(resp http.ResponseWriter, req *http.Request) {
 	// Reject unauthorized requests.
 	if !rtr.isSystemAuth(GetAPIToken(req)) {
@@ -708,7 +712,7 @@ func GetBlock(ctx context.Context, volmgr *RRVolumeManager, hash string, buf []b
 			continue
 		}
 		// Check the file checksum.
-		filehash := fmt.Sprintf("%x", md5.Sum(buf[:size]))
+		filehash := blockdigest.HashAlgorithmFor(hash).Sum(buf[:size])
 		if filehash != hash {
 			// TODO: Try harder to tell a sysadmin about
 			// this.
@@ -729,7 +733,8 @@ func GetBlock(ctx context.Context, volmgr *RRVolumeManager, hash string, buf []b
 // PutBlock(ctx, block, hash)
 //   Stores the BLOCK (identified by the content id HASH) in Keep.
 //
-//   The MD5 checksum of the block must be identical to the content id HASH.
+//   The checksum of the block (computed using the hash algorithm
+//   indicated by the length of HASH) must be identical to HASH.
 //   If not, an error is returned.
 //
 //   PutBlock stores the BLOCK on the first Keep volume with free space.
@@ -742,7 +747,7 @@ func GetBlock(ctx context.Context, volmgr *RRVolumeManager, hash string, buf []b
 //          A different block with the same hash already exists on this
 //          Keep server.
 //   422 MD5Fail
-//          The MD5 hash of the BLOCK does not match the argument HASH.
+//          The hash of the BLOCK does not match the argument HASH.
 //   503 Full
 //          There was not enough space left in any Keep volume to store
 //          the object.
@@ -755,9 +760,13 @@ func PutBlock(ctx context.Context, volmgr *RRVolumeManager, block []byte, hash s
 	log := ctxlog.FromContext(ctx)
 
 	// Check that BLOCK's checksum matches HASH.
-	blockhash := fmt.Sprintf("%x", md5.Sum(block))
+	alg := blockdigest.HashAlgorithmFor(hash)
+	if alg == nil {
+		return 0, BadRequestError
+	}
+	blockhash := alg.Sum(block)
 	if blockhash != hash {
-		log.Printf("%s: MD5 checksum %s did not match request", hash, blockhash)
+		log.Printf("%s: %s checksum %s did not match request", hash, alg, blockhash)
 		return 0, RequestHashError
 	}
 
@@ -859,11 +868,11 @@ func CompareAndTouch(ctx context.Context, volmgr *RRVolumeManager, hash string,
 	return 0, bestErr
 }
 
-var validLocatorRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var validLocatorRe = regexp.MustCompile(`^` + blockdigest.HashPattern + `$`)
 
-// IsValidLocator returns true if the specified string is a valid Keep locator.
-//   When Keep is extended to support hash types other than MD5,
-//   this should be updated to cover those as well.
+// IsValidLocator returns true if the specified string is a valid Keep
+// locator hash, using any supported hash algorithm (see
+// blockdigest.HashAlgorithms).
 //
 func IsValidLocator(loc string) bool {
 	return validLocatorRe.MatchString(loc)
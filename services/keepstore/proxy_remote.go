@@ -17,6 +17,7 @@ import (
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
 	"git.arvados.org/arvados.git/sdk/go/auth"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"git.arvados.org/arvados.git/sdk/go/keepclient"
 )
 
@@ -177,7 +178,7 @@ func (rrc *remoteResponseCacher) Close() error {
 		rrc.ResponseWriter.Write(rrc.Buffer)
 		return nil
 	}
-	_, err := PutBlock(rrc.Context, rrc.VolumeManager, rrc.Buffer, rrc.Locator[:32])
+	_, err := PutBlock(rrc.Context, rrc.VolumeManager, rrc.Buffer, blockdigest.HashPart(rrc.Locator))
 	if rrc.Context.Err() != nil {
 		// If caller hung up, log that instead of subsequent/misleading errors.
 		http.Error(rrc.ResponseWriter, rrc.Context.Err().Error(), http.StatusGatewayTimeout)
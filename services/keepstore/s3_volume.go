@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -25,6 +26,7 @@ import (
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"github.com/AdRoll/goamz/aws"
 	"github.com/AdRoll/goamz/s3"
 	"github.com/prometheus/client_golang/prometheus"
@@ -412,7 +414,7 @@ func (v *S3Volume) Compare(ctx context.Context, loc string, expect []byte) error
 		return err
 	}
 	defer rdr.Close()
-	return v.translateError(compareReaderWithBuf(ctx, rdr, expect, loc[:32]))
+	return v.translateError(compareReaderWithBuf(ctx, rdr, expect, loc))
 }
 
 // Put writes a block.
@@ -423,11 +425,18 @@ func (v *S3Volume) Put(ctx context.Context, loc string, block []byte) error {
 	var opts s3.Options
 	size := len(block)
 	if size > 0 {
-		md5, err := hex.DecodeString(loc)
-		if err != nil {
-			return err
+		var sum []byte
+		if blockdigest.HashAlgorithmFor(loc) == blockdigest.MD5 {
+			var err error
+			sum, err = hex.DecodeString(loc)
+			if err != nil {
+				return err
+			}
+		} else {
+			md5sum := md5.Sum(block)
+			sum = md5sum[:]
 		}
-		opts.ContentMD5 = base64.StdEncoding.EncodeToString(md5)
+		opts.ContentMD5 = base64.StdEncoding.EncodeToString(sum)
 		// In AWS regions that use V4 signatures, we need to
 		// provide ContentSHA256 up front. Otherwise, the S3
 		// library reads the request body (from our buffer)
@@ -720,7 +729,7 @@ func (v *S3Volume) String() string {
 	return fmt.Sprintf("s3-bucket:%+q", v.Bucket)
 }
 
-var s3KeepBlockRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var s3KeepBlockRegexp = regexp.MustCompile(`^` + blockdigest.HashPattern + `$`)
 
 func (v *S3Volume) isKeepBlock(s string) bool {
 	return s3KeepBlockRegexp.MatchString(s)
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/awserr"
 	"github.com/aws/aws-sdk-go-v2/aws/defaults"
@@ -81,7 +82,7 @@ const (
 	WriteConcurrency = 5
 )
 
-var s3AWSKeepBlockRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var s3AWSKeepBlockRegexp = regexp.MustCompile(`^` + blockdigest.HashPattern + `$`)
 var s3AWSZeroTime time.Time
 
 func (v *S3AWSVolume) isKeepBlock(s string) bool {
@@ -268,7 +269,7 @@ func (v *S3AWSVolume) Compare(ctx context.Context, loc string, expect []byte) er
 	if err != nil {
 		return v.translateError(err)
 	}
-	return v.translateError(compareReaderWithBuf(ctx, result.Body, expect, loc[:32]))
+	return v.translateError(compareReaderWithBuf(ctx, result.Body, expect, loc))
 }
 
 // EmptyTrash looks for trashed blocks that exceeded BlobTrashLifetime
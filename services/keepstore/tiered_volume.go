@@ -0,0 +1,713 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
+	"github.com/sirupsen/logrus"
+)
+
+// Maximum number of blocks waiting for a flush worker. Blocks that
+// don't fit in the queue are picked up by the next retry pass.
+const tieredFlushQueueSize = 1000
+
+// A TieredVolume uses a local directory (the "fast tier") as a
+// write-back cache in front of another volume (the "capacity
+// tier"), typically a cloud storage bucket.
+//
+// New blocks are written to the fast tier and copied to the
+// capacity tier in the background. Blocks read from the capacity
+// tier are copied to the fast tier. When the fast tier exceeds
+// FastTierSize, the least recently used blocks that have already
+// been copied to the capacity tier are deleted from it.
+//
+// Timestamps, trash, index, status, and device ID come from the
+// capacity tier, except for blocks that have not been copied there
+// yet. Keep-balance therefore sees a single replica on a single
+// device, no matter which tiers hold the data.
+type TieredVolume struct {
+	FastTierRoot         string
+	FastTierSize         arvados.ByteSize
+	FastTierFlushWorkers int
+
+	cluster  *arvados.Cluster
+	volume   arvados.Volume
+	logger   logrus.FieldLogger
+	capacity Volume
+	fast     *UnixVolume
+
+	// Blocks are encrypted by an EncryptedVolume, so their
+	// content can't be checked against their hashes.
+	opaque bool
+
+	flushRetryInterval time.Duration
+	flushq             chan string
+	stop               chan struct{}
+
+	mtx      sync.Mutex
+	blocks   map[string]*tieredBlock // blocks stored on the fast tier
+	lru      list.List               // blocks, most recently used first
+	size     int64                   // total size of blocks
+	writing  map[string]int          // fast tier writes in progress
+	evicting map[string]bool         // fast tier deletes in progress
+	evicted  *sync.Cond              // broadcast when evicting changes
+}
+
+type tieredBlock struct {
+	loc  string
+	size int64
+	elem *list.Element
+
+	// dirty blocks have not been copied to the capacity tier
+	// yet, and cannot be evicted.
+	dirty bool
+	// recovered blocks were found on the fast tier at startup,
+	// and might already be on the capacity tier.
+	recovered bool
+	// queued blocks are waiting for (or being copied by) a flush
+	// worker.
+	queued bool
+	// version is incremented by each Put and Touch, so a flush
+	// that started before the latest update doesn't mark the
+	// block clean.
+	version int
+}
+
+// wrapTieredVolume returns a TieredVolume with the given capacity
+// tier, if the volume's DriverParameters specify a FastTierRoot.
+// Otherwise it returns the capacity volume itself.
+func wrapTieredVolume(cluster *arvados.Cluster, volume arvados.Volume, capacity Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) (Volume, error) {
+	v := &TieredVolume{
+		cluster:            cluster,
+		volume:             volume,
+		logger:             logger,
+		capacity:           capacity,
+		flushRetryInterval: time.Minute,
+	}
+	if len(volume.DriverParameters) > 0 {
+		err := json.Unmarshal(volume.DriverParameters, &v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v.FastTierRoot == "" {
+		return capacity, nil
+	}
+	v.opaque = encryptionEnabled(volume)
+	if v.FastTierSize <= 0 {
+		return nil, errors.New("DriverParameters.FastTierSize must be greater than zero")
+	}
+	if v.FastTierFlushWorkers < 1 {
+		v.FastTierFlushWorkers = 1
+	}
+	v.fast = &UnixVolume{
+		Root:    v.FastTierRoot,
+		cluster: cluster,
+		volume:  volume,
+		logger:  logger,
+		metrics: metrics,
+	}
+	v.fast.volume.ReadOnly = false
+	if err := v.fast.check(); err != nil {
+		return nil, fmt.Errorf("fast tier: %s", err)
+	}
+	v.fast.logger = logger.WithField("Volume", v.fast.String())
+	v.logger = logger.WithField("Volume", v.String())
+	err := v.start()
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// start indexes the blocks that are already on the fast tier and
+// starts the flush workers. Blocks left over from a previous run are
+// flushed (if they are not already on the capacity tier) before they
+// become eligible for eviction.
+func (v *TieredVolume) start() error {
+	v.blocks = map[string]*tieredBlock{}
+	v.writing = map[string]int{}
+	v.evicting = map[string]bool{}
+	v.evicted = sync.NewCond(&v.mtx)
+	v.flushq = make(chan string, tieredFlushQueueSize)
+	v.stop = make(chan struct{})
+
+	var index bytes.Buffer
+	if err := v.fast.IndexTo("", &index); err != nil {
+		return fmt.Errorf("error indexing fast tier: %s", err)
+	}
+	type found struct {
+		loc   string
+		size  int64
+		mtime int64
+	}
+	var existing []found
+	for _, line := range strings.Split(index.String(), "\n") {
+		var f found
+		fields := strings.Split(line, " ")
+		if len(fields) != 2 {
+			continue
+		}
+		locsize := strings.SplitN(fields[0], "+", 2)
+		if len(locsize) != 2 {
+			continue
+		}
+		f.loc = locsize[0]
+		f.size, _ = strconv.ParseInt(locsize[1], 10, 64)
+		f.mtime, _ = strconv.ParseInt(fields[1], 10, 64)
+		existing = append(existing, f)
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].mtime < existing[j].mtime })
+
+	v.mtx.Lock()
+	for _, f := range existing {
+		blk := v.add(f.loc, f.size)
+		blk.dirty = true
+		blk.recovered = true
+		v.enqueue(blk)
+	}
+	v.mtx.Unlock()
+	if len(existing) > 0 {
+		v.logger.Infof("found %d blocks on fast tier", len(existing))
+	}
+
+	for i := 0; i < v.FastTierFlushWorkers; i++ {
+		go v.runFlushWorker()
+	}
+	go v.runFlushRetries()
+	return nil
+}
+
+// shutdown stops the flush workers.
+func (v *TieredVolume) shutdown() {
+	select {
+	case <-v.stop:
+	default:
+		close(v.stop)
+	}
+}
+
+// Get a block from the fast tier if possible. Otherwise, get it from
+// the capacity tier and copy it to the fast tier.
+func (v *TieredVolume) Get(ctx context.Context, loc string, buf []byte) (int, error) {
+	return v.get(ctx, loc, buf, true)
+}
+
+// getUncached gets a block like Get, but doesn't copy it to the fast
+// tier or update its position in the LRU list. It implements
+// uncachedGetter.
+func (v *TieredVolume) getUncached(ctx context.Context, loc string, buf []byte) (int, error) {
+	return v.get(ctx, loc, buf, false)
+}
+
+func (v *TieredVolume) get(ctx context.Context, loc string, buf []byte, cache bool) (int, error) {
+	n, err := v.fast.Get(ctx, loc, buf)
+	if err == nil {
+		if cache {
+			v.mtx.Lock()
+			if blk := v.blocks[loc]; blk != nil {
+				v.lru.MoveToFront(blk.elem)
+			}
+			v.mtx.Unlock()
+		}
+		return n, nil
+	} else if !os.IsNotExist(err) {
+		v.logger.WithError(err).Warnf("error reading block %s from fast tier, trying capacity tier", loc)
+	}
+	n, err = v.capacity.Get(ctx, loc, buf)
+	if err != nil {
+		return n, err
+	}
+	if cache {
+		v.promote(ctx, loc, buf[:n])
+	}
+	return n, nil
+}
+
+// Compare the given data with the stored data. A clean block that
+// doesn't match on the fast tier is evicted, and compared on the
+// capacity tier instead.
+func (v *TieredVolume) Compare(ctx context.Context, loc string, expect []byte) error {
+	err := v.fast.Compare(ctx, loc, expect)
+	if err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		v.mtx.Lock()
+		blk := v.blocks[loc]
+		dirty := blk != nil && blk.dirty
+		v.mtx.Unlock()
+		if dirty {
+			return err
+		}
+		v.evict(loc)
+	}
+	return v.capacity.Compare(ctx, loc, expect)
+}
+
+// Put writes a block to the fast tier and queues it to be copied to
+// the capacity tier. If the fast tier write fails, the block is
+// written directly to the capacity tier.
+func (v *TieredVolume) Put(ctx context.Context, loc string, block []byte) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	v.mtx.Lock()
+	for v.evicting[loc] {
+		// Don't let the eviction delete the new data.
+		v.evicted.Wait()
+	}
+	v.writing[loc]++
+	v.mtx.Unlock()
+	err := v.fast.Put(ctx, loc, block)
+	var evicted []string
+	v.mtx.Lock()
+	v.doneWriting(loc)
+	if err == nil {
+		blk := v.blocks[loc]
+		if blk == nil {
+			blk = v.add(loc, int64(len(block)))
+		} else {
+			v.lru.MoveToFront(blk.elem)
+		}
+		blk.dirty = true
+		blk.recovered = false
+		blk.version++
+		v.enqueue(blk)
+		evicted = v.evictLRU()
+	}
+	v.mtx.Unlock()
+	v.unlink(evicted)
+	if err != nil {
+		v.logger.WithError(err).Warnf("error writing block %s to fast tier, writing to capacity tier instead", loc)
+		return v.capacity.Put(ctx, loc, block)
+	}
+	return nil
+}
+
+// Touch updates the block's timestamp on the fast tier if the block
+// hasn't been copied to the capacity tier yet, otherwise on the
+// capacity tier.
+func (v *TieredVolume) Touch(loc string) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	v.mtx.Lock()
+	blk := v.blocks[loc]
+	dirty := blk != nil && blk.dirty
+	if dirty {
+		// Ensure the flush updates the capacity tier's
+		// timestamp even if it is already in progress.
+		blk.version++
+	}
+	v.mtx.Unlock()
+	if dirty {
+		return v.fast.Touch(loc)
+	}
+	return v.capacity.Touch(loc)
+}
+
+// rewrite replaces a block on the capacity tier without changing its
+// timestamp (see rewriter), and removes the old copy from the fast
+// tier. Blocks that are waiting to be flushed can't be replaced this
+// way.
+func (v *TieredVolume) rewrite(ctx context.Context, loc string, data []byte, mtime time.Time) error {
+	rw, ok := v.capacity.(rewriter)
+	if !ok {
+		return errCannotRewrite
+	}
+	v.mtx.Lock()
+	blk := v.blocks[loc]
+	pending := (blk != nil && blk.dirty) || v.writing[loc] > 0
+	v.mtx.Unlock()
+	if pending {
+		return errCannotRewrite
+	}
+	if err := rw.rewrite(ctx, loc, data, mtime); err != nil {
+		return err
+	}
+	v.evict(loc)
+	return nil
+}
+
+// Mtime returns the block's timestamp on the fast tier if the block
+// hasn't been copied to the capacity tier yet, otherwise on the
+// capacity tier.
+func (v *TieredVolume) Mtime(loc string) (time.Time, error) {
+	v.mtx.Lock()
+	blk := v.blocks[loc]
+	dirty := blk != nil && blk.dirty
+	v.mtx.Unlock()
+	if dirty {
+		return v.fast.Mtime(loc)
+	}
+	return v.capacity.Mtime(loc)
+}
+
+// IndexTo writes the capacity tier's index, followed by any blocks
+// that haven't been copied to the capacity tier yet.
+func (v *TieredVolume) IndexTo(prefix string, w io.Writer) error {
+	pending := map[string]int64{}
+	v.mtx.Lock()
+	for loc, blk := range v.blocks {
+		if blk.dirty && strings.HasPrefix(loc, prefix) {
+			pending[loc] = blk.size
+		}
+	}
+	v.mtx.Unlock()
+	if len(pending) == 0 {
+		return v.capacity.IndexTo(prefix, w)
+	}
+	// Blocks copied to the capacity tier while it's being
+	// indexed might appear in its index as well as ours.
+	err := v.capacity.IndexTo(prefix, &pendingIndexWriter{Writer: w, pending: pending})
+	if err != nil {
+		return err
+	}
+	for loc, size := range pending {
+		t, err := v.fast.Mtime(loc)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s+%d %d\n", loc, size, t.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Trash trashes the block on the capacity tier, and evicts it from
+// the fast tier if the capacity tier no longer has it. Blocks that
+// haven't been copied to the capacity tier yet are not trashed. Like
+// other volumes, Trash returns nil if such a block is too new to
+// trash anyway; otherwise it returns VolumeBusyError so the caller
+// can try again later.
+func (v *TieredVolume) Trash(loc string) error {
+	v.mtx.Lock()
+	blk := v.blocks[loc]
+	pending := (blk != nil && blk.dirty) || v.writing[loc] > 0
+	v.mtx.Unlock()
+	if pending {
+		t, err := v.fast.Mtime(loc)
+		if err == nil && time.Since(t) < v.cluster.Collections.BlobSigningTTL.Duration() {
+			return nil
+		}
+		return VolumeBusyError
+	}
+	err := v.capacity.Trash(loc)
+	if err != nil {
+		return err
+	}
+	if _, err := v.capacity.Mtime(loc); os.IsNotExist(err) {
+		v.evict(loc)
+	}
+	return nil
+}
+
+// Untrash untrashes the block on the capacity tier.
+func (v *TieredVolume) Untrash(loc string) error {
+	return v.capacity.Untrash(loc)
+}
+
+// Status returns the capacity tier's status.
+func (v *TieredVolume) Status() *VolumeStatus {
+	return v.capacity.Status()
+}
+
+func (v *TieredVolume) String() string {
+	return fmt.Sprintf("[TieredVolume %s, %s]", v.FastTierRoot, v.capacity)
+}
+
+// EmptyTrash empties the capacity tier's trash.
+func (v *TieredVolume) EmptyTrash() {
+	v.capacity.EmptyTrash()
+}
+
+// GetDeviceID returns the capacity tier's device ID.
+func (v *TieredVolume) GetDeviceID() string {
+	return v.capacity.GetDeviceID()
+}
+
+type tieredVolumeStats struct {
+	FastTierBlocks int
+	FastTierBytes  int64
+	PendingBlocks  int
+	CapacityTier   interface{} `json:",omitempty"`
+}
+
+// InternalStats returns the fast tier's usage and the capacity
+// tier's stats.
+func (v *TieredVolume) InternalStats() interface{} {
+	var stats tieredVolumeStats
+	v.mtx.Lock()
+	stats.FastTierBlocks = len(v.blocks)
+	stats.FastTierBytes = v.size
+	for _, blk := range v.blocks {
+		if blk.dirty {
+			stats.PendingBlocks++
+		}
+	}
+	v.mtx.Unlock()
+	if is, ok := v.capacity.(InternalStatser); ok {
+		stats.CapacityTier = is.InternalStats()
+	}
+	return stats
+}
+
+// promote copies a block from the capacity tier to the fast tier.
+func (v *TieredVolume) promote(ctx context.Context, loc string, data []byte) {
+	v.mtx.Lock()
+	if v.blocks[loc] != nil || v.writing[loc] > 0 || v.evicting[loc] {
+		v.mtx.Unlock()
+		return
+	}
+	v.writing[loc]++
+	v.mtx.Unlock()
+	err := v.fast.Put(ctx, loc, data)
+	var evicted []string
+	v.mtx.Lock()
+	v.doneWriting(loc)
+	if err != nil {
+		v.logger.WithError(err).Warnf("error copying block %s to fast tier", loc)
+	} else if v.blocks[loc] == nil {
+		v.add(loc, int64(len(data)))
+		evicted = v.evictLRU()
+	}
+	v.mtx.Unlock()
+	v.unlink(evicted)
+}
+
+// add a (clean) block to the LRU list. Caller must have lock.
+func (v *TieredVolume) add(loc string, size int64) *tieredBlock {
+	blk := &tieredBlock{loc: loc, size: size}
+	blk.elem = v.lru.PushFront(blk)
+	v.blocks[loc] = blk
+	v.size += size
+	return blk
+}
+
+// Caller must have lock.
+func (v *TieredVolume) doneWriting(loc string) {
+	if v.writing[loc]--; v.writing[loc] == 0 {
+		delete(v.writing, loc)
+	}
+}
+
+// enqueue a dirty block for the flush workers, unless it is already
+// queued or the queue is full. Caller must have lock.
+func (v *TieredVolume) enqueue(blk *tieredBlock) {
+	if blk.queued {
+		return
+	}
+	select {
+	case v.flushq <- blk.loc:
+		blk.queued = true
+	default:
+	}
+}
+
+// evictLRU removes the least recently used clean blocks until the
+// fast tier fits in FastTierSize, and returns their locators. Caller
+// must have lock, and must pass the returned locators to unlink after
+// releasing it.
+func (v *TieredVolume) evictLRU() []string {
+	var evicted []string
+	for e := v.lru.Back(); e != nil && v.size > int64(v.FastTierSize); {
+		blk := e.Value.(*tieredBlock)
+		e = e.Prev()
+		if blk.dirty || v.writing[blk.loc] > 0 {
+			continue
+		}
+		evicted = append(evicted, v.remove(blk))
+	}
+	return evicted
+}
+
+// evict deletes a clean block from the fast tier.
+func (v *TieredVolume) evict(loc string) {
+	var evicted []string
+	v.mtx.Lock()
+	if blk := v.blocks[loc]; blk != nil && !blk.dirty && v.writing[loc] == 0 {
+		evicted = append(evicted, v.remove(blk))
+	}
+	v.mtx.Unlock()
+	v.unlink(evicted)
+}
+
+// remove a block from the LRU list, and return its locator. Caller
+// must have lock, and must pass the returned locator to unlink after
+// releasing it.
+func (v *TieredVolume) remove(blk *tieredBlock) string {
+	v.lru.Remove(blk.elem)
+	delete(v.blocks, blk.loc)
+	v.size -= blk.size
+	v.evicting[blk.loc] = true
+	return blk.loc
+}
+
+// unlink deletes blocks that were removed from the LRU list from the
+// fast tier. Caller must not have lock.
+func (v *TieredVolume) unlink(locs []string) {
+	if len(locs) == 0 {
+		return
+	}
+	for _, loc := range locs {
+		if err := v.fast.remove(loc); err != nil {
+			v.logger.WithError(err).Warnf("error evicting block %s from fast tier", loc)
+		}
+	}
+	v.mtx.Lock()
+	for _, loc := range locs {
+		delete(v.evicting, loc)
+	}
+	v.mtx.Unlock()
+	v.evicted.Broadcast()
+}
+
+func (v *TieredVolume) runFlushWorker() {
+	for {
+		select {
+		case <-v.stop:
+			return
+		case loc := <-v.flushq:
+			v.flush(loc)
+		}
+	}
+}
+
+// runFlushRetries periodically re-queues dirty blocks whose flush
+// failed or didn't fit in the queue.
+func (v *TieredVolume) runFlushRetries() {
+	ticker := time.NewTicker(v.flushRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+		}
+		v.mtx.Lock()
+		for _, blk := range v.blocks {
+			if blk.dirty {
+				v.enqueue(blk)
+			}
+		}
+		v.mtx.Unlock()
+	}
+}
+
+// flush copies a dirty block to the capacity tier, and marks it
+// clean (i.e., eligible for eviction) if it hasn't been updated in
+// the meantime.
+func (v *TieredVolume) flush(loc string) {
+	v.mtx.Lock()
+	blk := v.blocks[loc]
+	if blk == nil {
+		v.mtx.Unlock()
+		return
+	} else if !blk.dirty {
+		blk.queued = false
+		v.mtx.Unlock()
+		return
+	}
+	version, recovered, size := blk.version, blk.recovered, blk.size
+	v.mtx.Unlock()
+
+	err := v.copyToCapacity(loc, size, recovered)
+
+	var evicted []string
+	v.mtx.Lock()
+	blk.queued = false
+	if err == DiskHashError {
+		v.logger.Errorf("block %s on fast tier is corrupt, deleting", loc)
+		blk.dirty = false
+		if v.blocks[loc] == blk {
+			evicted = append(evicted, v.remove(blk))
+		}
+	} else if err != nil {
+		v.logger.WithError(err).Warnf("error copying block %s to capacity tier, will retry", loc)
+	} else if blk.version != version {
+		v.enqueue(blk)
+	} else {
+		blk.dirty = false
+		blk.recovered = false
+		evicted = v.evictLRU()
+	}
+	v.mtx.Unlock()
+	v.unlink(evicted)
+}
+
+// copyToCapacity copies a block of the given (stored) size from the
+// fast tier to the capacity tier. A block recovered from a previous
+// run is skipped if the capacity tier already has it, and (unless
+// it's encrypted) checked for corruption otherwise.
+func (v *TieredVolume) copyToCapacity(loc string, size int64, recovered bool) error {
+	if recovered {
+		if _, err := v.capacity.Mtime(loc); err == nil {
+			return nil
+		}
+	}
+	if size > int64(maxStoredBlockSize) {
+		return fmt.Errorf("block %s on fast tier is too big (%d bytes)", loc, size)
+	}
+	// Encrypted blocks are bigger than BlockSize.
+	buf := bufs.Get(maxStoredBlockSize)
+	defer bufs.Put(buf)
+	n, err := v.fast.Get(context.Background(), loc, buf)
+	if err != nil {
+		return err
+	} else if int64(n) != size {
+		return fmt.Errorf("block %s on fast tier has size %d, expected %d", loc, n, size)
+	}
+	if alg := blockdigest.HashAlgorithmFor(loc); recovered && !v.opaque && alg != nil {
+		h := alg.New()
+		h.Write(buf[:n])
+		if fmt.Sprintf("%x", h.Sum(nil)) != loc {
+			return DiskHashError
+		}
+	}
+	return v.capacity.Put(context.Background(), loc, buf[:n])
+}
+
+// pendingIndexWriter passes an index through to Writer, deleting
+// each block it sees from pending.
+type pendingIndexWriter struct {
+	io.Writer
+	pending map[string]int64
+	partial []byte
+}
+
+func (pw *pendingIndexWriter) Write(p []byte) (int, error) {
+	data := append(pw.partial, p...)
+	for {
+		eol := bytes.IndexByte(data, '\n')
+		if eol < 0 {
+			break
+		}
+		if plus := bytes.IndexByte(data[:eol], '+'); plus > 0 {
+			delete(pw.pending, string(data[:plus]))
+		}
+		data = data[eol+1:]
+	}
+	pw.partial = append([]byte(nil), data...)
+	return pw.Writer.Write(p)
+}
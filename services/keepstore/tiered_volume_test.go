@@ -0,0 +1,291 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/sirupsen/logrus"
+	check "gopkg.in/check.v1"
+)
+
+type TestableTieredVolume struct {
+	*TieredVolume
+	capacity *TestableUnixVolume
+	t        TB
+}
+
+// PutRaw writes a block directly to the capacity tier, and evicts it
+// from the fast tier.
+func (v *TestableTieredVolume) PutRaw(locator string, data []byte) {
+	v.waitFlushed()
+	v.capacity.PutRaw(locator, data)
+	v.evict(locator)
+}
+
+func (v *TestableTieredVolume) TouchWithDate(locator string, lastPut time.Time) {
+	v.waitFlushed()
+	v.capacity.TouchWithDate(locator, lastPut)
+}
+
+func (v *TestableTieredVolume) Teardown() {
+	v.shutdown()
+	v.capacity.Teardown()
+	if err := os.RemoveAll(v.FastTierRoot); err != nil {
+		v.t.Error(err)
+	}
+}
+
+func (v *TestableTieredVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return v.capacity.ReadWriteOperationLabelValues()
+}
+
+// waitFlushed waits for all blocks on the fast tier to be copied to
+// the capacity tier.
+func (v *TestableTieredVolume) waitFlushed() {
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		if v.InternalStats().(tieredVolumeStats).PendingBlocks == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			v.t.Fatal("timed out waiting for flush")
+		}
+	}
+}
+
+// onFastTier returns true if the given block is stored on the fast
+// tier.
+func (v *TestableTieredVolume) onFastTier(loc string) bool {
+	_, err := os.Stat(v.fast.blockPath(loc))
+	return err == nil
+}
+
+var _ = check.Suite(&TieredVolumeSuite{})
+
+type TieredVolumeSuite struct {
+	unix    UnixVolumeSuite
+	cluster *arvados.Cluster
+	metrics *volumeMetricsVecs
+	volumes []*TestableTieredVolume
+	bufs    *bufferPool
+}
+
+func (s *TieredVolumeSuite) SetUpTest(c *check.C) {
+	s.unix.SetUpTest(c)
+	s.cluster = s.unix.cluster
+	s.metrics = s.unix.metrics
+	s.volumes = nil
+	s.bufs = bufs
+	bufs = newBufferPool(ctxlog.TestLogger(c), 4, maxStoredBlockSize)
+}
+
+func (s *TieredVolumeSuite) TearDownTest(c *check.C) {
+	bufs = s.bufs
+}
+
+// newTestableTieredVolume returns a TieredVolume whose capacity tier
+// is a new UnixVolume, or the given one if not nil. If fastTierRoot
+// is empty, the fast tier is a new temporary directory.
+func (s *TieredVolumeSuite) newTestableTieredVolume(c *check.C, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs, capacity *TestableUnixVolume, fastTierRoot string, fastTierSize int) *TestableTieredVolume {
+	if capacity == nil {
+		capacity = s.unix.newTestableUnixVolume(c, cluster, volume, metrics, false)
+	}
+	if fastTierRoot == "" {
+		var err error
+		fastTierRoot, err = ioutil.TempDir("", "volume_test")
+		c.Assert(err, check.IsNil)
+	}
+	var err error
+	volume.DriverParameters, err = json.Marshal(map[string]interface{}{
+		"Root":                 capacity.Root,
+		"FastTierRoot":         fastTierRoot,
+		"FastTierSize":         fastTierSize,
+		"FastTierFlushWorkers": 2,
+	})
+	c.Assert(err, check.IsNil)
+	vol, err := wrapTieredVolume(cluster, volume, capacity, ctxlog.TestLogger(c), metrics)
+	c.Assert(err, check.IsNil)
+	v := &TestableTieredVolume{TieredVolume: vol.(*TieredVolume), capacity: capacity, t: c}
+	s.volumes = append(s.volumes, v)
+	return v
+}
+
+func (s *TieredVolumeSuite) TestTieredVolumeWithGenericTests(c *check.C) {
+	DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableTieredVolume(c, cluster, volume, metrics, nil, "", BlockSize*2)
+	})
+}
+
+func (s *TieredVolumeSuite) TestTieredVolumeWithGenericTestsReadOnly(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableTieredVolume(c, cluster, volume, metrics, nil, "", BlockSize*2)
+	})
+}
+
+func (s *TieredVolumeSuite) TestNotTiered(c *check.C) {
+	capacity := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	vol, err := wrapTieredVolume(s.cluster, arvados.Volume{DriverParameters: json.RawMessage(`{"Root":"/tmp"}`)}, capacity, ctxlog.TestLogger(c), s.metrics)
+	c.Check(err, check.IsNil)
+	c.Check(vol, check.Equals, capacity)
+
+	_, err = wrapTieredVolume(s.cluster, arvados.Volume{DriverParameters: json.RawMessage(`{"FastTierRoot":"/tmp"}`)}, capacity, ctxlog.TestLogger(c), s.metrics)
+	c.Check(err, check.ErrorMatches, `.*FastTierSize must be greater than zero`)
+}
+
+func (s *TieredVolumeSuite) TestWriteBack(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{Replication: 1}, s.metrics, nil, "", BlockSize)
+	defer v.Teardown()
+
+	// Stop the flush workers, so the new block stays on the fast
+	// tier only.
+	v.shutdown()
+	err := v.Put(context.Background(), TestHash, TestBlock)
+	c.Assert(err, check.IsNil)
+	c.Check(v.onFastTier(TestHash), check.Equals, true)
+	_, err = v.capacity.Mtime(TestHash)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+
+	// The block is readable, indexed, and timestamped even though
+	// it's not on the capacity tier yet.
+	buf := make([]byte, BlockSize)
+	n, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+	_, err = v.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	var index bytes.Buffer
+	c.Check(v.IndexTo("", &index), check.IsNil)
+	c.Check(index.String(), check.Matches, TestHash+`\+\d+ \d+\n`)
+
+	// It is not trashed, even if it's old, and the caller is
+	// told so.
+	s.cluster.Collections.BlobSigningTTL = arvados.Duration(time.Nanosecond)
+	c.Check(v.Trash(TestHash), check.Equals, VolumeBusyError)
+	c.Check(v.onFastTier(TestHash), check.Equals, true)
+
+	// Once flushed, the block is on the capacity tier, and
+	// appears in the index only once.
+	v.flush(TestHash)
+	c.Check(v.InternalStats().(tieredVolumeStats).PendingBlocks, check.Equals, 0)
+	_, err = v.capacity.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	index.Reset()
+	c.Check(v.IndexTo("", &index), check.IsNil)
+	c.Check(index.String(), check.Matches, TestHash+`\+\d+ \d+\n`)
+}
+
+func (s *TieredVolumeSuite) TestEvictAndPromote(c *check.C) {
+	// The fast tier can hold any two of these blocks, but not all
+	// three.
+	blocks := [][]byte{TestBlock, TestBlock2, TestBlock3}
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{Replication: 1}, s.metrics, nil, "", len(TestBlock)+len(TestBlock2)+len(TestBlock3)-1)
+	defer v.Teardown()
+	var locs []string
+	for _, data := range blocks {
+		locs = append(locs, fmt.Sprintf("%x", md5.Sum(data)))
+	}
+	for i := range blocks[:2] {
+		c.Assert(v.Put(context.Background(), locs[i], blocks[i]), check.IsNil)
+	}
+	v.waitFlushed()
+
+	// Reading block 0 makes block 1 the least recently used, so
+	// it's evicted when block 2 is written.
+	buf := make([]byte, BlockSize)
+	_, err := v.Get(context.Background(), locs[0], buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.Put(context.Background(), locs[2], blocks[2]), check.IsNil)
+	v.waitFlushed()
+	c.Check(v.onFastTier(locs[0]), check.Equals, true)
+	c.Check(v.onFastTier(locs[1]), check.Equals, false)
+	c.Check(v.onFastTier(locs[2]), check.Equals, true)
+
+	// Reading block 1 from the capacity tier promotes it to the
+	// fast tier, evicting block 0.
+	n, err := v.Get(context.Background(), locs[1], buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, blocks[1])
+	c.Check(v.onFastTier(locs[0]), check.Equals, false)
+	c.Check(v.onFastTier(locs[1]), check.Equals, true)
+	c.Check(v.onFastTier(locs[2]), check.Equals, true)
+	stats := v.InternalStats().(tieredVolumeStats)
+	c.Check(stats.FastTierBlocks, check.Equals, 2)
+	c.Check(stats.FastTierBytes, check.Equals, int64(len(blocks[1])+len(blocks[2])))
+}
+
+// Fast tier files are deleted without holding the volume lock, and a
+// Put that arrives while a block is being evicted waits for the
+// delete to finish, so the new data isn't lost.
+func (s *TieredVolumeSuite) TestPutWhileEvicting(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{Replication: 1}, s.metrics, nil, "", BlockSize)
+	defer v.Teardown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	v.waitFlushed()
+
+	v.mtx.Lock()
+	evicted := []string{v.remove(v.blocks[TestHash])}
+	v.mtx.Unlock()
+
+	// The block can still be read while it's being evicted.
+	buf := make([]byte, BlockSize)
+	_, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	done := make(chan error)
+	go func() {
+		done <- v.Put(context.Background(), TestHash, TestBlock)
+	}()
+	select {
+	case err := <-done:
+		c.Fatalf("Put returned %v before eviction finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	v.unlink(evicted)
+	c.Check(<-done, check.IsNil)
+	c.Check(v.onFastTier(TestHash), check.Equals, true)
+	c.Check(v.InternalStats().(tieredVolumeStats).FastTierBlocks, check.Equals, 1)
+	// Don't let the flush race with Teardown.
+	v.waitFlushed()
+}
+
+// Dirty blocks are never evicted, even if the fast tier is full.
+func (s *TieredVolumeSuite) TestNoEvictDirty(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{Replication: 1}, s.metrics, nil, "", 1)
+	defer v.Teardown()
+	v.shutdown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	c.Assert(v.Put(context.Background(), TestHash2, TestBlock2), check.IsNil)
+	c.Check(v.onFastTier(TestHash), check.Equals, true)
+	c.Check(v.onFastTier(TestHash2), check.Equals, true)
+}
+
+// Blocks left on the fast tier by a previous process are copied to
+// the capacity tier if needed.
+func (s *TieredVolumeSuite) TestRecover(c *check.C) {
+	fastTierRoot, err := ioutil.TempDir("", "volume_test")
+	c.Assert(err, check.IsNil)
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{Replication: 1}, s.metrics, nil, fastTierRoot, BlockSize)
+	v.shutdown()
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	c.Assert(v.fast.Put(context.Background(), TestHash2, []byte("corrupt")), check.IsNil)
+
+	v2 := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{Replication: 1}, s.metrics, v.capacity, fastTierRoot, BlockSize)
+	defer v2.Teardown()
+	v2.waitFlushed()
+	_, err = v.capacity.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	_, err = v.capacity.Mtime(TestHash2)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	c.Check(v2.onFastTier(TestHash2), check.Equals, false)
+}
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/blockdigest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
@@ -211,7 +212,7 @@ func (v *UnixVolume) stat(path string) (os.FileInfo, error) {
 	if err == nil {
 		if stat.Size() < 0 {
 			err = os.ErrInvalid
-		} else if stat.Size() > BlockSize {
+		} else if stat.Size() > int64(maxStoredBlockSize) {
 			err = TooLongError
 		}
 	}
@@ -249,7 +250,7 @@ func (v *UnixVolume) Compare(ctx context.Context, loc string, expect []byte) err
 		return v.translateError(err)
 	}
 	return v.getFunc(ctx, path, func(rdr io.Reader) error {
-		return compareReaderWithBuf(ctx, rdr, expect, loc[:32])
+		return compareReaderWithBuf(ctx, rdr, expect, loc)
 	})
 }
 
@@ -319,6 +320,60 @@ func (v *UnixVolume) WriteBlock(ctx context.Context, loc string, rdr io.Reader)
 	return nil
 }
 
+// rewrite replaces a block's data without changing its timestamp,
+// if the timestamp is still mtime. It implements rewriter.
+//
+// Like Trash, it holds the block file's lock while checking the
+// timestamp and replacing the file, so a concurrent Touch or Trash
+// applies to the new file.
+func (v *UnixVolume) rewrite(ctx context.Context, loc string, data []byte, mtime time.Time) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	tmpfile, err := v.os.TempFile(v.blockDir(loc), "tmp"+loc)
+	if err != nil {
+		return err
+	}
+	defer v.os.Remove(tmpfile.Name())
+	n, err := tmpfile.Write(data)
+	v.os.stats.TickOutBytes(uint64(n))
+	if err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+	v.os.stats.TickOps("utimes")
+	v.os.stats.Tick(&v.os.stats.UtimesOps)
+	err = os.Chtimes(tmpfile.Name(), mtime, mtime)
+	v.os.stats.TickErr(err)
+	if err != nil {
+		return err
+	}
+
+	if err := v.lock(ctx); err != nil {
+		return err
+	}
+	defer v.unlock()
+	p := v.blockPath(loc)
+	f, err := v.os.OpenFile(p, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := v.lockfile(f); err != nil {
+		return err
+	}
+	defer v.unlockfile(f)
+	if fi, err := v.os.Stat(p); err != nil {
+		return err
+	} else if !fi.ModTime().Equal(mtime) {
+		return errMtimeChanged
+	}
+	return v.os.Rename(tmpfile.Name(), p)
+}
+
 // Status returns a VolumeStatus struct describing the volume's
 // current state, or nil if an error occurs.
 //
@@ -349,7 +404,7 @@ func (v *UnixVolume) Status() *VolumeStatus {
 }
 
 var blockDirRe = regexp.MustCompile(`^[0-9a-f]+$`)
-var blockFileRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var blockFileRe = regexp.MustCompile(`^` + blockdigest.HashPattern + `$`)
 
 // IndexTo writes (to the given Writer) a list of blocks found on this
 // volume which begin with the specified prefix. If the prefix is an
@@ -513,6 +568,21 @@ func (v *UnixVolume) Untrash(loc string) (err error) {
 	return
 }
 
+// remove deletes a block immediately, regardless of its timestamp
+// and the trash lifetime. It is used to evict blocks from a
+// TieredVolume's fast tier.
+func (v *UnixVolume) remove(loc string) error {
+	if err := v.lock(context.TODO()); err != nil {
+		return err
+	}
+	defer v.unlock()
+	err := v.os.Remove(v.blockPath(loc))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 // blockDir returns the fully qualified directory name for the directory
 // where loc is (or would be) stored on this volume.
 func (v *UnixVolume) blockDir(loc string) string {
@@ -641,7 +711,7 @@ func (v *UnixVolume) translateError(err error) error {
 	}
 }
 
-var unixTrashLocRegexp = regexp.MustCompile(`/([0-9a-f]{32})\.trash\.(\d+)$`)
+var unixTrashLocRegexp = regexp.MustCompile(`/(` + blockdigest.HashPattern + `)\.trash\.(\d+)$`)
 
 // EmptyTrash walks hierarchy looking for {hash}.trash.*
 // and deletes those with deadline < now.
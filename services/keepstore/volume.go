@@ -38,8 +38,8 @@ type Volume interface {
 	// Get a block: copy the block data into buf, and return the
 	// number of bytes copied.
 	//
-	// loc is guaranteed to consist of 32 or more lowercase hex
-	// digits.
+	// loc is guaranteed to be a block hash of 32 (MD5) or 64
+	// (SHA-256) lowercase hex digits.
 	//
 	// Get should not verify the integrity of the data: it should
 	// just return whatever was found in its backing
@@ -315,6 +315,14 @@ func makeRRVolumeManager(logger logrus.FieldLogger, cluster *arvados.Cluster, my
 		if err != nil {
 			return nil, fmt.Errorf("error initializing volume %s: %s", uuid, err)
 		}
+		vol, err = wrapTieredVolume(cluster, cfgvol, vol, logger, metrics)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing volume %s: %s", uuid, err)
+		}
+		vol, err = wrapEncryptedVolume(cluster, cfgvol, vol, logger, metrics)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing volume %s: %s", uuid, err)
+		}
 		logger.Printf("started volume %s (%s), ReadOnly=%v", uuid, vol, cfgvol.ReadOnly)
 
 		sc := cfgvol.StorageClasses
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"regexp"
@@ -77,6 +78,7 @@ func DoGenericVolumeTests(t TB, readonly bool, factory TestableVolumeFactory) {
 	s.testMtimeNoSuchBlock(t, factory)
 
 	s.testIndexTo(t, factory)
+	s.testSHA256Block(t, readonly, factory)
 
 	if !readonly {
 		s.testDeleteNewBlock(t, factory)
@@ -124,6 +126,45 @@ func (s *genericVolumeSuite) newVolume(t TB, factory TestableVolumeFactory) Test
 	return factory(t, s.cluster, s.volume, s.logger, s.metrics)
 }
 
+// Store a block whose name is a SHA-256 hash; get, compare, and
+// index it.
+func (s *genericVolumeSuite) testSHA256Block(t TB, readonly bool, factory TestableVolumeFactory) {
+	s.setup(t)
+	v := s.newVolume(t, factory)
+	defer v.Teardown()
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(TestBlock))
+	if readonly {
+		v.PutRaw(hash, TestBlock)
+	} else if err := v.Put(context.Background(), hash, TestBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, BlockSize)
+	n, err := v.Get(context.Background(), hash, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(buf[:n], TestBlock) != 0 {
+		t.Errorf("expected %s, got %s", string(TestBlock), string(buf[:n]))
+	}
+
+	if err := v.Compare(context.Background(), hash, TestBlock); err != nil {
+		t.Errorf("Compare: got %v, expected nil", err)
+	}
+	if err := v.Compare(context.Background(), hash, TestBlock2); err != CollisionError {
+		t.Errorf("Compare with different data: got %v, expected %v", err, CollisionError)
+	}
+
+	idx := new(bytes.Buffer)
+	if err := v.IndexTo(hash[:5], idx); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := regexp.MatchString(`^`+hash+`\+\d+ \d+\n$`, idx.String()); !m {
+		t.Errorf("Got index %q for prefix %s", idx.String(), hash[:5])
+	}
+}
+
 // Put a test block, get it and verify content
 // Test should pass for both writable and read-only volumes
 func (s *genericVolumeSuite) testGet(t TB, factory TestableVolumeFactory) {
@@ -3,8 +3,13 @@
 // SPDX-License-Identifier: AGPL-3.0
 
 // Arvados-ws exposes Arvados APIs (currently just one, the
-// cache-invalidation event feed at "ws://.../websocket") to
-// websocket clients.
+// cache-invalidation event feed) to websocket clients. The feed is
+// available using the minimal v0 protocol at "ws://.../websocket"
+// and the v1 protocol (subscription IDs, acknowledgements, and
+// filters with the same operators as the API) at
+// "ws://.../arvados/v1/events.ws". A v1 subscription can also be
+// limited to objects in a given project, optionally including its
+// subprojects.
 //
 // Installation and configuration
 //
@@ -0,0 +1,337 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package ws
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// A logMatcher reports whether a log record matches a filter.
+type logMatcher func(*arvados.Log) bool
+
+// compileFilters returns a logMatcher that accepts log records
+// matching all of the given filters, using the same operators as the
+// API server's list endpoints ("=", "!=", "<", "<=", ">", ">=", "in",
+// "not in", "like", "ilike", "is_a", "exists").
+//
+// kindForUUID is used to evaluate "is_a" filters.
+func compileFilters(filters []arvados.Filter, kindForUUID func(string) (string, error)) (logMatcher, error) {
+	var funcs []logMatcher
+	for _, f := range filters {
+		fn, err := compileFilter(f, kindForUUID)
+		if err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, fn)
+	}
+	return func(lg *arvados.Log) bool {
+		for _, fn := range funcs {
+			if !fn(lg) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileFilter(f arvados.Filter, kindForUUID func(string) (string, error)) (logMatcher, error) {
+	if f.Attr == "properties" && f.Operator == "exists" {
+		// ["properties", "exists", "key"]
+		key, ok := f.Operand.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %#v for %q filter: must be a string", f.Operand, f.Operator)
+		}
+		return func(lg *arvados.Log) bool {
+			_, ok := lg.Properties[key]
+			return ok
+		}, nil
+	}
+	get, isTime, err := logAttrGetter(f.Attr)
+	if err != nil {
+		return nil, err
+	}
+	switch f.Operator {
+	case "=", "==", "!=", "<>":
+		eq, err := compileEquals(f.Operand, isTime)
+		if err != nil {
+			return nil, err
+		}
+		if f.Operator == "!=" || f.Operator == "<>" {
+			return func(lg *arvados.Log) bool { return !eq(get(lg)) }, nil
+		}
+		return func(lg *arvados.Log) bool { return eq(get(lg)) }, nil
+	case "<", "<=", ">", ">=":
+		cmp, err := compileCompare(f.Operand, isTime)
+		if err != nil {
+			return nil, err
+		}
+		var accept func(int) bool
+		switch f.Operator {
+		case "<":
+			accept = func(c int) bool { return c < 0 }
+		case "<=":
+			accept = func(c int) bool { return c <= 0 }
+		case ">":
+			accept = func(c int) bool { return c > 0 }
+		case ">=":
+			accept = func(c int) bool { return c >= 0 }
+		}
+		return func(lg *arvados.Log) bool {
+			c, ok := cmp(get(lg))
+			return ok && accept(c)
+		}, nil
+	case "in", "not in":
+		list, ok := f.Operand.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %#v for %q filter: must be an array", f.Operand, f.Operator)
+		}
+		var eqs []func(interface{}) bool
+		for _, operand := range list {
+			eq, err := compileEquals(operand, isTime)
+			if err != nil {
+				return nil, err
+			}
+			eqs = append(eqs, eq)
+		}
+		in := func(lg *arvados.Log) bool {
+			v := get(lg)
+			for _, eq := range eqs {
+				if eq(v) {
+					return true
+				}
+			}
+			return false
+		}
+		if f.Operator == "not in" {
+			return func(lg *arvados.Log) bool { return !in(lg) }, nil
+		}
+		return in, nil
+	case "like", "ilike":
+		pattern, ok := f.Operand.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %#v for %q filter: must be a string", f.Operand, f.Operator)
+		}
+		re, err := likeRegexp(pattern, f.Operator == "ilike")
+		if err != nil {
+			return nil, err
+		}
+		return func(lg *arvados.Log) bool {
+			s, ok := get(lg).(string)
+			return ok && re.MatchString(s)
+		}, nil
+	case "is_a":
+		var kinds []string
+		switch operand := f.Operand.(type) {
+		case string:
+			kinds = []string{operand}
+		case []interface{}:
+			for _, k := range operand {
+				k, ok := k.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid operand %#v for %q filter: must be a string or array of strings", f.Operand, f.Operator)
+				}
+				kinds = append(kinds, k)
+			}
+		default:
+			return nil, fmt.Errorf("invalid operand %#v for %q filter: must be a string or array of strings", f.Operand, f.Operator)
+		}
+		return func(lg *arvados.Log) bool {
+			uuid, ok := get(lg).(string)
+			if !ok || uuid == "" {
+				return false
+			}
+			kind, err := kindForUUID(uuid)
+			if err != nil {
+				return false
+			}
+			for _, k := range kinds {
+				if k == kind {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "exists":
+		want, ok := f.Operand.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %#v for %q filter: must be true or false", f.Operand, f.Operator)
+		}
+		return func(lg *arvados.Log) bool {
+			return (get(lg) != nil) == want
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid operator %q", f.Operator)
+	}
+}
+
+// logAttrGetter returns a func that extracts the given attribute
+// from a log record. Values are returned as string, float64, bool,
+// time.Time, or nil (which means null or missing). isTime is true if
+// the attribute is a timestamp.
+func logAttrGetter(attr string) (get func(*arvados.Log) interface{}, isTime bool, err error) {
+	switch attr {
+	case "id":
+		return func(lg *arvados.Log) interface{} { return float64(lg.ID) }, false, nil
+	case "uuid":
+		return func(lg *arvados.Log) interface{} { return lg.UUID }, false, nil
+	case "object_uuid":
+		return func(lg *arvados.Log) interface{} { return lg.ObjectUUID }, false, nil
+	case "object_owner_uuid":
+		return func(lg *arvados.Log) interface{} { return lg.ObjectOwnerUUID }, false, nil
+	case "event_type":
+		return func(lg *arvados.Log) interface{} { return lg.EventType }, false, nil
+	case "event_at":
+		return func(lg *arvados.Log) interface{} { return timeOrNil(lg.EventAt) }, true, nil
+	case "created_at":
+		return func(lg *arvados.Log) interface{} { return timeOrNil(lg.CreatedAt) }, true, nil
+	}
+	if strings.HasPrefix(attr, "properties.") {
+		path := strings.Split(strings.TrimPrefix(attr, "properties."), ".")
+		return func(lg *arvados.Log) interface{} {
+			var v interface{} = lg.Properties
+			for _, key := range path {
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return nil
+				}
+				v = m[key]
+			}
+			return v
+		}, false, nil
+	}
+	return nil, false, fmt.Errorf("invalid attribute %q", attr)
+}
+
+func timeOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+func compileEquals(operand interface{}, isTime bool) (func(interface{}) bool, error) {
+	if isTime && operand != nil {
+		t, err := parseTimeOperand(operand)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool {
+			vt, ok := v.(time.Time)
+			return ok && vt.Equal(t)
+		}, nil
+	}
+	switch operand.(type) {
+	case string, float64, bool, nil:
+	default:
+		return nil, fmt.Errorf("invalid operand %#v for equality test", operand)
+	}
+	return func(v interface{}) bool {
+		return v == operand
+	}, nil
+}
+
+// compileCompare returns a func that compares a value to the given
+// operand, returning -1, 0, or 1 if the value is less than, equal
+// to, or greater than the operand. The bool return value is false if
+// the value is not comparable with the operand.
+func compileCompare(operand interface{}, isTime bool) (func(interface{}) (int, bool), error) {
+	if isTime {
+		t, err := parseTimeOperand(operand)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) (int, bool) {
+			vt, ok := v.(time.Time)
+			if !ok {
+				return 0, false
+			}
+			switch {
+			case vt.Before(t):
+				return -1, true
+			case vt.After(t):
+				return 1, true
+			default:
+				return 0, true
+			}
+		}, nil
+	}
+	switch operand := operand.(type) {
+	case float64:
+		return func(v interface{}) (int, bool) {
+			vf, ok := v.(float64)
+			if !ok {
+				return 0, false
+			}
+			switch {
+			case vf < operand:
+				return -1, true
+			case vf > operand:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}, nil
+	case string:
+		return func(v interface{}) (int, bool) {
+			vs, ok := v.(string)
+			if !ok {
+				return 0, false
+			}
+			return strings.Compare(vs, operand), true
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid operand %#v for comparison: must be a string or number", operand)
+	}
+}
+
+func parseTimeOperand(operand interface{}) (time.Time, error) {
+	s, ok := operand.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid operand %#v for timestamp: must be a string", operand)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid operand %q for timestamp: %s", s, err)
+	}
+	return t, nil
+}
+
+// likeRegexp converts a SQL LIKE pattern to an anchored regular
+// expression.
+func likeRegexp(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var re strings.Builder
+	if caseInsensitive {
+		re.WriteString("(?is)")
+	} else {
+		re.WriteString("(?s)")
+	}
+	re.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			re.WriteString(".*")
+		case r == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		re.WriteString(`\\`)
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,109 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&filterSuite{})
+
+type filterSuite struct{}
+
+func (*filterSuite) kindForUUID(uuid string) (string, error) {
+	switch {
+	case len(uuid) != 27:
+		return "", errors.New("invalid uuid")
+	case uuid[6:11] == "7fd4e":
+		return "arvados#workflow", nil
+	case uuid[6:11] == "4zz18":
+		return "arvados#collection", nil
+	default:
+		return "", errors.New("unknown type")
+	}
+}
+
+func (s *filterSuite) TestFilters(c *check.C) {
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	lg := &arvados.Log{
+		ID:              1234,
+		UUID:            "zzzzz-57u5n-aaaaaaaaaaaaaaa",
+		ObjectUUID:      "zzzzz-7fd4e-bbbbbbbbbbbbbbb",
+		ObjectOwnerUUID: "zzzzz-tpzed-ccccccccccccccc",
+		EventType:       "update",
+		EventAt:         &t0,
+		CreatedAt:       &t0,
+		Properties: map[string]interface{}{
+			"new_attributes": map[string]interface{}{
+				"name":  "Foo_bar",
+				"state": "Complete",
+			},
+		},
+	}
+	for _, trial := range []struct {
+		filters string
+		match   bool
+	}{
+		{`[]`, true},
+		{`[["event_type","=","update"]]`, true},
+		{`[["event_type","!=","update"]]`, false},
+		{`[["event_type","in",["create","update"]]]`, true},
+		{`[["event_type","not in",["create","update"]]]`, false},
+		{`[["event_type","in",["create","update"]],["object_owner_uuid","=","zzzzz-tpzed-xxxxxxxxxxxxxxx"]]`, false},
+		{`[["id",">",1000]]`, true},
+		{`[["id","<=",1233]]`, false},
+		{`[["id",">=",1234],["id","<",1235]]`, true},
+		{`[["created_at",">=","2020-06-01T12:00:00Z"]]`, true},
+		{`[["created_at",">","2020-06-01T12:00:00Z"]]`, false},
+		{`[["event_at","=","2020-06-01T12:00:00.000Z"]]`, true},
+		{`[["object_uuid","like","zzzzz-7fd4e-%"]]`, true},
+		{`[["object_uuid","like","ZZZZZ-7fd4e-%"]]`, false},
+		{`[["object_uuid","ilike","ZZZZZ-7fd4e-%"]]`, true},
+		{`[["object_uuid","like","zzzzz_7fd4e_bbbbbbbbbbbbbbb"]]`, true},
+		{`[["object_uuid","like","zzzzz\\_7fd4e%"]]`, false},
+		{`[["object_uuid","is_a","arvados#workflow"]]`, true},
+		{`[["object_uuid","is_a",["arvados#collection","arvados#workflow"]]]`, true},
+		{`[["object_uuid","is_a","arvados#collection"]]`, false},
+		{`[["properties.new_attributes.state","=","Complete"]]`, true},
+		{`[["properties.new_attributes.name","ilike","foo%"]]`, true},
+		{`[["properties.new_attributes.name","exists",true]]`, true},
+		{`[["properties.old_attributes.name","exists",true]]`, false},
+		{`[["properties.old_attributes","exists",false]]`, true},
+		{`[["properties","exists","new_attributes"]]`, true},
+		{`[["properties","exists","old_attributes"]]`, false},
+	} {
+		c.Logf("trial: %s", trial.filters)
+		var filters []arvados.Filter
+		c.Assert(json.Unmarshal([]byte(trial.filters), &filters), check.IsNil)
+		match, err := compileFilters(filters, s.kindForUUID)
+		c.Assert(err, check.IsNil)
+		c.Check(match(lg), check.Equals, trial.match)
+	}
+}
+
+func (s *filterSuite) TestInvalidFilters(c *check.C) {
+	for _, filters := range []string{
+		`[["bogus_attr","=","x"]]`,
+		`[["event_type","bogus_op","x"]]`,
+		`[["event_type","in","update"]]`,
+		`[["event_type","like",3]]`,
+		`[["id",">",true]]`,
+		`[["created_at",">","yesterday"]]`,
+		`[["object_uuid","is_a",3]]`,
+		`[["properties","exists",true]]`,
+		`[["properties.foo","exists","bar"]]`,
+	} {
+		c.Logf("trial: %s", filters)
+		var f []arvados.Filter
+		c.Assert(json.Unmarshal([]byte(filters), &f), check.IsNil)
+		_, err := compileFilters(f, s.kindForUUID)
+		c.Check(err, check.NotNil)
+	}
+}
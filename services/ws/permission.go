@@ -8,6 +8,10 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
@@ -22,6 +26,11 @@ const (
 type permChecker interface {
 	SetToken(token string)
 	Check(ctx context.Context, uuid string) (bool, error)
+
+	// Invalidate discards cached permissions, so subsequent
+	// checks reflect permission changes immediately. It must not
+	// block.
+	Invalidate()
 }
 
 func newPermChecker(ac arvados.Client) permChecker {
@@ -35,20 +44,28 @@ func newPermChecker(ac arvados.Client) permChecker {
 
 type cacheEnt struct {
 	time.Time
-	allowed bool
+	allowed    bool
+	generation uint64
 }
 
 type cachingPermChecker struct {
-	*arvados.Client
-	cache      map[string]cacheEnt
-	maxCurrent int
+	// Cache entries from earlier generations are stale. (64-bit
+	// fields are first, to ensure alignment for atomic ops.)
+	generation uint64
 
 	nChecks  uint64
 	nMisses  uint64
 	nInvalid uint64
+
+	*arvados.Client
+	cache      map[string]cacheEnt
+	maxCurrent int
+	mtx        sync.Mutex
 }
 
 func (pc *cachingPermChecker) SetToken(token string) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
 	if pc.Client.AuthToken == token {
 		return
 	}
@@ -56,25 +73,33 @@ func (pc *cachingPermChecker) SetToken(token string) {
 	pc.cache = make(map[string]cacheEnt)
 }
 
+func (pc *cachingPermChecker) Invalidate() {
+	atomic.AddUint64(&pc.generation, 1)
+}
+
 func (pc *cachingPermChecker) Check(ctx context.Context, uuid string) (bool, error) {
-	pc.nChecks++
+	atomic.AddUint64(&pc.nChecks, 1)
 	logger := ctxlog.FromContext(ctx).
 		WithField("token", pc.Client.AuthToken).
 		WithField("uuid", uuid)
-	pc.tidy()
+	generation := atomic.LoadUint64(&pc.generation)
 	now := time.Now()
-	if perm, ok := pc.cache[uuid]; ok && now.Sub(perm.Time) < maxPermCacheAge {
+	pc.mtx.Lock()
+	pc.tidy(generation)
+	perm, ok := pc.cache[uuid]
+	pc.mtx.Unlock()
+	if ok && perm.generation == generation && now.Sub(perm.Time) < maxPermCacheAge {
 		logger.WithField("allowed", perm.allowed).Debug("cache hit")
 		return perm.allowed, nil
 	}
 	var buf map[string]interface{}
 	path, err := pc.PathForUUID("get", uuid)
 	if err != nil {
-		pc.nInvalid++
+		atomic.AddUint64(&pc.nInvalid, 1)
 		return false, err
 	}
 
-	pc.nMisses++
+	atomic.AddUint64(&pc.nMisses, 1)
 	err = pc.RequestAndDecode(&buf, "GET", path, nil, url.Values{
 		"include_trash": {"true"},
 		"select":        {`["uuid"]`},
@@ -90,7 +115,11 @@ func (pc *cachingPermChecker) Check(ctx context.Context, uuid string) (bool, err
 		return false, err
 	}
 	logger.WithField("allowed", allowed).Debug("cache miss")
-	pc.cache[uuid] = cacheEnt{Time: now, allowed: allowed}
+	// If Invalidate() was called during our lookup, this entry
+	// is already stale and will be ignored next time.
+	pc.mtx.Lock()
+	pc.cache[uuid] = cacheEnt{Time: now, allowed: allowed, generation: generation}
+	pc.mtx.Unlock()
 	return allowed, nil
 }
 
@@ -103,15 +132,48 @@ func (pc *cachingPermChecker) isNotAllowed(status int) bool {
 	}
 }
 
-func (pc *cachingPermChecker) tidy() {
+// tidy removes old and stale entries from the cache. Caller must
+// hold pc.mtx.
+func (pc *cachingPermChecker) tidy(generation uint64) {
 	if len(pc.cache) <= pc.maxCurrent*2 {
 		return
 	}
 	tooOld := time.Now().Add(-minPermCacheAge)
 	for uuid, t := range pc.cache {
-		if t.Before(tooOld) {
+		if t.Before(tooOld) || t.generation != generation {
 			delete(pc.cache, uuid)
 		}
 	}
 	pc.maxCurrent = len(pc.cache)
 }
+
+// permissionChanged returns true if the given log entry records a
+// change that can grant or revoke permissions: a permission link
+// (including a group membership) was created, updated, or deleted;
+// a group was moved, trashed, untrashed, or deleted; or a user was
+// activated, deactivated, or deleted.
+func permissionChanged(detail *arvados.Log) bool {
+	oldAttrs, _ := detail.Properties["old_attributes"].(map[string]interface{})
+	newAttrs, _ := detail.Properties["new_attributes"].(map[string]interface{})
+	updated := func(attrs ...string) bool {
+		if detail.EventType != "update" {
+			return false
+		}
+		for _, attr := range attrs {
+			if !reflect.DeepEqual(oldAttrs[attr], newAttrs[attr]) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case strings.Contains(detail.ObjectUUID, "-o0j2j-"):
+		return oldAttrs["link_class"] == "permission" || newAttrs["link_class"] == "permission"
+	case strings.Contains(detail.ObjectUUID, "-j7d0g-"):
+		return detail.EventType == "delete" || updated("owner_uuid", "is_trashed")
+	case strings.Contains(detail.ObjectUUID, "-tpzed-"):
+		return detail.EventType == "delete" || updated("is_active")
+	default:
+		return false
+	}
+}
@@ -6,6 +6,7 @@ package ws
 
 import (
 	"context"
+	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/arvadostest"
@@ -75,3 +76,67 @@ func (s *permSuite) TestCheck(c *check.C) {
 
 	c.Logf("%d checks, %d misses, %d invalid, %d cached", pc.nChecks, pc.nMisses, pc.nInvalid, len(pc.cache))
 }
+
+func (s *permSuite) TestInvalidate(c *check.C) {
+	pc := newPermChecker(*(arvados.NewClientFromEnv())).(*cachingPermChecker)
+	pc.SetToken(arvadostest.ActiveToken)
+	// Requests would fail, so Check can only succeed by using
+	// the cache.
+	pc.Client.APIHost = "127.0.0.1:9"
+	pc.cache[arvadostest.FooCollection] = cacheEnt{Time: time.Now(), allowed: true}
+
+	ok, err := pc.Check(context.Background(), arvadostest.FooCollection)
+	c.Check(ok, check.Equals, true)
+	c.Check(err, check.IsNil)
+
+	pc.Invalidate()
+	ok, err = pc.Check(context.Background(), arvadostest.FooCollection)
+	c.Check(ok, check.Equals, false)
+	c.Check(err, check.NotNil)
+}
+
+func (s *permSuite) TestPermissionChanged(c *check.C) {
+	attrs := func(kv ...interface{}) map[string]interface{} {
+		m := map[string]interface{}{}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(string)] = kv[i+1]
+		}
+		return m
+	}
+	for _, trial := range []struct {
+		uuid   string
+		etype  string
+		old    map[string]interface{}
+		new    map[string]interface{}
+		expect bool
+	}{
+		{"zzzzz-o0j2j-000000000000000", "create", nil, attrs("link_class", "permission"), true},
+		{"zzzzz-o0j2j-000000000000000", "update", attrs("link_class", "permission", "name", "can_write"), attrs("link_class", "permission", "name", "can_read"), true},
+		{"zzzzz-o0j2j-000000000000000", "delete", attrs("link_class", "permission"), nil, true},
+		{"zzzzz-o0j2j-000000000000000", "delete", attrs("link_class", "star"), nil, false},
+		{"zzzzz-o0j2j-000000000000000", "create", nil, attrs("link_class", "tag"), false},
+		{"zzzzz-j7d0g-000000000000000", "create", nil, attrs("owner_uuid", "zzzzz-tpzed-000000000000000"), false},
+		{"zzzzz-j7d0g-000000000000000", "update", attrs("owner_uuid", "zzzzz-tpzed-000000000000000", "name", "a"), attrs("owner_uuid", "zzzzz-tpzed-000000000000000", "name", "b"), false},
+		{"zzzzz-j7d0g-000000000000000", "update", attrs("owner_uuid", "zzzzz-tpzed-000000000000000"), attrs("owner_uuid", "zzzzz-j7d0g-111111111111111"), true},
+		{"zzzzz-j7d0g-000000000000000", "update", attrs("is_trashed", false), attrs("is_trashed", true), true},
+		{"zzzzz-j7d0g-000000000000000", "delete", attrs("owner_uuid", "zzzzz-tpzed-000000000000000"), nil, true},
+		{"zzzzz-tpzed-000000000000000", "update", attrs("is_active", true, "first_name", "a"), attrs("is_active", true, "first_name", "b"), false},
+		{"zzzzz-tpzed-000000000000000", "update", attrs("is_active", true), attrs("is_active", false), true},
+		{"zzzzz-4zz18-000000000000000", "update", attrs("owner_uuid", "zzzzz-tpzed-000000000000000"), attrs("owner_uuid", "zzzzz-j7d0g-111111111111111"), false},
+		{"zzzzz-4zz18-000000000000000", "blip", nil, nil, false},
+	} {
+		c.Logf("%+v", trial)
+		props := map[string]interface{}{}
+		if trial.old != nil {
+			props["old_attributes"] = trial.old
+		}
+		if trial.new != nil {
+			props["new_attributes"] = trial.new
+		}
+		c.Check(permissionChanged(&arvados.Log{
+			ObjectUUID: trial.uuid,
+			EventType:  trial.etype,
+			Properties: props,
+		}), check.Equals, trial.expect)
+	}
+}
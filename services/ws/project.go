@@ -0,0 +1,108 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package ws
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+)
+
+// Maximum number of owners whose ancestors are cached by an
+// ownerCache. When the cache is full, it is emptied.
+const maxOwnerCacheSize = 1000
+
+// An ownerCache looks up the ancestors of an owner UUID (the owner
+// itself, its owner, and so on up to a user) in the groups table,
+// and caches the results until Invalidate is called.
+type ownerCache struct {
+	// Cache entries from earlier generations are stale.
+	generation uint64
+
+	db        *sql.DB
+	ancestors map[string]ownerCacheEnt
+	mtx       sync.Mutex
+
+	// Look up ancestors in the database. Tests can override this.
+	lookup func(ctx context.Context, uuid string) ([]string, error)
+}
+
+type ownerCacheEnt struct {
+	ancestors  []string
+	generation uint64
+}
+
+func newOwnerCache(db *sql.DB) *ownerCache {
+	oc := &ownerCache{db: db}
+	oc.lookup = oc.lookupDB
+	return oc
+}
+
+// Cached returns the cached ancestors of the given owner. If ok is
+// false, the ancestors are not known yet. Cached does not block.
+func (oc *ownerCache) Cached(uuid string) (ancestors []string, ok bool) {
+	generation := atomic.LoadUint64(&oc.generation)
+	oc.mtx.Lock()
+	defer oc.mtx.Unlock()
+	ent, ok := oc.ancestors[uuid]
+	if !ok || ent.generation != generation {
+		return nil, false
+	}
+	return ent.ancestors, true
+}
+
+// Ancestors returns the ancestors of the given owner, from the cache
+// if possible, otherwise from the database.
+func (oc *ownerCache) Ancestors(ctx context.Context, uuid string) ([]string, error) {
+	if ancestors, ok := oc.Cached(uuid); ok {
+		return ancestors, nil
+	}
+	generation := atomic.LoadUint64(&oc.generation)
+	ancestors, err := oc.lookup(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	oc.mtx.Lock()
+	defer oc.mtx.Unlock()
+	if oc.ancestors == nil || len(oc.ancestors) >= maxOwnerCacheSize {
+		oc.ancestors = map[string]ownerCacheEnt{}
+	}
+	// If Invalidate() was called during our lookup, this entry
+	// is already stale and will be ignored by Cached().
+	oc.ancestors[uuid] = ownerCacheEnt{ancestors: ancestors, generation: generation}
+	return ancestors, nil
+}
+
+// Invalidate discards all cached ancestors. It does not block.
+func (oc *ownerCache) Invalidate() {
+	atomic.AddUint64(&oc.generation, 1)
+}
+
+func (oc *ownerCache) lookupDB(ctx context.Context, uuid string) ([]string, error) {
+	// UNION (not UNION ALL) stops the recursion if there is an
+	// ownership cycle.
+	rows, err := oc.db.QueryContext(ctx, `
+		WITH RECURSIVE ancestors(uuid) AS (
+			SELECT $1::varchar
+			UNION
+			SELECT groups.owner_uuid FROM groups
+				INNER JOIN ancestors ON groups.uuid = ancestors.uuid
+		)
+		SELECT uuid FROM ancestors`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ancestors []string
+	for rows.Next() {
+		var ancestor string
+		if err := rows.Scan(&ancestor); err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, ancestor)
+	}
+	return ancestors, rows.Err()
+}
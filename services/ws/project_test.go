@@ -0,0 +1,133 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package ws
+
+import (
+	"context"
+	"errors"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&projectSuite{})
+
+type projectSuite struct {
+	owners  *ownerCache
+	lookups []string
+	// Ancestors of each owner, as if from the groups table.
+	tree map[string][]string
+}
+
+func (s *projectSuite) SetUpTest(c *check.C) {
+	s.lookups = nil
+	s.tree = map[string][]string{
+		"zzzzz-j7d0g-topproject00000": {"zzzzz-j7d0g-topproject00000", "zzzzz-tpzed-000000000000000"},
+		"zzzzz-j7d0g-subproject00000": {"zzzzz-j7d0g-subproject00000", "zzzzz-j7d0g-topproject00000", "zzzzz-tpzed-000000000000000"},
+		"zzzzz-j7d0g-otherproject000": {"zzzzz-j7d0g-otherproject000", "zzzzz-tpzed-000000000000000"},
+	}
+	s.owners = newOwnerCache(nil)
+	s.owners.lookup = func(ctx context.Context, uuid string) ([]string, error) {
+		s.lookups = append(s.lookups, uuid)
+		if uuid == "zzzzz-j7d0g-brokenproject00" {
+			return nil, errors.New("database is on fire")
+		}
+		return s.tree[uuid], nil
+	}
+}
+
+func (s *projectSuite) TestOwnerCache(c *check.C) {
+	ctx := context.Background()
+	_, ok := s.owners.Cached("zzzzz-j7d0g-subproject00000")
+	c.Check(ok, check.Equals, false)
+
+	for i := 0; i < 2; i++ {
+		ancestors, err := s.owners.Ancestors(ctx, "zzzzz-j7d0g-subproject00000")
+		c.Check(err, check.IsNil)
+		c.Check(ancestors, check.DeepEquals, s.tree["zzzzz-j7d0g-subproject00000"])
+	}
+	c.Check(s.lookups, check.DeepEquals, []string{"zzzzz-j7d0g-subproject00000"})
+	ancestors, ok := s.owners.Cached("zzzzz-j7d0g-subproject00000")
+	c.Check(ok, check.Equals, true)
+	c.Check(ancestors, check.DeepEquals, s.tree["zzzzz-j7d0g-subproject00000"])
+
+	// Subproject moved to a different project
+	s.tree["zzzzz-j7d0g-subproject00000"] = []string{"zzzzz-j7d0g-subproject00000", "zzzzz-j7d0g-otherproject000", "zzzzz-tpzed-000000000000000"}
+	s.owners.Invalidate()
+	_, ok = s.owners.Cached("zzzzz-j7d0g-subproject00000")
+	c.Check(ok, check.Equals, false)
+	ancestors, err := s.owners.Ancestors(ctx, "zzzzz-j7d0g-subproject00000")
+	c.Check(err, check.IsNil)
+	c.Check(ancestors, check.DeepEquals, s.tree["zzzzz-j7d0g-subproject00000"])
+	c.Check(s.lookups, check.HasLen, 2)
+
+	_, err = s.owners.Ancestors(ctx, "zzzzz-j7d0g-brokenproject00")
+	c.Check(err, check.ErrorMatches, `database is on fire`)
+	_, ok = s.owners.Cached("zzzzz-j7d0g-brokenproject00")
+	c.Check(ok, check.Equals, false)
+}
+
+// If the cache is invalidated while a lookup is in progress, the
+// result of that lookup is not used by subsequent calls.
+func (s *projectSuite) TestInvalidateDuringLookup(c *check.C) {
+	lookup := s.owners.lookup
+	s.owners.lookup = func(ctx context.Context, uuid string) ([]string, error) {
+		s.owners.Invalidate()
+		return lookup(ctx, uuid)
+	}
+	_, err := s.owners.Ancestors(context.Background(), "zzzzz-j7d0g-subproject00000")
+	c.Check(err, check.IsNil)
+	_, ok := s.owners.Cached("zzzzz-j7d0g-subproject00000")
+	c.Check(ok, check.Equals, false)
+}
+
+func (s *projectSuite) TestInProject(c *check.C) {
+	sess := &v1session{owners: s.owners}
+	ctx := context.Background()
+	recursive := &v1subscription{project: "zzzzz-j7d0g-topproject00000", recursive: true}
+	direct := &v1subscription{project: "zzzzz-j7d0g-topproject00000"}
+	everything := &v1subscription{}
+	for _, trial := range []struct {
+		objectUUID string
+		ownerUUID  string
+		sub        *v1subscription
+		expect     bool
+	}{
+		{"zzzzz-4zz18-000000000000000", "zzzzz-j7d0g-topproject00000", direct, true},
+		{"zzzzz-4zz18-000000000000000", "zzzzz-j7d0g-topproject00000", recursive, true},
+		{"zzzzz-j7d0g-topproject00000", "zzzzz-tpzed-000000000000000", direct, true},
+		{"zzzzz-4zz18-000000000000000", "zzzzz-j7d0g-subproject00000", direct, false},
+		{"zzzzz-4zz18-000000000000000", "zzzzz-j7d0g-subproject00000", recursive, true},
+		{"zzzzz-4zz18-000000000000000", "zzzzz-j7d0g-otherproject000", recursive, false},
+		{"zzzzz-4zz18-000000000000000", "zzzzz-j7d0g-otherproject000", everything, true},
+		{"zzzzz-4zz18-000000000000000", "", recursive, false},
+	} {
+		c.Logf("%+v", trial)
+		detail := &arvados.Log{ObjectUUID: trial.objectUUID, ObjectOwnerUUID: trial.ownerUUID}
+		s.owners.Invalidate()
+		// Without waiting, anything that depends on
+		// uncached ancestors is a tentative match.
+		ok, err := sess.inProject(ctx, trial.sub, detail, false)
+		c.Check(err, check.IsNil)
+		if trial.sub.recursive && trial.ownerUUID != "" && trial.ownerUUID != trial.sub.project {
+			c.Check(ok, check.Equals, true)
+		} else {
+			c.Check(ok, check.Equals, trial.expect)
+		}
+
+		ok, err = sess.inProject(ctx, trial.sub, detail, true)
+		c.Check(err, check.IsNil)
+		c.Check(ok, check.Equals, trial.expect)
+
+		// Now the ancestors are cached, so the non-blocking
+		// check is accurate.
+		ok, err = sess.inProject(ctx, trial.sub, detail, false)
+		c.Check(err, check.IsNil)
+		c.Check(ok, check.Equals, trial.expect)
+	}
+
+	_, err := sess.inProject(ctx, recursive, &arvados.Log{ObjectUUID: "zzzzz-4zz18-000000000000000", ObjectOwnerUUID: "zzzzz-j7d0g-brokenproject00"}, true)
+	c.Check(err, check.NotNil)
+}
@@ -6,8 +6,10 @@ package ws
 
 import (
 	"database/sql"
+	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
 )
 
 type session interface {
@@ -35,3 +37,98 @@ type session interface {
 }
 
 type sessionFactory func(wsConn, chan<- interface{}, *sql.DB, permChecker, *arvados.Client) (session, error)
+
+// eventProperties returns the subset of the given log entry's
+// properties that should be sent to clients.
+func eventProperties(detail *arvados.Log) interface{} {
+	if detail.Properties != nil && detail.Properties["text"] != nil {
+		return detail.Properties
+	}
+	msgProps := map[string]map[string]interface{}{}
+	for _, ak := range []string{"old_attributes", "new_attributes"} {
+		eventAttrs, ok := detail.Properties[ak].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		msgAttrs := map[string]interface{}{}
+		for _, k := range sendObjectAttributes {
+			if v, ok := eventAttrs[k]; ok {
+				msgAttrs[k] = v
+			}
+		}
+		msgProps[ak] = msgAttrs
+	}
+	return msgProps
+}
+
+// sendOldEvents queues events that were logged after lastLogID and
+// satisfy match. It returns when all such events have been queued or
+// the connection has closed.
+func sendOldEvents(ws wsConn, db *sql.DB, sendq chan<- interface{}, log logrus.FieldLogger, lastLogID int64, match func(*event) bool) {
+	if lastLogID == 0 {
+		return
+	}
+	log.WithField("LastLogID", lastLogID).Debug("sendOldEvents")
+	// Here we do a "select id" query and queue an event for every
+	// log since the given ID, then use (*event)Detail() to
+	// retrieve the whole row and decide whether to send it. This
+	// approach is very inefficient if the subscriber asks for
+	// last_log_id==1, even if the filters end up matching very
+	// few events.
+	//
+	// To mitigate this, filter on "created > 10 minutes ago" when
+	// retrieving the list of old event IDs to consider.
+	rows, err := db.Query(
+		`SELECT id FROM logs WHERE id > $1 AND created_at > $2 ORDER BY id`,
+		lastLogID,
+		time.Now().UTC().Add(-10*time.Minute).Format(time.RFC3339Nano))
+	if err != nil {
+		log.WithError(err).Error("sendOldEvents db.Query failed")
+		return
+	}
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		err := rows.Scan(&id)
+		if err != nil {
+			log.WithError(err).Error("sendOldEvents row Scan failed")
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.WithError(err).Error("sendOldEvents db.Query failed")
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		for len(sendq)*2 > cap(sendq) {
+			// Ugly... but if we fill up the whole client
+			// queue with a backlog of old events, a
+			// single new event will overflow it and
+			// terminate the connection, and then the
+			// client will probably reconnect and do the
+			// same thing all over again.
+			time.Sleep(100 * time.Millisecond)
+			if ws.Request().Context().Err() != nil {
+				// Session terminated while we were sleeping
+				return
+			}
+		}
+		now := time.Now()
+		e := &event{
+			LogID:    id,
+			Received: now,
+			Ready:    now,
+			db:       db,
+		}
+		if match(e) {
+			select {
+			case sendq <- e:
+			case <-ws.Request().Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -144,30 +144,15 @@ func (sess *v0session) EventMessage(e *event) ([]byte, error) {
 		"object_kind":       kind,
 		"event_type":        detail.EventType,
 		"event_at":          detail.EventAt,
-	}
-	if detail.Properties != nil && detail.Properties["text"] != nil {
-		msg["properties"] = detail.Properties
-	} else {
-		msgProps := map[string]map[string]interface{}{}
-		for _, ak := range []string{"old_attributes", "new_attributes"} {
-			eventAttrs, ok := detail.Properties[ak].(map[string]interface{})
-			if !ok {
-				continue
-			}
-			msgAttrs := map[string]interface{}{}
-			for _, k := range sendObjectAttributes {
-				if v, ok := eventAttrs[k]; ok {
-					msgAttrs[k] = v
-				}
-			}
-			msgProps[ak] = msgAttrs
-		}
-		msg["properties"] = msgProps
+		"properties":        eventProperties(detail),
 	}
 	return json.Marshal(msg)
 }
 
 func (sess *v0session) Filter(e *event) bool {
+	if detail := e.Detail(); detail != nil && permissionChanged(detail) {
+		sess.permChecker.Invalidate()
+	}
 	sess.mtx.Lock()
 	defer sess.mtx.Unlock()
 	for _, sub := range sess.subscriptions {
@@ -179,72 +164,9 @@ func (sess *v0session) Filter(e *event) bool {
 }
 
 func (sub *v0subscribe) sendOldEvents(sess *v0session) {
-	if sub.LastLogID == 0 {
-		return
-	}
-	sess.log.WithField("LastLogID", sub.LastLogID).Debug("sendOldEvents")
-	// Here we do a "select id" query and queue an event for every
-	// log since the given ID, then use (*event)Detail() to
-	// retrieve the whole row and decide whether to send it. This
-	// approach is very inefficient if the subscriber asks for
-	// last_log_id==1, even if the filters end up matching very
-	// few events.
-	//
-	// To mitigate this, filter on "created > 10 minutes ago" when
-	// retrieving the list of old event IDs to consider.
-	rows, err := sess.db.Query(
-		`SELECT id FROM logs WHERE id > $1 AND created_at > $2 ORDER BY id`,
-		sub.LastLogID,
-		time.Now().UTC().Add(-10*time.Minute).Format(time.RFC3339Nano))
-	if err != nil {
-		sess.log.WithError(err).Error("sendOldEvents db.Query failed")
-		return
-	}
-
-	var ids []uint64
-	for rows.Next() {
-		var id uint64
-		err := rows.Scan(&id)
-		if err != nil {
-			sess.log.WithError(err).Error("sendOldEvents row Scan failed")
-			continue
-		}
-		ids = append(ids, id)
-	}
-	if err := rows.Err(); err != nil {
-		sess.log.WithError(err).Error("sendOldEvents db.Query failed")
-	}
-	rows.Close()
-
-	for _, id := range ids {
-		for len(sess.sendq)*2 > cap(sess.sendq) {
-			// Ugly... but if we fill up the whole client
-			// queue with a backlog of old events, a
-			// single new event will overflow it and
-			// terminate the connection, and then the
-			// client will probably reconnect and do the
-			// same thing all over again.
-			time.Sleep(100 * time.Millisecond)
-			if sess.ws.Request().Context().Err() != nil {
-				// Session terminated while we were sleeping
-				return
-			}
-		}
-		now := time.Now()
-		e := &event{
-			LogID:    id,
-			Received: now,
-			Ready:    now,
-			db:       sess.db,
-		}
-		if sub.match(sess, e) {
-			select {
-			case sess.sendq <- e:
-			case <-sess.ws.Request().Context().Done():
-				return
-			}
-		}
-	}
+	sendOldEvents(sess.ws, sess.db, sess.sendq, sess.log, sub.LastLogID, func(e *event) bool {
+		return sub.match(sess, e)
+	})
 }
 
 type v0subscribe struct {
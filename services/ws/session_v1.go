@@ -5,14 +5,372 @@
 package ws
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/sirupsen/logrus"
 )
 
+// v1request is a message sent by a v1 client.
+//
+// Supported methods are "subscribe" (add a subscription with the
+// given filters, optionally replaying events after LastLogID) and
+// "unsubscribe" (remove the subscription with the given
+// SubscriptionID).
+//
+// If ProjectUUID is given, the subscription only matches events
+// about that project and the objects it contains -- including, if
+// Recursive is true, objects in its subprojects.
+type v1request struct {
+	Method         string           `json:"method"`
+	RequestID      string           `json:"request_id"`
+	SubscriptionID string           `json:"subscription_id"`
+	Filters        []arvados.Filter `json:"filters"`
+	LastLogID      int64            `json:"last_log_id"`
+	ProjectUUID    string           `json:"project_uuid"`
+	Recursive      bool             `json:"recursive"`
+}
+
+// v1response acknowledges a v1request. Status is an HTTP status code.
+type v1response struct {
+	RequestID      string `json:"request_id,omitempty"`
+	Status         int    `json:"status"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+type v1subscription struct {
+	id        string
+	match     logMatcher
+	project   string
+	recursive bool
+}
+
+type v1session struct {
+	ac            *arvados.Client
+	ws            wsConn
+	sendq         chan<- interface{}
+	db            *sql.DB
+	permChecker   permChecker
+	owners        *ownerCache
+	subscriptions map[string]*v1subscription
+	lastSubID     uint64
+	lastMsgID     uint64
+	log           logrus.FieldLogger
+	mtx           sync.Mutex
+}
+
 // newSessionV1 returns a v1 session -- see
 // https://dev.arvados.org/projects/arvados/wiki/Websocket_server
+//
+// Each subscription has an ID (assigned by the client or the
+// server). Every request is acknowledged with a status message
+// carrying the client's request_id, and every event message lists
+// the IDs of the subscriptions it matched. A subscription with a
+// last_log_id replays matching events logged since that ID before
+// continuing with new events.
 func newSessionV1(ws wsConn, sendq chan<- interface{}, db *sql.DB, pc permChecker, ac *arvados.Client) (session, error) {
-	return nil, errors.New("Not implemented")
+	sess := &v1session{
+		sendq:         sendq,
+		ws:            ws,
+		db:            db,
+		ac:            ac,
+		permChecker:   pc,
+		owners:        newOwnerCache(db),
+		subscriptions: map[string]*v1subscription{},
+		log:           ctxlog.FromContext(ws.Request().Context()),
+	}
+
+	err := ws.Request().ParseForm()
+	if err != nil {
+		sess.log.WithError(err).Error("ParseForm failed")
+		return nil, err
+	}
+	token := ws.Request().Form.Get("api_token")
+	sess.permChecker.SetToken(token)
+	sess.log.WithField("token", token).Debug("set token")
+
+	return sess, nil
+}
+
+func (sess *v1session) Receive(buf []byte) error {
+	var req v1request
+	if err := json.Unmarshal(buf, &req); err != nil {
+		sess.log.WithError(err).Info("invalid message from client")
+		sess.respond(v1response{Status: http.StatusBadRequest, Error: "invalid message: " + err.Error()})
+		return nil
+	}
+	switch req.Method {
+	case "subscribe":
+		sess.subscribe(req)
+	case "unsubscribe":
+		sess.unsubscribe(req)
+	default:
+		sess.log.WithField("Method", req.Method).Info("unknown method")
+		sess.respond(v1response{
+			RequestID: req.RequestID,
+			Status:    http.StatusBadRequest,
+			Error:     fmt.Sprintf("unknown method %q", req.Method),
+		})
+	}
+	return nil
+}
+
+func (sess *v1session) subscribe(req v1request) {
+	match, err := compileFilters(req.Filters, sess.ac.KindForUUID)
+	if err != nil {
+		sess.respond(v1response{
+			RequestID: req.RequestID,
+			Status:    http.StatusBadRequest,
+			Error:     err.Error(),
+		})
+		return
+	}
+	if req.ProjectUUID != "" {
+		if status, err := sess.checkProject(req.ProjectUUID); err != nil {
+			sess.respond(v1response{
+				RequestID: req.RequestID,
+				Status:    status,
+				Error:     err.Error(),
+			})
+			return
+		}
+	} else if req.Recursive {
+		sess.respond(v1response{
+			RequestID: req.RequestID,
+			Status:    http.StatusBadRequest,
+			Error:     "recursive requires project_uuid",
+		})
+		return
+	}
+	sub := &v1subscription{
+		id:        req.SubscriptionID,
+		match:     match,
+		project:   req.ProjectUUID,
+		recursive: req.Recursive,
+	}
+
+	sess.mtx.Lock()
+	if sub.id == "" {
+		for sub.id == "" || sess.subscriptions[sub.id] != nil {
+			sub.id = strconv.FormatUint(atomic.AddUint64(&sess.lastSubID, 1), 10)
+		}
+	} else if sess.subscriptions[sub.id] != nil {
+		sess.mtx.Unlock()
+		sess.respond(v1response{
+			RequestID:      req.RequestID,
+			Status:         http.StatusBadRequest,
+			SubscriptionID: sub.id,
+			Error:          "subscription_id already in use",
+		})
+		return
+	}
+	sess.subscriptions[sub.id] = sub
+	sess.mtx.Unlock()
+
+	sess.log.WithField("subscription_id", sub.id).WithField("filters", req.Filters).Debug("subscribed")
+	sess.respond(v1response{
+		RequestID:      req.RequestID,
+		Status:         http.StatusOK,
+		SubscriptionID: sub.id,
+	})
+	sendOldEvents(sess.ws, sess.db, sess.sendq, sess.log, req.LastLogID, func(e *event) bool {
+		return sess.subscribed(sub) && sess.match(sub, e)
+	})
+}
+
+// checkProject returns an error (and a suitable response status) if
+// uuid is not a project, or the client doesn't have permission to
+// read it.
+func (sess *v1session) checkProject(uuid string) (int, error) {
+	if !strings.Contains(uuid, "-j7d0g-") && !strings.Contains(uuid, "-tpzed-") {
+		return http.StatusBadRequest, fmt.Errorf("invalid project_uuid %q", uuid)
+	}
+	ok, err := sess.permChecker.Check(sess.ws.Request().Context(), uuid)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	} else if !ok {
+		return http.StatusNotFound, fmt.Errorf("project %q not found", uuid)
+	}
+	return http.StatusOK, nil
+}
+
+func (sess *v1session) unsubscribe(req v1request) {
+	sess.mtx.Lock()
+	_, found := sess.subscriptions[req.SubscriptionID]
+	delete(sess.subscriptions, req.SubscriptionID)
+	sess.mtx.Unlock()
+	sess.log.WithField("subscription_id", req.SubscriptionID).WithField("found", found).Debug("unsubscribe")
+	resp := v1response{
+		RequestID:      req.RequestID,
+		Status:         http.StatusOK,
+		SubscriptionID: req.SubscriptionID,
+	}
+	if !found {
+		resp.Status = http.StatusNotFound
+		resp.Error = "no such subscription"
+	}
+	sess.respond(resp)
+}
+
+// subscribed returns true if sub has not been unsubscribed.
+func (sess *v1session) subscribed(sub *v1subscription) bool {
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	return sess.subscriptions[sub.id] == sub
+}
+
+func (sess *v1session) respond(resp v1response) {
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		sess.log.WithError(err).Error("json.Marshal failed")
+		return
+	}
+	sess.sendq <- buf
+}
+
+// match returns true if the event matches the subscription's
+// filters, and (as far as we can tell without waiting for a database
+// query) its project.
+func (sess *v1session) match(sub *v1subscription, e *event) bool {
+	detail := e.Detail()
+	if detail == nil {
+		sess.log.WithField("LogID", e.LogID).Error("match failed, no detail")
+		return false
+	}
+	if !sub.match(detail) {
+		return false
+	}
+	ok, _ := sess.inProject(sess.ws.Request().Context(), sub, detail, false)
+	return ok
+}
+
+// inProject returns true if the given log entry is about sub.project
+// itself, an object in it, or (if sub.recursive) an object in one of
+// its subprojects.
+//
+// If wait is false, inProject does not block: if the answer depends
+// on ancestors that are not cached yet, it returns true.
+func (sess *v1session) inProject(ctx context.Context, sub *v1subscription, detail *arvados.Log, wait bool) (bool, error) {
+	if sub.project == "" || detail.ObjectUUID == sub.project || detail.ObjectOwnerUUID == sub.project {
+		return true, nil
+	}
+	if !sub.recursive || detail.ObjectOwnerUUID == "" {
+		return false, nil
+	}
+	var ancestors []string
+	if !wait {
+		var ok bool
+		ancestors, ok = sess.owners.Cached(detail.ObjectOwnerUUID)
+		if !ok {
+			return true, nil
+		}
+	} else {
+		var err error
+		ancestors, err = sess.owners.Ancestors(ctx, detail.ObjectOwnerUUID)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, uuid := range ancestors {
+		if uuid == sub.project {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchingSubscriptions returns the (sorted) IDs of all current
+// subscriptions that match the given event. Unlike Filter, it waits
+// for database queries to resolve project subscriptions.
+func (sess *v1session) matchingSubscriptions(ctx context.Context, e *event) ([]string, error) {
+	sess.mtx.Lock()
+	var subs []*v1subscription
+	for _, sub := range sess.subscriptions {
+		if sess.match(sub, e) {
+			subs = append(subs, sub)
+		}
+	}
+	sess.mtx.Unlock()
+	var ids []string
+	for _, sub := range subs {
+		ok, err := sess.inProject(ctx, sub, e.Detail(), true)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			ids = append(ids, sub.id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (sess *v1session) Filter(e *event) bool {
+	if detail := e.Detail(); detail != nil && permissionChanged(detail) {
+		sess.permChecker.Invalidate()
+		sess.owners.Invalidate()
+	}
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	for _, sub := range sess.subscriptions {
+		if sess.match(sub, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sess *v1session) EventMessage(e *event) ([]byte, error) {
+	detail := e.Detail()
+	if detail == nil {
+		return nil, nil
+	}
+
+	subIDs, err := sess.matchingSubscriptions(sess.ws.Request().Context(), e)
+	if err != nil {
+		return nil, err
+	} else if len(subIDs) == 0 {
+		// Subscription(s) removed since the event was
+		// queued, or the object is not in a subscribed
+		// project.
+		return nil, nil
+	}
+
+	var permTarget string
+	if detail.EventType == "delete" {
+		// See (*v0session)EventMessage
+		permTarget = detail.ObjectOwnerUUID
+	} else {
+		permTarget = detail.ObjectUUID
+	}
+	ok, err := sess.permChecker.Check(sess.ws.Request().Context(), permTarget)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	kind, _ := sess.ac.KindForUUID(detail.ObjectUUID)
+	msg := map[string]interface{}{
+		"msgID":             atomic.AddUint64(&sess.lastMsgID, 1),
+		"subscription_ids":  subIDs,
+		"id":                detail.ID,
+		"uuid":              detail.UUID,
+		"object_uuid":       detail.ObjectUUID,
+		"object_owner_uuid": detail.ObjectOwnerUUID,
+		"object_kind":       kind,
+		"event_type":        detail.EventType,
+		"event_at":          detail.EventAt,
+		"created_at":        detail.CreatedAt,
+		"properties":        eventProperties(detail),
+	}
+	return json.Marshal(msg)
 }
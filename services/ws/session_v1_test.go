@@ -0,0 +1,315 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"golang.org/x/net/websocket"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&v1Suite{})
+
+// v1Suite uses the v0Suite helpers to generate events and check
+// permissions.
+type v1Suite struct {
+	v0Suite v0Suite
+}
+
+type v1testMessage struct {
+	v1response
+	SubscriptionIDs []string `json:"subscription_ids"`
+	ID              uint64   `json:"id"`
+	ObjectUUID      string   `json:"object_uuid"`
+	EventType       string   `json:"event_type"`
+}
+
+func (s *v1Suite) SetUpTest(c *check.C) {
+	s.v0Suite.SetUpTest(c)
+}
+
+func (s *v1Suite) TearDownTest(c *check.C) {
+	s.v0Suite.TearDownTest(c)
+}
+
+func (s *v1Suite) TearDownSuite(c *check.C) {
+	s.v0Suite.TearDownSuite(c)
+}
+
+func (s *v1Suite) TestSubscribeUnsubscribe(c *check.C) {
+	conn, r, w := s.testClient()
+	defer conn.Close()
+
+	send := func(req map[string]interface{}) {
+		c.Check(w.Encode(req), check.IsNil)
+	}
+
+	send(map[string]interface{}{"method": "subscribe", "request_id": "r1", "filters": [][]interface{}{{"event_type", "=", "update"}}})
+	resp := s.expectResponse(c, r)
+	c.Check(resp.RequestID, check.Equals, "r1")
+	c.Check(resp.Status, check.Equals, 200)
+	c.Check(resp.SubscriptionID, check.Not(check.Equals), "")
+	updateSub := resp.SubscriptionID
+
+	send(map[string]interface{}{"method": "subscribe", "request_id": "r2", "subscription_id": "blips", "filters": [][]interface{}{{"event_type", "in", []string{"blip"}}}})
+	resp = s.expectResponse(c, r)
+	c.Check(resp.RequestID, check.Equals, "r2")
+	c.Check(resp.Status, check.Equals, 200)
+	c.Check(resp.SubscriptionID, check.Equals, "blips")
+
+	send(map[string]interface{}{"method": "subscribe", "request_id": "r3", "subscription_id": "blips"})
+	resp = s.expectResponse(c, r)
+	c.Check(resp.RequestID, check.Equals, "r3")
+	c.Check(resp.Status, check.Equals, 400)
+
+	send(map[string]interface{}{"method": "subscribe", "request_id": "r4", "filters": [][]interface{}{{"event_type", "bogus", "update"}}})
+	resp = s.expectResponse(c, r)
+	c.Check(resp.RequestID, check.Equals, "r4")
+	c.Check(resp.Status, check.Equals, 400)
+	c.Check(resp.Error, check.Matches, `.*invalid operator.*`)
+
+	send(map[string]interface{}{"method": "unsubscribe", "request_id": "r5", "subscription_id": "nonexistent"})
+	resp = s.expectResponse(c, r)
+	c.Check(resp.RequestID, check.Equals, "r5")
+	c.Check(resp.Status, check.Equals, 404)
+
+	uuidChan := make(chan string, 1)
+	go s.v0Suite.emitEvents(uuidChan)
+	uuid := <-uuidChan
+	for _, expect := range []struct {
+		etype string
+		subID string
+	}{{"blip", "blips"}, {"update", updateSub}} {
+		msg := s.expectEvent(c, r, uuid)
+		c.Check(msg.EventType, check.Equals, expect.etype)
+		c.Check(msg.SubscriptionIDs, check.DeepEquals, []string{expect.subID})
+	}
+
+	send(map[string]interface{}{"method": "unsubscribe", "request_id": "r6", "subscription_id": "blips"})
+	resp = s.expectResponse(c, r)
+	c.Check(resp.RequestID, check.Equals, "r6")
+	c.Check(resp.Status, check.Equals, 200)
+
+	go s.v0Suite.emitEvents(uuidChan)
+	uuid = <-uuidChan
+	msg := s.expectEvent(c, r, uuid)
+	c.Check(msg.EventType, check.Equals, "update")
+	c.Check(msg.SubscriptionIDs, check.DeepEquals, []string{updateSub})
+}
+
+func (s *v1Suite) TestOverlappingSubscriptions(c *check.C) {
+	conn, r, w := s.testClient()
+	defer conn.Close()
+
+	for _, sub := range []string{"a", "b"} {
+		c.Check(w.Encode(map[string]interface{}{"method": "subscribe", "subscription_id": sub, "filters": [][]interface{}{{"object_uuid", "is_a", "arvados#workflow"}}}), check.IsNil)
+		c.Check(s.expectResponse(c, r).Status, check.Equals, 200)
+	}
+
+	uuidChan := make(chan string, 1)
+	go s.v0Suite.emitEvents(uuidChan)
+	msg := s.expectEvent(c, r, <-uuidChan)
+	c.Check(msg.EventType, check.Equals, "create")
+	c.Check(msg.SubscriptionIDs, check.DeepEquals, []string{"a", "b"})
+}
+
+func (s *v1Suite) TestLastLogID(c *check.C) {
+	lastID := s.v0Suite.lastLogID(c)
+	uuidChan := make(chan string, 1)
+	s.v0Suite.emitEvents(uuidChan)
+	uuid := <-uuidChan
+
+	conn, r, w := s.testClient()
+	defer conn.Close()
+	c.Check(w.Encode(map[string]interface{}{
+		"method":      "subscribe",
+		"last_log_id": lastID,
+		"filters":     [][]interface{}{{"object_uuid", "=", uuid}},
+	}), check.IsNil)
+	c.Check(s.expectResponse(c, r).Status, check.Equals, 200)
+	for _, etype := range []string{"create", "blip", "update"} {
+		msg := s.expectEvent(c, r, uuid)
+		c.Check(msg.EventType, check.Equals, etype)
+		c.Check(msg.ID > uint64(lastID), check.Equals, true)
+	}
+}
+
+func (s *v1Suite) TestSendBadJSON(c *check.C) {
+	conn, r, w := s.testClient()
+	defer conn.Close()
+
+	_, err := fmt.Fprint(conn, "^]beep\n")
+	c.Check(err, check.IsNil)
+	c.Check(s.expectResponse(c, r).Status, check.Equals, 400)
+
+	c.Check(w.Encode(map[string]interface{}{"method": "bogus", "request_id": "x"}), check.IsNil)
+	resp := s.expectResponse(c, r)
+	c.Check(resp.Status, check.Equals, 400)
+	c.Check(resp.RequestID, check.Equals, "x")
+
+	c.Check(w.Encode(map[string]interface{}{"method": "subscribe"}), check.IsNil)
+	c.Check(s.expectResponse(c, r).Status, check.Equals, 200)
+}
+
+func (s *v1Suite) TestProjectSubscription(c *check.C) {
+	ac := arvados.NewClientFromEnv()
+	ac.AuthToken = arvadostest.ActiveToken
+	create := func(rsc, ownerUUID string, attrs map[string]interface{}) string {
+		attrs["owner_uuid"] = ownerUUID
+		var resp struct{ UUID string }
+		err := ac.RequestAndDecode(&resp, "POST", "arvados/v1/"+rsc+"s", s.v0Suite.jsonBody(rsc, attrs), map[string]interface{}{"ensure_unique_name": true})
+		c.Assert(err, check.IsNil)
+		s.v0Suite.toDelete = append([]string{"arvados/v1/" + rsc + "s/" + resp.UUID}, s.v0Suite.toDelete...)
+		return resp.UUID
+	}
+	project := create("group", arvadostest.ActiveUserUUID, map[string]interface{}{"name": "ws_test project", "group_class": "project"})
+	subproject := create("group", project, map[string]interface{}{"name": "ws_test subproject", "group_class": "project"})
+
+	conn, r, w := s.testClient()
+	defer conn.Close()
+	for _, trial := range []struct {
+		req    map[string]interface{}
+		status int
+	}{
+		{map[string]interface{}{"project_uuid": "zzzzz-4zz18-fy296fx3hot09f7"}, 400},
+		{map[string]interface{}{"project_uuid": "zzzzz-j7d0g-nonexistent0000"}, 404},
+		{map[string]interface{}{"recursive": true}, 400},
+		{map[string]interface{}{"subscription_id": "direct", "project_uuid": project}, 200},
+		{map[string]interface{}{"subscription_id": "recursive", "project_uuid": project, "recursive": true}, 200},
+	} {
+		trial.req["method"] = "subscribe"
+		c.Check(w.Encode(trial.req), check.IsNil)
+		c.Check(s.expectResponse(c, r).Status, check.Equals, trial.status, check.Commentf("%v", trial.req))
+	}
+
+	outside := create("collection", arvadostest.ActiveUserUUID, map[string]interface{}{"manifest_text": ""})
+	insideSub := create("collection", subproject, map[string]interface{}{"manifest_text": ""})
+	inside := create("collection", project, map[string]interface{}{"manifest_text": ""})
+	for _, expect := range []struct {
+		uuid   string
+		subIDs []string
+	}{{insideSub, []string{"recursive"}}, {inside, []string{"direct", "recursive"}}} {
+		for {
+			msg := s.expectMessage(c, r)
+			c.Check(msg.ObjectUUID, check.Not(check.Equals), outside)
+			if msg.ObjectUUID == expect.uuid && msg.ID > s.v0Suite.ignoreLogID {
+				c.Check(msg.SubscriptionIDs, check.DeepEquals, expect.subIDs)
+				break
+			}
+		}
+	}
+}
+
+// When a permission link is deleted, the client stops receiving
+// events about the objects it granted access to, even if permission
+// was already checked (and cached) for those objects.
+func (s *v1Suite) TestPermissionRevoked(c *check.C) {
+	ac := arvados.NewClientFromEnv()
+	ac.AuthToken = arvadostest.ActiveToken
+	var coll arvados.Collection
+	err := ac.RequestAndDecode(&coll, "POST", "arvados/v1/collections", s.v0Suite.jsonBody("collection", `{"manifest_text":""}`), map[string]interface{}{"ensure_unique_name": true})
+	c.Assert(err, check.IsNil)
+	s.v0Suite.toDelete = append(s.v0Suite.toDelete, "arvados/v1/collections/"+coll.UUID)
+	var link arvados.Link
+	err = ac.RequestAndDecode(&link, "POST", "arvados/v1/links", s.v0Suite.jsonBody("link", map[string]interface{}{
+		"link_class": "permission",
+		"name":       "can_read",
+		"tail_uuid":  arvadostest.SpectatorUserUUID,
+		"head_uuid":  coll.UUID,
+	}), nil)
+	c.Assert(err, check.IsNil)
+
+	conn, r, w := s.testClientWithToken(arvadostest.SpectatorToken)
+	defer conn.Close()
+	c.Check(w.Encode(map[string]interface{}{"method": "subscribe", "filters": [][]interface{}{{"object_uuid", "=", coll.UUID}}}), check.IsNil)
+	c.Check(s.expectResponse(c, r).Status, check.Equals, 200)
+
+	rename := func(name string) {
+		err := ac.RequestAndDecode(&coll, "PUT", "arvados/v1/collections/"+coll.UUID, s.v0Suite.jsonBody("collection", map[string]interface{}{"name": name}), nil)
+		c.Assert(err, check.IsNil)
+	}
+	rename("ws_test permission 1")
+	msg := s.expectEvent(c, r, coll.UUID)
+	c.Check(msg.EventType, check.Equals, "update")
+	firstID := msg.ID
+
+	err = ac.RequestAndDecode(nil, "DELETE", "arvados/v1/links/"+link.UUID, nil, nil)
+	c.Assert(err, check.IsNil)
+	rename("ws_test permission 2")
+
+	// Subscribe to a second (visible) object, and wait for an
+	// event about it, to ensure the second rename event has been
+	// processed.
+	c.Check(w.Encode(map[string]interface{}{"method": "subscribe", "filters": [][]interface{}{{"object_uuid", "is_a", "arvados#workflow"}}}), check.IsNil)
+	c.Check(s.expectResponse(c, r).Status, check.Equals, 200)
+	s.v0Suite.token = arvadostest.SpectatorToken
+	uuidChan := make(chan string, 1)
+	go s.v0Suite.emitEvents(uuidChan)
+	wfUUID := <-uuidChan
+	for {
+		msg := s.expectMessage(c, r)
+		if msg.ObjectUUID == coll.UUID && msg.ID > firstID {
+			c.Errorf("received event %d after permission was revoked", msg.ID)
+		}
+		if msg.ObjectUUID == wfUUID && msg.ID > s.v0Suite.ignoreLogID {
+			break
+		}
+	}
+}
+
+func (s *v1Suite) expectResponse(c *check.C, r *json.Decoder) v1testMessage {
+	for {
+		msg := s.expectMessage(c, r)
+		if msg.Status != 0 {
+			return msg
+		}
+	}
+}
+
+// expectEvent returns the next event about the given object.
+func (s *v1Suite) expectEvent(c *check.C, r *json.Decoder, uuid string) v1testMessage {
+	for {
+		msg := s.expectMessage(c, r)
+		if msg.Status == 0 && msg.ID > s.v0Suite.ignoreLogID && msg.ObjectUUID == uuid {
+			return msg
+		}
+	}
+}
+
+func (s *v1Suite) expectMessage(c *check.C, r *json.Decoder) v1testMessage {
+	var msg v1testMessage
+	ok := make(chan struct{})
+	go func() {
+		c.Check(r.Decode(&msg), check.IsNil)
+		close(ok)
+	}()
+	select {
+	case <-time.After(10 * time.Second):
+		panic("timed out")
+	case <-ok:
+		return msg
+	}
+}
+
+func (s *v1Suite) testClient() (*websocket.Conn, *json.Decoder, *json.Encoder) {
+	return s.testClientWithToken(arvadostest.ActiveToken)
+}
+
+func (s *v1Suite) testClientWithToken(token string) (*websocket.Conn, *json.Decoder, *json.Encoder) {
+	srv := s.v0Suite.serviceSuite.srv
+	conn, err := websocket.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/arvados/v1/events.ws?api_token="+token, "", srv.URL)
+	if err != nil {
+		panic(err)
+	}
+	w := json.NewEncoder(conn)
+	r := json.NewDecoder(conn)
+	return conn, r, w
+}